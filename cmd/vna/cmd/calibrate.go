@@ -0,0 +1,85 @@
+/*
+Copyright © 2021 Tim Drysdale <timothy.d.drysdale@gmail.com>
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU Affero General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU Affero General Public License for more details.
+
+You should have received a copy of the GNU Affero General Public License
+along with this program. If not, see <http://www.gnu.org/licenses/>.
+*/
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/ory/viper"
+	"github.com/practable/pocket-vna-two-port/pkg/calibrate"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// calibrateCmd represents the calibrate command
+var calibrateCmd = &cobra.Command{
+	Use:   "calibrate",
+	Short: "Calibrate serves an emulated calibration service",
+	Long: `Calibrate serves a built-in calibration service on VNA_ADDR, in place of the python
+service in py/server.py, so that vna stream can be run without scikit-rf, e.g. for demos
+and CI. It does a plain SOLT calibration, so the results are close to, but not exactly the
+same as, those of the python service.
+
+export VNA_ADDR=localhost:9001
+export VNA_LOG_LEVEL=info
+vna calibrate
+`,
+	Run: func(cmd *cobra.Command, args []string) {
+
+		viper.SetEnvPrefix("VNA")
+		viper.AutomaticEnv()
+
+		viper.SetDefault("addr", "localhost:9001")
+		viper.SetDefault("log_level", "warn")
+
+		addr := viper.GetString("addr")
+
+		level, err := log.ParseLevel(viper.GetString("log_level"))
+
+		if err != nil {
+			fmt.Print(err.Error())
+			os.Exit(1)
+		}
+
+		log.SetLevel(level)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		c := make(chan os.Signal, 1)
+
+		signal.Notify(c, os.Interrupt)
+
+		go func() {
+			<-c
+			cancel()
+		}()
+
+		err = calibrate.Serve(ctx, addr)
+
+		if err != nil {
+			fmt.Print("cannot serve calibration on " + addr + " because " + err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(calibrateCmd)
+}
@@ -22,11 +22,23 @@ import (
 	"os"
 	"os/signal"
 	"strings"
-	"time"
+	"syscall"
 
 	"github.com/ory/viper"
+	"github.com/practable/pocket-vna-two-port/pkg/audit"
+	"github.com/practable/pocket-vna-two-port/pkg/calkit"
+	"github.com/practable/pocket-vna-two-port/pkg/config"
+	"github.com/practable/pocket-vna-two-port/pkg/history"
+	"github.com/practable/pocket-vna-two-port/pkg/logring"
+	"github.com/practable/pocket-vna-two-port/pkg/measure"
 	"github.com/practable/pocket-vna-two-port/pkg/middle"
+	"github.com/practable/pocket-vna-two-port/pkg/pathloss"
 	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/rfusb"
+	"github.com/practable/pocket-vna-two-port/pkg/store"
+	"github.com/practable/pocket-vna-two-port/pkg/stream"
+	"github.com/practable/pocket-vna-two-port/pkg/watchdog"
+	"github.com/practable/pocket-vna-two-port/pkg/wear"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -35,58 +47,170 @@ import (
 var streamCmd = &cobra.Command{
 	Use:   "stream",
 	Short: "Stream connects a pocketVNA to a websocket server",
-	Long: `Stream connects the first available pocketVNA to a websocket server. The websocket server is specified via an environment variable
+	Long: `Stream connects the first available pocketVNA to a websocket server. The settings can be given in a YAML config file,
+with keys named after the environment variables below (e.g. timeout_usb), and any environment variables that are set take precedence
+
+export VNA_CONFIG=/etc/vna/config.yaml
+vna stream
+
+Send SIGHUP to reload the config file (and environment) without restarting. Only addr, audit_log, baud,
+grpc, listen, log_file, port, sessions, spectator_topic, store, switch, switch_wear, timeout_usb, tls_cert, tls_key, token, topic, usb_reset and watchdog need a restart to change.
+
+or via environment variables alone
 
 export VNA_ADDR=localhost:9001
+export VNA_AUDIT_LOG=/var/log/vna/audit.log
 export VNA_BAUD=57600
+export VNA_CALKIT=/etc/vna/calkit.json
+export VNA_CRASH_DIR=/var/log/vna/crash
+export VNA_FALLBACK=false
+export VNA_FLEET_INTERVAL=1m
+export VNA_FLEET_NAME=rig7
+export VNA_FLEET_TOKEN=some-secret
+export VNA_FLEET_URL=https://fleet.example.org/health
+export VNA_FREQ_CLAMP=false
+export VNA_FREQ_GUARD=10000000
+export VNA_FREQ_MAX=0
+export VNA_FREQ_MIN=0
+export VNA_GRPC=0.0.0.0:9002
 export VNA_LOG_FILE=/var/log/vna/vna.log
 export VNA_LOG_FORMAT=json
 export VNA_LOG_LEVEL=info
+export VNA_MAX_MESSAGE=1048576
+export VNA_PATH_LOSS=/etc/vna/pathloss.json
 export VNA_PORT=/dev/ttyUSB0
+export VNA_PRESETS=full=1e6-4e9/201,uhf=300e6-1e9/101
+export VNA_REPLAY_TTL=5m
+export VNA_SETTLE=0s
+export VNA_SETTLE_PORTS=thru=100ms,dut1=100ms
+export VNA_SPECTATOR_TOPIC=ws://localhost:8888/ws/spectate
+export VNA_STORE=s3://bucket/rig1?endpoint=https://minio.example.org&region=eu-west-2
+export VNA_SWITCH=usb
+export VNA_SWITCH_RATED=5000000
+export VNA_SWITCH_TERMS=dut1,dut3
+export VNA_SWITCH_TRAFFIC=false
+export VNA_SWITCH_TRAFFIC_FILE=/var/log/vna/switch_traffic.log
+export VNA_SWITCH_WEAR=/var/lib/vna/switch_wear.json
 export VNA_TIMEOUT_USB=30s
+export VNA_TIMEOUT_CMDS=rc=10m/1m,crq=1m
 export VNA_TIMEOUT_REQUEST=3m
+export VNA_TIMEOUT_SOFT=30s
 export VNA_TOPIC=ws://localhost:8888/ws/data
+export VNA_USB_RESET="uhubctl -l 1-1 -p 2 -a cycle"
+export VNA_WARMUP=10m
+export VNA_WARMUP_REFUSE=false
+export VNA_WATCHDOG=2m
+export VNA_WEBHOOK_CMDS=crq,rq
+export VNA_WEBHOOK_TOKEN=some-secret
+export VNA_WEBHOOK_URL=https://grader.example.org/results
 vna stream 
+
+or, to serve the stream to clients directly, without a relay (topic is then not used)
+
+export VNA_LISTEN=0.0.0.0:8888
+export VNA_TLS_CERT=/etc/vna/cert.pem
+export VNA_TLS_KEY=/etc/vna/key.pem
+export VNA_TOKEN=some-secret
+export VNA_SESSIONS=addressed
+vna stream
 `,
 	Run: func(cmd *cobra.Command, args []string) {
 
 		viper.SetEnvPrefix("VNA")
 		viper.AutomaticEnv()
 
-		viper.SetDefault("addr", "localhost:9001")
-		viper.SetDefault("baud", 57600)
-		viper.SetDefault("log_file", "/var/log/vna/vna.log")
-		viper.SetDefault("log_format", "json")
-		viper.SetDefault("log_level", "warn")
-		viper.SetDefault("port", "/dev/ttyUSB0")
-		viper.SetDefault("timeout_usb", "30s")
-		viper.SetDefault("timeout_request", "3m")
-		viper.SetDefault("topic", "ws://localhost:8888/ws/data")
-
-		addr := viper.GetString("addr")
-		baud := viper.GetInt("baud")
-		logFile := viper.GetString("log_file")
-		logFormat := viper.GetString("log_format")
-		logLevel := viper.GetString("log_level")
-		port := viper.GetString("port")
-		timeoutUSBStr := viper.GetString("timeout_usb")
-		timeoutRequestStr := viper.GetString("timeout_request")
-		topic := viper.GetString("topic")
-
-		// parse durations
-
-		timeoutRequest, err := time.ParseDuration(timeoutRequestStr)
+		viper.SetDefault("config", "")
+
+		configFile := viper.GetString("config")
+
+		// settings from the config file (if any), overridden by the environment
+		conf, err := config.Load(configFile)
 
 		if err != nil {
-			fmt.Print("cannot parse duration in VNA_TIMEOUT_REQUEST=" + timeoutRequestStr)
+			fmt.Print(err.Error())
 			os.Exit(1)
 		}
 
-		timeoutUSB, err := time.ParseDuration(timeoutUSBStr)
+		addr := conf.Addr
+		baud := conf.Baud
+		calkitFile := conf.CalKit
+		logFile := conf.LogFile
+		logFormat := conf.LogFormat
+		logLevel := conf.LogLevel
+		port := conf.Port
+		switchTerms := conf.SwitchTerms
+		topic := conf.Topic
+
+		// already checked, so these parse
+		settle, timeoutUSB, timeoutRequest := conf.Durations()
+		settlePorts, _ := measure.ParseSettle(conf.SettlePorts)
+		presets, _ := measure.ParsePresets(conf.Presets)
+
+		// an empty path means the cal standards are ideal
+		var kit *calkit.Kit
+
+		if calkitFile != "" {
+			kit, err = calkit.Load(calkitFile)
+			if err != nil {
+				fmt.Print("cannot load calkit " + calkitFile + " because " + err.Error())
+				os.Exit(1)
+			}
+		}
 
-		if err != nil {
-			fmt.Print("cannot parse duration in VNA_TIMEOUT_USB=" + timeoutUSBStr)
-			os.Exit(1)
+		// an empty path means raw results cannot have the switch path loss removed
+		var pl *pathloss.Table
+
+		if conf.PathLoss != "" {
+			pl, err = pathloss.Load(conf.PathLoss)
+			if err != nil {
+				fmt.Print("cannot load path_loss " + conf.PathLoss + " because " + err.Error())
+				os.Exit(1)
+			}
+		}
+
+		// where the audit log and saved cals are kept, nil for nowhere
+		var st store.Store
+
+		if conf.Store != "" {
+			st, err = store.Open(conf.Store)
+			if err != nil {
+				fmt.Print("cannot open store " + conf.Store + " because " + err.Error())
+				os.Exit(1)
+			}
+			defer st.Close()
+		}
+
+		// an empty path means the moves of the switch are only counted until a restart
+		sw := wear.New()
+
+		if conf.SwitchWear != "" {
+			sw, err = wear.Open(conf.SwitchWear)
+			if err != nil {
+				fmt.Print("cannot open switch_wear " + conf.SwitchWear + " because " + err.Error())
+				os.Exit(1)
+			}
+			defer sw.Save()
+		}
+
+		sw.SetRated(conf.SwitchRated)
+
+		// the bytes to and from the switch, recorded on request to diagnose its link
+		traffic := rfusb.NewTraffic(rfusb.DefaultTraffic)
+		traffic.SetFile(conf.SwitchTrafficFile)
+		traffic.SetRecording(conf.SwitchTraffic)
+
+		// an empty path means no record is kept of the requests, unless there is a store
+		var al *audit.Log
+
+		if st != nil {
+			al = audit.OpenStore(st)
+		} else if conf.AuditLog != "" {
+			al, err = audit.Open(conf.AuditLog)
+			if err != nil {
+				fmt.Print("cannot open audit_log " + conf.AuditLog + " because " + err.Error())
+				os.Exit(1)
+			}
+			defer al.Close()
 		}
 
 		// set up logging
@@ -134,17 +258,48 @@ vna stream
 			}
 		}
 
+		// keep the recent log lines, for crash reports
+		ring := logring.New(logring.DefaultSize)
+		log.AddHook(ring)
+
 		// Report useful info
 		log.Infof("vna version: %s", versionString())
+		log.Infof("config: [%s]", configFile)
 		log.Infof("addr: [%s]", addr)
+		log.Infof("audit log: [%s]", conf.AuditLog)
 		log.Infof("baud: [%d]", baud)
+		log.Infof("calkit: [%s]", calkitFile)
+		log.Infof("crash dir: [%s]", conf.CrashDir)
+		log.Infof("fallback: [%t]", conf.Fallback)
+		log.Infof("fleet: [%s every %s as %s]", conf.FleetURL, conf.FleetInterval, conf.FleetName)
+		log.Infof("freq: [%d-%d Hz, guard %d Hz, clamp %t]", conf.FreqMin, conf.FreqMax, conf.FreqGuard, conf.FreqClamp)
+		log.Infof("grpc: [%s]", conf.GRPC)
+		log.Infof("listen: [%s]", conf.Listen)
 		log.Infof("log file: [%s]", logFile)
 		log.Infof("log format: [%s]", logFormat)
 		log.Infof("log level: [%s]", logLevel)
+		log.Infof("max message: [%d]", conf.MaxMessage)
+		log.Infof("path loss: [%s]", conf.PathLoss)
 		log.Infof("port: [%s]", port)
+		log.Infof("presets: [%s]", conf.Presets)
+		log.Infof("replay ttl: [%s]", conf.ReplayTTL)
+		log.Infof("sessions: [%s]", conf.Sessions)
+		log.Infof("settle: [%s]", settle)
+		log.Infof("settle ports: [%v]", settlePorts)
+		log.Infof("spectator topic: [%s]", conf.SpectatorTopic)
+		log.Infof("store: [%s]", conf.Store)
+		log.Infof("switch: [%s]", conf.Switch)
+		log.Infof("switch rated: [%d]", conf.SwitchRated)
+		log.Infof("switch terms: [%v]", switchTerms)
+		log.Infof("switch traffic: [%t to %s]", conf.SwitchTraffic, conf.SwitchTrafficFile)
+		log.Infof("switch wear: [%s]", conf.SwitchWear)
 		log.Infof("topic: [%s]", topic)
-		log.Infof("timeoutRequest: [%s]", timeoutUSB)
+		log.Infof("timeoutRequest: [%s]", timeoutRequest)
 		log.Infof("timeoutUSB: [%s]", timeoutUSB)
+		log.Infof("usb reset: [%s]", conf.USBReset)
+		log.Infof("warmup: [%s, refuse %t]", conf.Warmup, conf.WarmupRefuse)
+		log.Infof("watchdog: [%s]", conf.Ceiling())
+		log.Infof("webhook: [%s for %v]", conf.WebhookURL, conf.WebhookCmds)
 
 		ctx, cancel := context.WithCancel(context.Background())
 
@@ -161,11 +316,99 @@ vna stream
 
 		// connect to VNA
 		v, disconnect, err := pocket.NewHardware()
+
+		// reset the VNA if it hangs or stops responding
+		if conf.Ceiling() > 0 {
+
+			w := watchdog.New(v, disconnect, conf.Ceiling())
+
+			if conf.USBReset != "" {
+				w.Cycle = watchdog.Command(conf.USBReset)
+			}
+
+			v = w
+			disconnect = w.Release
+		}
+
 		defer disconnect()
 
-		m := middle.New(ctx, addr, port, baud, timeoutUSB, timeoutRequest, topic, &v)
+		// serving the stream ourselves means there is no relay to connect to
+		if conf.Listen != "" {
+			topic = ""
+		}
+
+		m, err := middle.New(ctx, addr, conf.Switch, port, baud, timeoutUSB, timeoutRequest, topic, &v)
+
+		if err != nil {
+			fmt.Print(err.Error())
+			os.Exit(1)
+		}
+
+		if conf.Listen != "" {
+
+			s, err := stream.NewServer(ctx, stream.Listen{
+				Addr:    conf.Listen,
+				Cert:    conf.TLSCert,
+				Key:     conf.TLSKey,
+				Token:   conf.Token,
+				Address: conf.Addressed(),
+			})
+
+			if err != nil {
+				fmt.Print("cannot serve stream on " + conf.Listen + " because " + err.Error())
+				os.Exit(1)
+			}
+
+			m.SetStream(&s)
+		}
+
+		m.SetMaxMessage(conf.MaxMessage)
+		m.SetSpectator(conf.SpectatorTopic)
+		m.SetSettling(settle, settlePorts)
+		m.SetCalKit(kit)
+		m.SetPathLoss(pl)
+		m.SetFallback(conf.Fallback)
+		m.SetGuard(conf.Guard())
+		m.SetPresets(presets)
+		m.SetRelease(disconnect)
+		m.SetWarmup(conf.Warming())
+		m.SetReplay(conf.Replay())
+		m.SetTimeouts(conf.Timeouts())
+		m.SetAudit(al)
+		m.SetStore(st)
+		m.SetFleet(conf.Fleet())
+		m.SetWebhook(conf.Webhook())
+		m.SetWear(sw)
+		m.SetTraffic(traffic)
+		m.SetVersion(versionString())
+		m.SetCrashDir(conf.CrashDir)
+		m.SetLogRing(ring)
+		m.SetHistory(history.New(history.DefaultSize))
+		m.SetConfig(configFile, conf)
+
+		// reload the config on SIGHUP, e.g. systemctl reload vna
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		m.NotifyReload(hup)
+
+		err = m.SetSwitchStandards(switchTerms)
+
+		if err != nil {
+			fmt.Print("cannot use switch_terms " + strings.Join(switchTerms, ",") + " because " + err.Error())
+			os.Exit(1)
+		}
 		go m.Run()
 
+		// optional, for clients on the LAN that don't use the relay
+		if conf.GRPC != "" {
+			go func() {
+				err := m.ServeGRPC(conf.GRPC)
+				if err != nil {
+					log.WithFields(log.Fields{"grpc": conf.GRPC, "error": err.Error()}).Error("could not serve gRPC measurement API")
+				}
+			}()
+		}
+
 		<-ctx.Done()
 
 	},
@@ -31,7 +31,24 @@
   *  {"report":"port","is":"open"}
   *  {"report":"port","is":"load"}  
   *  {"report":"port","is":"dut1"}  
+  *  To check the current port without changing it, use
+  *  {"get":"port"}
+  *  and you will get the same report message as when the port is set
   *  etc  
+  *
+  *  Protocol version 2 adds an optional sequence number and checksum to
+  *  commands, which are then echoed in the report, e.g.
+  *  {"set":"port","to":"dut1","seq":5,"crc":"xxxx"}
+  *  {"report":"port","is":"dut1","seq":5,"crc":"xxxx"}
+  *  where crc is the CRC-16/CCITT-FALSE of the other values joined by |, in
+  *  order, as four lower case hex digits, e.g. of port|dut1|5 above.
+  *  A command with the wrong checksum is not acted on, and reported as
+  *  {"report":"error","is":"crc","seq":5,"crc":"xxxx"}
+  *  and a command that cannot be parsed is reported as
+  *  {"report":"error","is":"json"}
+  *  To find which version is supported, use
+  *  {"get":"version"}
+  *  which gets {"report":"version","is":"2"} (version 1 does not reply)
   */
 
 
@@ -135,6 +152,7 @@ enum blinkCode {
 /*********** JSON SERIAL ***********/
 #define COMMAND_SIZE 128 
 #define REPORT_SIZE 128 
+#define PROTOCOL_VERSION "2"
 char command[COMMAND_SIZE];
 char writeBuffer[REPORT_SIZE];
 StaticJsonDocument<COMMAND_SIZE> doc;
@@ -144,6 +162,7 @@ StaticJsonDocument<COMMAND_SIZE> doc;
 bool writing;//for serial semaphore
 long int count; //counter for displaying port set as blinks.
 int blink; //current port state to blink according to blink enum
+long pendingSeq; //sequence number of the set command to echo in the next port report, 0 if none
 
 // pins struct represents RF switch control pin settings
 struct pins {
@@ -158,6 +177,9 @@ typedef struct pins Pins;
 
 void setRFPort(int port1, int port2);
 void reportRFPort(const char *name); //const since not modifying the string
+void report(const char *what, const char *is, long seq);
+uint16_t crc16Update(uint16_t crc, const char *s);
+uint16_t checksum(const char *a, const char *b, long seq);
 void requestSerial(void);
 void releaseSerial(void);
 bool blinkState(long int count, int blink);
@@ -416,14 +438,60 @@ void setRFPort(int port1, int port2){
 }
 
 void reportRFPort(const char *name){
+  report("port", name, pendingSeq);
+  pendingSeq = 0; //only the first report after the set command is the reply
+}
+
+// report prints {"report":what,"is":is}, with the sequence number and 
+// checksum added if seq is not zero (protocol version 2)
+void report(const char *what, const char *is, long seq){
+  char crc[5];
+  
   requestSerial();
-  Serial.print("{\"report\":\"port\",\"is\":\"");
-  Serial.print(name);
-  Serial.println("\"}");
+  Serial.print("{\"report\":\"");
+  Serial.print(what);
+  Serial.print("\",\"is\":\"");
+  Serial.print(is);
+  Serial.print("\"");
+  if (seq != 0) {
+    sprintf(crc, "%04x", checksum(what, is, seq));
+    Serial.print(",\"seq\":");
+    Serial.print(seq);
+    Serial.print(",\"crc\":\"");
+    Serial.print(crc);
+    Serial.print("\"");
+  }
+  Serial.println("}");
   releaseSerial();
   
 }
 
+// crc16Update adds the characters of s to the CRC-16/CCITT-FALSE in crc
+uint16_t crc16Update(uint16_t crc, const char *s){
+  while (*s) {
+    crc ^= ((uint16_t)(uint8_t)*s++) << 8;
+    for (int i = 0; i < 8; i++) {
+      crc = (crc & 0x8000) ? (uint16_t)((crc << 1) ^ 0x1021) : (uint16_t)(crc << 1);
+    }
+  }
+  return crc;
+}
+
+// checksum gives the CRC of a|b|seq, or a|seq if b is NULL, to match pkg/rfusb Checksum
+uint16_t checksum(const char *a, const char *b, long seq){
+  char num[12];
+  uint16_t crc = 0xFFFF;
+  
+  crc = crc16Update(crc, a);
+  if (b != NULL) {
+    crc = crc16Update(crc, "|");
+    crc = crc16Update(crc, b);
+  }
+  crc = crc16Update(crc, "|");
+  ltoa(seq, num, 10);
+  return crc16Update(crc, num);
+}
+
 bool blinkState(long int count, int blink){
 
   // we want a pattern like this
@@ -700,14 +768,42 @@ StateType readSerialJSON(StateType state) {
 
   if(Serial.available() > 0) {
 
-    Serial.readBytesUntil(10, command, COMMAND_SIZE);
-    deserializeJson(doc, command);
+    size_t n = Serial.readBytesUntil(10, command, COMMAND_SIZE - 1);
+    command[n] = 0; //terminate, so no part of the last command is left over
+    
+    if (deserializeJson(doc, command) != DeserializationError::Ok) {
+        report("error", "json", 0);
+        return state;
+    }
 
     const char* set = doc["set"];
+    const char* get = doc["get"];
+    const char* to = doc["to"];
+    const char* crc = doc["crc"];
+    long seq = doc["seq"] | 0L;
+
+    // protocol version 2: check the command was not corrupted
+    if (crc != NULL) {
+        char expected[5];
+        sprintf(expected, "%04x", checksum(set != NULL ? set : (get != NULL ? get : ""), set != NULL ? to : NULL, seq));
+        if (strcmp(crc, expected) != 0) {
+          report("error", "crc", seq);
+          return state;
+        }
+    }
+
+    if(get != NULL && strcmp(get, "version")==0) {
+        report("version", PROTOCOL_VERSION, 0);
+    }
 
-    if(strcmp(set, "port")==0) {
+    if(get != NULL && strcmp(get, "port")==0) {
+        report("port", nameFromState(state), seq);
+    }
+
+    if(set != NULL && strcmp(set, "port")==0 && to != NULL) {
  
-        const char* port = doc["to"];
+        const char* port = to;
+        pendingSeq = seq; //reported when the port has been set
 
         if(strcmp(port, name_short) == 0) {
           state = STATE_SHORT_BEFORE;
@@ -739,6 +835,36 @@ StateType readSerialJSON(StateType state) {
   return state;     //return whatever state it changed to or maintain the state.
 }
 
+// nameFromState gives the name of the port that the state has set
+const char* nameFromState(StateType state) {
+
+  switch (state) {
+    case STATE_OPEN_BEFORE:
+    case STATE_OPEN_DURING:
+      return name_open;
+    case STATE_LOAD_BEFORE:
+    case STATE_LOAD_DURING:
+      return name_load;
+    case STATE_THRU_BEFORE:
+    case STATE_THRU_DURING:
+      return name_thru;
+    case STATE_DUT1_BEFORE:
+    case STATE_DUT1_DURING:
+      return name_dut1;
+    case STATE_DUT2_BEFORE:
+    case STATE_DUT2_DURING:
+      return name_dut2;
+    case STATE_DUT3_BEFORE:
+    case STATE_DUT3_DURING:
+      return name_dut3;
+    case STATE_DUT4_BEFORE:
+    case STATE_DUT4_DURING:
+      return name_dut4;
+    default:
+      return name_short;
+  }
+}
+
 void requestSerial(void){
   while(writing); //wait for port to free up
   writing = true;
@@ -0,0 +1,134 @@
+package harness
+
+import (
+	"math"
+	"math/cmplx"
+
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+)
+
+// Model is a device whose actual S-parameters depend on frequency (Hz), as those
+// of a real DUT do, so that the synthetic VNA gives traces like those of a real rig
+type Model func(freq uint64) twoport.S
+
+// z0 is the reference impedance of the models, ohms
+const z0 = 50.0
+
+// abcd is the chain (ABCD) matrix of a two-port, which makes it easy to build a
+// device from series and shunt elements, by multiplying their matrices in order
+type abcd [2][2]complex128
+
+// mul returns the chain matrix of a followed by b
+func (a abcd) mul(b abcd) abcd {
+	return abcd{
+		{a[0][0]*b[0][0] + a[0][1]*b[1][0], a[0][0]*b[0][1] + a[0][1]*b[1][1]},
+		{a[1][0]*b[0][0] + a[1][1]*b[1][0], a[1][0]*b[0][1] + a[1][1]*b[1][1]},
+	}
+}
+
+// s returns the S-parameters of the two-port, in z0
+func (a abcd) s() twoport.S {
+
+	A, B, C, D := a[0][0], a[0][1]/z0, a[1][0]*z0, a[1][1]
+
+	d := A + B + C + D
+
+	return twoport.S{
+		{(A + B - C - D) / d, 2 * (A*D - B*C) / d},
+		{2 / d, (-A + B - C + D) / d},
+	}
+}
+
+// series is an impedance z in series between the ports
+func series(z complex128) abcd {
+	return abcd{{1, z}, {0, 1}}
+}
+
+// shunt is an admittance y from the line to ground
+func shunt(y complex128) abcd {
+	return abcd{{1, 0}, {y, 1}}
+}
+
+// omega returns the angular frequency of freq
+func omega(freq uint64) float64 {
+	return 2 * math.Pi * float64(freq)
+}
+
+// Fixed is a device with the same S-parameters s at every frequency
+func Fixed(s twoport.S) Model {
+	return func(uint64) twoport.S {
+		return s
+	}
+}
+
+// Attenuate is a matched attenuator of db decibels, at every frequency
+func Attenuate(db float64) Model {
+
+	t := complex(math.Pow(10, -db/20), 0)
+
+	return Fixed(twoport.S{{0, t}, {t, 0}})
+}
+
+// Line is a length of transmission line of impedance z (ohms) and one-way delay
+// (seconds), whose loss is db decibels at 1 GHz, rising with the square root of
+// frequency as the skin effect in a coaxial cable does
+func Line(z, delay, db float64) Model {
+
+	return func(freq uint64) twoport.S {
+
+		alpha := db / (20 * math.Log10(math.E)) * math.Sqrt(float64(freq)/1e9)
+
+		gl := complex(alpha, omega(freq)*delay)
+
+		ch, sh := cmplx.Cosh(gl), cmplx.Sinh(gl)
+
+		return abcd{
+			{ch, complex(z, 0) * sh},
+			{sh / complex(z, 0), ch},
+		}.s()
+	}
+}
+
+// Resonator is a resistor r (ohms), inductor l (henries) and capacitor c (farads)
+// in series between the ports, which passes a band around its resonant frequency,
+// 1/(2 pi sqrt(lc)), and reflects the rest
+func Resonator(r, l, c float64) Model {
+
+	return func(freq uint64) twoport.S {
+
+		w := omega(freq)
+
+		if w == 0 {
+			return twoport.S{{1, 0}, {0, 1}} // the capacitor is open
+		}
+
+		return series(complex(r, w*l-1/(w*c))).s()
+	}
+}
+
+// LowPass is a Butterworth low-pass filter of the given order, with its 3 dB
+// cutoff at fc (Hz), built as a ladder of series inductors and shunt capacitors
+func LowPass(fc float64, order int) Model {
+
+	wc := 2 * math.Pi * fc
+
+	return func(freq uint64) twoport.S {
+
+		w := omega(freq)
+
+		a := abcd{{1, 0}, {0, 1}}
+
+		for k := 1; k <= order; k++ {
+
+			g := 2 * math.Sin(float64(2*k-1)*math.Pi/float64(2*order))
+
+			if k%2 == 1 {
+				a = a.mul(series(complex(0, w*g*z0/wc)))
+			} else {
+				a = a.mul(shunt(complex(0, w*g/(z0*wc))))
+			}
+		}
+
+		return a.s()
+	}
+}
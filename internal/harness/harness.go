@@ -0,0 +1,232 @@
+// Package harness runs the whole daemon in-process for end to end tests, without
+// hardware: Middle with a mock rf switch, a synthetic VNA, and the emulated
+// calibration service over an in-memory gRPC connection, served on a local
+// websocket stream that a scripted client sends commands to as a user would.
+package harness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/practable/pocket-vna-two-port/pkg/calibrate"
+	"github.com/practable/pocket-vna-two-port/pkg/calibration"
+	"github.com/practable/pocket-vna-two-port/pkg/measure"
+	"github.com/practable/pocket-vna-two-port/pkg/middle"
+	"github.com/practable/pocket-vna-two-port/pkg/pb"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/rfusb"
+	"github.com/practable/pocket-vna-two-port/pkg/stream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Timeout is how long each request may take, and how long the client waits for its response
+const Timeout = 10 * time.Second
+
+// Harness is a running daemon, and the mocks it uses in place of hardware
+type Harness struct {
+	Middle *middle.Middle
+	Switch *rfusb.Mock
+	VNA    *VNA
+	// URL of the stream, for clients to connect to
+	URL    string
+	cancel context.CancelFunc
+	srv    *grpc.Server
+	conn   *grpc.ClientConn
+}
+
+// New starts a daemon, which runs until Close is called or ctx is done
+func New(ctx context.Context) (*Harness, error) {
+
+	ctx, cancel := context.WithCancel(ctx)
+
+	sw := rfusb.NewMock()
+	vna := NewVNA(sw)
+
+	var v pocket.VNA = vna
+
+	hw := measure.NewHardware(&v, sw)
+
+	// the emulated calibration service, reached over an in-memory connection
+	lis := bufconn.Listen(1 << 20)
+	srv := grpc.NewServer()
+	pb.RegisterCalibrateServer(srv, &calibrate.Emulator{})
+
+	go srv.Serve(lis)
+
+	conn, err := grpc.DialContext(ctx, "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+
+	if err != nil {
+		srv.Stop()
+		cancel()
+		return nil, err
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		conn.Close()
+		srv.Stop()
+		cancel()
+		return nil, err
+	}
+
+	s := stream.Serve(ctx, ln, stream.Listen{})
+
+	m := middle.NewWith(ctx, hw, calibration.NewGRPC(pb.NewCalibrateClient(conn)), Timeout)
+	m.SetStream(&s)
+
+	go m.Run()
+
+	return &Harness{
+		Middle: &m,
+		Switch: sw,
+		VNA:    vna,
+		URL:    "ws://" + ln.Addr().String(),
+		cancel: cancel,
+		srv:    srv,
+		conn:   conn,
+	}, nil
+}
+
+// Close stops the daemon and the calibration service
+func (h *Harness) Close() {
+	h.cancel()
+	h.conn.Close()
+	h.srv.Stop()
+}
+
+// Client is a user of the daemon, sending commands over the stream
+type Client struct {
+	conn *websocket.Conn
+	n    int
+}
+
+// Dial connects a client to the stream of the daemon
+func (h *Harness) Dial() (*Client, error) {
+
+	conn, _, err := websocket.DefaultDialer.Dial(h.URL, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{conn: conn}, nil
+}
+
+// Close disconnects the client
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Do sends request, a JSON command, and unmarshals the response to it into response,
+// unless that is nil. The request is given an id if it has none, so that heartbeats
+// and responses to other requests can be skipped. An error response is returned as an error.
+func (c *Client) Do(request string, response interface{}) error {
+
+	var r map[string]interface{}
+
+	err := json.Unmarshal([]byte(request), &r)
+
+	if err != nil {
+		return fmt.Errorf("request is not JSON because %s", err.Error())
+	}
+
+	id, _ := r["id"].(string)
+
+	if id == "" {
+		c.n++
+		id = fmt.Sprintf("harness-%d", c.n)
+		r["id"] = id
+	}
+
+	b, err := json.Marshal(r)
+
+	if err != nil {
+		return err
+	}
+
+	err = c.conn.WriteMessage(websocket.TextMessage, b)
+
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(Timeout)
+
+	for {
+
+		c.conn.SetReadDeadline(deadline)
+
+		_, data, err := c.conn.ReadMessage()
+
+		if err != nil {
+			return fmt.Errorf("no response to %s because %s", r["cmd"], err.Error())
+		}
+
+		var reply struct {
+			pocket.Command
+			Message *string
+			Request *pocket.Command `json:"Command"`
+		}
+
+		if err := json.Unmarshal(data, &reply); err != nil {
+			continue
+		}
+
+		// an error, with the request it was for
+		if reply.Message != nil && reply.Request != nil {
+			if reply.Request.ID == id {
+				return errors.New(*reply.Message)
+			}
+			continue
+		}
+
+		if reply.ID != id {
+			continue
+		}
+
+		if response == nil {
+			return nil
+		}
+
+		return json.Unmarshal(data, response)
+	}
+}
+
+// Script sends the JSON command on each line of script in turn, stopping at the first
+// error, and returns the responses so far. Blank lines, and lines starting with #, are skipped.
+func (c *Client) Script(script string) ([]json.RawMessage, error) {
+
+	var responses []json.RawMessage
+
+	for i, line := range strings.Split(script, "\n") {
+
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var r json.RawMessage
+
+		if err := c.Do(line, &r); err != nil {
+			return responses, fmt.Errorf("line %d: %s", i+1, err.Error())
+		}
+
+		responses = append(responses, r)
+	}
+
+	return responses, nil
+}
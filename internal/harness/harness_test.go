@@ -0,0 +1,470 @@
+package harness
+
+import (
+	"context"
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/rfusb"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.SetLevel(log.WarnLevel)
+}
+
+// equal asserts that a calibrated result is the actual device, at every frequency
+func equal(t *testing.T, device Model, got []pocket.SParam) {
+
+	for _, p := range got {
+
+		s := twoport.FromSParam(p)
+		want := device(p.Freq)
+
+		for i := 0; i < 2; i++ {
+			for j := 0; j < 2; j++ {
+				assert.InDelta(t, real(want[i][j]), real(s[i][j]), 1e-9, "%d Hz", p.Freq)
+				assert.InDelta(t, imag(want[i][j]), imag(s[i][j]), 1e-9, "%d Hz", p.Freq)
+			}
+		}
+	}
+}
+
+func TestVNA(t *testing.T) {
+
+	v := NewVNA(nil)
+
+	assert.Equal(t, []uint64{100, 150, 200}, distribute(pocket.Range{Start: 100, End: 200}, 3, false))
+	assert.Equal(t, []uint64{100, 1000, 10000}, distribute(pocket.Range{Start: 100, End: 10000}, 3, true))
+
+	// the synthetic measurements are what a calibration removes
+	m := twoport.FromSParam(v.terms(1e9).Measure(Attenuator).SParam(1e9))
+	assert.NotEqual(t, Attenuator, m)
+
+	c := v.terms(1e9).Correct(m)
+	assert.InDelta(t, 0.5, real(c[1][0]), 1e-12)
+}
+
+func TestModels(t *testing.T) {
+
+	// each device is passive and reciprocal
+	for _, d := range []Model{Attenuate(3), Line(75, 1e-9, 0.5), Bandpass, Filter} {
+		for _, f := range []uint64{1e6, 100e6, 503e6, 1e9, 3e9} {
+			s := d(f)
+			assert.InDelta(t, 0, cmplx.Abs(s[0][1]-s[1][0]), 1e-12)
+			assert.True(t, cmplx.Abs(s[0][0])*cmplx.Abs(s[0][0])+cmplx.Abs(s[1][0])*cmplx.Abs(s[1][0]) <= 1+1e-12)
+		}
+	}
+
+	assert.InDelta(t, 0.1, real(Attenuate(20)(1e9)[1][0]), 1e-12)
+
+	// a matched, lossless line only delays
+	s := Line(50, 0.25e-9, 0)(1e9)
+	assert.InDelta(t, 0, cmplx.Abs(s[0][0]), 1e-12)
+	assert.InDelta(t, 0, real(s[1][0]), 1e-12)
+	assert.InDelta(t, -1, imag(s[1][0]), 1e-12)
+
+	// loss given at 1 GHz, twice as much in dB at 4 GHz
+	assert.InDelta(t, -1, 20*math.Log10(cmplx.Abs(Line(50, 1e-9, 1)(1e9)[1][0])), 1e-9)
+	assert.InDelta(t, -2, 20*math.Log10(cmplx.Abs(Line(50, 1e-9, 1)(4e9)[1][0])), 1e-9)
+
+	// a mismatched line reflects, except where it is half a wavelength long
+	assert.True(t, cmplx.Abs(Line(75, 1e-9, 0)(250e6)[0][0]) > 0.1)
+	assert.InDelta(t, 0, cmplx.Abs(Line(75, 1e-9, 0)(500e6)[0][0]), 1e-12)
+
+	// only the resistor is left at resonance
+	f0 := 1 / (2 * math.Pi * math.Sqrt(100e-9*1e-12))
+	assert.InDelta(t, 100.0/105, cmplx.Abs(Bandpass(uint64(math.Round(f0)))[1][0]), 1e-6)
+	assert.True(t, cmplx.Abs(Bandpass(50e6)[1][0]) < 0.1)
+	assert.True(t, cmplx.Abs(Bandpass(3e9)[1][0]) < 0.1)
+
+	// 3 dB down at the cutoff, and falling at 20 dB per decade per order above it
+	assert.InDelta(t, 1, cmplx.Abs(Filter(1e6)[1][0]), 1e-6)
+	assert.InDelta(t, -3.0103, 20*math.Log10(cmplx.Abs(Filter(1e9)[1][0])), 1e-3)
+	assert.InDelta(t, -10*math.Log10(1+math.Pow(3, 10)), 20*math.Log10(cmplx.Abs(Filter(3e9)[1][0])), 1e-6)
+}
+
+func TestNoise(t *testing.T) {
+
+	sw := rfusb.NewMock()
+	assert.NoError(t, sw.SetDUT1())
+
+	v := NewVNA(sw)
+
+	sweep := func() []pocket.SParam {
+		rq := pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 1e9}, Size: 51}
+		assert.NoError(t, v.RangeQuery(&rq))
+		return rq.Result
+	}
+
+	clean := sweep()
+
+	n := Noise{Seed: 1, Trace: 0.01, Drift: 0.001, Spurs: 0.1, Spur: 0.5}
+
+	v.SetNoise(n)
+	a := sweep()
+	b := sweep()
+	assert.NotEqual(t, clean, a)
+	assert.NotEqual(t, a, b)
+
+	// the same again, from the seed
+	v.SetNoise(n)
+	assert.Equal(t, a, sweep())
+	assert.Equal(t, b, sweep())
+
+	n.Seed = 2
+	v.SetNoise(n)
+	assert.NotEqual(t, a, sweep())
+
+	// the trace noise has the standard deviation given
+	v.SetNoise(Noise{Seed: 1, Trace: 0.01})
+
+	var sum float64
+	var count int
+
+	for i := 0; i < 20; i++ {
+		for j, p := range sweep() {
+			d := p.S21.Real - clean[j].S21.Real
+			sum += d * d
+			count++
+		}
+	}
+
+	assert.InDelta(t, 0.01, math.Sqrt(sum/float64(count)), 0.001)
+}
+
+// worst returns the largest error in S21 of a calibrated result from the actual device
+func worst(device Model, got []pocket.SParam) float64 {
+
+	var w float64
+
+	for _, p := range got {
+		w = math.Max(w, cmplx.Abs(twoport.FromSParam(p)[1][0]-device(p.Freq)[1][0]))
+	}
+
+	return w
+}
+
+func TestNoiseAveraging(t *testing.T) {
+
+	h, err := New(context.Background())
+	assert.NoError(t, err)
+	defer h.Close()
+
+	c, err := h.Dial()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	assert.NoError(t, c.Do(`{"cmd":"rc","range":{"start":100000000,"end":1000000000},"size":21}`, nil))
+
+	measure := func(request string) float64 {
+		var crq pocket.CalibratedRangeQuery
+		assert.NoError(t, c.Do(request, &crq))
+		return worst(Fixed(Attenuator), crq.Result)
+	}
+
+	h.VNA.SetNoise(Noise{Seed: 1, Trace: 0.01})
+
+	one := measure(`{"cmd":"crq","what":"dut1"}`)
+	many := measure(`{"cmd":"crq","what":"dut1","sweeps":16}`)
+	assert.True(t, one > 0.01, one)
+	assert.True(t, many < one/2, "%f %f", many, one)
+
+	// spurs spoil the mean, but not the median
+	h.VNA.SetNoise(Noise{Seed: 1, Spurs: 0.05, Spur: 1})
+
+	mean := measure(`{"cmd":"crq","what":"dut1","sweeps":9}`)
+	median := measure(`{"cmd":"crq","what":"dut1","sweeps":9,"reject":"median"}`)
+	assert.True(t, mean > 0.05, mean)
+	assert.True(t, median < mean/10, "%f %f", median, mean)
+}
+
+func TestCommands(t *testing.T) {
+
+	h, err := New(context.Background())
+	assert.NoError(t, err)
+	defer h.Close()
+
+	c, err := h.Dial()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	var rr pocket.ReasonableFrequencyRange
+	assert.NoError(t, c.Do(`{"cmd":"rr"}`, &rr))
+	assert.Equal(t, h.VNA.Range, rr.Result)
+
+	// nothing to correct with yet
+	err = c.Do(`{"cmd":"crq","what":"dut1"}`, nil)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "not calibrated yet")
+
+	var rq pocket.RangeQuery
+	assert.NoError(t, c.Do(`{"cmd":"rq","what":"dut1","range":{"start":100000000,"end":200000000},"size":5}`, &rq))
+	assert.Equal(t, 5, len(rq.Result))
+	assert.Equal(t, "dut1", h.Switch.Get())
+	assert.Equal(t, pocket.CorrectionNone, rq.Meta.Correction)
+	assert.NotEqual(t, 0.5, rq.Result[0].S21.Real) // raw
+
+	var rc pocket.RangeQuery
+	assert.NoError(t, c.Do(`{"cmd":"rc","range":{"start":100000000,"end":4000000000},"size":21,"islog":true}`, &rc))
+	assert.Equal(t, 21, len(rc.Result))
+	equal(t, Fixed(twoport.Thru), rc.Result)
+
+	for what, want := range map[string]Model{"dut1": Fixed(Attenuator), "dut2": Fixed(Mismatch), "dut3": Bandpass, "dut4": Filter} {
+
+		var crq pocket.CalibratedRangeQuery
+		assert.NoError(t, c.Do(`{"cmd":"crq","what":"`+what+`"}`, &crq))
+		assert.Equal(t, 21, len(crq.Result), what)
+		assert.Equal(t, what, h.Switch.Get())
+		equal(t, want, crq.Result)
+	}
+
+	// a new device is measured, not cached
+	h.VNA.SetDevice("dut4", Attenuator)
+
+	var crq pocket.CalibratedRangeQuery
+	assert.NoError(t, c.Do(`{"cmd":"crq","what":"dut4","format":"db"}`, &crq))
+	equal(t, Fixed(Attenuator), crq.Result)
+	assert.InDelta(t, -6.02, crq.Formatted[0].S21.Mag, 0.01)
+
+	assert.Error(t, c.Do(`{"cmd":"crq","what":"dut5"}`, nil))
+}
+
+func TestDebugTiming(t *testing.T) {
+
+	h, err := New(context.Background())
+	assert.NoError(t, err)
+	defer h.Close()
+
+	c, err := h.Dial()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	var rc pocket.RangeQuery
+	assert.NoError(t, c.Do(`{"cmd":"rc","range":{"start":1000000,"end":3000000000},"size":101,"debugtiming":true}`, &rc))
+	assert.NotNil(t, rc.Meta.Timing)
+	assert.True(t, rc.Meta.Timing.Sweep > 0)
+	assert.True(t, rc.Meta.Timing.Calibrate > 0)
+	assert.True(t, rc.Meta.Timing.Marshal > 0)
+
+	var crq pocket.CalibratedRangeQuery
+	assert.NoError(t, c.Do(`{"cmd":"crq","what":"dut1","maxage":60}`, &crq))
+	assert.Nil(t, crq.Meta.Timing)
+
+	// the error terms from the cal are used, so there is no call to the calibration service
+	crq = pocket.CalibratedRangeQuery{}
+	assert.NoError(t, c.Do(`{"cmd":"crq","what":"dut2","debugtiming":true}`, &crq))
+	tm := crq.Meta.Timing
+	assert.NotNil(t, tm)
+	assert.True(t, tm.Sweep > 0)
+	assert.Equal(t, 0.0, tm.Calibrate)
+	assert.True(t, tm.Total >= tm.Switch+tm.Sweep+tm.Marshal)
+
+	// a cached result is timed too, without being changed in the cache
+	crq = pocket.CalibratedRangeQuery{}
+	assert.NoError(t, c.Do(`{"cmd":"crq","what":"dut1","maxage":60,"debugtiming":true}`, &crq))
+	assert.True(t, crq.Cached)
+	assert.Equal(t, 0.0, crq.Meta.Timing.Sweep)
+
+	crq = pocket.CalibratedRangeQuery{}
+	assert.NoError(t, c.Do(`{"cmd":"crq","what":"dut1","maxage":60}`, &crq))
+	assert.True(t, crq.Cached)
+	assert.Nil(t, crq.Meta.Timing)
+
+	var rq pocket.RangeQuery
+	assert.NoError(t, c.Do(`{"cmd":"rq","what":"dut3","range":{"start":1000000,"end":3000000000},"size":11,"debugtiming":true}`, &rq))
+	assert.True(t, rq.Meta.Timing.Sweep > 0)
+}
+
+func TestScript(t *testing.T) {
+
+	h, err := New(context.Background())
+	assert.NoError(t, err)
+	defer h.Close()
+
+	c, err := h.Dial()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	r, err := c.Script(`
+		# calibrate, then measure each dut
+		{"cmd":"rc","range":{"start":1000000,"end":3000000000},"size":11}
+		{"cmd":"crq","what":"dut1"}
+		{"cmd":"crq","what":"dut2","sweeps":3}
+		{"cmd":"sp","power":-10}
+		{"cmd":"crq","what":"dut1"}
+		{"cmd":"hello"}
+	`)
+
+	// the cal was at the old power
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "line 7")
+	assert.Equal(t, 4, len(r))
+	assert.Equal(t, -10.0, h.VNA.Power)
+	assert.Equal(t, 4+1+3, h.VNA.Sweeps) // four standards for the cal, and none once it fails
+}
+
+func TestBadStandard(t *testing.T) {
+
+	h, err := New(context.Background())
+	assert.NoError(t, err)
+	defer h.Close()
+
+	c, err := h.Dial()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	// the cable has come off the load
+	h.VNA.SetDevice("load", twoport.Ideal.Open)
+
+	err = c.Do(`{"cmd":"rc","range":{"start":1000000,"end":3000000000},"size":11}`, nil)
+	assert.EqualError(t, err, "calibration stopped because the load on port 1 looks the same as the open, is it connected?")
+
+	err = c.Do(`{"cmd":"crq","what":"dut1"}`, nil)
+	assert.EqualError(t, err, "not calibrated yet")
+
+	h.VNA.SetDevice("load", twoport.Ideal.Load)
+	assert.NoError(t, c.Do(`{"cmd":"rc","range":{"start":1000000,"end":3000000000},"size":11}`, nil))
+}
+
+func TestCalState(t *testing.T) {
+
+	h, err := New(context.Background())
+	assert.NoError(t, err)
+	defer h.Close()
+
+	c, err := h.Dial()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	var cs pocket.CalState
+	assert.NoError(t, c.Do(`{"cmd":"cs"}`, &cs))
+	assert.Equal(t, "uncalibrated", cs.State)
+
+	assert.NoError(t, c.Do(`{"cmd":"rc","range":{"start":1000000,"end":3000000000},"size":11}`, nil))
+
+	cs = pocket.CalState{}
+	assert.NoError(t, c.Do(`{"cmd":"calstate"}`, &cs))
+	assert.Equal(t, "calibrated", cs.State)
+	assert.Equal(t, []string{"short", "open", "load", "thru"}, cs.Measured)
+
+	// the cal does not apply at another power, until it is set back
+	assert.NoError(t, c.Do(`{"cmd":"sp","power":-10}`, nil))
+
+	cs = pocket.CalState{}
+	assert.NoError(t, c.Do(`{"cmd":"cs"}`, &cs))
+	assert.Equal(t, "stale", cs.State)
+	assert.Contains(t, cs.Reason, "output power")
+
+	assert.NoError(t, c.Do(`{"cmd":"sp","power":0}`, nil))
+
+	cs = pocket.CalState{}
+	assert.NoError(t, c.Do(`{"cmd":"cs"}`, &cs))
+	assert.Equal(t, "calibrated", cs.State)
+
+	// a failed cal leaves none, rather than the previous one, but keeps the standards
+	h.VNA.SetDevice("load", twoport.Ideal.Open)
+	assert.Error(t, c.Do(`{"cmd":"rc","range":{"start":1000000,"end":3000000000},"size":11}`, nil))
+
+	cs = pocket.CalState{}
+	assert.NoError(t, c.Do(`{"cmd":"cs"}`, &cs))
+	assert.Equal(t, "interrupted", cs.State)
+	assert.Contains(t, cs.Reason, "calibration stopped")
+	assert.Empty(t, cs.Remaining)
+
+	assert.Error(t, c.Do(`{"cmd":"crq","what":"dut1"}`, nil))
+
+	// so only the wrong standard is measured again once it is put right
+	h.VNA.SetDevice("load", twoport.Ideal.Load)
+	rc := pocket.ResumeCal{}
+	assert.NoError(t, c.Do(`{"cmd":"resumecal","redo":["load"]}`, &rc))
+	assert.Equal(t, []string{"load"}, rc.Measured)
+
+	cs = pocket.CalState{}
+	assert.NoError(t, c.Do(`{"cmd":"cs"}`, &cs))
+	assert.Equal(t, "calibrated", cs.State)
+}
+
+func TestMeasureCal(t *testing.T) {
+
+	h, err := New(context.Background())
+	assert.NoError(t, err)
+	defer h.Close()
+
+	c, err := h.Dial()
+	assert.NoError(t, err)
+	defer c.Close()
+
+	err = c.Do(`{"cmd":"mc","what":"load"}`, nil)
+	assert.EqualError(t, err, "a standard can only be measured again when calibrated, not uncalibrated")
+
+	assert.NoError(t, c.Do(`{"cmd":"rc","range":{"start":1000000,"end":3000000000},"size":11}`, nil))
+
+	err = c.Do(`{"cmd":"mc","what":"dut1"}`, nil)
+	assert.EqualError(t, err, "what must be one of short, open, load, thru, not dut1")
+
+	// a bad measurement of the load is not used, and the cal is kept
+	h.VNA.SetDevice("load", twoport.Ideal.Open)
+
+	err = c.Do(`{"cmd":"mc","what":"load"}`, nil)
+	assert.EqualError(t, err, "calibration stopped because the load on port 1 looks the same as the open, is it connected?")
+
+	var crq pocket.CalibratedRangeQuery
+	assert.NoError(t, c.Do(`{"cmd":"crq","what":"dut1"}`, &crq))
+	equal(t, h.VNA.Device("dut1"), crq.Result)
+
+	// measuring it again once it is connected needs none of the others, and can be repeated
+	h.VNA.SetDevice("load", twoport.Ideal.Load)
+
+	for i := 0; i < 2; i++ {
+		var mc pocket.MeasureCal
+		assert.NoError(t, c.Do(`{"cmd":"measurecal","what":"load"}`, &mc))
+		assert.Equal(t, 11, len(mc.Result))
+	}
+
+	crq = pocket.CalibratedRangeQuery{}
+	assert.NoError(t, c.Do(`{"cmd":"crq","what":"dut2"}`, &crq))
+	equal(t, h.VNA.Device("dut2"), crq.Result)
+
+	var cs pocket.CalState
+	assert.NoError(t, c.Do(`{"cmd":"cs"}`, &cs))
+	assert.Equal(t, "calibrated", cs.State)
+	assert.Equal(t, "load", cs.Reason)
+}
+
+// BenchmarkPipeline times a calibrated measurement from end to end: the request over
+// the websocket stream, the sweep, the correction, and the response
+func BenchmarkPipeline(b *testing.B) {
+
+	h, err := New(context.Background())
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer h.Close()
+
+	c, err := h.Dial()
+
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer c.Close()
+
+	if err := c.Do(`{"cmd":"rc","range":{"start":1000000,"end":3000000000},"size":501}`, nil); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := c.Do(`{"cmd":"crq","what":"dut1","format":"db"}`, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package harness
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+)
+
+// Noise is added to the raw measurements of the synthetic VNA, so that averaging,
+// outlier rejection and the quality of a cal can be tested against something like
+// a real rig. It comes from a random source started from Seed, so that a test
+// gets the same measurements every time it is run. The zero value adds no noise.
+type Noise struct {
+	Seed int64
+	// Trace is the standard deviation of the real and imaginary parts of the
+	// noise added to each S-parameter, at each point
+	Trace float64
+	// Drift is the standard deviation of the change in the gain of the VNA from
+	// one sweep to the next, as a complex factor, as when the rig warms up
+	Drift float64
+	// Spurs is the chance of a spur at each point of a sweep, of magnitude Spur,
+	// at a random phase, which averaging spreads out but median or outlier rejection removes
+	Spurs float64
+	Spur  float64
+}
+
+// noisy is the state of the noise: its random source, and the gain the VNA has drifted to
+type noisy struct {
+	Noise
+	rnd  *rand.Rand
+	gain complex128
+}
+
+// newNoisy starts the noise n from its seed
+func newNoisy(n Noise) *noisy {
+	return &noisy{
+		Noise: n,
+		rnd:   rand.New(rand.NewSource(n.Seed)),
+		gain:  1,
+	}
+}
+
+// add returns s with the noise added at one point
+func (n *noisy) add(s twoport.S) twoport.S {
+
+	spur := complex128(0)
+
+	if n.Spurs > 0 && n.rnd.Float64() < n.Spurs {
+		spur = cmplx.Rect(n.Spur, 2*math.Pi*n.rnd.Float64())
+	}
+
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			s[i][j] = s[i][j]*n.gain + spur + n.normal(n.Trace)
+		}
+	}
+
+	return s
+}
+
+// sweep moves the gain on by one sweep's drift
+func (n *noisy) sweep() {
+	n.gain += n.normal(n.Drift)
+}
+
+// normal returns a complex number whose parts have standard deviation sd
+func (n *noisy) normal(sd float64) complex128 {
+
+	if sd == 0 {
+		return 0
+	}
+
+	return complex(n.rnd.NormFloat64()*sd, n.rnd.NormFloat64()*sd)
+}
@@ -0,0 +1,236 @@
+package harness
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+	"strings"
+	"sync"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/rfusb"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+)
+
+// VNA is a synthetic VNA, which measures the device at the current position of
+// the switch, as seen through the error terms. A calibration therefore gives back
+// the devices exactly, at every frequency, so results can be checked against them,
+// unless noise has been added with SetNoise.
+type VNA struct {
+	mu      sync.Mutex
+	sw      rfusb.Switch
+	terms   func(freq uint64) twoport.ErrorTerms
+	devices map[string]Model
+	noise   *noisy // nil if there is none
+	// Range is the reasonable frequency range reported to the middle
+	Range pocket.Range
+	// Power is the output power (dBm) last set, zero for the device default
+	Power float64
+	// Sweeps is the number of range queries measured so far
+	Sweeps int
+}
+
+// Attenuator is a matched 6 dB attenuator, the default DUT in dut1
+var Attenuator = twoport.S{{0, 0.5}, {0.5, 0}}
+
+// Mismatch is a reflective DUT, with a little transmission, the default in dut2
+var Mismatch = twoport.S{{0.3 + 0.2i, 0.1}, {0.1, -0.25i}}
+
+// Bandpass is a series RLC resonator at about 503 MHz, the default DUT in dut3
+var Bandpass = Resonator(5, 100e-9, 1e-12)
+
+// Filter is a 5th order low-pass filter with its cutoff at 1 GHz, the default DUT in dut4
+var Filter = LowPass(1e9, 5)
+
+// NewVNA returns a synthetic VNA that measures at the position of sw, with the
+// standards in short, open, load and thru, Attenuator in dut1, Mismatch in dut2,
+// Bandpass in dut3 and Filter in dut4
+func NewVNA(sw rfusb.Switch) *VNA {
+	return &VNA{
+		sw:    sw,
+		terms: Terms,
+		devices: map[string]Model{
+			"short": Fixed(twoport.Ideal.Short),
+			"open":  Fixed(twoport.Ideal.Open),
+			"load":  Fixed(twoport.Ideal.Load),
+			"thru":  Fixed(twoport.Ideal.Thru),
+			"dut1":  Fixed(Attenuator),
+			"dut2":  Fixed(Mismatch),
+			"dut3":  Bandpass,
+			"dut4":  Filter,
+		},
+		Range: pocket.Range{Start: 500e3, End: 4e9},
+	}
+}
+
+// Terms are error terms like those of a VNA with a short cable on each port, whose
+// phase changes with frequency, so that the calibration is different at each point
+func Terms(freq uint64) twoport.ErrorTerms {
+
+	// 1ns each way along the cables
+	p := cmplx.Exp(complex(0, -4*math.Pi*float64(freq)*1e-9))
+
+	return twoport.ErrorTerms{
+		Edf: 0.05, Esf: 0.1 + 0.02i, Erf: 0.9 * p, Etf: 0.8 * p, Elf: 0.08, Exf: 0.001,
+		Edr: 0.04i, Esr: 0.12, Err: 0.85 * p, Etr: 0.8 * p, Elr: 0.09 - 0.01i, Exr: 0.001,
+	}
+}
+
+// SetDevice puts a device with actual S-parameters s, at every frequency, at a position of the switch
+func (v *VNA) SetDevice(position string, s twoport.S) {
+	v.SetModel(position, Fixed(s))
+}
+
+// SetModel puts a device whose actual S-parameters are given by d at a position of the switch
+func (v *VNA) SetModel(position string, d Model) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.devices[strings.ToLower(position)] = d
+}
+
+// SetNoise adds noise n to the measurements from now on, starting again from its seed
+func (v *VNA) SetNoise(n Noise) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.noise = newNoisy(n)
+}
+
+// Device returns the model of the device at a position of the switch, nil if there is none
+func (v *VNA) Device(position string) Model {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.devices[strings.ToLower(position)]
+}
+
+// measure returns the raw measurement at freq of the device the switch is set to
+func (v *VNA) measure(freq uint64) (pocket.SParam, error) {
+
+	d, ok := v.devices[v.sw.Get()]
+
+	if !ok {
+		return pocket.SParam{}, errors.New("switch is not set to a device")
+	}
+
+	m := v.terms(freq).Measure(d(freq))
+
+	if v.noise != nil {
+		m = v.noise.add(m)
+	}
+
+	return m.SParam(freq), nil
+}
+
+func (v *VNA) Connect() (func() error, error) {
+	return func() error { return nil }, nil
+}
+
+func (v *VNA) GetReasonableFrequencyRange(command interface{}) error {
+
+	c := command.(*pocket.ReasonableFrequencyRange)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c.Result = v.Range
+
+	return nil
+}
+
+func (v *VNA) RangeQuery(command interface{}) error {
+
+	c := command.(*pocket.RangeQuery)
+
+	freq := c.Frequencies
+
+	if len(freq) == 0 {
+		freq = distribute(c.Range, c.Size, c.LogDistribution)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	c.Result = make([]pocket.SParam, len(freq))
+
+	for i, f := range freq {
+
+		p, err := v.measure(f)
+
+		if err != nil {
+			return err
+		}
+
+		c.Result[i] = p
+	}
+
+	v.Sweeps++
+
+	if v.noise != nil {
+		v.noise.sweep()
+	}
+
+	return nil
+}
+
+func (v *VNA) SingleQuery(command interface{}) error {
+
+	c := command.(*pocket.SingleQuery)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	p, err := v.measure(c.Freq)
+
+	if err != nil {
+		return err
+	}
+
+	c.Result = p
+
+	return nil
+}
+
+func (v *VNA) SetPower(command interface{}) error {
+
+	c := command.(*pocket.SetPower)
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	v.Power = c.Power
+
+	return nil
+}
+
+func (v *VNA) HandleCommand(command interface{}) error {
+
+	switch command.(type) {
+
+	case *pocket.ReasonableFrequencyRange:
+		return v.GetReasonableFrequencyRange(command)
+
+	case *pocket.RangeQuery:
+		return v.RangeQuery(command)
+
+	case *pocket.SingleQuery:
+		return v.SingleQuery(command)
+
+	case *pocket.SetPower:
+		return v.SetPower(command)
+	}
+
+	return errors.New("unknown command")
+}
+
+// distribute returns size frequencies across r, spaced as the pocket VNA spaces them
+func distribute(r pocket.Range, size int, islog bool) []uint64 {
+
+	if size < 2 {
+		return []uint64{r.Start}
+	}
+
+	if islog {
+		return pocket.LogFrequency(r.Start, r.End, size)
+	}
+
+	return pocket.LinFrequency(r.Start, r.End, size)
+}
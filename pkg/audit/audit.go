@@ -0,0 +1,264 @@
+// package audit keeps a record of who asked the rig to do what, and when, how
+// long it took and how it turned out, for tracking the use of a shared rig and
+// finding out what went wrong. It is separate from the debug log, and is only
+// ever appended to, one JSON entry per line, in a file, or in a store, with a
+// file for each day.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/store"
+)
+
+// Entry is one request in the log
+type Entry = pocket.AuditEntry
+
+// MaxParams is the largest request kept in the log, in bytes. The size of a larger
+// one is recorded instead, e.g. a batch or a fixture with many points.
+const MaxParams = 4096
+
+// DefaultLimit is the number of entries returned by Query if no limit is given
+const DefaultLimit = 100
+
+// MaxLimit is the most entries returned by Query
+const MaxLimit = 1000
+
+// Outcomes of a request
+const (
+	OK        = "ok"
+	Error     = "error"
+	Cancelled = "cancelled"
+)
+
+// Log is an audit log in a file, or a store. It is safe to use from more than one goroutine.
+type Log struct {
+	mu   sync.Mutex
+	path string
+	f    *os.File
+	// where the log is kept instead of a file, nil if it is in a file
+	store store.Store
+}
+
+// Dir is the prefix of the keys of the log in a store
+const Dir = "audit/"
+
+// Key is the key of the file in a store that an entry made at t is kept in
+func Key(t time.Time) string {
+	return Dir + t.UTC().Format("2006-01-02") + ".jsonl"
+}
+
+// Filter chooses entries from the log. The zero value of each field matches everything.
+type Filter struct {
+	Since    time.Time
+	Until    time.Time
+	Who      string   // session
+	Commands []string // cmd, as recorded
+	Limit    int      // most recent entries to return, DefaultLimit if zero
+}
+
+// Open opens the log at path for appending, creating it if there is none
+func Open(path string) (*Log, error) {
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Log{path: path, f: f}, nil
+}
+
+// OpenStore returns a log kept in s, under Dir, with a file for each day (UTC).
+// Closing the log does not close s.
+func OpenStore(s store.Store) *Log {
+	return &Log{store: s}
+}
+
+// Close closes the log
+func (l *Log) Close() error {
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.store != nil {
+		return nil
+	}
+
+	return l.f.Close()
+}
+
+// Params returns the JSON of request, to record as its parameters, or its size
+// in bytes if it is larger than MaxParams
+func Params(request interface{}) (json.RawMessage, int) {
+
+	b, err := json.Marshal(request)
+
+	if err != nil || len(b) > MaxParams {
+		return nil, len(b)
+	}
+
+	return b, 0
+}
+
+// Record appends e to the log
+func (l *Log) Record(e Entry) error {
+
+	b, err := json.Marshal(e)
+
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.store != nil {
+		return l.store.Append(Key(e.Time), append(b, '\n'))
+	}
+
+	// one write, so that an entry is never split by another
+	_, err = l.f.Write(append(b, '\n'))
+
+	return err
+}
+
+// Query returns the most recent entries that match f, up to its limit, oldest first
+func (l *Log) Query(f Filter) ([]Entry, error) {
+
+	limit := f.Limit
+
+	if limit == 0 {
+		limit = DefaultLimit
+	}
+
+	if limit < 0 || limit > MaxLimit {
+		return nil, fmt.Errorf("limit must be between 1 and %d, not %d", MaxLimit, limit)
+	}
+
+	if !f.Until.IsZero() && f.Until.Before(f.Since) {
+		return nil, errors.New("until must not be before since")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entries := []Entry{}
+
+	if l.store != nil {
+		return l.queryStore(f, limit, entries)
+	}
+
+	r, err := os.Open(l.path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer r.Close()
+
+	return scan(r, f, limit, entries)
+}
+
+// queryStore reads the files of the days that f covers, oldest first
+func (l *Log) queryStore(f Filter, limit int, entries []Entry) ([]Entry, error) {
+
+	keys, err := l.store.List(Dir)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, k := range keys {
+
+		// the keys sort by day, as they are named from the date
+		if !f.Since.IsZero() && k < Key(f.Since) {
+			continue
+		}
+
+		if !f.Until.IsZero() && k > Key(f.Until) {
+			continue
+		}
+
+		b, err := l.store.Get(k)
+
+		if err != nil {
+			return nil, err
+		}
+
+		entries, err = scan(bytes.NewReader(b), f, limit, entries)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return entries, nil
+}
+
+// scan adds the entries in r that match f to entries, keeping only the most recent limit
+func scan(r io.Reader, f Filter, limit int, entries []Entry) ([]Entry, error) {
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 2*MaxParams+64*1024)
+
+	for s.Scan() {
+
+		var e Entry
+
+		// skip anything that is not an entry, e.g. a line cut short when the disk was full
+		if json.Unmarshal(s.Bytes(), &e) != nil {
+			continue
+		}
+
+		if !f.match(e) {
+			continue
+		}
+
+		entries = append(entries, e)
+
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+
+	return entries, s.Err()
+}
+
+// match returns whether e passes every part of the filter
+func (f Filter) match(e Entry) bool {
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+
+	if f.Who != "" && e.Session != f.Who {
+		return false
+	}
+
+	if len(f.Commands) == 0 {
+		return true
+	}
+
+	for _, c := range f.Commands {
+		if strings.EqualFold(c, e.Cmd) {
+			return true
+		}
+	}
+
+	return false
+}
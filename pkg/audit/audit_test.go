@@ -0,0 +1,134 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	l, err := Open(path)
+	assert.NoError(t, err)
+
+	t0 := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	params, omitted := Params(pocket.RangeQuery{Command: pocket.Command{Command: "rc", Session: "alice"}, Size: 2})
+	assert.Equal(t, 0, omitted)
+	assert.Contains(t, string(params), `"size":2`)
+
+	assert.NoError(t, l.Record(Entry{Time: t0, Source: "stream", Session: "alice", Cmd: "rc", Params: params, Outcome: OK}))
+	assert.NoError(t, l.Record(Entry{Time: t0.Add(time.Minute), Source: "stream", Session: "bob", Cmd: "crq", Outcome: Error, Error: "not calibrated yet"}))
+	assert.NoError(t, l.Record(Entry{Time: t0.Add(2 * time.Minute), Source: "grpc", Cmd: "crq", Outcome: OK}))
+	assert.NoError(t, l.Close())
+
+	// appended to, not replaced, when opened again
+	l, err = Open(path)
+	assert.NoError(t, err)
+	defer l.Close()
+
+	assert.NoError(t, l.Record(Entry{Time: t0.Add(3 * time.Minute), Source: "stream", Session: "alice", Cmd: "flushqueue", Outcome: OK}))
+
+	e, err := l.Query(Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(e))
+	assert.Equal(t, "rc", e[0].Cmd)
+	assert.True(t, t0.Equal(e[0].Time))
+
+	e, err = l.Query(Filter{Who: "alice"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(e))
+
+	e, err = l.Query(Filter{Commands: []string{"CRQ"}, Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(e))
+	assert.Equal(t, "grpc", e[0].Source)
+
+	e, err = l.Query(Filter{Since: t0.Add(30 * time.Second), Until: t0.Add(2 * time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(e))
+
+	_, err = l.Query(Filter{Limit: MaxLimit + 1})
+	assert.Error(t, err)
+
+	_, err = l.Query(Filter{Since: t0, Until: t0.Add(-time.Second)})
+	assert.Error(t, err)
+
+	// a line cut short is skipped
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.NoError(t, err)
+	_, err = f.WriteString(`{"time":"2023-10-01T12:`)
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+
+	e, err = l.Query(Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(e))
+}
+
+func TestStore(t *testing.T) {
+
+	s, err := store.NewDir(t.TempDir())
+	assert.NoError(t, err)
+
+	l := OpenStore(s)
+
+	t0 := time.Date(2023, 10, 1, 23, 59, 0, 0, time.UTC)
+
+	assert.NoError(t, l.Record(Entry{Time: t0, Source: "stream", Session: "alice", Cmd: "rc", Outcome: OK}))
+	assert.NoError(t, l.Record(Entry{Time: t0.Add(2 * time.Minute), Source: "stream", Session: "bob", Cmd: "crq", Outcome: OK}))
+	assert.NoError(t, l.Record(Entry{Time: t0.Add(24 * time.Hour), Source: "grpc", Cmd: "crq", Outcome: OK}))
+	assert.NoError(t, l.Close())
+
+	// a file for each day
+	keys, err := s.List(Dir)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"audit/2023-10-01.jsonl", "audit/2023-10-02.jsonl"}, keys)
+
+	e, err := l.Query(Filter{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(e))
+	assert.Equal(t, "rc", e[0].Cmd)
+	assert.Equal(t, "grpc", e[2].Source)
+
+	e, err = l.Query(Filter{Until: t0.Add(time.Hour)})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(e))
+	assert.Equal(t, "bob", e[1].Session)
+
+	// a line cut short is skipped, as in a file
+	b, err := s.Get("audit/2023-10-01.jsonl")
+	assert.NoError(t, err)
+	assert.NoError(t, s.Put("audit/2023-10-01.jsonl", append(b, []byte("not json\n")...)))
+
+	e, err = l.Query(Filter{Since: t0.Add(time.Minute)})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(e))
+	assert.Equal(t, "bob", e[0].Session)
+
+	e, err = l.Query(Filter{Limit: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(e))
+	assert.Equal(t, "grpc", e[0].Source)
+}
+
+func TestParams(t *testing.T) {
+
+	big := pocket.RangeQuery{Frequencies: make([]uint64, MaxParams)}
+
+	params, omitted := Params(big)
+	assert.Nil(t, params)
+	assert.True(t, omitted > MaxParams)
+
+	params, omitted = Params(pocket.Command{Command: "hq"})
+	assert.Equal(t, 0, omitted)
+	assert.True(t, strings.HasPrefix(string(params), "{"))
+}
@@ -0,0 +1,331 @@
+// package average combines repeated sweeps into one, with optional rejection
+// of outliers at each point, and reports the spread of the sweeps, including
+// the trace noise in magnitude and phase
+package average
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"sort"
+	"strings"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+const (
+	None    = "none"    // complex mean of all sweeps
+	Median  = "median"  // median of the real and imaginary parts separately
+	Outlier = "outlier" // complex mean, after dropping sweeps that are further than Threshold standard deviations from the mean
+)
+
+// Threshold is the number of standard deviations from the mean beyond which
+// a sweep is treated as an outlier at that point
+const Threshold = 3.0
+
+// MaxSweeps limits the time spent on one request
+const MaxSweeps = 100
+
+// Check returns an error if the number of sweeps or the rejection method is not valid
+func Check(sweeps int, reject string) error {
+
+	if sweeps < 0 || sweeps > MaxSweeps {
+		return fmt.Errorf("sweeps must be between 1 and %d, not %d", MaxSweeps, sweeps)
+	}
+
+	switch strings.ToLower(reject) {
+	case "", None, Median, Outlier:
+		return nil
+	}
+
+	return fmt.Errorf("unknown reject %s, use none, median or outlier", reject)
+}
+
+// Combine returns the average of sweeps at each point, using the rejection
+// method reject, along with the standard deviation of all the sweeps (the rms
+// distance from their complex mean) at each point
+func Combine(sweeps [][]pocket.SParam, reject string) ([]pocket.SParam, []pocket.Deviation, error) {
+
+	err := Check(len(sweeps), reject)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(sweeps) == 0 {
+		return nil, nil, errors.New("no sweeps to combine")
+	}
+
+	n := len(sweeps[0])
+
+	for _, s := range sweeps {
+		if len(s) != n {
+			return nil, nil, errors.New("sweeps do not have the same number of points")
+		}
+	}
+
+	reject = strings.ToLower(reject)
+
+	result := make([]pocket.SParam, n)
+	deviation := make([]pocket.Deviation, n)
+
+	get := []func(*pocket.SParam) *pocket.Complex{
+		func(v *pocket.SParam) *pocket.Complex { return &v.S11 },
+		func(v *pocket.SParam) *pocket.Complex { return &v.S12 },
+		func(v *pocket.SParam) *pocket.Complex { return &v.S21 },
+		func(v *pocket.SParam) *pocket.Complex { return &v.S22 },
+	}
+
+	x := make([]complex128, len(sweeps))
+
+	for i := 0; i < n; i++ {
+
+		freq := sweeps[0][i].Freq
+
+		result[i].Freq = freq
+		deviation[i].Freq = freq
+
+		sd := [4]*float64{&deviation[i].S11, &deviation[i].S12, &deviation[i].S21, &deviation[i].S22}
+
+		for j, g := range get {
+
+			for k := range sweeps {
+				c := g(&sweeps[k][i])
+				x[k] = complex(c.Real, c.Imag)
+			}
+
+			m, s := meanStd(x)
+
+			*sd[j] = s
+
+			switch reject {
+			case Median:
+				m = median(x)
+			case Outlier:
+				m = withoutOutliers(x, m, s)
+			}
+
+			*g(&result[i]) = pocket.Complex{Real: real(m), Imag: imag(m)}
+		}
+	}
+
+	return result, deviation, nil
+}
+
+// Floor is the lowest magnitude reported, dB, so that zero readings stay finite
+const Floor = -200.0
+
+// Noise returns the mean magnitude and the standard deviation of the
+// magnitude (dB) and phase (degrees) of the sweeps, at each point
+func Noise(sweeps [][]pocket.SParam) ([]pocket.Noise, error) {
+
+	if len(sweeps) < 2 {
+		return nil, fmt.Errorf("need at least two sweeps to find the noise, not %d", len(sweeps))
+	}
+
+	n := len(sweeps[0])
+
+	for _, s := range sweeps {
+		if len(s) != n {
+			return nil, errors.New("sweeps do not have the same number of points")
+		}
+	}
+
+	get := []func(*pocket.SParam) pocket.Complex{
+		func(v *pocket.SParam) pocket.Complex { return v.S11 },
+		func(v *pocket.SParam) pocket.Complex { return v.S12 },
+		func(v *pocket.SParam) pocket.Complex { return v.S21 },
+		func(v *pocket.SParam) pocket.Complex { return v.S22 },
+	}
+
+	result := make([]pocket.Noise, n)
+
+	x := make([]complex128, len(sweeps))
+
+	for i := 0; i < n; i++ {
+
+		result[i].Freq = sweeps[0][i].Freq
+
+		spread := [4]*pocket.Spread{&result[i].S11, &result[i].S12, &result[i].S21, &result[i].S22}
+
+		for j, g := range get {
+
+			for k := range sweeps {
+				c := g(&sweeps[k][i])
+				x[k] = complex(c.Real, c.Imag)
+			}
+
+			*spread[j] = spreadOf(x)
+		}
+	}
+
+	return result, nil
+}
+
+// Adapt returns how many readings to average at each point so that the trace noise
+// is no more than target, given the spread dev of sweeps made at an average of one,
+// along with that noise, which is the rms over the points of the S-parameters in sel
+// (all of them if none are selected). The average is at least one, and no more than
+// max, in which case limited is true.
+func Adapt(dev []pocket.Deviation, sel pocket.SParamSelect, target float64, max int) (avg int, noise float64, limited bool, err error) {
+
+	if target <= 0 {
+		return 0, 0, false, fmt.Errorf("target must be positive, not %g", target)
+	}
+
+	if len(dev) == 0 {
+		return 0, 0, false, errors.New("no spread to find the noise from")
+	}
+
+	if sel == (pocket.SParamSelect{}) {
+		sel = pocket.SParamSelect{S11: true, S12: true, S21: true, S22: true}
+	}
+
+	ss, n := 0.0, 0
+
+	for _, d := range dev {
+		for _, v := range []struct {
+			use bool
+			sd  float64
+		}{{sel.S11, d.S11}, {sel.S12, d.S12}, {sel.S21, d.S21}, {sel.S22, d.S22}} {
+			if v.use {
+				ss += v.sd * v.sd
+				n++
+			}
+		}
+	}
+
+	noise = math.Sqrt(ss / float64(n))
+
+	// averaging n readings divides the noise by the square root of n
+	a := math.Ceil((noise / target) * (noise / target))
+
+	if a < 1 {
+		a = 1
+	}
+
+	if a > float64(max) {
+		return max, noise, true, nil
+	}
+
+	return int(a), noise, false, nil
+}
+
+func spreadOf(x []complex128) pocket.Spread {
+
+	n := float64(len(x))
+
+	db := make([]float64, len(x))
+
+	// mean direction, so that phases either side of +/-180 degrees are close together
+	var dir complex128
+
+	for i, v := range x {
+		db[i] = math.Max(Floor, 20*math.Log10(cmplx.Abs(v)))
+		if v != 0 {
+			dir += v / complex(cmplx.Abs(v), 0)
+		}
+	}
+
+	mean := 0.0
+
+	for _, v := range db {
+		mean += v
+	}
+
+	mean /= n
+
+	ref := cmplx.Phase(dir)
+
+	sm, sp := 0.0, 0.0
+
+	for i, v := range x {
+
+		sm += (db[i] - mean) * (db[i] - mean)
+
+		d := math.Remainder(cmplx.Phase(v)-ref, 2*math.Pi)
+		sp += d * d
+	}
+
+	return pocket.Spread{
+		Mean:  mean,
+		Mag:   math.Sqrt(sm / (n - 1)),
+		Phase: math.Sqrt(sp/(n-1)) * 180 / math.Pi,
+	}
+}
+
+func meanStd(x []complex128) (complex128, float64) {
+
+	var m complex128
+
+	for _, v := range x {
+		m += v
+	}
+
+	m /= complex(float64(len(x)), 0)
+
+	if len(x) < 2 {
+		return m, 0
+	}
+
+	ss := 0.0
+
+	for _, v := range x {
+		d := cmplx.Abs(v - m)
+		ss += d * d
+	}
+
+	return m, math.Sqrt(ss / float64(len(x)-1))
+}
+
+func median(x []complex128) complex128 {
+
+	re := make([]float64, len(x))
+	im := make([]float64, len(x))
+
+	for i, v := range x {
+		re[i] = real(v)
+		im[i] = imag(v)
+	}
+
+	return complex(middle(re), middle(im))
+}
+
+func middle(v []float64) float64 {
+
+	sort.Float64s(v)
+
+	n := len(v)
+
+	if n%2 == 1 {
+		return v[n/2]
+	}
+
+	return (v[n/2-1] + v[n/2]) / 2
+}
+
+// withoutOutliers returns the mean of the values within Threshold standard
+// deviations of mean m, or m if there is nothing to drop
+func withoutOutliers(x []complex128, m complex128, s float64) complex128 {
+
+	if s == 0 {
+		return m
+	}
+
+	var keep []complex128
+
+	for _, v := range x {
+		if cmplx.Abs(v-m) <= Threshold*s {
+			keep = append(keep, v)
+		}
+	}
+
+	if len(keep) == 0 || len(keep) == len(x) {
+		return m
+	}
+
+	km, _ := meanStd(keep)
+
+	return km
+}
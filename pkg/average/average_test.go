@@ -0,0 +1,149 @@
+package average
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func sweep(s11 ...float64) []pocket.SParam {
+	var s []pocket.SParam
+	for i, v := range s11 {
+		s = append(s, pocket.SParam{Freq: uint64(100 * (i + 1)), S11: pocket.Complex{Real: v, Imag: -v}})
+	}
+	return s
+}
+
+func TestCheck(t *testing.T) {
+
+	for _, r := range []string{"", "none", "Median", "outlier"} {
+		assert.NoError(t, Check(1, r), r)
+	}
+
+	assert.Error(t, Check(1, "mode"))
+	assert.Error(t, Check(-1, ""))
+	assert.Error(t, Check(MaxSweeps+1, ""))
+}
+
+func TestCombine(t *testing.T) {
+
+	sweeps := [][]pocket.SParam{
+		sweep(0.1, 1),
+		sweep(0.2, 1),
+		sweep(0.3, 1),
+		sweep(0.2, 1),
+		sweep(0.2, 1),
+		sweep(0.2, 1),
+		sweep(0.2, 1),
+		sweep(0.2, 1),
+		sweep(0.2, 1),
+		sweep(0.2, 1),
+		sweep(0.2, 1),
+		sweep(0.2, 1),
+		sweep(3.0, 1), // glitch
+	}
+
+	r, d, err := Combine(sweeps, "none")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(r))
+	assert.Equal(t, uint64(200), r[1].Freq)
+	assert.InDelta(t, 5.4/13, r[0].S11.Real, 1e-9)
+	assert.InDelta(t, -5.4/13, r[0].S11.Imag, 1e-9)
+	assert.InDelta(t, 1, r[1].S11.Real, 1e-9)
+	assert.InDelta(t, 0, d[1].S11, 1e-9)
+	assert.True(t, d[0].S11 > 0.5)
+	assert.Equal(t, 0.0, d[0].S21)
+
+	r, _, err = Combine(sweeps, "median")
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.2, r[0].S11.Real, 1e-9)
+
+	r, _, err = Combine(sweeps, "outlier")
+	assert.NoError(t, err)
+	assert.InDelta(t, 2.4/12, r[0].S11.Real, 1e-9)
+
+	// even number of sweeps
+	r, _, err = Combine(sweeps[:4], "median")
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.2, r[0].S11.Real, 1e-9)
+
+	// one sweep has no spread
+	r, d, err = Combine(sweeps[:1], "")
+	assert.NoError(t, err)
+	assert.Equal(t, 0.1, r[0].S11.Real)
+	assert.False(t, math.IsNaN(d[0].S11))
+	assert.Equal(t, 0.0, d[0].S11)
+
+	_, _, err = Combine(nil, "")
+	assert.Error(t, err)
+
+	_, _, err = Combine([][]pocket.SParam{sweep(1, 2), sweep(1)}, "")
+	assert.Error(t, err)
+}
+
+func TestNoise(t *testing.T) {
+
+	// phases either side of 180 degrees
+	a := cmplx.Rect(0.1, math.Pi-0.01)
+	b := cmplx.Rect(0.1, -math.Pi+0.01)
+
+	var sweeps [][]pocket.SParam
+
+	for _, v := range []complex128{a, b, a, b} {
+		sweeps = append(sweeps, []pocket.SParam{{
+			Freq: 100,
+			S11:  pocket.Complex{Real: real(v), Imag: imag(v)},
+		}})
+	}
+
+	n, err := Noise(sweeps)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(n))
+	assert.Equal(t, uint64(100), n[0].Freq)
+	assert.InDelta(t, -20, n[0].S11.Mean, 1e-9)
+	assert.InDelta(t, 0, n[0].S11.Mag, 1e-9)
+	assert.InDelta(t, math.Sqrt(4.0/3)*0.01*180/math.Pi, n[0].S11.Phase, 1e-6)
+
+	// zero readings stay finite
+	assert.Equal(t, Floor, n[0].S21.Mean)
+	assert.Equal(t, 0.0, n[0].S21.Phase)
+
+	_, err = Noise(sweeps[:1])
+	assert.Error(t, err)
+
+	_, err = Noise([][]pocket.SParam{sweep(1, 2), sweep(1)})
+	assert.Error(t, err)
+}
+
+func TestAdapt(t *testing.T) {
+
+	dev := []pocket.Deviation{{S11: 0.01, S21: 0.03}, {S11: 0.01, S21: 0.01}}
+
+	// only s11 is used, with a noise of 0.01, so a quarter of that needs 16 readings
+	a, noise, limited, err := Adapt(dev, pocket.SParamSelect{S11: true}, 0.0025, 1000)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.01, noise, 1e-12)
+	assert.Equal(t, 16, a)
+	assert.False(t, limited)
+
+	// quiet enough already
+	a, _, _, err = Adapt(dev, pocket.SParamSelect{S11: true}, 0.1, 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, a)
+
+	// every s-parameter if none are selected, and no more than max
+	a, noise, limited, err = Adapt(dev, pocket.SParamSelect{}, 0.0001, 500)
+	assert.NoError(t, err)
+	assert.InDelta(t, math.Sqrt((0.0001+0.0009+0.0001+0.0001)/8), noise, 1e-12)
+	assert.Equal(t, 500, a)
+	assert.True(t, limited)
+
+	_, _, _, err = Adapt(dev, pocket.SParamSelect{}, 0, 500)
+	assert.Error(t, err)
+
+	_, _, _, err = Adapt(nil, pocket.SParamSelect{}, 0.1, 500)
+	assert.Error(t, err)
+}
@@ -3,10 +3,13 @@ package calibrate
 import (
 	"context"
 	"log"
+	"math/cmplx"
 	"testing"
 	"time"
 
 	pb "github.com/practable/pocket-vna-two-port/pkg/pb"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+	"github.com/stretchr/testify/assert"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -61,3 +64,64 @@ func TestRequest(t *testing.T) {
 	_ = r.GetResult()
 
 }
+
+func TestEmulator(t *testing.T) {
+
+	e := twoport.ErrorTerms{
+		Edf: 0.05 + 0.02i, Esf: 0.1 - 0.05i, Erf: 0.9 + 0.1i, Etf: 0.85 - 0.2i, Elf: 0.08 + 0.03i, Exf: 0.001i,
+		Edr: 0.04 - 0.01i, Esr: -0.07 + 0.06i, Err: 0.88 - 0.15i, Etr: 0.8 + 0.25i, Elr: 0.06 - 0.02i, Exr: 0.002,
+	}
+
+	dut := twoport.S{{0.1 + 0.2i, 0.7 - 0.1i}, {0.8 - 0.2i, -0.3 + 0.1i}}
+
+	sp := func(s twoport.S) *pb.SParams {
+		return &pb.SParams{
+			S11: []*pb.Complex{toPB(s[0][0]), toPB(s[0][0])},
+			S12: []*pb.Complex{toPB(s[0][1]), toPB(s[0][1])},
+			S21: []*pb.Complex{toPB(s[1][0]), toPB(s[1][0])},
+			S22: []*pb.Complex{toPB(s[1][1]), toPB(s[1][1])},
+		}
+	}
+
+	req := &pb.CalibrateTwoPortRequest{
+		Frequency: []float64{1e9, 2e9},
+		Short:     sp(e.Measure(twoport.Ideal.Short)),
+		Open:      sp(e.Measure(twoport.Ideal.Open)),
+		Load:      sp(e.Measure(twoport.Ideal.Load)),
+		Thru:      sp(e.Measure(twoport.Ideal.Thru)),
+		Dut:       sp(e.Measure(dut)),
+	}
+
+	r, err := (&Emulator{}).CalibrateTwoPort(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, req.Frequency, r.GetFrequency())
+
+	for i := 0; i < 2; i++ {
+		assert.InDelta(t, 0, cmplx.Abs(fromPB(r.Result.S21[i])-dut[1][0]), 1e-9)
+		assert.InDelta(t, 0, cmplx.Abs(fromPB(r.Result.S11[i])-dut[0][0]), 1e-9)
+		assert.InDelta(t, 0, cmplx.Abs(fromPB(r.ErrorTerms.ReverseLoadMatch[i])-e.Elr), 1e-9)
+	}
+
+	// a cal kit that says the thru is twice as long gives a different result
+	req.IdealThru = sp(twoport.S{{0, -1}, {-1, 0}})
+
+	r, err = (&Emulator{}).CalibrateTwoPort(context.Background(), req)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0, cmplx.Abs(fromPB(r.Result.S21[0])+dut[1][0]), 1e-9)
+
+	req.Dut.S11 = req.Dut.S11[:1]
+	_, err = (&Emulator{}).CalibrateTwoPort(context.Background(), req)
+	assert.Error(t, err)
+
+	one := &pb.CalibrateOnePortRequest{
+		Frequency: []float64{1e9},
+		Short:     []*pb.Complex{toPB(e.Measure(twoport.Ideal.Short)[0][0])},
+		Open:      []*pb.Complex{toPB(e.Measure(twoport.Ideal.Open)[0][0])},
+		Load:      []*pb.Complex{toPB(e.Measure(twoport.Ideal.Load)[0][0])},
+		Dut:       []*pb.Complex{toPB(e.Measure(twoport.S{{0.3 - 0.4i, 0}, {0, 0}})[0][0])},
+	}
+
+	r1, err := (&Emulator{}).CalibrateOnePort(context.Background(), one)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0, cmplx.Abs(fromPB(r1.Result[0])-(0.3-0.4i)), 1e-9)
+}
@@ -0,0 +1,163 @@
+package calibrate
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	"github.com/practable/pocket-vna-two-port/pkg/calibration"
+	pb "github.com/practable/pocket-vna-two-port/pkg/pb"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Emulator is a built-in stand-in for the python calibration service (py/server.py),
+// so that the daemon can be run end to end without scikit-rf, e.g. for demos and CI.
+// It uses calibration.Native, a plain SOLT calibration with ideal standards unless
+// the request gives their actual S-parameters, so its results are close to, but
+// not the same as, those of the python service.
+type Emulator struct {
+	pb.UnimplementedCalibrateServer
+}
+
+// Serve serves the emulated calibration service on addr (host:port) until ctx is done
+func Serve(ctx context.Context, addr string) error {
+
+	l, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return err
+	}
+
+	s := grpc.NewServer()
+
+	pb.RegisterCalibrateServer(s, &Emulator{})
+
+	go func() {
+		<-ctx.Done()
+		s.Stop()
+	}()
+
+	log.WithField("addr", addr).Infof("serving emulated calibration service")
+
+	return s.Serve(l)
+}
+
+// CalibrateOnePort corrects the reflection of the dut using the short, open and load
+func (e *Emulator) CalibrateOnePort(ctx context.Context, req *pb.CalibrateOnePortRequest) (*pb.CalibrateOnePortResponse, error) {
+
+	n := len(req.GetFrequency())
+
+	for _, c := range [][]*pb.Complex{req.GetShort(), req.GetOpen(), req.GetLoad(), req.GetDut()} {
+		if len(c) != n {
+			return nil, status.Error(codes.InvalidArgument, "array lengths do not match frequency")
+		}
+	}
+
+	result := make([]*pb.Complex, n)
+
+	for i := 0; i < n; i++ {
+
+		ed, es, er, err := twoport.OnePort(
+			[3]complex128{fromPB(req.Short[i]), fromPB(req.Open[i]), fromPB(req.Load[i])},
+			[3]complex128{-1, 1, 0},
+		)
+
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("cannot calibrate at %g Hz because %s", req.Frequency[i], err.Error()))
+		}
+
+		d := fromPB(req.Dut[i]) - ed
+
+		g := d / (er + es*d)
+
+		result[i] = toPB(g)
+	}
+
+	return &pb.CalibrateOnePortResponse{
+		Frequency: req.GetFrequency(),
+		Result:    result,
+	}, nil
+}
+
+// CalibrateTwoPort finds the twelve error terms from the standards, and uses them to correct the dut
+func (e *Emulator) CalibrateTwoPort(ctx context.Context, req *pb.CalibrateTwoPortRequest) (*pb.CalibrateTwoPortResponse, error) {
+
+	f := req.GetFrequency()
+	n := len(f)
+
+	for _, sp := range []*pb.SParams{req.GetShort(), req.GetOpen(), req.GetLoad(), req.GetThru(), req.GetDut()} {
+		if !fits(sp, n) {
+			return nil, status.Error(codes.InvalidArgument, "array lengths do not match frequency")
+		}
+	}
+
+	std := &calibration.Standards{
+		Short: calibration.Cal2Meas(f, req.Short),
+		Open:  calibration.Cal2Meas(f, req.Open),
+		Load:  calibration.Cal2Meas(f, req.Load),
+		Thru:  calibration.Cal2Meas(f, req.Thru),
+	}
+
+	// replace the ideal standards with their actual response, if a cal kit was supplied
+	ideals := []struct {
+		name string
+		sp   *pb.SParams
+		s    *[]pocket.SParam
+	}{
+		{"ideal_short", req.GetIdealShort(), &std.IdealShort},
+		{"ideal_open", req.GetIdealOpen(), &std.IdealOpen},
+		{"ideal_load", req.GetIdealLoad(), &std.IdealLoad},
+		{"ideal_thru", req.GetIdealThru(), &std.IdealThru},
+	}
+
+	for _, d := range ideals {
+
+		if d.sp == nil {
+			continue
+		}
+
+		if !fits(d.sp, n) {
+			return nil, status.Error(codes.InvalidArgument, d.name+" array lengths do not match frequency")
+		}
+
+		*d.s = calibration.Cal2Meas(f, d.sp)
+	}
+
+	freq := make([]uint64, n)
+
+	for i, v := range f {
+		freq[i] = uint64(v)
+	}
+
+	r, err := calibration.Native{}.Calibrate(ctx, freq, std, calibration.Cal2Meas(f, req.Dut))
+
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	log.WithField("size", n).Debug("emulated two port calibration")
+
+	return &pb.CalibrateTwoPortResponse{
+		Frequency:  f,
+		Result:     calibration.Meas2Cal(r.DUT),
+		ErrorTerms: calibration.Terms2Cal(r.Terms),
+	}, nil
+}
+
+// fits returns whether sp has n values of each parameter
+func fits(sp *pb.SParams, n int) bool {
+	return len(sp.GetS11()) == n && len(sp.GetS12()) == n && len(sp.GetS21()) == n && len(sp.GetS22()) == n
+}
+
+func fromPB(c *pb.Complex) complex128 {
+	return complex(c.GetReal(), c.GetImag())
+}
+
+func toPB(c complex128) *pb.Complex {
+	return &pb.Complex{Real: real(c), Imag: imag(c)}
+}
@@ -0,0 +1,247 @@
+// package calibration defines how a two-port calibration is done, so that the
+// middleware does not depend on which backend does it. The usual backend is the
+// python scikit-rf service over gRPC (see GRPC), but it could equally be native
+// Go (see Native), a REST service, or python embedded in the daemon.
+package calibration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+)
+
+// Backend calibrates the raw measurement of a dut, using the raw measurements of
+// the standards at the same frequencies, freq. The same *Standards is passed for
+// every dut measured with one calibration, so a backend may keep anything it
+// works out from them until it is given a different one.
+type Backend interface {
+	Calibrate(ctx context.Context, freq []uint64, std *Standards, dut []pocket.SParam) (Result, error)
+}
+
+// ErrUnavailable is returned, wrapped, by a backend that cannot be reached, as
+// opposed to one that could not calibrate with the measurements it was given
+var ErrUnavailable = errors.New("calibration service is unavailable")
+
+// Standards are the raw measurements of the cal standards, with the switch terms
+// already removed. The short, open and load are on both ports at once.
+type Standards struct {
+	Short []pocket.SParam
+	Open  []pocket.SParam
+	Load  []pocket.SParam
+	Thru  []pocket.SParam
+
+	// actual S-parameters of the standards, from a cal kit, or nil if they are ideal
+	IdealShort []pocket.SParam
+	IdealOpen  []pocket.SParam
+	IdealLoad  []pocket.SParam
+	IdealThru  []pocket.SParam
+}
+
+// Result is the calibrated dut, and the error terms at each frequency, which are
+// nil if the backend does not give them
+type Result struct {
+	DUT   []pocket.SParam
+	Terms []twoport.ErrorTerms
+}
+
+// Freq lists the frequencies of s
+func Freq(s []pocket.SParam) []uint64 {
+
+	freq := make([]uint64, len(s))
+
+	for i, v := range s {
+		freq[i] = v.Freq
+	}
+
+	return freq
+}
+
+// Native is a backend that does a plain SOLT calibration in Go, with no service
+// needed. Its results are close to, but not the same as, those of scikit-rf.
+type Native struct{}
+
+// Calibrate finds the error terms at each frequency, and removes them from the dut
+func (Native) Calibrate(ctx context.Context, freq []uint64, std *Standards, dut []pocket.SParam) (Result, error) {
+
+	n := len(freq)
+
+	for _, s := range [][]pocket.SParam{std.Short, std.Open, std.Load, std.Thru, dut} {
+		if len(s) != n {
+			return Result{}, fmt.Errorf("measurements have %d points but there are %d frequencies", len(s), n)
+		}
+	}
+
+	ideals := [][]pocket.SParam{std.IdealShort, std.IdealOpen, std.IdealLoad, std.IdealThru}
+
+	for _, s := range ideals {
+		if s != nil && len(s) != n {
+			return Result{}, fmt.Errorf("cal kit has %d points but there are %d frequencies", len(s), n)
+		}
+	}
+
+	r := Result{
+		DUT:   make([]pocket.SParam, n),
+		Terms: make([]twoport.ErrorTerms, n),
+	}
+
+	for i, f := range freq {
+
+		if err := ctx.Err(); err != nil {
+			return Result{}, err
+		}
+
+		m := twoport.Standards{
+			Short: twoport.FromSParam(std.Short[i]),
+			Open:  twoport.FromSParam(std.Open[i]),
+			Load:  twoport.FromSParam(std.Load[i]),
+			Thru:  twoport.FromSParam(std.Thru[i]),
+		}
+
+		terms, err := twoport.Solve(m, std.actual(i))
+
+		if err != nil {
+			return Result{}, fmt.Errorf("cannot calibrate at %d Hz because %s", f, err.Error())
+		}
+
+		r.Terms[i] = terms
+		r.DUT[i] = terms.Correct(twoport.FromSParam(dut[i])).SParam(f)
+	}
+
+	return r, nil
+}
+
+// actual returns the actual S-parameters of the standards at point i, from the
+// cal kit where given, or else ideal. Call after checking the lengths.
+func (s *Standards) actual(i int) twoport.Standards {
+
+	a := twoport.Ideal
+
+	ideals := [][]pocket.SParam{s.IdealShort, s.IdealOpen, s.IdealLoad, s.IdealThru}
+
+	for j, p := range []*twoport.S{&a.Short, &a.Open, &a.Load, &a.Thru} {
+		if ideals[j] != nil {
+			*p = twoport.FromSParam(ideals[j][i])
+		}
+	}
+
+	return a
+}
+
+// Similar is how close two of the short, open and load may look, compared to the
+// others, before one is taken to be missing or the wrong standard. 0.25 is 12 dB.
+const Similar = 0.25
+
+// ThruLoss is how much weaker the thru may be than the reflections, before it is
+// taken to be missing. 0.1 is 20 dB.
+const ThruLoss = 0.1
+
+// Verify checks that the raw measurements of the standards look like the standards
+// they should be, e.g. that a cable has not come off, leaving an open in place of
+// the load, and returns an error naming the one that looks wrong. Since the error
+// terms are not known yet, the standards are only compared with each other: the
+// differences between the reflections on each port, each scaled by the difference
+// expected from the actual standards, should be much the same size (the tracking).
+// A short and open that are swapped cannot be told apart from the reflections alone,
+// so are not found. The thru should
+// be no more than ThruLoss below the tracking of the reflections. Each check must
+// fail at more than half the frequencies to be reported. Points at which every
+// reflection is the same, so nothing can be told, are skipped.
+func (s *Standards) Verify() error {
+
+	n := len(s.Short)
+
+	for _, v := range [][]pocket.SParam{s.Open, s.Load, s.Thru} {
+		if len(v) != n {
+			return fmt.Errorf("standards have %d, %d, %d and %d points, but should all be the same", len(s.Short), len(s.Open), len(s.Load), len(s.Thru))
+		}
+	}
+
+	for _, v := range [][]pocket.SParam{s.IdealShort, s.IdealOpen, s.IdealLoad, s.IdealThru} {
+		if v != nil && len(v) != n {
+			return fmt.Errorf("cal kit has %d points but there are %d frequencies", len(v), n)
+		}
+	}
+
+	problems := []string{
+		"the load on port %d looks the same as the open, is it connected?",
+		"the short on port %d looks the same as the open, is it connected?",
+		"the short on port %d looks the same as the load",
+		"the thru looks like it is not connected",
+	}
+
+	// how many points each problem is seen at, on each port
+	var count [2][4]int
+
+	for i := 0; i < n; i++ {
+
+		m := twoport.Standards{
+			Short: twoport.FromSParam(s.Short[i]),
+			Open:  twoport.FromSParam(s.Open[i]),
+			Load:  twoport.FromSParam(s.Load[i]),
+			Thru:  twoport.FromSParam(s.Thru[i]),
+		}
+
+		a := s.actual(i)
+
+		tracking := 0.0
+		ports := 0
+
+		for p := 0; p < 2; p++ {
+
+			ms, mo, ml := m.Short[p][p], m.Open[p][p], m.Load[p][p]
+			as, ao, al := a.Short[p][p], a.Open[p][p], a.Load[p][p]
+
+			if ms == mo && mo == ml {
+				continue
+			}
+
+			so := cmplx.Abs(ms-mo) / cmplx.Abs(as-ao)
+			sl := cmplx.Abs(ms-ml) / cmplx.Abs(as-al)
+			ol := cmplx.Abs(mo-ml) / cmplx.Abs(ao-al)
+
+			largest := math.Max(so, math.Max(sl, ol))
+
+			switch {
+			case ol < Similar*largest:
+				count[p][0]++
+			case so < Similar*largest:
+				count[p][1]++
+			case sl < Similar*largest:
+				count[p][2]++
+			}
+
+			tracking += (so + sl + ol) / 3
+			ports++
+		}
+
+		if ports == 0 {
+			continue
+		}
+
+		tracking /= float64(ports)
+
+		thru := (cmplx.Abs(m.Thru[1][0]/a.Thru[1][0]) + cmplx.Abs(m.Thru[0][1]/a.Thru[0][1])) / 2
+
+		if thru < ThruLoss*tracking {
+			count[0][3]++
+		}
+	}
+
+	for p := 0; p < 2; p++ {
+		for j, c := range count[p] {
+			if c > n/2 {
+				if j == 3 {
+					return errors.New(problems[j])
+				}
+				return fmt.Errorf(problems[j], p+1)
+			}
+		}
+	}
+
+	return nil
+}
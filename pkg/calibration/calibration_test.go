@@ -0,0 +1,283 @@
+package calibration
+
+import (
+	"context"
+	"errors"
+	"math/cmplx"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/parallel"
+	"github.com/practable/pocket-vna-two-port/pkg/pb"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var e = twoport.ErrorTerms{
+	Edf: 0.05 + 0.02i, Esf: 0.1 - 0.05i, Erf: 0.9 + 0.1i, Etf: 0.85 - 0.2i, Elf: 0.08 + 0.03i, Exf: 0.001i,
+	Edr: 0.04 - 0.01i, Esr: -0.07 + 0.06i, Err: 0.88 - 0.15i, Etr: 0.8 + 0.25i, Elr: 0.06 - 0.02i, Exr: 0.002,
+}
+
+var dut = twoport.S{{0.1 + 0.2i, 0.7 - 0.1i}, {0.8 - 0.2i, -0.3 + 0.1i}}
+
+// measured returns the raw measurements of the standards and the dut at freq, through e
+func measured(freq []uint64) (*Standards, []pocket.SParam) {
+
+	std := &Standards{}
+	var d []pocket.SParam
+
+	for _, f := range freq {
+		std.Short = append(std.Short, e.Measure(twoport.Ideal.Short).SParam(f))
+		std.Open = append(std.Open, e.Measure(twoport.Ideal.Open).SParam(f))
+		std.Load = append(std.Load, e.Measure(twoport.Ideal.Load).SParam(f))
+		std.Thru = append(std.Thru, e.Measure(twoport.Ideal.Thru).SParam(f))
+		d = append(d, e.Measure(dut).SParam(f))
+	}
+
+	return std, d
+}
+
+func TestNative(t *testing.T) {
+
+	freq := []uint64{100e6, 200e6}
+
+	std, d := measured(freq)
+
+	r, err := Native{}.Calibrate(context.Background(), freq, std, d)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(r.DUT))
+	assert.Equal(t, 2, len(r.Terms))
+	assert.Equal(t, uint64(200e6), r.DUT[1].Freq)
+	assert.InDelta(t, 0, cmplx.Abs(twoport.FromSParam(r.DUT[1])[1][0]-dut[1][0]), 1e-9)
+	assert.InDelta(t, 0, cmplx.Abs(r.Terms[0].Elr-e.Elr), 1e-9)
+
+	_, err = Native{}.Calibrate(context.Background(), freq, std, d[:1])
+	assert.Error(t, err)
+
+	std.IdealThru = std.Thru[:1]
+	_, err = Native{}.Calibrate(context.Background(), freq, std, d)
+	assert.Error(t, err)
+}
+
+// service is a calibration service that remembers the requests it was sent
+type service struct {
+	pb.CalibrateClient
+	requests []*pb.CalibrateTwoPortRequest
+	shorts   []*pb.SParams
+	err      error
+}
+
+func (s *service) CalibrateTwoPort(ctx context.Context, in *pb.CalibrateTwoPortRequest, opts ...grpc.CallOption) (*pb.CalibrateTwoPortResponse, error) {
+	s.requests = append(s.requests, in)
+	s.shorts = append(s.shorts, in.Short)
+	return &pb.CalibrateTwoPortResponse{Frequency: in.Frequency, Result: in.Dut}, s.err
+}
+
+func TestGRPC(t *testing.T) {
+
+	freq := []uint64{100e6, 200e6}
+
+	std, d := measured(freq)
+
+	s := &service{}
+	g := NewGRPC(s)
+
+	r, err := g.Calibrate(context.Background(), freq, std, d)
+	assert.NoError(t, err)
+	assert.Equal(t, d, r.DUT)
+	assert.Nil(t, r.Terms)
+	assert.Equal(t, []float64{100e6, 200e6}, s.requests[0].Frequency)
+	assert.Nil(t, s.requests[0].IdealShort)
+
+	// the same standards are not converted again
+	_, err = g.Calibrate(context.Background(), freq, std, d)
+	assert.NoError(t, err)
+	assert.True(t, s.shorts[0] == s.shorts[1])
+
+	// different ones are, along with a cal kit
+	kit, _ := measured(freq)
+	other := &Standards{Short: std.Short, Open: std.Open, Load: std.Load, Thru: std.Thru, IdealThru: kit.Thru}
+
+	_, err = g.Calibrate(context.Background(), freq, other, d)
+	assert.NoError(t, err)
+	assert.False(t, s.shorts[1] == s.shorts[2])
+	assert.Nil(t, s.requests[2].IdealShort)
+	assert.Equal(t, 2, len(s.requests[2].IdealThru.S21))
+
+	s.err = errors.New("connection refused")
+	_, err = g.Calibrate(context.Background(), freq, other, d)
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrUnavailable))
+
+	// the service could not be reached, rather than refusing the request
+	s.err = status.Error(codes.Unavailable, "connection refused")
+	_, err = g.Calibrate(context.Background(), freq, other, d)
+	assert.True(t, errors.Is(err, ErrUnavailable))
+
+	assert.NoError(t, g.Close())
+}
+
+func TestCal2Terms(t *testing.T) {
+
+	v := func(z complex128) []*pb.Complex {
+		return []*pb.Complex{{Real: real(z), Imag: imag(z)}}
+	}
+
+	pe := &pb.ErrorTerms{
+		ForwardDirectivity: v(e.Edf), ForwardSourceMatch: v(e.Esf), ForwardReflectionTracking: v(e.Erf),
+		ForwardTransmissionTracking: v(e.Etf), ForwardLoadMatch: v(e.Elf), ForwardIsolation: v(e.Exf),
+		ReverseDirectivity: v(e.Edr), ReverseSourceMatch: v(e.Esr), ReverseReflectionTracking: v(e.Err),
+		ReverseTransmissionTracking: v(e.Etr), ReverseLoadMatch: v(e.Elr), ReverseIsolation: v(e.Exr),
+	}
+
+	assert.Nil(t, Cal2Terms([]float64{1e8}, nil))
+	assert.Nil(t, Cal2Terms([]float64{1e8, 2e8}, pe))
+	assert.Equal(t, []twoport.ErrorTerms{e}, Cal2Terms([]float64{1e8}, pe))
+	assert.Equal(t, pe, Terms2Cal([]twoport.ErrorTerms{e}))
+}
+
+func TestMeas2CalInto(t *testing.T) {
+
+	s := []pocket.SParam{
+		{Freq: 100e6, S11: pocket.Complex{Real: 0.1, Imag: 0.2}, S22: pocket.Complex{Real: -0.3}},
+		{Freq: 200e6, S12: pocket.Complex{Imag: 0.5}, S21: pocket.Complex{Real: 0.7}},
+	}
+
+	p := Meas2Cal(s)
+	assert.Equal(t, s, Cal2Meas(Meas2Freq(s), p))
+
+	// the same size reuses the values, without allocating
+	s[0].S11.Real = 0.9
+	q := Meas2CalInto(p, s)
+	assert.True(t, p == q)
+	assert.Equal(t, 0.9, q.S11[0].Real)
+	assert.Equal(t, s, Cal2Meas(Meas2Freq(s), q))
+
+	allocs := testing.AllocsPerRun(10, func() {
+		Meas2CalInto(p, s)
+	})
+	assert.Equal(t, 0.0, allocs)
+
+	// a different size starts again
+	q = Meas2CalInto(p, s[:1])
+	assert.False(t, p == q)
+	assert.Equal(t, 1, len(q.S11))
+
+	// a long sweep is converted in parallel, to the same
+	d := parallel.Default
+	defer func() { parallel.Default = d }()
+
+	parallel.Default = parallel.NewPool(3)
+
+	long := sweep()
+	p = Meas2Cal(long)
+
+	for _, i := range []int{0, 500, 1000} {
+		assert.Equal(t, long[i].S21.Imag, p.S21[i].Imag)
+	}
+
+	assert.Equal(t, long, Cal2Meas(Meas2Freq(long), p))
+	assert.True(t, p == Meas2CalInto(p, long))
+}
+
+func TestVerify(t *testing.T) {
+
+	freq := []uint64{100e6, 200e6, 300e6}
+
+	std, _ := measured(freq)
+	assert.NoError(t, std.Verify())
+
+	// a cable off the load on port 1, at most frequencies
+	bad, _ := measured(freq)
+	bad.Load[0].S11 = bad.Open[0].S11
+	bad.Load[1].S11 = bad.Open[1].S11
+	assert.EqualError(t, bad.Verify(), "the load on port 1 looks the same as the open, is it connected?")
+
+	// but not at just one of them
+	bad, _ = measured(freq)
+	bad.Load[0].S11 = bad.Open[0].S11
+	assert.NoError(t, bad.Verify())
+
+	bad, _ = measured(freq)
+	for i := range freq {
+		bad.Short[i].S22 = bad.Open[i].S22
+	}
+	assert.EqualError(t, bad.Verify(), "the short on port 2 looks the same as the open, is it connected?")
+
+	bad, _ = measured(freq)
+	for i := range freq {
+		bad.Load[i].S22 = bad.Short[i].S22
+	}
+	assert.EqualError(t, bad.Verify(), "the short on port 2 looks the same as the load")
+
+	bad, _ = measured(freq)
+	for i, f := range freq {
+		bad.Thru[i] = e.Measure(twoport.Ideal.Load).SParam(f)
+	}
+	assert.EqualError(t, bad.Verify(), "the thru looks like it is not connected")
+
+	// the cal kit is used for what the standards should look like
+	bad, _ = measured(freq)
+	bad.IdealLoad = bad.Open
+	assert.Error(t, bad.Verify())
+
+	bad, _ = measured(freq)
+	bad.Thru = bad.Thru[:1]
+	assert.Error(t, bad.Verify())
+
+	// nothing can be told from identical standards, as from a mock
+	p := []pocket.SParam{{Freq: 100e6, S11: pocket.Complex{Real: 0.5}}}
+	same := &Standards{Short: p, Open: p, Load: p, Thru: p}
+	assert.NoError(t, same.Verify())
+}
+
+// sweep is a raw measurement of the dut, at as many points as a long segmented sweep
+func sweep() []pocket.SParam {
+
+	s := make([]pocket.SParam, 1001)
+
+	for i := range s {
+		s[i] = e.Measure(dut).SParam(uint64(1e6 + i*3e6))
+	}
+
+	return s
+}
+
+func BenchmarkMeas2Cal(b *testing.B) {
+
+	s := sweep()
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Meas2Cal(s)
+	}
+}
+
+func BenchmarkMeas2CalInto(b *testing.B) {
+
+	s := sweep()
+	p := Meas2Cal(s)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p = Meas2CalInto(p, s)
+	}
+}
+
+func BenchmarkCal2Meas(b *testing.B) {
+
+	s := sweep()
+	f := Meas2Freq(s)
+	p := Meas2Cal(s)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		Cal2Meas(f, p)
+	}
+}
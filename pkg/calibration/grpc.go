@@ -0,0 +1,309 @@
+package calibration
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/practable/pocket-vna-two-port/pkg/parallel"
+	"github.com/practable/pocket-vna-two-port/pkg/pb"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// GRPC is a backend that sends the calibration to a gRPC calibration service,
+// normally py/server.py. It keeps the request for the last standards it was given,
+// so that only the dut is converted for each further measurement with the same
+// calibration. It must not be used by more than one goroutine at once.
+type GRPC struct {
+	conn *grpc.ClientConn // nil if the client was given
+	c    pb.CalibrateClient
+	req  *pb.CalibrateTwoPortRequest
+	std  *Standards // that req holds, nil if none
+}
+
+// Dial returns a backend using the calibration service at addr (host:port). The
+// connection is made when it is first needed, so the service need not be running yet.
+// The service is unlikely to be remote, due to the difficulties of proxying HTTP/2.
+func Dial(addr string) (*GRPC, error) {
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+
+	if err != nil {
+		return nil, fmt.Errorf("did not connect to calibration gRPC service %s because %s", addr, err.Error())
+	}
+
+	g := NewGRPC(pb.NewCalibrateClient(conn))
+	g.conn = conn
+
+	return g, nil
+}
+
+// NewGRPC returns a backend using a client that is already connected
+func NewGRPC(c pb.CalibrateClient) *GRPC {
+	return &GRPC{
+		c:   c,
+		req: &pb.CalibrateTwoPortRequest{},
+	}
+}
+
+// Close closes the connection made by Dial
+func (g *GRPC) Close() error {
+
+	if g.conn == nil {
+		return nil
+	}
+
+	return g.conn.Close()
+}
+
+// Calibrate sends the standards and dut to the calibration service
+func (g *GRPC) Calibrate(ctx context.Context, freq []uint64, std *Standards, dut []pocket.SParam) (Result, error) {
+
+	if std != g.std {
+
+		g.req.Reset()
+		g.std = nil
+
+		g.req.Frequency = make([]float64, len(freq))
+
+		for i, f := range freq {
+			g.req.Frequency[i] = float64(f)
+		}
+
+		g.req.Short = Meas2Cal(std.Short)
+		g.req.Open = Meas2Cal(std.Open)
+		g.req.Load = Meas2Cal(std.Load)
+		g.req.Thru = Meas2Cal(std.Thru)
+
+		// the service treats the standards as ideal unless they are given
+		if std.IdealShort != nil {
+			g.req.IdealShort = Meas2Cal(std.IdealShort)
+		}
+		if std.IdealOpen != nil {
+			g.req.IdealOpen = Meas2Cal(std.IdealOpen)
+		}
+		if std.IdealLoad != nil {
+			g.req.IdealLoad = Meas2Cal(std.IdealLoad)
+		}
+		if std.IdealThru != nil {
+			g.req.IdealThru = Meas2Cal(std.IdealThru)
+		}
+
+		g.std = std
+	}
+
+	//reuse the other parts of the protocol buffer that are already there
+	g.req.Dut = Meas2CalInto(g.req.Dut, dut)
+
+	r, err := g.c.CalibrateTwoPort(ctx, g.req)
+
+	if err != nil {
+		switch status.Code(err) {
+		case codes.Unavailable, codes.DeadlineExceeded:
+			return Result{}, fmt.Errorf("%w: %s", ErrUnavailable, err.Error())
+		}
+		return Result{}, err
+	}
+
+	return Result{
+		DUT:   Cal2Meas(r.GetFrequency(), r.GetResult()),
+		Terms: Cal2Terms(r.GetFrequency(), r.GetErrorTerms()),
+	}, nil
+}
+
+// Cal2Terms converts the error terms returned by the calibration service,
+// returning nil if there are none, or they do not match the frequencies f
+func Cal2Terms(f []float64, e *pb.ErrorTerms) []twoport.ErrorTerms {
+
+	if e == nil {
+		return nil
+	}
+
+	terms := [][]*pb.Complex{
+		e.ForwardDirectivity, e.ForwardSourceMatch, e.ForwardReflectionTracking,
+		e.ForwardTransmissionTracking, e.ForwardLoadMatch, e.ForwardIsolation,
+		e.ReverseDirectivity, e.ReverseSourceMatch, e.ReverseReflectionTracking,
+		e.ReverseTransmissionTracking, e.ReverseLoadMatch, e.ReverseIsolation,
+	}
+
+	for _, t := range terms {
+		if len(t) != len(f) {
+			return nil
+		}
+	}
+
+	c := func(v *pb.Complex) complex128 {
+		return complex(v.Real, v.Imag)
+	}
+
+	et := make([]twoport.ErrorTerms, len(f))
+
+	for i := range f {
+		et[i] = twoport.ErrorTerms{
+			Edf: c(e.ForwardDirectivity[i]),
+			Esf: c(e.ForwardSourceMatch[i]),
+			Erf: c(e.ForwardReflectionTracking[i]),
+			Etf: c(e.ForwardTransmissionTracking[i]),
+			Elf: c(e.ForwardLoadMatch[i]),
+			Exf: c(e.ForwardIsolation[i]),
+			Edr: c(e.ReverseDirectivity[i]),
+			Esr: c(e.ReverseSourceMatch[i]),
+			Err: c(e.ReverseReflectionTracking[i]),
+			Etr: c(e.ReverseTransmissionTracking[i]),
+			Elr: c(e.ReverseLoadMatch[i]),
+			Exr: c(e.ReverseIsolation[i]),
+		}
+	}
+
+	return et
+}
+
+// Terms2Cal converts error terms into a protocol buffer, as returned by the calibration service
+func Terms2Cal(terms []twoport.ErrorTerms) *pb.ErrorTerms {
+
+	c := func(v complex128) *pb.Complex {
+		return &pb.Complex{Real: real(v), Imag: imag(v)}
+	}
+
+	e := &pb.ErrorTerms{}
+
+	for _, t := range terms {
+		e.ForwardDirectivity = append(e.ForwardDirectivity, c(t.Edf))
+		e.ForwardSourceMatch = append(e.ForwardSourceMatch, c(t.Esf))
+		e.ForwardReflectionTracking = append(e.ForwardReflectionTracking, c(t.Erf))
+		e.ForwardTransmissionTracking = append(e.ForwardTransmissionTracking, c(t.Etf))
+		e.ForwardLoadMatch = append(e.ForwardLoadMatch, c(t.Elf))
+		e.ForwardIsolation = append(e.ForwardIsolation, c(t.Exf))
+		e.ReverseDirectivity = append(e.ReverseDirectivity, c(t.Edr))
+		e.ReverseSourceMatch = append(e.ReverseSourceMatch, c(t.Esr))
+		e.ReverseReflectionTracking = append(e.ReverseReflectionTracking, c(t.Err))
+		e.ReverseTransmissionTracking = append(e.ReverseTransmissionTracking, c(t.Etr))
+		e.ReverseLoadMatch = append(e.ReverseLoadMatch, c(t.Elr))
+		e.ReverseIsolation = append(e.ReverseIsolation, c(t.Exr))
+	}
+
+	return e
+}
+
+// MinParallel is the fewest points worth converting in another goroutine
+const MinParallel = 256
+
+// Meas2Freq lists the frequencies of s, for a request to the calibration service
+func Meas2Freq(s []pocket.SParam) []float64 {
+	freq := make([]float64, len(s))
+
+	for i, v := range s {
+		freq[i] = float64(v.Freq)
+	}
+
+	return freq
+}
+
+// Meas2Cal converts s into a protocol buffer
+func Meas2Cal(s []pocket.SParam) *pb.SParams {
+	return Meas2CalInto(nil, s)
+}
+
+// Meas2CalInto converts s into the protocol buffer p, reusing the
+// values already in p where there are enough of them, so that repeated
+// conversions of the same size do not allocate. A nil p makes a new one, with
+// all the values for the four parameters in a single allocation.
+func Meas2CalInto(p *pb.SParams, s []pocket.SParam) *pb.SParams {
+
+	n := len(s)
+
+	if p == nil || len(p.S11) != n || len(p.S12) != n || len(p.S21) != n || len(p.S22) != n {
+
+		p = &pb.SParams{
+			S11: make([]*pb.Complex, n),
+			S12: make([]*pb.Complex, n),
+			S21: make([]*pb.Complex, n),
+			S22: make([]*pb.Complex, n),
+		}
+
+		values := make([]pb.Complex, 4*n)
+
+		for i := 0; i < n; i++ {
+			p.S11[i] = &values[4*i]
+			p.S12[i] = &values[4*i+1]
+			p.S21[i] = &values[4*i+2]
+			p.S22[i] = &values[4*i+3]
+		}
+	}
+
+	if parallel.Default.Chunks(n, MinParallel) == 1 {
+		meas2cal(p, s, 0, n)
+	} else {
+		meas2calParallel(p, s)
+	}
+
+	return p
+
+}
+
+// meas2calParallel copies s into p in the parallel pool, which is kept out of
+// Meas2CalInto so that a short sweep is converted without allocating
+func meas2calParallel(p *pb.SParams, s []pocket.SParam) {
+	parallel.For(len(s), MinParallel, func(start, end int) {
+		meas2cal(p, s, start, end)
+	})
+}
+
+// meas2cal copies the points of s from start up to end into p
+func meas2cal(p *pb.SParams, s []pocket.SParam, start, end int) {
+
+	for i := start; i < end; i++ {
+		v := s[i]
+		p.S11[i].Real, p.S11[i].Imag = v.S11.Real, v.S11.Imag
+		p.S12[i].Real, p.S12[i].Imag = v.S12.Real, v.S12.Imag
+		p.S21[i].Real, p.S21[i].Imag = v.S21.Real, v.S21.Imag
+		p.S22[i].Real, p.S22[i].Imag = v.S22.Real, v.S22.Imag
+	}
+}
+
+// Cal2Meas converts a protocol buffer from the calibration service back into
+// S-parameters at frequencies f, in the parallel pool if there are enough of them
+func Cal2Meas(f []float64, s *pb.SParams) []pocket.SParam {
+
+	ps := make([]pocket.SParam, len(s.S11))
+
+	parallel.For(len(ps), MinParallel, func(start, end int) {
+		cal2meas(ps, f, s, start, end)
+	})
+
+	return ps
+
+}
+
+// cal2meas converts the points of s from start up to end into ps
+func cal2meas(ps []pocket.SParam, f []float64, s *pb.SParams, start, end int) {
+
+	for i := start; i < end; i++ {
+
+		ps[i] = pocket.SParam{
+			Freq: uint64(f[i]),
+			S11: pocket.Complex{
+				Real: s.S11[i].Real,
+				Imag: s.S11[i].Imag,
+			},
+			S12: pocket.Complex{
+				Real: s.S12[i].Real,
+				Imag: s.S12[i].Imag,
+			},
+			S21: pocket.Complex{
+				Real: s.S21[i].Real,
+				Imag: s.S21[i].Imag,
+			},
+			S22: pocket.Complex{
+				Real: s.S22[i].Real,
+				Imag: s.S22[i].Imag,
+			},
+		}
+
+	}
+
+}
@@ -0,0 +1,306 @@
+// package calkit describes the calibration standards, so that the calibration
+// can use their actual response instead of assuming they are ideal.
+//
+// Each standard follows the usual offset model: a length of lossy line (delay,
+// loss, z0) terminated by a capacitance (open), inductance (short) or
+// resistance (load). Alternatively, a measured .s1p file (or .s2p for the thru)
+// can be given instead. All quantities are in SI units (s, ohm/s, F, H).
+package calkit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/touchstone"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+)
+
+// Z0 is the system reference impedance, ohms
+const Z0 = 50.0
+
+// Standard defines one calibration standard
+type Standard struct {
+	Delay float64   `json:"delay,omitempty"` // one-way offset delay, s
+	Loss  float64   `json:"loss,omitempty"`  // offset loss at 1GHz, ohm/s
+	Z0    float64   `json:"z0,omitempty"`    // offset impedance, ohm (default 50)
+	C     []float64 `json:"c,omitempty"`     // open: C0 (F), C1 (F/Hz), C2 (F/Hz^2), C3 (F/Hz^3)
+	L     []float64 `json:"l,omitempty"`     // short: L0 (H), L1 (H/Hz), L2 (H/Hz^2), L3 (H/Hz^3)
+	R     float64   `json:"r,omitempty"`     // load resistance, ohm (default 50)
+	File  string    `json:"file,omitempty"`  // measured data to use instead of the model
+	data  []pocket.SParam
+}
+
+// Kit is a set of standards for a SOLT calibration
+type Kit struct {
+	Name  string   `json:"name"`
+	Short Standard `json:"short"`
+	Open  Standard `json:"open"`
+	Load  Standard `json:"load"`
+	Thru  Standard `json:"thru"`
+}
+
+// Load reads a kit definition in JSON format from path. Any measured data
+// files are read at the same time, relative to the directory of the kit file.
+func Load(path string) (*Kit, error) {
+
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var k Kit
+
+	err = json.Unmarshal(b, &k)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read cal kit %s because %s", path, err.Error())
+	}
+
+	err = k.Check()
+
+	if err != nil {
+		return nil, fmt.Errorf("cal kit %s is not valid because %s", path, err.Error())
+	}
+
+	dir := filepath.Dir(path)
+
+	standards := []struct {
+		name  string
+		s     *Standard
+		ports int
+	}{
+		{"short", &k.Short, 1},
+		{"open", &k.Open, 1},
+		{"load", &k.Load, 1},
+		{"thru", &k.Thru, 2},
+	}
+
+	for _, v := range standards {
+
+		if v.s.File == "" {
+			continue
+		}
+
+		f := v.s.File
+
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(dir, f)
+		}
+
+		b, err := os.ReadFile(f)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not read data for %s because %s", v.name, err.Error())
+		}
+
+		d, err := touchstone.Parse(string(b))
+
+		if err != nil {
+			return nil, fmt.Errorf("could not parse data for %s because %s", v.name, err.Error())
+		}
+
+		if d.Ports != v.ports {
+			return nil, fmt.Errorf("data for %s has %d ports but should have %d", v.name, d.Ports, v.ports)
+		}
+
+		if d.Z0 != Z0 {
+			return nil, fmt.Errorf("data for %s has a reference impedance of %g ohms but should be %g ohms", v.name, d.Z0, Z0)
+		}
+
+		v.s.data = d.SParam
+	}
+
+	return &k, nil
+}
+
+// Ideals returns the expected responses of the short, open, load and thru
+// at each frequency (Hz), in the form of two-port S-parameters. The same
+// reflect standard is assumed on both ports.
+func (k *Kit) Ideals(freq []uint64) (short, open, load, thru []pocket.SParam, err error) {
+
+	short, err = k.Short.reflect(freq, func(w float64) complex128 {
+		return complex(0, w*poly(k.Short.L, w/(2*math.Pi)))
+	})
+
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("short: %s", err.Error())
+	}
+
+	open, err = k.Open.reflect(freq, func(w float64) complex128 {
+		c := poly(k.Open.C, w/(2*math.Pi))
+		if c == 0 {
+			return cmplx.Inf()
+		}
+		return 1 / complex(0, w*c)
+	})
+
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("open: %s", err.Error())
+	}
+
+	load, err = k.Load.reflect(freq, func(w float64) complex128 {
+		if k.Load.R == 0 {
+			return complex(Z0, 0)
+		}
+		return complex(k.Load.R, 0)
+	})
+
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("load: %s", err.Error())
+	}
+
+	thru, err = k.Thru.thru(freq)
+
+	if err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("thru: %s", err.Error())
+	}
+
+	return short, open, load, thru, nil
+}
+
+func (s Standard) reflect(freq []uint64, zt func(w float64) complex128) ([]pocket.SParam, error) {
+
+	if s.data != nil {
+
+		d, err := twoport.Resample(s.data, freq)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range d {
+			d[i].S22 = d[i].S11
+		}
+
+		return d, nil
+	}
+
+	r := make([]pocket.SParam, len(freq))
+
+	for i, f := range freq {
+
+		w := 2 * math.Pi * float64(f)
+
+		zc, gl := s.offset(float64(f))
+
+		z := zt(w)
+
+		var zin complex128
+
+		switch {
+		case gl == 0:
+			zin = z
+		case cmplx.IsInf(z):
+			zin = zc / cmplx.Tanh(gl)
+		default:
+			t := cmplx.Tanh(gl)
+			zin = zc * (z + zc*t) / (zc + z*t)
+		}
+
+		var g complex128
+
+		if cmplx.IsInf(zin) {
+			g = 1
+		} else {
+			g = (zin - Z0) / (zin + Z0)
+		}
+
+		c := pocket.Complex{Real: real(g), Imag: imag(g)}
+
+		r[i] = pocket.SParam{Freq: f, S11: c, S22: c}
+	}
+
+	return r, nil
+}
+
+func (s Standard) thru(freq []uint64) ([]pocket.SParam, error) {
+
+	if s.data != nil {
+		return twoport.Resample(s.data, freq)
+	}
+
+	r := make([]pocket.SParam, len(freq))
+
+	for i, f := range freq {
+
+		zc, gl := s.offset(float64(f))
+
+		sh := cmplx.Sinh(gl)
+		ch := cmplx.Cosh(gl)
+
+		d := 2*zc*Z0*ch + (zc*zc+Z0*Z0)*sh
+
+		s11 := (zc*zc - Z0*Z0) * sh / d
+		s21 := 2 * zc * Z0 / d
+
+		r[i] = twoport.S{{s11, s21}, {s21, s11}}.SParam(f)
+	}
+
+	return r, nil
+}
+
+// offset returns the characteristic impedance and propagation constant times
+// length of the offset line, using the standard model of a coaxial offset
+// with skin effect loss
+func (s Standard) offset(f float64) (complex128, complex128) {
+
+	z0 := s.Z0
+
+	if z0 == 0 {
+		z0 = Z0
+	}
+
+	if s.Delay == 0 && s.Loss == 0 {
+		return complex(z0, 0), 0
+	}
+
+	w := 2 * math.Pi * f
+
+	root := math.Sqrt(f / 1e9)
+
+	al := s.Loss * s.Delay / (2 * z0) * root // nepers
+
+	bl := w*s.Delay + al // radians
+
+	zc := complex(z0, 0)
+
+	if w > 0 {
+		zc += complex(1, -1) * complex(s.Loss/(2*w)*root, 0)
+	}
+
+	return zc, complex(al, bl)
+}
+
+// poly evaluates c[0] + c[1] f + c[2] f^2 + c[3] f^3
+func poly(c []float64, f float64) float64 {
+
+	v := 0.0
+
+	for i := len(c) - 1; i >= 0; i-- {
+		v = v*f + c[i]
+	}
+
+	return v
+}
+
+// Check returns an error if the kit has values that cannot be right
+func (k *Kit) Check() error {
+
+	for _, s := range []Standard{k.Short, k.Open, k.Load, k.Thru} {
+		if s.Delay < 0 || s.Loss < 0 || s.Z0 < 0 || s.R < 0 {
+			return errors.New("delay, loss, z0 and r must not be negative")
+		}
+		if len(s.C) > 4 || len(s.L) > 4 {
+			return errors.New("c and l have at most four coefficients")
+		}
+	}
+
+	return nil
+}
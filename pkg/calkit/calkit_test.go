@@ -0,0 +1,112 @@
+package calkit
+
+import (
+	"math"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func toComplex(c pocket.Complex) complex128 {
+	return complex(c.Real, c.Imag)
+}
+
+func TestIdeal(t *testing.T) {
+
+	k := Kit{}
+
+	short, open, load, thru, err := k.Ideals([]uint64{1e6, 1e9})
+
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, pocket.Complex{Real: -1}, short[i].S11)
+		assert.Equal(t, pocket.Complex{Real: 1}, open[i].S11)
+		assert.Equal(t, pocket.Complex{Real: 1}, open[i].S22)
+		assert.Equal(t, pocket.Complex{}, load[i].S11)
+		assert.Equal(t, pocket.Complex{}, short[i].S21)
+		assert.Equal(t, pocket.Complex{Real: 1}, thru[i].S21)
+		assert.Equal(t, pocket.Complex{}, thru[i].S11)
+	}
+}
+
+func TestModel(t *testing.T) {
+
+	f := 1e9
+	w := 2 * math.Pi * f
+	d := 30e-12
+
+	k := Kit{
+		Short: Standard{Delay: d},
+		Open:  Standard{C: []float64{50e-15}},
+		Load:  Standard{R: 75},
+		Thru:  Standard{Delay: d},
+	}
+
+	short, open, load, thru, err := k.Ideals([]uint64{uint64(f)})
+
+	assert.NoError(t, err)
+
+	// lossless offset short rotates by twice the delay
+	assert.InDelta(t, 0, cmplx.Abs(toComplex(short[0].S11)-(-cmplx.Exp(complex(0, -2*w*d)))), 1e-9)
+
+	// capacitive open has a negative phase
+	g := toComplex(open[0].S11)
+	assert.InDelta(t, 1, cmplx.Abs(g), 1e-9)
+	zc := 1 / complex(0, w*50e-15)
+	assert.InDelta(t, 0, cmplx.Abs(g-(zc-50)/(zc+50)), 1e-9)
+	assert.True(t, imag(g) < 0)
+
+	assert.InDelta(t, 0.2, load[0].S11.Real, 1e-9)
+
+	// matched thru is a pure delay
+	assert.InDelta(t, 0, cmplx.Abs(toComplex(thru[0].S21)-cmplx.Exp(complex(0, -w*d))), 1e-9)
+	assert.InDelta(t, 0, cmplx.Abs(toComplex(thru[0].S11)), 1e-9)
+
+	// loss reduces the magnitude
+	k.Short.Loss = 2e9
+	short, _, _, _, err = k.Ideals([]uint64{uint64(f)})
+	assert.NoError(t, err)
+	assert.True(t, cmplx.Abs(toComplex(short[0].S11)) < 1)
+
+}
+
+func TestLoad(t *testing.T) {
+
+	k, err := Load("testdata/kit.json")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test kit", k.Name)
+
+	short, open, load, thru, err := k.Ideals([]uint64{100e6, 1550e6, 3000e6})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(short))
+	assert.Equal(t, 3, len(open))
+	assert.Equal(t, 3, len(thru))
+	assert.InDelta(t, 0.03, load[1].S11.Real, 1e-9)
+	assert.InDelta(t, 0.01, load[1].S22.Imag, 1e-9)
+
+	// data file does not cover this frequency
+	_, _, _, _, err = k.Ideals([]uint64{4000e6})
+	assert.Error(t, err)
+
+	_, err = Load("testdata/missing.json")
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.json")
+	err = os.WriteFile(bad, []byte(`{"short":{"delay":-1}}`), 0644)
+	assert.NoError(t, err)
+	_, err = Load(bad)
+	assert.Error(t, err)
+
+	err = os.WriteFile(bad, []byte(`{"thru":{"file":"../testdata/load.s1p"}}`), 0644)
+	assert.NoError(t, err)
+	_, err = Load(bad)
+	assert.Error(t, err)
+}
@@ -0,0 +1,204 @@
+// package calstate follows the calibration through its workflow: from
+// uncalibrated, through setting up a cal and measuring its standards, to
+// calibrated, and on to stale if a setting the cal depends on is changed.
+// A cal that stops part way is interrupted, keeping the standards measured
+// so far, so that it can be resumed from the one that failed.
+// Only the transitions listed in Transitions can be made, so the state
+// cannot get out of step with the cal, and each one is returned to the
+// caller so that users can be told about it.
+package calstate
+
+import (
+	"fmt"
+	"time"
+)
+
+// State is where the calibration is up to
+type State string
+
+const (
+	// Uncalibrated means there is no cal, e.g. at start up or after a failed rc
+	Uncalibrated State = "uncalibrated"
+	// Setup means an rc has been accepted, and any previous cal discarded, but
+	// no standards have been measured yet
+	Setup State = "setup"
+	// PartiallyMeasured means some, but not all, of the standards have been measured
+	PartiallyMeasured State = "partial"
+	// Interrupted means an rc stopped after measuring some of the standards, which
+	// are kept so that it can be resumed, but there is no cal that crq can use
+	Interrupted State = "interrupted"
+	// Calibrated means there is a cal that crq can use
+	Calibrated State = "calibrated"
+	// Stale means there is a cal, but a setting it depends on has changed since,
+	// so crq cannot use it until the setting is restored or a new cal is made
+	Stale State = "stale"
+)
+
+// Event is something that happens in the calibration workflow
+type Event string
+
+const (
+	// Start is an rc being accepted
+	Start Event = "start"
+	// Measure is one of the standards being measured
+	Measure Event = "measure"
+	// Complete is the cal being made from the standards
+	Complete Event = "complete"
+	// Fail is an rc stopping before the cal is made
+	Fail Event = "fail"
+	// Interrupt is an rc stopping before the cal is made, keeping the standards
+	// measured so far
+	Interrupt Event = "interrupt"
+	// Resume is an interrupted rc carrying on from the standard that failed
+	Resume Event = "resume"
+	// Remeasure is one standard of the cal being measured again, and the cal
+	// made again with it
+	Remeasure Event = "remeasure"
+	// Invalidate is a setting the cal depends on being changed
+	Invalidate Event = "invalidate"
+	// Restore is the setting being put back as it was when the cal was made
+	Restore Event = "restore"
+	// Discard is the cal being dropped, e.g. by a reset
+	Discard Event = "discard"
+)
+
+// Transitions is the state each event moves each state on to. An event that
+// is not listed for the current state is not allowed.
+var Transitions = map[State]map[Event]State{
+	Uncalibrated: {
+		Start: Setup,
+	},
+	Setup: {
+		Measure: PartiallyMeasured,
+		Fail:    Uncalibrated,
+	},
+	PartiallyMeasured: {
+		Measure:   PartiallyMeasured,
+		Complete:  Calibrated,
+		Fail:      Uncalibrated,
+		Interrupt: Interrupted,
+	},
+	Interrupted: {
+		Start:   Setup,
+		Resume:  PartiallyMeasured,
+		Discard: Uncalibrated,
+	},
+	Calibrated: {
+		Start:      Setup,
+		Remeasure:  Calibrated,
+		Invalidate: Stale,
+		Discard:    Uncalibrated,
+	},
+	Stale: {
+		Start:   Setup,
+		Restore: Calibrated,
+		Discard: Uncalibrated,
+	},
+}
+
+// Transition is a change of state, and the event that caused it
+type Transition struct {
+	From   State
+	To     State
+	Event  Event
+	Reason string
+	Time   time.Time
+}
+
+// Machine is the state of the calibration. The zero value is Uncalibrated.
+// It is not safe for concurrent use, so it is kept with the cal it describes.
+type Machine struct {
+	state    State
+	since    time.Time
+	reason   string
+	measured []string
+}
+
+// State returns the current state
+func (m *Machine) State() State {
+
+	if m.state == "" {
+		return Uncalibrated
+	}
+
+	return m.state
+}
+
+// Since returns when the current state was entered, zero if it never has been
+func (m *Machine) Since() time.Time {
+	return m.since
+}
+
+// Reason returns why the current state was entered, if known
+func (m *Machine) Reason() string {
+	return m.reason
+}
+
+// Measured returns the standards measured since the last Start, in order
+func (m *Machine) Measured() []string {
+	return append([]string(nil), m.measured...)
+}
+
+// Remaining returns those of standards that have not been measured since the last
+// Start, in order, or nil if the cal is not being made or resumed
+func (m *Machine) Remaining(standards []string) []string {
+
+	switch m.State() {
+	case Setup, PartiallyMeasured, Interrupted:
+	default:
+		return nil
+	}
+
+	done := make(map[string]bool)
+
+	for _, s := range m.measured {
+		done[s] = true
+	}
+
+	var r []string
+
+	for _, s := range standards {
+		if !done[s] {
+			r = append(r, s)
+		}
+	}
+
+	return r
+}
+
+// Fire moves the machine on by event e, returning the transition, or an error
+// if e is not allowed in the current state, in which case the state is not
+// changed. The reason for a Measure event is the name of the standard.
+func (m *Machine) Fire(e Event, reason string) (Transition, error) {
+
+	from := m.State()
+
+	to, ok := Transitions[from][e]
+
+	if !ok {
+		return Transition{}, fmt.Errorf("cannot %s when %s", e, from)
+	}
+
+	switch e {
+	case Start:
+		m.measured = nil
+	case Measure:
+		m.measured = append(m.measured, reason)
+	case Fail, Discard:
+		m.measured = nil
+	}
+
+	t := Transition{
+		From:   from,
+		To:     to,
+		Event:  e,
+		Reason: reason,
+		Time:   time.Now(),
+	}
+
+	m.state = to
+	m.since = t.Time
+	m.reason = reason
+
+	return t, nil
+}
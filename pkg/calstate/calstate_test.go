@@ -0,0 +1,165 @@
+package calstate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMachine(t *testing.T) {
+
+	m := Machine{}
+
+	assert.Equal(t, Uncalibrated, m.State())
+	assert.True(t, m.Since().IsZero())
+
+	_, err := m.Fire(Measure, "short")
+	assert.EqualError(t, err, "cannot measure when uncalibrated")
+	assert.Equal(t, Uncalibrated, m.State())
+
+	tr, err := m.Fire(Start, "")
+	assert.NoError(t, err)
+	assert.Equal(t, Uncalibrated, tr.From)
+	assert.Equal(t, Setup, tr.To)
+	assert.Equal(t, tr.Time, m.Since())
+
+	// there must be standards to make a cal from
+	_, err = m.Fire(Complete, "")
+	assert.Error(t, err)
+
+	for _, s := range []string{"short", "open", "load", "thru"} {
+		_, err = m.Fire(Measure, s)
+		assert.NoError(t, err)
+		assert.Equal(t, PartiallyMeasured, m.State())
+	}
+
+	assert.Equal(t, []string{"short", "open", "load", "thru"}, m.Measured())
+
+	_, err = m.Fire(Complete, "")
+	assert.NoError(t, err)
+	assert.Equal(t, Calibrated, m.State())
+
+	_, err = m.Fire(Restore, "")
+	assert.Error(t, err)
+
+	// measuring a standard again keeps the cal, and the list of what was measured
+	_, err = m.Fire(Remeasure, "load")
+	assert.NoError(t, err)
+	assert.Equal(t, Calibrated, m.State())
+	assert.Equal(t, "load", m.Reason())
+	assert.Equal(t, []string{"short", "open", "load", "thru"}, m.Measured())
+
+	_, err = m.Fire(Invalidate, "power changed")
+	assert.NoError(t, err)
+	assert.Equal(t, Stale, m.State())
+	assert.Equal(t, "power changed", m.Reason())
+
+	_, err = m.Fire(Restore, "")
+	assert.NoError(t, err)
+	assert.Equal(t, Calibrated, m.State())
+
+	// a new cal starts afresh, and failing part way leaves no cal
+	_, err = m.Fire(Start, "")
+	assert.NoError(t, err)
+	assert.Empty(t, m.Measured())
+
+	_, err = m.Fire(Measure, "short")
+	assert.NoError(t, err)
+
+	tr, err = m.Fire(Fail, "the VNA is not connected")
+	assert.NoError(t, err)
+	assert.Equal(t, PartiallyMeasured, tr.From)
+	assert.Equal(t, Uncalibrated, m.State())
+	assert.Empty(t, m.Measured())
+
+	// a cal can be dropped, e.g. by a reset, but there must be one to drop
+	_, err = m.Fire(Discard, "reset")
+	assert.Error(t, err)
+
+	m = Machine{}
+
+	for _, e := range []Event{Start, Measure, Complete, Discard} {
+		_, err = m.Fire(e, "short")
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, Uncalibrated, m.State())
+	assert.Empty(t, m.Measured())
+}
+
+func TestResume(t *testing.T) {
+
+	m := Machine{}
+	all := []string{"short", "open", "load", "thru", "dut1"}
+
+	assert.Nil(t, m.Remaining(all))
+
+	for _, e := range []Event{Start, Measure} {
+		_, err := m.Fire(e, "short")
+		assert.NoError(t, err)
+	}
+
+	_, err := m.Fire(Measure, "open")
+	assert.NoError(t, err)
+
+	// the standards measured so far are kept
+	tr, err := m.Fire(Interrupt, "the thru is not connected")
+	assert.NoError(t, err)
+	assert.Equal(t, Interrupted, tr.To)
+	assert.Equal(t, []string{"short", "open"}, m.Measured())
+	assert.Equal(t, []string{"load", "thru", "dut1"}, m.Remaining(all))
+
+	_, err = m.Fire(Complete, "")
+	assert.Error(t, err)
+
+	_, err = m.Fire(Resume, "")
+	assert.NoError(t, err)
+	assert.Equal(t, PartiallyMeasured, m.State())
+	assert.Equal(t, []string{"short", "open"}, m.Measured())
+
+	for _, s := range []string{"load", "thru", "dut1"} {
+		_, err = m.Fire(Measure, s)
+		assert.NoError(t, err)
+	}
+
+	assert.Empty(t, m.Remaining(all))
+
+	_, err = m.Fire(Complete, "")
+	assert.NoError(t, err)
+	assert.Nil(t, m.Remaining(all))
+
+	// an interrupted cal can be started again, or dropped
+	for _, e := range []Event{Start, Measure, Interrupt, Start} {
+		_, err = m.Fire(e, "short")
+		assert.NoError(t, err)
+	}
+
+	assert.Empty(t, m.Measured())
+
+	for _, e := range []Event{Measure, Interrupt, Discard} {
+		_, err = m.Fire(e, "short")
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, Uncalibrated, m.State())
+	assert.Empty(t, m.Measured())
+}
+
+func TestTransitions(t *testing.T) {
+
+	states := []State{Uncalibrated, Setup, PartiallyMeasured, Interrupted, Calibrated, Stale}
+
+	assert.Equal(t, len(states), len(Transitions))
+
+	// every state can be reached, and every transition goes to a known state
+	reached := make(map[State]bool)
+
+	for _, s := range states {
+		for _, to := range Transitions[s] {
+			assert.Contains(t, states, to)
+			reached[to] = true
+		}
+	}
+
+	assert.Equal(t, len(states), len(reached))
+}
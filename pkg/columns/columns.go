@@ -0,0 +1,86 @@
+// package columns lays out a result as one array per quantity, i.e. column-major,
+// with the real and imaginary parts of each S-parameter in arrays of their own,
+// which is how numpy and MATLAB load data most easily, rather than one object per point
+package columns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+const (
+	Points  = "points"  // one object per point, in result (the default)
+	Columns = "columns" // one array per quantity, in columns, instead of result
+)
+
+// Check returns an error if layout is not a known layout
+func Check(layout string) error {
+	switch strings.ToLower(layout) {
+	case "", Points, Columns:
+		return nil
+	}
+	return fmt.Errorf("unknown layout %s, use points or columns", layout)
+}
+
+// Wanted returns true if layout asks for columns
+func Wanted(layout string) bool {
+	return strings.ToLower(layout) == Columns
+}
+
+// DTypes are the numpy dtypes of the columns, once the real and imaginary parts are joined
+var DTypes = map[string]string{
+	"freq": "uint64",
+	"s11":  "complex128",
+	"s12":  "complex128",
+	"s21":  "complex128",
+	"s22":  "complex128",
+}
+
+// Loaders are snippets of code that load the columns of a response that has been saved
+// to response.json, for numpy and MATLAB
+var Loaders = map[string]string{
+	"numpy": "import json, numpy as np; c = json.load(open('response.json'))['columns']; " +
+		"f = np.array(c['freq'], dtype=np.uint64); " +
+		"s = {k: np.array(c[k]['real']) + 1j * np.array(c[k]['imag']) for k in ('s11', 's12', 's21', 's22')}",
+	"matlab": "c = jsondecode(fileread('response.json')).columns; f = uint64(c.freq); " +
+		"s11 = c.s11.real + 1i*c.s11.imag; s12 = c.s12.real + 1i*c.s12.imag; " +
+		"s21 = c.s21.real + 1i*c.s21.imag; s22 = c.s22.real + 1i*c.s22.imag;",
+}
+
+// From returns s as columns. The arrays are empty rather than null if s is, so
+// that they load as empty arrays.
+func From(s []pocket.SParam) pocket.Columns {
+
+	c := pocket.Columns{
+		Freq:   make([]uint64, len(s)),
+		S11:    column(len(s)),
+		S12:    column(len(s)),
+		S21:    column(len(s)),
+		S22:    column(len(s)),
+		DTypes: DTypes,
+	}
+
+	for i, v := range s {
+		c.Freq[i] = v.Freq
+		set(c.S11, i, v.S11)
+		set(c.S12, i, v.S12)
+		set(c.S21, i, v.S21)
+		set(c.S22, i, v.S22)
+	}
+
+	return c
+}
+
+func column(n int) pocket.ComplexColumn {
+	return pocket.ComplexColumn{
+		Real: make([]float64, n),
+		Imag: make([]float64, n),
+	}
+}
+
+func set(c pocket.ComplexColumn, i int, v pocket.Complex) {
+	c.Real[i] = v.Real
+	c.Imag[i] = v.Imag
+}
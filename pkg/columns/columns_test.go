@@ -0,0 +1,41 @@
+package columns
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	assert.NoError(t, Check(""))
+	assert.NoError(t, Check("points"))
+	assert.NoError(t, Check("Columns"))
+	assert.EqualError(t, Check("rows"), "unknown layout rows, use points or columns")
+	assert.True(t, Wanted("columns"))
+	assert.False(t, Wanted(""))
+}
+
+func TestFrom(t *testing.T) {
+
+	s := []pocket.SParam{
+		{Freq: 100, S11: pocket.Complex{Real: 1, Imag: 2}, S22: pocket.Complex{Imag: -1}},
+		{Freq: 200, S21: pocket.Complex{Real: 0.5}},
+	}
+
+	c := From(s)
+
+	assert.Equal(t, []uint64{100, 200}, c.Freq)
+	assert.Equal(t, []float64{1, 0}, c.S11.Real)
+	assert.Equal(t, []float64{2, 0}, c.S11.Imag)
+	assert.Equal(t, []float64{0, 0.5}, c.S21.Real)
+	assert.Equal(t, []float64{-1, 0}, c.S22.Imag)
+	assert.Equal(t, "uint64", c.DTypes["freq"])
+
+	// empty arrays, not null, so that they load
+	b, err := json.Marshal(From(nil))
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"freq":[]`)
+	assert.Contains(t, string(b), `"s12":{"real":[],"imag":[]}`)
+}
@@ -0,0 +1,622 @@
+// package config loads the settings for the vna daemon from a YAML file, with
+// any VNA_* environment variables taking precedence over the file. The keys
+// in the file are the names of the environment variables without the prefix,
+// in lower case, e.g. VNA_TIMEOUT_USB is timeout_usb.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/calkit"
+	"github.com/practable/pocket-vna-two-port/pkg/fleet"
+	"github.com/practable/pocket-vna-two-port/pkg/measure"
+	"github.com/practable/pocket-vna-two-port/pkg/pathloss"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/rfusb"
+	"github.com/practable/pocket-vna-two-port/pkg/store"
+	"github.com/practable/pocket-vna-two-port/pkg/stream"
+	"github.com/practable/pocket-vna-two-port/pkg/webhook"
+	"gopkg.in/yaml.v3"
+)
+
+// Prefix is the prefix of the environment variables that override the file
+const Prefix = "VNA_"
+
+// Config holds the daemon settings
+type Config struct {
+	Addr              string   `yaml:"addr" json:"addr"`                               // host:port of the calibration service
+	AuditLog          string   `yaml:"audit_log" json:"audit_log"`                     // file to record every request in, empty for none
+	Baud              int      `yaml:"baud" json:"baud"`                               // baud rate of the rf switch
+	CalKit            string   `yaml:"calkit" json:"calkit"`                           // cal kit definition, empty if the standards are ideal
+	CrashDir          string   `yaml:"crash_dir" json:"crash_dir"`                     // directory to write a report to when a request panics, empty for none
+	Fallback          bool     `yaml:"fallback" json:"fallback"`                       // crq returns raw results, flagged as uncorrected, when the calibration service cannot be reached
+	FleetInterval     string   `yaml:"fleet_interval" json:"fleet_interval"`           // how often the health of the rig is reported to fleet_url
+	FleetName         string   `yaml:"fleet_name" json:"fleet_name"`                   // name of the rig in health reports, empty for the hostname
+	FleetToken        string   `yaml:"fleet_token" json:"fleet_token"`                 // sent to fleet_url as a bearer token, unless empty
+	FleetURL          string   `yaml:"fleet_url" json:"fleet_url"`                     // http(s) address to POST the health of the rig to, empty for none
+	FreqClamp         bool     `yaml:"freq_clamp" json:"freq_clamp"`                   // move sweeps outside the allowed frequencies inside them, rather than reject them
+	FreqGuard         uint64   `yaml:"freq_guard" json:"freq_guard"`                   // Hz to keep away from each end of the range of the VNA, where it is less accurate, 0 for none
+	FreqMax           uint64   `yaml:"freq_max" json:"freq_max"`                       // highest frequency that may be measured (Hz), 0 for that of the VNA
+	FreqMin           uint64   `yaml:"freq_min" json:"freq_min"`                       // lowest frequency that may be measured (Hz), 0 for that of the VNA
+	GRPC              string   `yaml:"grpc" json:"grpc"`                               // host:port to serve the gRPC measurement API on, empty for none
+	Listen            string   `yaml:"listen" json:"listen"`                           // host:port to serve the stream on, instead of connecting to topic, empty for none
+	LogFile           string   `yaml:"log_file" json:"log_file"`                       // path, or stdout
+	LogFormat         string   `yaml:"log_format" json:"log_format"`                   // json or text
+	LogLevel          string   `yaml:"log_level" json:"log_level"`                     // trace, debug, info, warn, error, fatal or panic
+	MaxMessage        int      `yaml:"max_message" json:"max_message"`                 // largest message sent on the stream, in bytes, larger responses are split up, 0 for no limit
+	PathLoss          string   `yaml:"path_loss" json:"path_loss"`                     // loss and phase of each switch path, to remove from raw results on request, empty for none
+	Presets           string   `yaml:"presets" json:"presets"`                         // named sweeps that an rq or rc can use, e.g. full=1e6-4e9/201,uhf=300e6-1e9/101/log
+	Port              string   `yaml:"port" json:"port"`                               // serial port of the rf switch, its gpio pins, i2c bus@address, or controller host:port
+	ReplayTTL         string   `yaml:"replay_ttl" json:"replay_ttl"`                   // how long a response is kept to send again to a request with the same id, 0s for never
+	Sessions          string   `yaml:"sessions" json:"sessions"`                       // shared, to send every response to every client of the served stream, or addressed, to send each only to its session
+	Settle            string   `yaml:"settle" json:"settle"`                           // settling time after every switch change
+	SettlePorts       string   `yaml:"settle_ports" json:"settle_ports"`               // settling time by switch position, e.g. thru=100ms,dut1=100ms
+	SpectatorTopic    string   `yaml:"spectator_topic" json:"spectator_topic"`         // websocket address of a read-only topic that every message sent is mirrored to, empty for none
+	Store             string   `yaml:"store" json:"store"`                             // directory, sqlite:// or s3:// to keep the audit log and saved cals in, empty for none
+	Switch            string   `yaml:"switch" json:"switch"`                           // driver for the rf switch: usb, gpio, i2c, tcp or udp
+	SwitchRated       uint64   `yaml:"switch_rated" json:"switch_rated"`               // moves of the switch to any one position at which it should be replaced, 0 for no limit
+	SwitchTerms       []string `yaml:"switch_terms" json:"switch_terms"`               // reciprocal devices measured with the thru, to find the switch terms
+	SwitchTraffic     bool     `yaml:"switch_traffic" json:"switch_traffic"`           // record the bytes to and from the switch controller from the start, see switchtraffic
+	SwitchTrafficFile string   `yaml:"switch_traffic_file" json:"switch_traffic_file"` // file to append the switch traffic to while it is recorded, empty to keep it in memory only
+	SwitchWear        string   `yaml:"switch_wear" json:"switch_wear"`                 // file to keep the counts of the moves of the switch in, across restarts, empty to keep them in memory only
+	TimeoutUSB        string   `yaml:"timeout_usb" json:"timeout_usb"`                 // serial comms with the rf switch
+	TLSCert           string   `yaml:"tls_cert" json:"tls_cert"`                       // certificate file, to serve the stream over wss
+	TLSKey            string   `yaml:"tls_key" json:"tls_key"`                         // key file, to serve the stream over wss
+	Token             string   `yaml:"token" json:"token"`                             // clients of the served stream must give this, unless empty
+	TimeoutRequest    string   `yaml:"timeout_request" json:"timeout_request"`         // the longest any one request may take
+	TimeoutCmds       string   `yaml:"timeout_cmds" json:"timeout_cmds"`               // timeouts by command, hard then optionally soft, e.g. rc=10m/1m,crq=1m
+	TimeoutSoft       string   `yaml:"timeout_soft" json:"timeout_soft"`               // how long a request runs before a working message is sent, and again each time after, 0s for none
+	Topic             string   `yaml:"topic" json:"topic"`                             // websocket address of the data stream
+	USBReset          string   `yaml:"usb_reset" json:"usb_reset"`                     // command to power cycle the USB port of the VNA when it is reset, e.g. uhubctl, empty for none
+	Warmup            string   `yaml:"warmup" json:"warmup"`                           // how long the VNA takes to warm up after it is opened, during which a cal is warned about, 0s for no warm-up
+	WarmupRefuse      bool     `yaml:"warmup_refuse" json:"warmup_refuse"`             // refuse rc and mc while the VNA is warming up, rather than warn
+	Watchdog          string   `yaml:"watchdog" json:"watchdog"`                       // longest any one VNA operation may take before the VNA is reset, 0s for no watchdog
+	WebhookCmds       []string `yaml:"webhook_cmds" json:"webhook_cmds"`               // commands whose responses are posted to webhook_url as well as sent on the stream
+	WebhookToken      string   `yaml:"webhook_token" json:"webhook_token"`             // sent to webhook_url as a bearer token, unless empty
+	WebhookURL        string   `yaml:"webhook_url" json:"webhook_url"`                 // http(s) address to POST the responses to webhook_cmds to, empty for none
+}
+
+// Default returns the settings used when neither the file nor the environment sets them
+func Default() Config {
+	return Config{
+		Addr:           "localhost:9001",
+		Baud:           57600,
+		CrashDir:       "/var/log/vna/crash",
+		FleetInterval:  "1m",
+		LogFile:        "/var/log/vna/vna.log",
+		LogFormat:      "json",
+		LogLevel:       "warn",
+		MaxMessage:     stream.DefaultMaxMessage,
+		Port:           "/dev/ttyUSB0",
+		ReplayTTL:      "0s",
+		Sessions:       "shared",
+		Settle:         "0s",
+		Switch:         "usb",
+		TimeoutUSB:     "30s",
+		TimeoutRequest: "3m",
+		TimeoutSoft:    "30s",
+		Topic:          "ws://localhost:8888/ws/data",
+		Warmup:         "0s",
+		Watchdog:       "0s",
+	}
+}
+
+// Load reads the settings from the YAML file at path, over the defaults, then
+// applies any environment variables. An empty path uses the defaults and environment only.
+// The result is checked before it is returned.
+func Load(path string) (Config, error) {
+
+	c := Default()
+
+	if path != "" {
+
+		b, err := os.ReadFile(path)
+
+		if err != nil {
+			return c, err
+		}
+
+		d := yaml.NewDecoder(bytes.NewReader(b))
+		d.KnownFields(true) // catch misspelt keys rather than silently using the default
+
+		err = d.Decode(&c)
+
+		// an empty file is not an error, it just means the defaults
+		if err != nil && !errors.Is(err, io.EOF) {
+			return c, fmt.Errorf("could not read config %s because %s", path, err.Error())
+		}
+	}
+
+	err := c.FromEnv(os.LookupEnv)
+
+	if err != nil {
+		return c, err
+	}
+
+	err = c.Check()
+
+	if err != nil {
+		if path != "" {
+			return c, fmt.Errorf("config %s is not valid because %s", path, err.Error())
+		}
+		return c, fmt.Errorf("config is not valid because %s", err.Error())
+	}
+
+	return c, nil
+}
+
+// FromEnv overrides each setting that has an environment variable, e.g. VNA_BAUD for baud.
+// lookup is normally os.LookupEnv. Lists are comma separated, and true or false as strconv.ParseBool.
+func (c *Config) FromEnv(lookup func(string) (string, bool)) error {
+
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+
+		key := t.Field(i).Tag.Get("yaml")
+		name := Prefix + strings.ToUpper(key)
+
+		s, ok := lookup(name)
+
+		if !ok {
+			continue
+		}
+
+		f := v.Field(i)
+
+		switch f.Kind() {
+		case reflect.String:
+			f.SetString(s)
+		case reflect.Int:
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("%s=%s is not a whole number", name, s)
+			}
+			f.SetInt(int64(n))
+		case reflect.Uint64:
+			n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+			if err != nil {
+				return fmt.Errorf("%s=%s is not a whole number", name, s)
+			}
+			f.SetUint(n)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(strings.TrimSpace(s))
+			if err != nil {
+				return fmt.Errorf("%s=%s is not true or false", name, s)
+			}
+			f.SetBool(b)
+		case reflect.Slice:
+			var items []string
+			for _, item := range strings.Split(s, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					items = append(items, item)
+				}
+			}
+			f.Set(reflect.ValueOf(items))
+		}
+	}
+
+	return nil
+}
+
+// Check returns an error describing every setting that is not valid, or nil if they all are
+func (c Config) Check() error {
+
+	var msg []string
+
+	if c.Addr == "" {
+		msg = append(msg, "addr must be given as host:port")
+	}
+
+	if c.Baud <= 0 {
+		msg = append(msg, fmt.Sprintf("baud must be positive, not %d", c.Baud))
+	}
+
+	if c.CalKit != "" {
+		if _, err := calkit.Load(c.CalKit); err != nil {
+			msg = append(msg, "calkit cannot be loaded because "+err.Error())
+		}
+	}
+
+	if c.AuditLog != "" {
+		if _, err := os.Stat(filepath.Dir(c.AuditLog)); err != nil {
+			msg = append(msg, "audit_log cannot be written because "+err.Error())
+		}
+	}
+
+	if c.SwitchTrafficFile != "" {
+		if _, err := os.Stat(filepath.Dir(c.SwitchTrafficFile)); err != nil {
+			msg = append(msg, "switch_traffic_file cannot be written because "+err.Error())
+		}
+	}
+
+	if c.SwitchWear != "" {
+		if _, err := os.Stat(filepath.Dir(c.SwitchWear)); err != nil {
+			msg = append(msg, "switch_wear cannot be written because "+err.Error())
+		}
+	}
+
+	if c.Store != "" {
+		if err := store.Check(c.Store); err != nil {
+			msg = append(msg, "store is not valid because "+err.Error())
+		}
+	}
+
+	if c.PathLoss != "" {
+		if _, err := pathloss.Load(c.PathLoss); err != nil {
+			msg = append(msg, "path_loss cannot be loaded because "+err.Error())
+		}
+	}
+
+	if c.FleetURL != "" {
+		if u, err := url.Parse(c.FleetURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			msg = append(msg, "fleet_url must be an http or https address, or empty, not "+c.FleetURL)
+		}
+	}
+
+	if d, err := time.ParseDuration(c.FleetInterval); err != nil || d <= 0 {
+		msg = append(msg, fmt.Sprintf("fleet_interval must be a positive duration such as 1m, not %q", c.FleetInterval))
+	}
+
+	if c.WebhookURL != "" {
+
+		if u, err := url.Parse(c.WebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			msg = append(msg, "webhook_url must be an http or https address, or empty, not "+c.WebhookURL)
+		}
+
+		if len(c.WebhookCmds) == 0 {
+			msg = append(msg, "webhook_cmds must list the commands whose responses are posted to webhook_url, e.g. crq,rq")
+		}
+	}
+
+	if _, err := webhook.New(c.WebhookURL, c.WebhookToken, c.WebhookCmds); err != nil {
+		msg = append(msg, "webhook_cmds is not valid because "+err.Error())
+	}
+
+	if c.GRPC != "" {
+		if _, _, err := net.SplitHostPort(c.GRPC); err != nil {
+			msg = append(msg, "grpc must be the host:port to serve on, or empty, not "+c.GRPC)
+		}
+	}
+
+	if c.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+			msg = append(msg, "listen must be the host:port to serve the stream on, or empty, not "+c.Listen)
+		}
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		msg = append(msg, "tls_cert and tls_key must be given together")
+	}
+
+	for _, f := range []struct{ key, path string }{{"tls_cert", c.TLSCert}, {"tls_key", c.TLSKey}} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			msg = append(msg, f.key+" cannot be read because "+err.Error())
+		}
+	}
+
+	if c.LogFile == "" {
+		msg = append(msg, "log_file must be a path, or stdout")
+	}
+
+	switch strings.ToLower(c.LogFormat) {
+	case "json", "text":
+	default:
+		msg = append(msg, "log_format can be json or text but not "+c.LogFormat)
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "trace", "debug", "info", "warn", "error", "fatal", "panic":
+	default:
+		msg = append(msg, "log_level can be trace, debug, info, warn, error, fatal or panic but not "+c.LogLevel)
+	}
+
+	if c.FreqMax != 0 && c.FreqMax <= c.FreqMin {
+		msg = append(msg, fmt.Sprintf("freq_max must be above freq_min (%d Hz), or 0 for no limit, not %d", c.FreqMin, c.FreqMax))
+	}
+
+	if c.MaxMessage != 0 && c.MaxMessage < stream.MinMessage {
+		msg = append(msg, fmt.Sprintf("max_message must be at least %d bytes, or 0 for no limit, not %d", stream.MinMessage, c.MaxMessage))
+	}
+
+	switch strings.ToLower(c.Switch) {
+	case "usb":
+		if c.Port == "" {
+			msg = append(msg, "port must be given, e.g. /dev/ttyUSB0")
+		}
+	case "gpio":
+		if _, err := rfusb.ParsePins(c.Port); err != nil {
+			msg = append(msg, "port "+err.Error())
+		}
+	case "i2c":
+		if _, _, err := rfusb.ParseI2C(c.Port); err != nil {
+			msg = append(msg, "port "+err.Error())
+		}
+	case "tcp", "udp":
+		if _, _, err := net.SplitHostPort(c.Port); err != nil {
+			msg = append(msg, "port must be the host:port of the switch controller, not "+c.Port)
+		}
+	default:
+		msg = append(msg, "switch can be usb, gpio, i2c, tcp or udp but not "+c.Switch)
+	}
+
+	switch strings.ToLower(c.Sessions) {
+	case "shared":
+	case "addressed":
+		if c.Listen == "" {
+			msg = append(msg, "sessions can only be addressed when the stream is served with listen, because the relay sends every response to everyone")
+		}
+	default:
+		msg = append(msg, "sessions can be shared or addressed but not "+c.Sessions)
+	}
+
+	durations := []struct {
+		key   string
+		value string
+	}{
+		{"replay_ttl", c.ReplayTTL},
+		{"settle", c.Settle},
+		{"timeout_usb", c.TimeoutUSB},
+		{"timeout_request", c.TimeoutRequest},
+		{"timeout_soft", c.TimeoutSoft},
+		{"warmup", c.Warmup},
+		{"watchdog", c.Watchdog},
+	}
+
+	for _, d := range durations {
+		if _, err := time.ParseDuration(d.value); err != nil {
+			msg = append(msg, fmt.Sprintf("%s must be a duration such as 100ms or 3m, not %q", d.key, d.value))
+		}
+	}
+
+	// a zero timeout would stop every request as soon as it started
+	if d, err := time.ParseDuration(c.TimeoutRequest); err == nil && d <= 0 {
+		msg = append(msg, fmt.Sprintf("timeout_request must be a positive duration such as 3m, not %q", c.TimeoutRequest))
+	}
+
+	if c.USBReset != "" {
+		if w, _ := time.ParseDuration(c.Watchdog); w <= 0 {
+			msg = append(msg, "usb_reset is only used by the watchdog, so watchdog must be set too")
+		}
+	}
+
+	if _, err := measure.ParseSettle(c.SettlePorts); err != nil {
+		msg = append(msg, "settle_ports "+err.Error())
+	}
+
+	if _, err := measure.ParsePresets(c.Presets); err != nil {
+		msg = append(msg, "presets "+err.Error())
+	}
+
+	if _, err := ParseTimeouts(c.TimeoutCmds, 0); err != nil {
+		msg = append(msg, "timeout_cmds "+err.Error())
+	}
+
+	// the topic is not used when the stream is served
+	if c.Listen == "" {
+
+		u, err := url.Parse(c.Topic)
+
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			msg = append(msg, "topic must be a websocket address such as ws://localhost:8888/ws/data, not "+c.Topic)
+		}
+	}
+
+	if c.SpectatorTopic != "" {
+
+		u, err := url.Parse(c.SpectatorTopic)
+
+		if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") || u.Host == "" {
+			msg = append(msg, "spectator_topic must be a websocket address such as ws://localhost:8888/ws/spectate, not "+c.SpectatorTopic)
+		} else if c.Listen == "" && c.SpectatorTopic == c.Topic {
+			msg = append(msg, "spectator_topic must not be the topic, or spectators could send commands")
+		}
+	}
+
+	if len(msg) > 0 {
+		return errors.New(strings.Join(msg, "; "))
+	}
+
+	return nil
+}
+
+// Addressed returns whether responses are sent only to the session that made the request. Call Check first.
+func (c Config) Addressed() bool {
+	return strings.EqualFold(c.Sessions, "addressed")
+}
+
+// Ceiling returns the longest any one VNA operation may take, zero for no watchdog. Call Check first.
+func (c Config) Ceiling() time.Duration {
+	d, _ := time.ParseDuration(c.Watchdog)
+	return d
+}
+
+// Replay returns how long a response is kept to send again to a request with the same id, zero for never. Call Check first.
+func (c Config) Replay() time.Duration {
+	d, _ := time.ParseDuration(c.ReplayTTL)
+	return d
+}
+
+// Fleet returns where to report the health of the rig, nil if it is not reported. Call Check first.
+func (c Config) Fleet() *fleet.Reporter {
+
+	if c.FleetURL == "" {
+		return nil
+	}
+
+	d, _ := time.ParseDuration(c.FleetInterval)
+
+	return fleet.New(c.FleetURL, c.FleetToken, c.FleetName, d)
+}
+
+// Webhook returns where to post the responses to chosen commands, nil if they are not posted. Call Check first.
+func (c Config) Webhook() *webhook.Hook {
+
+	if c.WebhookURL == "" {
+		return nil
+	}
+
+	h, _ := webhook.New(c.WebhookURL, c.WebhookToken, c.WebhookCmds)
+
+	return h
+}
+
+// Guard returns the frequencies that may be measured, and whether sweeps outside are clamped
+func (c Config) Guard() pocket.Guard {
+	return pocket.Guard{
+		Min:   c.FreqMin,
+		Max:   c.FreqMax,
+		Band:  c.FreqGuard,
+		Clamp: c.FreqClamp,
+	}
+}
+
+// Warming returns how long the VNA takes to warm up, and whether a cal is refused until then. Call Check first.
+func (c Config) Warming() (time.Duration, bool) {
+	d, _ := time.ParseDuration(c.Warmup)
+	return d, c.WarmupRefuse
+}
+
+// Durations returns the settling time, USB timeout and request timeout. Call Check first.
+func (c Config) Durations() (settle, timeoutUSB, timeoutRequest time.Duration) {
+	settle, _ = time.ParseDuration(c.Settle)
+	timeoutUSB, _ = time.ParseDuration(c.TimeoutUSB)
+	timeoutRequest, _ = time.ParseDuration(c.TimeoutRequest)
+	return settle, timeoutUSB, timeoutRequest
+}
+
+// Timeout is how long a request may take before it is stopped (Hard), and how
+// long it runs before the user is told it is still working (Soft), zero for never
+type Timeout struct {
+	Hard time.Duration
+	Soft time.Duration
+}
+
+// Timeouts returns the default timeouts for a request, and those for each command
+// that has its own, by the name it is given in the response. Call Check first.
+func (c Config) Timeouts() (Timeout, map[string]Timeout) {
+	_, _, hard := c.Durations()
+	soft, _ := time.ParseDuration(c.TimeoutSoft)
+	cmds, _ := ParseTimeouts(c.TimeoutCmds, soft)
+	return Timeout{Hard: hard, Soft: soft}, cmds
+}
+
+// ParseTimeouts parses timeouts by command of the form "rc=10m/1m,crq=1m". Each hard
+// timeout may be followed by a soft one, and soft is used for those that have none. Any
+// name or alias of a command can be used, and the result is keyed by the name it is
+// given in the response.
+func ParseTimeouts(s string, soft time.Duration) (map[string]Timeout, error) {
+
+	timeouts := make(map[string]Timeout)
+
+	for _, item := range strings.Split(s, ",") {
+
+		item = strings.TrimSpace(item)
+
+		if item == "" {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+
+		if len(kv) != 2 {
+			return timeouts, fmt.Errorf("timeout %s is not of the form cmd=hard or cmd=hard/soft", item)
+		}
+
+		name := strings.TrimSpace(kv[0])
+
+		cmd, ok := pocket.Lookup(name)
+
+		if !ok {
+			return timeouts, fmt.Errorf("timeout is for %s, which is not a command", name)
+		}
+
+		t := Timeout{Soft: soft}
+
+		hs := strings.SplitN(kv[1], "/", 2)
+
+		d, err := time.ParseDuration(strings.TrimSpace(hs[0]))
+
+		if err != nil || d <= 0 {
+			return timeouts, fmt.Errorf("timeout for %s must be a positive duration such as 10m, not %q", name, hs[0])
+		}
+
+		t.Hard = d
+
+		if len(hs) == 2 {
+
+			d, err = time.ParseDuration(strings.TrimSpace(hs[1]))
+
+			if err != nil || d < 0 {
+				return timeouts, fmt.Errorf("soft timeout for %s must be a duration such as 1m, or 0s for none, not %q", name, hs[1])
+			}
+
+			t.Soft = d
+		}
+
+		timeouts[cmd] = t
+	}
+
+	return timeouts, nil
+}
+
+// Restart lists the settings that only take effect when the daemon is restarted,
+// because they are used to open the connections to the rf switch, VNA, calibration
+// service and stream, or the log file. All others can be changed by a reload.
+var Restart = []string{"addr", "audit_log", "baud", "grpc", "listen", "log_file", "port", "sessions", "spectator_topic", "store", "switch", "switch_wear", "timeout_usb", "tls_cert", "tls_key", "token", "topic", "usb_reset", "watchdog"}
+
+// Apply compares the settings loaded for a reload with those running. It returns
+// the settings to run with, which are the loaded ones except for any that need a
+// restart, along with the keys that changed and can be applied now, and the keys
+// that changed but need a restart.
+func Apply(running, loaded Config) (Config, []string, []string) {
+
+	restart := make(map[string]bool)
+
+	for _, k := range Restart {
+		restart[k] = true
+	}
+
+	var live, later []string
+
+	next := loaded
+
+	r := reflect.ValueOf(running)
+	l := reflect.ValueOf(loaded)
+	n := reflect.ValueOf(&next).Elem()
+	t := r.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+
+		if reflect.DeepEqual(r.Field(i).Interface(), l.Field(i).Interface()) {
+			continue
+		}
+
+		key := t.Field(i).Tag.Get("yaml")
+
+		if restart[key] {
+			later = append(later, key)
+			n.Field(i).Set(r.Field(i)) // keep running with the old value
+			continue
+		}
+
+		live = append(live, key)
+	}
+
+	return next, live, later
+}
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+}
+
+func TestDefault(t *testing.T) {
+	assert.NoError(t, Default().Check())
+}
+
+func TestFromEnv(t *testing.T) {
+
+	c := Default()
+
+	err := c.FromEnv(env(map[string]string{
+		"VNA_BAUD":         "115200",
+		"VNA_TIMEOUT_USB":  "10s",
+		"VNA_SWITCH_TERMS": "dut1, dut3",
+	}))
+
+	assert.NoError(t, err)
+	assert.Equal(t, 115200, c.Baud)
+	assert.Equal(t, "10s", c.TimeoutUSB)
+	assert.Equal(t, []string{"dut1", "dut3"}, c.SwitchTerms)
+	assert.Equal(t, "localhost:9001", c.Addr)
+
+	err = c.FromEnv(env(map[string]string{"VNA_BAUD": "fast"}))
+	assert.Error(t, err)
+
+	err = c.FromEnv(env(map[string]string{"VNA_FALLBACK": "true"}))
+	assert.NoError(t, err)
+	assert.True(t, c.Fallback)
+
+	err = c.FromEnv(env(map[string]string{"VNA_FALLBACK": "maybe"}))
+	assert.Error(t, err)
+
+	err = c.FromEnv(env(map[string]string{"VNA_FREQ_MAX": "3000000000", "VNA_FREQ_GUARD": "10000000", "VNA_FREQ_CLAMP": "true"}))
+	assert.NoError(t, err)
+	assert.Equal(t, pocket.Guard{Max: 3e9, Band: 10e6, Clamp: true}, c.Guard())
+
+	err = c.FromEnv(env(map[string]string{"VNA_FREQ_MIN": "-1"}))
+	assert.Error(t, err)
+
+	err = c.FromEnv(env(map[string]string{"VNA_WARMUP": "10m", "VNA_WARMUP_REFUSE": "true"}))
+	assert.NoError(t, err)
+	period, refuse := c.Warming()
+	assert.Equal(t, 10*time.Minute, period)
+	assert.True(t, refuse)
+}
+
+func TestCheck(t *testing.T) {
+
+	c := Default()
+	c.Baud = 0
+	c.TimeoutUSB = "30"
+	c.Topic = "localhost:8888"
+	c.LogLevel = "loud"
+	c.GRPC = "9002"
+	c.TLSCert = "/no/such/cert.pem"
+	c.MaxMessage = 100
+	c.PathLoss = "/no/such/pathloss.json"
+	c.AuditLog = "/no/such/dir/audit.log"
+	c.FreqMin = 2e9
+	c.FreqMax = 1e9
+	c.Presets = "full=1e6-4e9"
+	c.Store = "ftp://host/vna"
+	c.SwitchWear = "/no/such/dir/wear.json"
+	c.SwitchTrafficFile = "/no/such/dir/traffic.log"
+
+	err := c.Check()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "baud")
+	assert.Contains(t, err.Error(), "timeout_usb")
+	assert.Contains(t, err.Error(), "topic")
+	assert.Contains(t, err.Error(), "grpc")
+	assert.Contains(t, err.Error(), "tls_cert and tls_key must be given together")
+	assert.Contains(t, err.Error(), "tls_cert cannot be read")
+	assert.Contains(t, err.Error(), "max_message")
+	assert.Contains(t, err.Error(), "path_loss cannot be loaded")
+	assert.Contains(t, err.Error(), "audit_log cannot be written")
+	assert.Contains(t, err.Error(), "freq_max must be above freq_min")
+	assert.Contains(t, err.Error(), "presets preset full=1e6-4e9 is not of the form")
+	assert.Contains(t, err.Error(), "store is not valid")
+	assert.Contains(t, err.Error(), "switch_wear cannot be written")
+	assert.Contains(t, err.Error(), "switch_traffic_file cannot be written")
+
+	// the topic is not needed when the stream is served
+	c = Default()
+	c.Topic = ""
+	assert.Error(t, c.Check())
+	c.Listen = ":8888"
+	assert.NoError(t, c.Check())
+	assert.Contains(t, err.Error(), "log_level")
+
+	// spectators get a topic of their own, which they cannot send commands on
+	c = Default()
+	c.SpectatorTopic = "localhost:8888/ws/spectate"
+	assert.Contains(t, c.Check().Error(), "spectator_topic must be a websocket address")
+	c.SpectatorTopic = c.Topic
+	assert.Contains(t, c.Check().Error(), "spectator_topic must not be the topic")
+	c.SpectatorTopic = "ws://localhost:8888/ws/spectate"
+	assert.NoError(t, c.Check())
+
+	// there is no sqlite driver built in, so an sqlite store would fail at startup
+	c = Default()
+	c.Store = "sqlite:///var/lib/vna/store.db"
+	assert.Contains(t, c.Check().Error(), "no sqlite3 driver built in")
+	c.Store = t.TempDir()
+	assert.NoError(t, c.Check())
+
+	// the usb port is only power cycled by the watchdog
+	c = Default()
+	c.USBReset = "uhubctl -l 1-1 -p 2 -a cycle"
+	assert.Error(t, c.Check())
+	c.Watchdog = "1m"
+	assert.NoError(t, c.Check())
+	assert.Equal(t, time.Minute, c.Ceiling())
+
+	// health is only reported to a web address, at a positive interval
+	c = Default()
+	assert.Nil(t, c.Fleet())
+	c.FleetURL = "fleet.example.org/health"
+	c.FleetInterval = "0s"
+	err = c.Check()
+	assert.Contains(t, err.Error(), "fleet_url")
+	assert.Contains(t, err.Error(), "fleet_interval")
+	c.FleetURL = "https://fleet.example.org/health"
+	c.FleetInterval = "30s"
+	c.FleetName = "rig7"
+	assert.NoError(t, c.Check())
+	assert.Equal(t, "rig7", c.Fleet().Rig)
+	assert.Equal(t, 30*time.Second, c.Fleet().Interval)
+
+	// responses are only posted to a web address, for commands that are known
+	c = Default()
+	assert.Nil(t, c.Webhook())
+	c.WebhookURL = "https://grader.example.org/results"
+	err = c.Check()
+	assert.Contains(t, err.Error(), "webhook_cmds must list")
+	c.WebhookCmds = []string{"crq", "nope"}
+	err = c.Check()
+	assert.Contains(t, err.Error(), "there is no command called nope")
+	c.WebhookCmds = []string{"crq", "rangequery"}
+	assert.NoError(t, c.Check())
+	assert.True(t, c.Webhook().Wants("rq"))
+	assert.False(t, c.Webhook().Wants("rc"))
+	c.Watchdog = "soon"
+	assert.Error(t, c.Check())
+
+	c = Default()
+	c.Warmup = "ten minutes"
+	assert.Error(t, c.Check())
+
+	c = Default()
+	c.ReplayTTL = "5m"
+	assert.NoError(t, c.Check())
+	assert.Equal(t, 5*time.Minute, c.Replay())
+	c.ReplayTTL = "5"
+	assert.Error(t, c.Check())
+
+	// responses can only be addressed by the stream server
+	c = Default()
+	c.Sessions = "addressed"
+	assert.Error(t, c.Check())
+	c.Listen = ":8888"
+	assert.NoError(t, c.Check())
+	assert.True(t, c.Addressed())
+	c.Sessions = "private"
+	assert.Error(t, c.Check())
+
+	// the port depends on the switch driver
+	c = Default()
+	c.Switch = "gpio"
+	assert.Error(t, c.Check())
+	c.Port = "8,9,10,4,5,6"
+	assert.NoError(t, c.Check())
+
+	c.Switch = "i2c"
+	assert.Error(t, c.Check())
+	c.Port = "/dev/i2c-1@0x20"
+	assert.NoError(t, c.Check())
+
+	c.Switch = "tcp"
+	assert.Error(t, c.Check())
+	c.Port = "192.168.1.20:9000"
+	assert.NoError(t, c.Check())
+
+	c.Switch = "spi"
+	assert.Error(t, c.Check())
+}
+
+func TestTimeouts(t *testing.T) {
+
+	c := Default()
+	c.TimeoutCmds = "rangecal=10m/1m, crq=1m"
+
+	assert.NoError(t, c.Check())
+
+	def, cmds := c.Timeouts()
+	assert.Equal(t, Timeout{Hard: 3 * time.Minute, Soft: 30 * time.Second}, def)
+	assert.Equal(t, map[string]Timeout{
+		"rc":  {Hard: 10 * time.Minute, Soft: time.Minute},
+		"crq": {Hard: time.Minute, Soft: 30 * time.Second},
+	}, cmds)
+
+	c.TimeoutSoft = "0s"
+	c.TimeoutCmds = "rc=10m/0s"
+	assert.NoError(t, c.Check())
+
+	def, cmds = c.Timeouts()
+	assert.Equal(t, time.Duration(0), def.Soft)
+	assert.Equal(t, Timeout{Hard: 10 * time.Minute}, cmds["rc"])
+
+	for _, bad := range []string{"rc", "rc=", "rc=soon", "rc=0s", "rc=-1m", "rc=1m/soon", "fly=1m"} {
+		c.TimeoutCmds = bad
+		err := c.Check()
+		assert.Error(t, err, bad)
+		if err != nil {
+			assert.Contains(t, err.Error(), "timeout_cmds", bad)
+		}
+	}
+
+	c.TimeoutCmds = ""
+	c.TimeoutSoft = "later"
+	assert.Error(t, c.Check())
+
+	// a zero hard timeout would stop every request at once
+	c.TimeoutSoft = "30s"
+	c.TimeoutRequest = "0s"
+	err := c.Check()
+	assert.Error(t, err)
+	if err != nil {
+		assert.Contains(t, err.Error(), "timeout_request")
+	}
+}
+
+func TestLoad(t *testing.T) {
+
+	dir := t.TempDir()
+
+	good := filepath.Join(dir, "good.yaml")
+	err := os.WriteFile(good, []byte("baud: 9600\nsettle_ports: thru=100ms\nswitch_terms:\n  - dut1\n  - dut3\n"), 0644)
+	assert.NoError(t, err)
+
+	c, err := Load(good)
+	assert.NoError(t, err)
+	assert.Equal(t, 9600, c.Baud)
+	assert.Equal(t, "thru=100ms", c.SettlePorts)
+	assert.Equal(t, []string{"dut1", "dut3"}, c.SwitchTerms)
+	assert.Equal(t, "/dev/ttyUSB0", c.Port)
+
+	settle, timeoutUSB, _ := c.Durations()
+	assert.Equal(t, "0s", settle.String())
+	assert.Equal(t, "30s", timeoutUSB.String())
+
+	empty := filepath.Join(dir, "empty.yaml")
+	err = os.WriteFile(empty, []byte(""), 0644)
+	assert.NoError(t, err)
+
+	c, err = Load(empty)
+	assert.NoError(t, err)
+	assert.Equal(t, Default(), c)
+
+	typo := filepath.Join(dir, "typo.yaml")
+	err = os.WriteFile(typo, []byte("buad: 9600\n"), 0644)
+	assert.NoError(t, err)
+
+	_, err = Load(typo)
+	assert.Error(t, err)
+
+	_, err = Load(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestApply(t *testing.T) {
+
+	running := Default()
+
+	loaded := Default()
+	loaded.LogLevel = "debug"
+	loaded.TimeoutRequest = "5m"
+	loaded.Port = "/dev/ttyUSB1"
+
+	next, live, restart := Apply(running, loaded)
+
+	assert.Equal(t, []string{"log_level", "timeout_request"}, live)
+	assert.Equal(t, []string{"port"}, restart)
+	assert.Equal(t, "debug", next.LogLevel)
+	assert.Equal(t, "5m", next.TimeoutRequest)
+	assert.Equal(t, "/dev/ttyUSB0", next.Port)
+
+	_, live, restart = Apply(running, running)
+	assert.Nil(t, live)
+	assert.Nil(t, restart)
+}
@@ -0,0 +1,60 @@
+// package crash writes a report when handling a request panics, with the stack,
+// the request and the log lines leading up to it, so that the bug can be found
+// later, while the daemon carries on serving everyone else.
+package crash
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Report describes a panic
+type Report struct {
+	Time    time.Time   `json:"time"`
+	Panic   string      `json:"panic"`   // the value the panic was called with
+	Request interface{} `json:"request"` // the request that was being handled
+	Stack   string      `json:"stack"`   // of the goroutine that panicked
+	Log     []string    `json:"log,omitempty"`
+}
+
+// New returns a report of a panic with value p, e.g. from recover, while
+// handling request. Give it the stack from debug.Stack, and any recent log lines.
+func New(p interface{}, request interface{}, stack []byte, log []string) Report {
+	return Report{
+		Time:    time.Now().UTC(),
+		Panic:   fmt.Sprint(p),
+		Request: request,
+		Stack:   string(stack),
+		Log:     log,
+	}
+}
+
+// Write writes r to a new JSON file in dir, named from its time, creating dir
+// if there is none, and returns the path of the file
+func Write(dir string, r Report) (string, error) {
+
+	err := os.MkdirAll(dir, 0755)
+
+	if err != nil {
+		return "", err
+	}
+
+	b, err := json.MarshalIndent(r, "", "  ")
+
+	if err != nil {
+		// the request itself may be what cannot be marshalled
+		r.Request = fmt.Sprintf("%+v", r.Request)
+		b, err = json.MarshalIndent(r, "", "  ")
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, "crash-"+r.Time.Format("20060102T150405.000000000Z")+".json")
+
+	return path, os.WriteFile(path, b, 0644)
+}
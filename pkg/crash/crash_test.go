@@ -0,0 +1,44 @@
+package crash
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrite(t *testing.T) {
+
+	dir := filepath.Join(t.TempDir(), "crash") // made if there is none
+
+	rq := pocket.RangeQuery{Command: pocket.Command{ID: "7", Command: "rq"}, Size: 2}
+
+	r := New("index out of range", rq, []byte("goroutine 1 [running]:\n..."), []string{"a", "b"})
+
+	path, err := Write(dir, r)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(filepath.Base(path), "crash-"))
+
+	b, err := os.ReadFile(path)
+	assert.NoError(t, err)
+
+	var got map[string]interface{}
+	assert.NoError(t, json.Unmarshal(b, &got))
+	assert.Equal(t, "index out of range", got["panic"])
+	assert.Equal(t, "7", got["request"].(map[string]interface{})["id"])
+	assert.Contains(t, got["stack"], "goroutine 1")
+	assert.Equal(t, []interface{}{"a", "b"}, got["log"])
+
+	// a request that cannot be marshalled is written as text
+	r = New(42, func() {}, nil, nil)
+	path, err = Write(dir, r)
+	assert.NoError(t, err)
+
+	b, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"panic": "42"`)
+}
@@ -0,0 +1,179 @@
+// package fleet reports the health of a rig to a central endpoint, so that the
+// operator of many rigs can see which ones need attention without polling the
+// stream of each. A summary is POSTed as JSON at a regular interval, and a report
+// that cannot be sent is logged and dropped, since the next one supersedes it.
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+// Timeout limits each report, so that a slow endpoint does not hold up the next one
+const Timeout = 10 * time.Second
+
+// OK and Attention are the statuses of a rig, which needs attention if it has any problems
+const (
+	OK        = "ok"
+	Attention = "attention"
+)
+
+// Health summarises the state of a rig
+type Health struct {
+	Rig         string         `json:"rig"`                   // name of the rig
+	Time        time.Time      `json:"time"`                  // when the summary was made
+	Status      string         `json:"status"`                // ok, or attention if there are problems
+	Problems    []string       `json:"problems,omitempty"`    // why the rig needs attention
+	Version     string         `json:"version"`               // of the daemon
+	Protocol    int            `json:"protocol"`              // version of the stream protocol
+	Uptime      float64        `json:"uptime"`                // seconds since the daemon started
+	CalState    string         `json:"calstate"`              // where the calibration workflow is up to
+	CalAge      *float64       `json:"calage,omitempty"`      // seconds since the current cal was made, if there is one
+	Requests    int            `json:"requests"`              // handled since the daemon started
+	Errors      int            `json:"errors"`                // that failed since the daemon started
+	Recent      int            `json:"recent"`                // that failed since the last report
+	LastError   string         `json:"lasterror,omitempty"`   // of the last request that failed
+	LastErrorAt *time.Time     `json:"lasterrorat,omitempty"` // when the last request failed
+	Queue       int            `json:"queue"`                 // requests waiting to be served
+	Warmup      *pocket.Warmup `json:"warmup,omitempty"`      // whether the VNA has warmed up, if it is tracked
+}
+
+// Check sets the status from the problems
+func (h *Health) Check() {
+	h.Status = OK
+	if len(h.Problems) > 0 {
+		h.Status = Attention
+	}
+}
+
+// Reporter sends the health of a rig to URL every Interval
+type Reporter struct {
+	URL      string
+	Token    string // sent as a bearer token, unless empty
+	Rig      string
+	Interval time.Duration
+	Client   *http.Client
+}
+
+// New returns a reporter, named after the host if rig is empty
+func New(url, token, rig string, interval time.Duration) *Reporter {
+
+	if rig == "" {
+		rig, _ = os.Hostname()
+	}
+
+	return &Reporter{
+		URL:      url,
+		Token:    token,
+		Rig:      rig,
+		Interval: interval,
+		Client:   &http.Client{Timeout: Timeout},
+	}
+}
+
+// Send POSTs h to the URL, and returns an error unless it is accepted with a 2xx status
+func (r *Reporter) Send(ctx context.Context, h Health) error {
+
+	b, err := json.Marshal(h)
+
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.URL, bytes.NewReader(b))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if r.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+
+	client := r.Client
+
+	if client == nil {
+		client = &http.Client{Timeout: Timeout}
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s returned %d %s", r.URL, resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// Tally counts the requests handled, and those that failed. It is safe to use from
+// more than one goroutine, and a nil Tally counts nothing.
+type Tally struct {
+	mu          sync.Mutex
+	requests    int
+	errors      int
+	recent      int
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// Add counts a request that finished with err, nil if it succeeded
+func (t *Tally) Add(err error) {
+
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.requests++
+
+	if err != nil {
+		t.errors++
+		t.recent++
+		t.lastError = err.Error()
+		t.lastErrorAt = time.Now().UTC()
+	}
+}
+
+// Fill adds the counts to h, and starts counting the recent errors again
+func (t *Tally) Fill(h *Health) {
+
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	h.Requests = t.requests
+	h.Errors = t.errors
+	h.Recent = t.recent
+	h.LastError = t.lastError
+
+	if !t.lastErrorAt.IsZero() {
+		at := t.lastErrorAt
+		h.LastErrorAt = &at
+	}
+
+	t.recent = 0
+}
@@ -0,0 +1,79 @@
+package fleet
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSend(t *testing.T) {
+
+	var got Health
+	var auth string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if got.Rig == "reject" {
+			http.Error(w, "unknown rig", http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	r := New(srv.URL, "secret", "rig7", time.Minute)
+
+	h := Health{Rig: r.Rig, Problems: []string{"the rig is not calibrated"}}
+	h.Check()
+
+	assert.NoError(t, r.Send(context.Background(), h))
+	assert.Equal(t, "Bearer secret", auth)
+	assert.Equal(t, "rig7", got.Rig)
+	assert.Equal(t, Attention, got.Status)
+
+	h.Rig = "reject"
+	err := r.Send(context.Background(), h)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "403 unknown rig")
+
+	// named after the host if no name is given
+	assert.NotEmpty(t, New(srv.URL, "", "", time.Minute).Rig)
+}
+
+func TestTally(t *testing.T) {
+
+	var nothing *Tally
+	nothing.Add(errors.New("not counted"))
+	nothing.Fill(&Health{})
+
+	tally := &Tally{}
+	tally.Add(nil)
+	tally.Add(errors.New("no response from the VNA"))
+	tally.Add(nil)
+
+	h := Health{}
+	tally.Fill(&h)
+	h.Check()
+
+	assert.Equal(t, OK, h.Status)
+	assert.Equal(t, 3, h.Requests)
+	assert.Equal(t, 1, h.Errors)
+	assert.Equal(t, 1, h.Recent)
+	assert.Equal(t, "no response from the VNA", h.LastError)
+	assert.NotNil(t, h.LastErrorAt)
+
+	h = Health{}
+	tally.Fill(&h)
+	assert.Equal(t, 1, h.Errors)
+	assert.Equal(t, 0, h.Recent)
+}
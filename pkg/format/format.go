@@ -0,0 +1,393 @@
+// package format converts S-parameters from real/imaginary pairs into the
+// scalar quantities that users usually plot, so thin clients need not do the maths
+package format
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+const (
+	RI   = "ri"   // real, imaginary (no conversion)
+	MA   = "ma"   // linear magnitude, phase in degrees
+	DB   = "db"   // magnitude in dB, phase in degrees
+	VSWR = "vswr" // voltage standing wave ratio, reflection parameters only
+	GD   = "gd"   // group delay in seconds
+)
+
+// Check returns an error if f is not a known format
+func Check(f string) error {
+	switch strings.ToLower(f) {
+	case "", RI, MA, DB, VSWR, GD:
+		return nil
+	}
+	return fmt.Errorf("unknown format %s, use ri, ma, db, vswr or gd", f)
+}
+
+// Describe says what the values of format f are, for the metadata of a result
+func Describe(f string) string {
+	switch strings.ToLower(f) {
+	case "", RI:
+		return pocket.LinearRI
+	case MA:
+		return "linear magnitude, phase in degrees"
+	case DB:
+		return "magnitude in dB, phase in degrees"
+	case VSWR:
+		return "voltage standing wave ratio"
+	case GD:
+		return "group delay in seconds"
+	}
+	return ""
+}
+
+// Apply converts s into format f. There is nothing to do for ri, so it returns nil.
+func Apply(f string, s []pocket.SParam) ([]pocket.FormattedSParam, error) {
+
+	err := Check(f)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f = strings.ToLower(f)
+
+	if f == "" || f == RI {
+		return nil, nil
+	}
+
+	if f == GD {
+		return groupDelay(s)
+	}
+
+	var fs []pocket.FormattedSParam
+
+	for _, v := range s {
+
+		p := pocket.FormattedSParam{Freq: v.Freq}
+
+		switch f {
+		case MA:
+			p.S11 = magAngle(v.S11)
+			p.S12 = magAngle(v.S12)
+			p.S21 = magAngle(v.S21)
+			p.S22 = magAngle(v.S22)
+		case DB:
+			p.S11 = dBAngle(v.S11)
+			p.S12 = dBAngle(v.S12)
+			p.S21 = dBAngle(v.S21)
+			p.S22 = dBAngle(v.S22)
+		case VSWR:
+			// transmission parameters have no standing wave ratio
+			p.S11 = vswr(v.S11)
+			p.S22 = vswr(v.S22)
+		}
+
+		fs = append(fs, p)
+	}
+
+	return fs, nil
+}
+
+func toComplex(c pocket.Complex) complex128 {
+	return complex(c.Real, c.Imag)
+}
+
+func magAngle(c pocket.Complex) *pocket.Value {
+	z := toComplex(c)
+	return &pocket.Value{
+		Mag:   cmplx.Abs(z),
+		Phase: cmplx.Phase(z) * 180 / math.Pi,
+	}
+}
+
+func dBAngle(c pocket.Complex) *pocket.Value {
+	z := toComplex(c)
+	return &pocket.Value{
+		Mag:   20 * math.Log10(cmplx.Abs(z)),
+		Phase: cmplx.Phase(z) * 180 / math.Pi,
+	}
+}
+
+func vswr(c pocket.Complex) *pocket.Value {
+	g := cmplx.Abs(toComplex(c))
+	if g >= 1 {
+		return &pocket.Value{Mag: math.Inf(1)}
+	}
+	return &pocket.Value{Mag: (1 + g) / (1 - g)}
+}
+
+// Smith returns the reflection parameters of s as impedances normalised to the
+// reference impedance, z = (1 + gamma) / (1 - gamma), which are the coordinates of
+// the points on a Smith chart
+func Smith(s []pocket.SParam) []pocket.SmithPoint {
+
+	sp := make([]pocket.SmithPoint, len(s))
+
+	for i, v := range s {
+		sp[i] = pocket.SmithPoint{
+			Freq: v.Freq,
+			S11:  impedance(v.S11),
+			S22:  impedance(v.S22),
+		}
+	}
+
+	return sp
+}
+
+// impedance is nil at the open circuit, since JSON has no infinity
+func impedance(c pocket.Complex) *pocket.Impedance {
+
+	g := toComplex(c)
+
+	if g == 1 {
+		return nil
+	}
+
+	z := (1 + g) / (1 - g)
+
+	return &pocket.Impedance{R: real(z), X: imag(z)}
+}
+
+// Delta returns the difference of b from a at each frequency, as the ratio of the
+// magnitudes in dB, and the difference of the phases in degrees (-180 to 180)
+func Delta(a, b []pocket.SParam) ([]pocket.FormattedSParam, error) {
+
+	if len(a) != len(b) {
+		return nil, fmt.Errorf("cannot compare %d points with %d points", len(a), len(b))
+	}
+
+	fs := make([]pocket.FormattedSParam, len(a))
+
+	for i := range a {
+
+		if a[i].Freq != b[i].Freq {
+			return nil, fmt.Errorf("cannot compare point %d because the frequencies are %d and %d", i, a[i].Freq, b[i].Freq)
+		}
+
+		fs[i] = pocket.FormattedSParam{
+			Freq: a[i].Freq,
+			S11:  delta(a[i].S11, b[i].S11),
+			S12:  delta(a[i].S12, b[i].S12),
+			S21:  delta(a[i].S21, b[i].S21),
+			S22:  delta(a[i].S22, b[i].S22),
+		}
+	}
+
+	return fs, nil
+}
+
+func delta(a, b pocket.Complex) *pocket.Value {
+	za, zb := toComplex(a), toComplex(b)
+	return &pocket.Value{
+		Mag:   20 * math.Log10(cmplx.Abs(zb)/cmplx.Abs(za)),
+		Phase: cmplx.Phase(zb*cmplx.Conj(za)) * 180 / math.Pi,
+	}
+}
+
+// groupDelay is -dphi/domega, using central differences of the unwrapped
+// phase, and one-sided differences at the ends of the range
+func groupDelay(s []pocket.SParam) ([]pocket.FormattedSParam, error) {
+
+	if len(s) < 2 {
+		return nil, errors.New("need at least two points to find group delay")
+	}
+
+	freq := make([]float64, len(s))
+
+	for i, v := range s {
+		freq[i] = float64(v.Freq)
+	}
+
+	get := []func(pocket.SParam) pocket.Complex{
+		func(v pocket.SParam) pocket.Complex { return v.S11 },
+		func(v pocket.SParam) pocket.Complex { return v.S12 },
+		func(v pocket.SParam) pocket.Complex { return v.S21 },
+		func(v pocket.SParam) pocket.Complex { return v.S22 },
+	}
+
+	var delays [4][]float64
+
+	for j, g := range get {
+
+		phase := make([]float64, len(s))
+
+		for i, v := range s {
+			phase[i] = cmplx.Phase(toComplex(g(v)))
+		}
+
+		delays[j] = differentiate(freq, Unwrap(phase))
+	}
+
+	fs := make([]pocket.FormattedSParam, len(s))
+
+	for i, v := range s {
+		fs[i] = pocket.FormattedSParam{
+			Freq: v.Freq,
+			S11:  &pocket.Value{Mag: delays[0][i]},
+			S12:  &pocket.Value{Mag: delays[1][i]},
+			S21:  &pocket.Value{Mag: delays[2][i]},
+			S22:  &pocket.Value{Mag: delays[3][i]},
+		}
+	}
+
+	return fs, nil
+}
+
+// DefaultAperture is the aperture of PhaseDelay if none is given, which is a
+// central difference between the neighbours of each point
+const DefaultAperture = 3
+
+// CheckAperture returns an error if aperture is not a valid aperture for PhaseDelay
+// of a trace of n points, or of any size if n is zero
+func CheckAperture(aperture, n int) error {
+
+	if aperture == 0 {
+		aperture = DefaultAperture
+	}
+
+	if aperture < 3 || aperture%2 == 0 {
+		return fmt.Errorf("group delay aperture must be an odd number of points, at least 3, not %d", aperture)
+	}
+
+	if n > 0 && aperture > n {
+		return fmt.Errorf("group delay aperture of %d points is wider than the %d points of the trace", aperture, n)
+	}
+
+	return nil
+}
+
+// PhaseDelay returns the unwrapped phase of s21 in s, in degrees, and its group delay,
+// -dphi/domega, from the least squares slope of the phase over aperture points,
+// centred on each point, or at the ends, over the first or last aperture of points
+func PhaseDelay(s []pocket.SParam, aperture int) (pocket.PhaseDelay, error) {
+
+	if aperture == 0 {
+		aperture = DefaultAperture
+	}
+
+	pd := pocket.PhaseDelay{Aperture: aperture}
+
+	err := CheckAperture(aperture, len(s))
+
+	if err != nil {
+		return pd, err
+	}
+
+	phase := make([]float64, len(s))
+
+	for i, v := range s {
+		phase[i] = cmplx.Phase(toComplex(v.S21))
+	}
+
+	phase = Unwrap(phase)
+
+	n := len(s)
+	half := aperture / 2
+
+	pd.Phase = make([]float64, n)
+	pd.Delay = make([]float64, n)
+
+	for i := range s {
+
+		pd.Phase[i] = phase[i] * 180 / math.Pi
+
+		// the aperture, kept within the trace
+		lo := i - half
+
+		if lo < 0 {
+			lo = 0
+		}
+
+		if lo+aperture > n {
+			lo = n - aperture
+		}
+
+		slope, err := slope(s[lo:lo+aperture], phase[lo:lo+aperture])
+
+		if err != nil {
+			return pd, err
+		}
+
+		pd.Delay[i] = -slope / (2 * math.Pi)
+	}
+
+	return pd, nil
+}
+
+// slope is the least squares slope of phase against the frequencies of s
+func slope(s []pocket.SParam, phase []float64) (float64, error) {
+
+	// relative to the first frequency, to keep the sums small
+	f0 := float64(s[0].Freq)
+
+	var mf, mp float64
+
+	for i, v := range s {
+		mf += float64(v.Freq) - f0
+		mp += phase[i]
+	}
+
+	mf /= float64(len(s))
+	mp /= float64(len(s))
+
+	var sfp, sff float64
+
+	for i, v := range s {
+		df := float64(v.Freq) - f0 - mf
+		sfp += df * (phase[i] - mp)
+		sff += df * df
+	}
+
+	if sff == 0 {
+		return 0, fmt.Errorf("cannot find group delay at %d Hz, because the points around it are all at the same frequency", s[0].Freq)
+	}
+
+	return sfp / sff, nil
+}
+
+// Unwrap removes jumps of more than pi between successive phases (radians)
+func Unwrap(phase []float64) []float64 {
+
+	u := make([]float64, len(phase))
+
+	offset := 0.0
+
+	for i, p := range phase {
+		if i > 0 {
+			d := p - phase[i-1]
+			if d > math.Pi {
+				offset -= 2 * math.Pi
+			} else if d < -math.Pi {
+				offset += 2 * math.Pi
+			}
+		}
+		u[i] = p + offset
+	}
+
+	return u
+}
+
+func differentiate(freq, phase []float64) []float64 {
+
+	n := len(freq)
+	d := make([]float64, n)
+
+	for i := range freq {
+		lo, hi := i-1, i+1
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > n-1 {
+			hi = n - 1
+		}
+		d[i] = -(phase[hi] - phase[lo]) / (2 * math.Pi * (freq[hi] - freq[lo]))
+	}
+
+	return d
+}
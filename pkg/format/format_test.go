@@ -0,0 +1,188 @@
+package format
+
+import (
+	"math"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	for _, f := range []string{"", "ri", "MA", "db", "vswr", "gd"} {
+		assert.NoError(t, Check(f), f)
+	}
+	assert.Error(t, Check("smith"))
+}
+
+func TestDescribe(t *testing.T) {
+	assert.Equal(t, "linear real/imag", Describe(""))
+	assert.Equal(t, "magnitude in dB, phase in degrees", Describe("DB"))
+	assert.Equal(t, "", Describe("smith"))
+}
+
+func TestApply(t *testing.T) {
+
+	s := []pocket.SParam{
+		{
+			Freq: 100,
+			S11:  pocket.Complex{Real: 0, Imag: 0.5},
+			S21:  pocket.Complex{Real: -0.1, Imag: 0},
+		},
+	}
+
+	fs, err := Apply("ri", s)
+	assert.NoError(t, err)
+	assert.Nil(t, fs)
+
+	fs, err = Apply("ma", s)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.5, fs[0].S11.Mag, 1e-9)
+	assert.InDelta(t, 90, fs[0].S11.Phase, 1e-9)
+	assert.InDelta(t, 180, fs[0].S21.Phase, 1e-9)
+
+	fs, err = Apply("db", s)
+	assert.NoError(t, err)
+	assert.InDelta(t, -20, fs[0].S21.Mag, 1e-9)
+
+	fs, err = Apply("vswr", s)
+	assert.NoError(t, err)
+	assert.InDelta(t, 3, fs[0].S11.Mag, 1e-9)
+	assert.Nil(t, fs[0].S21)
+
+	_, err = Apply("gd", s)
+	assert.Error(t, err)
+
+	_, err = Apply("xy", s)
+	assert.Error(t, err)
+}
+
+func TestSmith(t *testing.T) {
+
+	s := []pocket.SParam{
+		{Freq: 100}, // matched
+		{Freq: 200, S11: pocket.Complex{Real: -1}},      // short
+		{Freq: 300, S11: pocket.Complex{Real: 1}},       // open
+		{Freq: 400, S22: pocket.Complex{Imag: 1}},       // inductor of 50 ohms
+		{Freq: 500, S22: pocket.Complex{Real: 1.0 / 3}}, // 100 ohms
+	}
+
+	sp := Smith(s)
+
+	assert.Equal(t, 5, len(sp))
+	assert.Equal(t, pocket.Impedance{R: 1}, *sp[0].S11)
+	assert.Equal(t, uint64(200), sp[1].Freq)
+	assert.InDelta(t, 0, sp[1].S11.R, 1e-12)
+	assert.Nil(t, sp[2].S11)
+	assert.InDelta(t, 0, sp[3].S22.R, 1e-12)
+	assert.InDelta(t, 1, sp[3].S22.X, 1e-12)
+	assert.InDelta(t, 2, sp[4].S22.R, 1e-12)
+}
+
+func TestGroupDelay(t *testing.T) {
+
+	tau := 3e-9
+
+	var s []pocket.SParam
+
+	// enough phase change per step to need unwrapping
+	for i := 0; i < 50; i++ {
+		f := 1e9 + float64(i)*50e6
+		p := -2 * math.Pi * f * tau
+		s = append(s, pocket.SParam{
+			Freq: uint64(f),
+			S21:  pocket.Complex{Real: math.Cos(p), Imag: math.Sin(p)},
+		})
+	}
+
+	fs, err := Apply("gd", s)
+	assert.NoError(t, err)
+
+	for _, v := range fs {
+		assert.InDelta(t, tau, v.S21.Mag, 1e-12)
+	}
+
+}
+
+func TestPhaseDelay(t *testing.T) {
+
+	tau := 3e-9
+
+	var s []pocket.SParam
+
+	// enough phase change per step to need unwrapping
+	for i := 0; i < 20; i++ {
+		f := 1e9 + float64(i)*100e6
+		p := -2 * math.Pi * f * tau
+		s = append(s, pocket.SParam{
+			Freq: uint64(f),
+			S21:  pocket.Complex{Real: math.Cos(p), Imag: math.Sin(p)},
+		})
+	}
+
+	for _, aperture := range []int{0, 3, 7, 19} {
+
+		pd, err := PhaseDelay(s, aperture)
+		assert.NoError(t, err)
+
+		if aperture == 0 {
+			assert.Equal(t, DefaultAperture, pd.Aperture)
+		}
+
+		for i := range s {
+			assert.InDelta(t, tau, pd.Delay[i], 1e-12)
+		}
+
+		// no jumps, since it is unwrapped
+		for i := 1; i < len(s); i++ {
+			assert.InDelta(t, -360*100e6*tau, pd.Phase[i]-pd.Phase[i-1], 1e-6)
+		}
+	}
+
+	_, err := PhaseDelay(s, 4)
+	assert.EqualError(t, err, "group delay aperture must be an odd number of points, at least 3, not 4")
+
+	_, err = PhaseDelay(s, 21)
+	assert.EqualError(t, err, "group delay aperture of 21 points is wider than the 20 points of the trace")
+
+	same := []pocket.SParam{{Freq: 100}, {Freq: 100}, {Freq: 100}}
+	_, err = PhaseDelay(same, 3)
+	assert.Error(t, err)
+
+	assert.NoError(t, CheckAperture(0, 0))
+	assert.Error(t, CheckAperture(1, 0))
+}
+
+func TestUnwrap(t *testing.T) {
+	u := Unwrap([]float64{3, -3, 3})
+	assert.InDelta(t, 2*math.Pi-3, u[1], 1e-9)
+	assert.InDelta(t, 3, u[2], 1e-9)
+}
+
+func TestDelta(t *testing.T) {
+
+	a := []pocket.SParam{{Freq: 100, S21: pocket.Complex{Real: 0.5}, S11: pocket.Complex{Imag: -0.1}}}
+	b := []pocket.SParam{{Freq: 100, S21: pocket.Complex{Real: 0.05}, S11: pocket.Complex{Real: -0.1}}}
+
+	d, err := Delta(a, b)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), d[0].Freq)
+	assert.InDelta(t, -20, d[0].S21.Mag, 1e-9)
+	assert.InDelta(t, 0, d[0].S21.Phase, 1e-9)
+	assert.InDelta(t, 0, d[0].S11.Mag, 1e-9)
+	assert.InDelta(t, -90, d[0].S11.Phase, 1e-9) // from -90 to 180 degrees is 270, which wraps to -90
+
+	// phase difference wraps, rather than exceeding 180
+	a[0].S11 = pocket.Complex{Real: math.Cos(170 * math.Pi / 180), Imag: math.Sin(170 * math.Pi / 180)}
+	b[0].S11 = pocket.Complex{Real: math.Cos(-170 * math.Pi / 180), Imag: math.Sin(-170 * math.Pi / 180)}
+	d, err = Delta(a, b)
+	assert.NoError(t, err)
+	assert.InDelta(t, 20, d[0].S11.Phase, 1e-9)
+
+	_, err = Delta(a, append(b, b...))
+	assert.Error(t, err)
+
+	b[0].Freq = 200
+	_, err = Delta(a, b)
+	assert.Error(t, err)
+}
@@ -0,0 +1,89 @@
+// package history keeps the most recent responses sent on the stream in memory,
+// so that a client that loses its connection while a request is being handled,
+// e.g. a long sweep, can fetch the response when it is back, rather than
+// measuring again.
+package history
+
+import (
+	"sync"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+// DefaultSize is the number of responses kept if no size is given
+const DefaultSize = 20
+
+// Entry is a response, and when it was sent. Session, ID and Cmd are those of the
+// request it answered, and Error is true if it was an error.
+type Entry struct {
+	Time     time.Time
+	Session  string
+	ID       string
+	Cmd      string
+	Error    bool
+	Response interface{}
+}
+
+// History holds the last responses added, oldest first. It is safe to use from
+// more than one goroutine.
+type History struct {
+	mu      sync.Mutex
+	entries []Entry
+	next    int  // where the next entry goes
+	full    bool // entries has wrapped around
+}
+
+// New returns a history that keeps the last size responses, DefaultSize if size is not positive
+func New(size int) *History {
+
+	if size <= 0 {
+		size = DefaultSize
+	}
+
+	return &History{entries: make([]Entry, size)}
+}
+
+// Add adds a response, dropping the oldest if the history is full. The command is
+// found by its name, e.g. crq for calibratedrangequery, if it is known.
+func (h *History) Add(response interface{}) {
+
+	e := Entry{
+		Time:     time.Now().UTC(),
+		Response: response,
+	}
+
+	if c, ok := pocket.CommandOf(response); ok {
+		e.Session = c.Session
+		e.ID = c.ID
+		e.Cmd = c.Command
+		if cmd, ok := pocket.Lookup(c.Command); ok {
+			e.Cmd = cmd
+		}
+	}
+
+	_, e.Error = response.(pocket.CustomResult)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// Entries returns the entries that are held, oldest first
+func (h *History) Entries() []Entry {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.full {
+		return append([]Entry(nil), h.entries[:h.next]...)
+	}
+
+	return append(append([]Entry(nil), h.entries[h.next:]...), h.entries[:h.next]...)
+}
@@ -0,0 +1,37 @@
+package history
+
+import (
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistory(t *testing.T) {
+
+	h := New(2)
+	assert.Empty(t, h.Entries())
+
+	h.Add(pocket.RangeQuery{Command: pocket.Command{ID: "1", Command: "rangequery", Session: "alice"}})
+
+	e := h.Entries()
+	assert.Equal(t, 1, len(e))
+	assert.Equal(t, "1", e[0].ID)
+	assert.Equal(t, "rq", e[0].Cmd) // by its name, not the alias it was sent with
+	assert.Equal(t, "alice", e[0].Session)
+	assert.False(t, e[0].Error)
+	assert.False(t, e[0].Time.IsZero())
+
+	h.Add(pocket.CustomResult{Message: "not calibrated yet", Command: pocket.CalibratedRangeQuery{Command: pocket.Command{ID: "2", Command: "crq"}}})
+	h.Add(pocket.Hold{Command: pocket.Command{ID: "3", Command: "hq"}})
+
+	// the oldest go first
+	e = h.Entries()
+	assert.Equal(t, 2, len(e))
+	assert.Equal(t, "2", e[0].ID)
+	assert.Equal(t, "crq", e[0].Cmd)
+	assert.True(t, e[0].Error)
+	assert.Equal(t, "3", e[1].ID)
+
+	assert.Equal(t, DefaultSize, len(New(0).entries))
+}
@@ -0,0 +1,127 @@
+// package limit checks measurements against limit lines on the magnitude of
+// each S-parameter, for automated grading and go/no-go testing
+package limit
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+// MaxLimits is the most limit lines that can be set at once
+const MaxLimits = 64
+
+// Check returns an error describing the first limit line that is not valid
+func Check(limits []pocket.Limit) error {
+
+	if len(limits) > MaxLimits {
+		return fmt.Errorf("there are %d limits but the most allowed is %d", len(limits), MaxLimits)
+	}
+
+	for i, l := range limits {
+
+		if _, err := get(l.SParam); err != nil {
+			return fmt.Errorf("limit %d: %s", i, err.Error())
+		}
+
+		if l.End < l.Start {
+			return fmt.Errorf("limit %d: end %d Hz is below start %d Hz", i, l.End, l.Start)
+		}
+
+		if l.Max == nil && l.Min == nil {
+			return fmt.Errorf("limit %d: give a max, a min or both", i)
+		}
+
+		if l.Max != nil && l.Min != nil && *l.Min > *l.Max {
+			return fmt.Errorf("limit %d: min %g dB is above max %g dB", i, *l.Min, *l.Max)
+		}
+	}
+
+	return nil
+}
+
+// get returns a function that picks the S-parameter named p
+func get(p string) (func(pocket.SParam) pocket.Complex, error) {
+
+	switch strings.ToLower(p) {
+	case "s11":
+		return func(v pocket.SParam) pocket.Complex { return v.S11 }, nil
+	case "s12":
+		return func(v pocket.SParam) pocket.Complex { return v.S12 }, nil
+	case "s21":
+		return func(v pocket.SParam) pocket.Complex { return v.S21 }, nil
+	case "s22":
+		return func(v pocket.SParam) pocket.Complex { return v.S22 }, nil
+	}
+
+	return nil, fmt.Errorf("sparam can be s11, s12, s21 or s22, not %s", p)
+}
+
+// Evaluate compares s with each limit line, and reports whether they all passed.
+// Call Check on the limits first.
+func Evaluate(limits []pocket.Limit, s []pocket.SParam) ([]pocket.LimitResult, bool, error) {
+
+	results := make([]pocket.LimitResult, len(limits))
+	pass := true
+
+	for i, l := range limits {
+
+		g, err := get(l.SParam)
+
+		if err != nil {
+			return nil, false, err
+		}
+
+		r := pocket.LimitResult{Limit: l}
+		margin := math.Inf(1)
+
+		for _, v := range s {
+
+			if v.Freq < l.Start || v.Freq > l.End {
+				continue
+			}
+
+			c := g(v)
+			db := 20 * math.Log10(cmplx.Abs(complex(c.Real, c.Imag)))
+
+			// a zero magnitude is -Inf dB, which passes a max, and fails a min by a long way
+			m := math.Inf(1)
+
+			if l.Max != nil {
+				m = math.Min(m, *l.Max-db)
+			}
+
+			if l.Min != nil {
+				m = math.Min(m, db-*l.Min)
+			}
+
+			if m < margin {
+				margin = m
+				r.Worst = v.Freq
+			}
+
+			r.Points++
+		}
+
+		// keep the margin representable in JSON
+		switch {
+		case r.Points == 0:
+			margin = 0
+		case math.IsInf(margin, -1):
+			margin = -math.MaxFloat64
+		case math.IsInf(margin, 1):
+			margin = math.MaxFloat64
+		}
+
+		r.Margin = margin
+		r.Pass = r.Points > 0 && margin >= 0
+
+		pass = pass && r.Pass
+		results[i] = r
+	}
+
+	return results, pass, nil
+}
@@ -0,0 +1,79 @@
+package limit
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func db(v float64) *float64 {
+	return &v
+}
+
+func TestCheck(t *testing.T) {
+
+	assert.NoError(t, Check(nil))
+	assert.NoError(t, Check([]pocket.Limit{{SParam: "S21", Start: 1e6, End: 2e6, Max: db(-3), Min: db(-10)}}))
+
+	bad := []pocket.Limit{
+		{SParam: "s31", Max: db(0)},
+		{SParam: "s21", Start: 2e6, End: 1e6, Max: db(0)},
+		{SParam: "s21"},
+		{SParam: "s21", Max: db(-10), Min: db(-3)},
+	}
+
+	for _, l := range bad {
+		assert.Error(t, Check([]pocket.Limit{l}), l)
+	}
+
+	assert.Error(t, Check(make([]pocket.Limit, MaxLimits+1)))
+}
+
+func TestEvaluate(t *testing.T) {
+
+	// a low pass filter: 0 dB, -6 dB, -40 dB
+	s := []pocket.SParam{
+		{Freq: 100e6, S21: pocket.Complex{Real: 1}, S11: pocket.Complex{Real: 0.1}},
+		{Freq: 200e6, S21: pocket.Complex{Real: 0.5}, S11: pocket.Complex{Real: 0.1}},
+		{Freq: 300e6, S21: pocket.Complex{Real: 0.01}},
+	}
+
+	limits := []pocket.Limit{
+		{Name: "passband", SParam: "s21", Start: 100e6, End: 200e6, Min: db(-7)},
+		{Name: "stopband", SParam: "s21", Start: 300e6, End: 300e6, Max: db(-30)},
+		{Name: "match", SParam: "s11", Start: 100e6, End: 200e6, Max: db(-25)},
+		{Name: "nothing", SParam: "s22", Start: 1e9, End: 2e9, Max: db(0)},
+		{Name: "zero", SParam: "s11", Start: 300e6, End: 300e6, Min: db(-50)},
+	}
+
+	results, pass, err := Evaluate(limits, s)
+	assert.NoError(t, err)
+	assert.False(t, pass)
+
+	assert.True(t, results[0].Pass)
+	assert.Equal(t, 2, results[0].Points)
+	assert.Equal(t, uint64(200e6), results[0].Worst)
+	assert.InDelta(t, 0.979, results[0].Margin, 1e-3)
+
+	assert.True(t, results[1].Pass)
+	assert.InDelta(t, 10, results[1].Margin, 1e-9)
+
+	assert.False(t, results[2].Pass)
+	assert.InDelta(t, -5, results[2].Margin, 1e-9)
+
+	assert.False(t, results[3].Pass)
+	assert.Equal(t, 0, results[3].Points)
+
+	assert.False(t, results[4].Pass)
+
+	// results can always be sent
+	_, err = json.Marshal(results)
+	assert.NoError(t, err)
+
+	results, pass, err = Evaluate(limits[:2], s)
+	assert.NoError(t, err)
+	assert.True(t, pass)
+	assert.Equal(t, "passband", results[0].Name)
+}
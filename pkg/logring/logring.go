@@ -0,0 +1,80 @@
+// package logring keeps the most recent lines of the debug log in memory, so
+// that they can be included in a crash report, or fetched by a maintainer,
+// without having to get onto the rig to read the log file.
+package logring
+
+import (
+	"strings"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSize is the number of lines kept if no size is given
+const DefaultSize = 200
+
+// Ring holds the last lines logged, oldest first. Add it to logrus with AddHook.
+// Only the lines at or above the log level are seen, as for the log file.
+// It is safe to use from more than one goroutine.
+type Ring struct {
+	mu    sync.Mutex
+	lines []string
+	next  int  // where the next line goes
+	full  bool // lines has wrapped around
+}
+
+// New returns a ring that keeps the last size lines, DefaultSize if size is not positive
+func New(size int) *Ring {
+
+	if size <= 0 {
+		size = DefaultSize
+	}
+
+	return &Ring{lines: make([]string, size)}
+}
+
+// Levels is every level, so that the ring sees whatever the logger logs
+func (r *Ring) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire adds the entry, formatted as it is for the log file
+func (r *Ring) Fire(e *log.Entry) error {
+
+	line, err := e.String()
+
+	if err != nil {
+		return err
+	}
+
+	r.Add(strings.TrimRight(line, "\n"))
+
+	return nil
+}
+
+// Add adds a line, dropping the oldest if the ring is full
+func (r *Ring) Add(line string) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Lines returns the lines that are held, oldest first
+func (r *Ring) Lines() []string {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+
+	return append(append([]string(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+}
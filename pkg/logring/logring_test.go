@@ -0,0 +1,49 @@
+package logring
+
+import (
+	"bytes"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRing(t *testing.T) {
+
+	r := New(3)
+	assert.Empty(t, r.Lines())
+
+	r.Add("a")
+	r.Add("b")
+	assert.Equal(t, []string{"a", "b"}, r.Lines())
+
+	r.Add("c")
+	assert.Equal(t, []string{"a", "b", "c"}, r.Lines())
+
+	// the oldest go first
+	r.Add("d")
+	r.Add("e")
+	assert.Equal(t, []string{"c", "d", "e"}, r.Lines())
+
+	assert.Equal(t, DefaultSize, len(New(0).lines))
+}
+
+func TestHook(t *testing.T) {
+
+	l := log.New()
+	l.SetOutput(&bytes.Buffer{})
+	l.SetFormatter(&log.JSONFormatter{})
+	l.SetLevel(log.InfoLevel)
+
+	r := New(10)
+	l.AddHook(r)
+
+	l.WithField("port", "/dev/ttyUSB0").Info("switch opened")
+	l.Debug("not logged, so not kept")
+
+	lines := r.Lines()
+	assert.Equal(t, 1, len(lines))
+	assert.Contains(t, lines[0], `"msg":"switch opened"`)
+	assert.Contains(t, lines[0], `"port":"/dev/ttyUSB0"`)
+	assert.NotContains(t, lines[0], "\n")
+}
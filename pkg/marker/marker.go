@@ -0,0 +1,159 @@
+// package marker finds the usual markers in a calibrated result, such as the
+// resonance of an antenna or the bandwidth of a filter, so that thin clients
+// do not need to, and student results can be checked automatically
+package marker
+
+import (
+	"errors"
+	"math"
+	"math/cmplx"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+// Find returns the markers for s, which must be in order of increasing frequency
+func Find(s []pocket.SParam) (pocket.Markers, error) {
+
+	var m pocket.Markers
+
+	if len(s) < 2 {
+		return m, errors.New("need at least two points to find markers")
+	}
+
+	freq := make([]float64, len(s))
+	s11 := make([]float64, len(s))
+	s21 := make([]float64, len(s))
+
+	for i, v := range s {
+		freq[i] = float64(v.Freq)
+		s11[i] = dB(v.S11)
+		s21[i] = dB(v.S21)
+	}
+
+	lo := argmin(s11)
+	m.S11Min = pocket.Marker{Freq: freq[lo], DB: s11[lo]}
+
+	hi := argmax(s21)
+	m.S21Max = pocket.Marker{Freq: freq[hi], DB: s21[hi]}
+
+	m.Bandwidth3dB = bandwidth(freq, s21, hi, 3)
+	m.Bandwidth10dB = bandwidth(freq, s21, hi, 10)
+
+	// ripple over the passband, i.e. the points within 3dB of the peak either side of it
+	first, last := hi, hi
+
+	for first > 0 && s21[first-1] >= s21[hi]-3 {
+		first--
+	}
+
+	for last < len(s21)-1 && s21[last+1] >= s21[hi]-3 {
+		last++
+	}
+
+	m.Ripple = s21[hi] - s21[first+argmin(s21[first:last+1])]
+
+	// crossovers, where |S11| = |S21|
+	m.Crossover = []float64{}
+
+	for i := 1; i < len(s); i++ {
+
+		d0 := s21[i-1] - s11[i-1]
+		d1 := s21[i] - s11[i]
+
+		if d0 == 0 {
+			m.Crossover = append(m.Crossover, freq[i-1])
+			continue
+		}
+
+		if d0*d1 < 0 {
+			m.Crossover = append(m.Crossover, freq[i-1]+(freq[i]-freq[i-1])*d0/(d0-d1))
+		}
+	}
+
+	if s21[len(s)-1] == s11[len(s)-1] {
+		m.Crossover = append(m.Crossover, freq[len(s)-1])
+	}
+
+	return m, nil
+}
+
+// bandwidth finds where the trace falls by drop dB either side of the peak at
+// index p, interpolating between points, or returns nil if it does not fall
+// that far on both sides within the sweep
+func bandwidth(freq, trace []float64, p int, drop float64) *pocket.Bandwidth {
+
+	level := trace[p] - drop
+
+	low, high := math.NaN(), math.NaN()
+
+	for i := p; i > 0; i-- {
+		if trace[i-1] < level {
+			low = cross(freq[i-1], freq[i], trace[i-1], trace[i], level)
+			break
+		}
+	}
+
+	for i := p; i < len(trace)-1; i++ {
+		if trace[i+1] < level {
+			high = cross(freq[i], freq[i+1], trace[i], trace[i+1], level)
+			break
+		}
+	}
+
+	if math.IsNaN(low) || math.IsNaN(high) {
+		return nil
+	}
+
+	return &pocket.Bandwidth{
+		Low:    low,
+		High:   high,
+		Centre: (low + high) / 2,
+		Width:  high - low,
+	}
+}
+
+// cross returns the frequency at which the line from (f0, y0) to (f1, y1) reaches level
+func cross(f0, f1, y0, y1, level float64) float64 {
+	return f0 + (f1-f0)*(level-y0)/(y1-y0)
+}
+
+// Floor is the least magnitude of a marker, in dB, given in place of that of zero,
+// e.g. the S11 of an ideal match, which has no value in dB, so that the markers, and
+// the ripple and bandwidths found from them, can be sent as JSON
+const Floor = -200
+
+// dB returns the magnitude of c in dB, no less than Floor, and no more than -Floor,
+// with Floor in place of a value that is not a number
+func dB(c pocket.Complex) float64 {
+
+	d := 20 * math.Log10(cmplx.Abs(complex(c.Real, c.Imag)))
+
+	switch {
+	case math.IsNaN(d), d < Floor:
+		return Floor
+	case d > -Floor:
+		return -Floor
+	}
+
+	return d
+}
+
+func argmin(x []float64) int {
+	j := 0
+	for i, v := range x {
+		if v < x[j] {
+			j = i
+		}
+	}
+	return j
+}
+
+func argmax(x []float64) int {
+	j := 0
+	for i, v := range x {
+		if v > x[j] {
+			j = i
+		}
+	}
+	return j
+}
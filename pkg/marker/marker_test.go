@@ -0,0 +1,93 @@
+package marker
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func fromDB(db float64) pocket.Complex {
+	return pocket.Complex{Real: math.Pow(10, db/20)}
+}
+
+func TestFind(t *testing.T) {
+
+	// a bandpass filter, sampled every 10 MHz from 100 to 200 MHz
+	s21 := []float64{-30, -20, -10, -2, -0.5, -1, -0.6, -2, -10, -20, -30}
+	s11 := []float64{-0.1, -0.5, -2, -10, -25, -15, -20, -10, -2, -0.5, -0.1}
+
+	var s []pocket.SParam
+
+	for i := range s21 {
+		s = append(s, pocket.SParam{
+			Freq: uint64(100e6 + 10e6*i),
+			S11:  fromDB(s11[i]),
+			S21:  fromDB(s21[i]),
+		})
+	}
+
+	m, err := Find(s)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 140e6, m.S11Min.Freq)
+	assert.InDelta(t, -25, m.S11Min.DB, 1e-9)
+
+	assert.Equal(t, 140e6, m.S21Max.Freq)
+	assert.InDelta(t, -0.5, m.S21Max.DB, 1e-9)
+
+	// -3.5 dB lies 3/16 of the way from -2 dB towards -10 dB
+	assert.NotNil(t, m.Bandwidth3dB)
+	assert.InDelta(t, 130e6-10e6*1.5/8, m.Bandwidth3dB.Low, 1)
+	assert.InDelta(t, 170e6+10e6*1.5/8, m.Bandwidth3dB.High, 1)
+	assert.InDelta(t, 150e6, m.Bandwidth3dB.Centre, 1)
+
+	// -10.5 dB is just below the -10 dB points
+	assert.NotNil(t, m.Bandwidth10dB)
+	assert.InDelta(t, 120e6-10e6*0.05, m.Bandwidth10dB.Low, 1)
+	assert.InDelta(t, 40e6+2*10e6*1.05, m.Bandwidth10dB.Width, 1)
+
+	// the -2 dB points are in the passband too
+	assert.InDelta(t, 1.5, m.Ripple, 1e-9)
+
+	assert.Equal(t, 2, len(m.Crossover))
+	assert.True(t, m.Crossover[0] > 120e6 && m.Crossover[0] < 130e6)
+	assert.True(t, m.Crossover[1] > 170e6 && m.Crossover[1] < 180e6)
+
+	// the passband runs off the end of the sweep
+	m, err = Find(s[3:])
+	assert.NoError(t, err)
+	assert.Nil(t, m.Bandwidth3dB)
+	assert.NotNil(t, m.Crossover)
+
+	_, err = Find(s[:1])
+	assert.Error(t, err)
+
+	// an ideal match, and no transmission at all, have no value in dB, but the
+	// markers can still be sent
+	s = []pocket.SParam{
+		{Freq: 100e6, S21: fromDB(-1)},
+		{Freq: 110e6},
+	}
+
+	m, err = Find(s)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(Floor), m.S11Min.DB)
+	assert.Equal(t, 0.0, m.Ripple) // the floor is not in the passband
+	assert.Equal(t, []float64{110e6}, m.Crossover)
+
+	_, err = json.Marshal(m)
+	assert.NoError(t, err)
+
+	s = []pocket.SParam{{Freq: 100e6}, {Freq: 110e6, S11: pocket.Complex{Real: math.NaN()}}}
+
+	m, err = Find(s)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(Floor), m.S21Max.DB)
+	assert.Equal(t, 0.0, m.Ripple)
+
+	_, err = json.Marshal(m)
+	assert.NoError(t, err)
+}
@@ -9,21 +9,51 @@ An extension would select only the SParams specified, and help test single measu
 package measure
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/practable/pocket-vna-two-port/pkg/pocket"
 	"github.com/practable/pocket-vna-two-port/pkg/rfusb"
+	"github.com/practable/pocket-vna-two-port/pkg/wear"
 	log "github.com/sirupsen/logrus"
 )
 
 type Measure interface {
-	Measure(rq *pocket.RangeQuery) error
+	Measure(ctx context.Context, rq *pocket.RangeQuery) error
 }
 
 type Hardware struct {
-	Switch rfusb.Switch // expect user to supply a pointer to a Switch instance
-	VNA    *pocket.VNA
+	Switch    rfusb.Switch // expect user to supply a pointer to a Switch instance
+	VNA       *pocket.VNA
+	Settle    time.Duration            // wait after setting the switch, before sweeping
+	SettleFor map[string]time.Duration // per-position overrides of Settle
+	next      *switching               // switch change started by Next, nil if none
+	// called each time the switch is confirmed at a new position, with where it was
+	// before, empty if not known, e.g. to tell the users, nil if not needed
+	OnSwitch func(from, to string)
+	at       string // where the switch was last confirmed to be, empty if not known
+	// counts the moves of the switch to each position, nil if they are not counted
+	Wear *wear.Counter
+	// time to sweep one point at an average of one, learned from the sweeps made
+	// so far, zero until there has been one, see PointTime
+	pointTime time.Duration
+	// when the VNA was opened, and when it first measured, zero until it has
+	opened time.Time
+	first  time.Time
+}
+
+// switching is a change of switch position that is under way in the background
+type switching struct {
+	what string
+	done chan struct{}
+	err  error
+	at   time.Time // when the switch was confirmed in position
 }
 type Mock struct {
 	Switch                         rfusb.Switch // expect user to supply a pointer to a Switch instance
@@ -33,12 +63,146 @@ type Mock struct {
 	ResultReasonableFrequencyRange pocket.Range
 }
 
+// timingKey is the key in a context of the Timing that MeasureRange adds to
+type timingKey struct{}
+
+// WithTiming returns a copy of ctx that MeasureRange adds the time spent setting the
+// switch, and sweeping, to t, when it is given the copy
+func WithTiming(ctx context.Context, t *pocket.Timing) context.Context {
+	return context.WithValue(ctx, timingKey{}, t)
+}
+
+// TimingFrom returns the Timing in ctx, or nil if it has none
+func TimingFrom(ctx context.Context) *pocket.Timing {
+	t, _ := ctx.Value(timingKey{}).(*pocket.Timing)
+	return t
+}
+
 func NewHardware(v *pocket.VNA, s rfusb.Switch) *Hardware {
 
 	return &Hardware{
-		Switch: s,
-		VNA:    v,
+		Switch:    s,
+		VNA:       v,
+		SettleFor: make(map[string]time.Duration),
+		opened:    time.Now(),
+	}
+}
+
+// SettleTime returns how long to wait for the switch path to stabilise after
+// setting it to what, using the per-position override if there is one
+func (h *Hardware) SettleTime(what string) time.Duration {
+	if d, ok := h.SettleFor[what]; ok {
+		return d
+	}
+	return h.Settle
+}
+
+// ParseSettle parses per-position settling times of the form "thru=100ms,dut1=250ms"
+func ParseSettle(s string) (map[string]time.Duration, error) {
+
+	settle := make(map[string]time.Duration)
+
+	for _, item := range strings.Split(s, ",") {
+
+		item = strings.TrimSpace(item)
+
+		if item == "" {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+
+		if len(kv) != 2 {
+			return settle, fmt.Errorf("settling time %s is not of the form position=duration", item)
+		}
+
+		d, err := time.ParseDuration(strings.TrimSpace(kv[1]))
+
+		if err != nil {
+			return settle, fmt.Errorf("settling time for %s is not a valid duration because %s", kv[0], err.Error())
+		}
+
+		settle[strings.TrimSpace(kv[0])] = d
+	}
+
+	return settle, nil
+}
+
+// ParsePresets parses named sweeps of the form "full=1e6-4e9/201,uhf=300e6-1e9/101/log",
+// i.e. the start and end (Hz), the size, and log if the points are spread logarithmically.
+// Each is checked as an rq would be, except against the range of the VNA, which is not known yet.
+func ParsePresets(s string) ([]pocket.Preset, error) {
+
+	var presets []pocket.Preset
+
+	seen := make(map[string]bool)
+
+	for _, item := range strings.Split(s, ",") {
+
+		item = strings.TrimSpace(item)
+
+		if item == "" {
+			continue
+		}
+
+		kv := strings.SplitN(item, "=", 2)
+
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return presets, fmt.Errorf("preset %s is not of the form name=start-end/size or name=start-end/size/log", item)
+		}
+
+		p := pocket.Preset{Name: strings.TrimSpace(kv[0])}
+
+		if seen[strings.ToLower(p.Name)] {
+			return presets, fmt.Errorf("preset %s is given more than once", p.Name)
+		}
+
+		seen[strings.ToLower(p.Name)] = true
+
+		parts := strings.Split(kv[1], "/")
+		span := strings.SplitN(parts[0], "-", 2)
+
+		if len(parts) < 2 || len(parts) > 3 || len(span) != 2 {
+			return presets, fmt.Errorf("preset %s is not of the form name=start-end/size or name=start-end/size/log", item)
+		}
+
+		for i, v := range []*uint64{&p.Range.Start, &p.Range.End} {
+
+			f, err := strconv.ParseFloat(strings.TrimSpace(span[i]), 64)
+
+			if err != nil || f < 0 || f != math.Trunc(f) {
+				return presets, fmt.Errorf("preset %s must have whole frequencies in Hz, such as 1e6, not %s", p.Name, span[i])
+			}
+
+			*v = uint64(f)
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+
+		if err != nil {
+			return presets, fmt.Errorf("preset %s must have a whole number of points, not %s", p.Name, parts[1])
+		}
+
+		p.Size = size
+
+		if len(parts) == 3 {
+			if !strings.EqualFold(strings.TrimSpace(parts[2]), "log") {
+				return presets, fmt.Errorf("preset %s can only end with log, not %s", p.Name, parts[2])
+			}
+			p.LogDistribution = true
+		}
+
+		// what is not part of a preset
+		rq := pocket.RangeQuery{What: "thru", Range: p.Range, Size: p.Size}
+
+		if err := CheckRange(&rq, pocket.Range{}); err != nil {
+			return presets, fmt.Errorf("preset %s %s", p.Name, err.Error())
+		}
+
+		presets = append(presets, p)
 	}
+
+	return presets, nil
 }
 
 func NewMock(v *pocket.VNA, s rfusb.Switch) *Mock {
@@ -51,22 +215,328 @@ func NewMock(v *pocket.VNA, s rfusb.Switch) *Mock {
 	}
 }
 
-func (h *Hardware) MeasureRange(rq *pocket.RangeQuery) error {
+// SetPort sets the switch to what, then asks the switch where it is, in case
+// the cached position is stale. A mismatch gets one more attempt at setting
+// the switch before giving up, so we never measure the wrong standard or DUT.
+// It stops as soon as ctx is done.
+func (h *Hardware) SetPort(ctx context.Context, what string) error {
+
+	var is string
+
+	for attempt := 0; attempt < 2; attempt++ {
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err := h.Switch.SetPort(ctx, what)
+
+		if err != nil {
+			log.Warnf("pkg/measure: error setting switch to %s because %s", what, err.Error())
+		}
+
+		is, err = h.Switch.QueryPort(ctx)
+
+		if err != nil {
+			return fmt.Errorf("error querying switch after setting it to %s because %s", what, err.Error())
+		}
+
+		if strings.EqualFold(is, what) {
+			h.moved(what)
+			return nil
+		}
+
+		log.Warnf("pkg/measure: switch reports %s instead of %s (attempt %d)", is, what, attempt+1)
+	}
+
+	log.Errorf("pkg/measure: switch is stuck at %s instead of %s; not measuring", is, what)
+
+	return fmt.Errorf("switch reports %s instead of %s after retrying", is, what)
+}
+
+// moved records that the switch is at what, counting the move and calling OnSwitch if
+// it was somewhere else. A move from an unknown position is counted, in case it moved.
+func (h *Hardware) moved(what string) {
+
+	from := h.at
+	h.at = what
+
+	if strings.EqualFold(from, what) {
+		return
+	}
+
+	h.Wear.Add(strings.ToLower(what))
+
+	if h.OnSwitch != nil {
+		h.OnSwitch(from, what)
+	}
+}
+
+// Next starts setting the switch to what in the background, so that it can
+// be moving and settling while the last measurement is processed. The next
+// measurement of what then only waits for whatever settling time is left.
+// The switch change stops if ctx is done.
+func (h *Hardware) Next(ctx context.Context, what string) {
+
+	h.wait() // one switch change at a time
+
+	s := &switching{
+		what: what,
+		done: make(chan struct{}),
+	}
+
+	h.next = s
+
+	go func() {
+		s.err = h.SetPort(ctx, what)
+		s.at = time.Now()
+		close(s.done)
+	}()
+}
+
+// wait returns the switch change started by Next, once it has finished, or nil if there was none
+func (h *Hardware) wait() *switching {
+
+	s := h.next
+	h.next = nil
+
+	if s != nil {
+		<-s.done
+	}
+
+	return s
+}
+
+// ready sets the switch to what, unless Next already did, and returns
+// when the switch was in position, for working out the settling time
+func (h *Hardware) ready(ctx context.Context, what string) (time.Time, error) {
+
+	s := h.wait()
+
+	if s != nil && s.what == what {
+		return s.at, s.err
+	}
+
+	err := h.SetPort(ctx, what)
+
+	return time.Now(), err
+}
+
+// settle waits until the switch has been in position for its settling time, unless ctx is done first
+func (h *Hardware) settle(ctx context.Context, what string, at time.Time) error {
+
+	t := time.NewTimer(time.Until(at.Add(h.SettleTime(what))))
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MeasureRange sets the switch and prepares the VNA at the same time, then
+// sweeps once the switch has settled. A sweep cannot be stopped part way
+// through, so ctx is checked up until the sweep starts.
+func (h *Hardware) MeasureRange(ctx context.Context, rq *pocket.RangeQuery) error {
 
 	if rq == nil {
 		return errors.New("nil command")
 	}
-	err := h.Switch.SetPort(rq.What)
+
+	// a VNA that can prepare does so while the switch is being set
+	prepared := make(chan error, 1)
+
+	if p, ok := (*h.VNA).(pocket.Preparer); ok {
+		go func() {
+			prepared <- p.Prepare(rq)
+		}()
+	} else {
+		prepared <- nil
+	}
+
+	start := time.Now()
+
+	at, err := h.ready(ctx, rq.What)
+
+	if err != nil {
+		<-prepared
+		return err
+	}
+
+	err = h.settle(ctx, rq.What, at)
+
+	if err != nil {
+		<-prepared
+		return err
+	}
+
+	settled := time.Now()
+
+	err = <-prepared
 
 	if err != nil {
-		return fmt.Errorf("error setting switch to %s because %s", rq.What, err.Error())
+		return err
 	}
+
 	log.Infof("pkg/measure: range query requested")
-	return (*h.VNA).RangeQuery(rq)
+	err = (*h.VNA).RangeQuery(rq)
+
+	if err == nil {
+		h.learn(rq, time.Since(settled))
+		h.measured()
+	}
+
+	if t := TimingFrom(ctx); t != nil {
+		t.Switch += settled.Sub(start).Seconds()
+		t.Sweep += time.Since(settled).Seconds()
+	}
+
+	return err
+
+}
+
+// Work is the number of readings the VNA makes in one sweep of rq, i.e. the
+// points, each multiplied by the number of readings averaged at it
+func Work(rq *pocket.RangeQuery) int {
+
+	points := rq.Size
+
+	if len(rq.Frequencies) > 0 {
+		points = len(rq.Frequencies)
+	}
+
+	a := int(rq.Avg)
+
+	if a == 0 {
+		a = 1
+	}
+
+	return points * a
+}
+
+// learn updates the time per reading from a sweep of rq that took d, weighting
+// the latest sweep by a quarter, so that one slow sweep does not throw it out
+func (h *Hardware) learn(rq *pocket.RangeQuery, d time.Duration) {
+
+	w := Work(rq)
+
+	if w <= 0 {
+		return
+	}
+
+	t := d / time.Duration(w)
+
+	if h.pointTime == 0 {
+		h.pointTime = t
+		return
+	}
+
+	h.pointTime = (3*h.pointTime + t) / 4
+}
+
+// ReopenVNA closes the VNA with release, if it is not nil, and opens it again, returning
+// the func to close it from then on. The time it was opened is kept, as it is not
+// powered off, so it carries on warming up.
+func (h *Hardware) ReopenVNA(release func() error) (func() error, error) {
+
+	h.wait() // let a switch change finish first
+
+	if release != nil {
+		if err := release(); err != nil {
+			log.Warnf("pkg/measure: could not close the VNA because %s", err.Error())
+		}
+	}
+
+	return (*h.VNA).Connect()
+}
+
+// ReopenSwitch closes the switch and opens it again with port, baud and timeout, as for
+// Switch.Open. Where it is is not known until it is next set.
+func (h *Hardware) ReopenSwitch(port string, baud int, timeout time.Duration) error {
+
+	h.wait()
+
+	if err := h.Switch.Close(); err != nil {
+		log.Warnf("pkg/measure: could not close the switch because %s", err.Error())
+	}
+
+	h.at = ""
+
+	return h.Switch.Open(port, baud, timeout)
+}
+
+// FlashSwitch replaces the firmware of the switch controller with image, talking to its
+// bootloader at baud, once the switch is still, and opens it again with the new firmware
+func (h *Hardware) FlashSwitch(ctx context.Context, image []byte, baud int) error {
+
+	f, ok := h.Switch.(rfusb.Flasher)
+
+	if !ok {
+		return errors.New("the firmware of this switch cannot be flashed, only that of an arduino on a usb serial port")
+	}
+
+	h.wait()
+
+	h.at = ""
+
+	return f.Flash(ctx, image, baud)
+}
+
+// ProtocolTest puts the switch controller through rfusb.ProtocolTest, once the switch is
+// still, and counts the moves it made
+func (h *Hardware) ProtocolTest(ctx context.Context, toggles int) (rfusb.Conformance, error) {
+
+	t, ok := h.Switch.(rfusb.ProtocolTester)
+
+	if !ok {
+		return rfusb.Conformance{}, errors.New("the protocol of this switch cannot be tested, only that of a controller on a usb serial port or the network")
+	}
+
+	h.wait()
+
+	c, err := t.ProtocolTest(ctx, toggles)
+
+	h.at = ""
+
+	if _, known := rfusb.Channels[c.Port]; known && err == nil {
+		h.at = c.Port
+	}
+
+	for position, n := range c.Moves {
+		for i := 0; i < n; i++ {
+			h.Wear.Add(position)
+		}
+	}
+
+	return c, err
+}
+
+// measured notes when the VNA first measured
+func (h *Hardware) measured() {
+	if h.first.IsZero() {
+		h.first = time.Now()
+	}
+}
 
+// Opened returns when the VNA was opened, which is taken as when it was powered on
+func (h *Hardware) Opened() time.Time {
+	return h.opened
 }
 
-func (m *Mock) MeasureRange(rq *pocket.RangeQuery) error {
+// FirstMeasured returns when the VNA first measured, or zero if it has not yet
+func (h *Hardware) FirstMeasured() time.Time {
+	return h.first
+}
+
+// PointTime returns the time taken to sweep one point at an average of one, as
+// learned from the sweeps made so far, or zero if there have been none
+func (h *Hardware) PointTime() time.Duration {
+	return h.pointTime
+}
+
+func (m *Mock) MeasureRange(ctx context.Context, rq *pocket.RangeQuery) error {
 	if rq == nil {
 		return errors.New("nil command")
 	}
@@ -78,23 +548,375 @@ func (m *Mock) MeasureRange(rq *pocket.RangeQuery) error {
 
 }
 
-func (h *Hardware) MeasureSingle(sq *pocket.SingleQuery) error {
+// MaxAvg is the most readings the VNA can average at each frequency
+const MaxAvg = 1000
+
+// CheckWhat returns an error if what is not a position of the switch
+func CheckWhat(what string) error {
+
+	if _, ok := rfusb.Channels[strings.ToLower(what)]; ok {
+		return nil
+	}
+
+	var p []string
+
+	for k := range rfusb.Channels {
+		p = append(p, k)
+	}
+
+	sort.Strings(p)
+
+	if what == "" {
+		return fmt.Errorf("what must be given, one of %s", strings.Join(p, ", "))
+	}
+
+	return fmt.Errorf("what must be one of %s, not %s", strings.Join(p, ", "), what)
+}
+
+// CheckAvg returns an error if the VNA cannot average avg readings
+func CheckAvg(avg uint16) error {
+
+	if avg > MaxAvg {
+		return fmt.Errorf("avg must be no more than %d, not %d", MaxAvg, avg)
+	}
+
+	return nil
+}
+
+// CheckRange returns an error describing what is wrong with the sweep in rq, so
+// that it is found before the switch or VNA is used, rather than part way through.
+// device is the reasonable frequency range of the VNA, or zero if it is not known.
+func CheckRange(rq *pocket.RangeQuery, device pocket.Range) error {
+
+	if rq == nil {
+		return errors.New("nil command")
+	}
+
+	err := CheckWhat(rq.What)
+
+	if err != nil {
+		return err
+	}
+
+	err = CheckAvg(rq.Avg)
+
+	if err != nil {
+		return err
+	}
+
+	// the lowest and highest frequency in the sweep
+	var lo, hi uint64
+
+	switch {
+
+	case len(rq.Frequencies) > 0 && len(rq.Segments) > 0:
+		return errors.New("use either frequencies or segments, not both")
+
+	case len(rq.Frequencies) > 0:
+
+		err = pocket.CheckFrequencies(rq.Frequencies)
+
+		if err != nil {
+			return err
+		}
+
+		lo, hi = rq.Frequencies[0], rq.Frequencies[len(rq.Frequencies)-1]
+
+	case len(rq.Segments) > 0:
+
+		err = pocket.CheckSegments(rq.Segments)
+
+		if err != nil {
+			return err
+		}
+
+		for i, g := range rq.Segments {
+			if err := CheckAvg(g.Avg); err != nil {
+				return fmt.Errorf("segment %d %s", i+1, err.Error())
+			}
+		}
+
+		lo, hi = rq.Segments[0].Start, rq.Segments[len(rq.Segments)-1].End
+
+	default:
+
+		if rq.Size < 2 || rq.Size > pocket.MaxPoints {
+			return fmt.Errorf("size must be between 2 and %d points, not %d", pocket.MaxPoints, rq.Size)
+		}
+
+		if rq.Range.Start == 0 {
+			return errors.New("range start must be greater than zero")
+		}
+
+		if rq.Range.End <= rq.Range.Start {
+			return fmt.Errorf("range end (%d Hz) must be above the start (%d Hz)", rq.Range.End, rq.Range.Start)
+		}
+
+		lo, hi = rq.Range.Start, rq.Range.End
+	}
+
+	if device.End == 0 {
+		return nil
+	}
+
+	if lo < device.Start || hi > device.End {
+		return fmt.Errorf("frequencies must be within the range of the VNA, %d Hz to %d Hz, not %d Hz to %d Hz", device.Start, device.End, lo, hi)
+	}
+
+	return nil
+}
+
+// span returns the lowest and highest frequency of the sweep in rq, which has been checked
+func span(rq *pocket.RangeQuery) (uint64, uint64) {
+
+	switch {
+	case len(rq.Frequencies) > 0:
+		return rq.Frequencies[0], rq.Frequencies[len(rq.Frequencies)-1]
+	case len(rq.Segments) > 0:
+		return rq.Segments[0].Start, rq.Segments[len(rq.Segments)-1].End
+	default:
+		return rq.Range.Start, rq.Range.End
+	}
+}
+
+// Within returns an error if the sweep in rq, which has been checked with CheckRange,
+// goes outside allowed, the frequencies that may be measured on this rig. An End of
+// zero is no upper limit.
+func Within(rq *pocket.RangeQuery, allowed pocket.Range) error {
+
+	lo, hi := span(rq)
+
+	if lo < allowed.Start || (allowed.End > 0 && hi > allowed.End) {
+		return fmt.Errorf("frequencies must be within %s on this rig, not %d Hz to %d Hz", describe(allowed), lo, hi)
+	}
+
+	return nil
+}
+
+// Clamp moves the sweep in rq, which has been checked with CheckRange, inside allowed,
+// and returns whether it was changed. A range or segment is cut short, keeping its
+// size, and frequencies or segments outside are dropped. It is an error if there is
+// nothing left to measure.
+func Clamp(rq *pocket.RangeQuery, allowed pocket.Range) (bool, error) {
+
+	if Within(rq, allowed) == nil {
+		return false, nil
+	}
+
+	in := func(f uint64) bool {
+		return f >= allowed.Start && (allowed.End == 0 || f <= allowed.End)
+	}
+
+	cut := func(r pocket.Range) pocket.Range {
+		if r.Start < allowed.Start {
+			r.Start = allowed.Start
+		}
+		if allowed.End > 0 && r.End > allowed.End {
+			r.End = allowed.End
+		}
+		return r
+	}
+
+	switch {
+
+	case len(rq.Frequencies) > 0:
+
+		var f []uint64
+
+		for _, v := range rq.Frequencies {
+			if in(v) {
+				f = append(f, v)
+			}
+		}
+
+		if len(f) < 2 {
+			return false, fmt.Errorf("fewer than two of the frequencies are within %s on this rig", describe(allowed))
+		}
+
+		rq.Frequencies = f
+
+	case len(rq.Segments) > 0:
+
+		var g []pocket.Segment
+
+		for _, v := range rq.Segments {
+
+			r := cut(pocket.Range{Start: v.Start, End: v.End})
+
+			if r.End <= r.Start {
+				continue
+			}
+
+			v.Start, v.End = r.Start, r.End
+			g = append(g, v)
+		}
+
+		if len(g) == 0 {
+			return false, fmt.Errorf("none of the segments are within %s on this rig", describe(allowed))
+		}
+
+		rq.Segments = g
+
+	default:
+
+		r := cut(rq.Range)
+
+		if r.End <= r.Start {
+			return false, fmt.Errorf("range %d Hz to %d Hz is not within %s on this rig", rq.Range.Start, rq.Range.End, describe(allowed))
+		}
+
+		rq.Range = r
+	}
+
+	return true, nil
+}
+
+// describe describes allowed, which has no upper limit if End is zero
+func describe(allowed pocket.Range) string {
+
+	if allowed.End == 0 {
+		return fmt.Sprintf("%d Hz and above", allowed.Start)
+	}
+
+	return fmt.Sprintf("%d Hz to %d Hz", allowed.Start, allowed.End)
+}
+
+// MaxTimeCount limits the number of readings in a time query
+const MaxTimeCount = 10000
+
+// MaxTimeDuration limits the length of a time query, so that it finishes well
+// within the request timeout
+const MaxTimeDuration = 60 * time.Second
+
+// CheckTime returns an error if a time query does not say when to stop, or goes on too long
+func CheckTime(tq *pocket.TimeQuery) error {
+
+	if tq.Count <= 0 && tq.Duration <= 0 {
+		return errors.New("give a count or a duration (or both) for a time query")
+	}
+
+	if tq.Count > MaxTimeCount {
+		return fmt.Errorf("count must be no more than %d, not %d", MaxTimeCount, tq.Count)
+	}
+
+	if tq.Duration*float64(time.Second) > float64(MaxTimeDuration) {
+		return fmt.Errorf("duration must be no more than %s, not %gs", MaxTimeDuration, tq.Duration)
+	}
+
+	return nil
+}
+
+// MeasureTime sets the switch once, then takes single readings one after the
+// other until there are tq.Count of them, or tq.Duration has passed, or ctx is done
+func (h *Hardware) MeasureTime(ctx context.Context, tq *pocket.TimeQuery) error {
+
+	if tq == nil {
+		return errors.New("nil command")
+	}
+
+	err := CheckTime(tq)
+
+	if err != nil {
+		return err
+	}
+
+	at, err := h.ready(ctx, tq.What)
+
+	if err != nil {
+		return err
+	}
+
+	err = h.settle(ctx, tq.What, at)
+
+	if err != nil {
+		return err
+	}
+
+	log.Infof("pkg/measure: time query requested")
+
+	sq := pocket.SingleQuery{
+		Command: tq.Command,
+		Freq:    tq.Freq,
+		Avg:     tq.Avg,
+		Select:  tq.Select,
+		What:    tq.What,
+	}
+
+	duration := time.Duration(tq.Duration * float64(time.Second))
+
+	tq.Result = nil
+
+	var start time.Time
+
+	for {
+
+		now := time.Now()
+
+		if start.IsZero() {
+			start = now
+		}
+
+		t := now.Sub(start)
+
+		if tq.Duration > 0 && t >= duration {
+			break
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		err = (*h.VNA).SingleQuery(&sq)
+
+		if err != nil {
+			return err
+		}
+
+		h.measured()
+
+		p := sq.Result
+		p.Freq = tq.Freq
+
+		tq.Result = append(tq.Result, pocket.TimeSParam{Time: t.Seconds(), SParam: p})
+
+		if len(tq.Result) >= MaxTimeCount || (tq.Count > 0 && len(tq.Result) >= tq.Count) {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (h *Hardware) MeasureSingle(ctx context.Context, sq *pocket.SingleQuery) error {
 
 	if sq == nil {
 		return errors.New("nil command")
 	}
-	err := h.Switch.SetPort(sq.What)
+	at, err := h.ready(ctx, sq.What)
+
+	if err != nil {
+		return err
+	}
+
+	err = h.settle(ctx, sq.What, at)
 
 	if err != nil {
-		return fmt.Errorf("error setting switch to %s because %s", sq.What, err.Error())
+		return err
 	}
+
 	log.Infof("pkg/measure: single query requested")
 
-	return (*h.VNA).SingleQuery(sq)
+	err = (*h.VNA).SingleQuery(sq)
+
+	if err == nil {
+		h.measured()
+	}
+
+	return err
 
 }
 
-func (m *Mock) MeasureSingle(sq *pocket.SingleQuery) error {
+func (m *Mock) MeasureSingle(ctx context.Context, sq *pocket.SingleQuery) error {
 	if sq == nil {
 		return errors.New("nil command")
 	}
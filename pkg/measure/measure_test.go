@@ -0,0 +1,396 @@
+package measure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/rfusb"
+	"github.com/practable/pocket-vna-two-port/pkg/wear"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSettle(t *testing.T) {
+
+	s, err := ParseSettle("")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(s))
+
+	s, err = ParseSettle("thru=100ms, dut1=1s")
+	assert.NoError(t, err)
+	assert.Equal(t, 100*time.Millisecond, s["thru"])
+	assert.Equal(t, time.Second, s["dut1"])
+
+	_, err = ParseSettle("thru")
+	assert.Error(t, err)
+
+	_, err = ParseSettle("thru=soon")
+	assert.Error(t, err)
+
+}
+
+func TestParsePresets(t *testing.T) {
+
+	p, err := ParsePresets("")
+	assert.NoError(t, err)
+	assert.Empty(t, p)
+
+	p, err = ParsePresets("full=1e6-4e9/201, uhf=300000000-1000000000/101/LOG")
+	assert.NoError(t, err)
+	assert.Equal(t, []pocket.Preset{
+		{Name: "full", Range: pocket.Range{Start: 1e6, End: 4e9}, Size: 201},
+		{Name: "uhf", Range: pocket.Range{Start: 300e6, End: 1e9}, Size: 101, LogDistribution: true},
+	}, p)
+
+	for _, bad := range []string{
+		"full",
+		"=1e6-4e9/201",
+		"full=1e6-4e9",
+		"full=1e6/201",
+		"full=1e6-4e9/201/lin",
+		"full=1.5-4e9/201",
+		"full=1e6-4e9/many",
+		"full=1e6-4e9/1000",
+		"full=4e9-1e6/201",
+		"full=1e6-4e9/201,Full=1e6-2e9/11",
+	} {
+		_, err = ParsePresets(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestCheckRange(t *testing.T) {
+
+	device := pocket.Range{Start: 500e3, End: 4e9}
+
+	good := pocket.RangeQuery{What: "DUT1", Range: pocket.Range{Start: 1e6, End: 4e9}, Size: 201, Avg: 1}
+	assert.NoError(t, CheckRange(&good, device))
+
+	tests := map[string]struct {
+		change func(rq *pocket.RangeQuery)
+		msg    string
+	}{
+		"what":   {func(rq *pocket.RangeQuery) { rq.What = "dut5" }, "what must be one of dut1, dut2, dut3, dut4, load, open, short, thru, not dut5"},
+		"nowhat": {func(rq *pocket.RangeQuery) { rq.What = "" }, "what must be given, one of dut1, dut2, dut3, dut4, load, open, short, thru"},
+		"avg":    {func(rq *pocket.RangeQuery) { rq.Avg = 1001 }, "avg must be no more than 1000, not 1001"},
+		"size":   {func(rq *pocket.RangeQuery) { rq.Size = 1 }, "size must be between 2 and 512 points, not 1"},
+		"big":    {func(rq *pocket.RangeQuery) { rq.Size = 513 }, "size must be between 2 and 512 points, not 513"},
+		"zero":   {func(rq *pocket.RangeQuery) { rq.Range.Start = 0 }, "range start must be greater than zero"},
+		"span":   {func(rq *pocket.RangeQuery) { rq.Range.End = 1e6 }, "range end (1000000 Hz) must be above the start (1000000 Hz)"},
+		"high":   {func(rq *pocket.RangeQuery) { rq.Range.End = 6e9 }, "frequencies must be within the range of the VNA, 500000 Hz to 4000000000 Hz, not 1000000 Hz to 6000000000 Hz"},
+		"low":    {func(rq *pocket.RangeQuery) { rq.Frequencies = []uint64{100e3, 1e6} }, "frequencies must be within the range of the VNA, 500000 Hz to 4000000000 Hz, not 100000 Hz to 1000000 Hz"},
+		"both":   {func(rq *pocket.RangeQuery) { rq.Frequencies, rq.Segments = []uint64{1e6}, []pocket.Segment{{}} }, "use either frequencies or segments, not both"},
+		"segment": {func(rq *pocket.RangeQuery) {
+			rq.Segments = []pocket.Segment{{Start: 1e6, End: 2e6, Size: 2, Avg: 2000}}
+		}, "segment 1 avg must be no more than 1000, not 2000"},
+	}
+
+	for name, tc := range tests {
+		rq := good
+		tc.change(&rq)
+		err := CheckRange(&rq, device)
+		if assert.Error(t, err, name) {
+			assert.Equal(t, tc.msg, err.Error(), name)
+		}
+	}
+
+	// the device range is not checked if it is not known
+	rq := good
+	rq.Range.End = 6e9
+	assert.NoError(t, CheckRange(&rq, pocket.Range{}))
+}
+
+func TestClamp(t *testing.T) {
+
+	allowed := pocket.Range{Start: 10e6, End: 3e9}
+
+	rq := pocket.RangeQuery{What: "dut1", Range: pocket.Range{Start: 1e6, End: 4e9}, Size: 201}
+	assert.EqualError(t, Within(&rq, allowed), "frequencies must be within 10000000 Hz to 3000000000 Hz on this rig, not 1000000 Hz to 4000000000 Hz")
+
+	changed, err := Clamp(&rq, allowed)
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, allowed, rq.Range)
+	assert.Equal(t, 201, rq.Size)
+	assert.NoError(t, Within(&rq, allowed))
+
+	// already inside
+	changed, err = Clamp(&rq, allowed)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+
+	// no upper limit
+	assert.NoError(t, Within(&pocket.RangeQuery{Range: pocket.Range{Start: 10e6, End: 6e9}}, pocket.Range{Start: 10e6}))
+	assert.EqualError(t, Within(&pocket.RangeQuery{Range: pocket.Range{Start: 1e6, End: 6e9}}, pocket.Range{Start: 10e6}), "frequencies must be within 10000000 Hz and above on this rig, not 1000000 Hz to 6000000000 Hz")
+
+	rq = pocket.RangeQuery{Frequencies: []uint64{1e6, 100e6, 200e6, 3.5e9}}
+	_, err = Clamp(&rq, allowed)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint64{100e6, 200e6}, rq.Frequencies)
+
+	rq = pocket.RangeQuery{Frequencies: []uint64{1e6, 100e6, 3.5e9}}
+	_, err = Clamp(&rq, allowed)
+	assert.Error(t, err)
+
+	rq = pocket.RangeQuery{Segments: []pocket.Segment{
+		{Start: 1e6, End: 5e6, Size: 10},
+		{Start: 5e6, End: 100e6, Size: 20},
+		{Start: 2e9, End: 4e9, Size: 30},
+	}}
+	_, err = Clamp(&rq, allowed)
+	assert.NoError(t, err)
+	assert.Equal(t, []pocket.Segment{{Start: 10e6, End: 100e6, Size: 20}, {Start: 2e9, End: 3e9, Size: 30}}, rq.Segments)
+
+	rq = pocket.RangeQuery{Range: pocket.Range{Start: 3.5e9, End: 4e9}, Size: 2}
+	_, err = Clamp(&rq, allowed)
+	assert.Error(t, err)
+}
+
+func TestSettleTime(t *testing.T) {
+
+	ctx := context.Background()
+
+	var v pocket.VNA = pocket.NewMock()
+
+	h := NewHardware(&v, rfusb.NewMock())
+
+	h.Settle = 10 * time.Millisecond
+	h.SettleFor["dut1"] = 50 * time.Millisecond
+
+	assert.Equal(t, 10*time.Millisecond, h.SettleTime("short"))
+	assert.Equal(t, 50*time.Millisecond, h.SettleTime("dut1"))
+
+	t0 := time.Now()
+	err := h.MeasureRange(ctx, &pocket.RangeQuery{What: "dut1"})
+	assert.NoError(t, err)
+	assert.True(t, time.Since(t0) >= 50*time.Millisecond)
+	assert.Equal(t, "dut1", h.Switch.Get())
+
+}
+
+func TestSetPortVerify(t *testing.T) {
+
+	ctx := context.Background()
+
+	var v pocket.VNA = pocket.NewMock()
+
+	s := rfusb.NewMock()
+
+	h := NewHardware(&v, s)
+
+	// one misreport is fixed by the retry
+	s.Misreport = 1
+	err := h.MeasureRange(ctx, &pocket.RangeQuery{What: "thru"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, s.Misreport)
+
+	// a switch that stays in the wrong position is an error
+	s.Misreport = 2
+	err = h.MeasureSingle(ctx, &pocket.SingleQuery{What: "dut2"})
+	assert.Error(t, err)
+
+}
+
+func TestOnSwitch(t *testing.T) {
+
+	ctx := context.Background()
+
+	var v pocket.VNA = pocket.NewMock()
+
+	h := NewHardware(&v, rfusb.NewMock())
+	h.Wear = wear.New()
+
+	var moves [][2]string
+
+	h.OnSwitch = func(from, to string) {
+		moves = append(moves, [2]string{from, to})
+	}
+
+	assert.NoError(t, h.SetPort(ctx, "short"))
+	assert.NoError(t, h.SetPort(ctx, "short")) // not moved, so not called
+	assert.NoError(t, h.SetPort(ctx, "thru"))
+
+	// nor if the switch is not where it was asked to be
+	s := rfusb.NewMock()
+	s.Misreport = 2
+	h.Switch = s
+	assert.Error(t, h.SetPort(ctx, "dut1"))
+
+	assert.Equal(t, [][2]string{{"", "short"}, {"short", "thru"}}, moves)
+
+	// and the moves are counted the same way
+	assert.Equal(t, map[string]uint64{"short": 1, "thru": 1}, h.Wear.Wear().Counts)
+}
+
+func TestPointTime(t *testing.T) {
+
+	var v pocket.VNA = pocket.NewMock()
+
+	h := NewHardware(&v, rfusb.NewMock())
+	assert.Equal(t, time.Duration(0), h.PointTime())
+
+	rq := &pocket.RangeQuery{Size: 100, Avg: 2}
+	assert.Equal(t, 200, Work(rq))
+
+	h.learn(rq, 400*time.Millisecond)
+	assert.Equal(t, 2*time.Millisecond, h.PointTime())
+
+	// a slow sweep only counts for a quarter
+	h.learn(&pocket.RangeQuery{Frequencies: []uint64{1, 2, 3, 4}}, 24*time.Millisecond)
+	assert.Equal(t, 3*time.Millisecond, h.PointTime())
+
+	assert.NoError(t, h.MeasureRange(context.Background(), &pocket.RangeQuery{What: "thru", Size: 2}))
+	assert.True(t, h.PointTime() < 3*time.Millisecond)
+}
+
+func TestMeasureTime(t *testing.T) {
+
+	ctx := context.Background()
+
+	mock := pocket.NewMock()
+	mock.ResultSingleQuery = pocket.SParam{S11: pocket.Complex{Real: 0.5}}
+
+	var v pocket.VNA = mock
+
+	s := rfusb.NewMock()
+
+	h := NewHardware(&v, s)
+
+	tq := pocket.TimeQuery{What: "dut2", Freq: 433e6, Count: 5}
+
+	err := h.MeasureTime(ctx, &tq)
+	assert.NoError(t, err)
+	assert.Equal(t, "dut2", s.Get())
+	assert.Equal(t, 5, len(tq.Result))
+	assert.Equal(t, 0.0, tq.Result[0].Time)
+	assert.True(t, tq.Result[4].Time >= tq.Result[3].Time)
+	assert.Equal(t, uint64(433e6), tq.Result[4].Freq)
+	assert.Equal(t, 0.5, tq.Result[4].S11.Real)
+
+	// duration only
+	tq = pocket.TimeQuery{What: "dut2", Duration: 0.02}
+	err = h.MeasureTime(ctx, &tq)
+	assert.NoError(t, err)
+	assert.True(t, len(tq.Result) > 0)
+	assert.True(t, tq.Result[len(tq.Result)-1].Time < 0.02)
+
+	assert.Error(t, h.MeasureTime(ctx, &pocket.TimeQuery{What: "dut2"}))
+	assert.Error(t, h.MeasureTime(ctx, &pocket.TimeQuery{What: "dut2", Count: MaxTimeCount + 1}))
+	assert.Error(t, h.MeasureTime(ctx, &pocket.TimeQuery{What: "dut2", Duration: 3600}))
+}
+
+func TestOverlap(t *testing.T) {
+
+	ctx := context.Background()
+
+	mock := pocket.NewMock()
+	mock.PrepareDelay = 40 * time.Millisecond
+
+	var v pocket.VNA = mock
+
+	s := rfusb.NewMock()
+	s.Delay = 40 * time.Millisecond
+
+	h := NewHardware(&v, s)
+
+	// the VNA is prepared while the switch is set
+	t0 := time.Now()
+	err := h.MeasureRange(ctx, &pocket.RangeQuery{What: "short"})
+	assert.NoError(t, err)
+	assert.True(t, time.Since(t0) < 75*time.Millisecond)
+	assert.Equal(t, 1, mock.Prepared)
+	assert.Equal(t, "short", s.Get())
+
+	// the switch moves and settles while the last result is processed
+	mock.PrepareDelay = 0
+	h.Settle = 40 * time.Millisecond
+
+	h.Next(ctx, "open")
+	time.Sleep(100 * time.Millisecond)
+
+	t0 = time.Now()
+	err = h.MeasureRange(ctx, &pocket.RangeQuery{What: "open"})
+	assert.NoError(t, err)
+	assert.True(t, time.Since(t0) < 30*time.Millisecond)
+	assert.Equal(t, "open", s.Get())
+
+	// something other than what is next waits for the switch, then sets it again
+	h.Next(ctx, "load")
+
+	t0 = time.Now()
+	err = h.MeasureSingle(ctx, &pocket.SingleQuery{What: "thru"})
+	assert.NoError(t, err)
+	assert.True(t, time.Since(t0) >= 120*time.Millisecond)
+	assert.Equal(t, "thru", s.Get())
+}
+
+func TestCancel(t *testing.T) {
+
+	var v pocket.VNA = pocket.NewMock()
+
+	s := rfusb.NewMock()
+
+	h := NewHardware(&v, s)
+	h.Settle = time.Second
+
+	// settling stops when the request is cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	t0 := time.Now()
+	err := h.MeasureRange(ctx, &pocket.RangeQuery{What: "dut1"})
+	assert.Error(t, err)
+	assert.True(t, time.Since(t0) < 500*time.Millisecond)
+
+	// so does setting the switch
+	s.Delay = time.Second
+
+	t0 = time.Now()
+	err = h.MeasureSingle(ctx, &pocket.SingleQuery{What: "dut2"})
+	assert.Error(t, err)
+	assert.True(t, time.Since(t0) < 500*time.Millisecond)
+
+	// and a time query
+	s.Delay = 0
+	h.Settle = 0
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	err = h.MeasureTime(ctx, &pocket.TimeQuery{What: "dut2", Count: 5})
+	assert.Error(t, err)
+}
+
+func TestTiming(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.PrepareDelay = 80 * time.Millisecond
+
+	var v pocket.VNA = mock
+
+	s := rfusb.NewMock()
+	s.Delay = 30 * time.Millisecond
+
+	h := NewHardware(&v, s)
+	h.Settle = 20 * time.Millisecond
+
+	// nothing is recorded without a timing
+	assert.Nil(t, TimingFrom(context.Background()))
+	assert.NoError(t, h.MeasureRange(context.Background(), &pocket.RangeQuery{What: "short"}))
+
+	tm := &pocket.Timing{}
+	ctx := WithTiming(context.Background(), tm)
+	assert.Equal(t, tm, TimingFrom(ctx))
+
+	// the VNA is still being prepared once the switch has settled
+	assert.NoError(t, h.MeasureRange(ctx, &pocket.RangeQuery{What: "open"}))
+	assert.True(t, tm.Switch >= 0.05, tm.Switch)
+	assert.True(t, tm.Sweep >= 0.01, tm.Sweep)
+	assert.True(t, tm.Switch+tm.Sweep < 0.2)
+
+	// and added to by each sweep
+	sw := tm.Switch
+	assert.NoError(t, h.MeasureRange(ctx, &pocket.RangeQuery{What: "load"}))
+	assert.True(t, tm.Switch > sw+0.04)
+}
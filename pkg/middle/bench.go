@@ -0,0 +1,156 @@
+package middle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/calibration"
+	"github.com/practable/pocket-vna-two-port/pkg/format"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+)
+
+// DefaultBenchSize is the number of points in a bench sweep if none is given
+const DefaultBenchSize = 1001
+
+// MaxBenchSize is the most points in a bench sweep, which is synthetic, so can
+// have more points than the VNA can measure at once, as a segmented sweep does
+const MaxBenchSize = 10001
+
+// DefaultBenchRepeat is the number of times each stage is timed if not given
+const DefaultBenchRepeat = 10
+
+// MaxBenchRepeat is the most times each stage can be timed
+const MaxBenchRepeat = 100
+
+// benchTerms are the error terms of the synthetic VNA used by bench, like those
+// of a real one, so that correcting with them does the same arithmetic
+var benchTerms = twoport.ErrorTerms{
+	Edf: 0.05, Esf: 0.1 + 0.02i, Erf: 0.9, Etf: 0.8, Elf: 0.08, Exf: 0.001,
+	Edr: 0.04i, Esr: 0.12, Err: 0.85, Etr: 0.8, Elr: 0.09 - 0.01i, Exr: 0.001,
+}
+
+// benchDUT is the device measured by bench
+var benchDUT = twoport.S{{0.1 + 0.05i, 0.5}, {0.5, 0.1 - 0.05i}}
+
+// func Bench times each stage of handling a calibrated sweep, with synthetic data
+// in place of the VNA: making the raw measurement, correcting it with the error
+// terms, converting it to and from the protocol buffers of the calibration service,
+// a round trip to the calibration backend, formatting the result in dB, and
+// marshalling the response. The state of the rig, including its cal, is not changed.
+func (m *Middle) Bench(ctx context.Context, request *pocket.Bench) error {
+
+	if request.Size == 0 {
+		request.Size = DefaultBenchSize
+	}
+
+	if request.Repeat == 0 {
+		request.Repeat = DefaultBenchRepeat
+	}
+
+	if request.Size < 2 || request.Size > MaxBenchSize {
+		return fmt.Errorf("size must be 2 to %d, not %d", MaxBenchSize, request.Size)
+	}
+
+	if request.Repeat < 1 || request.Repeat > MaxBenchRepeat {
+		return fmt.Errorf("repeat must be 1 to %d, not %d", MaxBenchRepeat, request.Repeat)
+	}
+
+	freq := pocket.LinFrequency(1e6, 3e9, request.Size)
+
+	std := &calibration.Standards{}
+
+	for _, f := range freq {
+		std.Short = append(std.Short, benchTerms.Measure(twoport.Ideal.Short).SParam(f))
+		std.Open = append(std.Open, benchTerms.Measure(twoport.Ideal.Open).SParam(f))
+		std.Load = append(std.Load, benchTerms.Measure(twoport.Ideal.Load).SParam(f))
+		std.Thru = append(std.Thru, benchTerms.Measure(twoport.Ideal.Thru).SParam(f))
+	}
+
+	var raw, dut []pocket.SParam
+	var formatted []pocket.FormattedSParam
+
+	stages := []struct {
+		name string
+		run  func() error
+	}{
+		{"measure", func() error {
+			raw = make([]pocket.SParam, len(freq))
+			for i, f := range freq {
+				raw[i] = benchTerms.Measure(benchDUT).SParam(f)
+			}
+			return nil
+		}},
+		{"correct", func() error {
+			dut = make([]pocket.SParam, len(raw))
+			for i, v := range raw {
+				dut[i] = benchTerms.Correct(twoport.FromSParam(v)).SParam(v.Freq)
+			}
+			return nil
+		}},
+		{"convert", func() error {
+			dut = calibration.Cal2Meas(calibration.Meas2Freq(dut), calibration.Meas2Cal(dut))
+			return nil
+		}},
+		{"calibrate", func() error {
+			if m.cal == nil {
+				return errors.New("there is no calibration backend")
+			}
+			_, err := m.cal.Calibrate(ctx, freq, std, raw)
+			return err
+		}},
+		{"format", func() (err error) {
+			formatted, err = format.Apply(format.DB, dut)
+			return err
+		}},
+		{"marshal", func() error {
+			_, err := pocket.Marshal(pocket.CalibratedRangeQuery{
+				Command:   request.Command,
+				Result:    dut,
+				Formatted: formatted,
+			})
+			return err
+		}},
+	}
+
+	request.Result = nil
+	request.Total = 0
+
+	for _, s := range stages {
+
+		r := pocket.BenchStage{Stage: s.name}
+
+		var sum time.Duration
+
+		for i := 0; i < request.Repeat; i++ {
+
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			start := time.Now()
+			err := s.run()
+			d := time.Since(start)
+
+			if err != nil {
+				r.Error = err.Error()
+				break
+			}
+
+			sum += d
+			r.Max = math.Max(r.Max, d.Seconds())
+		}
+
+		if r.Error == "" {
+			r.Mean = sum.Seconds() / float64(request.Repeat)
+			request.Total += r.Mean
+		}
+
+		request.Result = append(request.Result, r)
+	}
+
+	return nil
+}
@@ -0,0 +1,358 @@
+package middle
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
+	"strings"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/calibration"
+	"github.com/practable/pocket-vna-two-port/pkg/format"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/store"
+	"github.com/practable/pocket-vna-two-port/pkg/touchstone"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+)
+
+// CurrentCal names the current calibration in a comparecal
+const CurrentCal = "current"
+
+// FloorDB is given in place of the magnitude in dB of a reflection of nothing, e.g. the
+// thru of a cal corrected with its own error terms, since JSON has no infinity
+const FloorDB = -200
+
+// func CompareCal compares two calibrations, see pocket.CompareCal. The error terms of
+// each are found from its standards in the same way, with a plain SOLT calibration,
+// whichever backend made them, so that they can be compared. It is an error if either
+// cannot be found, or they have no frequencies in common.
+func (m *Middle) CompareCal(ctx context.Context, request *pocket.CompareCal) error {
+
+	if request.A == "" {
+		return invalid(errors.New("give the cal to compare with as a, which is the name of a cal saved by exportcal, or current"))
+	}
+
+	if request.B == "" {
+		request.B = CurrentCal
+	}
+
+	a, at, err := m.loadCal(request.A)
+
+	if err != nil {
+		return err
+	}
+
+	b, bt, err := m.loadCal(request.B)
+
+	if err != nil {
+		return err
+	}
+
+	request.ATime = at
+	request.BTime = bt
+
+	var freq []uint64
+
+	if len(a.Short) > 0 && len(b.Short) > 0 {
+		for _, p := range a.Short {
+			if p.Freq >= b.Short[0].Freq && p.Freq <= b.Short[len(b.Short)-1].Freq {
+				freq = append(freq, p.Freq)
+			}
+		}
+	}
+
+	if len(freq) == 0 {
+		return invalid(fmt.Errorf("cals %s and %s have no frequencies in common", request.A, request.B))
+	}
+
+	ta, err := termsAt(ctx, a, freq)
+
+	if err != nil {
+		return fmt.Errorf("could not find the error terms of %s because %s", request.A, err.Error())
+	}
+
+	b, err = resampleStandards(b, freq)
+
+	if err != nil {
+		return fmt.Errorf("could not compare %s at the frequencies of %s because %s", request.B, request.A, err.Error())
+	}
+
+	tb, err := termsAt(ctx, b, freq)
+
+	if err != nil {
+		return fmt.Errorf("could not find the error terms of %s because %s", request.B, err.Error())
+	}
+
+	request.Result = make([]pocket.CalDifference, len(freq))
+
+	worst := pocket.CalDifference{
+		Thru: pocket.FormattedSParam{S11: &pocket.Value{Mag: FloorDB}, S12: &pocket.Value{}, S21: &pocket.Value{}, S22: &pocket.Value{Mag: FloorDB}},
+	}
+
+	for i, f := range freq {
+
+		thru := ta[i].Correct(twoport.FromSParam(b.Thru[i])).SParam(f)
+
+		db, err := format.Apply(format.DB, []pocket.SParam{thru})
+
+		if err != nil {
+			return err
+		}
+
+		d := pocket.CalDifference{
+			Freq: f,
+			Thru: db[0],
+		}
+
+		for _, v := range []*pocket.Value{d.Thru.S11, d.Thru.S22} {
+			if math.IsInf(v.Mag, -1) || v.Mag < FloorDB {
+				v.Mag = FloorDB
+			}
+		}
+
+		// the worst match, rather than the largest change
+		worst.Thru.S11.Mag = math.Max(worst.Thru.S11.Mag, d.Thru.S11.Mag)
+		worst.Thru.S22.Mag = math.Max(worst.Thru.S22.Mag, d.Thru.S22.Mag)
+
+		largest(worst.Thru.S21, *d.Thru.S21)
+		largest(worst.Thru.S12, *d.Thru.S12)
+
+		ea, eb := errorTerms(ta[i]), errorTerms(tb[i])
+		values, worstValues := calTerms(&d.Terms), calTerms(&worst.Terms)
+
+		for j := range ea {
+			*values[j] = change(ea[j], eb[j])
+			largest(worstValues[j], *values[j])
+		}
+
+		request.Result[i] = d
+	}
+
+	request.Max = &worst
+
+	return nil
+}
+
+// func loadCal returns the standards of the cal with name, and when it was made: the
+// current cal, or one saved in the store by exportcal, by its name or key
+func (m *Middle) loadCal(name string) (*calibration.Standards, time.Time, error) {
+
+	if name == CurrentCal {
+
+		if m.std == nil {
+			return nil, time.Time{}, ErrNotCalibrated
+		}
+
+		return m.std, m.calAt.UTC(), nil
+	}
+
+	if m.store == nil {
+		return nil, time.Time{}, invalid(fmt.Errorf("there is no store to load the cal %s from, so set store", name))
+	}
+
+	key := name
+
+	if !strings.Contains(key, "/") {
+		key = CalDir + key
+	}
+
+	if !strings.HasSuffix(key, ".zip") {
+		key += ".zip"
+	}
+
+	b, err := m.store.Get(key)
+
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, time.Time{}, invalid(fmt.Errorf("there is no cal %s saved in the store", name))
+	}
+
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("could not load the cal %s because %s", name, err.Error())
+	}
+
+	s, at, err := readCal(b)
+
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("could not read the cal %s because %s", name, err.Error())
+	}
+
+	return s, at, nil
+}
+
+// func readCal returns the standards in a zip made by exportcal, and when the cal was made
+func readCal(b []byte) (*calibration.Standards, time.Time, error) {
+
+	z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	read := func(name string) ([]byte, error) {
+
+		f, err := z.Open(name)
+
+		if err != nil {
+			return nil, err
+		}
+
+		defer f.Close()
+
+		return io.ReadAll(f)
+	}
+
+	j, err := read("calibration.json")
+
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	var a pocket.CalArchive
+
+	err = json.Unmarshal(j, &a)
+
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	s := &calibration.Standards{}
+
+	files := []struct {
+		name string
+		s    *[]pocket.SParam
+	}{
+		{"short", &s.Short},
+		{"open", &s.Open},
+		{"load", &s.Load},
+		{"thru", &s.Thru},
+		{"ideal_short", &s.IdealShort},
+		{"ideal_open", &s.IdealOpen},
+		{"ideal_load", &s.IdealLoad},
+		{"ideal_thru", &s.IdealThru},
+	}
+
+	for _, f := range files {
+
+		name, ok := a.Files[f.name]
+
+		if !ok && strings.HasPrefix(f.name, "ideal_") {
+			continue // no cal kit
+		}
+
+		if !ok {
+			return nil, time.Time{}, fmt.Errorf("there is no %s", f.name)
+		}
+
+		t, err := read(name)
+
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		d, err := touchstone.Parse(string(t))
+
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("%s: %s", name, err.Error())
+		}
+
+		*f.s = d.SParam
+	}
+
+	return s, a.Time, nil
+}
+
+// func resampleStandards returns the standards s, and the cal kit, if any, at freq
+func resampleStandards(s *calibration.Standards, freq []uint64) (*calibration.Standards, error) {
+
+	r := &calibration.Standards{}
+
+	for _, p := range []struct {
+		from []pocket.SParam
+		to   *[]pocket.SParam
+	}{
+		{s.Short, &r.Short},
+		{s.Open, &r.Open},
+		{s.Load, &r.Load},
+		{s.Thru, &r.Thru},
+		{s.IdealShort, &r.IdealShort},
+		{s.IdealOpen, &r.IdealOpen},
+		{s.IdealLoad, &r.IdealLoad},
+		{s.IdealThru, &r.IdealThru},
+	} {
+
+		if p.from == nil {
+			continue
+		}
+
+		v, err := twoport.Resample(p.from, freq)
+
+		if err != nil {
+			return nil, err
+		}
+
+		*p.to = v
+	}
+
+	return r, nil
+}
+
+// func termsAt returns the error terms of the standards s at freq
+func termsAt(ctx context.Context, s *calibration.Standards, freq []uint64) ([]twoport.ErrorTerms, error) {
+
+	s, err := resampleStandards(s, freq)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := calibration.Native{}.Calibrate(ctx, freq, s, s.Thru)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Terms, nil
+}
+
+// func errorTerms lists the error terms e in the order of calTerms
+func errorTerms(e twoport.ErrorTerms) []complex128 {
+	return []complex128{e.Edf, e.Esf, e.Erf, e.Etf, e.Elf, e.Exf, e.Edr, e.Esr, e.Err, e.Etr, e.Elr, e.Exr}
+}
+
+// func calTerms lists the values in t in the order of errorTerms
+func calTerms(t *pocket.CalTerms) []*pocket.Value {
+	return []*pocket.Value{&t.Edf, &t.Esf, &t.Erf, &t.Etf, &t.Elf, &t.Exf, &t.Edr, &t.Esr, &t.Err, &t.Etr, &t.Elr, &t.Exr}
+}
+
+// func change returns the change from a to b, as the ratio of their magnitudes in dB,
+// and the difference of their phases in degrees, or no change if either is zero, which
+// has no value in dB
+func change(a, b complex128) pocket.Value {
+
+	if a == 0 || b == 0 {
+		return pocket.Value{}
+	}
+
+	return pocket.Value{
+		Mag:   20 * math.Log10(cmplx.Abs(b)/cmplx.Abs(a)),
+		Phase: cmplx.Phase(b*cmplx.Conj(a)) * 180 / math.Pi,
+	}
+}
+
+// func largest keeps the largest magnitude and phase of v and max in max, whatever their sign
+func largest(max *pocket.Value, v pocket.Value) {
+
+	if math.Abs(v.Mag) > math.Abs(max.Mag) {
+		max.Mag = v.Mag
+	}
+
+	if math.Abs(v.Phase) > math.Abs(max.Phase) {
+		max.Phase = v.Phase
+	}
+}
@@ -2,24 +2,57 @@
 package middle
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	runtimedebug "runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/practable/pocket-vna-two-port/pkg/audit"
+	"github.com/practable/pocket-vna-two-port/pkg/average"
+	"github.com/practable/pocket-vna-two-port/pkg/calibration"
+	"github.com/practable/pocket-vna-two-port/pkg/calkit"
+	"github.com/practable/pocket-vna-two-port/pkg/calstate"
+	"github.com/practable/pocket-vna-two-port/pkg/columns"
+	"github.com/practable/pocket-vna-two-port/pkg/config"
+	"github.com/practable/pocket-vna-two-port/pkg/crash"
+	"github.com/practable/pocket-vna-two-port/pkg/fleet"
+	"github.com/practable/pocket-vna-two-port/pkg/format"
+	"github.com/practable/pocket-vna-two-port/pkg/history"
+	"github.com/practable/pocket-vna-two-port/pkg/limit"
+	"github.com/practable/pocket-vna-two-port/pkg/logring"
+	"github.com/practable/pocket-vna-two-port/pkg/marker"
 	"github.com/practable/pocket-vna-two-port/pkg/measure"
-	"github.com/practable/pocket-vna-two-port/pkg/pb"
+	"github.com/practable/pocket-vna-two-port/pkg/pathloss"
 	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/reduce"
 	"github.com/practable/pocket-vna-two-port/pkg/rfusb"
+	"github.com/practable/pocket-vna-two-port/pkg/smooth"
+	"github.com/practable/pocket-vna-two-port/pkg/store"
 	"github.com/practable/pocket-vna-two-port/pkg/stream"
+	"github.com/practable/pocket-vna-two-port/pkg/timedomain"
+	"github.com/practable/pocket-vna-two-port/pkg/touchstone"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+	"github.com/practable/pocket-vna-two-port/pkg/wear"
+	"github.com/practable/pocket-vna-two-port/pkg/webhook"
 	log "github.com/sirupsen/logrus"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 )
 
 // Middle holds config and service pointers
 type Middle struct {
-	c       *pb.CalibrateClient
-	conn    *grpc.ClientConn // calibration
+	cal     calibration.Backend
 	ctx     context.Context
 	h       *measure.Hardware // rf switch & VNA
 	s       *stream.Stream    // data stream from user
@@ -31,201 +64,4567 @@ type Middle struct {
 	thru    []pocket.SParam
 	dut     []pocket.SParam
 	dutcal  []pocket.SParam
-	ctpr    *pb.CalibrateTwoPortRequest
+	// standards of the current cal, as sent to the backend, nil if there is none
+	std *calibration.Standards
+	// error terms of the current cal at each frequency, nil if the service did not return them
+	terms []twoport.ErrorTerms
+	// where the calibration workflow is up to, see transition
+	calState calstate.Machine
+	// when the current cal was made
+	calAt   time.Time
+	power   float64            // output power (dBm) set with setpower, zero is device default
+	fixture [2][]pocket.SParam // fixtures to de-embed from port 1 and port 2, nil if none
+	portext pocket.Extension   // one-way delays added to each port
+	kit     *calkit.Kit        // cal kit definition, nil if the standards are ideal
+	// loss and phase of each switch path, for raw results, nil if not characterized
+	pathLoss *pathloss.Table
+	// return raw results from crq when the calibration service cannot be reached
+	fallback bool
+	// how long a request runs before a working message is sent, zero for never
+	timeoutSoft time.Duration
+	// timeouts of the commands that do not use the defaults, by Cmd, nil if none
+	timeouts map[string]config.Timeout
+	// sweep of the last rc, if it failed because the calibration service could not be reached
+	uncalibrated *pocket.RangeQuery
+	// settings of an rc that was interrupted, to carry on with resumecal, nil if there is none
+	resume *pocket.RangeQuery
+	// reciprocal devices to measure along with the thru during a cal, for finding the switch terms
+	switchStd []string
+	// devices measured for the switch terms in the current cal, and their measurements, nil if not in use
+	switchNames   []string
+	switchDevices [][]pocket.SParam
+	// forward and reverse switch terms at each frequency in the cal, nil if not in use
+	switchTerms [][2]complex128
+	// max-hold and min-hold of calibrated results
+	hold pocket.Hold
+	// continuous sweep, nil if not sweeping. It is set by requests, which may
+	// still be running after they time out, and read by Run, so it is held by
+	// sweepLock, nil if it is not, e.g. in a Middle made for a test
+	sweep     *pocket.Sweep
+	sweepLock *sync.Mutex
+	// stability experiment, nil if there is none running, also held by sweepLock
+	experiment *experiment
+	// identifies the calibration, and the corrections applied after it, that cached results belong to
+	calID int
+	// most recent calibrated result for each set of request parameters
+	cache map[string]cached
+	// settings the daemon is running with, nil if not known
+	config *config.Config
+	// file the settings were loaded from, empty if from the environment only
+	configFile string
+	// signals to reload the config, nil if there are none
+	hup <-chan os.Signal
+	// calibrated trace saved with saveref, and its description, nil if none
+	ref     []pocket.SParam
+	refInfo *pocket.Reference
+	// limit lines checked against each calibrated result, nil if none
+	limits []pocket.Limit
+	// reasonable frequency range of the VNA, nil until it is known
+	device *pocket.Range
+	// requests from the gRPC server, handled in turn with those from the stream
+	calls chan call
+	// requests from the stream that arrived while another was being served, oldest first
+	queue []queued
+	// request from the stream that is being served, nil if none
+	current *queued
+	// requests from the stream with an at time or an after delay, soonest first, see schedule
+	scheduled []queued
+	// record of the requests that have been handled, nil if none is kept
+	audit *audit.Log
+	// where to write a report when a request panics, empty for none
+	crashDir string
+	// recent lines of the debug log, for crash reports, nil if none are kept
+	logs *logring.Ring
+	// records the bytes to and from the switch controller, nil if it has no link to record
+	traffic *rfusb.Traffic
+	// where exported cals are saved, nil if there is nowhere
+	store store.Store
+	// where the health of the rig is reported, nil if it is not, and the counts of
+	// the requests handled for it, nil in a Middle made for a test
+	fleet   *fleet.Reporter
+	tally   *fleet.Tally
+	version string    // of the daemon, for the reports
+	started time.Time // when the daemon started
+	// where the responses to chosen commands are posted too, nil if they are not
+	webhook *webhook.Hook
+	// recent responses sent on the stream, nil if none are kept
+	history *history.History
+	// how long a response is kept to send again to a request with the same id, zero
+	// for never, and the responses kept, by session and id, see replay
+	replayTTL time.Duration
+	replies   map[string]reply
+	// the frequencies that may be measured on this rig
+	guard pocket.Guard
+	// trace noise at each frequency of the last nf, found as for the sweeps of a
+	// crq, and the grid it was measured on, for the uncertainty of results, nil if none
+	noiseFloor []pocket.Deviation
+	noiseGrid  string
+	// named sweeps that an rq or rc can use, listed by rr
+	presets []pocket.Preset
+	// how long the VNA takes to warm up, zero if it is not tracked, and whether an rc
+	// or mc is refused until then, rather than warned about
+	warmup       time.Duration
+	warmupRefuse bool
+	// how to open the switch and the calibration service again for a reset, as given
+	// to New, zero if they were set up elsewhere, e.g. for a test
+	switchPort string
+	baud       int
+	timeoutUSB time.Duration
+	calAddr    string
+	// closes the VNA, for a reset, nil if not known
+	release func() error
+	// held by the request that is using the calibration and other state, see claim,
+	// nil if requests are not kept apart, e.g. in a Middle made for a test
+	state *sync.Mutex
 }
 
+// holdingKey marks the context of a request that holds the state, see claim
+type holdingKey struct{}
+
+// queued is a request from the stream, when it arrived, and the rank of its priority,
+// and when it is due, if it was scheduled
+type queued struct {
+	request interface{}
+	at      time.Time
+	rank    int
+	due     time.Time
+}
+
+// DefaultLogLimit is the number of lines returned by logs if no limit is given
+const DefaultLogLimit = 100
+
+// DefaultTrafficLimit is the number of lines returned by switchtraffic if no limit is given
+const DefaultTrafficLimit = 50
+
+// ErrCancelled is returned for a request that was stopped by a cancel
+var ErrCancelled = errors.New("cancelled")
+
+// ErrTimeout is returned for a request that took longer than its timeout
+var ErrTimeout = errors.New("timeout")
+
+// ErrNotCalibrated is returned for a request that needs a calibration when there is none
+var ErrNotCalibrated = errors.New("not calibrated yet")
+
+// ErrNotInterrupted is returned for a resumecal when there is no rc to carry on with
+var ErrNotInterrupted = errors.New("there is no interrupted rc to resume, so use rc")
+
+// ErrInvalid is matched by errors.Is for a request that cannot be made as it
+// stands, e.g. a sweep outside the range of the VNA, rather than one that failed
+var ErrInvalid = errors.New("invalid request")
+
+// invalidError is a problem with a request, see invalid
+type invalidError struct {
+	error
+}
+
+func (e invalidError) Unwrap() error {
+	return e.error
+}
+
+func (e invalidError) Is(target error) bool {
+	return target == ErrInvalid
+}
+
+// func invalid marks err, if there is one, as a problem with the request rather than
+// the rig, keeping its message, so that gRPC clients can be told which it is
+func invalid(err error) error {
+	if err == nil {
+		return nil
+	}
+	return invalidError{err}
+}
+
+// ErrWarmingUp is returned for an rc or mc while the VNA is warming up, if they are refused until it has
+var ErrWarmingUp = errors.New("the VNA is still warming up")
+
+// ErrNoNoise is returned for a crq that asks for the uncertainty when the trace noise is not known
+var ErrNoNoise = errors.New("the uncertainty needs the trace noise, so use at least 2 sweeps, or measure the noise floor with nf at the frequencies of the cal")
+
+// ErrPreempted is the cause of a continuous sweep with batch priority being stopped
+// between sweeps, because a request with a higher priority has arrived
+var ErrPreempted = errors.New("preempted by a request with a higher priority")
+
+// reply is a response kept to send again to a retry of its request, the cmd of the
+// request, and when it was sent
+type reply struct {
+	response interface{}
+	cmd      string
+	at       time.Time
+}
+
+// MaxReplies limits the number of responses kept to send again, see SetReplay
+const MaxReplies = 64
+
+// cached is a calibrated result, and when it was measured
+type cached struct {
+	result pocket.CalibratedRangeQuery
+	at     time.Time
+}
+
+// SpeedOfLight in vacuum, m/s
+const SpeedOfLight = 299792458.0
+
+// Z0 is the reference impedance of the calibration standards, ohms
+const Z0 = 50.0
+
+// NoiseSweeps is the default number of sweeps for a noise floor measurement
+const NoiseSweeps = 10
+
+// CalDir is the prefix of the keys of the cals saved in the store by exportcal
+const CalDir = "cals/"
+
+// MaxCached limits the number of calibrated results kept for reuse
+const MaxCached = 64
+
+// A port looks disconnected if, at nearly all the frequencies (DisconnectedFraction),
+// its reflection is at least DisconnectedReflection and the transmission to and from
+// the other port is no more than DisconnectedTransmission (linear magnitudes)
+const (
+	DisconnectedReflection   = 0.9
+	DisconnectedTransmission = 0.03
+	DisconnectedFraction     = 0.95
+)
+
 // for the channel in Handle
 type Response struct {
 	Result interface{}
 	Error  error
 }
 
-// func New returns a new middleware - do this way so in Run we can call Handle without passing parameters to it
-// addr is the host:port of the local gRPC calibration service (unlikely to be remote due to difficulties in proxying HTTP/2)
-// port is the usb port for the rf switch, e.g. `/dev/ttyUSB0`
-// baud is usb port baud e.g. 57600
-// timeoutUSB is the timeout for USB comms e.g. 2m TODO is this needed?
-// topic is the address for the stream to connect to at the local `relay host` e.g. ws://localhost:8888/data (TODO check this address for correct format, e.g. does it need the ws://?)
+// func New returns a new middleware - do this way so in Run we can call Handle without passing parameters to it
+// addr is the host:port of the local gRPC calibration service (unlikely to be remote due to difficulties in proxying HTTP/2)
+// driver is the type of rf switch, usb, gpio, i2c, tcp or udp (see rfusb.NewSwitch)
+// port is the usb port for the rf switch, e.g. `/dev/ttyUSB0`, or its gpio pins, i2c bus@address or controller host:port
+// baud is usb port baud e.g. 57600
+// timeoutUSB is the timeout for USB comms e.g. 2m TODO is this needed?
+// topic is the address for the stream to connect to at the local `relay host` e.g. ws://localhost:8888/data (TODO check this address for correct format, e.g. does it need the ws://?)
+// An empty topic leaves the stream to be given with SetStream before Run, e.g. one from stream.NewServer
+// An error is returned if the calibration service address cannot be used. If the rf switch
+// cannot be opened, this is logged and it is opened again when it is next needed, e.g. after it is plugged in.
+
+func New(ctx context.Context, addr, driver, port string, baud int, timeoutUSB, timeoutRequest time.Duration, topic string, v *pocket.VNA) (Middle, error) {
+
+	// open the connection to the rf switch
+	r, err := rfusb.NewSwitch(driver)
+
+	if err != nil {
+		return Middle{}, err
+	}
+
+	err = r.Open(port, baud, timeoutUSB)
+
+	if err != nil {
+		log.WithFields(log.Fields{"port": port, "error": err.Error()}).Warn("could not open rf switch yet, will try again when it is next used")
+	}
+	// r.Close() is in Run()
+
+	// create a new measure.Hardware using the rfswitch and VNA
+	// note that vna has it's own context (same parent as this context though)
+	h := measure.NewHardware(v, r)
+
+	// open the gRPC connection to the calibration service
+	cal, err := calibration.Dial(addr)
+
+	if err != nil {
+		return Middle{}, err
+	}
+	// cal.Close() is in Run()
+
+	m := NewWith(ctx, h, cal, timeoutRequest)
+	m.switchPort = port
+	m.baud = baud
+	m.timeoutUSB = timeoutUSB
+	m.calAddr = addr
+
+	// open the command/data stream to the user (via relay etc)
+	if topic != "" {
+		s := stream.New(ctx, topic)
+		m.SetStream(&s)
+	}
+
+	return m, nil
+
+}
+
+// func NewWith returns a new middleware using hardware and a calibration backend that
+// are already set up, e.g. mocks for testing. Give it a stream with SetStream before Run.
+func NewWith(ctx context.Context, h *measure.Hardware, cal calibration.Backend, timeoutRequest time.Duration) Middle {
+	return Middle{
+		cal:       cal,
+		calls:     make(chan call),
+		ctx:       ctx,
+		h:         h,
+		state:     &sync.Mutex{},
+		started:   time.Now(),
+		sweepLock: &sync.Mutex{},
+		tally:     &fleet.Tally{},
+		timeout:   timeoutRequest,
+	}
+}
+
+// func SetSettling sets how long to wait after changing the switch, before starting a sweep
+// settleFor holds optional per-position overrides, e.g. for a dut on a long cable
+func (m *Middle) SetSettling(settle time.Duration, settleFor map[string]time.Duration) {
+	m.h.Settle = settle
+	for k, v := range settleFor {
+		m.h.SettleFor[k] = v
+	}
+}
+
+// func SetRelease sets the func that closes the VNA, so that reset can open it again
+func (m *Middle) SetRelease(release func() error) {
+	m.release = release
+}
+
+// func SetCalKit sets the definition of the cal standards to use in future calibrations
+// nil means the standards are treated as ideal
+func (m *Middle) SetCalKit(k *calkit.Kit) {
+	m.kit = k
+}
+
+// func SetPathLoss sets the loss and phase of each switch path, to remove from raw
+// results that ask for it. nil means the paths are not characterized.
+func (m *Middle) SetPathLoss(t *pathloss.Table) {
+	m.pathLoss = t
+}
+
+// func SetAudit sets the log that each request is recorded in, nil for none
+func (m *Middle) SetAudit(a *audit.Log) {
+	m.audit = a
+}
+
+// func SetStore sets where exportcal saves the cal, nil for nowhere
+func (m *Middle) SetStore(s store.Store) {
+	m.store = s
+}
+
+// func SetFleet sets where the health of the rig is reported, nil for nowhere
+func (m *Middle) SetFleet(r *fleet.Reporter) {
+	m.fleet = r
+}
+
+// func SetWebhook sets where the responses to chosen commands are posted, as well as
+// sent on the stream, nil for nowhere
+func (m *Middle) SetWebhook(h *webhook.Hook) {
+	m.webhook = h
+}
+
+// func SetVersion sets the version of the daemon, to include in health reports
+func (m *Middle) SetVersion(v string) {
+	m.version = v
+}
+
+// func SetWear sets what counts the moves of the switch, nil for nothing
+func (m *Middle) SetWear(w *wear.Counter) {
+	if m.h != nil {
+		m.h.Wear = w
+	}
+}
+
+// func SetCrashDir sets the directory that a report is written to when handling a
+// request panics, empty for none. The request is answered with an error either way.
+func (m *Middle) SetCrashDir(dir string) {
+	m.crashDir = dir
+}
+
+// func SetLogRing sets the recent lines of the debug log to include in crash reports, nil for none
+func (m *Middle) SetLogRing(r *logring.Ring) {
+	m.logs = r
+}
+
+// func SetTraffic sets what records the bytes to and from the switch controller, for
+// switchtraffic. It is not used if the switch has no serial or network link, e.g. gpio.
+func (m *Middle) SetTraffic(t *rfusb.Traffic) {
+
+	if m.h == nil {
+		return
+	}
+
+	r, ok := m.h.Switch.(rfusb.Recorder)
+
+	if !ok {
+		return
+	}
+
+	r.SetTraffic(t)
+	m.traffic = t
+}
+
+// func SetHistory sets where to keep the recent responses sent on the stream, for
+// history and lastresult, nil for nowhere
+func (m *Middle) SetHistory(h *history.History) {
+	m.history = h
+}
+
+// func SetReplay sets how long a response is kept to send again, rather than handling
+// the request again, to a request from the same session with the same id and cmd, e.g.
+// a retry after a network glitch, zero for never
+func (m *Middle) SetReplay(ttl time.Duration) {
+	m.replayTTL = ttl
+}
+
+// func SetGuard sets the frequencies that may be measured on this rig, and whether
+// sweeps outside them are moved inside or rejected
+func (m *Middle) SetGuard(g pocket.Guard) {
+	m.guard = g
+}
+
+// func SetPresets sets the named sweeps that an rq or rc can use, see measure.ParsePresets
+func (m *Middle) SetPresets(p []pocket.Preset) {
+	m.presets = p
+}
+
+// func SetWarmup sets how long the VNA takes to warm up, from when it was opened, zero
+// for no warm-up, and whether an rc or mc is refused until then, rather than warned about
+func (m *Middle) SetWarmup(period time.Duration, refuse bool) {
+	m.warmup = period
+	m.warmupRefuse = refuse
+}
+
+// func SetFallback sets whether crq returns raw results, flagged as uncorrected, when the
+// calibration service cannot be reached, rather than an error
+func (m *Middle) SetFallback(fallback bool) {
+	m.fallback = fallback
+}
+
+// func SetTimeouts sets the default timeouts of a request, and those of the
+// commands that have their own, see config.Timeouts
+func (m *Middle) SetTimeouts(t config.Timeout, cmds map[string]config.Timeout) {
+	m.timeout = t.Hard
+	m.timeoutSoft = t.Soft
+	m.timeouts = cmds
+}
+
+// func timeoutOf returns the timeouts of request, which are those of its
+// command if it has its own, or the defaults
+func (m *Middle) timeoutOf(request interface{}) config.Timeout {
+
+	if c, ok := pocket.CommandOf(request); ok {
+		if cmd, ok := pocket.Lookup(c.Command); ok {
+			if t, ok := m.timeouts[cmd]; ok {
+				return t
+			}
+		}
+	}
+
+	return config.Timeout{Hard: m.timeout, Soft: m.timeoutSoft}
+}
+
+// func SetConfig records the settings the daemon was started with, and the file
+// they came from (if any), for getconfig and reload
+func (m *Middle) SetConfig(file string, c config.Config) {
+	m.configFile = file
+	m.config = &c
+}
+
+// func NotifyReload reloads the config whenever there is a signal on c, e.g. SIGHUP
+func (m *Middle) NotifyReload(c <-chan os.Signal) {
+	m.hup = c
+}
+
+// func Reload re-reads the config file and environment, and applies the settings
+// that can be changed while running: timeout_request, timeout_soft, timeout_cmds, settle, settle_ports,
+// switch_terms, calkit, path_loss, fallback, crash_dir, freq_min, freq_max, freq_guard, freq_clamp, presets,
+// warmup, warmup_refuse, replay_ttl, max_message, log_level and log_format. The cal kit and switch terms are used from the next calibration onwards. Nothing is changed if the new settings
+// are not valid. Settings that need a restart are reported but left as they were.
+func (m *Middle) Reload(request *pocket.Reload) error {
+
+	if m.config == nil {
+		return errors.New("config is not known, so cannot be reloaded")
+	}
+
+	loaded, err := config.Load(m.configFile)
+
+	if err != nil {
+		return fmt.Errorf("config not reloaded because %s", err.Error())
+	}
+
+	next, changed, restart := config.Apply(*m.config, loaded)
+
+	// check everything that can fail before changing anything
+
+	var kit *calkit.Kit
+
+	if next.CalKit != "" {
+		kit, err = calkit.Load(next.CalKit)
+		if err != nil {
+			return fmt.Errorf("config not reloaded because %s", err.Error())
+		}
+	}
+
+	var pl *pathloss.Table
+
+	if next.PathLoss != "" {
+		pl, err = pathloss.Load(next.PathLoss)
+		if err != nil {
+			return fmt.Errorf("config not reloaded because %s", err.Error())
+		}
+	}
+
+	level, err := log.ParseLevel(next.LogLevel)
+
+	if err != nil {
+		return fmt.Errorf("config not reloaded because %s", err.Error())
+	}
+
+	err = m.SetSwitchStandards(next.SwitchTerms)
+
+	if err != nil {
+		return fmt.Errorf("config not reloaded because switch_terms %s", err.Error())
+	}
+
+	settle, _, _ := next.Durations()
+	settleFor, _ := measure.ParseSettle(next.SettlePorts) //already checked
+	presets, _ := measure.ParsePresets(next.Presets)
+
+	m.kit = kit
+	m.pathLoss = pl
+	m.fallback = next.Fallback
+	m.crashDir = next.CrashDir
+	m.guard = next.Guard()
+	m.presets = presets
+	m.SetWarmup(next.Warming())
+	m.SetReplay(next.Replay())
+	m.SetTimeouts(next.Timeouts())
+	m.fleet = next.Fleet()
+	m.webhook = next.Webhook()
+	m.traffic.SetFile(next.SwitchTrafficFile)
+
+	// so that recording started or stopped with switchtraffic is left as it is
+	if next.SwitchTraffic != m.config.SwitchTraffic {
+		m.traffic.SetRecording(next.SwitchTraffic)
+	}
+
+	if m.h != nil {
+		m.h.Settle = settle
+		m.h.SettleFor = settleFor
+		m.h.Wear.SetRated(next.SwitchRated)
+	}
+
+	m.SetMaxMessage(next.MaxMessage)
+
+	log.SetLevel(level)
+
+	if strings.ToLower(next.LogFormat) == "text" {
+		log.SetFormatter(&log.TextFormatter{})
+	} else {
+		log.SetFormatter(&log.JSONFormatter{})
+	}
+
+	m.config = &next
+
+	request.Changed = changed
+	request.Restart = restart
+
+	if len(restart) > 0 {
+		log.Warnf("config reloaded, but these changes need a restart: %s", strings.Join(restart, ", "))
+	}
+
+	log.Infof("config reloaded, changed: [%s]", strings.Join(changed, ", "))
+
+	return nil
+}
+
+// func SetSwitchStandards sets the switch positions of the reciprocal devices
+// that are measured, along with the thru, during a cal so that the switch terms
+// can be found and removed. Use at least two, or none to turn off the correction
+func (m *Middle) SetSwitchStandards(what []string) error {
+
+	if len(what) == 0 {
+		m.switchStd = nil
+		return nil
+	}
+
+	if len(what) < 2 {
+		return errors.New("switch terms need at least two reciprocal devices in addition to the thru")
+	}
+
+	seen := make(map[string]bool)
+
+	var std []string
+
+	for _, w := range what {
+
+		w = strings.ToLower(strings.TrimSpace(w))
+
+		switch w {
+		case "short", "open", "load", "thru":
+			return fmt.Errorf("%s cannot be used for switch terms, use a reciprocal device that transmits", w)
+		}
+
+		if seen[w] {
+			return fmt.Errorf("%s is listed more than once", w)
+		}
+
+		seen[w] = true
+		std = append(std, w)
+	}
+
+	m.switchStd = std
+
+	return nil
+}
+
+func (m *Middle) Run() {
+
+	defer m.h.Switch.Close()
+
+	// whichever backend is in use by then, since a reset replaces it
+	defer func() {
+		if c, ok := m.cal.(io.Closer); ok {
+			c.Close()
+		}
+	}()
+
+	// fires when the next sweep of a continuous sweep is due, nil if there is none
+	var next <-chan time.Time
+
+	// fires when the health of the rig is next reported, nil if it is not
+	var report <-chan time.Time
+
+	// fires when the next measurement of a stability experiment is due, at driftAt, nil if there is none
+	var drift <-chan time.Time
+	var driftAt time.Time
+
+	// fires when the first scheduled request is due, at alarmAt, nil if there is none
+	var alarm <-chan time.Time
+	var alarmAt time.Time
+
+	for {
+
+		if sweep := m.sweeping(); sweep != nil && next == nil {
+			next = time.After(time.Duration(sweep.Interval * float64(time.Second)))
+		}
+
+		if m.fleet != nil && report == nil {
+			report = time.After(m.fleet.Interval)
+		}
+
+		if e := m.experimenting(); e == nil {
+			drift = nil
+		} else if drift == nil || !driftAt.Equal(e.next) {
+			driftAt = e.next
+			drift = time.After(time.Until(driftAt))
+		}
+
+		if len(m.scheduled) == 0 {
+			alarm = nil
+		} else if alarm == nil || !alarmAt.Equal(m.scheduled[0].due) {
+			alarmAt = m.scheduled[0].due
+			alarm = time.After(time.Until(alarmAt))
+		}
+
+		if len(m.queue) > 0 {
+
+			q := m.queue[0]
+			m.queue = m.queue[1:]
+			m.serve(q)
+
+			next = nil
+
+			continue
+		}
+
+		select {
+
+		case request := <-m.s.Request:
+
+			m.Serve(request)
+
+			// one-shot commands pause a continuous sweep, which carries on
+			// a whole interval later, so they are never held up for long
+			next = nil
+
+		case <-next:
+
+			next = nil
+
+			if m.sweeping() == nil {
+				continue
+			}
+
+			m.SweepNext()
+
+		case c := <-m.calls:
+
+			m.answer(c)
+
+			next = nil
+
+		case <-m.hup:
+
+			_, release, err := m.claim(m.ctx)
+
+			if err != nil {
+				continue // stopping
+			}
+
+			req := pocket.Reload{Command: pocket.Command{Command: "reload"}}
+
+			err = m.Reload(&req)
+
+			release()
+
+			if err != nil {
+				log.Error(err.Error())
+			}
+
+		case <-drift:
+
+			drift = nil
+
+			m.StabilityNext()
+
+			next = nil
+
+		case <-alarm:
+
+			alarm = nil
+
+			m.fire(time.Now())
+
+		case <-report:
+
+			report = nil
+
+			if m.fleet == nil {
+				continue // turned off by a reload
+			}
+
+			_, release, err := m.claim(m.ctx)
+
+			if err != nil {
+				continue // stopping
+			}
+
+			h := m.Health()
+
+			release()
+
+			// sent in the background, so that a slow endpoint does not hold up requests
+			go func(r *fleet.Reporter) {
+				if err := r.Send(m.ctx, h); err != nil {
+					log.WithFields(log.Fields{"url": r.URL, "error": err.Error()}).Warn("could not report health to the fleet")
+				}
+			}(m.fleet)
+
+		case <-m.ctx.Done():
+			return
+		}
+
+	} //for
+
+}
+
+// func Serve handles one request from the stream and sends the response. The
+// stream is still read while the request is being handled, so that a cancel
+// can stop it straight away, rather than at the timeout, and queue and flushqueue
+// are answered straight away too. Any other requests that arrive in the meantime
+// are added to the queue, to be served next, highest priority first, then in order.
+// A request with an at time or an after delay is put aside until it is due, see schedule.
+func (m *Middle) Serve(request interface{}) {
+	m.serve(newQueued(request))
+}
+
+// func serve handles a request that arrived at q.at, see Serve
+func (m *Middle) serve(q queued) {
+
+	request := q.request
+
+	if m.admin(request) {
+		return
+	}
+
+	if c, ok := request.(pocket.Cancel); ok {
+		// there is nothing to cancel
+		m.record("stream", q, time.Now(), nil)
+		m.s.Response <- c
+		return
+	}
+
+	c, _ := pocket.CommandOf(request)
+
+	if _, ok := pocket.Rank(c.Priority); !ok {
+		err := fmt.Errorf("priority must be one of %s, not %s", strings.Join(pocket.Priorities, ", "), c.Priority)
+		m.record("stream", q, time.Now(), err)
+		m.s.Response <- pocket.CustomResult{
+			Message: err.Error(),
+			Command: request,
+		}
+		return
+	}
+
+	if m.schedule(q) {
+		return
+	}
+
+	// a retry of a request that has been answered already
+	if response, ok := m.replay(request); ok {
+		m.record("stream", q, time.Now(), nil)
+		m.s.Response <- response
+		return
+	}
+
+	m.current = &q
+	defer func() { m.current = nil }()
+
+	start := time.Now()
+
+	m.attend(request, false, func(ctx context.Context) interface{} {
+
+		response, err := m.Handle(ctx, request)
+
+		m.record("stream", q, start, err)
+
+		if err != nil {
+			response = pocket.CustomResult{
+				Message: err.Error(),
+				Command: request,
+			}
+		} else {
+			m.keep(request, response)
+			m.post(c.Command, response)
+		}
+
+		return response
+	})
+}
+
+// func post posts response, to a cmd, to the webhook, if it wants it
+func (m *Middle) post(cmd string, response interface{}) {
+
+	if !m.webhook.Wants(cmd) {
+		return
+	}
+
+	b, err := pocket.Marshal(response)
+
+	if err != nil {
+		log.WithFields(log.Fields{"cmd": cmd, "error": err.Error()}).Error("could not marshal response for webhook")
+		return
+	}
+
+	m.webhook.Post(m.ctx, cmd, b)
+}
+
+// replayKey identifies a request, by its session and id, for replay
+func replayKey(c pocket.Command) string {
+	return c.Session + "\x00" + c.ID
+}
+
+// func replay returns the response kept for request, if it is a retry of a request
+// that was answered within the replay TTL, i.e. with the same session, id and cmd
+func (m *Middle) replay(request interface{}) (interface{}, bool) {
+
+	c, _ := pocket.CommandOf(request)
+
+	if m.replayTTL <= 0 || c.ID == "" {
+		return nil, false
+	}
+
+	r, ok := m.replies[replayKey(c)]
+
+	if !ok || time.Since(r.at) > m.replayTTL {
+		return nil, false
+	}
+
+	cmd, _ := pocket.Lookup(c.Command)
+
+	if cmd != r.cmd {
+		return nil, false // the id has been used again for something else
+	}
+
+	log.WithFields(log.Fields{"id": c.ID, "session": c.Session, "cmd": cmd}).Info("sent the response kept for a retry")
+
+	return r.response, true
+}
+
+// func keep keeps the response to request, if it has an id, to send again to any
+// retry within the replay TTL, dropping those that have expired, and the oldest
+// if there are too many
+func (m *Middle) keep(request, response interface{}) {
+
+	c, _ := pocket.CommandOf(request)
+
+	if m.replayTTL <= 0 || c.ID == "" {
+		return
+	}
+
+	if m.replies == nil {
+		m.replies = make(map[string]reply)
+	}
+
+	oldest := ""
+
+	for k, r := range m.replies {
+
+		if time.Since(r.at) > m.replayTTL {
+			delete(m.replies, k)
+			continue
+		}
+
+		if oldest == "" || r.at.Before(m.replies[oldest].at) {
+			oldest = k
+		}
+	}
+
+	if len(m.replies) >= MaxReplies {
+		delete(m.replies, oldest)
+	}
+
+	cmd, _ := pocket.Lookup(c.Command)
+
+	m.replies[replayKey(c)] = reply{
+		response: response,
+		cmd:      cmd,
+		at:       time.Now(),
+	}
+}
+
+// func crashed logs a panic with value p while handling request, writes a crash report
+// to the crash directory, if there is one, and returns the error to answer request with
+func (m *Middle) crashed(request interface{}, p interface{}, stack []byte) error {
+
+	cmd := "request"
+
+	if c, ok := pocket.CommandOf(request); ok {
+		cmd = c.Command
+	}
+
+	log.WithFields(log.Fields{"cmd": cmd, "panic": fmt.Sprint(p)}).Error("panic handling request")
+
+	err := fmt.Errorf("internal error handling %s: %v", cmd, p)
+
+	if m.crashDir == "" {
+		return err
+	}
+
+	var lines []string
+
+	if m.logs != nil {
+		lines = m.logs.Lines()
+	}
+
+	path, werr := crash.Write(m.crashDir, crash.New(p, request, stack, lines))
+
+	if werr != nil {
+		log.Errorf("cannot write crash report because %s", werr.Error())
+		return err
+	}
+
+	log.Errorf("crash report written to %s", path)
+
+	return fmt.Errorf("%s, see crash report %s", err.Error(), path)
+}
+
+// func SweepNext measures the next sweep of the continuous sweep, reading the stream
+// meanwhile, as Serve does. If the sweep has batch priority, it is stopped between
+// sweeps when a request with a higher priority arrives, and carries on at the next interval.
+func (m *Middle) SweepNext() {
+
+	sweep := m.sweeping()
+
+	if sweep == nil {
+		return
+	}
+
+	c := sweep.Command
+	c.Command = "sweep"
+
+	q := newQueued(c)
+
+	m.current = &q
+	defer func() { m.current = nil }()
+
+	batch, _ := pocket.Rank(pocket.PriorityBatch)
+
+	m.attend(c, q.rank >= batch, m.SweepOnce)
+}
+
+// func attend sends the response from work, for request, if there is one, reading
+// the stream until it is done. A cancel stops work, and so does any request with a
+// higher priority than batch if preemptible is true, with ErrPreempted as the cause.
+// Work is stopped at the hard timeout of request, and a working message is sent
+// at each soft timeout until then, so that the user knows it has not been forgotten.
+func (m *Middle) attend(request interface{}, preemptible bool, work func(ctx context.Context) interface{}) {
+
+	t := m.timeoutOf(request)
+
+	pctx, preempt := context.WithCancelCause(m.ctx)
+	defer preempt(nil)
+
+	ctx, cancel := context.WithTimeout(pctx, t.Hard)
+	defer cancel()
+
+	done := make(chan interface{}, 1)
+
+	start := time.Now()
+
+	go func() {
+		done <- work(ctx)
+	}()
+
+	batch, _ := pocket.Rank(pocket.PriorityBatch)
+
+	var soft <-chan time.Time
+
+	if t.Soft > 0 {
+		ticker := time.NewTicker(t.Soft)
+		defer ticker.Stop()
+		soft = ticker.C
+	}
+
+	for {
+		select {
+
+		case <-soft:
+
+			c, _ := pocket.CommandOf(request)
+
+			w := pocket.Working{
+				Command: c,
+				For:     c.Command,
+				Elapsed: time.Since(start).Seconds(),
+				Timeout: t.Hard.Seconds(),
+			}
+
+			if cmd, ok := pocket.Lookup(c.Command); ok {
+				w.For = cmd
+			}
+
+			w.Command.Command = "working"
+
+			m.s.Response <- w
+
+		case response := <-done:
+			if response != nil {
+				m.remember(response)
+				m.s.Response <- response
+			}
+			return
+
+		case another := <-m.s.Request:
+
+			if m.admin(another) {
+				continue
+			}
+
+			c, ok := another.(pocket.Cancel)
+
+			if !ok {
+
+				q := newQueued(another)
+
+				if m.schedule(q) {
+					continue
+				}
+
+				m.enqueue(q)
+
+				if preemptible && q.rank < batch {
+					preempt(ErrPreempted)
+				}
+
+				continue
+			}
+
+			cancel()
+
+			m.record("stream", newQueued(c), time.Now(), nil)
+
+			c.Cancelled = true
+			m.s.Response <- c
+		}
+	}
+}
+
+// func newQueued records when request arrived, and its rank, which is that of
+// an interactive request if it does not have a valid priority
+func newQueued(request interface{}) queued {
+
+	c, _ := pocket.CommandOf(request)
+
+	rank, ok := pocket.Rank(c.Priority)
+
+	if !ok {
+		rank, _ = pocket.Rank(pocket.PriorityInteractive)
+	}
+
+	return queued{request: request, at: time.Now(), rank: rank}
+}
+
+// func enqueue adds q to the queue behind every request of the same or higher priority
+func (m *Middle) enqueue(q queued) {
+
+	i := sort.Search(len(m.queue), func(i int) bool { return m.queue[i].rank > q.rank })
+
+	m.queue = append(m.queue, queued{})
+	copy(m.queue[i+1:], m.queue[i:])
+	m.queue[i] = q
+}
+
+// func admin answers queue, flushqueue, schedule, unschedule, logs, history and lastresult, returning false for any other request
+func (m *Middle) admin(request interface{}) bool {
+
+	switch req := request.(type) {
+
+	case pocket.Queue:
+
+		m.record("stream", newQueued(req), time.Now(), nil)
+
+		if m.current != nil {
+			p := pending(*m.current)
+			req.Current = &p
+		}
+
+		req.Pending = []pocket.Pending{}
+
+		for _, q := range m.queue {
+			req.Pending = append(req.Pending, pending(q))
+		}
+
+		m.s.Response <- req
+
+		return true
+
+	case pocket.FlushQueue:
+
+		m.record("stream", newQueued(req), time.Now(), nil)
+
+		req.Flushed = []pocket.Pending{}
+
+		for _, q := range m.queue {
+			req.Flushed = append(req.Flushed, pending(q))
+		}
+
+		m.queue = nil
+
+		log.Warnf("flushed %d requests from the queue", len(req.Flushed))
+
+		m.s.Response <- req
+
+		return true
+
+	case pocket.Schedule:
+
+		m.record("stream", newQueued(req), time.Now(), nil)
+
+		m.Schedule(&req)
+
+		m.s.Response <- req
+
+		return true
+
+	case pocket.Unschedule:
+
+		err := m.Unschedule(&req)
+
+		m.record("stream", newQueued(req), time.Now(), err)
+
+		if err != nil {
+			m.s.Response <- pocket.CustomResult{
+				Message: err.Error(),
+				Command: req,
+			}
+			return true
+		}
+
+		m.s.Response <- req
+
+		return true
+
+	case pocket.Logs:
+
+		err := m.Logs(&req)
+
+		m.record("stream", newQueued(req), time.Now(), err)
+
+		if err != nil {
+			m.s.Response <- pocket.CustomResult{
+				Message: err.Error(),
+				Command: req,
+			}
+			return true
+		}
+
+		m.s.Response <- req
+
+		return true
+
+	case pocket.SwitchTraffic:
+
+		err := m.SwitchTraffic(&req)
+
+		m.record("stream", newQueued(req), time.Now(), err)
+
+		if err != nil {
+			m.s.Response <- pocket.CustomResult{
+				Message: err.Error(),
+				Command: req,
+			}
+			return true
+		}
+
+		m.s.Response <- req
+
+		return true
+
+	case pocket.History:
+
+		err := m.History(&req)
+
+		m.record("stream", newQueued(req), time.Now(), err)
+
+		if err != nil {
+			m.s.Response <- pocket.CustomResult{
+				Message: err.Error(),
+				Command: req,
+			}
+			return true
+		}
+
+		m.s.Response <- req
+
+		return true
+
+	case pocket.LastResult:
+
+		err := m.LastResult(&req)
+
+		m.record("stream", newQueued(req), time.Now(), err)
+
+		if err != nil {
+			m.s.Response <- pocket.CustomResult{
+				Message: err.Error(),
+				Command: req,
+			}
+			return true
+		}
+
+		m.s.Response <- req
+
+		return true
+	}
+
+	return false
+}
+
+// func remember adds a response to the history, if one is kept
+func (m *Middle) remember(response interface{}) {
+
+	if m.history == nil {
+		return
+	}
+
+	m.history.Add(response)
+}
+
+// func seen returns the responses in the history that request may see, oldest first:
+// those of its own session if it has one, or else all of them
+func (m *Middle) seen(request pocket.Command) ([]history.Entry, error) {
+
+	if m.history == nil {
+		return nil, errors.New("recent responses are not kept")
+	}
+
+	var entries []history.Entry
+
+	for _, e := range m.history.Entries() {
+		if request.Session == "" || e.Session == request.Session {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+// func History lists the most recent responses sent on the stream, without the responses themselves
+func (m *Middle) History(request *pocket.History) error {
+
+	entries, err := m.seen(request.Command)
+
+	if err != nil {
+		return err
+	}
+
+	if request.Limit < 0 || request.Limit > history.DefaultSize {
+		return fmt.Errorf("limit must be between 1 and %d, not %d", history.DefaultSize, request.Limit)
+	}
+
+	if request.Limit > 0 && len(entries) > request.Limit {
+		entries = entries[len(entries)-request.Limit:]
+	}
+
+	request.Result = []pocket.HistoryEntry{}
+
+	for _, e := range entries {
+
+		h := pocket.HistoryEntry{
+			Time:    e.Time,
+			Session: e.Session,
+			ID:      e.ID,
+			Cmd:     e.Cmd,
+			Outcome: "ok",
+		}
+
+		if e.Error {
+			h.Outcome = "error"
+		}
+
+		request.Result = append(request.Result, h)
+	}
+
+	return nil
+}
+
+// func LastResult returns the last response sent to a request, by its id, or failing
+// that, by its cmd, or else the last response of all
+func (m *Middle) LastResult(request *pocket.LastResult) error {
+
+	entries, err := m.seen(request.Command)
+
+	if err != nil {
+		return err
+	}
+
+	cmd := request.For
+
+	if c, ok := pocket.Lookup(request.For); ok {
+		cmd = c
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+
+		e := entries[i]
+
+		if request.Request != "" && e.ID != request.Request {
+			continue
+		}
+
+		if request.Request == "" && cmd != "" && e.Cmd != cmd {
+			continue
+		}
+
+		request.Result = e.Response
+
+		return nil
+	}
+
+	switch {
+	case request.Request != "":
+		return fmt.Errorf("no response to request %s is kept", request.Request)
+	case cmd != "":
+		return fmt.Errorf("no response to %s is kept", cmd)
+	}
+
+	return errors.New("no response is kept")
+}
+
+// func record adds q, which was handled from start, and the error it ended with, if any,
+// to the audit log, if there is one. source is where it came from, stream or grpc. It is
+// counted for the health reports too, as a failure unless it was cancelled or invalid,
+// which are down to the client rather than the rig.
+func (m *Middle) record(source string, q queued, start time.Time, err error) {
+
+	if errors.Is(err, ErrCancelled) || errors.Is(err, ErrInvalid) {
+		m.tally.Add(nil)
+	} else {
+		m.tally.Add(err)
+	}
+
+	if m.audit == nil {
+		return
+	}
+
+	p := pending(q)
+
+	e := audit.Entry{
+		Time:     start,
+		Source:   source,
+		Session:  p.Session,
+		ID:       p.ID,
+		Cmd:      p.Cmd,
+		Priority: p.Priority,
+		Wait:     start.Sub(q.at).Seconds(),
+		Duration: time.Since(start).Seconds(),
+		Outcome:  audit.OK,
+	}
+
+	e.Params, e.Omitted = audit.Params(q.request)
+
+	if err != nil {
+		e.Outcome = audit.Error
+		e.Error = err.Error()
+	}
+
+	if errors.Is(err, ErrCancelled) {
+		e.Outcome = audit.Cancelled
+	}
+
+	if aerr := m.audit.Record(e); aerr != nil {
+		log.Errorf("could not add %s to the audit log because %s", e.Cmd, aerr.Error())
+	}
+}
+
+// func Audit returns the entries in the audit log that match the request
+func (m *Middle) Audit(request *pocket.Audit) error {
+
+	if m.audit == nil {
+		return errors.New("there is no audit log, set audit_log or store in the config")
+	}
+
+	f := audit.Filter{
+		Who:   request.Who,
+		Limit: request.Limit,
+	}
+
+	if request.Since > 0 {
+		f.Since = time.Unix(request.Since, 0)
+	}
+
+	if request.Until > 0 {
+		f.Until = time.Unix(request.Until, 0)
+	}
+
+	for _, c := range request.Commands {
+
+		cmd, ok := pocket.Lookup(c)
+
+		if !ok {
+			return fmt.Errorf("there is no command called %s", c)
+		}
+
+		f.Commands = append(f.Commands, cmd)
+	}
+
+	e, err := m.audit.Query(f)
+
+	if err != nil {
+		return err
+	}
+
+	request.Result = e
+
+	return nil
+}
+
+// func Logs returns the recent lines of the debug log that match the request
+func (m *Middle) Logs(request *pocket.Logs) error {
+
+	if m.logs == nil {
+		return errors.New("recent log lines are not kept")
+	}
+
+	limit := request.Limit
+
+	if limit == 0 {
+		limit = DefaultLogLimit
+	}
+
+	if limit < 0 || limit > logring.DefaultSize {
+		return fmt.Errorf("limit must be between 1 and %d, not %d", logring.DefaultSize, limit)
+	}
+
+	lines := []string{}
+
+	for _, l := range m.logs.Lines() {
+		if strings.Contains(l, request.Match) {
+			lines = append(lines, l)
+		}
+	}
+
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+
+	request.Result = lines
+
+	return nil
+}
+
+// func SwitchTraffic starts or stops recording the bytes to and from the switch
+// controller, and returns the last lines recorded. It is answered straight away,
+// so that the traffic can be seen while a request is waiting for the switch.
+func (m *Middle) SwitchTraffic(request *pocket.SwitchTraffic) error {
+
+	if m.traffic == nil {
+		return errors.New("the traffic to and from the switch is not recorded, because it has no serial or network link")
+	}
+
+	limit := request.Limit
+
+	if limit == 0 {
+		limit = DefaultTrafficLimit
+	}
+
+	if limit < 0 || limit > rfusb.DefaultTraffic {
+		return invalid(fmt.Errorf("limit must be between 1 and %d, not %d", rfusb.DefaultTraffic, limit))
+	}
+
+	if request.Clear {
+		m.traffic.Clear()
+	}
+
+	if request.Record != nil {
+		m.traffic.SetRecording(*request.Record)
+	}
+
+	request.Recording = m.traffic.Recording()
+	request.File = m.traffic.File()
+
+	lines := m.traffic.Lines()
+
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+
+	request.Result = lines
+
+	return nil
+}
+
+// func pending describes a queued request by its id, cmd and age
+func pending(q queued) pocket.Pending {
+
+	p := pocket.Pending{
+		Cmd:      "unknown",
+		Priority: pocket.PriorityInteractive,
+		Age:      time.Since(q.at).Seconds(),
+	}
+
+	if _, ok := q.request.(pocket.Invalid); ok {
+		p.Cmd = "invalid"
+	}
+
+	c, ok := pocket.CommandOf(q.request)
+
+	if !ok {
+		return p
+	}
+
+	p.ID = c.ID
+	p.Session = c.Session
+
+	if c.Priority != "" {
+		p.Priority = c.Priority
+	}
+
+	if p.Cmd == "unknown" {
+		p.Cmd = c.Command
+	}
+
+	return p
+}
+
+// func claim waits until no other request is using the state of the middle, e.g. the
+// calibration, unless ctx is done first. A request that timed out or was cancelled
+// may still be sweeping, and changing the state, until its sweep is finished. claim
+// returns a context that says the state is held, so that the requests made in turn
+// with it, e.g. in a batch, go straight ahead, and a func to call when done with it.
+func (m *Middle) claim(ctx context.Context) (context.Context, func(), error) {
+
+	if m.state == nil || ctx.Value(holdingKey{}) != nil {
+		return ctx, func() {}, nil
+	}
+
+	locked := make(chan struct{})
+
+	go func() {
+		m.state.Lock()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		return context.WithValue(ctx, holdingKey{}, true), m.state.Unlock, nil
+	case <-ctx.Done():
+		// let the next request have it, once the last has finished with it
+		go func() {
+			<-locked
+			m.state.Unlock()
+		}()
+		return ctx, nil, ctx.Err()
+	}
+}
+
+func (m *Middle) Handle(ctx context.Context, request interface{}) (response interface{}, err error) {
+
+	// a request made in turn by one that holds the state, e.g. in a batch, is done before
+	// returning, even if ctx is done, so that the state is not let go of while it is in use
+	if ctx.Value(holdingKey{}) != nil {
+		h := m.handle(ctx, request)
+		return h.Result, h.Error
+	}
+
+	// buffered, so that a request that is given up on can still finish, and let go of the state
+	r := make(chan Response, 1)
+
+	// now try the request
+	// any calls that hang will result in a leakage of the associated goro
+	// but hopefully small impact compared to whole system hanging
+	go func() {
+		r <- m.handle(ctx, request)
+	}()
+
+	select {
+	case response := <-r:
+		return response.Result, response.Error
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return nil, ErrCancelled
+		}
+		return nil, ErrTimeout
+	}
+}
+
+// func handle does the request, once it holds the state, and returns the response
+func (m *Middle) handle(ctx context.Context, request interface{}) (response Response) {
+
+	// buffered, so that each case can answer without waiting to be read
+	r := make(chan Response, 1)
+
+	// a bug in handling one request must not stop the daemon for everyone
+	// else, so the panic is reported and the request answered with an error,
+	// after the state is released by the deferred call below
+	defer func() {
+		if p := recover(); p != nil {
+			response = Response{Error: m.crashed(request, p, runtimedebug.Stack())}
+			return
+		}
+		select {
+		case response = <-r:
+		default:
+			response = Response{Error: fmt.Errorf("unknown command %T", request)}
+		}
+	}()
+
+	ctx, release, err := m.claim(ctx)
+
+	if err != nil {
+		r <- Response{Error: err}
+		return
+	}
+
+	defer release()
+
+	// checked and planned, but not done
+	if c, ok := pocket.CommandOf(request); ok && c.DryRun {
+		plan, err := m.Plan(request)
+		r <- Response{
+			Result: plan,
+			Error:  err,
+		}
+		return
+	}
+
+	switch request.(type) {
+
+	case pocket.ReasonableFrequencyRange:
+
+		req := request.(pocket.ReasonableFrequencyRange)
+		err := m.h.ReasonableFrequencyRange(&req)
+		req.Presets = m.presets
+
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	// contains request for raw range query OR to do calibration
+	case pocket.RangeQuery:
+
+		rq := request.(pocket.RangeQuery)
+
+		cmd, _ := pocket.Lookup(rq.Command.Command)
+
+		switch cmd {
+
+		case "rq":
+
+			req := request.(pocket.RangeQuery)
+			if req.Power == 0 {
+				req.Power = m.power
+			}
+			tctx, t := timed(ctx, req.DebugTiming)
+			err := reduce.Check(req.Digits, req.Display)
+			if err != nil {
+				err = invalid(err)
+			}
+			if err == nil {
+				err = m.usePreset(&req)
+			}
+			if err == nil {
+				err = m.clamp(&req)
+			}
+			if err == nil {
+				err = m.MeasureRange(tctx, &req)
+			}
+			if err == nil {
+				req.Meta = rawMeta()
+				req.Meta.Grid = GridOf(req.Result)
+			}
+			if err == nil && req.PathLoss {
+				err = m.RemovePathLoss(&req)
+			}
+			if err == nil {
+				reduceRange(&req)
+				req.Meta = t.done(req.Meta, req)
+			}
+			r <- Response{
+				Result: req,
+				Error:  err,
+			}
+
+		case "rc":
+			req := request.(pocket.RangeQuery)
+			tctx, t := timed(ctx, req.DebugTiming)
+			err := m.CalibrateRange(tctx, &req)
+			if err == nil {
+				req.Meta = t.done(req.Meta, req)
+			}
+			r <- Response{
+				Result: req,
+				Error:  err,
+			}
+
+		default:
+			r <- Response{
+				Result: rq,
+				Error:  fmt.Errorf("unknown range command %s", rq.Command.Command),
+			}
+		}
+
+	case pocket.SetPower:
+
+		req := request.(pocket.SetPower)
+		err := m.SetPower(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.CalibratedRangeQuery:
+
+		req := request.(pocket.CalibratedRangeQuery)
+
+		tctx, t := timed(ctx, req.DebugTiming)
+		err := m.MeasureRangeCalibrated(tctx, &req)
+		if err == nil {
+			req.Meta = t.done(req.Meta, req)
+		}
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.SetFixture:
+
+		req := request.(pocket.SetFixture)
+		err := m.SetFixture(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Analysis:
+
+		req := request.(pocket.Analysis)
+		err := m.Analyze(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.TimeQuery:
+
+		req := request.(pocket.TimeQuery)
+		err := m.checkFreq(req.Freq)
+		if err == nil {
+			err = m.h.MeasureTime(ctx, &req)
+		}
+		if err == nil {
+			req.Meta = rawMeta()
+		}
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Sweep:
+
+		req := request.(pocket.Sweep)
+		err := m.SetSweep(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Stability:
+
+		req := request.(pocket.Stability)
+		err := m.Stability(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Hold:
+
+		req := request.(pocket.Hold)
+		err := m.Hold(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.NoiseFloor:
+
+		req := request.(pocket.NoiseFloor)
+		err := m.MeasureNoiseFloor(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Audit:
+
+		req := request.(pocket.Audit)
+		err := m.Audit(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Estimate:
+
+		req := request.(pocket.Estimate)
+		err := m.Estimate(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.GetGrid:
+
+		req := request.(pocket.GetGrid)
+		err := m.GetGrid(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Characterize:
+
+		req := request.(pocket.Characterize)
+		err := m.Characterize(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.PortExtension:
+
+		req := request.(pocket.PortExtension)
+		err := m.SetPortExtension(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.ClearFixture:
+
+		req := request.(pocket.ClearFixture)
+		err := m.ClearFixture(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Bench:
+
+		req := request.(pocket.Bench)
+		err := m.Bench(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.MeasureCal:
+
+		req := request.(pocket.MeasureCal)
+		err := m.MeasureCal(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.ResumeCal:
+
+		req := request.(pocket.ResumeCal)
+		err := m.ResumeCal(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.CalState:
+
+		req := request.(pocket.CalState)
+		err := m.CalState(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.CompareCal:
+
+		req := request.(pocket.CompareCal)
+		err := m.CompareCal(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.ExportCal:
+
+		req := request.(pocket.ExportCal)
+		err := m.ExportCal(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.GetConfig:
+
+		req := request.(pocket.GetConfig)
+		err := m.GetConfig(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Hello:
+
+		req := request.(pocket.Hello)
+		err := m.Hello(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Schema:
+
+		req := request.(pocket.Schema)
+		err := m.Schema(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case statusQuery:
+
+		req := request.(statusQuery)
+		req.result = m.status()
+		r <- Response{
+			Result: req,
+		}
+
+	case pocket.Invalid:
+
+		req := request.(pocket.Invalid)
+		r <- Response{
+			Result: req,
+			Error:  fmt.Errorf("request is not valid because %s", strings.Join(req.Errors, "; ")),
+		}
+
+	case pocket.Reload:
+
+		req := request.(pocket.Reload)
+		err := m.Reload(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Reset:
+
+		req := request.(pocket.Reset)
+		err := m.Reset(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.FlashSwitch:
+
+		req := request.(pocket.FlashSwitch)
+		err := m.FlashSwitch(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.ProtocolTest:
+
+		req := request.(pocket.ProtocolTest)
+		err := m.ProtocolTest(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.SwitchWear:
+
+		req := request.(pocket.SwitchWear)
+		err := m.SwitchWear(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.SaveReference:
+
+		req := request.(pocket.SaveReference)
+		err := m.SaveReference(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.ClearReference:
+
+		req := request.(pocket.ClearReference)
+		m.ClearReference(&req)
+		r <- Response{
+			Result: req,
+			Error:  nil,
+		}
+
+	case pocket.SetLimits:
+
+		req := request.(pocket.SetLimits)
+		err := m.SetLimits(&req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Compare:
+
+		req := request.(pocket.Compare)
+		err := m.Compare(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.Batch:
+
+		req := request.(pocket.Batch)
+		err := m.Batch(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	case pocket.TimeDomainQuery:
+
+		req := request.(pocket.TimeDomainQuery)
+
+		err := m.MeasureTimeDomain(ctx, &req)
+		r <- Response{
+			Result: req,
+			Error:  err,
+		}
+
+	}
+
+	return
+}
+
+// func SaveReference measures a calibrated trace, and keeps it to normalize later results to
+func (m *Middle) SaveReference(ctx context.Context, request *pocket.SaveReference) error {
+
+	crq := pocket.CalibratedRangeQuery{
+		What:   request.What,
+		Avg:    request.Avg,
+		Z0:     request.Z0,
+		Sweeps: request.Sweeps,
+		Reject: request.Reject,
+	}
+
+	err := m.MeasureRangeCalibrated(ctx, &crq)
+
+	if err != nil {
+		return err
+	}
+
+	if len(crq.Result) == 0 {
+		return errors.New("no result to save as the reference")
+	}
+
+	z0 := request.Z0
+
+	if z0 == 0 {
+		z0 = Z0
+	}
+
+	m.ref = crq.Result
+	m.refInfo = &pocket.Reference{
+		What:   request.What,
+		Saved:  time.Now().Unix(),
+		Points: len(crq.Result),
+		Start:  crq.Result[0].Freq,
+		End:    crq.Result[len(crq.Result)-1].Freq,
+		Z0:     z0,
+	}
+
+	// normalized results in the cache were for the old reference
+	m.cache = nil
+
+	request.Result = crq.Result
+	request.Meta = crq.Meta
+	info := *m.refInfo
+	request.Reference = &info
+
+	return nil
+}
+
+// func ClearReference forgets the saved reference
+func (m *Middle) ClearReference(request *pocket.ClearReference) {
+	m.ref = nil
+	m.refInfo = nil
+	m.cache = nil
+}
+
+// normalize divides s by the saved reference, which must have the same frequencies and reference impedance z0 (zero for the default)
+func (m *Middle) normalize(s []pocket.SParam, z0 float64) ([]pocket.SParam, error) {
+
+	if m.ref == nil {
+		return nil, errors.New("there is no reference to normalize to, so use saveref first")
+	}
+
+	if z0 == 0 {
+		z0 = Z0
+	}
+
+	if z0 != m.refInfo.Z0 {
+		return nil, fmt.Errorf("reference was saved with z0 of %g ohms, not %g ohms", m.refInfo.Z0, z0)
+	}
+
+	return Normalize(s, m.ref)
+}
+
+// func Normalize divides each S-parameter of s by the one at the same frequency in ref,
+// which is the same as subtracting ref in dB, and its phase in degrees. Where ref is zero, so is the result.
+func Normalize(s, ref []pocket.SParam) ([]pocket.SParam, error) {
+
+	if len(s) != len(ref) {
+		return nil, fmt.Errorf("reference has %d points, not %d, so use saveref again", len(ref), len(s))
+	}
+
+	divide := func(a, b pocket.Complex) pocket.Complex {
+		zb := complex(b.Real, b.Imag)
+		if zb == 0 {
+			return pocket.Complex{}
+		}
+		q := complex(a.Real, a.Imag) / zb
+		return pocket.Complex{Real: real(q), Imag: imag(q)}
+	}
+
+	x := make([]pocket.SParam, len(s))
+
+	for i, v := range s {
+
+		if v.Freq != ref[i].Freq {
+			return nil, fmt.Errorf("reference is at %d Hz, not %d Hz, so use saveref again", ref[i].Freq, v.Freq)
+		}
+
+		x[i] = pocket.SParam{
+			Freq: v.Freq,
+			S11:  divide(v.S11, ref[i].S11),
+			S12:  divide(v.S12, ref[i].S12),
+			S21:  divide(v.S21, ref[i].S21),
+			S22:  divide(v.S22, ref[i].S22),
+		}
+	}
+
+	return x, nil
+}
+
+// func SetLimits replaces the limit lines that calibrated results are checked against
+func (m *Middle) SetLimits(request *pocket.SetLimits) error {
+
+	err := limit.Check(request.Limits)
+
+	if err != nil {
+		return err
+	}
+
+	m.limits = request.Limits
+
+	if len(m.limits) == 0 {
+		m.limits = nil
+	}
+
+	// cached results were checked against the old limits
+	m.cache = nil
+
+	return nil
+}
+
+// func Compare measures DUTs A and B one after the other with the same calibrated
+// range query, and finds the difference of B from A
+func (m *Middle) Compare(ctx context.Context, request *pocket.Compare) error {
+
+	if request.A == "" || request.B == "" {
+		return errors.New("give the two positions to compare as a and b")
+	}
+
+	results := make([][]pocket.SParam, 2)
+
+	var meta pocket.Meta
+
+	for i, what := range []string{request.A, request.B} {
+
+		crq := pocket.CalibratedRangeQuery{
+			What:   what,
+			Avg:    request.Avg,
+			Select: request.Select,
+			Z0:     request.Z0,
+			Sweeps: request.Sweeps,
+			Reject: request.Reject,
+		}
+
+		err := m.MeasureRangeCalibrated(ctx, &crq)
+
+		if err != nil {
+			return fmt.Errorf("could not measure %s because %s", what, err.Error())
+		}
+
+		results[i] = crq.Result
+		meta = *crq.Meta
+	}
+
+	request.ResultA = results[0]
+	request.ResultB = results[1]
+	meta.Formatted = "difference of b from a, magnitude in dB, phase in degrees"
+	request.Meta = &meta
+
+	var err error
+
+	request.Delta, err = format.Delta(request.ResultA, request.ResultB)
+
+	return err
+}
+
+// MaxBatch is the most commands allowed in a batch, so one user cannot hold the
+// VNA for too long (the request timeout applies to the whole batch)
+const MaxBatch = 32
+
+// func Batch handles the requests in a batch in turn, with no others in between,
+// stopping at the first error unless Continue is set. Each result, or the error in
+// its place, is added to Results, so the results so far are returned even if it stops.
+func (m *Middle) Batch(ctx context.Context, request *pocket.Batch) error {
+
+	if len(request.Requests) == 0 {
+		return errors.New("batch has no commands")
+	}
+
+	if len(request.Requests) > MaxBatch {
+		return fmt.Errorf("batch has %d commands but the most allowed is %d", len(request.Requests), MaxBatch)
+	}
+
+	request.Results = make([]interface{}, 0, len(request.Requests))
+
+	for i, sub := range request.Requests {
+
+		var result interface{}
+		var err error
+
+		switch sub.(type) {
+		case nil:
+			err = errors.New("unknown command")
+		case pocket.Batch, pocket.Cancel, pocket.Queue, pocket.FlushQueue, pocket.Schedule, pocket.Unschedule, pocket.Logs, pocket.SwitchTraffic, pocket.History, pocket.LastResult:
+			err = errors.New("this command cannot be used in a batch")
+		default:
+			if sc, _ := pocket.CommandOf(sub); schedules(sc) {
+				err = ErrScheduledInBatch
+			} else {
+				result, err = m.Handle(ctx, sub)
+			}
+		}
+
+		if err != nil {
+
+			request.Results = append(request.Results, pocket.CustomResult{
+				Message: err.Error(),
+				Command: sub,
+			})
+
+			if !request.Continue || ctx.Err() != nil {
+				return fmt.Errorf("batch stopped at command %d because %s", i, err.Error())
+			}
+
+			continue
+		}
+
+		request.Results = append(request.Results, result)
+	}
+
+	return nil
+}
+
+// func MeasureRange makes a raw range measurement, averaging rq.Sweeps
+// complete sweeps if there is more than one
+func (m *Middle) MeasureRange(ctx context.Context, rq *pocket.RangeQuery) error {
+	return m.measureThen(ctx, rq, "")
+}
+
+// measureThen does MeasureRange, then starts moving the switch to next (if
+// given) as soon as the last sweep is done, so that it is settling while the
+// sweeps are combined and the following measurement is set up
+func (m *Middle) measureThen(ctx context.Context, rq *pocket.RangeQuery, next string) error {
+
+	err := average.Check(rq.Sweeps, rq.Reject)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	err = m.checkRange(rq)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	if rq.Sweeps <= 1 {
+		rq.StdDev = nil
+		err = m.measureSegments(ctx, rq)
+		if err == nil && next != "" {
+			m.h.Next(ctx, next)
+		}
+		return err
+	}
+
+	var sweeps [][]pocket.SParam
+
+	for i := 0; i < rq.Sweeps; i++ {
+
+		err = m.measureSegments(ctx, rq)
+
+		if err != nil {
+			return err
+		}
+
+		sweeps = append(sweeps, rq.Result)
+	}
+
+	if next != "" {
+		m.h.Next(ctx, next)
+	}
+
+	rq.Result, rq.StdDev, err = average.Combine(sweeps, rq.Reject)
+
+	return err
+}
+
+// checkRange checks the sweep in rq before the switch or VNA is used (see measure.CheckRange),
+// and that it is within the frequencies allowed on this rig
+func (m *Middle) checkRange(rq *pocket.RangeQuery) error {
+
+	device := m.deviceRange()
+
+	err := measure.CheckRange(rq, device)
+
+	if err != nil {
+		return err
+	}
+
+	return measure.Within(rq, m.allowed(device))
+}
+
+// clamp moves the sweep in rq inside the frequencies allowed on this rig, if sweeps
+// are to be clamped, then checks it. It is for the sweep given in a request, rather
+// than that of the cal, which must not change. Errors are wrapped with ErrInvalid.
+func (m *Middle) clamp(rq *pocket.RangeQuery) error {
+
+	if m.guard.Clamp {
+
+		device := m.deviceRange()
+
+		err := measure.CheckRange(rq, device)
+
+		if err != nil {
+			return invalid(err)
+		}
+
+		changed, err := measure.Clamp(rq, m.allowed(device))
+
+		if err != nil {
+			return invalid(err)
+		}
+
+		if changed {
+			log.Infof("%s of %s moved inside the frequencies allowed on this rig", rq.Command.Command, rq.What)
+		}
+	}
+
+	err := m.checkRange(rq)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	return nil
+}
+
+// usePreset fills in the sweep of rq from the preset it names, if any. The sweep must
+// not be given as well, so that it is clear which is measured. Errors are wrapped with ErrInvalid.
+func (m *Middle) usePreset(rq *pocket.RangeQuery) error {
+
+	if rq.Preset == "" {
+		return nil
+	}
+
+	if rq.Range != (pocket.Range{}) || rq.Size != 0 || len(rq.Frequencies) > 0 || len(rq.Segments) > 0 {
+		return invalid(errors.New("give a preset or a sweep, not both"))
+	}
+
+	for _, p := range m.presets {
+		if strings.EqualFold(p.Name, rq.Preset) {
+			rq.Range = p.Range
+			rq.Size = p.Size
+			rq.LogDistribution = p.LogDistribution
+			return nil
+		}
+	}
+
+	var names []string
+
+	for _, p := range m.presets {
+		names = append(names, p.Name)
+	}
+
+	if len(names) == 0 {
+		return invalid(fmt.Errorf("unknown preset %s, as there are none", rq.Preset))
+	}
+
+	return invalid(fmt.Errorf("unknown preset %s, use one of %s", rq.Preset, strings.Join(names, ", ")))
+}
+
+// checkFreq checks that a single frequency is allowed on this rig. It is not
+// clamped, since that would measure something else. Errors are wrapped with ErrInvalid.
+func (m *Middle) checkFreq(f uint64) error {
+
+	err := measure.Within(&pocket.RangeQuery{Frequencies: []uint64{f}}, m.allowed(m.deviceRange()))
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	return nil
+}
+
+// deviceRange is the reasonable frequency range of the VNA, which is asked for once,
+// or zero if it cannot be found, in which case a sweep will fail anyway, with a better error
+func (m *Middle) deviceRange() pocket.Range {
+
+	if m.device == nil {
+
+		rfr := pocket.ReasonableFrequencyRange{}
+
+		if err := m.h.ReasonableFrequencyRange(&rfr); err != nil {
+			return pocket.Range{}
+		}
+
+		m.device = &rfr.Result
+	}
+
+	return *m.device
+}
+
+// allowed is the frequencies that may be measured on this rig, with a VNA of range
+// device, or zero if it is not known, in which case the guard band cannot be applied.
+// An End of zero is no upper limit.
+func (m *Middle) allowed(device pocket.Range) pocket.Range {
+
+	a := pocket.Range{Start: m.guard.Min, End: m.guard.Max}
+
+	if m.guard.Band == 0 || device.End == 0 {
+		return a
+	}
+
+	if s := device.Start + m.guard.Band; s > a.Start {
+		a.Start = s
+	}
+
+	if device.End > m.guard.Band {
+		if e := device.End - m.guard.Band; a.End == 0 || e < a.End {
+			a.End = e
+		}
+	}
+
+	return a
+}
+
+// func meta describes a result corrected with the current cal, from a measurement of
+// n points. The further corrections made to the result are added to it by the caller.
+func (m *Middle) meta(n int) *pocket.Meta {
+
+	meta := &pocket.Meta{
+		Freq:        pocket.Hz,
+		Values:      pocket.LinearRI,
+		Z0:          Z0,
+		Orientation: pocket.PortOrientation,
+		Correction:  pocket.TwelveTerm,
+		Grid:        m.gridID(),
+	}
+
+	// see Unterminate
+	if m.switchTerms != nil && len(m.switchTerms) == n {
+		meta.Applied = []string{"switch-terms"}
+	}
+
+	return meta
+}
+
+// func RemovePathLoss removes the loss and phase of the switch path from a raw result
+func (m *Middle) RemovePathLoss(rq *pocket.RangeQuery) error {
+
+	if m.pathLoss == nil {
+		return errors.New("path loss is not known, set path_loss in the config")
+	}
+
+	s, err := m.pathLoss.Apply(rq.What, rq.Result)
+
+	if err != nil {
+		return fmt.Errorf("path loss not removed because %s", err.Error())
+	}
+
+	rq.Result = s
+
+	if rq.Meta != nil {
+		rq.Meta.Applied = append(rq.Meta.Applied, "pathloss")
+	}
+
+	return nil
+}
+
+// func Characterize measures each switch path with a known standard fitted, finds its
+// loss and phase, and writes them to the path_loss file along with any other paths
+// already there. They are used for raw results from then on.
+func (m *Middle) Characterize(ctx context.Context, request *pocket.Characterize) error {
+
+	if m.config == nil || m.config.PathLoss == "" {
+		return errors.New("path_loss must be set in the config, to say where to write the results")
+	}
+
+	paths := request.Paths
+
+	if len(paths) == 0 {
+		paths = map[string]string{"short": "short", "open": "open", "thru": "thru"}
+	}
+
+	positions := make([]string, 0, len(paths))
+
+	for what, std := range paths {
+
+		err := measure.CheckWhat(what)
+
+		if err != nil {
+			return err
+		}
+
+		switch strings.ToLower(std) {
+		case "short", "open", "thru":
+		default:
+			return fmt.Errorf("the standard at %s must be short, open or thru, not %s", what, std)
+		}
+
+		positions = append(positions, what)
+	}
+
+	sort.Strings(positions)
+
+	table := pathloss.Table{Name: "characterized " + time.Now().UTC().Format(time.RFC3339), Paths: make(map[string]pathloss.Path)}
+
+	if m.pathLoss != nil {
+		for k, v := range m.pathLoss.Paths {
+			table.Paths[k] = v
+		}
+	}
+
+	request.Result = make(map[string]pocket.SwitchPath)
+
+	for _, what := range positions {
+
+		rq := pocket.RangeQuery{
+			Command:         request.Command,
+			Range:           request.Range,
+			Size:            request.Size,
+			LogDistribution: request.LogDistribution,
+			Avg:             request.Avg,
+			Sweeps:          request.Sweeps,
+			Select:          pocket.SParamSelect{S11: true, S12: true, S21: true, S22: true},
+			What:            what,
+			Power:           m.power,
+		}
+
+		err := m.MeasureRange(ctx, &rq)
+
+		if err != nil {
+			return fmt.Errorf("could not measure %s because %s", what, err.Error())
+		}
+
+		actual, err := m.standard(paths[what], rq.Result)
+
+		if err != nil {
+			return err
+		}
+
+		p, err := pathloss.Find(paths[what], rq.Result, actual)
+
+		if err != nil {
+			return fmt.Errorf("could not characterize %s because %s", what, err.Error())
+		}
+
+		key := strings.ToLower(what)
+		table.Paths[key] = p
+		request.Result[key] = p
+	}
+
+	err := table.Save(m.config.PathLoss)
+
+	if err != nil {
+		return fmt.Errorf("could not write path loss because %s", err.Error())
+	}
+
+	m.pathLoss = &table
+	request.File = m.config.PathLoss
+
+	log.Infof("characterized switch paths %s, written to %s", strings.Join(positions, ", "), request.File)
+
+	return nil
+}
+
+// func standard returns the actual S-parameters of a standard at the frequencies of s,
+// from the cal kit if there is one, or else assuming it is ideal
+func (m *Middle) standard(name string, s []pocket.SParam) ([]pocket.SParam, error) {
+
+	if m.kit != nil {
+
+		freq := make([]uint64, len(s))
+
+		for i, v := range s {
+			freq[i] = v.Freq
+		}
+
+		short, open, _, thru, err := m.kit.Ideals(freq)
+
+		if err != nil {
+			return nil, fmt.Errorf("cal kit: %s", err.Error())
+		}
+
+		return map[string][]pocket.SParam{"short": short, "open": open, "thru": thru}[strings.ToLower(name)], nil
+	}
+
+	ideal := map[string]twoport.S{"short": twoport.Ideal.Short, "open": twoport.Ideal.Open, "thru": twoport.Ideal.Thru}[strings.ToLower(name)]
+
+	a := make([]pocket.SParam, len(s))
+
+	for i, v := range s {
+		a[i] = ideal.SParam(v.Freq)
+	}
+
+	return a, nil
+}
+
+// timing records how long each stage of a request takes, for debugtiming
+type timing struct {
+	pocket.Timing
+	start time.Time
+}
+
+// timed returns ctx with a timing for the stages of a request, if debug is set,
+// or ctx and nil otherwise
+func timed(ctx context.Context, debug bool) (context.Context, *timing) {
+
+	if !debug {
+		return ctx, nil
+	}
+
+	t := &timing{start: time.Now()}
+
+	return measure.WithTiming(ctx, &t.Timing), t
+}
+
+// done returns a copy of meta with the timing, once the response is ready, timing the
+// marshalling of response along the way. The copy leaves any cached result as it was.
+// It returns meta as it is if there is no timing, or no meta.
+func (t *timing) done(meta *pocket.Meta, response interface{}) *pocket.Meta {
+
+	if t == nil || meta == nil {
+		return meta
+	}
+
+	start := time.Now()
+	_, _ = pocket.Marshal(response)
+	t.Marshal = time.Since(start).Seconds()
+	t.Total = time.Since(t.start).Seconds()
+
+	c := *meta
+	r := t.Timing
+	c.Timing = &r
+
+	return &c
+}
+
+// calibrate calls the calibration backend, adding the time it takes to the timing in ctx, if any
+func (m *Middle) calibrate(ctx context.Context, freq []uint64, std *calibration.Standards, dut []pocket.SParam) (calibration.Result, error) {
+
+	start := time.Now()
+
+	r, err := m.cal.Calibrate(ctx, freq, std, dut)
+
+	if t := measure.TimingFrom(ctx); t != nil {
+		t.Calibrate += time.Since(start).Seconds()
+	}
+
+	return r, err
+}
+
+// func rawMeta describes a result as measured, with no correction
+func rawMeta() *pocket.Meta {
+	return &pocket.Meta{
+		Freq:        pocket.Hz,
+		Values:      pocket.LinearRI,
+		Z0:          Z0,
+		Orientation: pocket.PortOrientation,
+		Correction:  pocket.CorrectionNone,
+	}
+}
+
+// func SetBackend sets what does the calibrations, e.g. calibration.Native to do without the
+// calibration service. It takes effect from the next calibration.
+func (m *Middle) SetBackend(b calibration.Backend) {
+	m.cal = b
+}
+
+// func SetStream sets the stream of requests from users, and where their responses go,
+// along with a switchevent each time the switch moves
+func (m *Middle) SetStream(s *stream.Stream) {
+
+	m.s = s
+
+	if m.h == nil {
+		return
+	}
+
+	ctx := m.ctx
+
+	// does not refer to m, which may be copied
+	m.h.OnSwitch = func(from, to string) {
+		s.Publish(ctx, pocket.SwitchEvent{
+			Command: pocket.Command{Command: "switchevent"},
+			From:    from,
+			To:      to,
+			At:      time.Now().UTC(),
+		})
+	}
+}
+
+// func SetSpectator mirrors every message sent on the stream to topic, a second topic
+// that is read-only, e.g. so that a class can watch the measurements of a demonstrator
+// live without being able to send commands. An empty topic mirrors nothing.
+func (m *Middle) SetSpectator(topic string) {
+	if m.s != nil && topic != "" {
+		m.s.Spectate(m.ctx, topic)
+	}
+}
+
+// func SetMaxMessage sets the largest message sent on the stream, in bytes, so that
+// larger responses are split into parts. Zero means there is no limit.
+func (m *Middle) SetMaxMessage(n int) {
+	if m.s != nil {
+		m.s.SetMaxMessage(n)
+	}
+}
+
+// func GetConfig returns the settings the daemon was started with
+func (m *Middle) GetConfig(request *pocket.GetConfig) error {
+
+	if m.config == nil {
+		return errors.New("config is not known")
+	}
+
+	c := *m.config
+
+	if c.Token != "" {
+		c.Token = "********" // users need not know it to see the rest
+	}
+
+	if c.FleetToken != "" {
+		c.FleetToken = "********"
+	}
+
+	if c.WebhookToken != "" {
+		c.WebhookToken = "********"
+	}
+
+	request.Result = c
+
+	return nil
+}
+
+// func CalState reports where the calibration workflow is up to
+func (m *Middle) CalState(request *pocket.CalState) error {
+
+	request.State = string(m.calState.State())
+	request.Since = m.calState.Since()
+	request.Reason = m.calState.Reason()
+	request.Measured = m.calState.Measured()
+	request.Remaining = m.calState.Remaining(m.standards())
+	request.Warmup = m.warming()
+
+	return nil
+}
+
+// func Health summarises the state of the rig for the fleet, and starts counting the
+// recent errors again. Hold the state while calling it.
+func (m *Middle) Health() fleet.Health {
+
+	h := fleet.Health{
+		Time:     time.Now().UTC(),
+		Version:  m.version,
+		Protocol: stream.Protocol,
+		Uptime:   time.Since(m.started).Seconds(),
+		CalState: string(m.calState.State()),
+		Queue:    len(m.queue),
+		Warmup:   m.warming(),
+	}
+
+	if m.fleet != nil {
+		h.Rig = m.fleet.Rig
+	}
+
+	if m.rq != nil && !m.calAt.IsZero() {
+		age := time.Since(m.calAt).Seconds()
+		h.CalAge = &age
+	}
+
+	m.tally.Fill(&h)
+
+	switch m.calState.State() {
+	case calstate.Calibrated:
+	case calstate.Stale:
+		h.Problems = append(h.Problems, "the cal is stale because "+m.calState.Reason())
+	case calstate.Interrupted:
+		h.Problems = append(h.Problems, "the rc was interrupted because "+m.calState.Reason()+", so resume it with resumecal")
+	default:
+		h.Problems = append(h.Problems, "the rig is not calibrated")
+	}
+
+	if h.Warmup != nil && !h.Warmup.Warm {
+		h.Problems = append(h.Problems, fmt.Sprintf("the VNA is warming up, %.0fs to go", h.Warmup.Remaining))
+	}
+
+	if m.h != nil && m.h.Wear != nil {
+		for _, p := range m.h.Wear.Wear().Worn {
+			h.Problems = append(h.Problems, fmt.Sprintf("the switch has reached its rated moves to %s, and should be replaced", p))
+		}
+	}
+
+	if h.Recent > 0 {
+		h.Problems = append(h.Problems, fmt.Sprintf("%d requests failed since the last report, the last because %s", h.Recent, h.LastError))
+	}
+
+	h.Check()
+
+	return h
+}
+
+// warming says whether the VNA has warmed up, or nil if the warm-up is not tracked
+func (m *Middle) warming() *pocket.Warmup {
+
+	if m.warmup <= 0 || m.h == nil {
+		return nil
+	}
+
+	w := pocket.Warmup{
+		Period: m.warmup.Seconds(),
+		On:     m.h.Opened().UTC(),
+		Refuse: m.warmupRefuse,
+	}
+
+	if f := m.h.FirstMeasured(); !f.IsZero() {
+		f = f.UTC()
+		w.FirstMeasured = &f
+	}
+
+	remaining := time.Until(m.h.Opened().Add(m.warmup))
+
+	if remaining > 0 {
+		w.Remaining = remaining.Seconds()
+	} else {
+		w.Warm = true
+	}
+
+	return &w
+}
+
+// checkWarm returns a warning for a cal made while the VNA is warming up, or
+// ErrWarmingUp if such cals are refused
+func (m *Middle) checkWarm() (string, error) {
+
+	w := m.warming()
+
+	if w == nil || w.Warm {
+		return "", nil
+	}
+
+	remaining := time.Duration(w.Remaining * float64(time.Second)).Round(time.Second)
+
+	if w.Refuse {
+		return "", fmt.Errorf("%w, for another %s, so calibrate after that", ErrWarmingUp, remaining)
+	}
+
+	warning := fmt.Sprintf("the VNA is still warming up, for another %s, so the cal may drift; calibrate again after that", remaining)
+
+	log.Warn(warning)
+
+	return warning, nil
+}
+
+// func transition moves the calibration workflow on by event e, and tells the
+// users on the stream, if there is one, with a calevent. An event that is not
+// allowed in the current state is logged, since it means the state has got out
+// of step with the cal, and is otherwise ignored.
+func (m *Middle) transition(e calstate.Event, reason string) {
+
+	t, err := m.calState.Fire(e, reason)
+
+	if err != nil {
+		log.WithField("error", err).Error("Calibration state not changed")
+		return
+	}
+
+	log.WithFields(log.Fields{"from": t.From, "to": t.To, "event": t.Event, "reason": t.Reason}).Info("Calibration state changed")
+
+	if m.s == nil {
+		return
+	}
+
+	ev := pocket.CalEvent{
+		Command: pocket.Command{Command: "calevent"},
+		From:    string(t.From),
+		To:      string(t.To),
+		Event:   string(t.Event),
+		Reason:  t.Reason,
+		At:      t.Time,
+	}
+
+	// so that a client can show how far the cal has got
+	if rem := m.calState.Remaining(m.standards()); rem != nil || t.To == calstate.PartiallyMeasured {
+		ev.Measured = m.calState.Measured()
+		ev.Remaining = rem
+	}
+
+	m.s.Publish(m.ctx, ev)
+}
+
+// func Reset closes the VNA, the switch and the connection to the calibration service,
+// and opens them again, keeping the settings, to recover a confused rig without a
+// restart. The cal is discarded, unless keepcal is set, and cached results are not used
+// afterwards. Anything that cannot be opened again is given in the error, after
+// trying to open the rest.
+func (m *Middle) Reset(request *pocket.Reset) error {
+
+	request.Reopened = []string{}
+
+	var failed []string
+
+	release, err := m.h.ReopenVNA(m.release)
+
+	if err != nil {
+		failed = append(failed, "the VNA because "+err.Error())
+	} else {
+		m.release = release
+		request.Reopened = append(request.Reopened, "vna")
+	}
+
+	// asked again, in case it was wrong
+	m.device = nil
+
+	err = m.h.ReopenSwitch(m.switchPort, m.baud, m.timeoutUSB)
+
+	if err != nil {
+		failed = append(failed, "the switch because "+err.Error())
+	} else {
+		request.Reopened = append(request.Reopened, "switch")
+	}
+
+	// only a connection made by New can be made again
+	if m.calAddr != "" {
+
+		cal, err := calibration.Dial(m.calAddr)
+
+		if err != nil {
+			failed = append(failed, "the calibration service because "+err.Error())
+		} else {
+
+			if c, ok := m.cal.(io.Closer); ok {
+				c.Close()
+			}
+
+			m.cal = cal
+			request.Reopened = append(request.Reopened, "calibration")
+		}
+	}
+
+	m.invalidate()
+
+	if !request.KeepCal {
+
+		m.rq = nil
+		m.resume = nil
+		m.terms = nil
+		m.std = nil
+
+		if s := m.calState.State(); s == calstate.Calibrated || s == calstate.Stale || s == calstate.Interrupted {
+			m.transition(calstate.Discard, "reset")
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("reset could not open %s", strings.Join(failed, ", or "))
+	}
+
+	log.WithField("keepcal", request.KeepCal).Info("reset the VNA, switch and calibration service")
+
+	return nil
+}
+
+// func FlashSwitch replaces the firmware of the switch controller with the image in
+// the request, and opens the switch again with the new firmware. The cal is kept,
+// since the firmware does not change the paths through the switch.
+func (m *Middle) FlashSwitch(ctx context.Context, request *pocket.FlashSwitch) error {
+
+	image, err := rfusb.ParseHex(request.Hex)
+
+	request.Hex = "" // not worth sending back
+
+	if err != nil {
+		return invalid(fmt.Errorf("hex is not a firmware image because %s", err.Error()))
+	}
+
+	if request.Baud < 0 {
+		return invalid(fmt.Errorf("baud must be positive, or left out for %d, not %d", rfusb.BootBaud, request.Baud))
+	}
+
+	err = m.h.FlashSwitch(ctx, image, request.Baud)
+
+	if err != nil {
+		return fmt.Errorf("could not flash the switch because %s", err.Error())
+	}
+
+	request.Written = len(image)
+
+	return nil
+}
+
+// func ProtocolTest puts the switch controller through every command, and the edge cases
+// that it should survive, and reports how it did in each check, e.g. to validate a new
+// build of its firmware before it is flashed to every rig. A check that fails is in the
+// result, not an error, which is only for a controller that cannot be tested.
+func (m *Middle) ProtocolTest(ctx context.Context, request *pocket.ProtocolTest) error {
+
+	if request.Toggles < 0 || request.Toggles > rfusb.MaxToggles {
+		return invalid(fmt.Errorf("toggles must be between 1 and %d, or left out for %d, not %d", rfusb.MaxToggles, rfusb.Toggles, request.Toggles))
+	}
+
+	c, err := m.h.ProtocolTest(ctx, request.Toggles)
+
+	if err != nil {
+		return fmt.Errorf("could not test the protocol of the switch because %s", err.Error())
+	}
+
+	result := pocket.Conformance{
+		Protocol: c.Protocol,
+		Passed:   c.Passed(),
+		Failed:   c.Failed(),
+		Checks:   make([]pocket.ConformanceCheck, len(c.Checks)),
+		Moves:    c.Moves,
+		Port:     c.Port,
+		Took:     c.Took.Seconds(),
+	}
+
+	for i, check := range c.Checks {
+		result.Checks[i] = pocket.ConformanceCheck{
+			Name:   check.Name,
+			Passed: check.Passed,
+			Detail: check.Detail,
+			Took:   check.Took.Seconds(),
+		}
+	}
+
+	request.Result = &result
+
+	l := log.WithFields(log.Fields{"protocol": c.Protocol, "failed": c.Failed(), "took": c.Took.String()})
+
+	if c.Passed() {
+		l.Info("switch passed the protocol test")
+	} else {
+		l.Warn("switch failed the protocol test")
+	}
+
+	return nil
+}
+
+// func SwitchWear reports how many times the switch has moved to each position, and
+// starts counting again if asked, e.g. after the switch is replaced
+func (m *Middle) SwitchWear(request *pocket.SwitchWear) error {
+
+	if m.h == nil || m.h.Wear == nil {
+		return errors.New("the moves of the switch are not being counted")
+	}
+
+	if request.Reset {
+
+		err := m.h.Wear.Reset()
+
+		if err != nil {
+			return fmt.Errorf("could not start counting the moves of the switch again because %s", err.Error())
+		}
+
+		log.Warn("switch wear reset")
+	}
+
+	w := m.h.Wear.Wear()
+	request.Result = &w
+
+	return nil
+}
+
+// func GetGrid returns the ID and frequencies of the grid of the current cal
+func (m *Middle) GetGrid(request *pocket.GetGrid) error {
+
+	if m.std == nil {
+		return ErrNotCalibrated
+	}
+
+	request.Frequencies = calibration.Freq(m.std.Short)
+	request.Grid = GridID(request.Frequencies)
+
+	return nil
+}
+
+// gridID is the ID of the grid of the current cal, or empty if there is none
+func (m *Middle) gridID() string {
+
+	if m.std == nil {
+		return ""
+	}
+
+	return GridID(calibration.Freq(m.std.Short))
+}
+
+// func GridID identifies a list of frequencies (Hz), so that a client can tell whether
+// two results are on the same points without comparing them. It is the first 16 hex
+// digits of the SHA-256 of the frequencies, written as whole numbers separated by commas.
+func GridID(freq []uint64) string {
+
+	f := make([]string, len(freq))
+
+	for i, v := range freq {
+		f[i] = strconv.FormatUint(v, 10)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(f, ",")))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// func GridOf is the GridID of the frequencies of a result, or empty if it has none
+func GridOf(s []pocket.SParam) string {
+
+	if len(s) == 0 {
+		return ""
+	}
+
+	return GridID(calibration.Freq(s))
+}
+
+// func ExportCal zips the standards of the current cal, as they were sent to the
+// backend, along with those of the cal kit, if any, and a description of the cal,
+// so that its quality can be checked offline, e.g. by loading the files into scikit-rf
+func (m *Middle) ExportCal(request *pocket.ExportCal) error {
+
+	if m.rq == nil || m.std == nil {
+		return ErrNotCalibrated
+	}
+
+	a := pocket.CalArchive{
+		Time:            m.calAt.UTC(),
+		Range:           m.rq.Range,
+		Size:            m.rq.Size,
+		LogDistribution: m.rq.LogDistribution,
+		Avg:             m.rq.Avg,
+		Sweeps:          m.rq.Sweeps,
+		Reject:          m.rq.Reject,
+		Power:           m.rq.Power,
+		Z0:              Z0,
+		Frequencies:     calibration.Freq(m.std.Short),
+		Files:           make(map[string]string),
+	}
+
+	if m.kit != nil {
+		a.Kit = m.kit.Name
+	}
+
+	for i, st := range m.switchTerms {
+		a.SwitchTerms = append(a.SwitchTerms, pocket.SwitchTerm{
+			Freq:    a.Frequencies[i],
+			Forward: pocket.Complex{Real: real(st[0]), Imag: imag(st[0])},
+			Reverse: pocket.Complex{Real: real(st[1]), Imag: imag(st[1])},
+		})
+	}
+
+	files := []struct {
+		name string
+		s    []pocket.SParam
+	}{
+		{"short", m.std.Short},
+		{"open", m.std.Open},
+		{"load", m.std.Load},
+		{"thru", m.std.Thru},
+		{"ideal_short", m.std.IdealShort},
+		{"ideal_open", m.std.IdealOpen},
+		{"ideal_load", m.std.IdealLoad},
+		{"ideal_thru", m.std.IdealThru},
+	}
+
+	var b bytes.Buffer
+
+	z := zip.NewWriter(&b)
+
+	for _, f := range files {
+
+		if f.s == nil {
+			continue // no cal kit
+		}
+
+		name := f.name + ".s2p"
+
+		w, err := z.Create(name)
+
+		if err != nil {
+			return err
+		}
+
+		comment := "measured " + f.name + ", with the switch terms removed if in use"
+
+		if strings.HasPrefix(f.name, "ideal_") {
+			comment = "actual " + strings.TrimPrefix(f.name, "ideal_") + " from cal kit " + a.Kit
+		}
+
+		err = touchstone.Write(w, f.s, Z0, comment, "calibrated at "+a.Time.Format(time.RFC3339))
+
+		if err != nil {
+			return err
+		}
+
+		a.Files[f.name] = name
+	}
+
+	w, err := z.Create("calibration.json")
+
+	if err != nil {
+		return err
+	}
+
+	e := json.NewEncoder(w)
+	e.SetIndent("", "  ")
+
+	err = e.Encode(a)
+
+	if err != nil {
+		return err
+	}
+
+	err = z.Close()
+
+	if err != nil {
+		return err
+	}
+
+	request.Name = "cal-" + a.Time.Format("20060102T150405Z") + ".zip"
+	request.Result = b.Bytes()
+
+	if !request.Save {
+		return nil
+	}
+
+	if m.store == nil {
+		return invalid(errors.New("there is no store to save the cal in, so set store"))
+	}
+
+	key := CalDir + request.Name
+
+	err = m.store.Put(key, request.Result)
+
+	if err != nil {
+		return fmt.Errorf("could not save the cal because %s", err.Error())
+	}
+
+	request.Saved = key
+
+	return nil
+}
+
+// func Hello describes the protocol, commands and rig, for clients to learn what they can ask for
+func (m *Middle) Hello(request *pocket.Hello) error {
+
+	if request.Version > stream.Protocol {
+		log.WithFields(log.Fields{"client": request.Version, "protocol": stream.Protocol}).Warn("client expects a newer stream protocol")
+	}
+
+	rfr := pocket.ReasonableFrequencyRange{}
+
+	err := m.h.ReasonableFrequencyRange(&rfr)
+
+	if err != nil {
+		return fmt.Errorf("could not get the frequency range of the VNA because %s", err.Error())
+	}
+
+	var positions []string
+
+	for p := range rfusb.Channels {
+		positions = append(positions, p)
+	}
+
+	sort.Strings(positions)
+
+	request.Result = &pocket.Capabilities{
+		Protocol:  stream.Protocol,
+		Commands:  stream.Commands,
+		Positions: positions,
+		Range:     rfr.Result,
+		Allowed:   m.allowed(rfr.Result),
+		Clamp:     m.guard.Clamp,
+		MaxPoints: pocket.MaxPoints,
+		MaxBatch:  MaxBatch,
+		MaxLimits: limit.MaxLimits,
+		Chunking: pocket.Chunking{
+			Cmd:        "part",
+			Reassembly: stream.Reassembly,
+		},
+	}
+
+	if m.s != nil {
+		request.Result.Chunking.MaxMessage = m.s.MaxMessage()
+	}
+
+	return nil
+}
+
+// func Schema returns the JSON Schema of the named command, or of all of them
+func (m *Middle) Schema(request *pocket.Schema) error {
+
+	s := stream.Schemas()
+
+	if request.Name == "" {
+		request.Result = s
+		return nil
+	}
+
+	cmd, ok := pocket.Lookup(request.Name)
+
+	if !ok {
+		return fmt.Errorf("there is no command called %s", request.Name)
+	}
+
+	one, ok := s[cmd]
+
+	if !ok {
+		return fmt.Errorf("there is no command called %s", request.Name)
+	}
+
+	request.Result = one
+
+	return nil
+}
+
+// invalidate stops cached results from being used, after anything that changes calibrated results
+func (m *Middle) invalidate() {
+	m.calID++
+	m.cache = nil
+}
+
+// cacheKey identifies the parameters of a request that affect its result. Only the
+// fields that are given by the client are used, so that a field of the response, e.g.
+// one added later, cannot stop a request from matching the result kept for it. The avg
+// is left out when there is a target, since it is then chosen, not given.
+func (m *Middle) cacheKey(request *pocket.CalibratedRangeQuery) string {
+
+	p := struct {
+		What        string
+		Avg         uint16
+		Select      pocket.SParamSelect
+		Format      string
+		FormatOnly  bool
+		Z0          float64
+		Sweeps      int
+		Reject      string
+		Target      float64
+		MaxAvg      uint16
+		Grid        string
+		Smooth      *pocket.Smoothing
+		Pipeline    []string
+		Layout      string
+		Smith       bool
+		Aperture    int
+		Uncertainty bool
+		Normalize   bool
+		Raw         bool
+		Digits      int
+		Display     int
+	}{
+		What:        strings.ToLower(request.What),
+		Avg:         request.Avg,
+		Select:      request.Select,
+		Format:      request.Format,
+		FormatOnly:  request.FormatOnly,
+		Z0:          request.Z0,
+		Sweeps:      request.Sweeps,
+		Reject:      request.Reject,
+		Target:      request.Target,
+		MaxAvg:      request.MaxAvg,
+		Grid:        request.Grid,
+		Smooth:      request.Smooth,
+		Pipeline:    request.Pipeline,
+		Layout:      request.Layout,
+		Smith:       request.Smith,
+		Uncertainty: request.Uncertainty,
+		Normalize:   request.Normalize,
+		Raw:         request.Raw,
+		Digits:      request.Digits,
+		Display:     request.Display,
+	}
+
+	if p.Target > 0 {
+		p.Avg = 0
+	}
+
+	if request.PhaseDelay != nil {
+		// only the aperture asked for, as it was given
+		p.Aperture = request.PhaseDelay.Aperture
+		if p.Aperture == 0 {
+			p.Aperture = format.DefaultAperture
+		}
+	}
+
+	b, _ := json.Marshal(p)
+
+	return fmt.Sprintf("%d:%s", m.calID, b)
+}
+
+// func FromCache fills in the response to request from the cache, if there is a
+// result for the same parameters that is no older than request.MaxAge seconds.
+// If the client already has that result (same ETag), only NotModified is set.
+func (m *Middle) FromCache(request *pocket.CalibratedRangeQuery) bool {
+
+	if request.MaxAge <= 0 {
+		return false
+	}
+
+	c, ok := m.cache[m.cacheKey(request)]
+
+	if !ok || time.Since(c.at).Seconds() > request.MaxAge {
+		return false
+	}
+
+	command := request.Command
+	maxAge := request.MaxAge
+
+	if request.ETag == c.result.ETag {
+		*request = pocket.CalibratedRangeQuery{
+			What:        request.What,
+			ETag:        c.result.ETag,
+			NotModified: true,
+		}
+	} else {
+		*request = c.result
+	}
+
+	request.Command = command
+	request.MaxAge = maxAge
+	request.Cached = true
+
+	return true
+}
+
+// func ToCache keeps the result of request, to be used by later requests with the same parameters
+func (m *Middle) ToCache(request *pocket.CalibratedRangeQuery) {
+
+	at := time.Now()
+
+	request.ETag = fmt.Sprintf("%d-%d", m.calID, at.UnixNano())
+
+	if m.cache == nil || len(m.cache) >= MaxCached {
+		// start again rather than grow without limit
+		m.cache = make(map[string]cached)
+	}
+
+	m.cache[m.cacheKey(request)] = cached{
+		result: *request,
+		at:     at,
+	}
+}
+
+// func Analyze finds the markers in the last calibrated result
+func (m *Middle) Analyze(request *pocket.Analysis) error {
+
+	if m.rq == nil || len(m.dutcal) == 0 {
+		return errors.New("there is no calibrated result to analyze yet")
+	}
+
+	markers, err := marker.Find(m.dutcal)
+
+	if err != nil {
+		return err
+	}
+
+	request.What = m.rq.What
+	request.Result = &markers
+
+	return nil
+}
+
+// func SetSweep starts or stops a continuous sweep. Starting a new sweep
+// replaces any sweep that is already running
+func (m *Middle) SetSweep(request *pocket.Sweep) error {
+
+	cmd, _ := pocket.Lookup(request.Command.Command)
+
+	switch cmd {
+
+	case "stopsweep":
+		m.setSweep(nil)
+		return nil
+
+	case "startsweep":
+
+	default:
+		return fmt.Errorf("unknown sweep command %s", request.Command.Command)
+	}
+
+	if request.Interval < 0 {
+		return fmt.Errorf("interval must not be negative, not %g", request.Interval)
+	}
+
+	// check now, rather than finding out at the first sweep
+	err := format.Check(request.Format)
+
+	if err != nil {
+		return err
+	}
+
+	err = average.Check(request.Sweeps, request.Reject)
+
+	if err != nil {
+		return err
+	}
+
+	if request.Z0 < 0 {
+		return fmt.Errorf("reference impedance must be positive, not %g", request.Z0)
+	}
+
+	err = measure.CheckWhat(request.What)
+
+	if err != nil {
+		return err
+	}
+
+	if m.rq == nil {
+		return ErrNotCalibrated
+	}
+
+	sweep := *request
+	m.setSweep(&sweep)
+
+	return nil
+}
+
+// func sweeping returns the continuous sweep, nil if there is none
+func (m *Middle) sweeping() *pocket.Sweep {
+	if m.sweepLock != nil {
+		m.sweepLock.Lock()
+		defer m.sweepLock.Unlock()
+	}
+	return m.sweep
+}
+
+// func setSweep starts sweep, or stops the continuous sweep if sweep is nil
+func (m *Middle) setSweep(sweep *pocket.Sweep) {
+	if m.sweepLock != nil {
+		m.sweepLock.Lock()
+		defer m.sweepLock.Unlock()
+	}
+	m.sweep = sweep
+}
+
+// func stopSweep stops the continuous sweep if it is still sweep, rather
+// than one that was started in its place while sweep was being measured
+func (m *Middle) stopSweep(sweep *pocket.Sweep) {
+	if m.sweepLock != nil {
+		m.sweepLock.Lock()
+		defer m.sweepLock.Unlock()
+	}
+	if m.sweep == sweep {
+		m.sweep = nil
+	}
+}
+
+// func SweepOnce makes the next measurement of a continuous sweep, and returns
+// the response to send. An error stops the sweep, so that it is reported once
+// rather than every interval, unless the sweep was preempted, when there is nothing to send
+func (m *Middle) SweepOnce(ctx context.Context) interface{} {
+
+	sweep := m.sweeping()
+
+	if sweep == nil {
+		return nil // stopped since it was due
+	}
+
+	crq := sweep.CalibratedRangeQuery
+
+	crq.Command = pocket.Command{
+		ID:       sweep.Command.ID,
+		Time:     int(time.Now().Unix()),
+		Command:  "sweep",
+		Priority: sweep.Command.Priority,
+		Session:  sweep.Command.Session,
+	}
+
+	response, err := m.Handle(ctx, crq)
+
+	if errors.Is(context.Cause(ctx), ErrPreempted) {
+		// nothing is sent, and the sweep carries on at the next interval
+		log.Infof("continuous sweep %s", ErrPreempted.Error())
+		return nil
+	}
+
+	if err != nil {
+		m.stopSweep(sweep)
+		return pocket.CustomResult{
+			Message: "continuous sweep stopped because " + err.Error(),
+			Command: crq,
+		}
+	}
+
+	return response
+}
+
+// func Hold starts, reports or resets the max-hold and min-hold of calibrated results
+func (m *Middle) Hold(request *pocket.Hold) error {
+
+	cmd, _ := pocket.Lookup(request.Command.Command)
+
+	switch cmd {
+
+	case "hs":
+		m.hold = pocket.Hold{
+			What:   request.What,
+			Active: true,
+		}
+
+	case "hq":
+
+	case "hr":
+		m.hold = pocket.Hold{}
+
+	default:
+		return fmt.Errorf("unknown hold command %s", request.Command.Command)
+	}
+
+	command := request.Command
+	*request = m.hold
+	request.Command = command
+
+	if request.Count > 0 {
+		request.Meta = m.meta(len(request.Max))
+		request.Meta.Values = ""
+		request.Meta.Formatted = "magnitude in dB"
+	}
+
+	return nil
+}
+
+// func Accumulate updates the max-hold and min-hold traces in h with the
+// magnitudes of s, starting again if the frequencies have changed, e.g. after a new cal
+func Accumulate(h *pocket.Hold, s []pocket.SParam) error {
+
+	db, err := format.Apply(format.DB, s)
+
+	if err != nil {
+		return err
+	}
+
+	same := h.Count > 0 && len(h.Max) == len(db)
+
+	for i := 0; same && i < len(db); i++ {
+		same = h.Max[i].Freq == db[i].Freq
+	}
+
+	if !same {
+		h.Max = magnitudes(db)
+		h.Min = magnitudes(db)
+		h.Count = 1
+		return nil
+	}
+
+	for i, v := range db {
+
+		max := []*pocket.Value{h.Max[i].S11, h.Max[i].S12, h.Max[i].S21, h.Max[i].S22}
+		min := []*pocket.Value{h.Min[i].S11, h.Min[i].S12, h.Min[i].S21, h.Min[i].S22}
+
+		for j, x := range []*pocket.Value{v.S11, v.S12, v.S21, v.S22} {
+			max[j].Mag = math.Max(max[j].Mag, x.Mag)
+			min[j].Mag = math.Min(min[j].Mag, x.Mag)
+		}
+	}
+
+	h.Count++
+
+	return nil
+}
+
+// magnitudes copies the magnitudes from fs, leaving out the phase
+func magnitudes(fs []pocket.FormattedSParam) []pocket.FormattedSParam {
+
+	m := make([]pocket.FormattedSParam, len(fs))
+
+	for i, v := range fs {
+		m[i] = pocket.FormattedSParam{
+			Freq: v.Freq,
+			S11:  &pocket.Value{Mag: v.S11.Mag},
+			S12:  &pocket.Value{Mag: v.S12.Mag},
+			S21:  &pocket.Value{Mag: v.S21.Mag},
+			S22:  &pocket.Value{Mag: v.S22.Mag},
+		}
+	}
+
+	return m
+}
+
+// measureSegments makes one sweep, segment by segment if there are any,
+// joining the results together. The calibration works point by point, so a
+// cal over the joined segments applies to each segment separately.
+func (m *Middle) measureSegments(ctx context.Context, rq *pocket.RangeQuery) error {
+
+	if len(rq.Segments) == 0 {
+		return m.h.MeasureRange(ctx, rq)
+	}
+
+	var result []pocket.SParam
+
+	for _, g := range rq.Segments {
+
+		seg := *rq
+		seg.Segments = nil
+		seg.Range = pocket.Range{Start: g.Start, End: g.End}
+		seg.Size = g.Size
+		seg.LogDistribution = g.LogDistribution
+
+		if g.Avg != 0 {
+			seg.Avg = g.Avg
+		}
+
+		err := m.h.MeasureRange(ctx, &seg)
+
+		if err != nil {
+			return err
+		}
+
+		result = append(result, seg.Result...)
+	}
+
+	rq.Result = result
+
+	return nil
+}
+
+// func MeasureNoiseFloor measures the load standard repeatedly, and finds the
+// trace noise at each frequency from the raw (uncalibrated) sweeps
+func (m *Middle) MeasureNoiseFloor(ctx context.Context, request *pocket.NoiseFloor) error {
+
+	if request.Sweeps == 0 {
+		request.Sweeps = NoiseSweeps
+	}
+
+	if request.Sweeps < 2 || request.Sweeps > average.MaxSweeps {
+		return fmt.Errorf("sweeps must be between 2 and %d, not %d", average.MaxSweeps, request.Sweeps)
+	}
+
+	rq := pocket.RangeQuery{
+		Command:         request.Command,
+		Range:           request.Range,
+		Size:            request.Size,
+		LogDistribution: request.LogDistribution,
+		Avg:             request.Avg,
+		Select:          pocket.SParamSelect{S11: true, S12: true, S21: true, S22: true},
+		What:            "load",
+		Power:           m.power,
+	}
+
+	var sweeps [][]pocket.SParam
+
+	for i := 0; i < request.Sweeps; i++ {
+
+		err := m.h.MeasureRange(ctx, &rq)
+
+		if err != nil {
+			return err
+		}
+
+		sweeps = append(sweeps, rq.Result)
+	}
+
+	n, err := average.Noise(sweeps)
+
+	if err != nil {
+		return err
+	}
+
+	// kept for the uncertainty of calibrated results on the same grid
+	_, m.noiseFloor, err = average.Combine(sweeps, "")
+
+	if err != nil {
+		return err
+	}
+
+	m.noiseGrid = GridOf(sweeps[0])
+
+	request.Result = n
+
+	return nil
+}
+
+// func MeasureRangeCalibrated measures and applies a calibration, returning calibrated results
+func (m *Middle) MeasureRangeCalibrated(ctx context.Context, request *pocket.CalibratedRangeQuery) error {
+
+	// check before measuring, to avoid wasting a sweep
+	err := format.Check(request.Format)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	err = average.Check(request.Sweeps, request.Reject)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	if request.Z0 < 0 {
+		return invalid(fmt.Errorf("reference impedance must be positive, not %g", request.Z0))
+	}
+
+	if m.rq == nil && m.fallback && m.uncalibrated != nil {
+
+		rq := *m.uncalibrated
+		rq.What = request.What
+		rq.Sweeps = request.Sweeps
+		rq.Reject = request.Reject
+		rq.Power = m.power
+
+		err = m.MeasureRange(ctx, &rq)
+
+		if err != nil {
+			return err
+		}
+
+		request.StdDev = rq.StdDev
+
+		return m.uncorrected(request, rq.Result, "the last rc could not be completed because the "+calibration.ErrUnavailable.Error())
+	}
+
+	if m.rq == nil {
+		return ErrNotCalibrated
+	}
+
+	if m.rq.Power != m.power {
+		return fmt.Errorf("calibration was taken at %g dBm but output power is now %g dBm, so recalibrate or set the power back", m.rq.Power, m.power)
+	}
+
+	if request.MaxAge < 0 {
+		return invalid(fmt.Errorf("maxage must not be negative, not %g", request.MaxAge))
+	}
+
+	err = checkTarget(request)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	if request.Grid != "" && request.Grid != m.gridID() {
+		return fmt.Errorf("the cal is on grid %s, not %s, so it has changed since the grid was found", m.gridID(), request.Grid)
+	}
+
+	if request.Smooth != nil {
+
+		// the aperture is checked against the trace once it has been measured
+		err = smooth.Check(*request.Smooth, 0)
+
+		if err != nil {
+			return invalid(err)
+		}
+	}
+
+	err = CheckPipeline(request.Pipeline)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	err = columns.Check(request.Layout)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	err = reduce.Check(request.Digits, request.Display)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	if request.Uncertainty && request.Sweeps < 2 && (m.noiseFloor == nil || (m.gridID() != "" && m.noiseGrid != m.gridID())) {
+		return ErrNoNoise
+	}
+
+	if request.PhaseDelay != nil {
+
+		// the aperture is checked against the trace once it has been measured
+		err = format.CheckAperture(request.PhaseDelay.Aperture, 0)
+
+		if err != nil {
+			return invalid(err)
+		}
+	}
+
+	if m.FromCache(request) {
+		return nil
+	}
+
+	// measure dut set by user
+	m.rq.What = request.What
+
+	// sweeps are for this request only, so do not change the cal's settings
+	rq := *m.rq
+	rq.Sweeps = request.Sweeps
+	rq.Reject = request.Reject
+
+	var averaging *pocket.Averaging
+
+	if request.Target > 0 {
+
+		averaging, err = m.adapt(ctx, &rq, request)
+
+		if err != nil {
+			return err
+		}
+
+		request.Avg = uint16(averaging.Avg)
+	}
+
+	err = m.MeasureRange(ctx, &rq)
+
+	if err != nil {
+		return err
+	}
+
+	m.dut = rq.Result
+	request.StdDev = rq.StdDev
+
+	if request.Raw {
+		request.RawResult = m.dut
+	}
+
+	meta := m.meta(len(m.dut))
+	meta.Averaging = averaging
+
+	uncorrected, err := m.process(ctx, request, meta)
+
+	if err != nil || uncorrected {
+		return err
+	}
+
+	request.Meta = meta
+
+	roundCalibrated(request)
+
+	if request.FormatOnly && request.Formatted != nil {
+		request.Result = nil
+	}
+
+	// the columns hold the same result
+	if request.Columns != nil {
+		request.Result = nil
+	}
+
+	m.ToCache(request)
+
+	return nil
+
+}
+
+// checkTarget checks the trace noise target of request, and the most avg it may choose
+func checkTarget(request *pocket.CalibratedRangeQuery) error {
+
+	if request.Target < 0 {
+		return fmt.Errorf("target must not be negative, not %g", request.Target)
+	}
+
+	return measure.CheckAvg(request.MaxAvg)
+}
+
+// func adapt makes two quick sweeps with the settings of rq, at an average of one, to
+// find the trace noise, then sets the average of rq to what is needed to bring it down
+// to the target of request, within its maxavg, and says how it was chosen
+func (m *Middle) adapt(ctx context.Context, rq *pocket.RangeQuery, request *pocket.CalibratedRangeQuery) (*pocket.Averaging, error) {
+
+	probe := *rq
+	probe.Avg = 1
+	probe.Sweeps = 2
+	probe.Reject = ""
+
+	err := m.MeasureRange(ctx, &probe)
+
+	if err != nil {
+		return nil, err
+	}
+
+	max := int(request.MaxAvg)
+
+	if max == 0 {
+		max = measure.MaxAvg
+	}
+
+	a, noise, limited, err := average.Adapt(probe.StdDev, request.Select, request.Target, max)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rq.Avg = uint16(a)
+
+	log.WithFields(log.Fields{"target": request.Target, "noise": noise, "avg": a, "limited": limited}).Debug("avg chosen to meet the target")
+
+	return &pocket.Averaging{
+		Target:  request.Target,
+		Noise:   noise,
+		Avg:     a,
+		Limited: limited,
+	}, nil
+}
+
+// func roundCalibrated rounds every value of the response to request to the digits
+// it asks for, if any, and notes it in the meta
+func roundCalibrated(request *pocket.CalibratedRangeQuery) {
+
+	if request.Digits == 0 {
+		return
+	}
+
+	d := request.Digits
+
+	request.Result = reduce.Trace(request.Result, d)
+	request.RawResult = reduce.Trace(request.RawResult, d)
+	request.Formatted = reduce.Formatted(request.Formatted, d)
+	request.SmithChart = reduce.Smith(request.SmithChart, d)
+	request.Bounds = reduce.Bounds(request.Bounds, d)
+	request.StdDev = reduce.StdDev(request.StdDev, d)
+
+	if request.PhaseDelay != nil {
+		pd := *request.PhaseDelay
+		pd.Phase = reduce.Floats(pd.Phase, d)
+		pd.Delay = reduce.Floats(pd.Delay, d)
+		request.PhaseDelay = &pd
+	}
+
+	if request.Columns != nil {
+		c := *request.Columns
+		for _, col := range []*pocket.ComplexColumn{&c.S11, &c.S12, &c.S21, &c.S22} {
+			col.Real = reduce.Floats(col.Real, d)
+			col.Imag = reduce.Floats(col.Imag, d)
+		}
+		request.Columns = &c
+	}
+
+	if request.Meta.Reduced == nil {
+		n := len(request.Result)
+		if request.Columns != nil {
+			n = len(request.Columns.Freq)
+		}
+		request.Meta.Reduced = &pocket.Reduction{Points: n, Of: n}
+	}
+
+	request.Meta.Reduced.Digits = d
+}
+
+// func reduceRange decimates and rounds the result of an rq, as the request asks, and
+// notes it in the meta
+func reduceRange(request *pocket.RangeQuery) {
+
+	n := len(request.Result)
+	idx := reduce.Indices(request.Result, request.Select, request.Display)
+
+	if idx == nil && request.Digits == 0 {
+		return
+	}
+
+	request.Result = reduce.Trace(reduce.Pick(request.Result, idx, n), request.Digits)
+	request.StdDev = reduce.StdDev(reduce.Pick(request.StdDev, idx, n), request.Digits)
+	request.Meta.Reduced = &pocket.Reduction{Digits: request.Digits, Points: len(request.Result), Of: n}
+}
+
+// func uncorrected fills in the response to request with dut, the raw measurement,
+// flagged as uncorrected because of why. Only the format, decimation and rounding are
+// applied, since the other corrections, the hold and the limits all need a calibrated
+// result. It is not cached.
+func (m *Middle) uncorrected(request *pocket.CalibratedRangeQuery, dut []pocket.SParam, why string) error {
+
+	log.Warnf("crq of %s is not calibrated, because %s", request.What, why)
+
+	var err error
+
+	n := len(dut)
+	idx := reduce.Indices(dut, request.Select, request.Display)
+
+	request.Result = reduce.Pick(dut, idx, n)
+	request.StdDev = reduce.Pick(request.StdDev, idx, n)
+	request.Uncorrected = true
+	request.Warning = "not calibrated, because " + why
+	request.Meta = rawMeta()
+	request.Meta.Grid = GridOf(dut)
+
+	if idx != nil {
+		request.Meta.Reduced = &pocket.Reduction{Points: len(idx), Of: n}
+	}
+
+	if request.Raw {
+		request.RawResult = request.Result
+	}
+
+	request.Formatted, err = format.Apply(request.Format, request.Result)
+
+	if err != nil {
+		return err
+	}
+
+	if request.Formatted != nil {
+		request.Meta.Formatted = format.Describe(request.Format)
+	}
+
+	if request.Smith {
+		request.SmithChart = format.Smith(request.Result)
+	}
+
+	if columns.Wanted(request.Layout) {
+		c := columns.From(request.Result)
+		request.Columns = &c
+		request.Meta.Loaders = columns.Loaders
+	}
+
+	roundCalibrated(request)
+
+	if (request.FormatOnly && request.Formatted != nil) || request.Columns != nil {
+		request.Result = nil
+	}
+
+	return nil
+}
+
+// func MeasureTimeDomain measures a calibrated dut, and transforms S11 and/or S21 to impulse and step responses
+func (m *Middle) MeasureTimeDomain(ctx context.Context, request *pocket.TimeDomainQuery) error {
+
+	crq := pocket.CalibratedRangeQuery{
+		What:   request.What,
+		Avg:    request.Avg,
+		Select: request.Select,
+	}
+
+	err := m.MeasureRangeCalibrated(ctx, &crq)
+
+	if err != nil {
+		return err
+	}
+
+	result, err := TimeDomain(crq.Result, request.Select, timedomain.Window{Name: request.Window, Beta: request.Beta})
+
+	if err != nil {
+		return fmt.Errorf("time domain transform failed because %s", err.Error())
+	}
+
+	request.Result = result
+	meta := *crq.Meta
+	meta.Time = "s"
+	meta.Values = "impulse and step responses, linear"
+	request.Meta = &meta
+
+	return nil
+}
+
+// func TimeDomain transforms the selected parameters of calibrated results, defaulting to S11 and S21
+func TimeDomain(s []pocket.SParam, sel pocket.SParamSelect, w timedomain.Window) (pocket.TimeDomainResult, error) {
+
+	var result pocket.TimeDomainResult
+
+	if !sel.S11 && !sel.S21 {
+		sel.S11 = true
+		sel.S21 = true
+	}
+
+	freq := calibration.Meas2Freq(s)
+
+	s11 := make([]complex128, len(s))
+	s21 := make([]complex128, len(s))
+
+	for i, v := range s {
+		s11[i] = complex(v.S11.Real, v.S11.Imag)
+		s21[i] = complex(v.S21.Real, v.S21.Imag)
+	}
+
+	if sel.S11 {
+		r, err := timedomain.Transform(freq, s11, w)
+		if err != nil {
+			return result, err
+		}
+		result.Mode = r.Mode
+		result.Time = r.Time
+		result.S11 = &pocket.TimeResponse{Impulse: r.Impulse, Step: r.Step}
+	}
+
+	if sel.S21 {
+		r, err := timedomain.Transform(freq, s21, w)
+		if err != nil {
+			return result, err
+		}
+		result.Mode = r.Mode
+		result.Time = r.Time
+		result.S21 = &pocket.TimeResponse{Impulse: r.Impulse, Step: r.Step}
+	}
+
+	return result, nil
+}
+
+// func SetFixture stores a fixture to de-embed from subsequent calibrated results
+func (m *Middle) SetFixture(request *pocket.SetFixture) error {
+
+	if request.Port != 1 && request.Port != 2 {
+		return fmt.Errorf("port must be 1 or 2, not %d", request.Port)
+	}
+
+	data := request.Data
+
+	if request.S2P != "" {
+
+		if len(data) > 0 {
+			return errors.New("supply either s2p or data, not both")
+		}
+
+		d, err := touchstone.Parse(request.S2P)
+
+		if err != nil {
+			return fmt.Errorf("could not read s2p because %s", err.Error())
+		}
+
+		if d.Ports != 2 {
+			return errors.New("fixture must be a two-port (.s2p) file")
+		}
+
+		data = d.SParam
+
+		if d.Z0 != Z0 {
+			// bring the fixture to the same reference impedance as the calibration
+			for i, v := range data {
+				r, err := twoport.FromSParam(v).Renormalize(d.Z0, Z0)
+				if err != nil {
+					return fmt.Errorf("could not renormalize fixture to %g ohms because %s", Z0, err.Error())
+				}
+				data[i] = r.SParam(v.Freq)
+			}
+		}
+	}
+
+	if len(data) == 0 {
+		return errors.New("no fixture data supplied")
+	}
+
+	// check the fixture can be inverted, so we find out now rather than at the next measurement
+	for _, v := range data {
+		_, err := twoport.FromSParam(v).T()
+		if err != nil {
+			return fmt.Errorf("fixture cannot be de-embedded at %d Hz because %s", v.Freq, err.Error())
+		}
+	}
+
+	m.fixture[request.Port-1] = data
+	m.invalidate()
+
+	return nil
+}
+
+// func ClearFixture stops de-embedding the fixture on one port, or both if the port is zero
+func (m *Middle) ClearFixture(request *pocket.ClearFixture) error {
 
-func New(ctx context.Context, addr, port string, baud int, timeoutUSB, timeoutRequest time.Duration, topic string, v *pocket.VNA) Middle {
+	switch request.Port {
+	case 0:
+		m.fixture = [2][]pocket.SParam{}
+	case 1, 2:
+		m.fixture[request.Port-1] = nil
+	default:
+		return fmt.Errorf("port must be 0 (both), 1 or 2, not %d", request.Port)
+	}
 
-	// open the serial connection to the rf switch
-	r := rfusb.NewRFUSB()
-	r.Open(port, baud, timeoutUSB)
-	// r.Close() is in Run()
+	m.invalidate()
 
-	// create a new measure.Hardware using the rfswitch and VNA
-	// note that vna has it's own context (same parent as this context though)
-	h := measure.NewHardware(v, r)
+	return nil
+}
 
-	// open the gRPC connection to the calibration service
-	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+// func SetPortExtension sets the electrical delay to remove from one port of calibrated results
+func (m *Middle) SetPortExtension(request *pocket.PortExtension) error {
 
-	if err != nil {
-		log.Fatalf("did not connect to calibration gRPC service %s because %v", addr, err)
+	if request.Port != 1 && request.Port != 2 {
+		return fmt.Errorf("port must be 1 or 2, not %d", request.Port)
 	}
-	// conn.Close() is in Run()
 
-	c := pb.NewCalibrateClient(conn) //this doesn't need closing, apparently.
+	if request.Length != 0 {
 
-	// open the command/data stream to the user (via relay etc)
-	s := stream.New(ctx, topic)
+		if request.Delay != 0 {
+			return errors.New("supply either delay or length, not both")
+		}
 
-	ctpr := &pb.CalibrateTwoPortRequest{}
-	ctpr.Reset()
+		if request.Velocity == 0 {
+			request.Velocity = 1
+		}
 
-	return Middle{
-		c:       &c,
-		conn:    conn,
-		ctpr:    ctpr,
-		ctx:     ctx,
-		h:       h,
-		s:       &s,
-		timeout: timeoutRequest,
+		if request.Velocity < 0 || request.Velocity > 1 {
+			return fmt.Errorf("velocity factor must be between 0 and 1, not %g", request.Velocity)
+		}
+
+		request.Delay = request.Length / (request.Velocity * SpeedOfLight)
 	}
 
-}
+	if request.Port == 1 {
+		m.portext.Port1 = request.Delay
+	} else {
+		m.portext.Port2 = request.Delay
+	}
 
-func (m *Middle) Run() {
+	m.invalidate()
 
-	defer m.h.Switch.Close()
-	defer m.conn.Close()
+	return nil
+}
 
-	for {
+// func Extend applies port extension to calibrated results
+func Extend(s []pocket.SParam, e pocket.Extension) []pocket.SParam {
 
-		select {
+	x := make([]pocket.SParam, len(s))
 
-		case request := <-m.s.Request:
+	for i, v := range s {
+		x[i] = twoport.FromSParam(v).Extend(float64(v.Freq), e.Port1, e.Port2).SParam(v.Freq)
+	}
 
-			rctx, cancel := context.WithTimeout(m.ctx, m.timeout)
+	return x
+}
 
-			var response interface{}
+// func Disconnected returns the ports, 1 and 2, at which a calibrated result looks like
+// an open cable: all the power is reflected and none is transmitted, across the band
+func Disconnected(s []pocket.SParam) []int {
 
-			response, err := m.Handle(rctx, request)
+	if len(s) == 0 {
+		return nil
+	}
 
-			if err != nil {
-				response = pocket.CustomResult{
-					Message: err.Error(),
-					Command: request,
-				}
-			}
+	var n [2]int
 
-			m.s.Response <- response
+	for _, v := range s {
 
-			cancel()
+		through := math.Max(math.Hypot(v.S21.Real, v.S21.Imag), math.Hypot(v.S12.Real, v.S12.Imag)) <= DisconnectedTransmission
 
-		case <-m.ctx.Done():
-			return
+		if through && math.Hypot(v.S11.Real, v.S11.Imag) >= DisconnectedReflection {
+			n[0]++
 		}
 
-	} //for
+		if through && math.Hypot(v.S22.Real, v.S22.Imag) >= DisconnectedReflection {
+			n[1]++
+		}
+	}
+
+	var ports []int
 
+	for i, c := range n {
+		if float64(c) >= DisconnectedFraction*float64(len(s)) {
+			ports = append(ports, i+1)
+		}
+	}
+
+	return ports
 }
 
-func (m *Middle) Handle(ctx context.Context, request interface{}) (response interface{}, err error) {
+// func Renormalize changes the reference impedance of calibrated results from Z0 to z
+func Renormalize(s []pocket.SParam, z float64) ([]pocket.SParam, error) {
 
-	r := make(chan Response)
+	x := make([]pocket.SParam, len(s))
 
-	// now try the request
-	// any calls that hang will result in a leakage of the associated goro
-	// but hopefully small impact compared to whole system hanging
-	go func() {
+	for i, v := range s {
 
-		switch request.(type) {
+		r, err := twoport.FromSParam(v).Renormalize(Z0, z)
 
-		case pocket.ReasonableFrequencyRange:
+		if err != nil {
+			return nil, fmt.Errorf("renormalizing failed at %d Hz because %s", v.Freq, err.Error())
+		}
 
-			req := request.(pocket.ReasonableFrequencyRange)
-			err := m.h.ReasonableFrequencyRange(&req)
+		x[i] = r.SParam(v.Freq)
+	}
 
-			r <- Response{
-				Result: req,
-				Error:  err,
-			}
+	return x, nil
+}
 
-		// contains request for raw range query OR to do calibration
-		case pocket.RangeQuery:
+// func SwitchTerms finds the forward and reverse switch terms at each frequency
+// from raw measurements of the thru and at least two other reciprocal devices
+func SwitchTerms(thru []pocket.SParam, devices [][]pocket.SParam) ([][2]complex128, error) {
 
-			rq := request.(pocket.RangeQuery)
+	for _, d := range devices {
+		if len(d) != len(thru) {
+			return nil, errors.New("switch term measurements do not have the same number of points as the thru")
+		}
+	}
 
-			switch rq.Command.Command {
+	st := make([][2]complex128, len(thru))
 
-			case "rq", "rangequery":
+	for i, v := range thru {
 
-				req := request.(pocket.RangeQuery)
-				err := m.h.MeasureRange(&req)
-				r <- Response{
-					Result: req,
-					Error:  err,
-				}
+		m := []twoport.S{twoport.FromSParam(v)}
 
-			case "rc", "rangecal":
-				req := request.(pocket.RangeQuery)
-				err := m.CalibrateRange(&req)
-				r <- Response{
-					Result: req,
-					Error:  err,
-				}
+		for _, d := range devices {
+			m = append(m, twoport.FromSParam(d[i]))
+		}
 
-			}
+		gf, gr, err := twoport.SwitchTerms(m)
 
-		case pocket.CalibratedRangeQuery:
+		if err != nil {
+			return nil, fmt.Errorf("at %d Hz: %s", v.Freq, err.Error())
+		}
 
-			req := request.(pocket.CalibratedRangeQuery)
+		st[i] = [2]complex128{gf, gr}
+	}
 
-			err := m.MeasureRangeCalibrated(&req)
-			r <- Response{
-				Result: req,
-				Error:  err,
-			}
+	return st, nil
+}
 
-		}
-	}()
+// func Unterminate removes the switch terms from raw measurements, returning them unchanged if not in use
+func (m *Middle) Unterminate(s []pocket.SParam) []pocket.SParam {
 
-	select {
-	case response := <-r:
-		return response.Result, response.Error
-	case <-ctx.Done():
-		return nil, errors.New("timeout")
+	if m.switchTerms == nil || len(s) != len(m.switchTerms) {
+		return s
+	}
+
+	u := make([]pocket.SParam, len(s))
+
+	for i, v := range s {
+		u[i] = twoport.FromSParam(v).Unterminate(m.switchTerms[i][0], m.switchTerms[i][1]).SParam(v.Freq)
 	}
+
+	return u
 }
 
-// func MeasureRangeCalibrated measures and applies a calibration, returning calibrated results
-func (m *Middle) MeasureRangeCalibrated(request *pocket.CalibratedRangeQuery) error {
+// func Deembed removes any fixtures from calibrated results, returning them unchanged if there are none
+func (m *Middle) Deembed(s []pocket.SParam) ([]pocket.SParam, error) {
 
-	if m.rq == nil {
-		return errors.New("not calibrated yet")
+	if m.fixture[0] == nil && m.fixture[1] == nil {
+		return s, nil
 	}
 
-	// measure dut set by user
-	m.rq.What = request.What
+	freq := make([]uint64, len(s))
 
-	err := m.h.MeasureRange(m.rq)
+	for i, v := range s {
+		freq[i] = v.Freq
+	}
 
-	if err != nil {
-		return err
+	var fixture [2][]pocket.SParam
+
+	for i, f := range m.fixture {
+
+		if f == nil {
+			continue
+		}
+
+		r, err := twoport.Resample(f, freq)
+
+		if err != nil {
+			return nil, fmt.Errorf("port %d fixture does not cover the calibrated range because %s", i+1, err.Error())
+		}
+
+		fixture[i] = r
+	}
+
+	d := make([]pocket.SParam, len(s))
+
+	for i, v := range s {
+
+		a, b := twoport.Thru, twoport.Thru
+
+		if fixture[0] != nil {
+			a = twoport.FromSParam(fixture[0][i])
+		}
+
+		if fixture[1] != nil {
+			b = twoport.FromSParam(fixture[1][i])
+		}
+
+		dut, err := twoport.Deembed(twoport.FromSParam(v), a, b)
+
+		if err != nil {
+			return nil, fmt.Errorf("de-embedding failed at %d Hz because %s", v.Freq, err.Error())
+		}
+
+		d[i] = dut.SParam(v.Freq)
 	}
 
-	m.dut = m.rq.Result
+	return d, nil
+}
+
+// func SetPower changes the VNA output power used for subsequent measurements
+// an existing calibration is kept, but cannot be applied until the power matches it again
+func (m *Middle) SetPower(request *pocket.SetPower) error {
 
-	//reuse the other parts of the protocol buffer that are already there from the cal
-	m.ctpr.Dut = Meas2Cal(m.dut)
+	err := (*m.h.VNA).SetPower(request)
 
-	r, err := (*m.c).CalibrateTwoPort(m.ctx, m.ctpr)
 	if err != nil {
-		log.Fatalf("could not calibrate: %v", err)
+		return err
 	}
 
-	m.dutcal = Cal2Meas(r.GetFrequency(), r.GetResult())
+	m.power = request.Power
+	m.invalidate()
 
-	request.Result = m.dutcal
+	if m.rq != nil {
+		switch {
+		case m.calState.State() == calstate.Calibrated && m.rq.Power != m.power:
+			m.transition(calstate.Invalidate, fmt.Sprintf("output power changed to %g dBm from %g dBm", m.power, m.rq.Power))
+		case m.calState.State() == calstate.Stale && m.rq.Power == m.power:
+			m.transition(calstate.Restore, fmt.Sprintf("output power set back to %g dBm", m.power))
+		}
+	}
 
 	return nil
-
 }
 
 // func CalibrateRange performs the calibration measurements
-func (m *Middle) CalibrateRange(request *pocket.RangeQuery) error {
+func (m *Middle) CalibrateRange(ctx context.Context, request *pocket.RangeQuery) (err error) {
 
 	// store frequency range, size, LogDistribution
 	// Measure & save SOLT for all S-params
@@ -234,9 +4633,48 @@ func (m *Middle) CalibrateRange(request *pocket.RangeQuery) error {
 
 	request.What = "thru" //we'll force the return of the thru results for simplicity
 
+	if request.Power == 0 {
+		request.Power = m.power
+	}
+
+	if request.Power != m.power {
+		// calibrated measurements use the power stored with the cal, so keep them in step
+		return fmt.Errorf("calibration requested at %g dBm but output power is %g dBm, so use setpower first", request.Power, m.power)
+	}
+
+	// check before the current cal is replaced
+	err = m.usePreset(request)
+
+	if err != nil {
+		return err
+	}
+
+	err = m.clamp(request)
+
+	if err != nil {
+		return err
+	}
+
+	request.Warning, err = m.checkWarm()
+
+	if err != nil {
+		return err
+	}
+
 	rq := *request //make a local copy of the request to break the link to the original request
 	// so it's not changed by future requests coming in
 	m.rq = &rq
+	m.uncalibrated = nil
+	m.resume = nil
+	m.invalidate()
+	m.transition(calstate.Start, "")
+
+	// the previous cal has been replaced, so none can be used until this one is complete
+	defer func() {
+		if err != nil {
+			m.stopCal(err)
+		}
+	}()
 
 	// we need to measure all Sparams, so ignore user's select settings
 	m.rq.Select = pocket.SParamSelect{
@@ -246,150 +4684,331 @@ func (m *Middle) CalibrateRange(request *pocket.RangeQuery) error {
 		S22: true,
 	}
 
-	// measure cal standards
+	m.switchNames = nil
+	m.switchDevices = nil
 
-	//short
-	m.rq.What = "short"
-	err := m.h.MeasureRange(m.rq)
+	err = m.finishCal(ctx, m.standards())
 
 	if err != nil {
 		return err
 	}
 
-	m.short = m.rq.Result
+	m.transition(calstate.Complete, "")
+
+	request.Result = m.dutcal
+	request.Meta = m.meta(len(m.dut))
+
+	return nil
+
+}
+
+// indexOf returns the index of s in list, or -1 if it is not there
+func indexOf(list []string, s string) int {
+
+	for i, l := range list {
+		if l == s {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// func standards returns the positions measured for a cal, in order: the short, open,
+// load and thru, then any devices for the switch terms
+func (m *Middle) standards() []string {
+	return append([]string{"short", "open", "load", "thru"}, m.switchStd...)
+}
+
+// func finishCal measures the standards in todo, in order, with the settings of the
+// cal in m.rq, then makes the cal from them and those measured before
+func (m *Middle) finishCal(ctx context.Context, todo []string) error {
+
+	for i, w := range todo {
 
-	// open
-	m.rq.What = "open"
-	err = m.h.MeasureRange(m.rq)
+		next := ""
+
+		if i+1 < len(todo) {
+			next = todo[i+1]
+		}
+
+		m.rq.What = w
+		err := m.measureThen(ctx, m.rq, next)
+
+		if err != nil {
+			return err
+		}
+
+		switch w {
+		case "short":
+			m.short = m.rq.Result
+		case "open":
+			m.open = m.rq.Result
+		case "load":
+			m.load = m.rq.Result
+		case "thru":
+			m.thru = m.rq.Result
+		default:
+			// extra reciprocal devices for the switch terms, in place of any measured before
+			if i := indexOf(m.switchNames, w); i >= 0 {
+				m.switchDevices[i] = m.rq.Result
+			} else {
+				m.switchNames = append(m.switchNames, w)
+				m.switchDevices = append(m.switchDevices, m.rq.Result)
+			}
+		}
+
+		m.transition(calstate.Measure, w)
+	}
+
+	err := m.solve(ctx)
 
 	if err != nil {
+		// raw results can be measured at the same points until the service is back
+		if errors.Is(err, calibration.ErrUnavailable) {
+			sweep := *m.rq
+			m.uncalibrated = &sweep
+		}
 		return err
 	}
 
-	m.open = m.rq.Result
+	return nil
+}
+
+// func stopCal drops the cal being made, because of err. The standards measured
+// so far are kept, with the settings, so that resumecal can carry on from the one
+// that failed, unless none were measured, when there is nothing to resume.
+func (m *Middle) stopCal(err error) {
+
+	m.terms = nil
+	m.std = nil
+
+	if m.calState.State() == calstate.PartiallyMeasured {
+		m.resume = m.rq
+		m.rq = nil
+		m.transition(calstate.Interrupt, err.Error())
+		return
+	}
+
+	m.rq = nil
+	m.resume = nil
+	m.transition(calstate.Fail, err.Error())
+}
+
+// func ResumeCal carries on an rc that was interrupted, e.g. by a loose thru, from the
+// standard that failed, keeping those that had been measured, and makes the cal, with
+// the settings of the rc. Any standards in redo are measured again first, e.g. one that
+// the cal was rejected for. If it is interrupted again, it can be resumed again.
+func (m *Middle) ResumeCal(ctx context.Context, request *pocket.ResumeCal) (err error) {
+
+	if m.calState.State() != calstate.Interrupted || m.resume == nil {
+		return ErrNotInterrupted
+	}
+
+	measured := m.calState.Measured()
+
+	for _, w := range request.Redo {
+		if indexOf(measured, w) < 0 {
+			return invalid(fmt.Errorf("redo must be standards that have been measured, %s, not %s", strings.Join(measured, ", "), w))
+		}
+	}
+
+	if m.resume.Power != m.power {
+		return fmt.Errorf("the rc was at %g dBm but output power is now %g dBm, so use setpower, or start again with rc", m.resume.Power, m.power)
+	}
 
-	// load
-	m.rq.What = "load"
-	err = m.h.MeasureRange(m.rq)
+	request.Warning, err = m.checkWarm()
 
 	if err != nil {
 		return err
 	}
 
-	m.load = m.rq.Result
+	m.rq = m.resume
+	m.resume = nil
+	m.uncalibrated = nil
+	m.transition(calstate.Resume, "")
+
+	defer func() {
+		if err != nil {
+			m.stopCal(err)
+		}
+	}()
+
+	before := len(m.calState.Measured())
 
-	// thru
-	m.rq.What = "thru"
-	err = m.h.MeasureRange(m.rq)
+	err = m.finishCal(ctx, append(append([]string{}, request.Redo...), m.calState.Remaining(m.standards())...))
+
+	request.Measured = m.calState.Measured()[before:]
 
 	if err != nil {
 		return err
 	}
 
-	m.thru = m.rq.Result
+	m.transition(calstate.Complete, "")
+
+	request.Result = m.dutcal
+	request.Meta = m.meta(len(m.dut))
+
+	return nil
+}
+
+// func solve makes the cal from the standards that have been measured, finding
+// the switch terms first if there are devices for them, and keeps it for crq
+func (m *Middle) solve(ctx context.Context) error {
+
+	m.switchTerms = nil
+
+	if len(m.switchDevices) > 0 {
+
+		st, err := SwitchTerms(m.thru, m.switchDevices)
+
+		if err != nil {
+			return err
+		}
+
+		m.switchTerms = st
+	}
 
 	// Use the thru for the DUT for the purpose of this cal
 	m.dut = m.thru
 
-	// Prepare the cal buffer...
-	m.ctpr.Reset()
+	std := &calibration.Standards{
+		Short: m.Unterminate(m.short),
+		Open:  m.Unterminate(m.open),
+		Load:  m.Unterminate(m.load),
+		Thru:  m.Unterminate(m.thru),
+	}
+
+	freq := calibration.Freq(m.short)
+
+	var err error
 
-	m.ctpr.Frequency = Meas2Freq(m.short)
+	if m.kit != nil {
+
+		std.IdealShort, std.IdealOpen, std.IdealLoad, std.IdealThru, err = m.kit.Ideals(freq)
+
+		if err != nil {
+			return fmt.Errorf("could not use cal kit %s because %s", m.kit.Name, err.Error())
+		}
+	}
 
-	m.ctpr.Short = Meas2Cal(m.short)
-	m.ctpr.Open = Meas2Cal(m.open)
-	m.ctpr.Load = Meas2Cal(m.load)
-	m.ctpr.Thru = Meas2Cal(m.thru)
-	m.ctpr.Dut = Meas2Cal(m.dut)
+	// a standard that is missing, or the wrong one, would give a cal that looks fine but is not
+	err = std.Verify()
 
-	r, err := (*m.c).CalibrateTwoPort(m.ctx, m.ctpr)
 	if err != nil {
-		log.Fatalf("could not calibrate: %v", err)
+		return fmt.Errorf("calibration stopped because %s", err.Error())
 	}
 
-	m.dutcal = Cal2Meas(r.GetFrequency(), r.GetResult())
+	r, err := m.calibrate(ctx, freq, std, m.Unterminate(m.dut))
 
-	request.Result = m.dutcal
+	if err != nil {
+		return fmt.Errorf("could not calibrate because %w", err)
+	}
 
-	return nil
+	// kept for crq, along with the error terms so it can correct the DUT without another call
+	m.std = std
+	m.dutcal = r.DUT
+	m.terms = r.Terms
+	m.calAt = time.Now()
 
+	return nil
 }
 
-func Meas2Freq(s []pocket.SParam) []float64 {
-	freq := []float64{}
+// func MeasureCal measures one standard of the current cal again, at the same
+// settings, e.g. the load after finding it was not connected properly, and makes
+// the cal again with it in place of the old measurement, without measuring the
+// others. The switch terms are found again if the thru or one of their devices
+// is measured. If the cal cannot be made, the previous one is kept, so it is safe
+// to repeat. Result is the new measurement of the standard, uncorrected.
+func (m *Middle) MeasureCal(ctx context.Context, request *pocket.MeasureCal) error {
 
-	for _, v := range s {
-		freq = append(freq, float64(v.Freq))
+	if s := m.calState.State(); m.rq == nil || s != calstate.Calibrated {
+		return fmt.Errorf("a standard can only be measured again when calibrated, not %s", s)
 	}
 
-	return freq
-}
+	warning, err := m.checkWarm()
+
+	if err != nil {
+		return err
+	}
 
-func Meas2Cal(s []pocket.SParam) *pb.SParams {
+	request.Warning = warning
 
-	var s11, s12, s21, s22 []*pb.Complex
+	standards := map[string]*[]pocket.SParam{
+		"short": &m.short,
+		"open":  &m.open,
+		"load":  &m.load,
+		"thru":  &m.thru,
+	}
 
-	for _, v := range s {
-		s11 = append(s11, &pb.Complex{
-			Real: v.S11.Real,
-			Imag: v.S11.Imag,
-		})
-		s12 = append(s12, &pb.Complex{
-			Real: v.S12.Real,
-			Imag: v.S12.Imag,
-		})
-		s21 = append(s21, &pb.Complex{
-			Real: v.S21.Real,
-			Imag: v.S21.Imag,
-		})
-		s22 = append(s22, &pb.Complex{
-			Real: v.S22.Real,
-			Imag: v.S22.Imag,
-		})
+	for i, w := range m.switchNames {
+		standards[w] = &m.switchDevices[i]
+	}
+
+	target, ok := standards[request.What]
+
+	if !ok {
+		names := append([]string{"short", "open", "load", "thru"}, m.switchNames...)
+		return fmt.Errorf("what must be one of %s, not %s", strings.Join(names, ", "), request.What)
+	}
+
+	rq := *m.rq
+	rq.What = request.What
+	rq.Result = nil
+
+	err = m.measureThen(ctx, &rq, "")
 
+	if err != nil {
+		return err
 	}
 
-	return &pb.SParams{
-		S11: s11,
-		S12: s12,
-		S21: s21,
-		S22: s22,
+	// put back if the cal cannot be made with the new measurement
+	old := *target
+	dut, switchTerms, std, dutcal, terms, calAt := m.dut, m.switchTerms, m.std, m.dutcal, m.terms, m.calAt
+
+	*target = rq.Result
+
+	err = m.solve(ctx)
+
+	if err != nil {
+		*target = old
+		m.dut, m.switchTerms, m.std, m.dutcal, m.terms, m.calAt = dut, switchTerms, std, dutcal, terms, calAt
+		return err
 	}
 
+	m.invalidate()
+	m.transition(calstate.Remeasure, request.What)
+
+	request.Result = rq.Result
+
+	return nil
 }
 
-func Cal2Meas(f []float64, s *pb.SParams) []pocket.SParam {
+// func Correct removes the switch terms (if in use) and then the error terms of
+// the current cal from the raw measurement s, point by point, so there is no
+// intermediate copy of the whole sweep
+func (m *Middle) Correct(s []pocket.SParam) ([]pocket.SParam, error) {
 
-	var ps []pocket.SParam
+	if len(s) != len(m.terms) {
+		return nil, fmt.Errorf("measurement has %d points but the calibration has %d", len(s), len(m.terms))
+	}
 
-	for i := range s.S11 {
+	unterminate := m.switchTerms != nil && len(m.switchTerms) == len(s)
 
-		p := pocket.SParam{
-			Freq: uint64(f[i]),
-			S11: pocket.Complex{
-				Real: s.S11[i].Real,
-				Imag: s.S11[i].Imag,
-			},
-			S12: pocket.Complex{
-				Real: s.S12[i].Real,
-				Imag: s.S12[i].Imag,
-			},
-			S21: pocket.Complex{
-				Real: s.S21[i].Real,
-				Imag: s.S21[i].Imag,
-			},
-			S22: pocket.Complex{
-				Real: s.S22[i].Real,
-				Imag: s.S22[i].Imag,
-			},
-		}
+	c := make([]pocket.SParam, len(s))
 
-		ps = append(ps, p)
+	for i, p := range s {
 
-	}
+		raw := twoport.FromSParam(p)
+
+		if unterminate {
+			raw = raw.Unterminate(m.switchTerms[i][0], m.switchTerms[i][1])
+		}
 
-	return ps
+		c[i] = m.terms[i].Correct(raw).SParam(p.Freq)
+	}
 
+	return c, nil
 }
 
 /*
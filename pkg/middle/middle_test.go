@@ -1,26 +1,53 @@
 package middle
 
 import (
+	"archive/zip"
 	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"math"
+	"math/cmplx"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/practable/pocket-vna-two-port/pkg/audit"
+	"github.com/practable/pocket-vna-two-port/pkg/calibration"
+	"github.com/practable/pocket-vna-two-port/pkg/calstate"
+	"github.com/practable/pocket-vna-two-port/pkg/config"
 	"github.com/practable/pocket-vna-two-port/pkg/drain"
+	"github.com/practable/pocket-vna-two-port/pkg/fleet"
+	"github.com/practable/pocket-vna-two-port/pkg/history"
+	"github.com/practable/pocket-vna-two-port/pkg/logring"
+	"github.com/practable/pocket-vna-two-port/pkg/measure"
+	"github.com/practable/pocket-vna-two-port/pkg/pathloss"
+	"github.com/practable/pocket-vna-two-port/pkg/pb"
 	"github.com/practable/pocket-vna-two-port/pkg/pocket"
 	"github.com/practable/pocket-vna-two-port/pkg/reconws"
+	"github.com/practable/pocket-vna-two-port/pkg/rfusb"
+	"github.com/practable/pocket-vna-two-port/pkg/schema"
+	"github.com/practable/pocket-vna-two-port/pkg/store"
 	"github.com/practable/pocket-vna-two-port/pkg/stream"
+	"github.com/practable/pocket-vna-two-port/pkg/timedomain"
+	"github.com/practable/pocket-vna-two-port/pkg/touchstone"
+	"github.com/practable/pocket-vna-two-port/pkg/twoport"
+	"github.com/practable/pocket-vna-two-port/pkg/wear"
+	"github.com/practable/pocket-vna-two-port/pkg/webhook"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
 )
 
 var verbose bool
@@ -156,6 +183,3417 @@ func TestFakeMiddle(t *testing.T) {
 	time.Sleep(1000 * time.Millisecond)
 }
 
+func TestTimeDomain(t *testing.T) {
+
+	// matched load at dc, short at the far end of a 2ns line
+	var s []pocket.SParam
+
+	for i := 1; i <= 200; i++ {
+		f := float64(i) * 10e6
+		p := -2 * math.Pi * f * 2e-9
+		s = append(s, pocket.SParam{
+			Freq: uint64(f),
+			S11:  pocket.Complex{Real: -math.Cos(p), Imag: -math.Sin(p)},
+			S21:  pocket.Complex{Real: math.Cos(p / 2), Imag: math.Sin(p / 2)},
+		})
+	}
+
+	r, err := TimeDomain(s, pocket.SParamSelect{}, timedomain.Window{Name: "hann"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "lowpass", r.Mode)
+	assert.NotNil(t, r.S11)
+	assert.NotNil(t, r.S21)
+	assert.Equal(t, len(r.Time), len(r.S11.Impulse))
+
+	r, err = TimeDomain(s, pocket.SParamSelect{S21: true}, timedomain.Window{Name: "hann"})
+
+	assert.NoError(t, err)
+	assert.Nil(t, r.S11)
+	assert.NotNil(t, r.S21)
+
+	_, err = TimeDomain(s, pocket.SParamSelect{}, timedomain.Window{Name: "square"})
+
+	assert.Error(t, err)
+}
+
+func TestFixture(t *testing.T) {
+
+	m := Middle{}
+
+	meas := []pocket.SParam{
+		{Freq: 100e6, S11: pocket.Complex{Real: 0.1}, S12: pocket.Complex{Real: 0.25}, S21: pocket.Complex{Real: 0.25}, S22: pocket.Complex{Real: 0.1}},
+		{Freq: 200e6, S11: pocket.Complex{Real: 0.1}, S12: pocket.Complex{Real: 0.25}, S21: pocket.Complex{Real: 0.25}, S22: pocket.Complex{Real: 0.1}},
+	}
+
+	// nothing to de-embed
+	d, err := m.Deembed(meas)
+	assert.NoError(t, err)
+	assert.Equal(t, meas, d)
+
+	// matched 6dB attenuator on port 1
+	s2p := "# MHz S RI R 50\n50 0 0 0.5 0 0.5 0 0 0\n250 0 0 0.5 0 0.5 0 0 0\n"
+
+	err = m.SetFixture(&pocket.SetFixture{Port: 1, S2P: s2p})
+	assert.NoError(t, err)
+
+	d, err = m.Deembed(meas)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0.5, d[0].S21.Real, 1e-9)
+	assert.InDelta(t, 0.4, d[1].S11.Real, 1e-9)
+	assert.InDelta(t, 0.1, d[1].S22.Real, 1e-9)
+
+	// fixture must cover the measurement
+	err = m.SetFixture(&pocket.SetFixture{Port: 2, Data: meas[:1]})
+	assert.NoError(t, err)
+	_, err = m.Deembed(meas)
+	assert.Error(t, err)
+
+	err = m.ClearFixture(&pocket.ClearFixture{})
+	assert.NoError(t, err)
+	d, err = m.Deembed(meas)
+	assert.NoError(t, err)
+	assert.Equal(t, meas, d)
+
+	err = m.SetFixture(&pocket.SetFixture{Port: 3, S2P: s2p})
+	assert.Error(t, err)
+
+	err = m.SetFixture(&pocket.SetFixture{Port: 1})
+	assert.Error(t, err)
+
+	// 75 ohm fixture data is renormalized to 50 ohms
+	// (a matched 75 ohm attenuator is mismatched at 50 ohms)
+	err = m.SetFixture(&pocket.SetFixture{Port: 1, S2P: "# MHz S RI R 75\n50 0 0 0.5 0 0.5 0 0 0\n"})
+	assert.NoError(t, err)
+	assert.True(t, math.Abs(m.fixture[0][0].S11.Real) > 0.1)
+
+	err = m.ClearFixture(&pocket.ClearFixture{Port: 4})
+	assert.Error(t, err)
+}
+
+func TestPortExtension(t *testing.T) {
+
+	m := Middle{}
+
+	err := m.SetPortExtension(&pocket.PortExtension{Port: 1, Delay: 1e-9})
+	assert.NoError(t, err)
+
+	req := pocket.PortExtension{Port: 2, Length: 0.2, Velocity: 0.66}
+	err = m.SetPortExtension(&req)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1.0108e-9, req.Delay, 1e-12)
+	assert.Equal(t, req.Delay, m.portext.Port2)
+
+	// an open at the end of a 1ns line looks like an open once extended
+	p := -2 * math.Pi * 250e6 * 2e-9
+	s := []pocket.SParam{{Freq: 250e6, S11: pocket.Complex{Real: math.Cos(p), Imag: math.Sin(p)}}}
+
+	x := Extend(s, pocket.Extension{Port1: 1e-9})
+	assert.InDelta(t, 1, x[0].S11.Real, 1e-9)
+	assert.InDelta(t, 0, x[0].S11.Imag, 1e-9)
+
+	err = m.SetPortExtension(&pocket.PortExtension{Port: 1, Delay: 1e-9, Length: 0.1})
+	assert.Error(t, err)
+
+	err = m.SetPortExtension(&pocket.PortExtension{Port: 1, Length: 0.1, Velocity: 1.5})
+	assert.Error(t, err)
+
+	err = m.SetPortExtension(&pocket.PortExtension{Port: 0, Delay: 1e-9})
+	assert.Error(t, err)
+}
+
+func TestRenormalize(t *testing.T) {
+
+	// 75 ohm terminations on both ports look matched in a 75 ohm system
+	g := (75.0 - 50) / (75 + 50)
+	s := []pocket.SParam{{Freq: 100e6, S11: pocket.Complex{Real: g}, S22: pocket.Complex{Real: g}}}
+
+	r, err := Renormalize(s, 75)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0, r[0].S11.Real, 1e-9)
+	assert.InDelta(t, 0, r[0].S22.Real, 1e-9)
+	assert.Equal(t, uint64(100e6), r[0].Freq)
+
+	m := Middle{}
+	err = m.MeasureRangeCalibrated(context.Background(), &pocket.CalibratedRangeQuery{Z0: -50})
+	assert.Error(t, err)
+}
+
+func TestSwitchTerms(t *testing.T) {
+
+	gf := 0.2 + 0.1i
+	gr := -0.15 + 0.05i
+
+	devices := []twoport.S{
+		twoport.Thru,
+		{{0.2 - 0.1i, 0.6 + 0.3i}, {0.6 + 0.3i, 0.1 + 0.1i}},
+		{{-0.4, 0.3 - 0.5i}, {0.3 - 0.5i, 0.25i}},
+	}
+
+	var raw [][]pocket.SParam
+
+	for _, d := range devices {
+		raw = append(raw, []pocket.SParam{d.Terminate(gf, gr).SParam(100e6)})
+	}
+
+	st, err := SwitchTerms(raw[0], raw[1:])
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(st))
+	assert.InDelta(t, 0, cmplx.Abs(st[0][0]-gf), 1e-9)
+	assert.InDelta(t, 0, cmplx.Abs(st[0][1]-gr), 1e-9)
+
+	m := Middle{switchTerms: st}
+	u := m.Unterminate(raw[1])
+	assert.InDelta(t, 0.6, u[0].S21.Real, 1e-9)
+	assert.InDelta(t, 0.3, u[0].S12.Imag, 1e-9)
+
+	// unchanged when not in use
+	m = Middle{}
+	assert.Equal(t, raw[1], m.Unterminate(raw[1]))
+
+	_, err = SwitchTerms(raw[0], [][]pocket.SParam{raw[1], {}})
+	assert.Error(t, err)
+
+	assert.Error(t, m.SetSwitchStandards([]string{"dut1"}))
+	assert.Error(t, m.SetSwitchStandards([]string{"dut1", "thru"}))
+	assert.Error(t, m.SetSwitchStandards([]string{"dut1", "DUT1"}))
+	assert.NoError(t, m.SetSwitchStandards([]string{"dut1", "dut2"}))
+	assert.NoError(t, m.SetSwitchStandards(nil))
+}
+
+func TestMeasureRangeSweeps(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6, S21: pocket.Complex{Real: 0.5}}}
+
+	var v pocket.VNA = mock
+
+	// the range of the VNA is already known, so only sweeps are counted
+	m := Middle{h: measure.NewHardware(&v, rfusb.NewMock()), device: &pocket.Range{}}
+
+	rq := pocket.RangeQuery{What: "dut1", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Sweeps: 3, Reject: "median"}
+
+	err := m.MeasureRange(context.Background(), &rq)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(mock.CommandsReceived))
+	assert.Equal(t, 0.5, rq.Result[0].S21.Real)
+	assert.Equal(t, 1, len(rq.StdDev))
+	assert.Equal(t, 0.0, rq.StdDev[0].S21)
+
+	rq = pocket.RangeQuery{What: "dut1", Reject: "mean"}
+	err = m.MeasureRange(context.Background(), &rq)
+	assert.Error(t, err)
+
+	rq = pocket.RangeQuery{What: "dut1", Frequencies: []uint64{200e6, 100e6}}
+	err = m.MeasureRange(context.Background(), &rq)
+	assert.Error(t, err)
+
+	// segments are measured one after the other, with their own averaging
+	mock.CommandsReceived = nil
+
+	rq = pocket.RangeQuery{What: "dut1", Avg: 1, Segments: []pocket.Segment{
+		{Start: 1e6, End: 10e6, Size: 2},
+		{Start: 20e6, End: 30e6, Size: 2, Avg: 8, LogDistribution: true},
+	}}
+	err = m.MeasureRange(context.Background(), &rq)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(rq.Result))
+	assert.Equal(t, 2, len(mock.CommandsReceived))
+	first := mock.CommandsReceived[0].(pocket.RangeQuery)
+	second := mock.CommandsReceived[1].(pocket.RangeQuery)
+	assert.Equal(t, uint16(1), first.Avg)
+	assert.Equal(t, pocket.Range{Start: 20e6, End: 30e6}, second.Range)
+	assert.Equal(t, uint16(8), second.Avg)
+	assert.True(t, second.LogDistribution)
+
+	rq.Frequencies = []uint64{1e6}
+	err = m.MeasureRange(context.Background(), &rq)
+	assert.Error(t, err)
+
+	err = m.MeasureRangeCalibrated(context.Background(), &pocket.CalibratedRangeQuery{Sweeps: -1})
+	assert.Error(t, err)
+}
+
+func TestNoiseFloor(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6, S11: pocket.Complex{Real: 0.01}}}
+
+	var v pocket.VNA = mock
+
+	sw := rfusb.NewMock()
+
+	m := Middle{h: measure.NewHardware(&v, sw)}
+
+	nf := pocket.NoiseFloor{Size: 1}
+
+	err := m.MeasureNoiseFloor(context.Background(), &nf)
+	assert.NoError(t, err)
+	assert.Equal(t, NoiseSweeps, len(mock.CommandsReceived))
+	assert.Equal(t, "load", sw.Get())
+	assert.Equal(t, 1, len(nf.Result))
+	assert.InDelta(t, -40, nf.Result[0].S11.Mean, 1e-9)
+	assert.InDelta(t, 0, nf.Result[0].S11.Phase, 1e-9)
+
+	nf = pocket.NoiseFloor{Sweeps: 1}
+	assert.Error(t, m.MeasureNoiseFloor(context.Background(), &nf))
+}
+
+func TestHold(t *testing.T) {
+
+	sweep := func(s21 float64) []pocket.SParam {
+		return []pocket.SParam{
+			{Freq: 100e6, S11: pocket.Complex{Real: 0.1}, S21: pocket.Complex{Imag: s21}},
+			{Freq: 200e6, S11: pocket.Complex{Real: 0.1}, S21: pocket.Complex{Imag: -s21}},
+		}
+	}
+
+	h := pocket.Hold{}
+
+	assert.NoError(t, Accumulate(&h, sweep(0.1)))
+	assert.NoError(t, Accumulate(&h, sweep(1)))
+	assert.NoError(t, Accumulate(&h, sweep(0.01)))
+
+	assert.Equal(t, 3, h.Count)
+	assert.Equal(t, 2, len(h.Max))
+	assert.InDelta(t, 0, h.Max[1].S21.Mag, 1e-9)
+	assert.InDelta(t, -40, h.Min[1].S21.Mag, 1e-9)
+	assert.InDelta(t, -20, h.Max[0].S11.Mag, 1e-9)
+	assert.InDelta(t, -20, h.Min[0].S11.Mag, 1e-9)
+	assert.Equal(t, 0.0, h.Max[0].S21.Phase)
+
+	// a new frequency range starts again
+	assert.NoError(t, Accumulate(&h, sweep(0.1)[:1]))
+	assert.Equal(t, 1, h.Count)
+	assert.InDelta(t, -20, h.Max[0].S21.Mag, 1e-9)
+
+	m := Middle{}
+
+	req := pocket.Hold{Command: pocket.Command{Command: "hs"}, What: "dut1"}
+	assert.NoError(t, m.Hold(&req))
+	assert.True(t, req.Active)
+	assert.Equal(t, "hs", req.Command.Command)
+
+	m.hold.Count = 7
+
+	req = pocket.Hold{Command: pocket.Command{Command: "holdquery"}}
+	assert.NoError(t, m.Hold(&req))
+	assert.Equal(t, 7, req.Count)
+	assert.Equal(t, "dut1", req.What)
+
+	req = pocket.Hold{Command: pocket.Command{Command: "hr"}}
+	assert.NoError(t, m.Hold(&req))
+	assert.False(t, req.Active)
+	assert.Equal(t, 0, m.hold.Count)
+
+	req = pocket.Hold{Command: pocket.Command{Command: "hx"}}
+	assert.Error(t, m.Hold(&req))
+}
+
+func TestSweep(t *testing.T) {
+
+	m := Middle{timeout: time.Second}
+
+	start := pocket.Sweep{Interval: 0.5}
+	start.Command = pocket.Command{ID: "s0", Command: "startsweep"}
+
+	err := m.SetSweep(&start)
+	assert.Error(t, err) // not calibrated
+	assert.Nil(t, m.sweep)
+
+	m.rq = &pocket.RangeQuery{Power: -10}
+
+	bad := start
+	bad.Interval = -1
+	assert.Error(t, m.SetSweep(&bad))
+
+	bad = start
+	bad.Format = "smith"
+	assert.Error(t, m.SetSweep(&bad))
+
+	start.What = "dut1"
+	assert.NoError(t, m.SetSweep(&start))
+	assert.Equal(t, "dut1", m.sweep.What)
+
+	// the power has changed since the cal, so the sweep fails, and stops
+	response := m.SweepOnce(context.Background())
+	cr, ok := response.(pocket.CustomResult)
+	assert.True(t, ok)
+	assert.True(t, strings.HasPrefix(cr.Message, "continuous sweep stopped"))
+	assert.Equal(t, "s0", cr.Command.(pocket.CalibratedRangeQuery).Command.ID)
+	assert.Equal(t, "sweep", cr.Command.(pocket.CalibratedRangeQuery).Command.Command)
+	assert.Nil(t, m.sweep)
+
+	assert.NoError(t, m.SetSweep(&start))
+
+	stop := pocket.Sweep{}
+	stop.Command = pocket.Command{Command: "stopsweep"}
+	assert.NoError(t, m.SetSweep(&stop))
+	assert.Nil(t, m.sweep)
+
+	stop.Command.Command = "pausesweep"
+	assert.Error(t, m.SetSweep(&stop))
+}
+
+func TestStability(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 150e6}, {Freq: 200e6}}
+
+	for i := range mock.ResultRangeQuery {
+		mock.ResultRangeQuery[i].S11 = pocket.Complex{Real: 0.1}
+		mock.ResultRangeQuery[i].S21 = pocket.Complex{Real: 1}
+	}
+
+	var v pocket.VNA = mock
+
+	m := Middle{timeout: time.Second, h: measure.NewHardware(&v, rfusb.NewMock())}
+
+	st, err := store.NewDir(t.TempDir())
+	assert.NoError(t, err)
+	m.SetStore(st)
+
+	start := pocket.Stability{Every: 30, Hours: 1}
+	start.Command = pocket.Command{ID: "drift", Command: "stability"}
+	start.What = "load"
+	start.Range = pocket.Range{Start: 100e6, End: 200e6}
+	start.Size = 3
+	start.Select = pocket.SParamSelect{S11: true, S21: true}
+
+	// nothing to stop or query yet
+	assert.ErrorIs(t, m.Stability(&pocket.Stability{Stop: true}), ErrNoExperiment)
+	assert.ErrorIs(t, m.Stability(&pocket.Stability{Query: true}), ErrNoExperiment)
+
+	for _, f := range []func(s *pocket.Stability){
+		func(s *pocket.Stability) { s.Every = 0 },
+		func(s *pocket.Stability) { s.Hours = 0 },
+		func(s *pocket.Stability) { s.Hours = MaxStabilityHours + 1 },
+		func(s *pocket.Stability) { s.Every = 61 },
+		func(s *pocket.Stability) { s.Hours, s.Every, s.Size = MaxStabilityHours, 1, 1000 },
+		func(s *pocket.Stability) { s.What = "dut9" },
+		func(s *pocket.Stability) { s.Select = pocket.SParamSelect{} },
+	} {
+		bad := start
+		f(&bad)
+		assert.Error(t, m.Stability(&bad))
+		assert.Nil(t, m.experiment)
+	}
+
+	assert.NoError(t, m.Stability(&start))
+	assert.NotNil(t, m.experiment)
+	assert.Equal(t, "datalog/stability-"+start.Summary.Start.UTC().Format("20060102T150405Z")+".jsonl", start.Summary.Logged)
+	assert.Equal(t, 0, len(start.Summary.Times))
+
+	again := start
+	assert.ErrorIs(t, m.Stability(&again), ErrExperimentRunning)
+
+	// nothing is sent until it is done
+	assert.Nil(t, m.StabilityOnce(context.Background()))
+	assert.True(t, m.experiment.next.After(time.Now().Add(29*time.Minute)))
+
+	query := pocket.Stability{Query: true}
+	assert.NoError(t, m.Stability(&query))
+	assert.Equal(t, 1, len(query.Summary.Times))
+	assert.False(t, query.Summary.Done)
+	assert.Equal(t, 3, len(query.Summary.Drift))
+	assert.Equal(t, []pocket.Value{{}}, query.Summary.Drift[0].S11)
+
+	// the rest are due by the end
+	m.experiment.end = time.Now()
+
+	mock.ResultRangeQuery = append([]pocket.SParam{}, mock.ResultRangeQuery...)
+	mock.ResultRangeQuery[2].S21 = pocket.Complex{Imag: 0.5}
+
+	s, ok := m.StabilityOnce(context.Background()).(pocket.Stability)
+	assert.True(t, ok)
+	assert.Equal(t, "drift", s.ID)
+	assert.True(t, s.Summary.Done)
+	assert.Equal(t, 0, s.Summary.Failed)
+	assert.Equal(t, 2, len(s.Summary.Times))
+	assert.Equal(t, uint64(100e6), s.Summary.Drift[0].Freq)
+	assert.Equal(t, 2, len(s.Summary.Drift[2].S21))
+	assert.InDelta(t, -6.02, s.Summary.Drift[2].S21[1].Mag, 0.01)
+	assert.InDelta(t, 90, s.Summary.Drift[2].S21[1].Phase, 1e-9)
+	assert.InDelta(t, -6.02, s.Summary.Drift[2].Max.S21.Mag, 0.01)
+	assert.Equal(t, pocket.Value{}, s.Summary.Drift[1].Max.S21)
+	assert.Equal(t, pocket.Value{}, s.Summary.Drift[2].Max.S12)
+	assert.Nil(t, m.experiment)
+
+	// the query is not changed by the measurements made after it
+	assert.Equal(t, 1, len(query.Summary.Drift[0].S11))
+
+	b, err := st.Get(start.Summary.Logged)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(b), "\n"))
+
+	// stopped early
+	assert.NoError(t, m.Stability(&start))
+	stop := pocket.Stability{Stop: true}
+	assert.NoError(t, m.Stability(&stop))
+	assert.False(t, stop.Summary.Done)
+	assert.Nil(t, m.experiment)
+	assert.Nil(t, m.StabilityOnce(context.Background()))
+
+	// the dry run plans the first measurement
+	p, err := m.Plan(start)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(p.Steps))
+	assert.Equal(t, "load", p.Steps[0].What)
+
+	assert.Equal(t, pocket.Value{Mag: 0, Phase: 0}, drifted(&pocket.Value{Mag: math.Inf(-1), Phase: math.NaN()}, &pocket.Value{}))
+}
+
+func TestAnalyze(t *testing.T) {
+
+	m := Middle{}
+
+	req := pocket.Analysis{}
+	assert.Error(t, m.Analyze(&req))
+
+	m.rq = &pocket.RangeQuery{What: "dut1"}
+	m.dutcal = []pocket.SParam{
+		{Freq: 100e6, S11: pocket.Complex{Real: 0.1}, S21: pocket.Complex{Real: 0.5}},
+		{Freq: 200e6, S11: pocket.Complex{Real: 0.01}, S21: pocket.Complex{Real: 0.9}},
+	}
+
+	assert.NoError(t, m.Analyze(&req))
+	assert.Equal(t, "dut1", req.What)
+	assert.Equal(t, 200e6, req.Result.S11Min.Freq)
+	assert.InDelta(t, -40, req.Result.S11Min.DB, 1e-9)
+}
+
+func TestCache(t *testing.T) {
+
+	m := Middle{}
+
+	res := pocket.CalibratedRangeQuery{
+		Command: pocket.Command{ID: "a", Command: "crq"},
+		What:    "dut1",
+		Avg:     1,
+		Result:  []pocket.SParam{{Freq: 100e6, S11: pocket.Complex{Real: 0.1}}},
+	}
+
+	m.ToCache(&res)
+	assert.NotEqual(t, "", res.ETag)
+
+	// zero maxage always measures
+	req := pocket.CalibratedRangeQuery{What: "dut1", Avg: 1}
+	assert.False(t, m.FromCache(&req))
+
+	// different parameters are not in the cache
+	req = pocket.CalibratedRangeQuery{What: "dut2", Avg: 1, MaxAge: 10}
+	assert.False(t, m.FromCache(&req))
+
+	req = pocket.CalibratedRangeQuery{Command: pocket.Command{ID: "b", Command: "crq"}, What: "DUT1", Avg: 1, MaxAge: 10}
+	assert.True(t, m.FromCache(&req))
+	assert.True(t, req.Cached)
+	assert.False(t, req.NotModified)
+	assert.Equal(t, "b", req.ID)
+	assert.Equal(t, res.ETag, req.ETag)
+	assert.Equal(t, res.Result, req.Result)
+
+	// client already has this result
+	req = pocket.CalibratedRangeQuery{What: "dut1", Avg: 1, MaxAge: 10, ETag: res.ETag}
+	assert.True(t, m.FromCache(&req))
+	assert.True(t, req.NotModified)
+	assert.Nil(t, req.Result)
+
+	// too old
+	time.Sleep(20 * time.Millisecond)
+	req = pocket.CalibratedRangeQuery{What: "dut1", Avg: 1, MaxAge: 0.01}
+	assert.False(t, m.FromCache(&req))
+
+	// the avg chosen for a target, and the rest of the response, are not part of the key
+	res = pocket.CalibratedRangeQuery{
+		What:        "dut1",
+		Avg:         37,
+		Target:      0.01,
+		Result:      []pocket.SParam{{Freq: 100e6}},
+		Uncorrected: true,
+		Warning:     "check the connection",
+	}
+	m.ToCache(&res)
+
+	req = pocket.CalibratedRangeQuery{What: "dut1", Target: 0.01, MaxAge: 10}
+	assert.True(t, m.FromCache(&req))
+	assert.Equal(t, uint16(37), req.Avg)
+
+	req = pocket.CalibratedRangeQuery{What: "dut1", Target: 0.02, MaxAge: 10}
+	assert.False(t, m.FromCache(&req))
+
+	// a new calibration, fixture etc means measuring again
+	m.invalidate()
+	req = pocket.CalibratedRangeQuery{What: "dut1", Avg: 1, MaxAge: 10}
+	assert.False(t, m.FromCache(&req))
+}
+
+func TestGetConfig(t *testing.T) {
+
+	m := Middle{}
+
+	req := pocket.GetConfig{}
+	assert.Error(t, m.GetConfig(&req))
+
+	m.SetConfig("", config.Default())
+	assert.NoError(t, m.GetConfig(&req))
+	assert.Equal(t, config.Default(), req.Result)
+
+	// the token is not given away
+	c := config.Default()
+	c.Token = "secret"
+	m.SetConfig("", c)
+	assert.NoError(t, m.GetConfig(&req))
+	assert.Equal(t, "********", req.Result.(config.Config).Token)
+	assert.Equal(t, "secret", m.config.Token)
+
+	c.FleetToken = "secret"
+	m.SetConfig("", c)
+	assert.NoError(t, m.GetConfig(&req))
+	assert.Equal(t, "********", req.Result.(config.Config).FleetToken)
+
+	c.WebhookToken = "secret"
+	m.SetConfig("", c)
+	assert.NoError(t, m.GetConfig(&req))
+	assert.Equal(t, "********", req.Result.(config.Config).WebhookToken)
+}
+
+func TestReload(t *testing.T) {
+
+	m := Middle{}
+
+	req := pocket.Reload{}
+	assert.Error(t, m.Reload(&req))
+
+	var v pocket.VNA = pocket.NewMock()
+	h := measure.NewHardware(&v, rfusb.NewMock())
+	m.h = h
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "config.yaml")
+
+	err := os.WriteFile(file, []byte("log_level: warn\n"), 0644)
+	assert.NoError(t, err)
+
+	c, err := config.Load(file)
+	assert.NoError(t, err)
+	m.SetConfig(file, c)
+
+	err = os.WriteFile(file, []byte("log_level: warn\ntimeout_request: 5m\nsettle_ports: thru=100ms\nswitch_terms: [dut1, dut3]\nport: /dev/ttyUSB1\n"), 0644)
+	assert.NoError(t, err)
+
+	assert.NoError(t, m.Reload(&req))
+	assert.Equal(t, []string{"settle_ports", "switch_terms", "timeout_request"}, req.Changed)
+	assert.Equal(t, []string{"port"}, req.Restart)
+	assert.Equal(t, 5*time.Minute, m.timeout)
+	assert.Equal(t, 100*time.Millisecond, m.h.SettleFor["thru"])
+	assert.Equal(t, []string{"dut1", "dut3"}, m.switchStd)
+	assert.Equal(t, "/dev/ttyUSB0", m.config.Port)
+
+	// bad settings leave everything as it was
+	err = os.WriteFile(file, []byte("switch_terms: [dut1]\n"), 0644)
+	assert.NoError(t, err)
+
+	assert.Error(t, m.Reload(&req))
+	assert.Equal(t, 5*time.Minute, m.timeout)
+	assert.Equal(t, []string{"dut1", "dut3"}, m.switchStd)
+}
+
+func TestCorrect(t *testing.T) {
+
+	e := twoport.ErrorTerms{
+		Edf: 0.05 + 0.02i, Esf: 0.1 - 0.05i, Erf: 0.9 + 0.1i, Etf: 0.85 - 0.2i, Elf: 0.08 + 0.03i, Exf: 0.001i,
+		Edr: 0.04 - 0.01i, Esr: -0.07 + 0.06i, Err: 0.88 - 0.15i, Etr: 0.8 + 0.25i, Elr: 0.06 - 0.02i, Exr: 0.002,
+	}
+
+	m := Middle{
+		terms: []twoport.ErrorTerms{e},
+	}
+
+	dut := twoport.S{{0.1 + 0.2i, 0.7 - 0.1i}, {0.8 - 0.2i, -0.3 + 0.1i}}
+	raw := []pocket.SParam{e.Measure(dut).SParam(100e6)}
+
+	c, err := m.Correct(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100e6), c[0].Freq)
+	assert.InDelta(t, 0, cmplx.Abs(dut[0][0]-complex(c[0].S11.Real, c[0].S11.Imag)), 1e-9)
+	assert.InDelta(t, 0, cmplx.Abs(dut[1][0]-complex(c[0].S21.Real, c[0].S21.Imag)), 1e-9)
+
+	_, err = m.Correct(append(raw, raw...))
+	assert.Error(t, err)
+
+	// switch terms are removed first
+	gf, gr := 0.15-0.05i, -0.1+0.12i
+	m.switchTerms = [][2]complex128{{gf, gr}}
+	raw = []pocket.SParam{e.Measure(dut).Terminate(gf, gr).SParam(100e6)}
+
+	c, err = m.Correct(raw)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0, cmplx.Abs(dut[0][1]-complex(c[0].S12.Real, c[0].S12.Imag)), 1e-9)
+	assert.InDelta(t, 0, cmplx.Abs(dut[1][1]-complex(c[0].S22.Real, c[0].S22.Imag)), 1e-9)
+}
+
+func TestCompare(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{
+		{Freq: 100e6, S21: pocket.Complex{Real: 0.5}},
+		{Freq: 200e6, S21: pocket.Complex{Imag: 0.5}},
+	}
+
+	var v pocket.VNA = mock
+	sw := rfusb.NewMock()
+
+	// a cal with ideal error terms, so the results are the raw measurements
+	ideal := twoport.ErrorTerms{Erf: 1, Etf: 1, Err: 1, Etr: 1}
+
+	m := Middle{
+		h:     measure.NewHardware(&v, sw),
+		rq:    &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2},
+		terms: []twoport.ErrorTerms{ideal, ideal},
+	}
+
+	c := pocket.Compare{A: "dut1", B: "dut2"}
+	err := m.Compare(context.Background(), &c)
+	assert.NoError(t, err)
+	assert.Equal(t, "dut2", sw.Get())
+	assert.Equal(t, 2, len(c.ResultA))
+	assert.Equal(t, 2, len(c.ResultB))
+	assert.Equal(t, 2, len(c.Delta))
+	assert.InDelta(t, 0.5, c.ResultA[0].S21.Real, 1e-9)
+	assert.InDelta(t, 0, c.Delta[1].S21.Mag, 1e-9)
+	assert.InDelta(t, 0, c.Delta[1].S21.Phase, 1e-9)
+
+	c = pocket.Compare{A: "dut1"}
+	assert.Error(t, m.Compare(context.Background(), &c))
+
+	m.rq = nil
+	c = pocket.Compare{A: "dut1", B: "dut2"}
+	assert.Error(t, m.Compare(context.Background(), &c))
+}
+
+func TestReference(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{
+		{Freq: 100e6, S21: pocket.Complex{Real: 0.5}, S11: pocket.Complex{Imag: 0.1}},
+		{Freq: 200e6, S21: pocket.Complex{Imag: 0.5}},
+	}
+
+	var v pocket.VNA = mock
+
+	ideal := twoport.ErrorTerms{Erf: 1, Etf: 1, Err: 1, Etr: 1}
+
+	m := Middle{
+		h:     measure.NewHardware(&v, rfusb.NewMock()),
+		rq:    &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2},
+		terms: []twoport.ErrorTerms{ideal, ideal},
+	}
+
+	crq := pocket.CalibratedRangeQuery{What: "dut1", Normalize: true}
+	assert.Error(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+
+	sr := pocket.SaveReference{What: "thru"}
+	err := m.SaveReference(context.Background(), &sr)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(sr.Result))
+	assert.Equal(t, "thru", sr.Reference.What)
+	assert.Equal(t, uint64(200e6), sr.Reference.End)
+	assert.Equal(t, Z0, sr.Reference.Z0)
+
+	// the dut has twice the gain of the reference, and a quarter turn more phase
+	mock.ResultRangeQuery = []pocket.SParam{
+		{Freq: 100e6, S21: pocket.Complex{Imag: 1}},
+		{Freq: 200e6, S21: pocket.Complex{Real: -1}},
+	}
+
+	crq = pocket.CalibratedRangeQuery{What: "dut1", Normalize: true, Format: "db"}
+	err = m.MeasureRangeCalibrated(context.Background(), &crq)
+	assert.NoError(t, err)
+	assert.Equal(t, "thru", crq.Reference.What)
+	assert.InDelta(t, 20*math.Log10(2), crq.Formatted[0].S21.Mag, 1e-9)
+	assert.InDelta(t, 90, crq.Formatted[1].S21.Phase, 1e-9)
+	assert.Equal(t, 0.0, crq.Result[1].S11.Real) // zero in the reference
+
+	crq = pocket.CalibratedRangeQuery{What: "dut1", Normalize: true, Z0: 75}
+	assert.Error(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+
+	m.ClearReference(&pocket.ClearReference{})
+	crq = pocket.CalibratedRangeQuery{What: "dut1", Normalize: true}
+	assert.Error(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+
+	_, err = Normalize(mock.ResultRangeQuery, mock.ResultRangeQuery[:1])
+	assert.Error(t, err)
+}
+
+func TestLimits(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{
+		{Freq: 100e6, S21: pocket.Complex{Real: 1}},
+		{Freq: 200e6, S21: pocket.Complex{Real: 0.01}},
+	}
+
+	var v pocket.VNA = mock
+
+	ideal := twoport.ErrorTerms{Erf: 1, Etf: 1, Err: 1, Etr: 1}
+
+	m := Middle{
+		h:     measure.NewHardware(&v, rfusb.NewMock()),
+		rq:    &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2},
+		terms: []twoport.ErrorTerms{ideal, ideal},
+	}
+
+	max := -30.0
+	min := -3.0
+
+	// a bad limit is rejected and the old ones are kept
+	assert.Error(t, m.SetLimits(&pocket.SetLimits{Limits: []pocket.Limit{{SParam: "s33", Max: &max}}}))
+
+	crq := pocket.CalibratedRangeQuery{What: "dut1"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.Nil(t, crq.Pass)
+	assert.Nil(t, crq.Limits)
+
+	sl := pocket.SetLimits{Limits: []pocket.Limit{
+		{Name: "pass", SParam: "s21", Start: 100e6, End: 100e6, Min: &min},
+		{Name: "stop", SParam: "s21", Start: 200e6, End: 200e6, Max: &max},
+	}}
+	assert.NoError(t, m.SetLimits(&sl))
+
+	crq = pocket.CalibratedRangeQuery{What: "dut1"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.True(t, *crq.Pass)
+	assert.Equal(t, 2, len(crq.Limits))
+	assert.InDelta(t, 3, crq.Limits[0].Margin, 1e-9)
+	assert.InDelta(t, 10, crq.Limits[1].Margin, 1e-9)
+
+	max = -50.0
+	assert.NoError(t, m.SetLimits(&sl))
+
+	crq = pocket.CalibratedRangeQuery{What: "dut1"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.False(t, *crq.Pass)
+	assert.False(t, crq.Limits[1].Pass)
+	assert.InDelta(t, -10, crq.Limits[1].Margin, 1e-9)
+
+	assert.NoError(t, m.SetLimits(&pocket.SetLimits{}))
+
+	crq = pocket.CalibratedRangeQuery{What: "dut1"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.Nil(t, crq.Pass)
+}
+
+func TestRawResult(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{
+		{Freq: 100e6, S21: pocket.Complex{Real: 0.25}},
+		{Freq: 200e6, S21: pocket.Complex{Imag: 0.25}},
+	}
+
+	var v pocket.VNA = mock
+
+	// a transmission tracking of a half doubles S21
+	half := twoport.ErrorTerms{Erf: 1, Etf: 0.5, Err: 1, Etr: 0.5}
+
+	m := Middle{
+		h:     measure.NewHardware(&v, rfusb.NewMock()),
+		rq:    &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2},
+		terms: []twoport.ErrorTerms{half, half},
+	}
+
+	crq := pocket.CalibratedRangeQuery{What: "dut1"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.Nil(t, crq.RawResult)
+
+	crq = pocket.CalibratedRangeQuery{What: "dut1", Raw: true, Format: "db", FormatOnly: true}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.Nil(t, crq.Result)
+	assert.Equal(t, 2, len(crq.Formatted))
+	assert.Equal(t, 2, len(crq.RawResult))
+	assert.Equal(t, 0.25, crq.RawResult[0].S21.Real)
+	assert.Equal(t, 0.25, crq.RawResult[1].S21.Imag)
+
+	crq = pocket.CalibratedRangeQuery{What: "dut1", Raw: true}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.InDelta(t, 0.5, crq.Result[0].S21.Real, 1e-9)
+	assert.Equal(t, 0.25, crq.RawResult[0].S21.Real)
+}
+
+func TestMeta(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{
+		{Freq: 100e6, S11: pocket.Complex{Real: 0.5}, S21: pocket.Complex{Real: 0.5}},
+		{Freq: 200e6, S11: pocket.Complex{Real: 0.5}, S21: pocket.Complex{Real: 0.5}},
+	}
+
+	var v pocket.VNA = mock
+
+	ideal := twoport.ErrorTerms{Erf: 1, Etf: 1, Err: 1, Etr: 1}
+
+	m := Middle{
+		h:     measure.NewHardware(&v, rfusb.NewMock()),
+		rq:    &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2},
+		terms: []twoport.ErrorTerms{ideal, ideal},
+	}
+
+	crq := pocket.CalibratedRangeQuery{What: "dut1"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.Equal(t, &pocket.Meta{
+		Freq:        "Hz",
+		Values:      "linear real/imag",
+		Z0:          50,
+		Orientation: pocket.PortOrientation,
+		Correction:  "twelve-term",
+	}, crq.Meta)
+
+	// the corrections are listed in the order they are made
+	m.switchTerms = [][2]complex128{{0, 0}, {0, 0}}
+	m.portext = pocket.Extension{Port1: 1e-9}
+
+	crq = pocket.CalibratedRangeQuery{What: "dut1", Z0: 75, Format: "db"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.Equal(t, []string{"switch-terms", "portext", "renormalize"}, crq.Meta.Applied)
+	assert.Equal(t, 75.0, crq.Meta.Z0)
+	assert.Equal(t, "magnitude in dB, phase in degrees", crq.Meta.Formatted)
+
+	// switch terms for another number of points are not used
+	m.switchTerms = [][2]complex128{{0, 0}}
+	m.portext = pocket.Extension{}
+
+	crq = pocket.CalibratedRangeQuery{What: "dut1"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.Nil(t, crq.Meta.Applied)
+
+	assert.Equal(t, "none", rawMeta().Correction)
+}
+
+func TestPathLoss(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{
+		{Freq: 100e6, S11: pocket.Complex{Real: 0.25}, S21: pocket.Complex{Real: 0.5}},
+		{Freq: 200e6, S11: pocket.Complex{Real: 0.25}, S21: pocket.Complex{Real: 0.5}},
+	}
+
+	var v pocket.VNA = mock
+
+	m := Middle{h: measure.NewHardware(&v, rfusb.NewMock())}
+
+	rq := pocket.RangeQuery{
+		Command:  pocket.Command{Command: "rq"},
+		What:     "dut1",
+		Range:    pocket.Range{Start: 100e6, End: 200e6},
+		Size:     2,
+		PathLoss: true,
+	}
+
+	_, err := m.Handle(context.Background(), rq)
+	assert.EqualError(t, err, "path loss is not known, set path_loss in the config")
+
+	file := filepath.Join(t.TempDir(), "pathloss.json")
+
+	// 6.02 dB and -90 degrees each way
+	err = os.WriteFile(file, []byte(`{"paths":{"dut1":{"port1":[{"freq":1000000,"loss":6.0206,"phase":-90},{"freq":1000000000,"loss":6.0206,"phase":-90}]}}}`), 0644)
+	assert.NoError(t, err)
+
+	pl, err := pathloss.Load(file)
+	assert.NoError(t, err)
+	m.SetPathLoss(pl)
+
+	response, err := m.Handle(context.Background(), rq)
+	assert.NoError(t, err)
+
+	r := response.(pocket.RangeQuery)
+	assert.InDelta(t, -1, r.Result[1].S11.Real, 1e-4)
+	assert.InDelta(t, -2, r.Result[1].S21.Real, 1e-4)
+	assert.Equal(t, []string{"pathloss"}, r.Meta.Applied)
+	assert.Equal(t, "none", r.Meta.Correction)
+
+	// no path for this position
+	rq.What = "dut2"
+	_, err = m.Handle(context.Background(), rq)
+	assert.Error(t, err)
+
+	// not asked for
+	rq.PathLoss = false
+	response, err = m.Handle(context.Background(), rq)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, response.(pocket.RangeQuery).Result[1].S21.Real)
+}
+
+func TestCharacterize(t *testing.T) {
+
+	// the thru, with 3 dB one way along each line, and a short and open with 1 dB
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{
+		{Freq: 100e6, S11: pocket.Complex{Real: -0.794328}, S21: pocket.Complex{Real: 0.501187}, S12: pocket.Complex{Real: 0.501187}, S22: pocket.Complex{Real: -0.794328}},
+		{Freq: 200e6, S11: pocket.Complex{Real: -0.794328}, S21: pocket.Complex{Real: 0.501187}, S12: pocket.Complex{Real: 0.501187}, S22: pocket.Complex{Real: -0.794328}},
+	}
+
+	var v pocket.VNA = mock
+
+	m := Middle{h: measure.NewHardware(&v, rfusb.NewMock())}
+
+	request := pocket.Characterize{
+		Range: pocket.Range{Start: 100e6, End: 200e6},
+		Size:  2,
+		Paths: map[string]string{"thru": "thru", "DUT1": "short"},
+	}
+
+	assert.EqualError(t, m.Characterize(context.Background(), &request), "path_loss must be set in the config, to say where to write the results")
+
+	file := filepath.Join(t.TempDir(), "pathloss.json")
+	c := config.Default()
+	c.PathLoss = file
+	m.SetConfig("", c)
+
+	assert.NoError(t, m.Characterize(context.Background(), &request))
+	assert.Equal(t, file, request.File)
+	assert.InDelta(t, 3, request.Result["thru"].Port1[1].Loss, 1e-4)
+	assert.InDelta(t, 3, request.Result["thru"].Port2[1].Loss, 1e-4)
+	assert.InDelta(t, 0, request.Result["thru"].Port1[1].Phase, 1e-9)
+	assert.InDelta(t, 1, request.Result["dut1"].Port1[0].Loss, 1e-4)
+
+	// written, and used from now on
+	pl, err := pathloss.Load(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dut1", "thru"}, pl.Positions())
+	assert.Equal(t, pl.Paths, m.pathLoss.Paths)
+
+	// other paths are kept
+	request.Paths = map[string]string{"open": "open"}
+	mock.ResultRangeQuery[0].S11.Real = 0.794328
+	mock.ResultRangeQuery[1].S11.Real = 0.794328
+	assert.NoError(t, m.Characterize(context.Background(), &request))
+	assert.Equal(t, []string{"dut1", "open", "thru"}, m.pathLoss.Positions())
+	assert.Equal(t, 1, len(request.Result))
+
+	request.Paths = map[string]string{"dut2": "load"}
+	assert.Error(t, m.Characterize(context.Background(), &request))
+
+	request.Paths = map[string]string{"dut9": "short"}
+	assert.Error(t, m.Characterize(context.Background(), &request))
+}
+
+func TestCheckRange(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultReasonableFrequencyRange = pocket.Range{Start: 500e3, End: 4e9}
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+	var v pocket.VNA = mock
+
+	cal := &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2}
+	ideal := twoport.ErrorTerms{Erf: 1, Etf: 1, Err: 1, Etr: 1}
+
+	m := Middle{
+		h:     measure.NewHardware(&v, rfusb.NewMock()),
+		rq:    cal,
+		terms: []twoport.ErrorTerms{ideal, ideal},
+	}
+
+	// a bad cal is refused without touching the hardware, and the old cal is kept
+	rq := pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 6e9}, Size: 2}
+	err := m.CalibrateRange(context.Background(), &rq)
+	assert.Error(t, err)
+	assert.Equal(t, cal, m.rq)
+	assert.Equal(t, 1, len(mock.CommandsReceived)) // asking for the range
+
+	rq = pocket.RangeQuery{What: "dut1", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 0}
+	assert.Error(t, m.MeasureRange(context.Background(), &rq))
+
+	crq := pocket.CalibratedRangeQuery{What: "dut9"}
+	assert.Error(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+
+	assert.Equal(t, 1, len(mock.CommandsReceived))
+
+	crq = pocket.CalibratedRangeQuery{What: "dut1"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+}
+
+func TestHello(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultReasonableFrequencyRange = pocket.Range{Start: 100e3, End: 4e9}
+
+	var v pocket.VNA = mock
+
+	m := Middle{
+		h: measure.NewHardware(&v, rfusb.NewMock()),
+	}
+
+	h := pocket.Hello{Version: stream.Protocol + 1}
+	err := m.Hello(&h)
+	assert.NoError(t, err)
+	assert.Equal(t, stream.Protocol, h.Result.Protocol)
+	assert.Equal(t, uint64(4e9), h.Result.Range.End)
+	assert.Equal(t, pocket.MaxPoints, h.Result.MaxPoints)
+	assert.Contains(t, h.Result.Commands, "crq")
+	assert.Contains(t, h.Result.Commands, "hello")
+	assert.Equal(t, []string{"dut1", "dut2", "dut3", "dut4", "load", "open", "short", "thru"}, h.Result.Positions)
+	assert.Equal(t, "part", h.Result.Chunking.Cmd)
+	assert.Equal(t, 0, h.Result.Chunking.MaxMessage) // no stream
+}
+
+func TestGuard(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultReasonableFrequencyRange = pocket.Range{Start: 500e3, End: 4e9}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.SetGuard(pocket.Guard{Max: 3e9, Band: 10e6})
+
+	assert.Equal(t, pocket.Range{Start: 10.5e6, End: 3e9}, m.allowed(pocket.Range{Start: 500e3, End: 4e9}))
+	assert.Equal(t, pocket.Range{Start: 10.5e6, End: 3.99e9}, (&Middle{guard: pocket.Guard{Band: 10e6}}).allowed(pocket.Range{Start: 500e3, End: 4e9}))
+	assert.Equal(t, pocket.Range{End: 3e9}, m.allowed(pocket.Range{})) // the guard band needs the range of the VNA
+
+	rq := pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, What: "dut1", Range: pocket.Range{Start: 1e6, End: 4e9}, Size: 2}
+
+	_, err := m.Handle(context.Background(), rq)
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.Contains(t, err.Error(), "frequencies must be within 10500000 Hz to 3000000000 Hz on this rig")
+
+	rc := rq
+	rc.Command.Command = "rc"
+	_, err = m.Handle(context.Background(), rc)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = m.Handle(context.Background(), pocket.TimeQuery{What: "dut1", Freq: 3.5e9, Count: 1})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	m.SetGuard(pocket.Guard{Max: 3e9, Band: 10e6, Clamp: true})
+
+	res, err := m.Handle(context.Background(), rq)
+	assert.NoError(t, err)
+	assert.Equal(t, pocket.Range{Start: 10.5e6, End: 3e9}, res.(pocket.RangeQuery).Range)
+
+	p, err := m.Plan(rq)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(p.Steps))
+
+	h := pocket.Hello{}
+	assert.NoError(t, m.Hello(&h))
+	assert.Equal(t, pocket.Range{Start: 10.5e6, End: 3e9}, h.Result.Allowed)
+	assert.True(t, h.Result.Clamp)
+}
+
+func TestPresets(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultReasonableFrequencyRange = pocket.Range{Start: 500e3, End: 4e9}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+
+	presets, err := measure.ParsePresets("full=1e6-4e9/201,uhf=300e6-1e9/101/log")
+	assert.NoError(t, err)
+	m.SetPresets(presets)
+
+	res, err := m.Handle(context.Background(), pocket.ReasonableFrequencyRange{Command: pocket.Command{Command: "rr"}})
+	assert.NoError(t, err)
+	assert.Equal(t, presets, res.(pocket.ReasonableFrequencyRange).Presets)
+
+	rq := pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, What: "dut1", Preset: "UHF"}
+
+	res, err = m.Handle(context.Background(), rq)
+	assert.NoError(t, err)
+	r := res.(pocket.RangeQuery)
+	assert.Equal(t, pocket.Range{Start: 300e6, End: 1e9}, r.Range)
+	assert.Equal(t, 101, r.Size)
+	assert.True(t, r.LogDistribution)
+
+	// the sweep that was sent to the VNA
+	n := len(mock.CommandsReceived)
+	assert.Equal(t, 101, mock.CommandsReceived[n-1].(pocket.RangeQuery).Size)
+
+	p, err := m.Plan(rq)
+	assert.NoError(t, err)
+	assert.Equal(t, 101, p.Steps[0].Points)
+
+	rq.Preset = "vhf"
+	_, err = m.Handle(context.Background(), rq)
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.Contains(t, err.Error(), "use one of full, uhf")
+
+	rq.Preset = "full"
+	rq.Size = 11
+	_, err = m.Handle(context.Background(), rq)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	rc := pocket.RangeQuery{Command: pocket.Command{Command: "rc"}, Preset: "full", Size: 11}
+	_, err = m.Handle(context.Background(), rc)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestSchema(t *testing.T) {
+
+	m := Middle{}
+
+	s := pocket.Schema{Name: "CRQ"}
+	assert.NoError(t, m.Schema(&s))
+	assert.Equal(t, "CalibratedRangeQuery", s.Result.(*schema.Schema).Title)
+
+	s = pocket.Schema{}
+	assert.NoError(t, m.Schema(&s))
+	assert.Contains(t, s.Result, "schema")
+
+	assert.Error(t, m.Schema(&pocket.Schema{Name: "nope"}))
+
+	invalid, _ := stream.Parse([]byte(`{"cmd":"sp","power":"high"}`))
+	_, err := m.Handle(context.Background(), invalid)
+	assert.EqualError(t, err, `request is not valid because power must be a number, not "high"`)
+}
+
+func TestBatch(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+	var v pocket.VNA = mock
+	sw := rfusb.NewMock()
+
+	m := Middle{
+		h: measure.NewHardware(&v, sw),
+	}
+
+	rq := func(what string) pocket.RangeQuery {
+		return pocket.RangeQuery{
+			Command: pocket.Command{Command: "rq"},
+			Range:   pocket.Range{Start: 100e6, End: 200e6},
+			Size:    2,
+			What:    what,
+			Select:  pocket.SParamSelect{S11: true},
+		}
+	}
+
+	b := pocket.Batch{Requests: []interface{}{rq("dut1"), rq("dut2")}}
+	err := m.Batch(context.Background(), &b)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(b.Results))
+	assert.Equal(t, "dut1", b.Results[0].(pocket.RangeQuery).What)
+	assert.Equal(t, 2, len(b.Results[1].(pocket.RangeQuery).Result))
+	assert.Equal(t, "dut2", sw.Get())
+
+	// stops at the first error, with the error in place of the result
+	b = pocket.Batch{Requests: []interface{}{rq("dut1"), nil, rq("dut3")}}
+	err = m.Batch(context.Background(), &b)
+	assert.Error(t, err)
+	assert.Equal(t, 2, len(b.Results))
+	assert.Equal(t, "unknown command", b.Results[1].(pocket.CustomResult).Message)
+
+	b.Continue = true
+	err = m.Batch(context.Background(), &b)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(b.Results))
+	assert.Equal(t, "dut3", sw.Get())
+
+	b = pocket.Batch{Requests: []interface{}{pocket.Batch{}}}
+	assert.Error(t, m.Batch(context.Background(), &b))
+
+	b = pocket.Batch{}
+	assert.Error(t, m.Batch(context.Background(), &b))
+}
+
+// unavailable is a calibration service that is down
+type unavailable struct{}
+
+func (u unavailable) Calibrate(ctx context.Context, freq []uint64, std *calibration.Standards, dut []pocket.SParam) (calibration.Result, error) {
+	return calibration.Result{}, fmt.Errorf("%w: connection refused", calibration.ErrUnavailable)
+}
+
+func TestCalibrationUnavailable(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+	var v pocket.VNA = mock
+	m := Middle{
+		cal: unavailable{},
+		h:   measure.NewHardware(&v, rfusb.NewMock()),
+	}
+
+	rq := pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2}
+
+	// an error is returned, rather than exiting, and the partial cal is not used
+	err := m.CalibrateRange(context.Background(), &rq)
+	assert.Error(t, err)
+	assert.Nil(t, m.rq)
+
+	crq := pocket.CalibratedRangeQuery{What: "dut1"}
+	err = m.MeasureRangeCalibrated(context.Background(), &crq)
+	assert.Error(t, err)
+	assert.Equal(t, "not calibrated yet", err.Error())
+
+	// with fallback, raw results are returned at the points of the failed cal
+	m.SetFallback(true)
+	crq = pocket.CalibratedRangeQuery{What: "dut1", Raw: true, Format: "db"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.True(t, crq.Uncorrected)
+	assert.Contains(t, crq.Warning, "not calibrated")
+	assert.Equal(t, 2, len(crq.Result))
+	assert.Equal(t, crq.Result, crq.RawResult)
+	assert.Equal(t, 2, len(crq.Formatted))
+	assert.Equal(t, pocket.CorrectionNone, crq.Meta.Correction)
+
+	// and when the service is needed for each measurement
+	m.rq = &rq
+	m.std = &calibration.Standards{Short: []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}}
+	crq = pocket.CalibratedRangeQuery{What: "dut1"}
+	assert.NoError(t, m.MeasureRangeCalibrated(context.Background(), &crq))
+	assert.True(t, crq.Uncorrected)
+	assert.Contains(t, crq.Warning, "connection refused")
+
+	m.SetFallback(false)
+	err = m.MeasureRangeCalibrated(context.Background(), &crq)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "could not calibrate")
+}
+
+func TestServer(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultReasonableFrequencyRange = pocket.Range{Start: 500e3, End: 4e9}
+	mock.ResultRangeQuery = []pocket.SParam{
+		{Freq: 100e6, S21: pocket.Complex{Real: 0.5}},
+		{Freq: 200e6, S21: pocket.Complex{Imag: 0.5}},
+	}
+
+	var v pocket.VNA = mock
+	ideal := twoport.ErrorTerms{Erf: 1, Etf: 1, Err: 1, Etr: 1}
+
+	m := Middle{
+		cal:     unavailable{},
+		calls:   make(chan call),
+		h:       measure.NewHardware(&v, rfusb.NewMock()),
+		timeout: time.Second,
+	}
+
+	// stand in for Run
+	go func() {
+		for c := range m.calls {
+			m.answer(c)
+		}
+	}()
+
+	s := &Server{m: &m}
+	ctx := context.Background()
+
+	st, err := s.Status(ctx, &pb.StatusRequest{})
+	assert.NoError(t, err)
+	assert.False(t, st.Calibrated)
+	assert.Equal(t, uint64(4e9), st.MaxFrequency)
+	assert.Equal(t, int32(stream.Protocol), st.Protocol)
+	assert.Equal(t, 8, len(st.Positions))
+
+	mr, err := s.Measure(ctx, &pb.MeasureRequest{What: "dut1", Start: 100e6, End: 200e6, Size: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []float64{100e6, 200e6}, mr.Frequency)
+	assert.Equal(t, 0.5, mr.Result.S21[0].Real)
+
+	// errors have the status code that matches them
+	code := func(err error) codes.Code {
+		return grpcstatus.Code(err)
+	}
+
+	_, err = s.Measure(ctx, &pb.MeasureRequest{What: "dut1", Calibrated: true})
+	assert.Equal(t, codes.FailedPrecondition, code(err))
+	assert.Equal(t, "not calibrated yet", grpcstatus.Convert(err).Message())
+
+	_, err = s.Measure(ctx, &pb.MeasureRequest{What: "nowhere", Start: 100e6, End: 200e6, Size: 2})
+	assert.Equal(t, codes.InvalidArgument, code(err))
+
+	_, err = s.Measure(ctx, &pb.MeasureRequest{What: "dut1", Start: 100e6, End: 200e6, Size: 2, Reject: "mean"})
+	assert.Equal(t, codes.InvalidArgument, code(err))
+
+	_, err = s.Calibrate(ctx, &pb.CalibrateRequest{Start: 200e6, End: 100e6, Size: 2})
+	assert.Equal(t, codes.InvalidArgument, code(err))
+
+	_, err = s.Calibrate(ctx, &pb.CalibrateRequest{Start: 100e6, End: 200e6, Size: 2})
+	assert.Equal(t, codes.Unavailable, code(err))
+
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2}
+	m.terms = []twoport.ErrorTerms{ideal, ideal}
+
+	mr, err = s.Measure(ctx, &pb.MeasureRequest{What: "dut1", Calibrated: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 0.5, mr.Result.S21[1].Imag)
+
+	st, err = s.Status(ctx, &pb.StatusRequest{})
+	assert.NoError(t, err)
+	assert.True(t, st.Calibrated)
+	assert.Equal(t, int32(2), st.Size)
+
+	_, err = s.Measure(ctx, &pb.MeasureRequest{What: "dut1", Calibrated: true, Z0: -50})
+	assert.Equal(t, codes.InvalidArgument, code(err))
+
+	// a sweep that takes longer than the timeout
+	mock.PrepareDelay = 50 * time.Millisecond
+	m.timeout = 10 * time.Millisecond
+	_, err = s.Measure(ctx, &pb.MeasureRequest{What: "dut1", Start: 100e6, End: 200e6, Size: 2})
+	assert.Equal(t, codes.DeadlineExceeded, code(err))
+
+	// the delay is left alone, as the abandoned sweep may still be reading it
+	// (run with -race), and nothing after sweeps
+
+	// a request that is never taken gives up with its context
+	close(m.calls)
+	m.calls = make(chan call)
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = s.Status(cctx, &pb.StatusRequest{})
+	assert.Equal(t, codes.Canceled, code(err))
+}
+
+func TestServe(t *testing.T) {
+
+	m := Middle{
+		ctx:     context.Background(),
+		timeout: time.Second,
+		s: &stream.Stream{
+			Request:  make(chan interface{}, 2),
+			Response: make(chan interface{}, 2),
+		},
+	}
+
+	// nothing to cancel
+	m.Serve(pocket.Cancel{})
+	assert.Equal(t, 0, len(m.queue))
+	assert.Equal(t, pocket.Cancel{}, <-m.s.Response)
+
+	// a long request is cancelled, and requests that arrive meanwhile are kept
+	var v pocket.VNA = pocket.NewMock()
+	sw := rfusb.NewMock()
+	sw.Delay = 10 * time.Second
+	m.h = measure.NewHardware(&v, sw)
+
+	m.s.Request <- pocket.Hold{Command: pocket.Command{Command: "hq"}}
+	m.s.Request <- pocket.Cancel{Command: pocket.Command{ID: "c"}}
+
+	t0 := time.Now()
+	m.Serve(pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, What: "dut1"})
+	assert.True(t, time.Since(t0) < 5*time.Second)
+
+	assert.Equal(t, 1, len(m.queue))
+	assert.Equal(t, pocket.Hold{Command: pocket.Command{Command: "hq"}}, m.queue[0].request)
+
+	c, ok := (<-m.s.Response).(pocket.Cancel)
+	assert.True(t, ok)
+	assert.True(t, c.Cancelled)
+	assert.Equal(t, "c", c.ID)
+
+	r, ok := (<-m.s.Response).(pocket.CustomResult)
+	assert.True(t, ok)
+	assert.Equal(t, "cancelled", r.Message)
+}
+
+func TestQueue(t *testing.T) {
+
+	m := Middle{
+		ctx:     context.Background(),
+		timeout: time.Second,
+		s: &stream.Stream{
+			Request:  make(chan interface{}, 4),
+			Response: make(chan interface{}, 4),
+		},
+	}
+
+	// nothing is waiting
+	m.Serve(pocket.Queue{})
+	q, ok := (<-m.s.Response).(pocket.Queue)
+	assert.True(t, ok)
+	assert.Nil(t, q.Current)
+	assert.Equal(t, []pocket.Pending{}, q.Pending)
+
+	var v pocket.VNA = pocket.NewMock()
+	sw := rfusb.NewMock()
+	sw.Delay = 200 * time.Millisecond
+	m.h = measure.NewHardware(&v, sw)
+
+	// the queue is listed while a request is being handled, and is flushed without answering what was in it
+	m.s.Request <- pocket.Hold{Command: pocket.Command{ID: "a", Command: "hq"}}
+	m.s.Request <- pocket.Queue{Command: pocket.Command{Command: "queue"}}
+	m.s.Request <- pocket.FlushQueue{Command: pocket.Command{Command: "flushqueue"}}
+	m.s.Request <- pocket.Queue{Command: pocket.Command{Command: "queue"}}
+
+	m.Serve(pocket.RangeQuery{Command: pocket.Command{ID: "r", Command: "rq"}, What: "dut1", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2})
+
+	q, ok = (<-m.s.Response).(pocket.Queue)
+	assert.True(t, ok)
+	assert.Equal(t, "r", q.Current.ID)
+	assert.Equal(t, "rq", q.Current.Cmd)
+	assert.Equal(t, 1, len(q.Pending))
+	assert.Equal(t, "a", q.Pending[0].ID)
+	assert.Equal(t, "hq", q.Pending[0].Cmd)
+	assert.True(t, q.Pending[0].Age >= 0)
+
+	f, ok := (<-m.s.Response).(pocket.FlushQueue)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(f.Flushed))
+	assert.Equal(t, "a", f.Flushed[0].ID)
+
+	q, ok = (<-m.s.Response).(pocket.Queue)
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(q.Pending))
+
+	_, ok = (<-m.s.Response).(pocket.RangeQuery)
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(m.queue))
+	assert.Nil(t, m.current)
+
+	assert.Equal(t, "invalid", pending(queued{request: pocket.Invalid{Request: pocket.Command{Command: "nope"}}}).Cmd)
+	assert.Equal(t, "unknown", pending(queued{request: 3}).Cmd)
+}
+
+func TestSchedule(t *testing.T) {
+
+	m := Middle{
+		ctx:     context.Background(),
+		timeout: time.Second,
+		s: &stream.Stream{
+			Request:  make(chan interface{}, 4),
+			Response: make(chan interface{}, 4),
+		},
+	}
+
+	var v pocket.VNA = pocket.NewMock()
+	m.h = measure.NewHardware(&v, rfusb.NewMock())
+
+	now := time.Now()
+	at := now.Add(time.Hour).UTC().Format(time.RFC3339)
+
+	// put aside, soonest first, and a retry is not scheduled twice
+	m.Serve(pocket.Hold{Command: pocket.Command{ID: "a", Command: "hq", Session: "x", At: at}})
+	m.Serve(pocket.RangeQuery{Command: pocket.Command{ID: "b", Command: "rangequery", After: 60}, What: "dut1", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2})
+	m.Serve(pocket.Hold{Command: pocket.Command{ID: "a", Command: "holdquery", Session: "x", At: at}})
+
+	for _, want := range []string{"hq", "rq", "hq"} {
+		s, ok := (<-m.s.Response).(pocket.Scheduled)
+		assert.True(t, ok)
+		assert.Equal(t, "scheduled", s.Command.Command)
+		assert.Equal(t, want, s.For)
+	}
+
+	assert.Equal(t, 2, len(m.scheduled))
+
+	m.Serve(pocket.Schedule{Command: pocket.Command{Command: "schedule"}})
+	sd, ok := (<-m.s.Response).(pocket.Schedule)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(sd.Scheduled))
+	assert.Equal(t, "b", sd.Scheduled[0].ID)
+	assert.Equal(t, "a", sd.Scheduled[1].ID)
+	assert.Equal(t, at, sd.Scheduled[1].Due.UTC().Format(time.RFC3339))
+
+	// only those of its own session, if it has one
+	m.Serve(pocket.Schedule{Command: pocket.Command{Command: "sd", Session: "x"}})
+	sd, ok = (<-m.s.Response).(pocket.Schedule)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(sd.Scheduled))
+	assert.Equal(t, "a", sd.Scheduled[0].ID)
+
+	// nothing is due yet
+	m.fire(now.Add(time.Second))
+	assert.Equal(t, 0, len(m.queue))
+
+	// queued when due, and then done as usual
+	m.fire(now.Add(2 * time.Minute))
+	assert.Equal(t, 1, len(m.queue))
+	assert.Equal(t, 1, len(m.scheduled))
+
+	q := m.queue[0]
+	m.queue = nil
+	m.serve(q)
+
+	r, ok := (<-m.s.Response).(pocket.RangeQuery)
+	assert.True(t, ok)
+	assert.Equal(t, "b", r.ID)
+
+	// refused
+	for _, c := range []pocket.Command{
+		{Command: "hq", At: "tonight"},
+		{Command: "hq", At: now.Add(-time.Minute).UTC().Format(time.RFC3339)},
+		{Command: "hq", At: now.Add(30 * 24 * time.Hour).UTC().Format(time.RFC3339)},
+		{Command: "hq", After: -1},
+		{Command: "hq", After: 1e9},
+		{Command: "hq", At: at, After: 1},
+	} {
+		m.Serve(pocket.Hold{Command: c})
+		e, ok := (<-m.s.Response).(pocket.CustomResult)
+		assert.True(t, ok, c)
+		assert.NotEmpty(t, e.Message)
+	}
+
+	assert.Equal(t, 1, len(m.scheduled))
+
+	// a batch is scheduled as a whole, but not the commands in it
+	b := pocket.Batch{Requests: []interface{}{pocket.Hold{Command: pocket.Command{Command: "hq", After: 1}}}}
+	assert.Error(t, m.Batch(context.Background(), &b))
+	assert.Equal(t, ErrScheduledInBatch.Error(), b.Results[0].(pocket.CustomResult).Message)
+
+	_, err := m.Plan(pocket.Batch{Requests: b.Requests})
+	assert.Error(t, err)
+
+	// dropped, by id, only in its own session if it has one, or all of them
+	m.Serve(pocket.Unschedule{Command: pocket.Command{Command: "unschedule"}})
+	_, ok = (<-m.s.Response).(pocket.CustomResult)
+	assert.True(t, ok)
+
+	m.Serve(pocket.Unschedule{Command: pocket.Command{Command: "ud", Session: "y"}, Drop: "a"})
+	_, ok = (<-m.s.Response).(pocket.CustomResult)
+	assert.True(t, ok)
+
+	m.Serve(pocket.Unschedule{Command: pocket.Command{Command: "ud", Session: "y"}, All: true})
+	u, ok := (<-m.s.Response).(pocket.Unschedule)
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(u.Dropped))
+
+	m.Serve(pocket.Unschedule{Command: pocket.Command{Command: "ud", Session: "x"}, Drop: "a"})
+	u, ok = (<-m.s.Response).(pocket.Unschedule)
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(u.Dropped))
+	assert.Equal(t, "a", u.Dropped[0].ID)
+	assert.Equal(t, "hq", u.Dropped[0].For)
+	assert.Equal(t, 0, len(m.scheduled))
+
+	// and scheduled while another request is being handled
+	sw := rfusb.NewMock()
+	sw.Delay = 200 * time.Millisecond
+	m.h = measure.NewHardware(&v, sw)
+
+	m.s.Request <- pocket.Hold{Command: pocket.Command{ID: "c", Command: "hq", After: 1}}
+
+	m.Serve(pocket.RangeQuery{Command: pocket.Command{ID: "r", Command: "rq"}, What: "dut1", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2})
+
+	s, ok := (<-m.s.Response).(pocket.Scheduled)
+	assert.True(t, ok)
+	assert.Equal(t, "c", s.ID)
+
+	_, ok = (<-m.s.Response).(pocket.RangeQuery)
+	assert.True(t, ok)
+	assert.Equal(t, 0, len(m.queue))
+	assert.Equal(t, 1, len(m.scheduled))
+}
+
+func TestPriority(t *testing.T) {
+
+	m := Middle{
+		ctx:     context.Background(),
+		timeout: 30 * time.Second,
+		s: &stream.Stream{
+			Request:  make(chan interface{}, 4),
+			Response: make(chan interface{}, 4),
+		},
+	}
+
+	// higher priorities are served first, then in order of arrival
+	for i, p := range []string{"batch", "", "admin", "Interactive", "urgent"} {
+		m.enqueue(newQueued(pocket.Hold{Command: pocket.Command{ID: strconv.Itoa(i), Command: "hq", Priority: p}}))
+	}
+
+	var order []string
+
+	for _, q := range m.queue {
+		order = append(order, pending(q).ID)
+	}
+
+	assert.Equal(t, []string{"2", "1", "3", "4", "0"}, order)
+
+	m.queue = nil
+
+	m.Serve(pocket.Hold{Command: pocket.Command{Command: "hq", Priority: "urgent"}})
+	r, ok := (<-m.s.Response).(pocket.CustomResult)
+	assert.True(t, ok)
+	assert.Equal(t, "priority must be one of admin, interactive, batch, not urgent", r.Message)
+
+	// a sweep, with a switch that takes delay to set
+	sweeping := func(delay time.Duration) *Middle {
+
+		mock := pocket.NewMock()
+		mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+		var v pocket.VNA = mock
+		sw := rfusb.NewMock()
+		sw.Delay = delay
+
+		sweep := pocket.Sweep{Interval: 1}
+		sweep.Command = pocket.Command{ID: "s", Command: "startsweep"}
+		sweep.What = "dut1"
+
+		return &Middle{
+			ctx:     context.Background(),
+			timeout: 30 * time.Second,
+			s: &stream.Stream{
+				Request:  make(chan interface{}, 4),
+				Response: make(chan interface{}, 4),
+			},
+			h:     measure.NewHardware(&v, sw),
+			rq:    &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2},
+			terms: []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}},
+			sweep: &sweep,
+		}
+	}
+
+	// a batch sweep is stopped by an interactive request, and carries on later
+	b := sweeping(10 * time.Second)
+	b.sweep.Command.Priority = "batch"
+	b.s.Request <- pocket.Hold{Command: pocket.Command{ID: "h", Command: "hq"}}
+
+	t0 := time.Now()
+	b.SweepNext()
+	assert.True(t, time.Since(t0) < 5*time.Second)
+	assert.Equal(t, 0, len(b.s.Response))
+	assert.NotNil(t, b.sweep)
+	assert.Equal(t, 1, len(b.queue))
+	assert.Nil(t, b.current)
+
+	// but not by another batch request, nor is an interactive sweep
+	for p, another := range map[string]string{"batch": "batch", "interactive": "admin"} {
+
+		s := sweeping(100 * time.Millisecond)
+		s.sweep.Command.Priority = p
+		s.s.Request <- pocket.Hold{Command: pocket.Command{Command: "hq", Priority: another}}
+
+		s.SweepNext()
+
+		crq, ok := (<-s.s.Response).(pocket.CalibratedRangeQuery)
+		assert.True(t, ok)
+		assert.Equal(t, "sweep", crq.Command.Command)
+		assert.Equal(t, p, crq.Command.Priority)
+		assert.Equal(t, 2, len(crq.Result))
+		assert.Equal(t, 1, len(s.queue))
+	}
+}
+
+func TestAudit(t *testing.T) {
+
+	m := Middle{
+		ctx:     context.Background(),
+		timeout: time.Second,
+		s: &stream.Stream{
+			Request:  make(chan interface{}, 2),
+			Response: make(chan interface{}, 2),
+		},
+	}
+
+	assert.Error(t, m.Audit(&pocket.Audit{}))
+
+	a, err := audit.Open(filepath.Join(t.TempDir(), "audit.log"))
+	assert.NoError(t, err)
+	defer a.Close()
+
+	m.SetAudit(a)
+
+	m.Serve(pocket.Hold{Command: pocket.Command{ID: "1", Command: "hq", Session: "alice"}})
+	<-m.s.Response
+	m.Serve(pocket.Hold{Command: pocket.Command{ID: "2", Command: "hq", Session: "bob", Priority: "urgent"}})
+	<-m.s.Response
+	m.Serve(pocket.FlushQueue{Command: pocket.Command{ID: "3", Command: "flushqueue", Session: "alice"}})
+	<-m.s.Response
+
+	req := pocket.Audit{}
+	assert.NoError(t, m.Audit(&req))
+	assert.Equal(t, 3, len(req.Result))
+
+	e := req.Result[0]
+	assert.Equal(t, "stream", e.Source)
+	assert.Equal(t, "alice", e.Session)
+	assert.Equal(t, "1", e.ID)
+	assert.Equal(t, "hq", e.Cmd)
+	assert.Equal(t, "interactive", e.Priority)
+	assert.Equal(t, "ok", e.Outcome)
+	assert.Contains(t, string(e.Params), `"session":"alice"`)
+
+	assert.Equal(t, "error", req.Result[1].Outcome)
+	assert.Contains(t, req.Result[1].Error, "priority")
+
+	req = pocket.Audit{Who: "alice", Commands: []string{"holdquery"}}
+	assert.NoError(t, m.Audit(&req))
+	assert.Equal(t, 1, len(req.Result))
+	assert.Equal(t, "1", req.Result[0].ID)
+
+	req = pocket.Audit{Since: time.Now().Add(time.Hour).Unix()}
+	assert.NoError(t, m.Audit(&req))
+	assert.Equal(t, 0, len(req.Result))
+
+	assert.Error(t, m.Audit(&pocket.Audit{Commands: []string{"nope"}}))
+}
+
+func TestHealth(t *testing.T) {
+
+	m := Middle{
+		ctx:     context.Background(),
+		timeout: time.Second,
+		started: time.Now().Add(-time.Minute),
+		tally:   &fleet.Tally{},
+		s: &stream.Stream{
+			Request:  make(chan interface{}, 2),
+			Response: make(chan interface{}, 2),
+		},
+	}
+
+	m.SetVersion("v1.2.3")
+	m.SetFleet(fleet.New("http://localhost/health", "", "rig7", time.Minute))
+
+	m.Serve(pocket.Hold{Command: pocket.Command{ID: "1", Command: "hq"}})
+	<-m.s.Response
+	m.Serve(pocket.Hold{Command: pocket.Command{ID: "2", Command: "hq", Priority: "urgent"}})
+	<-m.s.Response
+
+	h := m.Health()
+	assert.Equal(t, "rig7", h.Rig)
+	assert.Equal(t, "v1.2.3", h.Version)
+	assert.Equal(t, stream.Protocol, h.Protocol)
+	assert.InDelta(t, 60, h.Uptime, 5)
+	assert.Equal(t, fleet.Attention, h.Status)
+	assert.Equal(t, "uncalibrated", h.CalState)
+	assert.Nil(t, h.CalAge)
+	assert.Equal(t, 2, h.Requests)
+	assert.Equal(t, 1, h.Errors)
+	assert.Equal(t, 1, h.Recent)
+	assert.Contains(t, h.LastError, "priority")
+	assert.NotNil(t, h.LastErrorAt)
+	assert.Equal(t, 2, len(h.Problems))
+	assert.Contains(t, h.Problems[0], "not calibrated")
+	assert.Contains(t, h.Problems[1], "1 requests failed")
+
+	// the recent errors are counted again from each report
+	h = m.Health()
+	assert.Equal(t, 1, h.Errors)
+	assert.Equal(t, 0, h.Recent)
+	assert.Equal(t, 1, len(h.Problems))
+}
+
+func TestLogs(t *testing.T) {
+
+	m := Middle{
+		ctx:     context.Background(),
+		timeout: time.Second,
+		s: &stream.Stream{
+			Request:  make(chan interface{}, 4),
+			Response: make(chan interface{}, 4),
+		},
+	}
+
+	assert.Error(t, m.Logs(&pocket.Logs{}))
+
+	r := logring.New(0)
+	m.SetLogRing(r)
+
+	for i := 0; i < 150; i++ {
+		r.Add(fmt.Sprintf("line %d", i))
+	}
+	r.Add("switch timed out")
+
+	req := pocket.Logs{}
+	assert.NoError(t, m.Logs(&req))
+	assert.Equal(t, DefaultLogLimit, len(req.Result))
+	assert.Equal(t, "switch timed out", req.Result[DefaultLogLimit-1])
+
+	req = pocket.Logs{Limit: 2, Match: "line"}
+	assert.NoError(t, m.Logs(&req))
+	assert.Equal(t, []string{"line 148", "line 149"}, req.Result)
+
+	assert.Error(t, m.Logs(&pocket.Logs{Limit: logring.DefaultSize + 1}))
+
+	// answered straight away, like queue
+	m.Serve(pocket.Logs{Command: pocket.Command{ID: "l", Command: "logs"}, Match: "switch"})
+	l, ok := (<-m.s.Response).(pocket.Logs)
+	assert.True(t, ok)
+	assert.Equal(t, "l", l.ID)
+	assert.Equal(t, []string{"switch timed out"}, l.Result)
+
+	m.Serve(pocket.Logs{Command: pocket.Command{Command: "logs"}, Limit: -1})
+	_, ok = (<-m.s.Response).(pocket.CustomResult)
+	assert.True(t, ok)
+}
+
+func TestHistory(t *testing.T) {
+
+	var v pocket.VNA = pocket.NewMock()
+
+	m := Middle{
+		ctx:     context.Background(),
+		timeout: time.Second,
+		h:       measure.NewHardware(&v, rfusb.NewMock()),
+		s: &stream.Stream{
+			Request:  make(chan interface{}, 4),
+			Response: make(chan interface{}, 4),
+		},
+	}
+
+	assert.Error(t, m.History(&pocket.History{}))
+	assert.Error(t, m.LastResult(&pocket.LastResult{}))
+
+	m.SetHistory(history.New(0))
+
+	m.Serve(pocket.RangeQuery{Command: pocket.Command{ID: "1", Command: "rq", Session: "alice"}, What: "dut1", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2})
+	<-m.s.Response
+	m.Serve(pocket.CalibratedRangeQuery{Command: pocket.Command{ID: "2", Command: "crq", Session: "bob"}, What: "dut1"})
+	<-m.s.Response
+	m.Serve(pocket.Hold{Command: pocket.Command{ID: "3", Command: "hq", Session: "alice"}})
+	<-m.s.Response
+
+	req := pocket.History{}
+	assert.NoError(t, m.History(&req))
+	assert.Equal(t, 3, len(req.Result))
+	assert.Equal(t, "rq", req.Result[0].Cmd)
+	assert.Equal(t, "ok", req.Result[0].Outcome)
+	assert.Equal(t, "error", req.Result[1].Outcome)
+
+	// only those of its own session
+	req = pocket.History{Command: pocket.Command{Session: "alice"}, Limit: 1}
+	assert.NoError(t, m.History(&req))
+	assert.Equal(t, 1, len(req.Result))
+	assert.Equal(t, "3", req.Result[0].ID)
+
+	assert.Error(t, m.History(&pocket.History{Limit: history.DefaultSize + 1}))
+
+	// by id, then by cmd, then the last of all
+	lr := pocket.LastResult{Request: "1"}
+	assert.NoError(t, m.LastResult(&lr))
+	assert.Equal(t, "1", lr.Result.(pocket.RangeQuery).ID)
+
+	lr = pocket.LastResult{For: "calibratedrangequery"}
+	assert.NoError(t, m.LastResult(&lr))
+	assert.Equal(t, "not calibrated yet", lr.Result.(pocket.CustomResult).Message)
+
+	lr = pocket.LastResult{}
+	assert.NoError(t, m.LastResult(&lr))
+	assert.Equal(t, "3", lr.Result.(pocket.Hold).ID)
+
+	lr = pocket.LastResult{Command: pocket.Command{Session: "alice"}, Request: "2"}
+	assert.EqualError(t, m.LastResult(&lr), "no response to request 2 is kept")
+
+	// answered straight away, like logs, and not kept themselves
+	m.Serve(pocket.LastResult{Command: pocket.Command{ID: "l", Command: "lastresult"}, For: "rq"})
+	l, ok := (<-m.s.Response).(pocket.LastResult)
+	assert.True(t, ok)
+	assert.Equal(t, "l", l.ID)
+	assert.Equal(t, "1", l.Result.(pocket.RangeQuery).ID)
+
+	m.Serve(pocket.History{Command: pocket.Command{Command: "history"}})
+	h, ok := (<-m.s.Response).(pocket.History)
+	assert.True(t, ok)
+	assert.Equal(t, 3, len(h.Result))
+}
+
+func TestWebhook(t *testing.T) {
+
+	posted := make(chan string, 4)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		posted <- r.Header.Get(webhook.Header) + " " + string(b)
+	}))
+	defer srv.Close()
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+	var v pocket.VNA = mock
+
+	m := Middle{
+		ctx:     context.Background(),
+		timeout: time.Second,
+		h:       measure.NewHardware(&v, rfusb.NewMock()),
+		s: &stream.Stream{
+			Request:  make(chan interface{}, 4),
+			Response: make(chan interface{}, 4),
+		},
+	}
+
+	h, err := webhook.New(srv.URL, "", []string{"rq"})
+	assert.NoError(t, err)
+	m.SetWebhook(h)
+
+	// the responses to other commands, and errors, are not posted
+	m.Serve(pocket.Hold{Command: pocket.Command{Command: "hq"}})
+	<-m.s.Response
+	m.Serve(pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, What: "nowhere", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2})
+	<-m.s.Response
+
+	m.Serve(pocket.RangeQuery{Command: pocket.Command{ID: "7", Command: "rangequery", Session: "alice"}, What: "dut1", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2})
+	sent := <-m.s.Response
+
+	b, err := json.Marshal(sent)
+	assert.NoError(t, err)
+
+	select {
+	case p := <-posted:
+		// as it was sent on the stream, named by its full command
+		assert.Equal(t, "rq "+string(b), p)
+	case <-time.After(time.Second):
+		t.Fatal("response was not posted")
+	}
+
+	select {
+	case p := <-posted:
+		t.Errorf("unexpected post %s", p)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestReplay(t *testing.T) {
+
+	mock := pocket.NewMock()
+	var v pocket.VNA = mock
+
+	m := Middle{
+		ctx:     context.Background(),
+		timeout: time.Second,
+		h:       measure.NewHardware(&v, rfusb.NewMock()),
+		s: &stream.Stream{
+			Request:  make(chan interface{}, 4),
+			Response: make(chan interface{}, 4),
+		},
+	}
+
+	rq := pocket.RangeQuery{Command: pocket.Command{ID: "1", Command: "rq", Session: "alice"}, What: "dut1", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2}
+
+	serve := func(request interface{}) interface{} {
+		m.Serve(request)
+		return <-m.s.Response
+	}
+
+	// not kept unless there is a ttl
+	serve(rq)
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+	assert.Equal(t, 2, len(serve(rq).(pocket.RangeQuery).Result))
+
+	m.SetReplay(time.Minute)
+	mock.ResultRangeQuery = nil
+
+	serve(rq)
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+	// a retry gets the response it missed, without measuring again
+	assert.Equal(t, 0, len(serve(rq).(pocket.RangeQuery).Result))
+	assert.Equal(t, 0, len(serve(pocket.RangeQuery{Command: pocket.Command{ID: "1", Command: "rangequery", Session: "alice"}, What: "dut1", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2}).(pocket.RangeQuery).Result))
+
+	// but not another session, or another cmd with the same id
+	bob := rq
+	bob.Session = "bob"
+	assert.Equal(t, 2, len(serve(bob).(pocket.RangeQuery).Result))
+
+	_, ok := serve(pocket.Hold{Command: pocket.Command{ID: "1", Command: "hq", Session: "alice"}}).(pocket.Hold)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(serve(rq).(pocket.RangeQuery).Result))
+
+	// errors are not kept, so that they can be tried again
+	_, ok = serve(pocket.CalibratedRangeQuery{Command: pocket.Command{ID: "2", Command: "crq"}, What: "dut1"}).(pocket.CustomResult)
+	assert.True(t, ok)
+	_, ok = m.replies[replayKey(pocket.Command{ID: "2"})]
+	assert.False(t, ok)
+
+	// nor after the ttl
+	m.SetReplay(time.Nanosecond)
+	mock.ResultRangeQuery = nil
+	assert.Equal(t, 0, len(serve(rq).(pocket.RangeQuery).Result))
+
+	// the oldest are dropped if there are too many
+	m.SetReplay(time.Minute)
+
+	for i := 0; i < MaxReplies+1; i++ {
+		m.keep(pocket.Hold{Command: pocket.Command{ID: strconv.Itoa(i), Command: "hq"}}, pocket.Hold{})
+	}
+
+	assert.Equal(t, MaxReplies, len(m.replies))
+	_, ok = m.replies[replayKey(pocket.Command{ID: "0"})]
+	assert.False(t, ok)
+}
+
+func TestTimeouts(t *testing.T) {
+
+	m := Middle{
+		ctx:         context.Background(),
+		timeout:     time.Minute,
+		timeoutSoft: 20 * time.Millisecond,
+		timeouts: map[string]config.Timeout{
+			"rc": {Hard: 30 * time.Millisecond},
+		},
+		s: &stream.Stream{
+			Request:  make(chan interface{}),
+			Response: make(chan interface{}, 10),
+		},
+	}
+
+	rq := pocket.RangeQuery{Command: pocket.Command{ID: "1", Session: "bench-3", Command: "rangequery"}}
+
+	// a slow request is still working at each soft timeout, but is not stopped
+	m.attend(rq, false, func(ctx context.Context) interface{} {
+		time.Sleep(70 * time.Millisecond)
+		return "done"
+	})
+
+	w, ok := (<-m.s.Response).(pocket.Working)
+	assert.True(t, ok)
+	assert.Equal(t, "working", w.Command.Command)
+	assert.Equal(t, "1", w.ID)
+	assert.Equal(t, "bench-3", w.Session)
+	assert.Equal(t, "rq", w.For)
+	assert.True(t, w.Elapsed >= 0.02)
+	assert.Equal(t, 60.0, w.Timeout)
+
+	var responses []interface{}
+
+	for len(m.s.Response) > 0 {
+		responses = append(responses, <-m.s.Response)
+	}
+
+	assert.True(t, len(responses) >= 2)
+	assert.Equal(t, "done", responses[len(responses)-1])
+
+	// rc has its own hard timeout, and no soft one
+	rc := rq
+	rc.Command.Command = "rc"
+
+	m.attend(rc, false, func(ctx context.Context) interface{} {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	assert.Equal(t, context.DeadlineExceeded, <-m.s.Response)
+	assert.Equal(t, 0, len(m.s.Response))
+}
+
+func TestCalState(t *testing.T) {
+
+	m := Middle{
+		s: &stream.Stream{
+			Response: make(chan interface{}, 2),
+		},
+	}
+
+	req := pocket.CalState{}
+	assert.NoError(t, m.CalState(&req))
+	assert.Equal(t, "uncalibrated", req.State)
+
+	m.transition(calstate.Start, "")
+
+	e, ok := (<-m.s.Response).(pocket.CalEvent)
+	assert.True(t, ok)
+	assert.Equal(t, "calevent", e.Command.Command)
+	assert.Equal(t, "uncalibrated", e.From)
+	assert.Equal(t, "setup", e.To)
+	assert.Equal(t, "start", e.Event)
+
+	m.transition(calstate.Measure, "short")
+
+	e = (<-m.s.Response).(pocket.CalEvent)
+	assert.Equal(t, "partial", e.To)
+	assert.Equal(t, "short", e.Reason)
+	assert.Equal(t, []string{"short"}, e.Measured)
+	assert.Equal(t, []string{"open", "load", "thru"}, e.Remaining)
+
+	// not allowed, so there is no event
+	m.transition(calstate.Restore, "")
+	assert.Equal(t, 0, len(m.s.Response))
+
+	req = pocket.CalState{}
+	assert.NoError(t, m.CalState(&req))
+	assert.Equal(t, "partial", req.State)
+	assert.Equal(t, []string{"short"}, req.Measured)
+	assert.Equal(t, []string{"open", "load", "thru"}, req.Remaining)
+	assert.False(t, req.Since.IsZero())
+}
+
+func TestResumeCal(t *testing.T) {
+
+	mock := pocket.NewMock()
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+
+	_, err := m.Handle(context.Background(), pocket.ResumeCal{})
+	assert.ErrorIs(t, err, ErrNotInterrupted)
+
+	// nothing to resume if the rc stops before any standard is measured
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1, Select: pocket.SParamSelect{S11: true, S12: true, S21: true, S22: true}}
+	m.transition(calstate.Start, "")
+	m.stopCal(errors.New("the switch did not move"))
+	assert.Equal(t, calstate.Uncalibrated, m.calState.State())
+	assert.Nil(t, m.resume)
+
+	standard := func(s11, s21 float64) []pocket.SParam {
+		return []pocket.SParam{
+			{Freq: 100e6, S11: pocket.Complex{Real: s11}, S22: pocket.Complex{Real: s11}, S21: pocket.Complex{Real: s21}, S12: pocket.Complex{Real: s21}},
+			{Freq: 200e6, S11: pocket.Complex{Real: s11}, S22: pocket.Complex{Real: s11}, S21: pocket.Complex{Real: s21}, S12: pocket.Complex{Real: s21}},
+		}
+	}
+
+	// the thru fails after the others have been measured
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1, Select: pocket.SParamSelect{S11: true, S12: true, S21: true, S22: true}}
+	m.transition(calstate.Start, "")
+	m.short, m.open, m.load = standard(-1, 0), standard(1, 0), standard(0, 0)
+
+	for _, w := range []string{"short", "open", "load"} {
+		m.transition(calstate.Measure, w)
+	}
+
+	m.stopCal(errors.New("no response from the VNA"))
+	assert.Equal(t, calstate.Interrupted, m.calState.State())
+	assert.Nil(t, m.rq)
+	assert.NotNil(t, m.resume)
+
+	req := pocket.CalState{}
+	assert.NoError(t, m.CalState(&req))
+	assert.Equal(t, []string{"thru"}, req.Remaining)
+
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.ErrorIs(t, err, ErrNotCalibrated)
+
+	_, err = m.Handle(context.Background(), pocket.ResumeCal{Redo: []string{"thru"}})
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.Equal(t, calstate.Interrupted, m.calState.State())
+
+	// only the thru is measured
+	mock.ResultRangeQuery = standard(0, 1)
+
+	res, err := m.Handle(context.Background(), pocket.ResumeCal{})
+	assert.NoError(t, err)
+	rc := res.(pocket.ResumeCal)
+	assert.Equal(t, []string{"thru"}, rc.Measured)
+	assert.Equal(t, 2, len(rc.Result))
+	assert.Equal(t, calstate.Calibrated, m.calState.State())
+	assert.Equal(t, []string{"short", "open", "load", "thru"}, m.calState.Measured())
+
+	var swept []string
+
+	for _, c := range mock.CommandsReceived {
+		if rq, ok := c.(pocket.RangeQuery); ok {
+			swept = append(swept, rq.What)
+		}
+	}
+
+	assert.Equal(t, []string{"thru"}, swept)
+
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.NoError(t, err)
+
+	_, err = m.Handle(context.Background(), pocket.ResumeCal{})
+	assert.ErrorIs(t, err, ErrNotInterrupted)
+}
+
+func TestGrid(t *testing.T) {
+
+	assert.Equal(t, "86179678ec3486f0", GridID([]uint64{100e6, 200e6}))
+	assert.NotEqual(t, GridID([]uint64{100e6, 200e6}), GridID([]uint64{100e6, 200000001}))
+	assert.Equal(t, "", GridOf(nil))
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+
+	_, err := m.Handle(context.Background(), pocket.GetGrid{})
+	assert.ErrorIs(t, err, ErrNotCalibrated)
+
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1}
+	m.std = &calibration.Standards{Short: mock.ResultRangeQuery}
+	m.terms = []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}}
+
+	res, err := m.Handle(context.Background(), pocket.GetGrid{})
+	assert.NoError(t, err)
+	g := res.(pocket.GetGrid)
+	assert.Equal(t, "86179678ec3486f0", g.Grid)
+	assert.Equal(t, []uint64{100e6, 200e6}, g.Frequencies)
+
+	// on the results, raw and calibrated
+	res, err = m.Handle(context.Background(), pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, What: "dut1", Frequencies: g.Frequencies})
+	assert.NoError(t, err)
+	assert.Equal(t, g.Grid, res.(pocket.RangeQuery).Meta.Grid)
+
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Grid: g.Grid})
+	assert.NoError(t, err)
+	assert.Equal(t, g.Grid, res.(pocket.CalibratedRangeQuery).Meta.Grid)
+
+	// the cal has changed since
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Grid: "0123456789abcdef"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "the cal is on grid 86179678ec3486f0, not 0123456789abcdef")
+}
+
+func TestReset(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+
+	released := 0
+	m.SetRelease(func() error { released++; return nil })
+
+	calibrate := func() {
+		m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1}
+		m.std = &calibration.Standards{Short: mock.ResultRangeQuery}
+		m.terms = []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}}
+		for _, e := range []calstate.Event{calstate.Start, calstate.Measure, calstate.Complete} {
+			m.transition(e, "short")
+		}
+	}
+
+	calibrate()
+
+	// the cal is kept if asked
+	res, err := m.Handle(context.Background(), pocket.Reset{KeepCal: true})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vna", "switch"}, res.(pocket.Reset).Reopened)
+	assert.Equal(t, 1, released)
+	assert.Equal(t, calstate.Calibrated, m.calState.State())
+
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.NoError(t, err)
+
+	// the release returned by the VNA is used from then on
+	_, err = m.Handle(context.Background(), pocket.Reset{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, released)
+	assert.Equal(t, calstate.Uncalibrated, m.calState.State())
+
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.ErrorIs(t, err, ErrNotCalibrated)
+
+	// the rest are still opened
+	calibrate()
+	mock.ConnectError = errors.New("no device")
+
+	res, err = m.Handle(context.Background(), pocket.Reset{})
+	assert.Error(t, err)
+	assert.Equal(t, "reset could not open the VNA because no device", err.Error())
+	assert.Equal(t, []string{"switch"}, res.(pocket.Reset).Reopened)
+	assert.Equal(t, calstate.Uncalibrated, m.calState.State())
+}
+
+func TestFlashSwitch(t *testing.T) {
+
+	var v pocket.VNA = pocket.NewMock()
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+
+	hex := ":0400000001020304F2\n:00000001FF\n"
+
+	_, err := m.Handle(context.Background(), pocket.FlashSwitch{Hex: ":0400000001020304F2\n"})
+	assert.ErrorIs(t, err, ErrInvalid)
+	assert.Contains(t, err.Error(), "hex is not a firmware image")
+
+	_, err = m.Handle(context.Background(), pocket.FlashSwitch{Hex: hex, Baud: -1})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// only the arduino can be flashed, not e.g. the mock
+	res, err := m.Handle(context.Background(), pocket.FlashSwitch{Hex: hex})
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrInvalid)
+	assert.Contains(t, err.Error(), "cannot be flashed")
+	assert.Empty(t, res.(pocket.FlashSwitch).Hex)
+	assert.Equal(t, 0, res.(pocket.FlashSwitch).Written)
+}
+
+func TestProtocolTest(t *testing.T) {
+
+	var v pocket.VNA = pocket.NewMock()
+
+	sw := rfusb.NewMock()
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, sw), calibration.Native{}, time.Second)
+
+	m.SetWear(wear.New())
+
+	assert.NoError(t, sw.SetPort(context.Background(), "dut1"))
+
+	_, err := m.Handle(context.Background(), pocket.ProtocolTest{Toggles: rfusb.MaxToggles + 1})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	res, err := m.Handle(context.Background(), pocket.ProtocolTest{Toggles: 2})
+	assert.NoError(t, err)
+
+	c := res.(pocket.ProtocolTest).Result
+	assert.True(t, c.Passed, "%+v", c.Checks)
+	assert.Empty(t, c.Failed)
+	assert.Equal(t, 1, c.Protocol)
+	assert.Equal(t, "dut1", c.Port)
+	assert.Equal(t, "version", c.Checks[0].Name)
+
+	// the moves are counted as wear
+	assert.Equal(t, uint64(2), m.h.Wear.Wear().Counts["short"])
+	assert.Equal(t, uint64(2), m.h.Wear.Wear().Counts["dut1"])
+
+	// the switch is still where it was
+	assert.Equal(t, "dut1", sw.Get())
+}
+
+func TestSwitchWear(t *testing.T) {
+
+	var v pocket.VNA = pocket.NewMock()
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+
+	_, err := m.Handle(context.Background(), pocket.SwitchWear{})
+	assert.Error(t, err)
+
+	m.SetWear(wear.New())
+	m.h.Wear.SetRated(2)
+
+	for _, what := range []string{"short", "short", "open", "short"} {
+		_, err = m.Handle(context.Background(), pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, What: what, Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1})
+		assert.NoError(t, err)
+	}
+
+	res, err := m.Handle(context.Background(), pocket.SwitchWear{})
+	assert.NoError(t, err)
+	w := res.(pocket.SwitchWear).Result
+	assert.Equal(t, map[string]uint64{"short": 2, "open": 1}, w.Counts)
+	assert.Equal(t, []string{"short"}, w.Worn)
+
+	// a worn switch needs attention
+	assert.Contains(t, m.Health().Problems, "the switch has reached its rated moves to short, and should be replaced")
+
+	res, err = m.Handle(context.Background(), pocket.SwitchWear{Reset: true})
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), res.(pocket.SwitchWear).Result.Total)
+}
+
+func TestSwitchTraffic(t *testing.T) {
+
+	var v pocket.VNA = pocket.NewMock()
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+
+	// nothing to record until there is a recorder
+	assert.Error(t, m.SwitchTraffic(&pocket.SwitchTraffic{}))
+
+	m.SetTraffic(rfusb.NewTraffic(rfusb.DefaultTraffic))
+
+	// not recording yet
+	_, err := m.Handle(context.Background(), pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, What: "short", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1})
+	assert.NoError(t, err)
+
+	req := pocket.SwitchTraffic{}
+	assert.NoError(t, m.SwitchTraffic(&req))
+	assert.False(t, req.Recording)
+	assert.Empty(t, req.Result)
+
+	on := true
+	req = pocket.SwitchTraffic{Record: &on}
+	assert.NoError(t, m.SwitchTraffic(&req))
+	assert.True(t, req.Recording)
+
+	_, err = m.Handle(context.Background(), pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, What: "open", Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1})
+	assert.NoError(t, err)
+
+	req = pocket.SwitchTraffic{}
+	assert.NoError(t, m.SwitchTraffic(&req))
+	assert.NotEmpty(t, req.Result)
+	assert.Contains(t, req.Result[0], " tx ")
+	assert.Contains(t, req.Result[0], `"to":"open"`)
+
+	req = pocket.SwitchTraffic{Limit: 1}
+	assert.NoError(t, m.SwitchTraffic(&req))
+	assert.Len(t, req.Result, 1)
+
+	assert.ErrorIs(t, m.SwitchTraffic(&pocket.SwitchTraffic{Limit: rfusb.DefaultTraffic + 1}), ErrInvalid)
+	assert.ErrorIs(t, m.SwitchTraffic(&pocket.SwitchTraffic{Limit: -1}), ErrInvalid)
+
+	off := false
+	req = pocket.SwitchTraffic{Record: &off, Clear: true}
+	assert.NoError(t, m.SwitchTraffic(&req))
+	assert.False(t, req.Recording)
+	assert.Empty(t, req.Result)
+}
+
+func TestExportCal(t *testing.T) {
+
+	m := Middle{}
+
+	assert.Error(t, m.ExportCal(&pocket.ExportCal{}))
+
+	s := []pocket.SParam{
+		{Freq: 100e6, S11: pocket.Complex{Real: -1}},
+		{Freq: 200e6, S11: pocket.Complex{Imag: -1}},
+	}
+
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 3}
+	m.std = &calibration.Standards{Short: s, Open: s, Load: s, Thru: s}
+	m.switchTerms = [][2]complex128{{0.1, 0.2i}, {0.3, 0.4i}}
+	m.calAt = time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	req := pocket.ExportCal{}
+	assert.NoError(t, m.ExportCal(&req))
+	assert.Equal(t, "cal-20231001T120000Z.zip", req.Name)
+	assert.Empty(t, req.Saved)
+
+	// nowhere to save it
+	assert.ErrorIs(t, m.ExportCal(&pocket.ExportCal{Save: true}), ErrInvalid)
+
+	st, err := store.NewDir(t.TempDir())
+	assert.NoError(t, err)
+	m.SetStore(st)
+
+	saved := pocket.ExportCal{Save: true}
+	assert.NoError(t, m.ExportCal(&saved))
+	assert.Equal(t, "cals/cal-20231001T120000Z.zip", saved.Saved)
+	kept, err := st.Get(saved.Saved)
+	assert.NoError(t, err)
+	assert.Equal(t, saved.Result, kept)
+
+	z, err := zip.NewReader(bytes.NewReader(req.Result), int64(len(req.Result)))
+	assert.NoError(t, err)
+
+	files := make(map[string]string)
+
+	for _, f := range z.File {
+		r, err := f.Open()
+		assert.NoError(t, err)
+		b, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		files[f.Name] = string(b)
+	}
+
+	// no cal kit, so no ideals
+	assert.Equal(t, 5, len(files))
+
+	d, err := touchstone.Parse(files["short.s2p"])
+	assert.NoError(t, err)
+	assert.Equal(t, s, d.SParam)
+
+	var a pocket.CalArchive
+	assert.NoError(t, json.Unmarshal([]byte(files["calibration.json"]), &a))
+	assert.Equal(t, []uint64{100e6, 200e6}, a.Frequencies)
+	assert.Equal(t, uint16(3), a.Avg)
+	assert.Equal(t, "thru.s2p", a.Files["thru"])
+	assert.Equal(t, 2, len(a.SwitchTerms))
+	assert.Equal(t, 0.4, a.SwitchTerms[1].Reverse.Imag)
+}
+
+func TestCompareCal(t *testing.T) {
+
+	m := Middle{}
+
+	// the raw standards, as seen through error terms e, at each frequency
+	standards := func(e twoport.ErrorTerms) *calibration.Standards {
+
+		s := &calibration.Standards{}
+
+		for _, f := range []uint64{100e6, 150e6, 200e6} {
+			s.Short = append(s.Short, e.Measure(twoport.Ideal.Short).SParam(f))
+			s.Open = append(s.Open, e.Measure(twoport.Ideal.Open).SParam(f))
+			s.Load = append(s.Load, e.Measure(twoport.Ideal.Load).SParam(f))
+			s.Thru = append(s.Thru, e.Measure(twoport.Ideal.Thru).SParam(f))
+		}
+
+		return s
+	}
+
+	e := twoport.ErrorTerms{
+		Edf: 0.01, Esf: 0.02i, Erf: 0.9, Etf: 0.8, Elf: 0.03, Exf: 0.001,
+		Edr: 0.01i, Esr: 0.02, Err: 0.85, Etr: 0.75i, Elr: 0.04, Exr: 0.002,
+	}
+
+	_, err := m.Handle(context.Background(), pocket.CompareCal{A: CurrentCal})
+	assert.ErrorIs(t, err, ErrNotCalibrated)
+
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 3}
+	m.std = standards(e)
+	m.calAt = time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	_, err = m.Handle(context.Background(), pocket.CompareCal{})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// nowhere to load it from
+	_, err = m.Handle(context.Background(), pocket.CompareCal{A: "cal-20231001T120000Z"})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	st, err := store.NewDir(t.TempDir())
+	assert.NoError(t, err)
+	m.SetStore(st)
+
+	_, err = m.Handle(context.Background(), pocket.CompareCal{A: "cal-20231001T120000Z"})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	assert.NoError(t, m.ExportCal(&pocket.ExportCal{Save: true}))
+
+	// the same cal, so nothing has changed
+	res, err := m.Handle(context.Background(), pocket.CompareCal{A: "cal-20231001T120000Z"})
+	assert.NoError(t, err)
+
+	c := res.(pocket.CompareCal)
+	assert.Equal(t, CurrentCal, c.B)
+	assert.Equal(t, m.calAt, c.ATime)
+	assert.Equal(t, m.calAt, c.BTime)
+	assert.Equal(t, 3, len(c.Result))
+	assert.Equal(t, uint64(150e6), c.Result[1].Freq)
+	assert.InDelta(t, 0, c.Max.Thru.S21.Mag, 1e-6)
+	assert.Less(t, c.Max.Thru.S11.Mag, -100.0)
+	assert.InDelta(t, 0, c.Max.Terms.Etf.Mag, 1e-6)
+
+	// the transmission tracking has dropped, and the cal has been made again over part of the range
+	e.Etf *= 0.9
+	e.Edf *= 2
+	m.std = standards(e)
+	m.std.Short = m.std.Short[1:]
+	m.std.Open = m.std.Open[1:]
+	m.std.Load = m.std.Load[1:]
+	m.std.Thru = m.std.Thru[1:]
+	m.calAt = m.calAt.Add(time.Hour)
+
+	res, err = m.Handle(context.Background(), pocket.CompareCal{A: "cals/cal-20231001T120000Z.zip", B: CurrentCal})
+	assert.NoError(t, err)
+
+	c = res.(pocket.CompareCal)
+	assert.Equal(t, 2, len(c.Result))
+	assert.Equal(t, time.Hour, c.BTime.Sub(c.ATime))
+
+	// the thru is a little off the drop in tracking, since the directivity has changed too
+	for _, d := range c.Result {
+		assert.InDelta(t, 20*math.Log10(0.9), d.Thru.S21.Mag, 0.01)
+		assert.InDelta(t, 0, d.Thru.S12.Mag, 0.01)
+		assert.InDelta(t, 20*math.Log10(0.9), d.Terms.Etf.Mag, 1e-6)
+		assert.InDelta(t, 20*math.Log10(2), d.Terms.Edf.Mag, 1e-6)
+		assert.InDelta(t, 0, d.Terms.Etr.Mag, 1e-6)
+	}
+
+	assert.InDelta(t, 20*math.Log10(0.9), c.Max.Thru.S21.Mag, 0.01)
+	assert.InDelta(t, 20*math.Log10(2), c.Max.Terms.Edf.Mag, 1e-6)
+
+	// and the other way round, the saved cal only covers part of the current one
+	res, err = m.Handle(context.Background(), pocket.CompareCal{A: CurrentCal, B: "cal-20231001T120000Z.zip"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res.(pocket.CompareCal).Result))
+
+	b, err := json.Marshal(res)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"edf":{"mag":-6.0`)
+
+	assert.Equal(t, pocket.Value{}, change(0, 1))
+}
+
+func TestBench(t *testing.T) {
+
+	m := Middle{}
+
+	req := pocket.Bench{Size: 11, Repeat: 2}
+	assert.NoError(t, m.Bench(context.Background(), &req))
+	assert.Equal(t, 6, len(req.Result))
+
+	for _, r := range req.Result {
+		if r.Stage == "calibrate" {
+			assert.Equal(t, "there is no calibration backend", r.Error)
+			continue
+		}
+		assert.Equal(t, "", r.Error, r.Stage)
+		assert.True(t, r.Max >= r.Mean, r.Stage)
+	}
+
+	m.cal = calibration.Native{}
+
+	req = pocket.Bench{}
+	assert.NoError(t, m.Bench(context.Background(), &req))
+	assert.Equal(t, DefaultBenchSize, req.Size)
+	assert.Equal(t, "calibrate", req.Result[3].Stage)
+	assert.Equal(t, "", req.Result[3].Error)
+	assert.True(t, req.Total > 0)
+
+	assert.Error(t, m.Bench(context.Background(), &pocket.Bench{Size: MaxBenchSize + 1}))
+	assert.Error(t, m.Bench(context.Background(), &pocket.Bench{Repeat: -1}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Error(t, m.Bench(ctx, &pocket.Bench{}))
+}
+
+func TestState(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+	mock.PrepareDelay = 50 * time.Millisecond
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+
+	rc := pocket.RangeQuery{Command: pocket.Command{Command: "rc"}, Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := m.Handle(ctx, rc)
+	assert.Error(t, err)
+
+	// the rc is still measuring the standards, so a crq waits for it, rather than
+	// racing with it (run with -race), unless it runs out of time first
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = m.Handle(ctx, pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.Error(t, err)
+	assert.Equal(t, "timeout", err.Error())
+
+	start := time.Now()
+
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.Error(t, err)
+	assert.Equal(t, "not calibrated yet", err.Error()) // the standards are all the same
+	assert.True(t, time.Since(start) > 50*time.Millisecond)
+
+	// requests in a batch go straight ahead, as the batch already holds the state
+	hq := pocket.Hold{Command: pocket.Command{Command: "hq"}}
+	b := pocket.Batch{Requests: []interface{}{hq, hq}}
+	res, err := m.Handle(context.Background(), b)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res.(pocket.Batch).Results))
+
+	// a batch that times out holds the state until the command it was doing is done,
+	// so the next request waits for that too
+	b = pocket.Batch{Requests: []interface{}{rc}}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = m.Handle(ctx, b)
+	assert.Error(t, err)
+
+	start = time.Now()
+
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) > 50*time.Millisecond)
+}
+
+func TestDryRun(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+	var v pocket.VNA = mock
+
+	sw := rfusb.NewMock()
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, sw), calibration.Native{}, time.Second)
+	m.SetSettling(0, map[string]time.Duration{"thru": 100 * time.Millisecond})
+	assert.NoError(t, m.SetSwitchStandards([]string{"dut1", "dut3"}))
+
+	dry := pocket.Command{Command: "rc", DryRun: true}
+
+	rc := pocket.RangeQuery{Command: dry, Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 100, Avg: 2}
+
+	res, err := m.Handle(context.Background(), rc)
+	assert.NoError(t, err)
+
+	p, ok := res.(pocket.Plan)
+	assert.True(t, ok)
+	assert.True(t, p.DryRun)
+	assert.Equal(t, 6, len(p.Steps))
+	assert.Equal(t, "short", p.Steps[0].What)
+	assert.Equal(t, "dut3", p.Steps[5].What)
+	assert.Equal(t, 100, p.Steps[0].Points)
+	assert.Equal(t, 6, p.Sweeps)
+	assert.InDelta(t, (SwitchTime + 200*PointTime).Seconds(), p.Steps[0].Estimate, 1e-9)
+	assert.InDelta(t, (SwitchTime + 100*time.Millisecond + 200*PointTime).Seconds(), p.Steps[3].Estimate, 1e-9)
+	assert.InDelta(t, 6*(SwitchTime+200*PointTime).Seconds()+0.1, p.Estimate, 1e-9)
+
+	// nothing was measured, or changed
+	assert.Empty(t, mock.CommandsReceived)
+	assert.Equal(t, "unknown", sw.Get())
+	assert.Nil(t, m.rq)
+	assert.Equal(t, calstate.Uncalibrated, m.calState.State())
+
+	// the request is checked as it would be if it were done
+	rc.Size = 1
+	_, err = m.Handle(context.Background(), rc)
+	assert.Error(t, err)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	crq := pocket.CalibratedRangeQuery{Command: pocket.Command{Command: "crq", DryRun: true}, What: "dut1"}
+	_, err = m.Handle(context.Background(), crq)
+	assert.ErrorIs(t, err, ErrNotCalibrated)
+
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2}
+
+	crq.Sweeps = 3
+	b := pocket.Batch{Command: pocket.Command{Command: "batch", DryRun: true}, Requests: []interface{}{crq, pocket.Hold{Command: pocket.Command{Command: "hq"}}, crq}}
+	res, err = m.Handle(context.Background(), b)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res.(pocket.Plan).Steps))
+	assert.Equal(t, 6, res.(pocket.Plan).Sweeps)
+
+	crq.Format = "nope"
+	_, err = m.Handle(context.Background(), crq)
+	assert.Error(t, err)
+}
+
+func TestEstimate(t *testing.T) {
+
+	var v pocket.VNA = pocket.NewMock()
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.SetSettling(10*time.Millisecond, map[string]time.Duration{"thru": 100 * time.Millisecond})
+
+	req := pocket.Estimate{}
+	assert.Error(t, m.Estimate(&req)) // no size, and no cal to take it from
+
+	req = pocket.Estimate{Size: 100, Avg: 2, Sweeps: 3, Positions: 2}
+	assert.NoError(t, m.Estimate(&req))
+	assert.False(t, req.Learned)
+	assert.Equal(t, PointTime.Seconds(), req.PointTime)
+	assert.InDelta(t, 2*(SwitchTime+10*time.Millisecond+600*PointTime).Seconds(), req.Result, 1e-9)
+
+	// the size is that of the cal, and the thru settles for longer
+	m.rq = &pocket.RangeQuery{Size: 201}
+	req = pocket.Estimate{Cal: true}
+	assert.NoError(t, m.Estimate(&req))
+	assert.InDelta(t, (4*(SwitchTime+201*PointTime) + 130*time.Millisecond).Seconds(), req.Result, 1e-9)
+
+	_, err := m.Handle(context.Background(), pocket.Estimate{Command: pocket.Command{Command: "estimate"}, Size: 10, Avg: 5000})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// learned from the sweeps made
+	rq := pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, What: "dut1"}
+	_, err = m.Handle(context.Background(), rq)
+	assert.NoError(t, err)
+
+	req = pocket.Estimate{Size: 100}
+	assert.NoError(t, m.Estimate(&req))
+	assert.True(t, req.Learned)
+	assert.Equal(t, m.h.PointTime().Seconds(), req.PointTime)
+}
+
+// noisy is a VNA whose s11 is 0.01 higher and lower in turn, on each sweep
+type noisy struct {
+	*pocket.Mock
+	n int
+}
+
+func (v *noisy) RangeQuery(command interface{}) error {
+
+	err := v.Mock.RangeQuery(command)
+
+	c := command.(*pocket.RangeQuery)
+
+	d := 0.01
+
+	if v.n%2 == 1 {
+		d = -d
+	}
+
+	v.n++
+
+	c.Result = append([]pocket.SParam(nil), c.Result...)
+
+	for i := range c.Result {
+		c.Result[i].S11.Real += d
+	}
+
+	return err
+}
+
+func TestAdapt(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+	var v pocket.VNA = &noisy{Mock: mock}
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1}
+	m.terms = []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}}
+
+	// the two sweeps differ by 0.02, so the noise is 0.0141, and 6 readings brings it down to 0.006
+	crq := pocket.CalibratedRangeQuery{What: "dut1", Select: pocket.SParamSelect{S11: true}, Target: 0.006}
+
+	res, err := m.Handle(context.Background(), crq)
+	assert.NoError(t, err)
+
+	r := res.(pocket.CalibratedRangeQuery)
+	assert.Equal(t, uint16(6), r.Avg)
+	assert.Equal(t, 6, r.Meta.Averaging.Avg)
+	assert.InDelta(t, 0.02/math.Sqrt2, r.Meta.Averaging.Noise, 1e-9)
+	assert.Equal(t, 0.006, r.Meta.Averaging.Target)
+	assert.False(t, r.Meta.Averaging.Limited)
+
+	// two quick sweeps, then the measurement with the avg chosen
+	n := len(mock.CommandsReceived)
+	assert.Equal(t, uint16(1), mock.CommandsReceived[n-2].(pocket.RangeQuery).Avg)
+	assert.Equal(t, uint16(6), mock.CommandsReceived[n-1].(pocket.RangeQuery).Avg)
+
+	crq.MaxAvg = 4
+	res, err = m.Handle(context.Background(), crq)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, res.(pocket.CalibratedRangeQuery).Meta.Averaging.Avg)
+	assert.True(t, res.(pocket.CalibratedRangeQuery).Meta.Averaging.Limited)
+
+	// the cal keeps its own avg
+	assert.Equal(t, uint16(1), m.rq.Avg)
+
+	crq.Target = -1
+	_, err = m.Handle(context.Background(), crq)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// no target, no averaging in the meta
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.NoError(t, err)
+	assert.Nil(t, res.(pocket.CalibratedRangeQuery).Meta.Averaging)
+}
+
+func TestSmooth(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{
+		{Freq: 100e6}, {Freq: 200e6}, {Freq: 300e6, S11: pocket.Complex{Real: 0.3}}, {Freq: 400e6}, {Freq: 500e6},
+	}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 500e6}, Size: 5, Avg: 1}
+	m.terms = make([]twoport.ErrorTerms, 5)
+
+	for i := range m.terms {
+		m.terms[i] = twoport.ErrorTerms{Erf: 1, Etf: 1, Err: 1, Etr: 1}
+	}
+
+	crq := pocket.CalibratedRangeQuery{What: "dut1", Smooth: &pocket.Smoothing{Method: "Mean", Aperture: 3}}
+
+	res, err := m.Handle(context.Background(), crq)
+	assert.NoError(t, err)
+
+	r := res.(pocket.CalibratedRangeQuery)
+
+	// the spike is spread over its neighbours, and the ends use the first and last three points
+	for i, want := range []float64{0.1, 0.1, 0.1, 0.1, 0.1} {
+		assert.InDelta(t, want, r.Result[i].S11.Real, 1e-9)
+	}
+
+	assert.Equal(t, &pocket.Smoothing{Method: "mean", Aperture: 3}, r.Meta.Smoothing)
+	assert.Contains(t, r.Meta.Applied, "smooth")
+
+	// the dut is kept as it was measured
+	assert.InDelta(t, 0.3, m.dutcal[2].S11.Real, 1e-9)
+
+	// sg fills in its order
+	crq.Smooth = &pocket.Smoothing{Method: "sg", Aperture: 5}
+	res, err = m.Handle(context.Background(), crq)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, res.(pocket.CalibratedRangeQuery).Meta.Smoothing.Order)
+
+	crq.Smooth = &pocket.Smoothing{Method: "mean", Aperture: 7}
+	_, err = m.Handle(context.Background(), crq)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	crq.Smooth = &pocket.Smoothing{Method: "median", Aperture: 3}
+	_, err = m.Handle(context.Background(), crq)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	// not smoothed unless asked
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.NoError(t, err)
+	assert.Nil(t, res.(pocket.CalibratedRangeQuery).Meta.Smoothing)
+	assert.InDelta(t, 0.3, res.(pocket.CalibratedRangeQuery).Result[2].S11.Real, 1e-9)
+}
+
+func TestPipeline(t *testing.T) {
+
+	assert.NoError(t, CheckPipeline(nil))
+	assert.NoError(t, CheckPipeline([]string{"calibrate", "smooth", "portext"}))
+	assert.EqualError(t, CheckPipeline([]string{"portext", "calibrate"}), "pipeline must start with calibrate, not portext")
+	assert.EqualError(t, CheckPipeline([]string{"calibrate", "smooth", "smooth"}), "pipeline has smooth more than once")
+	assert.Contains(t, CheckPipeline([]string{"calibrate", "fft"}).Error(), "pipeline stage must be one of calibrate, deembed, portext")
+	assert.Equal(t, "columns", DefaultPipeline()[len(DefaultPipeline())-1])
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 150e6}, {Freq: 200e6}}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 3, Avg: 1}
+	m.terms = []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}}
+	m.portext = pocket.Extension{Port1: 1e-12}
+
+	sm := &pocket.Smoothing{Method: "mean", Aperture: 3}
+
+	res, err := m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Smooth: sm})
+	assert.NoError(t, err)
+	crq := res.(pocket.CalibratedRangeQuery)
+	assert.Equal(t, []string{"portext", "smooth"}, crq.Meta.Applied)
+	assert.NotNil(t, crq.Extension)
+
+	// in the order given, and only those given
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Smooth: sm, Pipeline: []string{"calibrate", "smooth", "portext"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"smooth", "portext"}, res.(pocket.CalibratedRangeQuery).Meta.Applied)
+
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Smooth: sm, Format: "db", Pipeline: []string{"calibrate"}})
+	assert.NoError(t, err)
+	crq = res.(pocket.CalibratedRangeQuery)
+	assert.Empty(t, crq.Meta.Applied)
+	assert.Nil(t, crq.Extension)
+	assert.Nil(t, crq.Formatted)
+	assert.Equal(t, 3, len(crq.Result))
+
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Pipeline: []string{"smooth"}})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = m.Plan(pocket.CalibratedRangeQuery{What: "dut1", Pipeline: []string{"calibrate", "nope"}})
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestColumns(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6, S21: pocket.Complex{Real: 0.5, Imag: -0.5}}, {Freq: 200e6}}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1}
+	m.terms = []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}}
+
+	res, err := m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Layout: "columns"})
+	assert.NoError(t, err)
+	crq := res.(pocket.CalibratedRangeQuery)
+	assert.Nil(t, crq.Result)
+	assert.Equal(t, []uint64{100e6, 200e6}, crq.Columns.Freq)
+	assert.Equal(t, 2, len(crq.Columns.S21.Real))
+	assert.Equal(t, "complex128", crq.Columns.DTypes["s21"])
+	assert.Contains(t, crq.Meta.Loaders, "numpy")
+	assert.Contains(t, crq.Meta.Loaders, "matlab")
+
+	// the columns are not part of what identifies a cached result
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Layout: "columns", MaxAge: 10})
+	assert.NoError(t, err)
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Layout: "columns", MaxAge: 10})
+	assert.NoError(t, err)
+	assert.True(t, res.(pocket.CalibratedRangeQuery).Cached)
+	assert.NotNil(t, res.(pocket.CalibratedRangeQuery).Columns)
+
+	// the usual layout
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Layout: "points"})
+	assert.NoError(t, err)
+	crq = res.(pocket.CalibratedRangeQuery)
+	assert.Nil(t, crq.Columns)
+	assert.Equal(t, 2, len(crq.Result))
+	assert.Nil(t, crq.Meta.Loaders)
+
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Layout: "rows"})
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestPhaseDelay(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6, S21: pocket.Complex{Real: 1}}, {Freq: 150e6, S21: pocket.Complex{Imag: -1}}, {Freq: 200e6, S21: pocket.Complex{Real: -1}}}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 3, Avg: 1}
+	m.terms = []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}}
+
+	res, err := m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", PhaseDelay: &pocket.PhaseDelay{}})
+	assert.NoError(t, err)
+	pd := res.(pocket.CalibratedRangeQuery).PhaseDelay
+	assert.Equal(t, 3, pd.Aperture)
+	assert.Equal(t, 3, len(pd.Phase))
+	assert.Equal(t, 3, len(pd.Delay))
+
+	// the phase and delay are not part of what identifies a cached result
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", PhaseDelay: &pocket.PhaseDelay{}, MaxAge: 10})
+	assert.NoError(t, err)
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", PhaseDelay: &pocket.PhaseDelay{Aperture: 3}, MaxAge: 10})
+	assert.NoError(t, err)
+	assert.True(t, res.(pocket.CalibratedRangeQuery).Cached)
+	assert.Equal(t, 3, len(res.(pocket.CalibratedRangeQuery).PhaseDelay.Delay))
+
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", PhaseDelay: &pocket.PhaseDelay{Aperture: 2}})
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", PhaseDelay: &pocket.PhaseDelay{Aperture: 5}})
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestReduce(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = make([]pocket.SParam, 8)
+
+	for i := range mock.ResultRangeQuery {
+		mock.ResultRangeQuery[i] = pocket.SParam{Freq: uint64(i+1) * 100e6, S21: pocket.Complex{Real: 0.123456789}}
+	}
+
+	// a notch that taking every other point would miss
+	mock.ResultRangeQuery[5].S21.Real = 0.01
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 800e6}, Size: 8, Avg: 1}
+	m.terms = make([]twoport.ErrorTerms, 8)
+
+	for i := range m.terms {
+		m.terms[i] = twoport.ErrorTerms{Erf: 1, Etf: 1, Err: 1, Etr: 1}
+	}
+
+	crq := pocket.CalibratedRangeQuery{What: "dut1", Select: pocket.SParamSelect{S21: true}, Format: "ma", PhaseDelay: &pocket.PhaseDelay{}, Display: 4, Digits: 3}
+
+	res, err := m.Handle(context.Background(), crq)
+	assert.NoError(t, err)
+	r := res.(pocket.CalibratedRangeQuery)
+
+	assert.Equal(t, 3, len(r.Result))
+	assert.Contains(t, r.Result, pocket.SParam{Freq: 600e6, S21: pocket.Complex{Real: 0.01}})
+	assert.Equal(t, 0.123, r.Result[0].S21.Real)
+	assert.Equal(t, 3, len(r.Formatted))
+	assert.Equal(t, 3, len(r.PhaseDelay.Delay))
+	assert.Equal(t, &pocket.Reduction{Digits: 3, Points: 3, Of: 8}, r.Meta.Reduced)
+
+	// an searches every point, at full precision
+	assert.Equal(t, 8, len(m.dutcal))
+	assert.Equal(t, 0.123456789, m.dutcal[0].S21.Real)
+
+	// full precision unless asked
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.NoError(t, err)
+	assert.Equal(t, 8, len(res.(pocket.CalibratedRangeQuery).Result))
+	assert.Nil(t, res.(pocket.CalibratedRangeQuery).Meta.Reduced)
+
+	rq := pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, What: "dut1", Range: pocket.Range{Start: 100e6, End: 800e6}, Size: 8, Avg: 1, Display: 2, Digits: 2}
+	res, err = m.Handle(context.Background(), rq)
+	assert.NoError(t, err)
+	assert.Equal(t, []pocket.SParam{{Freq: 100e6, S21: pocket.Complex{Real: 0.12}}, {Freq: 600e6, S21: pocket.Complex{Real: 0.01}}}, res.(pocket.RangeQuery).Result)
+	assert.Equal(t, &pocket.Reduction{Digits: 2, Points: 2, Of: 8}, res.(pocket.RangeQuery).Meta.Reduced)
+
+	rq.Display = 1
+	_, err = m.Handle(context.Background(), rq)
+	assert.ErrorIs(t, err, ErrInvalid)
+
+	crq.Digits = 18
+	_, err = m.Handle(context.Background(), crq)
+	assert.ErrorIs(t, err, ErrInvalid)
+}
+
+func TestUncertainty(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6, S21: pocket.Complex{Real: 1}}, {Freq: 200e6, S21: pocket.Complex{Real: 1}}}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1}
+	m.terms = []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}}
+
+	// no noise to go on
+	_, err := m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Uncertainty: true})
+	assert.ErrorIs(t, err, ErrNoNoise)
+
+	res, err := m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Uncertainty: true, Sweeps: 3})
+	assert.NoError(t, err)
+	crq := res.(pocket.CalibratedRangeQuery)
+	assert.Equal(t, 2, len(crq.Bounds))
+	assert.Equal(t, uint64(200e6), crq.Bounds[1].Freq)
+	assert.Equal(t, "the 3 sweeps of this result", crq.Meta.Noise)
+
+	_, err = m.Handle(context.Background(), pocket.NoiseFloor{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1, Sweeps: 2})
+	assert.NoError(t, err)
+
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Uncertainty: true})
+	assert.NoError(t, err)
+	crq = res.(pocket.CalibratedRangeQuery)
+	assert.Equal(t, 2, len(crq.Bounds))
+	assert.Equal(t, "the noise floor measured with nf", crq.Meta.Noise)
+
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.NoError(t, err)
+	assert.Nil(t, res.(pocket.CalibratedRangeQuery).Bounds)
+}
+
+func TestSmith(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1}
+	m.terms = []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}}
+
+	res, err := m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Smith: true})
+	assert.NoError(t, err)
+	crq := res.(pocket.CalibratedRangeQuery)
+	assert.Equal(t, 2, len(crq.SmithChart))
+	assert.Equal(t, uint64(200e6), crq.SmithChart[1].Freq)
+	assert.NotNil(t, crq.SmithChart[0].S11)
+	assert.Equal(t, 2, len(crq.Result))
+
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.NoError(t, err)
+	assert.Nil(t, res.(pocket.CalibratedRangeQuery).SmithChart)
+
+	// the chart is not part of what identifies a cached result
+	_, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Smith: true, MaxAge: 10})
+	assert.NoError(t, err)
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1", Smith: true, MaxAge: 10})
+	assert.NoError(t, err)
+	assert.True(t, res.(pocket.CalibratedRangeQuery).Cached)
+	assert.Equal(t, 2, len(res.(pocket.CalibratedRangeQuery).SmithChart))
+}
+
+func TestDisconnected(t *testing.T) {
+
+	open := pocket.SParam{Freq: 100e6, S11: pocket.Complex{Real: 0.98}, S21: pocket.Complex{Imag: 0.01}, S22: pocket.Complex{Real: 0.1}}
+	thru := pocket.SParam{Freq: 200e6, S11: pocket.Complex{Real: 0.1}, S21: pocket.Complex{Real: 0.9}, S12: pocket.Complex{Real: 0.9}}
+
+	assert.Equal(t, []int{1}, Disconnected([]pocket.SParam{open, open}))
+	assert.Empty(t, Disconnected([]pocket.SParam{open, thru}))
+	assert.Empty(t, Disconnected(nil))
+
+	both := open
+	both.S22 = pocket.Complex{Imag: -1}
+	assert.Equal(t, []int{1, 2}, Disconnected([]pocket.SParam{both, both}))
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{open, open}
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, Avg: 1}
+	m.terms = []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}}
+
+	res, err := m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.NoError(t, err)
+	assert.Contains(t, res.(pocket.CalibratedRangeQuery).Warning, "possible disconnected DUT, because port 1 reflects")
+
+	// the open is meant to look like that
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "open"})
+	assert.NoError(t, err)
+	assert.Empty(t, res.(pocket.CalibratedRangeQuery).Warning)
+
+	mock.ResultRangeQuery = []pocket.SParam{thru, thru}
+	res, err = m.Handle(context.Background(), pocket.CalibratedRangeQuery{What: "dut1"})
+	assert.NoError(t, err)
+	assert.Empty(t, res.(pocket.CalibratedRangeQuery).Warning)
+}
+
+func TestWarmup(t *testing.T) {
+
+	mock := pocket.NewMock()
+
+	var v pocket.VNA = mock
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+
+	// not tracked
+	req := pocket.CalState{}
+	assert.NoError(t, m.CalState(&req))
+	assert.Nil(t, req.Warmup)
+
+	m.SetWarmup(time.Hour, false)
+
+	warning, err := m.checkWarm()
+	assert.NoError(t, err)
+	assert.Contains(t, warning, "the VNA is still warming up, for another ")
+
+	req = pocket.CalState{}
+	assert.NoError(t, m.CalState(&req))
+	assert.False(t, req.Warmup.Warm)
+	assert.Equal(t, 3600.0, req.Warmup.Period)
+	assert.InDelta(t, 3600, req.Warmup.Remaining, 5)
+	assert.Nil(t, req.Warmup.FirstMeasured)
+
+	m.SetWarmup(time.Hour, true)
+
+	rc := pocket.RangeQuery{Command: pocket.Command{Command: "rc"}, Range: pocket.Range{Start: 1e6, End: 2e6}, Size: 2, Avg: 1}
+	_, err = m.Handle(context.Background(), rc)
+	assert.ErrorIs(t, err, ErrWarmingUp)
+	assert.Equal(t, calstate.Uncalibrated, m.calState.State())
+
+	// other measurements are not affected
+	_, err = m.Handle(context.Background(), pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, What: "dut1", Range: pocket.Range{Start: 1e6, End: 2e6}, Size: 2, Avg: 1})
+	assert.NoError(t, err)
+
+	req = pocket.CalState{}
+	assert.NoError(t, m.CalState(&req))
+	assert.True(t, req.Warmup.Refuse)
+	assert.NotNil(t, req.Warmup.FirstMeasured)
+
+	m.SetWarmup(time.Nanosecond, true)
+
+	warning, err = m.checkWarm()
+	assert.NoError(t, err)
+	assert.Empty(t, warning)
+
+	req = pocket.CalState{}
+	assert.NoError(t, m.CalState(&req))
+	assert.True(t, req.Warmup.Warm)
+	assert.Equal(t, 0.0, req.Warmup.Remaining)
+}
+
+func TestSwitchEvent(t *testing.T) {
+
+	var v pocket.VNA = pocket.NewMock()
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+	m.SetStream(&stream.Stream{
+		Request:  make(chan interface{}),
+		Response: make(chan interface{}, 4),
+	})
+
+	rq := pocket.RangeQuery{Command: pocket.Command{Command: "rq"}, Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, What: "dut2"}
+
+	_, err := m.Handle(context.Background(), rq)
+	assert.NoError(t, err)
+
+	e, ok := (<-m.s.Response).(pocket.SwitchEvent)
+	assert.True(t, ok)
+	assert.Equal(t, "switchevent", e.Command.Command)
+	assert.Equal(t, "", e.From)
+	assert.Equal(t, "dut2", e.To)
+	assert.False(t, e.At.IsZero())
+
+	rq.What = "dut1"
+	_, err = m.Handle(context.Background(), rq)
+	assert.NoError(t, err)
+
+	e = (<-m.s.Response).(pocket.SwitchEvent)
+	assert.Equal(t, "dut2", e.From)
+	assert.Equal(t, "dut1", e.To)
+}
+
+func TestSweepStartStop(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+	mock.PrepareDelay = 5 * time.Millisecond
+
+	var v pocket.VNA = mock
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m := NewWith(ctx, measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, 20*time.Millisecond)
+	m.SetStream(&stream.Stream{
+		Request:  make(chan interface{}),
+		Response: make(chan interface{}),
+	})
+	m.rq = &pocket.RangeQuery{Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2}
+	m.terms = []twoport.ErrorTerms{{Erf: 1, Etf: 1, Err: 1, Etr: 1}, {Erf: 1, Etf: 1, Err: 1, Etr: 1}}
+
+	stopped := make(chan struct{})
+
+	go func() {
+		m.Run()
+		close(stopped)
+	}()
+
+	sweeps := 0
+	read := make(chan struct{})
+
+	go func() {
+		defer close(read)
+		for {
+			select {
+			case r := <-m.s.Response:
+				if crq, ok := r.(pocket.CalibratedRangeQuery); ok && crq.Command.Command == "sweep" {
+					sweeps++
+				}
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	start := pocket.Sweep{}
+	start.Command = pocket.Command{ID: "s", Command: "startsweep"}
+	start.What = "dut1"
+
+	stop := pocket.Sweep{}
+	stop.Command = pocket.Command{Command: "stopsweep"}
+
+	// the sweep is started and stopped while it is being measured, which
+	// must not race with Run reading it (run with -race)
+	for i := 0; i < 20; i++ {
+		m.s.Request <- start
+		time.Sleep(time.Duration(i%4) * time.Millisecond)
+		m.s.Request <- stop
+	}
+
+	m.s.Request <- start
+	assert.Eventually(t, func() bool { return m.sweeping() != nil }, time.Second, time.Millisecond)
+
+	m.s.Request <- stop
+	assert.Eventually(t, func() bool { return m.sweeping() == nil }, time.Second, time.Millisecond)
+
+	cancel()
+	<-stopped
+	<-read
+
+	assert.True(t, sweeps > 0)
+}
+
+// panicky is a VNA with a bug in its range query
+type panicky struct {
+	*pocket.Mock
+}
+
+func (p panicky) RangeQuery(command interface{}) error {
+	var s []pocket.SParam
+	_ = s[3]
+	return nil
+}
+
+func TestCrash(t *testing.T) {
+
+	mock := pocket.NewMock()
+	mock.ResultRangeQuery = []pocket.SParam{{Freq: 100e6}, {Freq: 200e6}}
+
+	var v pocket.VNA = panicky{mock}
+
+	m := NewWith(context.Background(), measure.NewHardware(&v, rfusb.NewMock()), calibration.Native{}, time.Second)
+
+	dir := t.TempDir()
+	m.SetCrashDir(dir)
+
+	r := logring.New(10)
+	r.Add("switch opened")
+	m.SetLogRing(r)
+
+	rq := pocket.RangeQuery{Command: pocket.Command{ID: "1", Command: "rq"}, Range: pocket.Range{Start: 100e6, End: 200e6}, Size: 2, What: "dut1"}
+
+	_, err := m.Handle(context.Background(), rq)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "internal error handling rq: runtime error: index out of range")
+
+	files, _ := filepath.Glob(filepath.Join(dir, "crash-*.json"))
+	assert.Equal(t, 1, len(files))
+	assert.Contains(t, err.Error(), files[0])
+
+	b, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), "panicky.RangeQuery")
+	assert.Contains(t, string(b), "switch opened")
+
+	// the state was let go of, so the next request is served
+	v = mock
+	_, err = m.Handle(context.Background(), rq)
+	assert.NoError(t, err)
+
+	// without a crash directory there is no report, but still an error
+	v = panicky{mock}
+	m.SetCrashDir("")
+	_, err = m.Handle(context.Background(), rq)
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "crash report")
+}
+
 func TestMiddle(t *testing.T) {
 	if debug {
 		t.Log("This test requires external dependencies and succeeds most of the time")
@@ -194,7 +3632,9 @@ func TestMiddle(t *testing.T) {
 
 	assert.NoError(t, err)
 
-	m := New(ctx, addr, port, baud, timeoutUSB, timeoutRequest, topic, &v)
+	m, err := New(ctx, addr, "usb", port, baud, timeoutUSB, timeoutRequest, topic, &v)
+
+	assert.NoError(t, err)
 
 	go m.Run()
 
@@ -0,0 +1,491 @@
+package middle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/cmplx"
+	"strings"
+
+	"github.com/practable/pocket-vna-two-port/pkg/calibration"
+	"github.com/practable/pocket-vna-two-port/pkg/columns"
+	"github.com/practable/pocket-vna-two-port/pkg/format"
+	"github.com/practable/pocket-vna-two-port/pkg/limit"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/reduce"
+	"github.com/practable/pocket-vna-two-port/pkg/smooth"
+	"github.com/practable/pocket-vna-two-port/pkg/uncertainty"
+	log "github.com/sirupsen/logrus"
+)
+
+// processing is a measurement of a DUT on its way through the pipeline of a crq
+type processing struct {
+	ctx     context.Context
+	request *pocket.CalibratedRangeQuery
+	meta    *pocket.Meta
+	trace   []pocket.SParam
+	// why the trace could not be calibrated, if the raw trace is returned instead
+	uncorrected string
+}
+
+// stage is one step of the pipeline. apply does what the request asks of the stage,
+// if anything, to the trace, and reports whether it changed it.
+type stage struct {
+	name string
+	// listed in the applied corrections of the meta, if it changed the trace
+	listed bool
+	// the trace is still a corrected measurement of the DUT afterwards, so is kept
+	// for an, as the last calibrated result
+	corrects bool
+	apply    func(m *Middle, p *processing) (bool, error)
+}
+
+// stages are those a calibrated result can go through, in the order they are used
+// unless a request gives its own pipeline. Add a new kind of processing as a stage.
+var stages = []stage{
+	{name: "calibrate", corrects: true, apply: (*Middle).calibrateStage},
+	{name: "deembed", listed: true, corrects: true, apply: (*Middle).deembedStage},
+	{name: "portext", listed: true, corrects: true, apply: (*Middle).portextStage},
+	{name: "renormalize", listed: true, corrects: true, apply: (*Middle).renormalizeStage},
+	{name: "uncertainty", apply: (*Middle).uncertaintyStage},
+	{name: "disconnected", apply: (*Middle).disconnectedStage},
+	{name: "hold", apply: (*Middle).holdStage},
+	{name: "smooth", listed: true, apply: (*Middle).smoothStage},
+	{name: "normalize", listed: true, apply: (*Middle).normalizeStage},
+	{name: "limits", apply: (*Middle).limitsStage},
+	{name: "format", apply: (*Middle).formatStage},
+	{name: "phasedelay", apply: (*Middle).phaseDelayStage},
+	{name: "decimate", apply: (*Middle).decimateStage},
+	{name: "smith", apply: (*Middle).smithStage},
+	{name: "columns", apply: (*Middle).columnsStage},
+}
+
+// DefaultPipeline returns the names of the stages of a crq, in the order they are
+// used unless a request gives its own pipeline
+func DefaultPipeline() []string {
+
+	names := make([]string, len(stages))
+
+	for i, s := range stages {
+		names[i] = s.name
+	}
+
+	return names
+}
+
+// stageOf returns the stage called name
+func stageOf(name string) (stage, bool) {
+
+	for _, s := range stages {
+		if s.name == strings.ToLower(name) {
+			return s, true
+		}
+	}
+
+	return stage{}, false
+}
+
+// CheckPipeline returns an error if pipeline is not a valid choice and order of
+// stages: each must be known, and used once, and the first must be calibrate,
+// since the others need a calibrated result. An empty pipeline uses the default.
+func CheckPipeline(pipeline []string) error {
+
+	if len(pipeline) == 0 {
+		return nil
+	}
+
+	if strings.ToLower(pipeline[0]) != "calibrate" {
+		return fmt.Errorf("pipeline must start with calibrate, not %s", pipeline[0])
+	}
+
+	seen := make(map[string]bool)
+
+	for _, name := range pipeline {
+
+		if _, ok := stageOf(name); !ok {
+			return fmt.Errorf("pipeline stage must be one of %s, not %s", strings.Join(DefaultPipeline(), ", "), name)
+		}
+
+		if seen[strings.ToLower(name)] {
+			return fmt.Errorf("pipeline has %s more than once", name)
+		}
+
+		seen[strings.ToLower(name)] = true
+	}
+
+	return nil
+}
+
+// func process takes the measurement of the DUT in m.dut through the pipeline of
+// request, or the default one, and puts the result in request, noting the stages
+// that changed it in meta. It returns true if the result could not be calibrated,
+// and the raw result has been given instead.
+func (m *Middle) process(ctx context.Context, request *pocket.CalibratedRangeQuery, meta *pocket.Meta) (bool, error) {
+
+	names := request.Pipeline
+
+	if len(names) == 0 {
+		names = DefaultPipeline()
+	}
+
+	p := processing{
+		ctx:     ctx,
+		request: request,
+		meta:    meta,
+		trace:   m.dut,
+	}
+
+	for _, name := range names {
+
+		s, _ := stageOf(name) // already checked
+
+		changed, err := s.apply(m, &p)
+
+		if err != nil {
+			return false, err
+		}
+
+		if p.uncorrected != "" {
+			return true, m.uncorrected(request, m.dut, p.uncorrected)
+		}
+
+		if changed && s.listed {
+			meta.Applied = append(meta.Applied, s.name)
+		}
+
+		if s.corrects {
+			m.dutcal = p.trace
+		}
+	}
+
+	request.Result = p.trace
+
+	return false, nil
+}
+
+// func calibrateStage applies the error terms of the cal, or has the calibration
+// service do it, if it did not return them
+func (m *Middle) calibrateStage(p *processing) (bool, error) {
+
+	if m.terms != nil {
+
+		// apply the error terms from the cal here, rather than sending all the standards again
+		dutcal, err := m.Correct(p.trace)
+
+		if err != nil {
+			return false, err
+		}
+
+		p.trace = dutcal
+
+		return true, nil
+	}
+
+	if m.std == nil {
+		return false, ErrNotCalibrated
+	}
+
+	// the backend may reuse what it worked out from the standards during the cal
+	r, err := m.calibrate(p.ctx, calibration.Freq(m.std.Short), m.std, m.Unterminate(p.trace))
+
+	if err != nil {
+		if m.fallback && errors.Is(err, calibration.ErrUnavailable) {
+			p.uncorrected = err.Error()
+			return false, nil
+		}
+		// the cal is still good, so try again once the calibration service is back
+		return false, fmt.Errorf("could not calibrate because %s", err.Error())
+	}
+
+	p.trace = r.DUT
+
+	return true, nil
+}
+
+// func deembedStage removes the fixtures set with setfixture, if any
+func (m *Middle) deembedStage(p *processing) (bool, error) {
+
+	trace, err := m.Deembed(p.trace)
+
+	if err != nil {
+		return false, err
+	}
+
+	p.trace = trace
+
+	return m.fixture[0] != nil || m.fixture[1] != nil, nil
+}
+
+// func portextStage adds the port extensions set with portext, if any
+func (m *Middle) portextStage(p *processing) (bool, error) {
+
+	if m.portext == (pocket.Extension{}) {
+		return false, nil
+	}
+
+	p.trace = Extend(p.trace, m.portext)
+	ext := m.portext
+	p.request.Extension = &ext
+
+	return true, nil
+}
+
+// func renormalizeStage changes the reference impedance to the z0 of the request, if it is not 50 ohms
+func (m *Middle) renormalizeStage(p *processing) (bool, error) {
+
+	if p.request.Z0 == 0 || p.request.Z0 == Z0 {
+		return false, nil
+	}
+
+	trace, err := Renormalize(p.trace, p.request.Z0)
+
+	if err != nil {
+		return false, err
+	}
+
+	p.trace = trace
+	p.meta.Z0 = p.request.Z0
+
+	return true, nil
+}
+
+// func uncertaintyStage adds bounds on the trace, if the request asks for them, from the
+// noise of its own sweeps, if there were at least two, or else the noise floor from nf
+func (m *Middle) uncertaintyStage(p *processing) (bool, error) {
+
+	if !p.request.Uncertainty {
+		return false, nil
+	}
+
+	noise := p.request.StdDev
+	from := fmt.Sprintf("the %d sweeps of this result", p.request.Sweeps)
+
+	if len(noise) != len(p.trace) {
+		noise = m.noiseFloor
+		from = "the noise floor measured with nf"
+	}
+
+	if len(noise) != len(p.trace) {
+		return false, ErrNoNoise
+	}
+
+	bounds, err := uncertainty.Apply(p.trace, noise, m.tracking(len(p.trace)))
+
+	if err != nil {
+		return false, err
+	}
+
+	p.request.Bounds = bounds
+	p.meta.Noise = from
+
+	return false, nil
+}
+
+// func tracking is the reflection and transmission tracking of the cal at each of n
+// points, or one if the error terms are not known
+func (m *Middle) tracking(n int) []uncertainty.Tracking {
+
+	t := make([]uncertainty.Tracking, n)
+
+	for i := range t {
+
+		if len(m.terms) != n {
+			t[i] = uncertainty.Tracking{Reflection: [2]float64{1, 1}, Transmission: [2]float64{1, 1}}
+			continue
+		}
+
+		e := m.terms[i]
+
+		t[i] = uncertainty.Tracking{
+			Reflection:   [2]float64{cmplx.Abs(e.Erf), cmplx.Abs(e.Err)},
+			Transmission: [2]float64{cmplx.Abs(e.Etf), cmplx.Abs(e.Etr)},
+		}
+	}
+
+	return t
+}
+
+// func disconnectedStage warns if the DUT looks disconnected, see Disconnected
+func (m *Middle) disconnectedStage(p *processing) (bool, error) {
+
+	// the short and open are meant to reflect everything
+	if w := strings.ToLower(p.request.What); w == "short" || w == "open" {
+		return false, nil
+	}
+
+	ports := Disconnected(p.trace)
+
+	if len(ports) == 0 {
+		return false, nil
+	}
+
+	at := fmt.Sprintf("port %d reflects", ports[0])
+
+	if len(ports) > 1 {
+		at = "ports 1 and 2 reflect"
+	}
+
+	p.request.Warning = "possible disconnected DUT, because " + at + " nearly everything and nothing is transmitted across the band, so check the cables"
+	log.Warnf("crq of %s: %s", p.request.What, p.request.Warning)
+
+	return false, nil
+}
+
+// func holdStage adds the trace to the hold, if one has been started for this DUT
+func (m *Middle) holdStage(p *processing) (bool, error) {
+
+	if !m.hold.Active || (m.hold.What != "" && !strings.EqualFold(m.hold.What, p.request.What)) {
+		return false, nil
+	}
+
+	return false, Accumulate(&m.hold, p.trace)
+}
+
+// func smoothStage smooths the trace, if the request asks for it
+func (m *Middle) smoothStage(p *processing) (bool, error) {
+
+	if p.request.Smooth == nil {
+		return false, nil
+	}
+
+	trace, sm, err := smooth.Apply(*p.request.Smooth, p.trace)
+
+	if err != nil {
+		return false, invalid(err)
+	}
+
+	p.trace = trace
+	p.meta.Smoothing = &sm
+
+	return true, nil
+}
+
+// func normalizeStage divides the trace by the reference, if the request asks for it
+func (m *Middle) normalizeStage(p *processing) (bool, error) {
+
+	if !p.request.Normalize {
+		return false, nil
+	}
+
+	trace, err := m.normalize(p.trace, p.request.Z0)
+
+	if err != nil {
+		return false, err
+	}
+
+	p.trace = trace
+	info := *m.refInfo
+	p.request.Reference = &info
+
+	return true, nil
+}
+
+// func limitsStage checks the trace against the limit lines, if there are any
+func (m *Middle) limitsStage(p *processing) (bool, error) {
+
+	if len(m.limits) == 0 {
+		return false, nil
+	}
+
+	results, pass, err := limit.Evaluate(m.limits, p.trace)
+
+	if err != nil {
+		return false, err
+	}
+
+	p.request.Limits = results
+	p.request.Pass = &pass
+
+	return false, nil
+}
+
+// func formatStage adds the trace in the format of the request, if it has one
+func (m *Middle) formatStage(p *processing) (bool, error) {
+
+	formatted, err := format.Apply(p.request.Format, p.trace)
+
+	if err != nil {
+		return false, err
+	}
+
+	p.request.Formatted = formatted
+
+	if formatted != nil {
+		p.meta.Formatted = format.Describe(p.request.Format)
+	}
+
+	return false, nil
+}
+
+// func phaseDelayStage adds the unwrapped phase and group delay of s21, if the request asks for them
+func (m *Middle) phaseDelayStage(p *processing) (bool, error) {
+
+	if p.request.PhaseDelay == nil {
+		return false, nil
+	}
+
+	pd, err := format.PhaseDelay(p.trace, p.request.PhaseDelay.Aperture)
+
+	if err != nil {
+		return false, invalid(err)
+	}
+
+	p.request.PhaseDelay = &pd
+
+	return false, nil
+}
+
+// func decimateStage keeps no more than the display points of the request, and the
+// same points of what has been found from the trace so far, so that the limits and
+// the phase delay are found from every point
+func (m *Middle) decimateStage(p *processing) (bool, error) {
+
+	n := len(p.trace)
+	idx := reduce.Indices(p.trace, p.request.Select, p.request.Display)
+
+	if idx == nil {
+		return false, nil
+	}
+
+	r := p.request
+	p.trace = reduce.Pick(p.trace, idx, n)
+	r.Formatted = reduce.Pick(r.Formatted, idx, n)
+	r.Bounds = reduce.Pick(r.Bounds, idx, n)
+	r.StdDev = reduce.Pick(r.StdDev, idx, n)
+	r.RawResult = reduce.Pick(r.RawResult, idx, n)
+
+	if r.PhaseDelay != nil {
+		pd := *r.PhaseDelay
+		pd.Phase = reduce.Pick(pd.Phase, idx, n)
+		pd.Delay = reduce.Pick(pd.Delay, idx, n)
+		r.PhaseDelay = &pd
+	}
+
+	p.meta.Reduced = &pocket.Reduction{Points: len(idx), Of: n}
+
+	return true, nil
+}
+
+// func smithStage adds the reflection parameters as normalised impedances, if the request asks for them
+func (m *Middle) smithStage(p *processing) (bool, error) {
+
+	if p.request.Smith {
+		p.request.SmithChart = format.Smith(p.trace)
+	}
+
+	return false, nil
+}
+
+// func columnsStage lays out the trace in columns, if the request asks for them
+func (m *Middle) columnsStage(p *processing) (bool, error) {
+
+	if !columns.Wanted(p.request.Layout) {
+		return false, nil
+	}
+
+	c := columns.From(p.trace)
+	p.request.Columns = &c
+	p.meta.Loaders = columns.Loaders
+
+	return false, nil
+}
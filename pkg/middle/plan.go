@@ -0,0 +1,473 @@
+package middle
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/average"
+	"github.com/practable/pocket-vna-two-port/pkg/columns"
+	"github.com/practable/pocket-vna-two-port/pkg/format"
+	"github.com/practable/pocket-vna-two-port/pkg/measure"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/smooth"
+)
+
+// PointTime is roughly how long the VNA takes to measure one point, at an average
+// of one, for estimating how long a request will take until it has been learned
+// from the sweeps made
+const PointTime = 2 * time.Millisecond
+
+// SwitchTime is roughly how long the switch takes to move and confirm its position,
+// before it settles, for estimating how long a request will take
+const SwitchTime = 50 * time.Millisecond
+
+// func Plan works out what request would do, without using the hardware or changing
+// anything: the switch positions it would measure, in order, the sweeps at each, and
+// roughly how long it would take. The request is checked as it would be if it were
+// handled. Requests that do not measure anything have no steps.
+func (m *Middle) Plan(request interface{}) (pocket.Plan, error) {
+
+	c, _ := pocket.CommandOf(request)
+
+	p := pocket.Plan{
+		Command: c,
+		Steps:   []pocket.Step{},
+	}
+
+	var err error
+
+	switch req := request.(type) {
+
+	case pocket.RangeQuery:
+
+		cmd, _ := pocket.Lookup(req.Command.Command)
+
+		err = m.usePreset(&req)
+
+		if err != nil {
+			break
+		}
+
+		// as it would be moved inside the frequencies allowed on this rig
+		if m.guard.Clamp {
+
+			_, err = measure.Clamp(&req, m.allowed(m.knownRange()))
+
+			if err != nil {
+				err = invalid(err)
+				break
+			}
+		}
+
+		if cmd == "rc" {
+			p.Steps, err = m.planCal(req)
+		} else {
+			p.Steps, err = m.planRange(req, req.What)
+		}
+
+	case pocket.CalibratedRangeQuery:
+
+		err = m.planCheck(req)
+
+		if err == nil {
+			p.Steps, err = m.planCalibrated(req.What, req.Sweeps, req.Reject)
+		}
+
+	case pocket.MeasureCal:
+
+		if m.rq == nil {
+			err = ErrNotCalibrated
+			break
+		}
+
+		p.Steps, err = m.planRange(*m.rq, req.What)
+
+	case pocket.ResumeCal:
+
+		if m.resume == nil {
+			err = ErrNotInterrupted
+			break
+		}
+
+		for _, what := range m.calState.Remaining(m.standards()) {
+
+			var s []pocket.Step
+
+			s, err = m.planRange(*m.resume, what)
+
+			if err != nil {
+				break
+			}
+
+			p.Steps = append(p.Steps, s...)
+		}
+
+	case pocket.Compare:
+
+		if req.A == "" || req.B == "" {
+			err = errors.New("give the two positions to compare as a and b")
+			break
+		}
+
+		for _, what := range []string{req.A, req.B} {
+
+			var s []pocket.Step
+
+			s, err = m.planCalibrated(what, req.Sweeps, req.Reject)
+
+			if err != nil {
+				break
+			}
+
+			p.Steps = append(p.Steps, s...)
+		}
+
+	case pocket.Sweep:
+
+		// the first sweep only, since it carries on until stopped
+		err = m.planCheck(req.CalibratedRangeQuery)
+
+		if err == nil {
+			p.Steps, err = m.planCalibrated(req.What, req.Sweeps, req.Reject)
+		}
+
+	case pocket.Stability:
+
+		if req.Stop || req.Query {
+			break
+		}
+
+		// the first measurement only, since the rest are made later
+		rq := req.RangeQuery
+		rq.Command.Command = "rq"
+
+		var sp pocket.Plan
+
+		sp, err = m.Plan(rq)
+		p.Steps = sp.Steps
+
+	case pocket.Batch:
+
+		for _, sub := range req.Requests {
+
+			var sp pocket.Plan
+
+			switch sub.(type) {
+			case nil:
+				err = errors.New("unknown command")
+			case pocket.Batch, pocket.Cancel, pocket.Queue, pocket.FlushQueue, pocket.Schedule, pocket.Unschedule, pocket.Logs, pocket.SwitchTraffic, pocket.History, pocket.LastResult:
+				err = errors.New("this command cannot be used in a batch")
+			default:
+				if sc, _ := pocket.CommandOf(sub); schedules(sc) {
+					err = ErrScheduledInBatch
+				} else {
+					sp, err = m.Plan(sub)
+				}
+			}
+
+			if err != nil {
+				sc, _ := pocket.CommandOf(sub)
+				err = fmt.Errorf("%s in the batch %s", sc.Command, err.Error())
+				break
+			}
+
+			p.Steps = append(p.Steps, sp.Steps...)
+		}
+	}
+
+	if err != nil {
+		return p, err
+	}
+
+	for _, s := range p.Steps {
+		p.Sweeps += s.Sweeps
+		p.Estimate += s.Estimate
+	}
+
+	return p, nil
+}
+
+// func Estimate predicts how long a measurement, or an rc, would take, from the time
+// per point learned from the sweeps made so far
+func (m *Middle) Estimate(request *pocket.Estimate) error {
+
+	rq := pocket.RangeQuery{
+		Size:   request.Size,
+		Avg:    request.Avg,
+		Sweeps: request.Sweeps,
+	}
+
+	if rq.Size == 0 {
+
+		if m.rq == nil {
+			return invalid(errors.New("give the size, as there is no cal to take it from"))
+		}
+
+		rq.Size = m.rq.Size
+
+		if len(m.rq.Frequencies) > 0 {
+			rq.Size = len(m.rq.Frequencies)
+		}
+
+		for _, g := range m.rq.Segments {
+			rq.Size += g.Size
+		}
+	}
+
+	if rq.Size < 2 {
+		return invalid(fmt.Errorf("size must be at least 2 points, not %d", rq.Size))
+	}
+
+	err := measure.CheckAvg(rq.Avg)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	err = average.Check(rq.Sweeps, "")
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	if rq.Sweeps == 0 {
+		rq.Sweeps = 1
+	}
+
+	if request.Positions < 0 {
+		return invalid(fmt.Errorf("positions must not be negative, not %d", request.Positions))
+	}
+
+	// any position without its own settling time
+	what := make([]string, request.Positions)
+
+	if request.Positions == 0 {
+		what = []string{""}
+	}
+
+	if request.Cal {
+		what = m.standards()
+	}
+
+	var t time.Duration
+
+	for _, w := range what {
+		t += m.stepTime(w, rq.Sweeps, measure.Work(&rq))
+	}
+
+	pt, learned := m.pointTime()
+
+	request.Result = t.Seconds()
+	request.PointTime = pt.Seconds()
+	request.Learned = learned
+
+	return nil
+}
+
+// planCheck checks the settings of a crq that do not depend on what is measured
+func (m *Middle) planCheck(request pocket.CalibratedRangeQuery) error {
+
+	err := format.Check(request.Format)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	if request.Z0 < 0 {
+		return invalid(fmt.Errorf("reference impedance must be positive, not %g", request.Z0))
+	}
+
+	if request.MaxAge < 0 {
+		return invalid(fmt.Errorf("maxage must not be negative, not %g", request.MaxAge))
+	}
+
+	err = checkTarget(&request)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	if request.Smooth != nil {
+
+		err = smooth.Check(*request.Smooth, 0)
+
+		if err != nil {
+			return invalid(err)
+		}
+	}
+
+	err = CheckPipeline(request.Pipeline)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	err = columns.Check(request.Layout)
+
+	if err != nil {
+		return invalid(err)
+	}
+
+	if request.PhaseDelay != nil {
+
+		err = format.CheckAperture(request.PhaseDelay.Aperture, 0)
+
+		if err != nil {
+			return invalid(err)
+		}
+	}
+
+	return nil
+}
+
+// planCalibrated plans a calibrated measurement of what, at the points of the current cal
+func (m *Middle) planCalibrated(what string, sweeps int, reject string) ([]pocket.Step, error) {
+
+	if m.rq == nil {
+		return nil, ErrNotCalibrated
+	}
+
+	rq := *m.rq
+	rq.Sweeps = sweeps
+	rq.Reject = reject
+
+	return m.planRange(rq, what)
+}
+
+// planCal plans the standards of an rc, followed by the devices for the switch terms, if any
+func (m *Middle) planCal(request pocket.RangeQuery) ([]pocket.Step, error) {
+
+	power := request.Power
+
+	if power == 0 {
+		power = m.power
+	}
+
+	if power != m.power {
+		return nil, fmt.Errorf("calibration requested at %g dBm but output power is %g dBm, so use setpower first", power, m.power)
+	}
+
+	var steps []pocket.Step
+
+	for _, what := range m.standards() {
+
+		s, err := m.planRange(request, what)
+
+		if err != nil {
+			return nil, err
+		}
+
+		steps = append(steps, s...)
+	}
+
+	return steps, nil
+}
+
+// planRange checks a raw sweep of what, with the settings of rq, and plans it as one step
+func (m *Middle) planRange(rq pocket.RangeQuery, what string) ([]pocket.Step, error) {
+
+	rq.What = what
+
+	err := average.Check(rq.Sweeps, rq.Reject)
+
+	if err != nil {
+		return nil, invalid(err)
+	}
+
+	device := m.knownRange()
+
+	err = measure.CheckRange(&rq, device)
+
+	if err != nil {
+		return nil, invalid(err)
+	}
+
+	err = measure.Within(&rq, m.allowed(device))
+
+	if err != nil {
+		return nil, invalid(err)
+	}
+
+	s := pocket.Step{
+		What:   what,
+		Sweeps: rq.Sweeps,
+	}
+
+	if s.Sweeps < 1 {
+		s.Sweeps = 1
+	}
+
+	// the readings in each sweep
+	var work int
+
+	if len(rq.Segments) > 0 {
+
+		for _, g := range rq.Segments {
+
+			seg := pocket.RangeQuery{Size: g.Size, Avg: rq.Avg}
+
+			if g.Avg != 0 {
+				seg.Avg = g.Avg
+			}
+
+			s.Points += g.Size
+			work += measure.Work(&seg)
+		}
+
+	} else {
+
+		s.Points = rq.Size
+
+		if len(rq.Frequencies) > 0 {
+			s.Points = len(rq.Frequencies)
+		}
+
+		work = measure.Work(&rq)
+	}
+
+	s.Estimate = m.stepTime(what, s.Sweeps, work).Seconds()
+
+	return []pocket.Step{s}, nil
+}
+
+// knownRange is the range of the VNA if it is already known, or zero, so that it is not
+// asked, and the range is only checked against it if it is known
+func (m *Middle) knownRange() pocket.Range {
+
+	if m.device == nil {
+		return pocket.Range{}
+	}
+
+	return *m.device
+}
+
+// stepTime estimates how long it takes to move the switch to what, let it settle,
+// and make sweeps sweeps of work readings each
+func (m *Middle) stepTime(what string, sweeps, work int) time.Duration {
+
+	pt, _ := m.pointTime()
+
+	return SwitchTime + m.settleTime(what) + time.Duration(sweeps*work)*pt
+}
+
+// pointTime is the time to sweep one point at an average of one, as learned from the
+// sweeps made so far, if there have been any, and whether it was, or PointTime if not
+func (m *Middle) pointTime() (time.Duration, bool) {
+
+	if m.h != nil && m.h.PointTime() > 0 {
+		return m.h.PointTime(), true
+	}
+
+	return PointTime, false
+}
+
+// settleTime is how long the switch settles at what, or zero if there is no hardware
+func (m *Middle) settleTime(what string) time.Duration {
+
+	if m.h == nil {
+		return 0
+	}
+
+	return m.h.SettleTime(what)
+}
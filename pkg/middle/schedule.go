@@ -0,0 +1,233 @@
+package middle
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxScheduled limits the number of requests waiting to be done at a later time
+const MaxScheduled = 100
+
+// MaxSchedule is the furthest ahead that a request can be scheduled
+const MaxSchedule = 7 * 24 * time.Hour
+
+// ErrScheduledInBatch is returned for a request in a batch with an at time or an after delay
+var ErrScheduledInBatch = errors.New("a command in a batch cannot be scheduled, so give the batch an at time or an after delay instead")
+
+// func schedules returns true if a request with Command c asks to be done later
+func schedules(c pocket.Command) bool {
+	return c.At != "" || c.After != 0
+}
+
+// func dueAt returns when a request with Command c, that arrived at now, is due, which is
+// an error if it gives both an at time and an after delay, or if it is in the past, or
+// further ahead than MaxSchedule
+func dueAt(c pocket.Command, now time.Time) (time.Time, error) {
+
+	if c.At != "" && c.After != 0 {
+		return time.Time{}, errors.New("give an at time or an after delay, not both")
+	}
+
+	if c.After < 0 {
+		return time.Time{}, fmt.Errorf("after must not be negative, not %g", c.After)
+	}
+
+	if c.After > MaxSchedule.Seconds() {
+		return time.Time{}, fmt.Errorf("after must be no more than %g seconds, not %g", MaxSchedule.Seconds(), c.After)
+	}
+
+	if c.At == "" {
+		return now.Add(time.Duration(c.After * float64(time.Second))), nil
+	}
+
+	due, err := time.Parse(time.RFC3339, c.At)
+
+	if err != nil {
+		return time.Time{}, fmt.Errorf("at must be an RFC 3339 time, e.g. 2023-01-09T22:00:00Z, not %s", c.At)
+	}
+
+	if due.Before(now) {
+		return time.Time{}, fmt.Errorf("at %s is in the past, since it is now %s", c.At, now.UTC().Format(time.RFC3339))
+	}
+
+	if due.Sub(now) > MaxSchedule {
+		return time.Time{}, fmt.Errorf("at %s is more than %s ahead", c.At, MaxSchedule)
+	}
+
+	return due, nil
+}
+
+// func schedule puts q aside until it is due, if it has an at time or an after delay, and
+// sends a scheduled message to say when that is, or an error if it cannot be scheduled,
+// returning false if it is to be done now instead. A retry of a request that is already
+// scheduled, with the same session, id and cmd, is told when it is due again, rather than
+// being scheduled twice. Cancels, dry runs, and requests that have been scheduled before,
+// which are now due, are done now.
+func (m *Middle) schedule(q queued) bool {
+
+	c, ok := pocket.CommandOf(q.request)
+
+	if !ok || !schedules(c) || c.DryRun || !q.due.IsZero() {
+		return false
+	}
+
+	switch q.request.(type) {
+	case pocket.Cancel, pocket.Invalid:
+		return false
+	}
+
+	if _, ok := pocket.Rank(c.Priority); !ok {
+		return false // refused when it is served
+	}
+
+	cmd, _ := pocket.Lookup(c.Command)
+
+	for _, s := range m.scheduled {
+
+		sc, _ := pocket.CommandOf(s.request)
+
+		if c.ID != "" && replayKey(sc) == replayKey(c) {
+			if scmd, _ := pocket.Lookup(sc.Command); scmd == cmd {
+				m.s.Response <- scheduled(s)
+				return true
+			}
+		}
+	}
+
+	due, err := dueAt(c, q.at)
+
+	if err == nil && len(m.scheduled) >= MaxScheduled {
+		err = fmt.Errorf("there are %d requests scheduled already, which is the most allowed", MaxScheduled)
+	}
+
+	if err != nil {
+		err = invalid(err)
+		m.record("stream", q, time.Now(), err)
+		m.s.Response <- pocket.CustomResult{
+			Message: err.Error(),
+			Command: q.request,
+		}
+		return true
+	}
+
+	q.due = due
+
+	i := sort.Search(len(m.scheduled), func(i int) bool { return m.scheduled[i].due.After(due) })
+
+	m.scheduled = append(m.scheduled, queued{})
+	copy(m.scheduled[i+1:], m.scheduled[i:])
+	m.scheduled[i] = q
+
+	log.WithFields(log.Fields{"id": c.ID, "session": c.Session, "cmd": cmd, "due": due.UTC().Format(time.RFC3339)}).Info("scheduled request")
+
+	m.s.Response <- scheduled(q)
+
+	return true
+}
+
+// func fire moves the scheduled requests that are due by now to the queue, where they
+// wait behind any request of the same or higher priority, as if they had just arrived
+func (m *Middle) fire(now time.Time) {
+
+	for len(m.scheduled) > 0 && !m.scheduled[0].due.After(now) {
+
+		q := m.scheduled[0]
+		m.scheduled = m.scheduled[1:]
+
+		q.at = now
+		m.enqueue(q)
+
+		c, _ := pocket.CommandOf(q.request)
+
+		log.WithFields(log.Fields{"id": c.ID, "session": c.Session, "cmd": c.Command, "late": now.Sub(q.due).Seconds()}).Info("scheduled request is due")
+	}
+
+	if len(m.scheduled) == 0 {
+		m.scheduled = nil
+	}
+}
+
+// func scheduled describes a scheduled request by its command, cmd and when it is due
+func scheduled(q queued) pocket.Scheduled {
+
+	c, _ := pocket.CommandOf(q.request)
+
+	s := pocket.Scheduled{
+		Command: c,
+		For:     c.Command,
+		Due:     q.due,
+	}
+
+	if cmd, ok := pocket.Lookup(c.Command); ok {
+		s.For = cmd
+	}
+
+	s.Command.Command = "scheduled"
+
+	return s
+}
+
+// func mine returns true if the scheduled request q may be seen by request: if it is in the
+// same session, or request has no session
+func mine(request pocket.Command, q queued) bool {
+
+	if request.Session == "" {
+		return true
+	}
+
+	c, _ := pocket.CommandOf(q.request)
+
+	return c.Session == request.Session
+}
+
+// func Schedule lists the scheduled requests, soonest first, see pocket.Schedule
+func (m *Middle) Schedule(request *pocket.Schedule) {
+
+	request.Scheduled = []pocket.Scheduled{}
+
+	for _, q := range m.scheduled {
+		if mine(request.Command, q) {
+			request.Scheduled = append(request.Scheduled, scheduled(q))
+		}
+	}
+}
+
+// func Unschedule drops scheduled requests before they are done, see pocket.Unschedule.
+// It is an error to give neither an id to drop nor all, or an id that is not scheduled.
+func (m *Middle) Unschedule(request *pocket.Unschedule) error {
+
+	if request.Drop == "" && !request.All {
+		return invalid(errors.New("give the id of the scheduled request to drop, or set all to drop every one"))
+	}
+
+	request.Dropped = []pocket.Scheduled{}
+
+	var kept []queued
+
+	for _, q := range m.scheduled {
+
+		c, _ := pocket.CommandOf(q.request)
+
+		if mine(request.Command, q) && (request.All || c.ID == request.Drop) {
+			request.Dropped = append(request.Dropped, scheduled(q))
+			continue
+		}
+
+		kept = append(kept, q)
+	}
+
+	if len(request.Dropped) == 0 && !request.All {
+		return invalid(fmt.Errorf("there is no scheduled request with id %s", request.Drop))
+	}
+
+	m.scheduled = kept
+
+	log.Warnf("dropped %d scheduled requests", len(request.Dropped))
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package middle
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/calibration"
+	"github.com/practable/pocket-vna-two-port/pkg/pb"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/rfusb"
+	"github.com/practable/pocket-vna-two-port/pkg/stream"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Server serves the measurement API over gRPC, for clients on the LAN that want to
+// drive the rig directly rather than through the websocket relay. Its requests are
+// handled by Run, in turn with those from the stream, so they never overlap.
+type Server struct {
+	pb.UnimplementedVNAServer
+	m *Middle
+}
+
+// call is a request from the gRPC server, waiting to be handled by Run
+type call struct {
+	ctx     context.Context
+	request interface{}
+	done    chan Response
+}
+
+// statusQuery asks for the state of the rig, for the Status RPC
+type statusQuery struct {
+	result *pb.StatusResponse
+}
+
+// func ServeGRPC serves the measurement API on addr (host:port) until the middleware's context is done
+func (m *Middle) ServeGRPC(addr string) error {
+
+	l, err := net.Listen("tcp", addr)
+
+	if err != nil {
+		return err
+	}
+
+	s := grpc.NewServer()
+
+	pb.RegisterVNAServer(s, &Server{m: m})
+
+	go func() {
+		<-m.ctx.Done()
+		s.Stop()
+	}()
+
+	log.WithField("addr", addr).Infof("serving gRPC measurement API")
+
+	return s.Serve(l)
+}
+
+// func Do passes a request to Run, and waits for its result. ctx can stop the request
+// while it is waiting its turn, as well as while it is being handled.
+func (m *Middle) Do(ctx context.Context, request interface{}) (interface{}, error) {
+
+	c := call{
+		ctx:     ctx,
+		request: request,
+		done:    make(chan Response, 1),
+	}
+
+	select {
+	case m.calls <- c:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	// Run always answers, if only with a timeout
+	r := <-c.done
+
+	return r.Result, r.Error
+}
+
+// answer handles a call from Do, with the hard timeout of its command
+func (m *Middle) answer(c call) {
+
+	ctx, cancel := context.WithTimeout(c.ctx, m.timeoutOf(c.request).Hard)
+	defer cancel()
+
+	start := time.Now()
+
+	result, err := m.Handle(ctx, c.request)
+
+	if _, ok := c.request.(statusQuery); !ok {
+		m.record("grpc", queued{request: c.request, at: start}, start, err)
+	}
+
+	c.done <- Response{
+		Result: result,
+		Error:  err,
+	}
+}
+
+// status reports the protocol, the range of the VNA, and the current calibration
+func (m *Middle) status() *pb.StatusResponse {
+
+	s := &pb.StatusResponse{
+		Protocol: stream.Protocol,
+	}
+
+	rfr := pocket.ReasonableFrequencyRange{}
+
+	if err := m.h.ReasonableFrequencyRange(&rfr); err == nil {
+		s.MinFrequency = rfr.Result.Start
+		s.MaxFrequency = rfr.Result.End
+	}
+
+	if m.rq != nil {
+		s.Calibrated = true
+		s.Start = m.rq.Range.Start
+		s.End = m.rq.Range.End
+		s.Size = int32(m.rq.Size)
+		s.Log = m.rq.LogDistribution
+	}
+
+	for p := range rfusb.Channels {
+		s.Positions = append(s.Positions, p)
+	}
+
+	sort.Strings(s.Positions)
+
+	return s
+}
+
+// func rpcError gives err the gRPC status code that matches it, so that clients
+// can tell a bad request from a rig that is not ready, or has run out of time
+func rpcError(err error) error {
+
+	code := codes.Unknown
+
+	switch {
+	case errors.Is(err, ErrInvalid):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrNotCalibrated), errors.Is(err, ErrWarmingUp), errors.Is(err, ErrNoNoise):
+		code = codes.FailedPrecondition
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, ErrCancelled), errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	case errors.Is(err, calibration.ErrUnavailable):
+		code = codes.Unavailable
+	}
+
+	return status.Error(code, err.Error())
+}
+
+// all is every S-parameter, which is what the gRPC API always measures
+var all = pocket.SParamSelect{S11: true, S12: true, S21: true, S22: true}
+
+// Measure makes a raw or calibrated measurement of a switch position
+func (s *Server) Measure(ctx context.Context, req *pb.MeasureRequest) (*pb.MeasureResponse, error) {
+
+	var request interface{}
+
+	if req.GetCalibrated() {
+		request = pocket.CalibratedRangeQuery{
+			Command: pocket.Command{Command: "crq"},
+			What:    req.GetWhat(),
+			Avg:     uint16(req.GetAvg()),
+			Select:  all,
+			Z0:      req.GetZ0(),
+			Sweeps:  int(req.GetSweeps()),
+			Reject:  req.GetReject(),
+		}
+	} else {
+		request = pocket.RangeQuery{
+			Command:         pocket.Command{Command: "rq"},
+			What:            req.GetWhat(),
+			Range:           pocket.Range{Start: req.GetStart(), End: req.GetEnd()},
+			Size:            int(req.GetSize()),
+			LogDistribution: req.GetLog(),
+			Avg:             uint16(req.GetAvg()),
+			Select:          all,
+			Sweeps:          int(req.GetSweeps()),
+			Reject:          req.GetReject(),
+		}
+	}
+
+	result, err := s.m.Do(ctx, request)
+
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	var sp []pocket.SParam
+
+	switch r := result.(type) {
+	case pocket.CalibratedRangeQuery:
+		sp = r.Result
+	case pocket.RangeQuery:
+		sp = r.Result
+	default:
+		return nil, status.Error(codes.Internal, "unexpected result")
+	}
+
+	return &pb.MeasureResponse{
+		Frequency: calibration.Meas2Freq(sp),
+		Result:    calibration.Meas2Cal(sp),
+	}, nil
+}
+
+// Calibrate calibrates over a range of frequencies, replacing the current calibration,
+// and returns the thru standard corrected with it
+func (s *Server) Calibrate(ctx context.Context, req *pb.CalibrateRequest) (*pb.CalibrateResponse, error) {
+
+	request := pocket.RangeQuery{
+		Command:         pocket.Command{Command: "rc"},
+		Range:           pocket.Range{Start: req.GetStart(), End: req.GetEnd()},
+		Size:            int(req.GetSize()),
+		LogDistribution: req.GetLog(),
+		Avg:             uint16(req.GetAvg()),
+		Select:          all,
+		Sweeps:          int(req.GetSweeps()),
+		Reject:          req.GetReject(),
+	}
+
+	result, err := s.m.Do(ctx, request)
+
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	r, ok := result.(pocket.RangeQuery)
+
+	if !ok {
+		return nil, status.Error(codes.Internal, "unexpected result")
+	}
+
+	return &pb.CalibrateResponse{
+		Frequency: calibration.Meas2Freq(r.Result),
+		Thru:      calibration.Meas2Cal(r.Result),
+	}, nil
+}
+
+// Status reports the protocol, the range of the VNA, and the current calibration
+func (s *Server) Status(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
+
+	result, err := s.m.Do(ctx, statusQuery{})
+
+	if err != nil {
+		return nil, rpcError(err)
+	}
+
+	return result.(statusQuery).result, nil
+}
@@ -0,0 +1,338 @@
+package middle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/format"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// MaxStabilityHours is the longest a stability experiment can run for
+const MaxStabilityHours = 7 * 24
+
+// MaxStabilityPoints limits the measurements of a stability experiment times the points
+// in each, since the drift at every point of every measurement is kept until it is done
+const MaxStabilityPoints = 1000000
+
+// DatalogDir is the prefix of the keys of the datalogs of stability experiments in the store
+const DatalogDir = "datalog/"
+
+// ErrNoExperiment is returned for a stability stop or query when there is no experiment running
+var ErrNoExperiment = errors.New("there is no stability experiment running")
+
+// ErrExperimentRunning is returned for a stability experiment when one is running already
+var ErrExperimentRunning = errors.New("a stability experiment is running already, so stop it first")
+
+// experiment is a stability experiment that is running: the request that started it,
+// when it finishes, how often it measures, when it next measures, the first result,
+// which the others are compared with, and the drift so far
+type experiment struct {
+	request pocket.Stability
+	end     time.Time
+	every   time.Duration
+	next    time.Time
+	first   []pocket.SParam
+	summary pocket.StabilitySummary
+}
+
+// func Stability starts a stability experiment, or stops or queries the one that is
+// running, see pocket.Stability. The sweep is checked as an rq would be, and it is an
+// error to start one while another is running.
+func (m *Middle) Stability(request *pocket.Stability) error {
+
+	if request.Stop || request.Query {
+
+		e := m.experimenting()
+
+		if e == nil {
+			return ErrNoExperiment
+		}
+
+		request.Summary = m.summarise(e)
+
+		if request.Stop {
+			m.stopExperiment(e)
+			log.WithFields(log.Fields{"id": e.request.ID, "measurements": len(request.Summary.Times)}).Warn("stability experiment stopped")
+		}
+
+		return nil
+	}
+
+	if m.experimenting() != nil {
+		return ErrExperimentRunning
+	}
+
+	if request.Every <= 0 {
+		return invalid(fmt.Errorf("every must be a positive number of minutes, not %g", request.Every))
+	}
+
+	if request.Hours <= 0 || request.Hours > MaxStabilityHours {
+		return invalid(fmt.Errorf("hours must be more than 0 and no more than %d, not %g", MaxStabilityHours, request.Hours))
+	}
+
+	if request.Every > request.Hours*60 {
+		return invalid(fmt.Errorf("every must be no more than %g minutes, so that there are at least two measurements, not %g", request.Hours*60, request.Every))
+	}
+
+	if request.Select == (pocket.SParamSelect{}) {
+		return invalid(errors.New("select at least one of s11, s12, s21 and s22 in sparam, to find the drift of"))
+	}
+
+	rq := request.RangeQuery
+	rq.Command = pocket.Command{Command: "rq"}
+
+	// checked as it will be at each measurement, rather than finding out at the first
+	p, err := m.Plan(rq)
+
+	if err != nil {
+		return err
+	}
+
+	points := 0
+
+	for _, s := range p.Steps {
+		points = max(points, s.Points)
+	}
+
+	n := int(request.Hours*60/request.Every) + 1
+
+	if n*points > MaxStabilityPoints {
+		return invalid(fmt.Errorf("%d measurements of %d points is more than the %d points allowed, so measure less often, for less time, or at fewer points", n, points, MaxStabilityPoints))
+	}
+
+	now := time.Now()
+
+	e := &experiment{
+		request: *request,
+		end:     now.Add(time.Duration(request.Hours * float64(time.Hour))),
+		every:   time.Duration(request.Every * float64(time.Minute)),
+		next:    now,
+		summary: pocket.StabilitySummary{
+			Start: now,
+			Times: []float64{},
+			Drift: []pocket.StabilityDrift{},
+		},
+	}
+
+	e.summary.End = e.end
+
+	if m.store != nil {
+		e.summary.Logged = DatalogDir + "stability-" + now.UTC().Format("20060102T150405Z") + ".jsonl"
+	}
+
+	m.setExperiment(e)
+
+	request.Summary = m.summarise(e)
+
+	log.WithFields(log.Fields{"id": request.ID, "what": request.What, "every": request.Every, "hours": request.Hours, "measurements": n}).Info("stability experiment started")
+
+	return nil
+}
+
+// func experimenting returns the stability experiment that is running, nil if there is none
+func (m *Middle) experimenting() *experiment {
+	if m.sweepLock != nil {
+		m.sweepLock.Lock()
+		defer m.sweepLock.Unlock()
+	}
+	return m.experiment
+}
+
+// func setExperiment starts e
+func (m *Middle) setExperiment(e *experiment) {
+	if m.sweepLock != nil {
+		m.sweepLock.Lock()
+		defer m.sweepLock.Unlock()
+	}
+	m.experiment = e
+}
+
+// func stopExperiment stops the stability experiment if it is still e
+func (m *Middle) stopExperiment(e *experiment) {
+	if m.sweepLock != nil {
+		m.sweepLock.Lock()
+		defer m.sweepLock.Unlock()
+	}
+	if m.experiment == e {
+		m.experiment = nil
+	}
+}
+
+// func summarise returns the drift of e so far, which is not changed by the measurements
+// made after, so that it can be sent while e carries on
+func (m *Middle) summarise(e *experiment) *pocket.StabilitySummary {
+
+	if m.sweepLock != nil {
+		m.sweepLock.Lock()
+		defer m.sweepLock.Unlock()
+	}
+
+	s := e.summary
+
+	// later measurements append to the slices of each drift, so copy the drifts, but
+	// not the slices, which only ever grow past what is copied
+	s.Drift = append([]pocket.StabilityDrift{}, e.summary.Drift...)
+
+	return &s
+}
+
+// func StabilityNext makes the next measurement of the stability experiment, if it is
+// due, reading the stream meanwhile, as Serve does, and sends the drift when it is done
+func (m *Middle) StabilityNext() {
+
+	e := m.experimenting()
+
+	if e == nil || time.Now().Before(e.next) {
+		return
+	}
+
+	q := newQueued(e.request)
+
+	m.current = &q
+	defer func() { m.current = nil }()
+
+	m.attend(e.request, false, m.StabilityOnce)
+}
+
+// func StabilityOnce makes the next measurement of the stability experiment, adds it to
+// the datalog, if there is a store, and returns the response to send, which is nothing
+// until the experiment is done. A measurement that fails is logged and left out, rather
+// than stopping an experiment that nobody may be watching.
+func (m *Middle) StabilityOnce(ctx context.Context) interface{} {
+
+	e := m.experimenting()
+
+	if e == nil {
+		return nil // stopped since it was due
+	}
+
+	c := e.request.Command
+
+	rq := e.request.RangeQuery
+	rq.Command = pocket.Command{
+		ID:       c.ID,
+		Time:     int(time.Now().Unix()),
+		Command:  "rq",
+		Priority: c.Priority,
+		Session:  c.Session,
+	}
+
+	at := time.Now()
+
+	response, err := m.Handle(ctx, rq)
+
+	if err == nil {
+		rq, _ = response.(pocket.RangeQuery)
+		m.datalog(e, rq)
+	} else {
+		log.WithFields(log.Fields{"id": c.ID, "error": err.Error()}).Warn("stability experiment measurement failed")
+	}
+
+	if !m.measured(e, at, rq.Result, err) {
+		return nil
+	}
+
+	m.stopExperiment(e)
+
+	s := e.request
+	s.Summary = m.summarise(e)
+
+	log.WithFields(log.Fields{"id": c.ID, "measurements": len(s.Summary.Times), "failed": s.Summary.Failed}).Info("stability experiment finished")
+
+	return s
+}
+
+// func datalog adds rq, a measurement of e, to its datalog, if it has one
+func (m *Middle) datalog(e *experiment, rq pocket.RangeQuery) {
+
+	key := e.summary.Logged
+
+	if key == "" || m.store == nil {
+		return
+	}
+
+	b, err := pocket.Marshal(rq)
+
+	if err == nil {
+		err = m.store.Append(key, append(b, '\n'))
+	}
+
+	if err != nil {
+		log.WithFields(log.Fields{"key": key, "error": err.Error()}).Warn("could not add stability measurement to the datalog")
+	}
+}
+
+// func measured adds result, measured at at, to the drift of e, unless err is set, when it
+// counts it as failed, and works out when the next measurement is due, skipping any that
+// were missed, returning true if there are no more
+func (m *Middle) measured(e *experiment, at time.Time, result []pocket.SParam, err error) bool {
+
+	if m.sweepLock != nil {
+		m.sweepLock.Lock()
+		defer m.sweepLock.Unlock()
+	}
+
+	if err == nil && e.first == nil {
+		e.first = result
+		e.summary.Drift = make([]pocket.StabilityDrift, len(result))
+		for i, p := range result {
+			e.summary.Drift[i].Freq = p.Freq
+		}
+	}
+
+	var d []pocket.FormattedSParam
+
+	if err == nil {
+		d, err = format.Delta(e.first, result)
+	}
+
+	if err != nil {
+		e.summary.Failed++
+	} else {
+
+		e.summary.Times = append(e.summary.Times, at.Sub(e.summary.Start).Seconds())
+
+		for i, v := range d {
+			s := &e.summary.Drift[i]
+			s.S11 = append(s.S11, drifted(v.S11, &s.Max.S11))
+			s.S12 = append(s.S12, drifted(v.S12, &s.Max.S12))
+			s.S21 = append(s.S21, drifted(v.S21, &s.Max.S21))
+			s.S22 = append(s.S22, drifted(v.S22, &s.Max.S22))
+		}
+	}
+
+	now := time.Now()
+
+	for !e.next.After(now) {
+		e.next = e.next.Add(e.every)
+	}
+
+	e.summary.Done = e.next.After(e.end)
+
+	return e.summary.Done
+}
+
+// func drifted returns the change v, with zero in place of a change to or from nothing,
+// which has no value in dB, e.g. a parameter that was not measured, and keeps the
+// largest change in max
+func drifted(v *pocket.Value, max *pocket.Value) pocket.Value {
+
+	d := pocket.Value{}
+
+	if v != nil && !math.IsNaN(v.Mag) && !math.IsInf(v.Mag, 0) {
+		d.Mag = v.Mag
+	}
+
+	if v != nil && !math.IsNaN(v.Phase) {
+		d.Phase = v.Phase
+	}
+
+	largest(max, d)
+
+	return d
+}
@@ -0,0 +1,246 @@
+// package parallel spreads the work on a large sweep, such as converting it for the
+// calibration service or marshalling it to JSON, over a pool of goroutines, one for
+// each processor beyond the caller's. The pool is shared by every request, so that
+// however many are in progress, no more goroutines are busy than there are processors,
+// and a chunk that no worker is free for is done by the caller, so that the work never
+// waits for the pool.
+package parallel
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// MinMarshal is the fewest elements of a slice worth marshalling in another goroutine
+const MinMarshal = 64
+
+// Pool is a fixed number of workers, which are started when first needed
+type Pool struct {
+	size int
+	once sync.Once
+	jobs chan func()
+}
+
+// Default is the pool used by For and Marshal, sized from GOMAXPROCS
+var Default = NewPool(runtime.GOMAXPROCS(0) - 1)
+
+// NewPool returns a pool of size workers, which does everything in the caller if size is zero
+func NewPool(size int) *Pool {
+
+	if size < 0 {
+		size = 0
+	}
+
+	return &Pool{
+		size: size,
+		jobs: make(chan func()), // unbuffered, so a job is only handed to an idle worker
+	}
+}
+
+// Size returns the number of workers
+func (p *Pool) Size() int {
+	return p.size
+}
+
+// Chunks returns how many chunks n elements are split into, so that each has at least
+// min elements, and there is one for each worker and the caller. If it is one, there
+// is no point in using the pool.
+func (p *Pool) Chunks(n, min int) int {
+
+	if min < 1 {
+		min = 1
+	}
+
+	c := n / min
+
+	if c > p.size+1 {
+		c = p.size + 1
+	}
+
+	if c < 1 {
+		c = 1
+	}
+
+	return c
+}
+
+// Run calls each of fns, handing them to idle workers, doing the rest and the last
+// itself, and returns once they have all returned. If any of them panics, Run panics
+// with the same value once they have all returned, so that the panic can be recovered
+// by the caller, as it could be if they had all been called in turn.
+func (p *Pool) Run(fns ...func()) {
+
+	if len(fns) == 0 {
+		return
+	}
+
+	p.once.Do(p.start)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var panicked interface{}
+
+	protect := func(fn func()) func() {
+		return func() {
+			defer func() {
+				if r := recover(); r != nil {
+					mu.Lock()
+					if panicked == nil {
+						panicked = r
+					}
+					mu.Unlock()
+				}
+				wg.Done()
+			}()
+			fn()
+		}
+	}
+
+	wg.Add(len(fns))
+
+	for _, fn := range fns[:len(fns)-1] {
+
+		job := protect(fn)
+
+		select {
+		case p.jobs <- job:
+		default:
+			job()
+		}
+	}
+
+	protect(fns[len(fns)-1])()
+
+	wg.Wait()
+
+	if panicked != nil {
+		panic(panicked)
+	}
+}
+
+// start starts the workers
+func (p *Pool) start() {
+	for i := 0; i < p.size; i++ {
+		go func() {
+			for job := range p.jobs {
+				job()
+			}
+		}()
+	}
+}
+
+// For calls fn on consecutive ranges of elements, from start up to end, that together
+// cover 0 to n, each at least min long, at the same time, and returns once they are done
+func (p *Pool) For(n, min int, fn func(start, end int)) {
+
+	c := p.Chunks(n, min)
+
+	fns := make([]func(), c)
+
+	for i := range fns {
+		start, end := i*n/c, (i+1)*n/c
+		fns[i] = func() { fn(start, end) }
+	}
+
+	p.Run(fns...)
+}
+
+// For is Pool.For on the Default pool
+func For(n, min int, fn func(start, end int)) {
+	Default.For(n, min, fn)
+}
+
+// Marshal returns the JSON of s, the same as json.Marshal does, marshalling chunks of
+// at least MinMarshal elements in the Default pool and joining them
+func Marshal[T any](s []T) ([]byte, error) {
+
+	c := Default.Chunks(len(s), MinMarshal)
+
+	if c == 1 {
+		return json.Marshal(s)
+	}
+
+	parts := make([][]byte, c)
+	errs := make([]error, c)
+
+	fns := make([]func(), c)
+
+	for i := range fns {
+		i, start, end := i, i*len(s)/c, (i+1)*len(s)/c
+		fns[i] = func() { parts[i], errs[i] = json.Marshal(s[start:end]) }
+	}
+
+	Default.Run(fns...)
+
+	size := 1
+
+	for i, b := range parts {
+
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+
+		size += len(b) - 1
+	}
+
+	j := make([]byte, 0, size)
+	j = append(j, '[')
+
+	for i, b := range parts {
+
+		if i > 0 {
+			j = append(j, ',')
+		}
+
+		j = append(j, b[1:len(b)-1]...)
+	}
+
+	return append(j, ']'), nil
+}
+
+// Replace returns the JSON object obj, with value in place of the value old of its
+// top level key, e.g. a placeholder left in a response so that the slice it stands for
+// could be marshalled separately, with Marshal. It is an error if key is not set to old.
+func Replace(obj []byte, key string, old, value []byte) ([]byte, error) {
+
+	k, err := json.Marshal(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	k = append(append(k, ':'), old...)
+
+	depth, quoted, escaped := 0, false, false
+
+	for i, c := range obj {
+
+		switch {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case quoted:
+			quoted = c != '"'
+		case c == '{' || c == '[':
+			depth++
+		case c == '}' || c == ']':
+			depth--
+		case c == '"':
+			// a key, since a value is never followed by a colon
+			if depth == 1 && bytes.HasPrefix(obj[i:], k) {
+				start := i + len(k) - len(old)
+				r := make([]byte, 0, len(obj)-len(old)+len(value))
+				r = append(r, obj[:start]...)
+				r = append(r, value...)
+				return append(r, obj[i+len(k):]...), nil
+			}
+			quoted = true
+		}
+	}
+
+	return nil, fmt.Errorf("%s is not set to %s at the top level of the object", key, old)
+}
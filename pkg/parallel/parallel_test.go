@@ -0,0 +1,126 @@
+package parallel
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunks(t *testing.T) {
+
+	p := NewPool(3)
+
+	assert.Equal(t, 1, p.Chunks(0, 64))
+	assert.Equal(t, 1, p.Chunks(127, 64))
+	assert.Equal(t, 2, p.Chunks(128, 64))
+	assert.Equal(t, 4, p.Chunks(1001, 64))
+	assert.Equal(t, 1, NewPool(0).Chunks(1001, 64))
+	assert.Equal(t, 0, NewPool(-1).Size())
+}
+
+func TestFor(t *testing.T) {
+
+	for _, p := range []*Pool{NewPool(0), NewPool(3)} {
+
+		for _, n := range []int{0, 1, 10, 1001} {
+
+			seen := make([]int32, n)
+			var calls atomic.Int32
+
+			p.For(n, 10, func(start, end int) {
+				calls.Add(1)
+				for i := start; i < end; i++ {
+					atomic.AddInt32(&seen[i], 1)
+				}
+			})
+
+			for i := range seen {
+				assert.Equal(t, int32(1), seen[i], "element %d of %d", i, n)
+			}
+
+			assert.Equal(t, int32(p.Chunks(n, 10)), calls.Load())
+		}
+	}
+}
+
+func TestRunPanic(t *testing.T) {
+
+	p := NewPool(2)
+
+	var done atomic.Int32
+
+	assert.PanicsWithValue(t, "broken", func() {
+		p.Run(func() { panic("broken") }, func() { done.Add(1) }, func() { done.Add(1) })
+	})
+
+	// the others are left to finish first
+	assert.Equal(t, int32(2), done.Load())
+
+	// and the pool still works
+	p.Run(func() { done.Add(1) }, func() { done.Add(1) })
+	assert.Equal(t, int32(4), done.Load())
+}
+
+func TestMarshal(t *testing.T) {
+
+	d := Default
+	defer func() { Default = d }()
+
+	Default = NewPool(3)
+
+	type point struct {
+		Freq  uint64  `json:"freq"`
+		Value float64 `json:"value"`
+		Note  string  `json:"note,omitempty"`
+	}
+
+	for _, n := range []int{0, 1, 100, 1001} {
+
+		s := make([]point, n)
+
+		for i := range s {
+			s[i] = point{Freq: uint64(i * 1000), Value: float64(i) / 7}
+		}
+
+		if n > 0 {
+			s[n-1].Note = `a "quoted", <odd> note`
+		}
+
+		want, err := json.Marshal(s)
+		assert.NoError(t, err)
+
+		got, err := Marshal(s)
+		assert.NoError(t, err)
+		assert.Equal(t, string(want), string(got))
+	}
+
+	b, err := Marshal([]int(nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "null", string(b))
+}
+
+func TestReplace(t *testing.T) {
+
+	null := []byte("null")
+
+	b, err := Replace([]byte(`{"id":"x","result":null,"n":1}`), "result", null, []byte(`[1,2]`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"x","result":[1,2],"n":1}`, string(b))
+
+	// not a nested key, nor one in a string
+	b, err = Replace([]byte(`{"id":"\"result\":null","meta":{"result":null},"result":null}`), "result", null, []byte(`[]`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"id":"\"result\":null","meta":{"result":null},"result":[]}`, string(b))
+
+	b, err = Replace([]byte(`{"a":[{"b":0}],"result":[{"freq":0}]}`), "result", []byte(`[{"freq":0}]`), []byte(`[{"freq":1},{"freq":2}]`))
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":[{"b":0}],"result":[{"freq":1},{"freq":2}]}`, string(b))
+
+	_, err = Replace([]byte(`{"meta":{"result":null}}`), "result", null, []byte(`[]`))
+	assert.Error(t, err)
+
+	_, err = Replace([]byte(`{"result":[1]}`), "result", null, []byte(`[]`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,283 @@
+// package pathloss describes the insertion loss and phase of each path through
+// the rf switch, from a characterization file, so that they can be removed from
+// raw measurements for quick checks without a calibration.
+//
+// Each path has a table of one-way offsets for the line to port 1 of the device,
+// and optionally for the line to port 2 (the same as port 1 if not given). The
+// phase is interpolated linearly between points, so it must be unwrapped.
+package pathloss
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/practable/pocket-vna-two-port/pkg/format"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+// Offset is the one-way loss and phase of a path at one frequency
+type Offset = pocket.PathOffset
+
+// Path is the offsets of the lines from the VNA to each port of the device at one switch position
+type Path = pocket.SwitchPath
+
+// Table is the offsets of each switch path, by position, e.g. short or dut1
+type Table struct {
+	Name  string          `json:"name"`
+	Paths map[string]Path `json:"paths"`
+}
+
+// Load reads a table in JSON format from path, with the positions in lower case
+func Load(path string) (*Table, error) {
+
+	b, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var t Table
+
+	err = json.Unmarshal(b, &t)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read path loss %s because %s", path, err.Error())
+	}
+
+	paths := make(map[string]Path)
+
+	for k, v := range t.Paths {
+		paths[strings.ToLower(k)] = v
+	}
+
+	t.Paths = paths
+
+	err = t.Check()
+
+	if err != nil {
+		return nil, fmt.Errorf("path loss %s is not valid because %s", path, err.Error())
+	}
+
+	return &t, nil
+}
+
+// Check returns an error if there are no paths, or a path has no offsets for port 1,
+// or its frequencies are not increasing
+func (t *Table) Check() error {
+
+	if len(t.Paths) == 0 {
+		return errors.New("there are no paths")
+	}
+
+	for _, k := range t.Positions() {
+
+		p := t.Paths[k]
+
+		if len(p.Port1) == 0 {
+			return fmt.Errorf("%s has no offsets for port1", k)
+		}
+
+		for port, o := range [][]Offset{p.Port1, p.Port2} {
+			for i := 1; i < len(o); i++ {
+				if o[i].Freq <= o[i-1].Freq {
+					return fmt.Errorf("%s port%d frequencies must be increasing", k, port+1)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Positions lists the positions that have a path, in order
+func (t *Table) Positions() []string {
+
+	var k []string
+
+	for p := range t.Paths {
+		k = append(k, p)
+	}
+
+	sort.Strings(k)
+
+	return k
+}
+
+// Apply removes the offsets of the path at position from raw results s, dividing
+// S11 by the round trip of port 1, S22 by that of port 2, and S12 and S21 by the
+// trip through both. It returns an error if the position has no path, or any of
+// the frequencies is outside its table.
+func (t *Table) Apply(position string, s []pocket.SParam) ([]pocket.SParam, error) {
+
+	p, ok := t.Paths[strings.ToLower(position)]
+
+	if !ok {
+		return nil, fmt.Errorf("there is no path loss for %s", position)
+	}
+
+	port2 := p.Port2
+
+	if len(port2) == 0 {
+		port2 = p.Port1
+	}
+
+	r := make([]pocket.SParam, len(s))
+
+	for i, v := range s {
+
+		a1, err := at(p.Port1, v.Freq)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s port1: %s", position, err.Error())
+		}
+
+		a2, err := at(port2, v.Freq)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s port2: %s", position, err.Error())
+		}
+
+		r[i] = pocket.SParam{
+			Freq: v.Freq,
+			S11:  divide(v.S11, a1*a1),
+			S12:  divide(v.S12, a1*a2),
+			S21:  divide(v.S21, a1*a2),
+			S22:  divide(v.S22, a2*a2),
+		}
+	}
+
+	return r, nil
+}
+
+// Save writes the table to path in JSON format, replacing the file only once it is complete
+func (t *Table) Save(path string) error {
+
+	b, err := json.MarshalIndent(t, "", "  ")
+
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+
+	err = os.WriteFile(tmp, b, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Find works out the path at a position from raw, a raw measurement of a standard
+// fitted there, and actual, the S-parameters of that standard at the same frequencies.
+// A short or open gives each line from its reflection. A thru only gives the trip
+// through both lines, so this is shared equally between them.
+func Find(standard string, raw, actual []pocket.SParam) (Path, error) {
+
+	if len(raw) == 0 {
+		return Path{}, errors.New("there is no measurement")
+	}
+
+	if len(raw) != len(actual) {
+		return Path{}, fmt.Errorf("there are %d points in the measurement but %d in the standard", len(raw), len(actual))
+	}
+
+	// the round trip along each line
+	g1 := make([]complex128, len(raw))
+	g2 := make([]complex128, len(raw))
+
+	for i := range raw {
+
+		if raw[i].Freq != actual[i].Freq {
+			return Path{}, fmt.Errorf("point %d is at %d Hz in the measurement but %d Hz in the standard", i, raw[i].Freq, actual[i].Freq)
+		}
+
+		r, a := raw[i], actual[i]
+
+		switch strings.ToLower(standard) {
+
+		case "short", "open":
+			g1[i] = toComplex(r.S11) / toComplex(a.S11)
+			g2[i] = toComplex(r.S22) / toComplex(a.S22)
+
+		case "thru":
+			g := (toComplex(r.S21)/toComplex(a.S21) + toComplex(r.S12)/toComplex(a.S12)) / 2
+			g1[i] = g
+			g2[i] = g
+
+		default:
+			return Path{}, fmt.Errorf("standard must be short, open or thru, not %s", standard)
+		}
+
+		if cmplx.IsInf(g1[i]) || cmplx.IsNaN(g1[i]) || cmplx.IsInf(g2[i]) || cmplx.IsNaN(g2[i]) {
+			return Path{}, fmt.Errorf("the %s has no response at %d Hz", standard, r.Freq)
+		}
+	}
+
+	return Path{Port1: oneWay(raw, g1), Port2: oneWay(raw, g2)}, nil
+}
+
+// oneWay returns the offsets of a line, from its round trip at the frequencies of s
+func oneWay(s []pocket.SParam, g []complex128) []Offset {
+
+	phase := make([]float64, len(g))
+
+	for i, v := range g {
+		phase[i] = cmplx.Phase(v)
+	}
+
+	phase = format.Unwrap(phase)
+
+	o := make([]Offset, len(g))
+
+	for i, v := range g {
+		o[i] = Offset{
+			Freq:  s[i].Freq,
+			Loss:  -10 * math.Log10(cmplx.Abs(v)),
+			Phase: phase[i] / 2 * 180 / math.Pi,
+		}
+	}
+
+	return o
+}
+
+func toComplex(c pocket.Complex) complex128 {
+	return complex(c.Real, c.Imag)
+}
+
+// at returns the one-way transmission of a line at freq, interpolating the loss and phase
+func at(o []Offset, freq uint64) (complex128, error) {
+
+	first := o[0].Freq
+	last := o[len(o)-1].Freq
+
+	if freq < first || freq > last {
+		return 0, fmt.Errorf("frequency %d Hz is outside the range %d - %d Hz", freq, first, last)
+	}
+
+	j := sort.Search(len(o), func(i int) bool { return o[i].Freq >= freq })
+
+	loss, phase := o[j].Loss, o[j].Phase
+
+	if o[j].Freq != freq {
+		lo := o[j-1]
+		hi := o[j]
+		x := float64(freq-lo.Freq) / float64(hi.Freq-lo.Freq)
+		loss = lo.Loss + x*(hi.Loss-lo.Loss)
+		phase = lo.Phase + x*(hi.Phase-lo.Phase)
+	}
+
+	return cmplx.Rect(math.Pow(10, -loss/20), phase*math.Pi/180), nil
+}
+
+func divide(c pocket.Complex, a complex128) pocket.Complex {
+	z := toComplex(c) / a
+	return pocket.Complex{Real: real(z), Imag: imag(z)}
+}
@@ -0,0 +1,163 @@
+package pathloss
+
+import (
+	"math"
+	"math/cmplx"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func fromComplex(z complex128) pocket.Complex {
+	return pocket.Complex{Real: real(z), Imag: imag(z)}
+}
+
+func TestLoad(t *testing.T) {
+
+	p, err := Load("testdata/paths.json")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "test switch", p.Name)
+	assert.Equal(t, []string{"dut1", "thru"}, p.Positions())
+
+	_, err = Load("testdata/missing.json")
+	assert.Error(t, err)
+
+	dir := t.TempDir()
+	bad := filepath.Join(dir, "bad.json")
+
+	for _, s := range []string{
+		`{"paths":{}}`,
+		`{"paths":{"dut1":{}}}`,
+		`{"paths":{"dut1":{"port1":[{"freq":2},{"freq":1}]}}}`,
+		`{"paths":{"dut1":{"port1":[{"freq":1}],"port2":[{"freq":2},{"freq":2}]}}}`,
+		`{"paths":`,
+	} {
+		assert.NoError(t, os.WriteFile(bad, []byte(s), 0644))
+		_, err = Load(bad)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestApply(t *testing.T) {
+
+	p, err := Load("testdata/paths.json")
+	assert.NoError(t, err)
+
+	// half way, so 1dB and -90 degrees each way along both lines
+	a := -math.Pow(10, -0.1)
+	m := fromComplex(complex(a, 0))
+	f := uint64(1500500000)
+
+	s, err := p.Apply("dut1", []pocket.SParam{{Freq: f, S11: m, S12: m, S21: m, S22: m}})
+
+	assert.NoError(t, err)
+	assert.Equal(t, f, s[0].Freq)
+
+	for _, v := range []pocket.Complex{s[0].S11, s[0].S12, s[0].S21, s[0].S22} {
+		assert.InDelta(t, 1, v.Real, 1e-9)
+		assert.InDelta(t, 0, v.Imag, 1e-9)
+	}
+
+	// different lines to each port
+	a1 := cmplx.Rect(math.Pow(10, -1.0/20), -10*math.Pi/180)
+	a2 := cmplx.Rect(math.Pow(10, -3.0/20), 20*math.Pi/180)
+	d := complex(0.3, -0.1)
+
+	s, err = p.Apply("Thru", []pocket.SParam{{
+		Freq: 1e6,
+		S11:  fromComplex(d * a1 * a1),
+		S12:  fromComplex(d * a1 * a2),
+		S21:  fromComplex(d * a1 * a2),
+		S22:  fromComplex(d * a2 * a2),
+	}})
+
+	assert.NoError(t, err)
+
+	for _, v := range []pocket.Complex{s[0].S11, s[0].S12, s[0].S21, s[0].S22} {
+		assert.InDelta(t, 0, cmplx.Abs(toComplex(v)-d), 1e-9)
+	}
+
+	_, err = p.Apply("dut2", s)
+	assert.Error(t, err)
+
+	_, err = p.Apply("dut1", []pocket.SParam{{Freq: 4e9}})
+	assert.Error(t, err)
+}
+
+func TestFind(t *testing.T) {
+
+	// lines with 1ns and 2ns of delay, getting lossier with frequency
+	var freq []uint64
+
+	for f := uint64(100e6); f <= 3e9; f += 100e6 {
+		freq = append(freq, f)
+	}
+
+	line := func(f uint64, delay float64) complex128 {
+		return cmplx.Rect(math.Pow(10, -float64(f)/1e9/20), -2*math.Pi*float64(f)*delay)
+	}
+
+	var raw, actual []pocket.SParam
+
+	for _, f := range freq {
+		a1, a2 := line(f, 1e-9), line(f, 2e-9)
+		raw = append(raw, pocket.SParam{Freq: f, S11: fromComplex(-a1 * a1), S22: fromComplex(-a2 * a2)})
+		actual = append(actual, pocket.SParam{Freq: f, S11: fromComplex(-1), S22: fromComplex(-1)})
+	}
+
+	p, err := Find("Short", raw, actual)
+	assert.NoError(t, err)
+	assert.Equal(t, 30, len(p.Port1))
+	assert.Equal(t, uint64(3e9), p.Port2[29].Freq)
+	assert.InDelta(t, 3, p.Port1[29].Loss, 1e-9)
+	assert.InDelta(t, -1080, p.Port1[29].Phase, 1e-6) // unwrapped
+	assert.InDelta(t, -2160, p.Port2[29].Phase, 1e-6)
+
+	// the short is found again
+	table := Table{Paths: map[string]Path{"short": p}}
+	s, err := table.Apply("short", raw)
+	assert.NoError(t, err)
+
+	for i := range s {
+		assert.InDelta(t, -1, s[i].S11.Real, 1e-9)
+		assert.InDelta(t, -1, s[i].S22.Real, 1e-9)
+	}
+
+	// a thru shares the loss between the lines
+	raw = []pocket.SParam{{Freq: 1e9, S12: fromComplex(0.25), S21: fromComplex(0.25)}}
+	actual = []pocket.SParam{{Freq: 1e9, S12: fromComplex(1), S21: fromComplex(1)}}
+
+	p, err = Find("thru", raw, actual)
+	assert.NoError(t, err)
+	assert.InDelta(t, 6.0206, p.Port1[0].Loss, 1e-4)
+	assert.Equal(t, p.Port1, p.Port2)
+
+	_, err = Find("load", raw, actual)
+	assert.Error(t, err)
+
+	_, err = Find("thru", raw, nil)
+	assert.Error(t, err)
+
+	_, err = Find("thru", raw, []pocket.SParam{{Freq: 2e9}})
+	assert.Error(t, err)
+
+	_, err = Find("thru", raw, []pocket.SParam{{Freq: 1e9}})
+	assert.Error(t, err)
+}
+
+func TestSave(t *testing.T) {
+
+	p, err := Load("testdata/paths.json")
+	assert.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "saved.json")
+	assert.NoError(t, p.Save(file))
+
+	q, err := Load(file)
+	assert.NoError(t, err)
+	assert.Equal(t, p, q)
+}
@@ -80,8 +80,9 @@ type CalibrateTwoPortResponse struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Frequency []float64 `protobuf:"fixed64,1,rep,packed,name=frequency,proto3" json:"frequency,omitempty"`
-	Result    *SParams  `protobuf:"bytes,2,opt,name=result,proto3" json:"result,omitempty"`
+	Frequency  []float64   `protobuf:"fixed64,1,rep,packed,name=frequency,proto3" json:"frequency,omitempty"`
+	Result     *SParams    `protobuf:"bytes,2,opt,name=result,proto3" json:"result,omitempty"`
+	ErrorTerms *ErrorTerms `protobuf:"bytes,3,opt,name=error_terms,json=errorTerms,proto3" json:"error_terms,omitempty"`
 }
 
 func (x *CalibrateTwoPortResponse) Reset() {
@@ -130,6 +131,13 @@ func (x *CalibrateTwoPortResponse) GetResult() *SParams {
 	return nil
 }
 
+func (x *CalibrateTwoPortResponse) GetErrorTerms() *ErrorTerms {
+	if x != nil {
+		return x.ErrorTerms
+	}
+	return nil
+}
+
 type CalibrateOnePortRequest struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -222,12 +230,16 @@ type CalibrateTwoPortRequest struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Frequency []float64 `protobuf:"fixed64,1,rep,packed,name=frequency,proto3" json:"frequency,omitempty"`
-	Short     *SParams  `protobuf:"bytes,2,opt,name=short,proto3" json:"short,omitempty"`
-	Open      *SParams  `protobuf:"bytes,3,opt,name=open,proto3" json:"open,omitempty"`
-	Load      *SParams  `protobuf:"bytes,4,opt,name=load,proto3" json:"load,omitempty"`
-	Thru      *SParams  `protobuf:"bytes,5,opt,name=thru,proto3" json:"thru,omitempty"`
-	Dut       *SParams  `protobuf:"bytes,6,opt,name=dut,proto3" json:"dut,omitempty"`
+	Frequency  []float64 `protobuf:"fixed64,1,rep,packed,name=frequency,proto3" json:"frequency,omitempty"`
+	Short      *SParams  `protobuf:"bytes,2,opt,name=short,proto3" json:"short,omitempty"`
+	Open       *SParams  `protobuf:"bytes,3,opt,name=open,proto3" json:"open,omitempty"`
+	Load       *SParams  `protobuf:"bytes,4,opt,name=load,proto3" json:"load,omitempty"`
+	Thru       *SParams  `protobuf:"bytes,5,opt,name=thru,proto3" json:"thru,omitempty"`
+	Dut        *SParams  `protobuf:"bytes,6,opt,name=dut,proto3" json:"dut,omitempty"`
+	IdealShort *SParams  `protobuf:"bytes,7,opt,name=ideal_short,json=idealShort,proto3" json:"ideal_short,omitempty"`
+	IdealOpen  *SParams  `protobuf:"bytes,8,opt,name=ideal_open,json=idealOpen,proto3" json:"ideal_open,omitempty"`
+	IdealLoad  *SParams  `protobuf:"bytes,9,opt,name=ideal_load,json=idealLoad,proto3" json:"ideal_load,omitempty"`
+	IdealThru  *SParams  `protobuf:"bytes,10,opt,name=ideal_thru,json=idealThru,proto3" json:"ideal_thru,omitempty"`
 }
 
 func (x *CalibrateTwoPortRequest) Reset() {
@@ -304,6 +316,34 @@ func (x *CalibrateTwoPortRequest) GetDut() *SParams {
 	return nil
 }
 
+func (x *CalibrateTwoPortRequest) GetIdealShort() *SParams {
+	if x != nil {
+		return x.IdealShort
+	}
+	return nil
+}
+
+func (x *CalibrateTwoPortRequest) GetIdealOpen() *SParams {
+	if x != nil {
+		return x.IdealOpen
+	}
+	return nil
+}
+
+func (x *CalibrateTwoPortRequest) GetIdealLoad() *SParams {
+	if x != nil {
+		return x.IdealLoad
+	}
+	return nil
+}
+
+func (x *CalibrateTwoPortRequest) GetIdealThru() *SParams {
+	if x != nil {
+		return x.IdealThru
+	}
+	return nil
+}
+
 type SParams struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -375,6 +415,141 @@ func (x *SParams) GetS22() []*Complex {
 	return nil
 }
 
+type ErrorTerms struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ForwardDirectivity          []*Complex `protobuf:"bytes,1,rep,name=forward_directivity,json=forwardDirectivity,proto3" json:"forward_directivity,omitempty"`
+	ForwardSourceMatch          []*Complex `protobuf:"bytes,2,rep,name=forward_source_match,json=forwardSourceMatch,proto3" json:"forward_source_match,omitempty"`
+	ForwardReflectionTracking   []*Complex `protobuf:"bytes,3,rep,name=forward_reflection_tracking,json=forwardReflectionTracking,proto3" json:"forward_reflection_tracking,omitempty"`
+	ForwardTransmissionTracking []*Complex `protobuf:"bytes,4,rep,name=forward_transmission_tracking,json=forwardTransmissionTracking,proto3" json:"forward_transmission_tracking,omitempty"`
+	ForwardLoadMatch            []*Complex `protobuf:"bytes,5,rep,name=forward_load_match,json=forwardLoadMatch,proto3" json:"forward_load_match,omitempty"`
+	ForwardIsolation            []*Complex `protobuf:"bytes,6,rep,name=forward_isolation,json=forwardIsolation,proto3" json:"forward_isolation,omitempty"`
+	ReverseDirectivity          []*Complex `protobuf:"bytes,7,rep,name=reverse_directivity,json=reverseDirectivity,proto3" json:"reverse_directivity,omitempty"`
+	ReverseSourceMatch          []*Complex `protobuf:"bytes,8,rep,name=reverse_source_match,json=reverseSourceMatch,proto3" json:"reverse_source_match,omitempty"`
+	ReverseReflectionTracking   []*Complex `protobuf:"bytes,9,rep,name=reverse_reflection_tracking,json=reverseReflectionTracking,proto3" json:"reverse_reflection_tracking,omitempty"`
+	ReverseTransmissionTracking []*Complex `protobuf:"bytes,10,rep,name=reverse_transmission_tracking,json=reverseTransmissionTracking,proto3" json:"reverse_transmission_tracking,omitempty"`
+	ReverseLoadMatch            []*Complex `protobuf:"bytes,11,rep,name=reverse_load_match,json=reverseLoadMatch,proto3" json:"reverse_load_match,omitempty"`
+	ReverseIsolation            []*Complex `protobuf:"bytes,12,rep,name=reverse_isolation,json=reverseIsolation,proto3" json:"reverse_isolation,omitempty"`
+}
+
+func (x *ErrorTerms) Reset() {
+	*x = ErrorTerms{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_calibrate_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ErrorTerms) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ErrorTerms) ProtoMessage() {}
+
+func (x *ErrorTerms) ProtoReflect() protoreflect.Message {
+	mi := &file_calibrate_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ErrorTerms.ProtoReflect.Descriptor instead.
+func (*ErrorTerms) Descriptor() ([]byte, []int) {
+	return file_calibrate_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ErrorTerms) GetForwardDirectivity() []*Complex {
+	if x != nil {
+		return x.ForwardDirectivity
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetForwardSourceMatch() []*Complex {
+	if x != nil {
+		return x.ForwardSourceMatch
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetForwardReflectionTracking() []*Complex {
+	if x != nil {
+		return x.ForwardReflectionTracking
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetForwardTransmissionTracking() []*Complex {
+	if x != nil {
+		return x.ForwardTransmissionTracking
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetForwardLoadMatch() []*Complex {
+	if x != nil {
+		return x.ForwardLoadMatch
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetForwardIsolation() []*Complex {
+	if x != nil {
+		return x.ForwardIsolation
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetReverseDirectivity() []*Complex {
+	if x != nil {
+		return x.ReverseDirectivity
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetReverseSourceMatch() []*Complex {
+	if x != nil {
+		return x.ReverseSourceMatch
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetReverseReflectionTracking() []*Complex {
+	if x != nil {
+		return x.ReverseReflectionTracking
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetReverseTransmissionTracking() []*Complex {
+	if x != nil {
+		return x.ReverseTransmissionTracking
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetReverseLoadMatch() []*Complex {
+	if x != nil {
+		return x.ReverseLoadMatch
+	}
+	return nil
+}
+
+func (x *ErrorTerms) GetReverseIsolation() []*Complex {
+	if x != nil {
+		return x.ReverseIsolation
+	}
+	return nil
+}
+
 type Complex struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -387,7 +562,7 @@ type Complex struct {
 func (x *Complex) Reset() {
 	*x = Complex{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_calibrate_proto_msgTypes[5]
+		mi := &file_calibrate_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -400,7 +575,7 @@ func (x *Complex) String() string {
 func (*Complex) ProtoMessage() {}
 
 func (x *Complex) ProtoReflect() protoreflect.Message {
-	mi := &file_calibrate_proto_msgTypes[5]
+	mi := &file_calibrate_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -413,7 +588,7 @@ func (x *Complex) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Complex.ProtoReflect.Descriptor instead.
 func (*Complex) Descriptor() ([]byte, []int) {
-	return file_calibrate_proto_rawDescGZIP(), []int{5}
+	return file_calibrate_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *Complex) GetImag() float64 {
@@ -430,6 +605,479 @@ func (x *Complex) GetReal() float64 {
 	return 0
 }
 
+type MeasureRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	What       string  `protobuf:"bytes,1,opt,name=what,proto3" json:"what,omitempty"`
+	Calibrated bool    `protobuf:"varint,2,opt,name=calibrated,proto3" json:"calibrated,omitempty"`
+	Start      uint64  `protobuf:"varint,3,opt,name=start,proto3" json:"start,omitempty"`
+	End        uint64  `protobuf:"varint,4,opt,name=end,proto3" json:"end,omitempty"`
+	Size       int32   `protobuf:"varint,5,opt,name=size,proto3" json:"size,omitempty"`
+	Log        bool    `protobuf:"varint,6,opt,name=log,proto3" json:"log,omitempty"`
+	Avg        uint32  `protobuf:"varint,7,opt,name=avg,proto3" json:"avg,omitempty"`
+	Sweeps     int32   `protobuf:"varint,8,opt,name=sweeps,proto3" json:"sweeps,omitempty"`
+	Reject     string  `protobuf:"bytes,9,opt,name=reject,proto3" json:"reject,omitempty"`
+	Z0         float64 `protobuf:"fixed64,10,opt,name=z0,proto3" json:"z0,omitempty"`
+}
+
+func (x *MeasureRequest) Reset() {
+	*x = MeasureRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_calibrate_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MeasureRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MeasureRequest) ProtoMessage() {}
+
+func (x *MeasureRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_calibrate_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MeasureRequest.ProtoReflect.Descriptor instead.
+func (*MeasureRequest) Descriptor() ([]byte, []int) {
+	return file_calibrate_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *MeasureRequest) GetWhat() string {
+	if x != nil {
+		return x.What
+	}
+	return ""
+}
+
+func (x *MeasureRequest) GetCalibrated() bool {
+	if x != nil {
+		return x.Calibrated
+	}
+	return false
+}
+
+func (x *MeasureRequest) GetStart() uint64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *MeasureRequest) GetEnd() uint64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+func (x *MeasureRequest) GetSize() int32 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *MeasureRequest) GetLog() bool {
+	if x != nil {
+		return x.Log
+	}
+	return false
+}
+
+func (x *MeasureRequest) GetAvg() uint32 {
+	if x != nil {
+		return x.Avg
+	}
+	return 0
+}
+
+func (x *MeasureRequest) GetSweeps() int32 {
+	if x != nil {
+		return x.Sweeps
+	}
+	return 0
+}
+
+func (x *MeasureRequest) GetReject() string {
+	if x != nil {
+		return x.Reject
+	}
+	return ""
+}
+
+func (x *MeasureRequest) GetZ0() float64 {
+	if x != nil {
+		return x.Z0
+	}
+	return 0
+}
+
+type MeasureResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Frequency []float64 `protobuf:"fixed64,1,rep,packed,name=frequency,proto3" json:"frequency,omitempty"`
+	Result    *SParams  `protobuf:"bytes,2,opt,name=result,proto3" json:"result,omitempty"`
+}
+
+func (x *MeasureResponse) Reset() {
+	*x = MeasureResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_calibrate_proto_msgTypes[8]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *MeasureResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*MeasureResponse) ProtoMessage() {}
+
+func (x *MeasureResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_calibrate_proto_msgTypes[8]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use MeasureResponse.ProtoReflect.Descriptor instead.
+func (*MeasureResponse) Descriptor() ([]byte, []int) {
+	return file_calibrate_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *MeasureResponse) GetFrequency() []float64 {
+	if x != nil {
+		return x.Frequency
+	}
+	return nil
+}
+
+func (x *MeasureResponse) GetResult() *SParams {
+	if x != nil {
+		return x.Result
+	}
+	return nil
+}
+
+type CalibrateRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Start  uint64 `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	End    uint64 `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+	Size   int32  `protobuf:"varint,3,opt,name=size,proto3" json:"size,omitempty"`
+	Log    bool   `protobuf:"varint,4,opt,name=log,proto3" json:"log,omitempty"`
+	Avg    uint32 `protobuf:"varint,5,opt,name=avg,proto3" json:"avg,omitempty"`
+	Sweeps int32  `protobuf:"varint,6,opt,name=sweeps,proto3" json:"sweeps,omitempty"`
+	Reject string `protobuf:"bytes,7,opt,name=reject,proto3" json:"reject,omitempty"`
+}
+
+func (x *CalibrateRequest) Reset() {
+	*x = CalibrateRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_calibrate_proto_msgTypes[9]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CalibrateRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CalibrateRequest) ProtoMessage() {}
+
+func (x *CalibrateRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_calibrate_proto_msgTypes[9]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CalibrateRequest.ProtoReflect.Descriptor instead.
+func (*CalibrateRequest) Descriptor() ([]byte, []int) {
+	return file_calibrate_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *CalibrateRequest) GetStart() uint64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *CalibrateRequest) GetEnd() uint64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+func (x *CalibrateRequest) GetSize() int32 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *CalibrateRequest) GetLog() bool {
+	if x != nil {
+		return x.Log
+	}
+	return false
+}
+
+func (x *CalibrateRequest) GetAvg() uint32 {
+	if x != nil {
+		return x.Avg
+	}
+	return 0
+}
+
+func (x *CalibrateRequest) GetSweeps() int32 {
+	if x != nil {
+		return x.Sweeps
+	}
+	return 0
+}
+
+func (x *CalibrateRequest) GetReject() string {
+	if x != nil {
+		return x.Reject
+	}
+	return ""
+}
+
+type CalibrateResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Frequency []float64 `protobuf:"fixed64,1,rep,packed,name=frequency,proto3" json:"frequency,omitempty"`
+	Thru      *SParams  `protobuf:"bytes,2,opt,name=thru,proto3" json:"thru,omitempty"`
+}
+
+func (x *CalibrateResponse) Reset() {
+	*x = CalibrateResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_calibrate_proto_msgTypes[10]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CalibrateResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CalibrateResponse) ProtoMessage() {}
+
+func (x *CalibrateResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_calibrate_proto_msgTypes[10]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CalibrateResponse.ProtoReflect.Descriptor instead.
+func (*CalibrateResponse) Descriptor() ([]byte, []int) {
+	return file_calibrate_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *CalibrateResponse) GetFrequency() []float64 {
+	if x != nil {
+		return x.Frequency
+	}
+	return nil
+}
+
+func (x *CalibrateResponse) GetThru() *SParams {
+	if x != nil {
+		return x.Thru
+	}
+	return nil
+}
+
+type StatusRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *StatusRequest) Reset() {
+	*x = StatusRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_calibrate_proto_msgTypes[11]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusRequest) ProtoMessage() {}
+
+func (x *StatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_calibrate_proto_msgTypes[11]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusRequest.ProtoReflect.Descriptor instead.
+func (*StatusRequest) Descriptor() ([]byte, []int) {
+	return file_calibrate_proto_rawDescGZIP(), []int{11}
+}
+
+type StatusResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Protocol     int32    `protobuf:"varint,1,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	MinFrequency uint64   `protobuf:"varint,2,opt,name=min_frequency,json=minFrequency,proto3" json:"min_frequency,omitempty"`
+	MaxFrequency uint64   `protobuf:"varint,3,opt,name=max_frequency,json=maxFrequency,proto3" json:"max_frequency,omitempty"`
+	Calibrated   bool     `protobuf:"varint,4,opt,name=calibrated,proto3" json:"calibrated,omitempty"`
+	Start        uint64   `protobuf:"varint,5,opt,name=start,proto3" json:"start,omitempty"`
+	End          uint64   `protobuf:"varint,6,opt,name=end,proto3" json:"end,omitempty"`
+	Size         int32    `protobuf:"varint,7,opt,name=size,proto3" json:"size,omitempty"`
+	Log          bool     `protobuf:"varint,8,opt,name=log,proto3" json:"log,omitempty"`
+	Positions    []string `protobuf:"bytes,9,rep,name=positions,proto3" json:"positions,omitempty"`
+}
+
+func (x *StatusResponse) Reset() {
+	*x = StatusResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_calibrate_proto_msgTypes[12]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *StatusResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StatusResponse) ProtoMessage() {}
+
+func (x *StatusResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_calibrate_proto_msgTypes[12]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StatusResponse.ProtoReflect.Descriptor instead.
+func (*StatusResponse) Descriptor() ([]byte, []int) {
+	return file_calibrate_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *StatusResponse) GetProtocol() int32 {
+	if x != nil {
+		return x.Protocol
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetMinFrequency() uint64 {
+	if x != nil {
+		return x.MinFrequency
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetMaxFrequency() uint64 {
+	if x != nil {
+		return x.MaxFrequency
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetCalibrated() bool {
+	if x != nil {
+		return x.Calibrated
+	}
+	return false
+}
+
+func (x *StatusResponse) GetStart() uint64 {
+	if x != nil {
+		return x.Start
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetEnd() uint64 {
+	if x != nil {
+		return x.End
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetSize() int32 {
+	if x != nil {
+		return x.Size
+	}
+	return 0
+}
+
+func (x *StatusResponse) GetLog() bool {
+	if x != nil {
+		return x.Log
+	}
+	return false
+}
+
+func (x *StatusResponse) GetPositions() []string {
+	if x != nil {
+		return x.Positions
+	}
+	return nil
+}
+
 var File_calibrate_proto protoreflect.FileDescriptor
 
 var file_calibrate_proto_rawDesc = []byte{
@@ -440,67 +1088,196 @@ var file_calibrate_proto_rawDesc = []byte{
 	0x20, 0x03, 0x28, 0x01, 0x52, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x12,
 	0x23, 0x0a, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32,
 	0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x06, 0x72, 0x65,
-	0x73, 0x75, 0x6c, 0x74, 0x22, 0x5d, 0x0a, 0x18, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74,
-	0x65, 0x54, 0x77, 0x6f, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x73, 0x75, 0x6c, 0x74, 0x22, 0x8e, 0x01, 0x0a, 0x18, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61,
+	0x74, 0x65, 0x54, 0x77, 0x6f, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73,
+	0x65, 0x12, 0x1c, 0x0a, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x01, 0x52, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x12,
+	0x23, 0x0a, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x06, 0x72, 0x65,
+	0x73, 0x75, 0x6c, 0x74, 0x12, 0x2f, 0x0a, 0x0b, 0x65, 0x72, 0x72, 0x6f, 0x72, 0x5f, 0x74, 0x65,
+	0x72, 0x6d, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x70, 0x62, 0x2e, 0x45,
+	0x72, 0x72, 0x6f, 0x72, 0x54, 0x65, 0x72, 0x6d, 0x73, 0x52, 0x0a, 0x65, 0x72, 0x72, 0x6f, 0x72,
+	0x54, 0x65, 0x72, 0x6d, 0x73, 0x22, 0xdc, 0x01, 0x0a, 0x17, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72,
+	0x61, 0x74, 0x65, 0x4f, 0x6e, 0x65, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x12, 0x1c, 0x0a, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x01, 0x52, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x12,
+	0x21, 0x0a, 0x05, 0x73, 0x68, 0x6f, 0x72, 0x74, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b,
+	0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x05, 0x73, 0x68, 0x6f,
+	0x72, 0x74, 0x12, 0x1f, 0x0a, 0x04, 0x6f, 0x70, 0x65, 0x6e, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b,
+	0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x04, 0x6f,
+	0x70, 0x65, 0x6e, 0x12, 0x1f, 0x0a, 0x04, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x04, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x04,
+	0x6c, 0x6f, 0x61, 0x64, 0x12, 0x1f, 0x0a, 0x04, 0x74, 0x68, 0x72, 0x75, 0x18, 0x05, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52,
+	0x04, 0x74, 0x68, 0x72, 0x75, 0x12, 0x1d, 0x0a, 0x03, 0x64, 0x75, 0x74, 0x18, 0x06, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52,
+	0x03, 0x64, 0x75, 0x74, 0x22, 0x8e, 0x03, 0x0a, 0x17, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61,
+	0x74, 0x65, 0x54, 0x77, 0x6f, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
 	0x12, 0x1c, 0x0a, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x01, 0x20,
-	0x03, 0x28, 0x01, 0x52, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x12, 0x23,
-	0x0a, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b,
-	0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x06, 0x72, 0x65, 0x73,
-	0x75, 0x6c, 0x74, 0x22, 0xdc, 0x01, 0x0a, 0x17, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74,
-	0x65, 0x4f, 0x6e, 0x65, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12,
-	0x1c, 0x0a, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x01, 0x20, 0x03,
-	0x28, 0x01, 0x52, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x12, 0x21, 0x0a,
-	0x05, 0x73, 0x68, 0x6f, 0x72, 0x74, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70,
-	0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x05, 0x73, 0x68, 0x6f, 0x72, 0x74,
-	0x12, 0x1f, 0x0a, 0x04, 0x6f, 0x70, 0x65, 0x6e, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b,
-	0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x04, 0x6f, 0x70, 0x65,
-	0x6e, 0x12, 0x1f, 0x0a, 0x04, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32,
-	0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x04, 0x6c, 0x6f,
-	0x61, 0x64, 0x12, 0x1f, 0x0a, 0x04, 0x74, 0x68, 0x72, 0x75, 0x18, 0x05, 0x20, 0x03, 0x28, 0x0b,
-	0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x04, 0x74,
-	0x68, 0x72, 0x75, 0x12, 0x1d, 0x0a, 0x03, 0x64, 0x75, 0x74, 0x18, 0x06, 0x20, 0x03, 0x28, 0x0b,
-	0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x03, 0x64,
-	0x75, 0x74, 0x22, 0xdc, 0x01, 0x0a, 0x17, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65,
-	0x54, 0x77, 0x6f, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1c,
-	0x0a, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x01, 0x20, 0x03, 0x28,
-	0x01, 0x52, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x12, 0x21, 0x0a, 0x05,
-	0x73, 0x68, 0x6f, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62,
-	0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x05, 0x73, 0x68, 0x6f, 0x72, 0x74, 0x12,
-	0x1f, 0x0a, 0x04, 0x6f, 0x70, 0x65, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
-	0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x04, 0x6f, 0x70, 0x65, 0x6e,
-	0x12, 0x1f, 0x0a, 0x04, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b,
-	0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x04, 0x6c, 0x6f, 0x61,
-	0x64, 0x12, 0x1f, 0x0a, 0x04, 0x74, 0x68, 0x72, 0x75, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32,
-	0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x04, 0x74, 0x68,
-	0x72, 0x75, 0x12, 0x1d, 0x0a, 0x03, 0x64, 0x75, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32,
-	0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x03, 0x64, 0x75,
-	0x74, 0x22, 0x85, 0x01, 0x0a, 0x07, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x1d, 0x0a,
-	0x03, 0x73, 0x31, 0x31, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e,
-	0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x03, 0x73, 0x31, 0x31, 0x12, 0x1d, 0x0a, 0x03,
-	0x73, 0x31, 0x32, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43,
-	0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x03, 0x73, 0x31, 0x32, 0x12, 0x1d, 0x0a, 0x03, 0x73,
-	0x32, 0x31, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f,
-	0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x03, 0x73, 0x32, 0x31, 0x12, 0x1d, 0x0a, 0x03, 0x73, 0x32,
-	0x32, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d,
-	0x70, 0x6c, 0x65, 0x78, 0x52, 0x03, 0x73, 0x32, 0x32, 0x22, 0x31, 0x0a, 0x07, 0x43, 0x6f, 0x6d,
-	0x70, 0x6c, 0x65, 0x78, 0x12, 0x12, 0x0a, 0x04, 0x69, 0x6d, 0x61, 0x67, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x01, 0x52, 0x04, 0x69, 0x6d, 0x61, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x72, 0x65, 0x61, 0x6c,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x04, 0x72, 0x65, 0x61, 0x6c, 0x32, 0xad, 0x01, 0x0a,
-	0x09, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x12, 0x4f, 0x0a, 0x10, 0x43, 0x61,
-	0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x4f, 0x6e, 0x65, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x1b,
-	0x2e, 0x70, 0x62, 0x2e, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x4f, 0x6e, 0x65,
+	0x03, 0x28, 0x01, 0x52, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x12, 0x21,
+	0x0a, 0x05, 0x73, 0x68, 0x6f, 0x72, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
+	0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x05, 0x73, 0x68, 0x6f, 0x72,
+	0x74, 0x12, 0x1f, 0x0a, 0x04, 0x6f, 0x70, 0x65, 0x6e, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x04, 0x6f, 0x70,
+	0x65, 0x6e, 0x12, 0x1f, 0x0a, 0x04, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x04, 0x6c,
+	0x6f, 0x61, 0x64, 0x12, 0x1f, 0x0a, 0x04, 0x74, 0x68, 0x72, 0x75, 0x18, 0x05, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x04,
+	0x74, 0x68, 0x72, 0x75, 0x12, 0x1d, 0x0a, 0x03, 0x64, 0x75, 0x74, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x03,
+	0x64, 0x75, 0x74, 0x12, 0x2c, 0x0a, 0x0b, 0x69, 0x64, 0x65, 0x61, 0x6c, 0x5f, 0x73, 0x68, 0x6f,
+	0x72, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50,
+	0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x0a, 0x69, 0x64, 0x65, 0x61, 0x6c, 0x53, 0x68, 0x6f, 0x72,
+	0x74, 0x12, 0x2a, 0x0a, 0x0a, 0x69, 0x64, 0x65, 0x61, 0x6c, 0x5f, 0x6f, 0x70, 0x65, 0x6e, 0x18,
+	0x08, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61,
+	0x6d, 0x73, 0x52, 0x09, 0x69, 0x64, 0x65, 0x61, 0x6c, 0x4f, 0x70, 0x65, 0x6e, 0x12, 0x2a, 0x0a,
+	0x0a, 0x69, 0x64, 0x65, 0x61, 0x6c, 0x5f, 0x6c, 0x6f, 0x61, 0x64, 0x18, 0x09, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x09,
+	0x69, 0x64, 0x65, 0x61, 0x6c, 0x4c, 0x6f, 0x61, 0x64, 0x12, 0x2a, 0x0a, 0x0a, 0x69, 0x64, 0x65,
+	0x61, 0x6c, 0x5f, 0x74, 0x68, 0x72, 0x75, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
+	0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52, 0x09, 0x69, 0x64, 0x65, 0x61,
+	0x6c, 0x54, 0x68, 0x72, 0x75, 0x22, 0x85, 0x01, 0x0a, 0x07, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d,
+	0x73, 0x12, 0x1d, 0x0a, 0x03, 0x73, 0x31, 0x31, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b,
+	0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x03, 0x73, 0x31, 0x31,
+	0x12, 0x1d, 0x0a, 0x03, 0x73, 0x31, 0x32, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
+	0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x03, 0x73, 0x31, 0x32, 0x12,
+	0x1d, 0x0a, 0x03, 0x73, 0x32, 0x31, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70,
+	0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x03, 0x73, 0x32, 0x31, 0x12, 0x1d,
+	0x0a, 0x03, 0x73, 0x32, 0x32, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62,
+	0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x03, 0x73, 0x32, 0x32, 0x22, 0xac, 0x06,
+	0x0a, 0x0a, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x54, 0x65, 0x72, 0x6d, 0x73, 0x12, 0x3c, 0x0a, 0x13,
+	0x66, 0x6f, 0x72, 0x77, 0x61, 0x72, 0x64, 0x5f, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x76,
+	0x69, 0x74, 0x79, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43,
+	0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x12, 0x66, 0x6f, 0x72, 0x77, 0x61, 0x72, 0x64, 0x44,
+	0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x76, 0x69, 0x74, 0x79, 0x12, 0x3d, 0x0a, 0x14, 0x66, 0x6f,
+	0x72, 0x77, 0x61, 0x72, 0x64, 0x5f, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x6d, 0x61, 0x74,
+	0x63, 0x68, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f,
+	0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x12, 0x66, 0x6f, 0x72, 0x77, 0x61, 0x72, 0x64, 0x53, 0x6f,
+	0x75, 0x72, 0x63, 0x65, 0x4d, 0x61, 0x74, 0x63, 0x68, 0x12, 0x4b, 0x0a, 0x1b, 0x66, 0x6f, 0x72,
+	0x77, 0x61, 0x72, 0x64, 0x5f, 0x72, 0x65, 0x66, 0x6c, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f,
+	0x74, 0x72, 0x61, 0x63, 0x6b, 0x69, 0x6e, 0x67, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b,
+	0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x19, 0x66, 0x6f, 0x72,
+	0x77, 0x61, 0x72, 0x64, 0x52, 0x65, 0x66, 0x6c, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x72,
+	0x61, 0x63, 0x6b, 0x69, 0x6e, 0x67, 0x12, 0x4f, 0x0a, 0x1d, 0x66, 0x6f, 0x72, 0x77, 0x61, 0x72,
+	0x64, 0x5f, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x74,
+	0x72, 0x61, 0x63, 0x6b, 0x69, 0x6e, 0x67, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
+	0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x1b, 0x66, 0x6f, 0x72, 0x77,
+	0x61, 0x72, 0x64, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x54,
+	0x72, 0x61, 0x63, 0x6b, 0x69, 0x6e, 0x67, 0x12, 0x39, 0x0a, 0x12, 0x66, 0x6f, 0x72, 0x77, 0x61,
+	0x72, 0x64, 0x5f, 0x6c, 0x6f, 0x61, 0x64, 0x5f, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x18, 0x05, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78,
+	0x52, 0x10, 0x66, 0x6f, 0x72, 0x77, 0x61, 0x72, 0x64, 0x4c, 0x6f, 0x61, 0x64, 0x4d, 0x61, 0x74,
+	0x63, 0x68, 0x12, 0x38, 0x0a, 0x11, 0x66, 0x6f, 0x72, 0x77, 0x61, 0x72, 0x64, 0x5f, 0x69, 0x73,
+	0x6f, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
+	0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x10, 0x66, 0x6f, 0x72, 0x77,
+	0x61, 0x72, 0x64, 0x49, 0x73, 0x6f, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x3c, 0x0a, 0x13,
+	0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x5f, 0x64, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x76,
+	0x69, 0x74, 0x79, 0x18, 0x07, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43,
+	0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x12, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x44,
+	0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x76, 0x69, 0x74, 0x79, 0x12, 0x3d, 0x0a, 0x14, 0x72, 0x65,
+	0x76, 0x65, 0x72, 0x73, 0x65, 0x5f, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x5f, 0x6d, 0x61, 0x74,
+	0x63, 0x68, 0x18, 0x08, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f,
+	0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x12, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x53, 0x6f,
+	0x75, 0x72, 0x63, 0x65, 0x4d, 0x61, 0x74, 0x63, 0x68, 0x12, 0x4b, 0x0a, 0x1b, 0x72, 0x65, 0x76,
+	0x65, 0x72, 0x73, 0x65, 0x5f, 0x72, 0x65, 0x66, 0x6c, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x5f,
+	0x74, 0x72, 0x61, 0x63, 0x6b, 0x69, 0x6e, 0x67, 0x18, 0x09, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b,
+	0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x19, 0x72, 0x65, 0x76,
+	0x65, 0x72, 0x73, 0x65, 0x52, 0x65, 0x66, 0x6c, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x54, 0x72,
+	0x61, 0x63, 0x6b, 0x69, 0x6e, 0x67, 0x12, 0x4f, 0x0a, 0x1d, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73,
+	0x65, 0x5f, 0x74, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x5f, 0x74,
+	0x72, 0x61, 0x63, 0x6b, 0x69, 0x6e, 0x67, 0x18, 0x0a, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
+	0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x1b, 0x72, 0x65, 0x76, 0x65,
+	0x72, 0x73, 0x65, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x6d, 0x69, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x54,
+	0x72, 0x61, 0x63, 0x6b, 0x69, 0x6e, 0x67, 0x12, 0x39, 0x0a, 0x12, 0x72, 0x65, 0x76, 0x65, 0x72,
+	0x73, 0x65, 0x5f, 0x6c, 0x6f, 0x61, 0x64, 0x5f, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x18, 0x0b, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78,
+	0x52, 0x10, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x4c, 0x6f, 0x61, 0x64, 0x4d, 0x61, 0x74,
+	0x63, 0x68, 0x12, 0x38, 0x0a, 0x11, 0x72, 0x65, 0x76, 0x65, 0x72, 0x73, 0x65, 0x5f, 0x69, 0x73,
+	0x6f, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0c, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0b, 0x2e,
+	0x70, 0x62, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x52, 0x10, 0x72, 0x65, 0x76, 0x65,
+	0x72, 0x73, 0x65, 0x49, 0x73, 0x6f, 0x6c, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x22, 0x31, 0x0a, 0x07,
+	0x43, 0x6f, 0x6d, 0x70, 0x6c, 0x65, 0x78, 0x12, 0x12, 0x0a, 0x04, 0x69, 0x6d, 0x61, 0x67, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x01, 0x52, 0x04, 0x69, 0x6d, 0x61, 0x67, 0x12, 0x12, 0x0a, 0x04, 0x72,
+	0x65, 0x61, 0x6c, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x04, 0x72, 0x65, 0x61, 0x6c, 0x22,
+	0xe4, 0x01, 0x0a, 0x0e, 0x4d, 0x65, 0x61, 0x73, 0x75, 0x72, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x77, 0x68, 0x61, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x04, 0x77, 0x68, 0x61, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x63, 0x61, 0x6c, 0x69, 0x62, 0x72,
+	0x61, 0x74, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x63, 0x61, 0x6c, 0x69,
+	0x62, 0x72, 0x61, 0x74, 0x65, 0x64, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x10, 0x0a, 0x03,
+	0x65, 0x6e, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x12,
+	0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x73, 0x69,
+	0x7a, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6c, 0x6f, 0x67, 0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52,
+	0x03, 0x6c, 0x6f, 0x67, 0x12, 0x10, 0x0a, 0x03, 0x61, 0x76, 0x67, 0x18, 0x07, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x03, 0x61, 0x76, 0x67, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x77, 0x65, 0x65, 0x70, 0x73,
+	0x18, 0x08, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06, 0x73, 0x77, 0x65, 0x65, 0x70, 0x73, 0x12, 0x16,
+	0x0a, 0x06, 0x72, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06,
+	0x72, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x12, 0x0e, 0x0a, 0x02, 0x7a, 0x30, 0x18, 0x0a, 0x20, 0x01,
+	0x28, 0x01, 0x52, 0x02, 0x7a, 0x30, 0x22, 0x54, 0x0a, 0x0f, 0x4d, 0x65, 0x61, 0x73, 0x75, 0x72,
+	0x65, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x66, 0x72, 0x65,
+	0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x01, 0x20, 0x03, 0x28, 0x01, 0x52, 0x09, 0x66, 0x72,
+	0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x12, 0x23, 0x0a, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c,
+	0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61,
+	0x72, 0x61, 0x6d, 0x73, 0x52, 0x06, 0x72, 0x65, 0x73, 0x75, 0x6c, 0x74, 0x22, 0xa2, 0x01, 0x0a,
+	0x10, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73,
+	0x74, 0x12, 0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x04,
+	0x52, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a,
+	0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x12, 0x10, 0x0a,
+	0x03, 0x6c, 0x6f, 0x67, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x6c, 0x6f, 0x67, 0x12,
+	0x10, 0x0a, 0x03, 0x61, 0x76, 0x67, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x03, 0x61, 0x76,
+	0x67, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x77, 0x65, 0x65, 0x70, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x06, 0x73, 0x77, 0x65, 0x65, 0x70, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x72, 0x65, 0x6a,
+	0x65, 0x63, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x06, 0x72, 0x65, 0x6a, 0x65, 0x63,
+	0x74, 0x22, 0x52, 0x0a, 0x11, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65,
+	0x6e, 0x63, 0x79, 0x18, 0x01, 0x20, 0x03, 0x28, 0x01, 0x52, 0x09, 0x66, 0x72, 0x65, 0x71, 0x75,
+	0x65, 0x6e, 0x63, 0x79, 0x12, 0x1f, 0x0a, 0x04, 0x74, 0x68, 0x72, 0x75, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x50, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x52,
+	0x04, 0x74, 0x68, 0x72, 0x75, 0x22, 0x0f, 0x0a, 0x0d, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x82, 0x02, 0x0a, 0x0e, 0x53, 0x74, 0x61, 0x74, 0x75,
+	0x73, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x08, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x12, 0x23, 0x0a, 0x0d, 0x6d, 0x69, 0x6e, 0x5f, 0x66, 0x72, 0x65,
+	0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x6d, 0x69,
+	0x6e, 0x46, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x12, 0x23, 0x0a, 0x0d, 0x6d, 0x61,
+	0x78, 0x5f, 0x66, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x18, 0x03, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x0c, 0x6d, 0x61, 0x78, 0x46, 0x72, 0x65, 0x71, 0x75, 0x65, 0x6e, 0x63, 0x79, 0x12,
+	0x1e, 0x0a, 0x0a, 0x63, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x64, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x08, 0x52, 0x0a, 0x63, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x64, 0x12,
+	0x14, 0x0a, 0x05, 0x73, 0x74, 0x61, 0x72, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05,
+	0x73, 0x74, 0x61, 0x72, 0x74, 0x12, 0x10, 0x0a, 0x03, 0x65, 0x6e, 0x64, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x04, 0x52, 0x03, 0x65, 0x6e, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x18,
+	0x07, 0x20, 0x01, 0x28, 0x05, 0x52, 0x04, 0x73, 0x69, 0x7a, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x6c,
+	0x6f, 0x67, 0x18, 0x08, 0x20, 0x01, 0x28, 0x08, 0x52, 0x03, 0x6c, 0x6f, 0x67, 0x12, 0x1c, 0x0a,
+	0x09, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x09, 0x20, 0x03, 0x28, 0x09,
+	0x52, 0x09, 0x70, 0x6f, 0x73, 0x69, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x32, 0xad, 0x01, 0x0a, 0x09,
+	0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x12, 0x4f, 0x0a, 0x10, 0x43, 0x61, 0x6c,
+	0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x4f, 0x6e, 0x65, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x1b, 0x2e,
+	0x70, 0x62, 0x2e, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x4f, 0x6e, 0x65, 0x50,
+	0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x70, 0x62, 0x2e,
+	0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x4f, 0x6e, 0x65, 0x50, 0x6f, 0x72, 0x74,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x4f, 0x0a, 0x10, 0x43, 0x61,
+	0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x54, 0x77, 0x6f, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x1b,
+	0x2e, 0x70, 0x62, 0x2e, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x54, 0x77, 0x6f,
 	0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x70, 0x62,
-	0x2e, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x4f, 0x6e, 0x65, 0x50, 0x6f, 0x72,
-	0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x4f, 0x0a, 0x10, 0x43,
-	0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x54, 0x77, 0x6f, 0x50, 0x6f, 0x72, 0x74, 0x12,
-	0x1b, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x54, 0x77,
-	0x6f, 0x50, 0x6f, 0x72, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1c, 0x2e, 0x70,
-	0x62, 0x2e, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x54, 0x77, 0x6f, 0x50, 0x6f,
-	0x72, 0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x31, 0x5a, 0x2f,
-	0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x70, 0x72, 0x61, 0x63, 0x74,
-	0x61, 0x62, 0x6c, 0x65, 0x2f, 0x70, 0x6f, 0x63, 0x6b, 0x65, 0x74, 0x2d, 0x76, 0x6e, 0x61, 0x2d,
-	0x74, 0x77, 0x6f, 0x2d, 0x70, 0x6f, 0x72, 0x74, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x62, 0x62,
-	0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x2e, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x54, 0x77, 0x6f, 0x50, 0x6f, 0x72,
+	0x74, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x32, 0xaa, 0x01, 0x0a, 0x03,
+	0x56, 0x4e, 0x41, 0x12, 0x34, 0x0a, 0x07, 0x4d, 0x65, 0x61, 0x73, 0x75, 0x72, 0x65, 0x12, 0x12,
+	0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x65, 0x61, 0x73, 0x75, 0x72, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x13, 0x2e, 0x70, 0x62, 0x2e, 0x4d, 0x65, 0x61, 0x73, 0x75, 0x72, 0x65, 0x52,
+	0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x3a, 0x0a, 0x09, 0x43, 0x61, 0x6c,
+	0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x12, 0x14, 0x2e, 0x70, 0x62, 0x2e, 0x43, 0x61, 0x6c, 0x69,
+	0x62, 0x72, 0x61, 0x74, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x15, 0x2e, 0x70,
+	0x62, 0x2e, 0x43, 0x61, 0x6c, 0x69, 0x62, 0x72, 0x61, 0x74, 0x65, 0x52, 0x65, 0x73, 0x70, 0x6f,
+	0x6e, 0x73, 0x65, 0x22, 0x00, 0x12, 0x31, 0x0a, 0x06, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x12,
+	0x11, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65,
+	0x73, 0x74, 0x1a, 0x12, 0x2e, 0x70, 0x62, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x75, 0x73, 0x52, 0x65,
+	0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x31, 0x5a, 0x2f, 0x67, 0x69, 0x74, 0x68,
+	0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x70, 0x72, 0x61, 0x63, 0x74, 0x61, 0x62, 0x6c, 0x65,
+	0x2f, 0x70, 0x6f, 0x63, 0x6b, 0x65, 0x74, 0x2d, 0x76, 0x6e, 0x61, 0x2d, 0x74, 0x77, 0x6f, 0x2d,
+	0x70, 0x6f, 0x72, 0x74, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x33,
 }
 
 var (
@@ -515,41 +1292,73 @@ func file_calibrate_proto_rawDescGZIP() []byte {
 	return file_calibrate_proto_rawDescData
 }
 
-var file_calibrate_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_calibrate_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
 var file_calibrate_proto_goTypes = []interface{}{
 	(*CalibrateOnePortResponse)(nil), // 0: pb.CalibrateOnePortResponse
 	(*CalibrateTwoPortResponse)(nil), // 1: pb.CalibrateTwoPortResponse
 	(*CalibrateOnePortRequest)(nil),  // 2: pb.CalibrateOnePortRequest
 	(*CalibrateTwoPortRequest)(nil),  // 3: pb.CalibrateTwoPortRequest
 	(*SParams)(nil),                  // 4: pb.SParams
-	(*Complex)(nil),                  // 5: pb.Complex
+	(*ErrorTerms)(nil),               // 5: pb.ErrorTerms
+	(*Complex)(nil),                  // 6: pb.Complex
+	(*MeasureRequest)(nil),           // 7: pb.MeasureRequest
+	(*MeasureResponse)(nil),          // 8: pb.MeasureResponse
+	(*CalibrateRequest)(nil),         // 9: pb.CalibrateRequest
+	(*CalibrateResponse)(nil),        // 10: pb.CalibrateResponse
+	(*StatusRequest)(nil),            // 11: pb.StatusRequest
+	(*StatusResponse)(nil),           // 12: pb.StatusResponse
 }
 var file_calibrate_proto_depIdxs = []int32{
-	5,  // 0: pb.CalibrateOnePortResponse.result:type_name -> pb.Complex
+	6,  // 0: pb.CalibrateOnePortResponse.result:type_name -> pb.Complex
 	4,  // 1: pb.CalibrateTwoPortResponse.result:type_name -> pb.SParams
-	5,  // 2: pb.CalibrateOnePortRequest.short:type_name -> pb.Complex
-	5,  // 3: pb.CalibrateOnePortRequest.open:type_name -> pb.Complex
-	5,  // 4: pb.CalibrateOnePortRequest.load:type_name -> pb.Complex
-	5,  // 5: pb.CalibrateOnePortRequest.thru:type_name -> pb.Complex
-	5,  // 6: pb.CalibrateOnePortRequest.dut:type_name -> pb.Complex
-	4,  // 7: pb.CalibrateTwoPortRequest.short:type_name -> pb.SParams
-	4,  // 8: pb.CalibrateTwoPortRequest.open:type_name -> pb.SParams
-	4,  // 9: pb.CalibrateTwoPortRequest.load:type_name -> pb.SParams
-	4,  // 10: pb.CalibrateTwoPortRequest.thru:type_name -> pb.SParams
-	4,  // 11: pb.CalibrateTwoPortRequest.dut:type_name -> pb.SParams
-	5,  // 12: pb.SParams.s11:type_name -> pb.Complex
-	5,  // 13: pb.SParams.s12:type_name -> pb.Complex
-	5,  // 14: pb.SParams.s21:type_name -> pb.Complex
-	5,  // 15: pb.SParams.s22:type_name -> pb.Complex
-	2,  // 16: pb.Calibrate.CalibrateOnePort:input_type -> pb.CalibrateOnePortRequest
-	3,  // 17: pb.Calibrate.CalibrateTwoPort:input_type -> pb.CalibrateTwoPortRequest
-	0,  // 18: pb.Calibrate.CalibrateOnePort:output_type -> pb.CalibrateOnePortResponse
-	1,  // 19: pb.Calibrate.CalibrateTwoPort:output_type -> pb.CalibrateTwoPortResponse
-	18, // [18:20] is the sub-list for method output_type
-	16, // [16:18] is the sub-list for method input_type
-	16, // [16:16] is the sub-list for extension type_name
-	16, // [16:16] is the sub-list for extension extendee
-	0,  // [0:16] is the sub-list for field type_name
+	5,  // 2: pb.CalibrateTwoPortResponse.error_terms:type_name -> pb.ErrorTerms
+	6,  // 3: pb.CalibrateOnePortRequest.short:type_name -> pb.Complex
+	6,  // 4: pb.CalibrateOnePortRequest.open:type_name -> pb.Complex
+	6,  // 5: pb.CalibrateOnePortRequest.load:type_name -> pb.Complex
+	6,  // 6: pb.CalibrateOnePortRequest.thru:type_name -> pb.Complex
+	6,  // 7: pb.CalibrateOnePortRequest.dut:type_name -> pb.Complex
+	4,  // 8: pb.CalibrateTwoPortRequest.short:type_name -> pb.SParams
+	4,  // 9: pb.CalibrateTwoPortRequest.open:type_name -> pb.SParams
+	4,  // 10: pb.CalibrateTwoPortRequest.load:type_name -> pb.SParams
+	4,  // 11: pb.CalibrateTwoPortRequest.thru:type_name -> pb.SParams
+	4,  // 12: pb.CalibrateTwoPortRequest.dut:type_name -> pb.SParams
+	4,  // 13: pb.CalibrateTwoPortRequest.ideal_short:type_name -> pb.SParams
+	4,  // 14: pb.CalibrateTwoPortRequest.ideal_open:type_name -> pb.SParams
+	4,  // 15: pb.CalibrateTwoPortRequest.ideal_load:type_name -> pb.SParams
+	4,  // 16: pb.CalibrateTwoPortRequest.ideal_thru:type_name -> pb.SParams
+	6,  // 17: pb.SParams.s11:type_name -> pb.Complex
+	6,  // 18: pb.SParams.s12:type_name -> pb.Complex
+	6,  // 19: pb.SParams.s21:type_name -> pb.Complex
+	6,  // 20: pb.SParams.s22:type_name -> pb.Complex
+	6,  // 21: pb.ErrorTerms.forward_directivity:type_name -> pb.Complex
+	6,  // 22: pb.ErrorTerms.forward_source_match:type_name -> pb.Complex
+	6,  // 23: pb.ErrorTerms.forward_reflection_tracking:type_name -> pb.Complex
+	6,  // 24: pb.ErrorTerms.forward_transmission_tracking:type_name -> pb.Complex
+	6,  // 25: pb.ErrorTerms.forward_load_match:type_name -> pb.Complex
+	6,  // 26: pb.ErrorTerms.forward_isolation:type_name -> pb.Complex
+	6,  // 27: pb.ErrorTerms.reverse_directivity:type_name -> pb.Complex
+	6,  // 28: pb.ErrorTerms.reverse_source_match:type_name -> pb.Complex
+	6,  // 29: pb.ErrorTerms.reverse_reflection_tracking:type_name -> pb.Complex
+	6,  // 30: pb.ErrorTerms.reverse_transmission_tracking:type_name -> pb.Complex
+	6,  // 31: pb.ErrorTerms.reverse_load_match:type_name -> pb.Complex
+	6,  // 32: pb.ErrorTerms.reverse_isolation:type_name -> pb.Complex
+	4,  // 33: pb.MeasureResponse.result:type_name -> pb.SParams
+	4,  // 34: pb.CalibrateResponse.thru:type_name -> pb.SParams
+	2,  // 35: pb.Calibrate.CalibrateOnePort:input_type -> pb.CalibrateOnePortRequest
+	3,  // 36: pb.Calibrate.CalibrateTwoPort:input_type -> pb.CalibrateTwoPortRequest
+	7,  // 37: pb.VNA.Measure:input_type -> pb.MeasureRequest
+	9,  // 38: pb.VNA.Calibrate:input_type -> pb.CalibrateRequest
+	11, // 39: pb.VNA.Status:input_type -> pb.StatusRequest
+	0,  // 40: pb.Calibrate.CalibrateOnePort:output_type -> pb.CalibrateOnePortResponse
+	1,  // 41: pb.Calibrate.CalibrateTwoPort:output_type -> pb.CalibrateTwoPortResponse
+	8,  // 42: pb.VNA.Measure:output_type -> pb.MeasureResponse
+	10, // 43: pb.VNA.Calibrate:output_type -> pb.CalibrateResponse
+	12, // 44: pb.VNA.Status:output_type -> pb.StatusResponse
+	40, // [40:45] is the sub-list for method output_type
+	35, // [35:40] is the sub-list for method input_type
+	35, // [35:35] is the sub-list for extension type_name
+	35, // [35:35] is the sub-list for extension extendee
+	0,  // [0:35] is the sub-list for field type_name
 }
 
 func init() { file_calibrate_proto_init() }
@@ -619,6 +1428,18 @@ func file_calibrate_proto_init() {
 			}
 		}
 		file_calibrate_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ErrorTerms); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_calibrate_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*Complex); i {
 			case 0:
 				return &v.state
@@ -630,6 +1451,78 @@ func file_calibrate_proto_init() {
 				return nil
 			}
 		}
+		file_calibrate_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MeasureRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_calibrate_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*MeasureResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_calibrate_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CalibrateRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_calibrate_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CalibrateResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_calibrate_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatusRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_calibrate_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*StatusResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
 	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
@@ -637,9 +1530,9 @@ func file_calibrate_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_calibrate_proto_rawDesc,
 			NumEnums:      0,
-			NumMessages:   6,
+			NumMessages:   13,
 			NumExtensions: 0,
-			NumServices:   1,
+			NumServices:   2,
 		},
 		GoTypes:           file_calibrate_proto_goTypes,
 		DependencyIndexes: file_calibrate_proto_depIdxs,
@@ -144,3 +144,167 @@ var Calibrate_ServiceDesc = grpc.ServiceDesc{
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "calibrate.proto",
 }
+
+const (
+	VNA_Measure_FullMethodName   = "/pb.VNA/Measure"
+	VNA_Calibrate_FullMethodName = "/pb.VNA/Calibrate"
+	VNA_Status_FullMethodName    = "/pb.VNA/Status"
+)
+
+// VNAClient is the client API for VNA service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type VNAClient interface {
+	Measure(ctx context.Context, in *MeasureRequest, opts ...grpc.CallOption) (*MeasureResponse, error)
+	Calibrate(ctx context.Context, in *CalibrateRequest, opts ...grpc.CallOption) (*CalibrateResponse, error)
+	Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error)
+}
+
+type vNAClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewVNAClient(cc grpc.ClientConnInterface) VNAClient {
+	return &vNAClient{cc}
+}
+
+func (c *vNAClient) Measure(ctx context.Context, in *MeasureRequest, opts ...grpc.CallOption) (*MeasureResponse, error) {
+	out := new(MeasureResponse)
+	err := c.cc.Invoke(ctx, VNA_Measure_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *vNAClient) Calibrate(ctx context.Context, in *CalibrateRequest, opts ...grpc.CallOption) (*CalibrateResponse, error) {
+	out := new(CalibrateResponse)
+	err := c.cc.Invoke(ctx, VNA_Calibrate_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *vNAClient) Status(ctx context.Context, in *StatusRequest, opts ...grpc.CallOption) (*StatusResponse, error) {
+	out := new(StatusResponse)
+	err := c.cc.Invoke(ctx, VNA_Status_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// VNAServer is the server API for VNA service.
+// All implementations must embed UnimplementedVNAServer
+// for forward compatibility
+type VNAServer interface {
+	Measure(context.Context, *MeasureRequest) (*MeasureResponse, error)
+	Calibrate(context.Context, *CalibrateRequest) (*CalibrateResponse, error)
+	Status(context.Context, *StatusRequest) (*StatusResponse, error)
+	mustEmbedUnimplementedVNAServer()
+}
+
+// UnimplementedVNAServer must be embedded to have forward compatible implementations.
+type UnimplementedVNAServer struct {
+}
+
+func (UnimplementedVNAServer) Measure(context.Context, *MeasureRequest) (*MeasureResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Measure not implemented")
+}
+func (UnimplementedVNAServer) Calibrate(context.Context, *CalibrateRequest) (*CalibrateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Calibrate not implemented")
+}
+func (UnimplementedVNAServer) Status(context.Context, *StatusRequest) (*StatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Status not implemented")
+}
+func (UnimplementedVNAServer) mustEmbedUnimplementedVNAServer() {}
+
+// UnsafeVNAServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to VNAServer will
+// result in compilation errors.
+type UnsafeVNAServer interface {
+	mustEmbedUnimplementedVNAServer()
+}
+
+func RegisterVNAServer(s grpc.ServiceRegistrar, srv VNAServer) {
+	s.RegisterService(&VNA_ServiceDesc, srv)
+}
+
+func _VNA_Measure_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(MeasureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VNAServer).Measure(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VNA_Measure_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VNAServer).Measure(ctx, req.(*MeasureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VNA_Calibrate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CalibrateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VNAServer).Calibrate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VNA_Calibrate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VNAServer).Calibrate(ctx, req.(*CalibrateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _VNA_Status_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(VNAServer).Status(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: VNA_Status_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(VNAServer).Status(ctx, req.(*StatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// VNA_ServiceDesc is the grpc.ServiceDesc for VNA service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var VNA_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "pb.VNA",
+	HandlerType: (*VNAServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Measure",
+			Handler:    _VNA_Measure_Handler,
+		},
+		{
+			MethodName: "Calibrate",
+			Handler:    _VNA_Calibrate_Handler,
+		},
+		{
+			MethodName: "Status",
+			Handler:    _VNA_Status_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "calibrate.proto",
+}
@@ -0,0 +1,104 @@
+package pocket
+
+import "strings"
+
+// Name is a command, and the other names it can be sent as. Cmd is the name
+// given in the response, whichever name was used in the request.
+type Name struct {
+	Cmd     string
+	Aliases []string
+}
+
+// Names lists every command. Most have a short Cmd and a long alias, except
+// those that were added with a long Cmd, which is kept so that responses to
+// existing clients do not change, and have a short alias instead.
+var Names = []Name{
+	{"rq", []string{"rangequery"}},
+	{"rc", []string{"rangecal"}},
+	{"crq", []string{"calibratedrangequery"}},
+	{"td", []string{"timedomain"}},
+	{"sq", []string{"singlequery"}},
+	{"rr", []string{"reasonablefrequencyrange"}},
+	{"sp", []string{"setpower"}},
+	{"sf", []string{"setfixture"}},
+	{"pe", []string{"portext"}},
+	{"an", []string{"analyze"}},
+	{"tq", []string{"timequery"}},
+	{"startsweep", []string{"ss"}},
+	{"stopsweep", []string{"xs"}},
+	{"stability", []string{"sb"}},
+	{"hs", []string{"holdstart"}},
+	{"hq", []string{"holdquery"}},
+	{"hr", []string{"holdreset"}},
+	{"nf", []string{"noisefloor"}},
+	{"cf", []string{"clearfixture"}},
+	{"getconfig", []string{"gc"}},
+	{"reload", []string{"rl"}},
+	{"reset", []string{"rs"}},
+	{"flashswitch", []string{"fw"}},
+	{"protocoltest", []string{"pt"}},
+	{"switchwear", []string{"sw"}},
+	{"switchtraffic", []string{"st"}},
+	{"cancel", []string{"cx"}},
+	{"queue", []string{"qu"}},
+	{"flushqueue", []string{"fq"}},
+	{"schedule", []string{"sd"}},
+	{"unschedule", []string{"ud"}},
+	{"audit", []string{"au"}},
+	{"logs", []string{"lg"}},
+	{"history", []string{"hy"}},
+	{"lastresult", []string{"lr"}},
+	{"exportcal", []string{"ec"}},
+	{"comparecal", []string{"cc"}},
+	{"bench", []string{"bm", "benchmark"}},
+	{"calstate", []string{"cs"}},
+	{"mc", []string{"measurecal"}},
+	{"resumecal", []string{"ru"}},
+	{"saveref", []string{"sr", "savereference"}},
+	{"clearref", []string{"cr", "clearreference"}},
+	{"setlimits", []string{"sl"}},
+	{"compare", []string{"cm"}},
+	{"hello", []string{"hi", "capabilities"}},
+	{"schema", []string{"sh"}},
+	{"batch", []string{"bx"}},
+	{"characterize", []string{"ch", "characterise"}},
+	{"estimate", []string{"es"}},
+	{"getgrid", []string{"gg"}},
+}
+
+// lookup finds the Cmd for each name, in lower case
+var lookup = func() map[string]string {
+
+	l := make(map[string]string)
+
+	for _, n := range Names {
+
+		l[n.Cmd] = n.Cmd
+
+		for _, a := range n.Aliases {
+			l[a] = n.Cmd
+		}
+	}
+
+	return l
+}()
+
+// Lookup returns the Cmd of a command, given any of its names in any case,
+// or false if there is no command of that name
+func Lookup(name string) (string, bool) {
+	c, ok := lookup[strings.ToLower(name)]
+	return c, ok
+}
+
+// AllNames returns every name of every command, each Cmd followed by its aliases
+func AllNames() []string {
+
+	var a []string
+
+	for _, n := range Names {
+		a = append(a, n.Cmd)
+		a = append(a, n.Aliases...)
+	}
+
+	return a
+}
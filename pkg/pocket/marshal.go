@@ -0,0 +1,125 @@
+package pocket
+
+import (
+	"encoding/json"
+
+	"github.com/practable/pocket-vna-two-port/pkg/parallel"
+)
+
+// null is the JSON of a nil slice, without omitempty
+var null = []byte("null")
+
+// oneFormatted stands in for the formatted result of a crq while the rest of the
+// response is marshalled, since an empty one would be left out
+var oneFormatted = []FormattedSParam{{}}
+
+// Marshal returns the JSON of a response, the same as json.Marshal does, except that
+// the result of a large rq or crq is marshalled in parallel, since it takes much of
+// the time to answer a sweep of a thousand points or so on a small computer
+func Marshal(v interface{}) ([]byte, error) {
+
+	switch r := v.(type) {
+	case RangeQuery:
+		return marshalRangeQuery(r)
+	case *RangeQuery:
+		if r != nil {
+			return marshalRangeQuery(*r)
+		}
+	case CalibratedRangeQuery:
+		return marshalCalibratedRangeQuery(r)
+	case *CalibratedRangeQuery:
+		if r != nil {
+			return marshalCalibratedRangeQuery(*r)
+		}
+	}
+
+	return json.Marshal(v)
+}
+
+// large returns true if a slice of n elements is worth marshalling in parallel
+func large(n int) bool {
+	return parallel.Default.Chunks(n, parallel.MinMarshal) > 1
+}
+
+func marshalRangeQuery(r RangeQuery) ([]byte, error) {
+
+	if !large(len(r.Result)) {
+		return json.Marshal(r)
+	}
+
+	result, err := parallel.Marshal(r.Result)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.Result = nil
+
+	b, err := json.Marshal(r)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return parallel.Replace(b, "result", null, result)
+}
+
+func marshalCalibratedRangeQuery(c CalibratedRangeQuery) ([]byte, error) {
+
+	var result, formatted []byte
+	var err error
+
+	if large(len(c.Result)) {
+
+		result, err = parallel.Marshal(c.Result)
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.Result = nil
+	}
+
+	if large(len(c.Formatted)) {
+
+		formatted, err = parallel.Marshal(c.Formatted)
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.Formatted = oneFormatted
+	}
+
+	b, err := json.Marshal(c)
+
+	if err != nil || (result == nil && formatted == nil) {
+		return b, err
+	}
+
+	if result != nil {
+
+		b, err = parallel.Replace(b, "result", null, result)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if formatted != nil {
+
+		one, err := json.Marshal(oneFormatted)
+
+		if err != nil {
+			return nil, err
+		}
+
+		b, err = parallel.Replace(b, "formatted", one, formatted)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return b, nil
+}
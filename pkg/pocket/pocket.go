@@ -1,9 +1,13 @@
 package pocket
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math"
 	"reflect"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -13,9 +17,20 @@ type VNA interface {
 	GetReasonableFrequencyRange(command interface{}) error
 	HandleCommand(command interface{}) error
 	RangeQuery(command interface{}) error
+	SetPower(command interface{}) error
 	SingleQuery(command interface{}) error
 }
 
+// ErrPowerNotSupported is returned when a non-default output power is requested
+// from hardware, because the pocketVNA openAPI does not expose the output amplitude
+var ErrPowerNotSupported = errors.New("output power control is not supported by the pocketVNA driver")
+
+// Preparer is implemented by a VNA that can get ready for a range query
+// while other things are happening, e.g. the rf switch is settling
+type Preparer interface {
+	Prepare(command interface{}) error
+}
+
 // Hardware type definition is in machine-specific file e.g. pocket_linux_amd64.go
 
 type Mock struct {
@@ -26,6 +41,9 @@ type Mock struct {
 	ResultSingleQuery              SParam
 	ResultReasonableFrequencyRange Range
 	CommandsReceived               []interface{}
+	Power                          float64
+	PrepareDelay                   time.Duration // how long Prepare takes
+	Prepared                       int           // number of calls to Prepare
 }
 
 /* For reference from C library
@@ -121,9 +139,93 @@ type Range struct {
 }
 
 type Command struct {
-	ID      string `json:"id,omitEmpty"`
-	Time    int    `json:"t,omitEmpty"`
-	Command string `json:"cmd,omitEmpty"`
+	ID       string  `json:"id,omitEmpty"`
+	Time     int     `json:"t,omitEmpty"`
+	Command  string  `json:"cmd,omitEmpty"`
+	Priority string  `json:"priority,omitempty"` // admin, interactive or batch, see Rank
+	Session  string  `json:"session,omitempty"`  // client or session that sent the request, echoed in its responses
+	DryRun   bool    `json:"dryrun,omitempty"`   // check the request and return its Plan, without doing it
+	At       string  `json:"at,omitempty"`       // RFC 3339 time to do the request at, rather than now, see Scheduled
+	After    float64 `json:"after,omitempty"`    // seconds to wait before doing the request, rather than now, see Scheduled
+}
+
+// CommandOf returns the Command of a request or response, which is embedded in
+// every one, or is that of the request in an error or an invalid request. It
+// returns false if there is none.
+func CommandOf(v interface{}) (Command, bool) {
+
+	switch r := v.(type) {
+	case Command:
+		return r, true
+	case CustomResult:
+		return CommandOf(r.Command)
+	case Invalid:
+		return CommandOf(r.Request)
+	}
+
+	f := reflect.ValueOf(v)
+
+	if f.Kind() != reflect.Struct {
+		return Command{}, false
+	}
+
+	f = f.FieldByName("Command")
+
+	if !f.IsValid() {
+		return Command{}, false
+	}
+
+	c, ok := f.Interface().(Command)
+
+	return c, ok
+}
+
+// Priorities of requests, highest first
+const (
+	PriorityAdmin       = "admin"
+	PriorityInteractive = "interactive"
+	PriorityBatch       = "batch"
+)
+
+// Priorities lists the priorities a request can have, highest first
+var Priorities = []string{PriorityAdmin, PriorityInteractive, PriorityBatch}
+
+// Rank returns the position of priority in Priorities, so that a lower rank
+// is served first, or false if there is no such priority. A request with
+// no priority is interactive.
+func Rank(priority string) (int, bool) {
+
+	if priority == "" {
+		priority = PriorityInteractive
+	}
+
+	for i, p := range Priorities {
+		if strings.EqualFold(p, priority) {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// TimeQuery measures at one frequency repeatedly, for Count readings or for
+// Duration seconds, whichever ends first, to show changes over time
+type TimeQuery struct {
+	Command
+	Freq     uint64       `json:"freq"`
+	Avg      uint16       `json:"avg"`
+	Select   SParamSelect `json:"sparam"`
+	What     string       `json:"what"`
+	Count    int          `json:"count,omitempty"`
+	Duration float64      `json:"duration,omitempty"` // seconds
+	Result   []TimeSParam `json:"result,omitempty"`
+	Meta     *Meta        `json:"meta,omitempty"`
+}
+
+// TimeSParam is a reading that started Time seconds after the first one
+type TimeSParam struct {
+	Time float64 `json:"time"`
+	SParam
 }
 
 type RangeQuery struct {
@@ -135,16 +237,324 @@ type RangeQuery struct {
 	Select          SParamSelect `json:"sparam"`
 	Result          []SParam     `json:"result,omitEmpty"`
 	What            string       `json:"what"`
+	Power           float64      `json:"power,omitempty"`       // dBm, zero for the device default
+	Sweeps          int          `json:"sweeps,omitempty"`      // number of complete sweeps to average, default 1
+	Reject          string       `json:"reject,omitempty"`      // none (default), median or outlier
+	StdDev          []Deviation  `json:"stddev,omitempty"`      // spread of the sweeps, when there is more than one
+	Frequencies     []uint64     `json:"frequencies,omitempty"` // measure at these frequencies (Hz) instead of the range
+	Segments        []Segment    `json:"segments,omitempty"`    // measure these bands, one after the other, instead of the range
+	Preset          string       `json:"preset,omitempty"`      // measure the sweep of this preset, listed by rr, instead of the range
+	PathLoss        bool         `json:"pathloss,omitempty"`    // remove the loss and phase of the switch path from the result (rq only)
+	Meta            *Meta        `json:"meta,omitempty"`        // units and orientation of the result, which is not calibrated
+	DebugTiming     bool         `json:"debugtiming,omitempty"` // add the time taken by each stage to the meta
+	Warning         string       `json:"warning,omitempty"`     // e.g. an rc made while the VNA was warming up
+	Digits          int          `json:"digits,omitempty"`      // round the result to this many significant digits (rq only)
+	Display         int          `json:"display,omitempty"`     // return no more than this many points of the result (rq only)
+}
+
+const (
+	Hz              = "Hz"
+	LinearRI        = "linear real/imag"
+	CorrectionNone  = "none"
+	TwelveTerm      = "twelve-term"
+	PortOrientation = "sij is the wave leaving port i for a wave entering port j, so s21 is the transmission from port 1 to port 2; " +
+		"ports 1 and 2 are those of the VNA, and of the DUT connected to them"
+)
+
+// Meta says what the numbers in a result mean, so that clients do not have to assume.
+// Correction is the error model that was applied (none or twelve-term), and Applied
+// lists the further corrections, in the order they were made: switch-terms (before
+// the error model), deembed, portext, renormalize, smooth and normalize, or pathloss
+// for a raw result.
+type Meta struct {
+	Freq        string     `json:"freq"`                // unit of frequency
+	Time        string     `json:"time,omitempty"`      // unit of time, for time domain results
+	Values      string     `json:"values,omitempty"`    // form of the S-parameters in result
+	Formatted   string     `json:"formatted,omitempty"` // form of the values in formatted, delta, max or min
+	Z0          float64    `json:"z0"`                  // reference impedance (ohms)
+	Orientation string     `json:"orientation"`
+	Correction  string     `json:"correction"`
+	Applied     []string   `json:"applied,omitempty"`
+	Timing      *Timing    `json:"timing,omitempty"`    // only if debugtiming was set in the request
+	Averaging   *Averaging `json:"averaging,omitempty"` // only if the avg was chosen to meet a target
+	Smoothing   *Smoothing `json:"smoothing,omitempty"` // only if the result was smoothed
+	Grid        string     `json:"grid,omitempty"`      // identifies the frequencies of the result, see GetGrid
+	// code that loads the columns of the response, by language, only if the result is in columns
+	Loaders map[string]string `json:"loaders,omitempty"`
+	// where the trace noise came from, only if the result has bounds
+	Noise string `json:"noise,omitempty"`
+	// only if the result was rounded or decimated, for display
+	Reduced *Reduction `json:"reduced,omitempty"`
+}
+
+// Reduction says how a result was shrunk for display: its values were rounded to
+// Digits significant digits, if not zero, and Points of the Of points measured were kept
+type Reduction struct {
+	Digits int `json:"digits,omitempty"`
+	Points int `json:"points"`
+	Of     int `json:"of"`
+}
+
+// Averaging says how the avg of a result was chosen to meet a Target for the trace
+// noise: Noise is the rms trace noise of two sweeps made at an average of one, and
+// Avg is what was chosen. Limited means it would have taken more than the most allowed.
+type Averaging struct {
+	Target  float64 `json:"target"`
+	Noise   float64 `json:"noise"`
+	Avg     int     `json:"avg"`
+	Limited bool    `json:"limited,omitempty"`
+}
+
+// Smoothing smooths a trace over an Aperture of neighbouring points (odd, at least 3),
+// with the Method mean (moving average) or sg (Savitzky-Golay, fitting a polynomial
+// of Order to the points, 2 unless given, which keeps the peaks sharper)
+type Smoothing struct {
+	Method   string `json:"method"`
+	Aperture int    `json:"aperture"`
+	Order    int    `json:"order,omitempty"`
+}
+
+// PhaseDelay is the unwrapped phase of s21 in degrees, and its group delay in seconds,
+// at each point of a result. The delay is found from the slope of the phase over an
+// aperture of points centred on each, or at the ends, over the first or last aperture.
+type PhaseDelay struct {
+	Aperture int       `json:"aperture"` // an odd number of points, 3 unless given
+	Phase    []float64 `json:"phase,omitempty"`
+	Delay    []float64 `json:"delay,omitempty"`
+}
+
+// UncertainSParam holds the uncertainty of each S-parameter at a frequency
+type UncertainSParam struct {
+	S11  Bound  `json:"s11"`
+	S12  Bound  `json:"s12"`
+	S21  Bound  `json:"s21"`
+	S22  Bound  `json:"s22"`
+	Freq uint64 `json:"freq"`
+}
+
+// Bound is how far an S-parameter may be from the value given, plus or minus: Mag in
+// linear magnitude, DB in magnitude in dB, and Phase in degrees
+type Bound struct {
+	Mag   float64 `json:"mag"`
+	DB    float64 `json:"db"`
+	Phase float64 `json:"phase"`
+}
+
+// Timing is how long each stage of a request took, in seconds, to find out why a
+// rig is slow. Switch includes the settling time, and Sweep includes preparing the
+// VNA once the switch has settled. Each is the sum over all the sweeps of the request.
+// Marshal is the time to marshal the response, without the timing, and Total is
+// the time from when the request was started, which includes the other stages.
+type Timing struct {
+	Switch    float64 `json:"switch"`
+	Sweep     float64 `json:"sweep"`
+	Calibrate float64 `json:"calibrate"`
+	Marshal   float64 `json:"marshal"`
+	Total     float64 `json:"total"`
+}
+
+// Segment is one band of a segmented sweep
+type Segment struct {
+	Start           uint64 `json:"start"`
+	End             uint64 `json:"end"`
+	Size            int    `json:"size"`
+	LogDistribution bool   `json:"islog,omitempty"`
+	Avg             uint16 `json:"avg,omitempty"` // default is the avg of the query
 }
 
 // this command is not supported by pocket
 // we have to handle this in the middle layer
 type CalibratedRangeQuery struct {
 	Command
-	What   string       `json:"what"`
-	Avg    uint16       `json:"avg"`
-	Select SParamSelect `json:"sparam"`
-	Result []SParam     `json:"result,omitEmpty"`
+	What       string            `json:"what"`
+	Avg        uint16            `json:"avg"`
+	Select     SParamSelect      `json:"sparam"`
+	Result     []SParam          `json:"result,omitEmpty"`
+	Format     string            `json:"format,omitempty"`     // ri (default), ma, db, vswr or gd
+	FormatOnly bool              `json:"formatonly,omitempty"` // omit the ri result when formatted
+	Formatted  []FormattedSParam `json:"formatted,omitempty"`
+	Extension  *Extension        `json:"portext,omitempty"` // port extension applied to the result, if any
+	Z0         float64           `json:"z0,omitempty"`      // reference impedance (ohms) for the result, default 50
+	Sweeps     int               `json:"sweeps,omitempty"`  // number of complete sweeps to average, default 1
+	Reject     string            `json:"reject,omitempty"`  // none (default), median or outlier
+	StdDev     []Deviation       `json:"stddev,omitempty"`  // spread of the raw sweeps, when there is more than one
+	MaxAge     float64           `json:"maxage,omitempty"`  // accept a cached result up to this old (seconds), zero to always measure
+	// choose avg so that the trace noise of the raw result is no more than this (linear), zero to use that of the cal
+	Target float64 `json:"target,omitempty"`
+	MaxAvg uint16  `json:"maxavg,omitempty"` // most avg that target may choose, 1000 unless given
+	ETag   string  `json:"etag,omitempty"`   // identifies a result; send it back to avoid being sent the same result again
+	Cached bool    `json:"cached,omitempty"` // the result came from the cache
+	// the result is the one identified by the ETag in the request, so is not sent again
+	NotModified bool `json:"notmodified,omitempty"`
+	// only measure if the cal is on this grid, from getgrid or the meta of an earlier result
+	Grid string `json:"grid,omitempty"`
+	// smooth the calibrated result, before it is normalized; the meta says how it was smoothed
+	Smooth *Smoothing `json:"smooth,omitempty"`
+	// the stages the result goes through, in order, starting with calibrate, e.g. to leave
+	// out the de-embedding, or smooth before the port extension; all of them unless given
+	Pipeline []string `json:"pipeline,omitempty"`
+	// points (default), or columns to give the result in Columns instead, e.g. for numpy or MATLAB
+	Layout  string   `json:"layout,omitempty"`
+	Columns *Columns `json:"columns,omitempty"`
+	// also return s11 and s22 as normalised impedances, to plot on a Smith chart
+	Smith      bool         `json:"smith,omitempty"`
+	SmithChart []SmithPoint `json:"smithchart,omitempty"`
+	// also return the unwrapped phase and group delay of s21, with the aperture to find the delay over
+	PhaseDelay *PhaseDelay `json:"phasedelay,omitempty"`
+	// also return bounds on the result, from the trace noise and what it leaves of the cal
+	Uncertainty bool              `json:"uncertainty,omitempty"`
+	Bounds      []UncertainSParam `json:"bounds,omitempty"`
+	// divide the result by the reference saved with saveref, i.e. subtract it in dB
+	Normalize bool       `json:"normalize,omitempty"`
+	Reference *Reference `json:"reference,omitempty"` // the reference that the result was normalized to
+	// each limit line set with setlimits, checked against the result, and whether they all passed
+	Limits []LimitResult `json:"limits,omitempty"`
+	Pass   *bool         `json:"pass,omitempty"`
+	// also return the DUT as measured, before calibration, to see what the calibration did
+	Raw       bool     `json:"raw,omitempty"`
+	RawResult []SParam `json:"rawresult,omitempty"`
+	// round every value of the response to this many significant digits, full precision unless given
+	Digits int `json:"digits,omitempty"`
+	// return no more than this many points, keeping the smallest and largest magnitude
+	// of each group of points, e.g. for a preview plot; every point unless given
+	Display int `json:"display,omitempty"`
+	// units and orientation of the result, and the corrections that were applied to it
+	Meta *Meta `json:"meta,omitempty"`
+	// the result is raw, because the calibration service could not be reached
+	Uncorrected bool `json:"uncorrected,omitempty"`
+	// why the result is uncorrected, or why it may not be what was meant, e.g. a disconnected DUT
+	Warning string `json:"warning,omitempty"`
+	// add the time taken by each stage to the meta
+	DebugTiming bool `json:"debugtiming,omitempty"`
+}
+
+// Limit is a mask on the magnitude (dB) of one S-parameter over a range of
+// frequencies (inclusive). Max, Min or both may be given.
+type Limit struct {
+	Name   string   `json:"name,omitempty"`
+	SParam string   `json:"sparam"` // s11, s12, s21 or s22
+	Start  uint64   `json:"start"`
+	End    uint64   `json:"end"`
+	Max    *float64 `json:"max,omitempty"`
+	Min    *float64 `json:"min,omitempty"`
+}
+
+// LimitResult is how a result compares with a limit line. Margin is how far the
+// worst point is inside the limit (dB), and is negative if it is outside.
+// A limit that covers none of the points does not pass.
+type LimitResult struct {
+	Limit
+	Pass   bool    `json:"pass"`
+	Margin float64 `json:"margin"`
+	Worst  uint64  `json:"worst"` // frequency of the worst point
+	Points int     `json:"points"`
+}
+
+// SetLimits replaces the limit lines checked against each calibrated result.
+// An empty list removes them.
+type SetLimits struct {
+	Command
+	Limits []Limit `json:"limits"`
+}
+
+// Reference describes a calibrated trace saved with saveref, which later results can be normalized to
+type Reference struct {
+	What   string  `json:"what"`
+	Saved  int64   `json:"saved"` // unix time
+	Points int     `json:"points"`
+	Start  uint64  `json:"start"`
+	End    uint64  `json:"end"`
+	Z0     float64 `json:"z0"`
+}
+
+// SaveReference measures a calibrated trace and keeps it as the reference, replacing
+// any that was saved before. The result and description of the reference are returned.
+type SaveReference struct {
+	Command
+	What      string     `json:"what"`
+	Avg       uint16     `json:"avg"`
+	Z0        float64    `json:"z0,omitempty"`     // reference impedance (ohms), default 50
+	Sweeps    int        `json:"sweeps,omitempty"` // number of complete sweeps to average, default 1
+	Reject    string     `json:"reject,omitempty"` // none (default), median or outlier
+	Result    []SParam   `json:"result,omitempty"`
+	Reference *Reference `json:"reference,omitempty"`
+	Meta      *Meta      `json:"meta,omitempty"`
+}
+
+// ClearReference forgets the reference saved with saveref
+type ClearReference struct {
+	Command
+}
+
+// Extension is the one-way electrical delay (s) added to each port
+type Extension struct {
+	Port1 float64 `json:"port1"`
+	Port2 float64 `json:"port2"`
+}
+
+// FormattedSParam holds S-parameters converted to the format requested
+// parameters that have no meaning in that format (e.g. vswr of s21) are omitted
+type FormattedSParam struct {
+	S11  *Value `json:"s11,omitempty"`
+	S12  *Value `json:"s12,omitempty"`
+	S21  *Value `json:"s21,omitempty"`
+	S22  *Value `json:"s22,omitempty"`
+	Freq uint64 `json:"freq"`
+}
+
+// SmithPoint holds the reflection parameters at a frequency as impedances normalised
+// to the reference impedance of the result. A parameter is omitted at the open
+// circuit, where the impedance is infinite.
+type SmithPoint struct {
+	S11  *Impedance `json:"s11,omitempty"`
+	S22  *Impedance `json:"s22,omitempty"`
+	Freq uint64     `json:"freq"`
+}
+
+// Impedance is a normalised impedance r + jx
+type Impedance struct {
+	R float64 `json:"r"`
+	X float64 `json:"x"`
+}
+
+// Value is the magnitude (linear, dB, vswr or group delay in seconds) and,
+// for ma and db, the phase in degrees
+// Deviation is the standard deviation of each S-parameter over repeated sweeps
+type Deviation struct {
+	S11  float64 `json:"s11"`
+	S12  float64 `json:"s12"`
+	S21  float64 `json:"s21"`
+	S22  float64 `json:"s22"`
+	Freq uint64  `json:"freq"`
+}
+type Value struct {
+	Mag   float64 `json:"mag"`
+	Phase float64 `json:"phase,omitempty"`
+}
+
+// TimeDomainQuery measures a calibrated dut and transforms it to the time domain
+// this command is not supported by pocket, we handle it in the middle layer
+type TimeDomainQuery struct {
+	Command
+	What   string           `json:"what"`
+	Avg    uint16           `json:"avg"`
+	Select SParamSelect     `json:"sparam"`         // s11 and/or s21, both if neither
+	Window string           `json:"window"`         // none, hann or kaiser
+	Beta   float64          `json:"beta,omitempty"` // kaiser window shape
+	Result TimeDomainResult `json:"result,omitempty"`
+	Meta   *Meta            `json:"meta,omitempty"`
+}
+
+type TimeDomainResult struct {
+	Mode string        `json:"mode,omitempty"` // lowpass or bandpass
+	Time []float64     `json:"time,omitempty"` // seconds
+	S11  *TimeResponse `json:"s11,omitempty"`
+	S21  *TimeResponse `json:"s21,omitempty"`
+}
+
+type TimeResponse struct {
+	Impulse []float64 `json:"impulse"`
+	Step    []float64 `json:"step,omitempty"` // lowpass only
 }
 
 type SingleQuery struct {
@@ -158,7 +568,778 @@ type SingleQuery struct {
 
 type ReasonableFrequencyRange struct {
 	Command
-	Result Range `json:"range"`
+	Result  Range    `json:"range"`
+	Presets []Preset `json:"presets,omitempty"` // sweeps that an rq or rc can use by name
+}
+
+// GetGrid returns the grid of the current cal: its ID, which is also in the meta of each
+// result on the same frequencies, and the frequencies (Hz) themselves, e.g. to make an rq
+// on exactly the points of the cal
+type GetGrid struct {
+	Command
+	Grid        string   `json:"grid,omitempty"`
+	Frequencies []uint64 `json:"frequencies,omitempty"`
+}
+
+// Preset is a named sweep, from the config, that an rq or rc can use by giving its
+// name, so that a cal and the measurements made with it are on the same points
+type Preset struct {
+	Name            string `json:"name"`
+	Range           Range  `json:"range"`
+	Size            int    `json:"size"`
+	LogDistribution bool   `json:"islog"`
+}
+
+// SetPower sets the output power (dBm) used for subsequent sweeps
+// zero selects the device default
+type SetPower struct {
+	Command
+	Power float64 `json:"power"`
+}
+
+// SetFixture stores the S-parameters of a fixture or adapter on one port,
+// to be de-embedded from calibrated results. Supply either the contents of
+// a touchstone .s2p file, or the data directly. Port 1 of the fixture is
+// towards port 1 of the VNA, for both ports.
+type SetFixture struct {
+	Command
+	Port int      `json:"port"`
+	S2P  string   `json:"s2p,omitempty"`
+	Data []SParam `json:"data,omitempty"`
+}
+
+// PortExtension moves the reference plane of a port by a one-way
+// electrical delay (s), or by a physical length (m) of line with a
+// velocity factor (default 1). Zero delay and length remove the extension.
+type PortExtension struct {
+	Command
+	Port     int     `json:"port"`
+	Delay    float64 `json:"delay"`
+	Length   float64 `json:"length,omitempty"`
+	Velocity float64 `json:"vf,omitempty"`
+}
+
+// Sweep starts (startsweep) or stops (stopsweep) repeated calibrated
+// measurements of one DUT, which are sent without being asked for
+type Sweep struct {
+	CalibratedRangeQuery
+	Interval float64 `json:"interval"` // seconds from the end of one sweep to the start of the next
+}
+
+// Stability measures the DUT or standard in What, raw, with the sweep of the embedded
+// RangeQuery, every Every minutes for Hours hours, e.g. overnight, to find how far the
+// results drift, without a client staying connected. It is answered straight away, and
+// again with the drift in Summary when it is done. Stop ends it early, and Query returns
+// the drift so far, without starting one.
+type Stability struct {
+	RangeQuery
+	Every   float64           `json:"every"`             // minutes from the start of one measurement to the start of the next
+	Hours   float64           `json:"hours"`             // how long to measure for
+	Stop    bool              `json:"stop,omitempty"`    // stop the experiment that is running, and return its drift so far
+	Query   bool              `json:"query,omitempty"`   // return the drift so far of the experiment that is running
+	Summary *StabilitySummary `json:"summary,omitempty"` // the drift, when it is done, stopped or queried
+}
+
+// StabilitySummary is how far the results of a stability experiment drifted from the
+// first measurement, at each frequency, at each measurement since
+type StabilitySummary struct {
+	Start  time.Time        `json:"start"`            // when the first measurement was made
+	End    time.Time        `json:"end"`              // when the experiment is due to finish
+	Times  []float64        `json:"times"`            // seconds from the start to each measurement, starting with the first
+	Failed int              `json:"failed"`           // measurements that could not be made, which are left out
+	Done   bool             `json:"done"`             // the experiment has finished, rather than being stopped or queried
+	Logged string           `json:"logged,omitempty"` // key of the datalog in the store, that each measurement is added to
+	Drift  []StabilityDrift `json:"drift"`
+}
+
+// StabilityDrift is the change in each S-parameter at a frequency, from the first
+// measurement to each of the others in Times, in dB and degrees (-180 to 180), and
+// the largest change of each
+type StabilityDrift struct {
+	Freq uint64         `json:"freq"`
+	S11  []Value        `json:"s11"`
+	S12  []Value        `json:"s12"`
+	S21  []Value        `json:"s21"`
+	S22  []Value        `json:"s22"`
+	Max  StabilityRange `json:"max"`
+}
+
+// StabilityRange is the largest change in dB and degrees of each S-parameter
+type StabilityRange struct {
+	S11 Value `json:"s11"`
+	S12 Value `json:"s12"`
+	S21 Value `json:"s21"`
+	S22 Value `json:"s22"`
+}
+
+// Analysis finds markers in the last calibrated result
+type Analysis struct {
+	Command
+	What   string   `json:"what"` // the DUT that the markers are for
+	Result *Markers `json:"result,omitempty"`
+}
+
+// Markers are the usual features of a filter or antenna response
+type Markers struct {
+	S11Min        Marker     `json:"s11min"`           // deepest |S11|, e.g. resonance of an antenna
+	S21Max        Marker     `json:"s21max"`           // peak |S21|
+	Bandwidth3dB  *Bandwidth `json:"bw3db,omitempty"`  // where |S21| is within 3 dB of its peak, if both edges are in the sweep
+	Bandwidth10dB *Bandwidth `json:"bw10db,omitempty"` // where |S21| is within 10 dB of its peak, if both edges are in the sweep
+	Ripple        float64    `json:"ripple"`           // peak-to-peak |S21| in the 3 dB passband, dB
+	Crossover     []float64  `json:"crossover"`        // frequencies (Hz) where |S11| = |S21|
+}
+
+// Marker is a value in dB at a frequency in Hz
+type Marker struct {
+	Freq float64 `json:"freq"`
+	DB   float64 `json:"db"`
+}
+
+// Bandwidth is a band of frequencies, in Hz
+type Bandwidth struct {
+	Low    float64 `json:"low"`
+	High   float64 `json:"high"`
+	Centre float64 `json:"centre"`
+	Width  float64 `json:"width"`
+}
+
+// Hold controls the max-hold and min-hold of calibrated results; the command
+// (holdstart, holdquery or holdreset) says what to do, in the same way as rq/rc
+type Hold struct {
+	Command
+	What   string            `json:"what,omitempty"` // only hold results for this DUT, or for any if empty
+	Active bool              `json:"active"`         // whether calibrated results are being held
+	Count  int               `json:"count"`          // number of sweeps held so far
+	Max    []FormattedSParam `json:"max,omitempty"`  // largest magnitude (dB) seen at each frequency
+	Min    []FormattedSParam `json:"min,omitempty"`  // smallest magnitude (dB) seen at each frequency
+	Meta   *Meta             `json:"meta,omitempty"`
+}
+
+// Characterize measures switch paths with known standards fitted, and writes their
+// loss and phase to the path_loss file, for commissioning a rig. Paths gives the
+// standard (short, open or thru) fitted at each position, by default the standards
+// in their own positions. Result is the table found for each position, and File
+// where it was written, along with the paths already in that file.
+type Characterize struct {
+	Command
+	Range           Range                 `json:"range"`
+	Size            int                   `json:"size"`
+	LogDistribution bool                  `json:"islog"`
+	Avg             uint16                `json:"avg"`
+	Sweeps          int                   `json:"sweeps,omitempty"` // number of complete sweeps to average, default 1
+	Paths           map[string]string     `json:"paths,omitempty"`
+	File            string                `json:"file,omitempty"`
+	Result          map[string]SwitchPath `json:"result,omitempty"`
+}
+
+// PathOffset is the one-way loss and phase of a line through the switch at one frequency
+type PathOffset struct {
+	Freq  uint64  `json:"freq"`  // Hz
+	Loss  float64 `json:"loss"`  // insertion loss, dB, positive for a loss
+	Phase float64 `json:"phase"` // transmission phase, degrees, usually negative
+}
+
+// SwitchPath is the offsets of the lines from the VNA to each port of the device at one switch position
+type SwitchPath struct {
+	Port1 []PathOffset `json:"port1"`
+	Port2 []PathOffset `json:"port2,omitempty"` // same as port1 if empty
+}
+
+// NoiseFloor measures the load standard repeatedly, to show the trace noise
+// of the rig at each frequency
+type NoiseFloor struct {
+	Command
+	Range           Range   `json:"range"`
+	Size            int     `json:"size"`
+	LogDistribution bool    `json:"islog"`
+	Avg             uint16  `json:"avg"`
+	Sweeps          int     `json:"sweeps,omitempty"` // number of sweeps to take, default 10
+	Result          []Noise `json:"result,omitempty"`
+}
+
+// Noise holds the trace noise of each S-parameter at one frequency
+type Noise struct {
+	S11  Spread `json:"s11"`
+	S12  Spread `json:"s12"`
+	S21  Spread `json:"s21"`
+	S22  Spread `json:"s22"`
+	Freq uint64 `json:"freq"`
+}
+
+// Spread describes repeated readings of one S-parameter
+type Spread struct {
+	Mean  float64 `json:"mean"`  // mean magnitude, dB
+	Mag   float64 `json:"mag"`   // standard deviation of the magnitude, dB
+	Phase float64 `json:"phase"` // standard deviation of the phase, degrees
+}
+
+// ClearFixture removes the fixture from a port, or from both ports if Port is zero
+type ClearFixture struct {
+	Command
+	Port int `json:"port"`
+}
+
+// GetConfig returns the effective daemon settings, after the config file and environment are combined
+type GetConfig struct {
+	Command
+	Result interface{} `json:"result,omitempty"`
+}
+
+// Hello asks the daemon what it supports, so that a client can adapt to the rig
+// rather than assume. Version is the stream protocol version the client was written
+// for, if it knows it, and is only logged.
+type Hello struct {
+	Command
+	Version int           `json:"version,omitempty"`
+	Result  *Capabilities `json:"result,omitempty"`
+}
+
+// Capabilities describes the daemon and the rig it is running
+type Capabilities struct {
+	Protocol  int      `json:"protocol"`  // version of the stream protocol
+	Commands  []string `json:"commands"`  // every cmd accepted, including aliases
+	Positions []string `json:"positions"` // switch positions that can be measured
+	Range     Range    `json:"range"`     // reasonable frequency range of the VNA
+	Allowed   Range    `json:"allowed"`   // frequencies that may be measured on this rig, zero for no limit
+	Clamp     bool     `json:"clamp"`     // sweeps outside allowed are moved inside, rather than rejected
+	MaxPoints int      `json:"maxpoints"` // most frequencies in one sweep
+	MaxBatch  int      `json:"maxbatch"`  // most commands in one batch
+	MaxLimits int      `json:"maxlimits"` // most limit lines in setlimits
+	Chunking  Chunking `json:"chunking"`  // how large responses are split up
+}
+
+// Guard limits the frequencies that can be measured on a rig to Min to Max (Hz), if
+// they are given, and to Band Hz inside each end of the range of the VNA, where it
+// is less accurate. Sweeps outside are rejected, or moved inside if Clamp.
+type Guard struct {
+	Min   uint64
+	Max   uint64
+	Band  uint64
+	Clamp bool
+}
+
+// Chunking describes how responses that are too large for one message are sent
+type Chunking struct {
+	MaxMessage int    `json:"maxmessage"` // largest message sent, in bytes, 0 if there is no limit
+	Cmd        string `json:"cmd"`        // cmd of each part of a large response
+	Reassembly string `json:"reassembly"` // how to put the parts back together
+}
+
+// Reload re-reads the config file, and reports which changed settings were applied,
+// and which need a restart to take effect
+type Reload struct {
+	Command
+	Changed []string `json:"changed"`
+	Restart []string `json:"restart"`
+}
+
+// Reset closes the VNA, the switch and the connection to the calibration service, and
+// opens them again, without restarting, to recover a rig that has got confused. The
+// cal is discarded unless KeepCal is set. Reopened lists what was opened again.
+type Reset struct {
+	Command
+	KeepCal  bool     `json:"keepcal,omitempty"`
+	Reopened []string `json:"reopened"`
+}
+
+// FlashSwitch replaces the firmware of the switch controller, an arduino on a usb serial
+// port, so that a fix does not need anyone to go to the rig. Hex is the Intel HEX file
+// of the firmware, as exported by the Arduino IDE, and Baud is the speed of its
+// bootloader, 115200 unless given. Hex is left out of the response, and Written is
+// how many bytes were written and verified.
+type FlashSwitch struct {
+	Command
+	Hex     string `json:"hex,omitempty"`
+	Baud    int    `json:"baud,omitempty"`
+	Written int    `json:"written"`
+}
+
+// ProtocolTest puts the switch controller through every command, and edge cases such as
+// rapid toggling, malformed messages and timeouts, to validate a new build of its
+// firmware. Toggles is how many times the switch is moved back and forth as fast as it
+// will go, 20 unless given. The switch is left where it was.
+type ProtocolTest struct {
+	Command
+	Toggles int          `json:"toggles,omitempty"`
+	Result  *Conformance `json:"result,omitempty"`
+}
+
+// Conformance is the report of a ProtocolTest. Protocol is the version the controller
+// reported, 1 if it did not reply, and Moves counts the moves made to each position.
+type Conformance struct {
+	Protocol int                `json:"protocol"`
+	Passed   bool               `json:"passed"`
+	Failed   []string           `json:"failed,omitempty"`
+	Checks   []ConformanceCheck `json:"checks"`
+	Moves    map[string]int     `json:"moves"`
+	Port     string             `json:"port"`
+	Took     float64            `json:"took"` // seconds
+}
+
+// ConformanceCheck is one of the checks of a ProtocolTest, with what the controller did
+type ConformanceCheck struct {
+	Name   string  `json:"name"`
+	Passed bool    `json:"passed"`
+	Detail string  `json:"detail,omitempty"`
+	Took   float64 `json:"took"` // seconds
+}
+
+// SwitchWear reports how many times the switch has moved to each position, so that it
+// can be replaced before it wears out. Set Reset to start counting again from zero,
+// after the switch is replaced.
+type SwitchWear struct {
+	Command
+	Reset  bool  `json:"reset,omitempty"`
+	Result *Wear `json:"result,omitempty"`
+}
+
+// SwitchTraffic records each read from, and write to, the switch controller, to
+// diagnose its link. Set Record to start or stop recording, and Clear to drop the
+// lines recorded so far. Result is the last Limit lines, oldest first, each with the
+// time, which way the bytes went (tx, rx or drained) and how many, then the bytes in
+// hex and ascii. File is where they are appended to as well, if anywhere.
+type SwitchTraffic struct {
+	Command
+	Record    *bool    `json:"record,omitempty"`
+	Clear     bool     `json:"clear,omitempty"`
+	Limit     int      `json:"limit,omitempty"`
+	Recording bool     `json:"recording"`
+	File      string   `json:"file,omitempty"`
+	Result    []string `json:"result,omitempty"`
+}
+
+// Wear is how many times the switch has moved to each position since Since, and the
+// positions that have reached Rated, the moves at which it should be replaced, which
+// is zero if there is no limit
+type Wear struct {
+	Counts map[string]uint64 `json:"counts"`
+	Total  uint64            `json:"total"`
+	Since  time.Time         `json:"since"`
+	Rated  uint64            `json:"rated"`
+	Worn   []string          `json:"worn,omitempty"`
+}
+
+// Cancel stops the request that is being handled, e.g. a long calibration.
+// Cancelled is false if there was nothing to stop
+type Cancel struct {
+	Command
+	Cancelled bool `json:"cancelled"`
+}
+
+// Queue lists the request that is being handled, if any, and the requests waiting
+// behind it, in the order they will be handled, e.g. to find out what is holding up the rig
+type Queue struct {
+	Command
+	Current *Pending  `json:"current,omitempty"`
+	Pending []Pending `json:"pending"`
+}
+
+// FlushQueue drops the requests that are waiting to be handled, without answering
+// them, and lists them in Flushed. The request that is being handled is left to finish.
+type FlushQueue struct {
+	Command
+	Flushed []Pending `json:"flushed"`
+}
+
+// Scheduled is sent, with the id and session of a request that has an at time or an
+// after delay, when it is put aside to be done later. For is the cmd of the request, and
+// Due is when it will be done, and answered as usual. Schedule and Unschedule list the
+// scheduled requests in the same form.
+type Scheduled struct {
+	Command
+	For string    `json:"for"`
+	Due time.Time `json:"due"`
+}
+
+// Schedule lists the requests that are waiting to be done at a later time, soonest first:
+// those of its own session if it has one, or else all of them
+type Schedule struct {
+	Command
+	Scheduled []Scheduled `json:"scheduled"`
+}
+
+// Unschedule drops the scheduled request with the id in Drop, or every one if All is set,
+// before it is done, and lists them in Dropped. Only the requests of its own session are
+// dropped if it has one.
+type Unschedule struct {
+	Command
+	Drop    string      `json:"drop,omitempty"`
+	All     bool        `json:"all,omitempty"`
+	Dropped []Scheduled `json:"dropped"`
+}
+
+// Pending is a request that has been received, and how long ago, in seconds
+type Pending struct {
+	ID       string  `json:"id,omitempty"`
+	Cmd      string  `json:"cmd"`
+	Priority string  `json:"priority"`
+	Session  string  `json:"session,omitempty"`
+	Age      float64 `json:"age"`
+}
+
+// Audit returns the most recent entries in the audit log, up to Limit, oldest first,
+// that match every filter given. Since and Until are unix times, Who is a session,
+// and Commands lists the commands to include, by any of their names.
+type Audit struct {
+	Command
+	Since    int64        `json:"since,omitempty"`
+	Until    int64        `json:"until,omitempty"`
+	Who      string       `json:"who,omitempty"`
+	Commands []string     `json:"commands,omitempty"`
+	Limit    int          `json:"limit,omitempty"`
+	Result   []AuditEntry `json:"result,omitempty"`
+}
+
+// Logs returns the most recent lines of the debug log, oldest first, up to Limit,
+// that contain Match, if it is given, so that a maintainer can find out what went
+// wrong on a rig without logging in to it
+type Logs struct {
+	Command
+	Limit  int      `json:"limit,omitempty"`
+	Match  string   `json:"match,omitempty"`
+	Result []string `json:"result,omitempty"`
+}
+
+// History lists the most recent responses sent on the stream, oldest first, up to Limit,
+// without the responses themselves, so that a client that has lost its connection can
+// find out what it missed, and fetch it with LastResult
+type History struct {
+	Command
+	Limit  int            `json:"limit,omitempty"`
+	Result []HistoryEntry `json:"result,omitempty"`
+}
+
+// HistoryEntry is a response that was sent: when, and the session, id and cmd of the
+// request it answered, and whether it was ok or an error
+type HistoryEntry struct {
+	Time    time.Time `json:"time"`
+	Session string    `json:"session,omitempty"`
+	ID      string    `json:"id,omitempty"`
+	Cmd     string    `json:"cmd"`
+	Outcome string    `json:"outcome"`
+}
+
+// LastResult returns the last response sent to the request with the id in Request, or
+// if there is none, to the last request with the cmd in For, or else the last response
+// of all, as it was sent, e.g. to get the result of a sweep missed while disconnected
+type LastResult struct {
+	Command
+	Request string      `json:"request,omitempty"`
+	For     string      `json:"for,omitempty"`
+	Result  interface{} `json:"result,omitempty"`
+}
+
+// AuditEntry records one request: when it was handled, who sent it and how,
+// what it was, how long it waited and took, in seconds, and how it turned out,
+// which is ok, error or cancelled. Params is the request as it was received,
+// unless it was larger than the audit log keeps, when Omitted is its size in bytes.
+type AuditEntry struct {
+	Time     time.Time       `json:"time"`
+	Source   string          `json:"source"` // stream or grpc
+	Session  string          `json:"session,omitempty"`
+	ID       string          `json:"id,omitempty"`
+	Cmd      string          `json:"cmd"`
+	Priority string          `json:"priority,omitempty"`
+	Params   json.RawMessage `json:"params,omitempty"`
+	Omitted  int             `json:"omitted,omitempty"`
+	Wait     float64         `json:"wait"`
+	Duration float64         `json:"duration"`
+	Outcome  string          `json:"outcome"`
+	Error    string          `json:"error,omitempty"`
+}
+
+// ExportCal bundles the measurements of the standards in the current calibration
+// into a zip, for analysing the quality of the cal offline, e.g. in scikit-rf.
+// Result is the zip, which is base64 in JSON, and Name is a file name for it.
+type ExportCal struct {
+	Command
+	Name   string `json:"name,omitempty"`
+	Result []byte `json:"result,omitempty"`
+	// also keep the zip in the store, and the key it was kept under
+	Save  bool   `json:"save,omitempty"`
+	Saved string `json:"saved,omitempty"`
+}
+
+// CalArchive describes the calibration in an ExportCal zip, in calibration.json.
+// Files lists the touchstone files in the zip, by standard, and SwitchTerms are
+// the forward and reverse switch terms at each frequency, if they were removed
+// from the standards.
+type CalArchive struct {
+	Time            time.Time         `json:"time"`
+	Range           Range             `json:"range"`
+	Size            int               `json:"size"`
+	LogDistribution bool              `json:"islog"`
+	Avg             uint16            `json:"avg"`
+	Sweeps          int               `json:"sweeps,omitempty"`
+	Reject          string            `json:"reject,omitempty"`
+	Power           float64           `json:"power,omitempty"`
+	Z0              float64           `json:"z0"`
+	Kit             string            `json:"kit,omitempty"`
+	Frequencies     []uint64          `json:"frequencies"`
+	Files           map[string]string `json:"files"`
+	SwitchTerms     []SwitchTerm      `json:"switchterms,omitempty"`
+}
+
+// SwitchTerm is the forward and reverse switch term at one frequency
+type SwitchTerm struct {
+	Freq    uint64  `json:"freq"`
+	Forward Complex `json:"forward"`
+	Reverse Complex `json:"reverse"`
+}
+
+// CompareCal compares two calibrations, A and B, each the name or key of a cal saved
+// in the store by exportcal, or current for the current calibration, which B is if
+// it is not given, e.g. to judge whether a rig needs attention. ATime and BTime are
+// when they were made, and Result is how B differs from A at each frequency of A that
+// B covers, with the largest difference of each in Max.
+type CompareCal struct {
+	Command
+	A      string          `json:"a"`
+	B      string          `json:"b,omitempty"`
+	ATime  time.Time       `json:"atime,omitempty"`
+	BTime  time.Time       `json:"btime,omitempty"`
+	Result []CalDifference `json:"result,omitempty"`
+	Max    *CalDifference  `json:"max,omitempty"`
+}
+
+// CalDifference is how calibration B differs from A at one frequency. Thru is the thru
+// of B, corrected with the error terms of A, in dB and degrees, which is the ideal thru,
+// with S21 and S12 of 0 dB and 0 degrees, and no reflection, if nothing has changed.
+// Terms is the change in each error term from A to B, in dB and degrees (-180 to 180).
+type CalDifference struct {
+	Freq  uint64          `json:"freq"`
+	Thru  FormattedSParam `json:"thru"`
+	Terms CalTerms        `json:"terms"`
+}
+
+// CalTerms holds a value for each of the twelve error terms, e.g. Edf is forward
+// directivity and Elr is reverse load match, with isolation as Exf and Exr
+type CalTerms struct {
+	Edf Value `json:"edf"`
+	Esf Value `json:"esf"`
+	Erf Value `json:"erf"`
+	Etf Value `json:"etf"`
+	Elf Value `json:"elf"`
+	Exf Value `json:"exf"`
+	Edr Value `json:"edr"`
+	Esr Value `json:"esr"`
+	Err Value `json:"err"`
+	Etr Value `json:"etr"`
+	Elr Value `json:"elr"`
+	Exr Value `json:"exr"`
+}
+
+// Bench times each stage of handling a sweep of Size points, Repeat times, using
+// synthetic data rather than the VNA, so that the speed of the rig can be checked
+// where it is deployed. Result has the time of each stage, in seconds, and Total
+// is the sum of their means.
+type Bench struct {
+	Command
+	Size   int          `json:"size,omitempty"`
+	Repeat int          `json:"repeat,omitempty"`
+	Result []BenchStage `json:"result,omitempty"`
+	Total  float64      `json:"total,omitempty"`
+}
+
+// BenchStage is the mean and longest time taken by a stage of a Bench, in seconds,
+// or the error that stopped it
+type BenchStage struct {
+	Stage string  `json:"stage"`
+	Mean  float64 `json:"mean"`
+	Max   float64 `json:"max"`
+	Error string  `json:"error,omitempty"`
+}
+
+// MeasureCal measures one standard of the current calibration again (What is
+// short, open, load, thru, or a device for the switch terms), and makes the cal
+// again with it, without measuring the others. Result is the new measurement.
+type MeasureCal struct {
+	Command
+	What    string   `json:"what"`
+	Result  []SParam `json:"result,omitempty"`
+	Warning string   `json:"warning,omitempty"` // e.g. measured while the VNA was warming up
+}
+
+// ResumeCal carries on an rc that was interrupted part way, e.g. by a loose thru,
+// measuring only the standards that had not been measured, and any in Redo again,
+// and makes the cal. Measured lists those it measured, and Result is the thru,
+// calibrated, as for rc.
+type ResumeCal struct {
+	Command
+	Redo     []string `json:"redo,omitempty"` // standards that were measured, to measure again first
+	Measured []string `json:"measured,omitempty"`
+	Result   []SParam `json:"result,omitempty"`
+	Meta     *Meta    `json:"meta,omitempty"`
+	Warning  string   `json:"warning,omitempty"` // e.g. measured while the VNA was warming up
+}
+
+// CalState reports where the calibration is up to: uncalibrated, setup, partial
+// (some standards measured), interrupted (an rc stopped part way, and can be
+// resumed), calibrated, or stale (a setting the cal depends on, such as the output
+// power, has changed since). Since is when that state was entered, Reason is why,
+// if known, Measured lists the standards measured so far in the current or last rc,
+// and Remaining those still to be measured, while a cal is being made or resumed.
+type CalState struct {
+	Command
+	State     string    `json:"state,omitempty"`
+	Since     time.Time `json:"since"`
+	Reason    string    `json:"reason,omitempty"`
+	Measured  []string  `json:"measured,omitempty"`
+	Remaining []string  `json:"remaining,omitempty"`
+	Warmup   *Warmup   `json:"warmup,omitempty"` // only if a warm-up period is set
+}
+
+// Warmup says whether the VNA has warmed up, since calibrating before then gives a cal
+// that drifts. Period is how long it takes (seconds) from when it was opened (On), which
+// is taken as when it was powered on, and Remaining is how much longer it will be.
+// FirstMeasured is when it first measured, if it has. Refuse is whether an rc or mc is
+// refused, rather than warned about, until it has warmed up.
+type Warmup struct {
+	Warm          bool       `json:"warm"`
+	Period        float64    `json:"period"`
+	Remaining     float64    `json:"remaining"`
+	On            time.Time  `json:"on"`
+	FirstMeasured *time.Time `json:"firstmeasured,omitempty"`
+	Refuse        bool       `json:"refuse,omitempty"`
+}
+
+// CalEvent is sent, without being asked for, each time the calibration moves
+// from one state to another (see CalState), because of Event.
+type CalEvent struct {
+	Command
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Event     string    `json:"event"`
+	Reason    string    `json:"reason,omitempty"`
+	At        time.Time `json:"at"`
+	Measured  []string  `json:"measured,omitempty"`  // standards measured so far, while a cal is being made or resumed
+	Remaining []string  `json:"remaining,omitempty"` // standards still to be measured
+}
+
+// Plan is the response to a request with dryrun set, which is checked but not
+// done: the switch positions it would measure, in order, the sweeps it would make,
+// and roughly how long it would take, in seconds. Nothing is measured or changed.
+type Plan struct {
+	Command
+	Steps    []Step  `json:"steps"`
+	Sweeps   int     `json:"sweeps"`
+	Estimate float64 `json:"estimate"`
+}
+
+// Step is the measurement of one switch position in a Plan, with the number of
+// sweeps, the points in each, and roughly how long it would take, in seconds
+type Step struct {
+	What     string  `json:"what"`
+	Sweeps   int     `json:"sweeps"`
+	Points   int     `json:"points"`
+	Estimate float64 `json:"estimate"`
+}
+
+// Estimate predicts how long it would take, in seconds, to measure Positions
+// switch positions (one unless given), or to calibrate, if Cal is set, making
+// Sweeps sweeps of Size points (those of the current cal, if zero) at each,
+// averaging Avg readings at each point. The time per point, at an average of one,
+// is learned from the sweeps made so far, unless there have been none.
+type Estimate struct {
+	Command
+	Size      int     `json:"size,omitempty"`
+	Avg       uint16  `json:"avg,omitempty"`
+	Sweeps    int     `json:"sweeps,omitempty"`
+	Positions int     `json:"positions,omitempty"`
+	Cal       bool    `json:"cal,omitempty"`
+	PointTime float64 `json:"pointtime"` // seconds per point, at an average of one
+	Learned   bool    `json:"learned"`   // pointtime was learned from sweeps, rather than assumed
+	Result    float64 `json:"result"`
+}
+
+// SwitchEvent is sent, without being asked for, each time the RF switch moves to
+// another position, including during a calibration, so that a UI can show the
+// signal path. From is empty if where the switch was before is not known.
+type SwitchEvent struct {
+	Command
+	From string    `json:"from,omitempty"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+// Compare measures two DUTs, A then B, back to back with the same settings, and
+// returns both calibrated results, and how B differs from A at each frequency
+// (the ratio of the magnitudes in dB, and the phase difference in degrees)
+type Compare struct {
+	Command
+	A       string            `json:"a"`
+	B       string            `json:"b"`
+	Avg     uint16            `json:"avg"`
+	Select  SParamSelect      `json:"sparam"`
+	Z0      float64           `json:"z0,omitempty"`     // reference impedance (ohms) for the results, default 50
+	Sweeps  int               `json:"sweeps,omitempty"` // number of complete sweeps to average, default 1
+	Reject  string            `json:"reject,omitempty"` // none (default), median or outlier
+	ResultA []SParam          `json:"resulta,omitempty"`
+	ResultB []SParam          `json:"resultb,omitempty"`
+	Delta   []FormattedSParam `json:"delta,omitempty"`
+	Meta    *Meta             `json:"meta,omitempty"`
+}
+
+// Batch runs a list of commands in order, with no other requests in between, and
+// returns their results together. It stops at the first error, unless Continue is set,
+// and the error is in place of that command's result.
+type Batch struct {
+	Command
+	Commands []json.RawMessage `json:"commands"`
+	Continue bool              `json:"continue,omitempty"`
+	Requests []interface{}     `json:"-"` // the commands, parsed
+	Results  []interface{}     `json:"results"`
+}
+
+// Schema returns the JSON Schema of the request and response of a command, or of
+// every command if Name is empty
+type Schema struct {
+	Command
+	Name   string      `json:"name,omitempty"`
+	Result interface{} `json:"result,omitempty"`
+}
+
+// Invalid is a request that does not match the schema of its command, along with
+// what is wrong with each field. It is sent back as the request, with the errors.
+type Invalid struct {
+	Request interface{}
+	Errors  []string
+}
+
+func (i Invalid) MarshalJSON() ([]byte, error) {
+	return json.Marshal(i.Request)
+}
+
+// Reconnected is sent when the connection to the relay is made again, after any
+// responses that were held while it was down. Dropped is how many responses were
+// lost because too many were held, in which case the requests should be sent again.
+type Reconnected struct {
+	Command
+	Count   int `json:"count"`
+	Dropped int `json:"dropped"`
+}
+
+// Working is sent, with the id and session of a request, each time its soft
+// timeout passes while it is still being handled, so that the user knows it has
+// not been forgotten. For is the cmd of the request, Elapsed is how long it has
+// taken so far, and Timeout is how long it may take before it is stopped, in seconds.
+type Working struct {
+	Command
+	For     string  `json:"for"`
+	Elapsed float64 `json:"elapsed"`
+	Timeout float64 `json:"timeout"`
+}
+
+// Part is one piece of a response that is too large to send in one message.
+// Parts with the same Message number are joined in order of Part, from 1 to Parts,
+// to give the JSON of the response, whose cmd is Of.
+type Part struct {
+	Command
+	Of      string `json:"of"`
+	Message int    `json:"message"`
+	Part    int    `json:"part"`
+	Parts   int    `json:"parts"`
+	Data    string `json:"data"`
 }
 
 type Progress struct {
@@ -176,6 +1357,23 @@ type Complex struct {
 	Imag float64 `json:"imag"`
 }
 
+// Columns is a result laid out as one array per quantity, for loading straight into
+// numpy or MATLAB, with the dtype of each once the real and imaginary parts are joined
+type Columns struct {
+	Freq   []uint64          `json:"freq"`
+	S11    ComplexColumn     `json:"s11"`
+	S12    ComplexColumn     `json:"s12"`
+	S21    ComplexColumn     `json:"s21"`
+	S22    ComplexColumn     `json:"s22"`
+	DTypes map[string]string `json:"dtypes"`
+}
+
+// ComplexColumn holds the real and imaginary parts of an S-parameter at each point
+type ComplexColumn struct {
+	Real []float64 `json:"real"`
+	Imag []float64 `json:"imag"`
+}
+
 const (
 	Undefined Distribution = iota //handle default value being undefined
 	Linear
@@ -257,6 +1455,10 @@ func (h *Hardware) HandleCommand(command interface{}) error {
 
 		return h.SingleQuery(command) //.(SingleQuery))
 
+	case *SetPower:
+
+		return h.SetPower(command)
+
 	default:
 		return errors.New("unknown command")
 	}
@@ -267,13 +1469,36 @@ func (h *Hardware) RangeQuery(command interface{}) error {
 
 	r := command.(*RangeQuery)
 
+	if r.Power != 0 {
+		return ErrPowerNotSupported
+	}
+
 	distr := 1 // Linear
 
 	if r.LogDistribution {
 		distr = 2
 	}
 
-	sparams, err := rangeQuery(h.handle, r.Range.Start, r.Range.End, r.Size, distr, r.Avg, r.Select)
+	var sparams []SParam
+	var err error
+
+	if len(r.Frequencies) > 0 {
+
+		err = CheckFrequencies(r.Frequencies)
+
+		if err != nil {
+			return err
+		}
+
+		r.Size = len(r.Frequencies)
+
+		sparams, err = multiQuery(h.handle, r.Frequencies, r.Avg, r.Select)
+
+	} else {
+
+		sparams, err = rangeQuery(h.handle, r.Range.Start, r.Range.End, r.Size, distr, r.Avg, r.Select)
+
+	}
 
 	if err != nil {
 		return err
@@ -286,6 +1511,33 @@ func (h *Hardware) RangeQuery(command interface{}) error {
 	return err
 }
 
+// Prepare checks a range query, and that the VNA is still connected, ahead of
+// the query itself. It does not use the VNA in any way that affects a sweep,
+// so it can be called while the rf switch is being set
+func (h *Hardware) Prepare(command interface{}) error {
+
+	r, ok := command.(*RangeQuery)
+
+	if !ok {
+		return errors.New("unknown command")
+	}
+
+	if r.Power != 0 {
+		return ErrPowerNotSupported
+	}
+
+	if len(r.Frequencies) > 0 {
+
+		err := CheckFrequencies(r.Frequencies)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return isValid(h.handle)
+}
+
 func (h *Hardware) SingleQuery(command interface{}) error {
 
 	s := command.(*SingleQuery)
@@ -304,6 +1556,20 @@ func (h *Hardware) SingleQuery(command interface{}) error {
 
 }
 
+// SetPower only accepts the default output power, because the driver
+// offers no way to change it (see ErrPowerNotSupported)
+func (h *Hardware) SetPower(command interface{}) error {
+
+	p := command.(*SetPower)
+
+	if p.Power != 0 {
+		return ErrPowerNotSupported
+	}
+
+	return nil
+
+}
+
 func NewMock() *Mock {
 	return &Mock{}
 }
@@ -354,6 +1620,25 @@ func (m *Mock) RangeQuery(command interface{}) error {
 	return m.CommandError
 }
 
+func (m *Mock) Prepare(command interface{}) error {
+
+	time.Sleep(m.PrepareDelay)
+	m.Prepared++
+
+	return m.CommandError
+}
+
+func (m *Mock) SetPower(command interface{}) error {
+
+	c := command.(*SetPower)
+
+	m.Power = c.Power
+	cc := *c
+	m.CommandsReceived = append(m.CommandsReceived, cc)
+
+	return m.CommandError
+}
+
 func (m *Mock) HandleCommand(command interface{}) error {
 
 	// used to return CustomResult{Message: err.Error()} on error, or copy of command
@@ -373,12 +1658,65 @@ func (m *Mock) HandleCommand(command interface{}) error {
 
 		return m.SingleQuery(command)
 
+	case *SetPower:
+
+		return m.SetPower(command)
+
 	default:
 		return errors.New("unknown command")
 	}
 
 }
 
+// MaxPoints is the largest number of frequencies in one sweep
+const MaxPoints = 512
+
+// CheckFrequencies returns an error if a list of frequencies cannot be measured
+// in one sweep, or is not in increasing order (which the calibration needs)
+func CheckFrequencies(freq []uint64) error {
+
+	if len(freq) == 0 {
+		return errors.New("no frequencies given")
+	}
+
+	if len(freq) > MaxPoints {
+		return fmt.Errorf("too many frequencies (%d), the maximum is %d", len(freq), MaxPoints)
+	}
+
+	for i, f := range freq {
+		if f == 0 {
+			return errors.New("frequencies must be greater than zero")
+		}
+		if i > 0 && f <= freq[i-1] {
+			return fmt.Errorf("frequencies must be in increasing order, but %d Hz follows %d Hz", f, freq[i-1])
+		}
+	}
+
+	return nil
+}
+
+// CheckSegments returns an error if the segments of a sweep are not each
+// a valid range, or overlap, or are not in increasing order of frequency
+func CheckSegments(segments []Segment) error {
+
+	for i, g := range segments {
+
+		if g.Size < 2 || g.Size > MaxPoints {
+			return fmt.Errorf("segment %d must have between 2 and %d points, not %d", i+1, MaxPoints, g.Size)
+		}
+
+		if g.Start == 0 || g.End <= g.Start {
+			return fmt.Errorf("segment %d must have 0 < start < end", i+1)
+		}
+
+		if i > 0 && g.Start <= segments[i-1].End {
+			return fmt.Errorf("segment %d must start above the end of segment %d", i+1, i)
+		}
+	}
+
+	return nil
+}
+
 func LinFrequency(start, end uint64, size int) []uint64 {
 
 	var ff []uint64
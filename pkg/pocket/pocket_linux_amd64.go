@@ -81,6 +81,24 @@ func getReasonableFrequencyRange(handle C.PVNA_DeviceHandler) (uint64, uint64, e
 
 }
 
+/* @brief Check whether the device handle is still valid, e.g. the device has not been disconnected
+
+       @ingroup API
+       @param handle A pointer to Device.
+
+       @returns
+           This function returns Result: 'Ok' on success, 'PVNA_Res_InvalidHandle' if handle is invalid
+
+   PVNA_EXPORTED PVNA_Res   pocketvna_is_valid(const PVNA_DeviceHandler handle);
+*/
+
+func isValid(handle C.PVNA_DeviceHandler) error {
+
+	result := C.pocketvna_is_valid(handle)
+	return decode(result)
+
+}
+
 /*  @brief Query device for some Network Parameters for particular frequency
      *
      *  It accepts @p handle and gets Network parameters @p params
@@ -251,7 +269,7 @@ func rangeQuery(handle C.PVNA_DeviceHandler, start, end uint64, size int, distr
 		ff = LogFrequency(start, end, size)
 	}
 
-	ss := []SParam{}
+	ss := make([]SParam, 0, size)
 
 	for i := 0; i < int(size); i++ {
 
@@ -272,3 +290,61 @@ func rangeQuery(handle C.PVNA_DeviceHandler, start, end uint64, size int, distr
 	return ss, decode(result)
 
 }
+
+/*
+   PVNA_EXPORTED PVNA_Res   pocketvna_multi_query(const PVNA_DeviceHandler handle,
+                                       const PVNA_Frequency * frequencies, const uint32_t size,
+                                       const uint16_t average, const PVNA_NetworkParam params,
+                                       PVNA_Sparam * s11a, PVNA_Sparam * s21a,
+                                       PVNA_Sparam * s12a, PVNA_Sparam * s22a,
+                                       PVNA_ProgressCallBack * progress);
+*/
+
+// multiQuery measures at an arbitrary list of frequencies (no callback, as for rangeQuery)
+func multiQuery(handle C.PVNA_DeviceHandler, freq []uint64, avg uint16, p SParamSelect) ([]SParam, error) {
+
+	size := len(freq)
+
+	ff := make([]C.PVNA_Frequency, size)
+
+	for i, f := range freq {
+		ff[i] = C.PVNA_Frequency(f)
+	}
+
+	S11 := make([]C.PVNA_Sparam, size)
+	S12 := make([]C.PVNA_Sparam, size)
+	S21 := make([]C.PVNA_Sparam, size)
+	S22 := make([]C.PVNA_Sparam, size)
+
+	result := C.pocketvna_multi_query(handle,
+		&ff[0],
+		C.uint32_t(size),
+		C.uint16_t(avg),
+		encodeParams(p),
+		&S11[0],
+		&S21[0],
+		&S12[0],
+		&S22[0],
+		nil)
+
+	ss := make([]SParam, 0, size)
+
+	for i := 0; i < size; i++ {
+
+		s := SParam{
+			S11:  Complex{Real: float64(S11[i].real), Imag: float64(S11[i].imag)},
+			S12:  Complex{Real: float64(S12[i].real), Imag: float64(S12[i].imag)},
+			S21:  Complex{Real: float64(S21[i].real), Imag: float64(S21[i].imag)},
+			S22:  Complex{Real: float64(S22[i].real), Imag: float64(S22[i].imag)},
+			Freq: freq[i],
+		}
+
+		ss = append(ss, s)
+
+	}
+
+	log.Debugf("mq decoded result: %v", decode(result))
+
+	return ss, decode(result)
+
+}
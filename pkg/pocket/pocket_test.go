@@ -3,11 +3,13 @@ package pocket
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"testing"
 
+	"github.com/practable/pocket-vna-two-port/pkg/parallel"
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 )
@@ -91,6 +93,143 @@ func TestLinLogFrequency(t *testing.T) {
 
 }
 
+func TestCheckFrequencies(t *testing.T) {
+
+	assert.NoError(t, CheckFrequencies([]uint64{100e6, 101e6, 150e6}))
+
+	assert.Error(t, CheckFrequencies(nil))
+	assert.Error(t, CheckFrequencies([]uint64{0, 1e6}))
+	assert.Error(t, CheckFrequencies([]uint64{2e6, 1e6}))
+	assert.Error(t, CheckFrequencies([]uint64{1e6, 1e6}))
+	assert.Error(t, CheckFrequencies(LinFrequency(1e6, 2e6, MaxPoints+1)))
+}
+
+func TestCheckSegments(t *testing.T) {
+
+	assert.NoError(t, CheckSegments([]Segment{
+		{Start: 1e6, End: 100e6, Size: 11},
+		{Start: 101e6, End: 110e6, Size: 201, Avg: 4},
+	}))
+
+	assert.Error(t, CheckSegments([]Segment{{Start: 1e6, End: 100e6, Size: 1}}))
+	assert.Error(t, CheckSegments([]Segment{{Start: 1e6, End: 100e6, Size: MaxPoints + 1}}))
+	assert.Error(t, CheckSegments([]Segment{{Start: 100e6, End: 100e6, Size: 2}}))
+	assert.Error(t, CheckSegments([]Segment{
+		{Start: 1e6, End: 100e6, Size: 11},
+		{Start: 100e6, End: 110e6, Size: 11},
+	}))
+}
+
+func TestLookup(t *testing.T) {
+
+	for _, name := range []string{"rq", "rangequery", "RangeQuery", "RQ"} {
+		c, ok := Lookup(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, "rq", c)
+	}
+
+	c, ok := Lookup("savereference")
+	assert.True(t, ok)
+	assert.Equal(t, "saveref", c)
+
+	_, ok = Lookup("nope")
+	assert.False(t, ok)
+
+	_, ok = Lookup("")
+	assert.False(t, ok)
+
+	// each name is for one command only
+	seen := make(map[string]bool)
+
+	for _, n := range AllNames() {
+		assert.False(t, seen[n], n)
+		seen[n] = true
+	}
+
+	assert.Equal(t, len(lookup), len(seen))
+}
+
+func TestCommandOf(t *testing.T) {
+
+	c := Command{ID: "a", Command: "crq", Session: "bench-3"}
+
+	for _, v := range []interface{}{c, CalibratedRangeQuery{Command: c}, CustomResult{Command: RangeQuery{Command: c}}, Invalid{Request: c}} {
+		got, ok := CommandOf(v)
+		assert.True(t, ok)
+		assert.Equal(t, c, got)
+	}
+
+	_, ok := CommandOf(3)
+	assert.False(t, ok)
+
+	_, ok = CommandOf(Range{})
+	assert.False(t, ok)
+}
+
+func TestMarshal(t *testing.T) {
+
+	d := parallel.Default
+	defer func() { parallel.Default = d }()
+
+	parallel.Default = parallel.NewPool(3)
+
+	// an id that looks like the keys that are filled in
+	c := Command{Command: "crq", ID: `"result":null,"formatted":[{"freq":0}]`}
+
+	for _, n := range []int{0, 2, 1001} {
+
+		var result []SParam
+		var formatted []FormattedSParam
+
+		for i := 0; i < n; i++ {
+			v := Value{Mag: -float64(i) / 3, Phase: float64(i % 360)}
+			result = append(result, SParam{Freq: uint64(1e6 + i*1e6), S21: Complex{Real: float64(i) / 7, Imag: -0.5}})
+			formatted = append(formatted, FormattedSParam{Freq: uint64(1e6 + i*1e6), S21: &v})
+		}
+
+		for _, r := range []interface{}{
+			RangeQuery{Command: c, Result: result, Meta: &Meta{Freq: Hz}},
+			&RangeQuery{Command: c, Result: result},
+			CalibratedRangeQuery{Command: c, Result: result, Formatted: formatted, Format: "db", Meta: &Meta{Formatted: "db"}},
+			&CalibratedRangeQuery{Command: c, Formatted: formatted, FormatOnly: true},
+			CalibratedRangeQuery{Command: c, Result: result},
+			Sweep{CalibratedRangeQuery: CalibratedRangeQuery{Command: c, Result: result}, Interval: 1},
+		} {
+			want, err := json.Marshal(r)
+			assert.NoError(t, err)
+
+			got, err := Marshal(r)
+			assert.NoError(t, err)
+			assert.Equal(t, string(want), string(got))
+		}
+	}
+
+	// the response is not changed
+	r := CalibratedRangeQuery{Result: make([]SParam, 1001), Formatted: make([]FormattedSParam, 1001)}
+	_, err := Marshal(&r)
+	assert.NoError(t, err)
+	assert.Equal(t, 1001, len(r.Result))
+	assert.Equal(t, 1001, len(r.Formatted))
+}
+
+func TestRank(t *testing.T) {
+
+	admin, ok := Rank("admin")
+	assert.True(t, ok)
+
+	interactive, ok := Rank("")
+	assert.True(t, ok)
+
+	batch, ok := Rank("Batch")
+	assert.True(t, ok)
+
+	assert.True(t, admin < interactive)
+	assert.True(t, interactive < batch)
+
+	_, ok = Rank("urgent")
+	assert.False(t, ok)
+}
+
 func TestMockConnect(t *testing.T) {
 
 	v := NewMock()
@@ -205,6 +344,41 @@ func TestMockRangeQuery(t *testing.T) {
 	assert.Equal(t, []interface{}{c}, v.CommandsReceived)
 }
 
+func TestMockSetPower(t *testing.T) {
+
+	v := NewMock()
+
+	c := SetPower{Command: Command{ID: "sp00"}, Power: -10}
+
+	err := v.SetPower(&c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, -10.0, v.Power)
+	assert.Equal(t, []interface{}{c}, v.CommandsReceived)
+
+	c = SetPower{Command: Command{ID: "sp01"}}
+	err = v.HandleCommand(&c)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, v.Power)
+}
+
+func TestHardwareSetPower(t *testing.T) {
+
+	// does not use the handle, so no hardware needed
+	h := new(Hardware)
+
+	err := h.SetPower(&SetPower{})
+	assert.NoError(t, err)
+
+	err = h.SetPower(&SetPower{Power: -5})
+	assert.Equal(t, ErrPowerNotSupported, err)
+
+	err = h.RangeQuery(&RangeQuery{Power: -5})
+	assert.Equal(t, ErrPowerNotSupported, err)
+
+}
+
 func TestMockHandleCommand(t *testing.T) {
 
 	v := NewMock()
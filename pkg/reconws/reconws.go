@@ -25,6 +25,7 @@ import (
 	"errors"
 	"math/rand"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -36,17 +37,45 @@ import (
 type WsMessage struct {
 	Data []byte
 	Type int
+	// Transient messages, e.g. heartbeats, are not worth keeping while disconnected
+	Transient bool
+	// To is the session the message is for, or empty for everyone. Only a stream
+	// server that addresses sessions uses it, a relay sends every message to everyone.
+	To string
 }
 
+// DefaultBuffer is the number of outgoing messages kept while disconnected
+const DefaultBuffer = 64
+
 // connects (retrying/reconnecting if necessary) to websocket server at url
+// Messages sent to Out while disconnected, or that could not be written when the
+// connection dropped, are kept and sent once connected again, up to Buffer of them,
+// after which the oldest are dropped. Each time the connection is made again,
+// a Reconnection is sent on Reconnected, if there is room.
 
 type ReconWs struct {
 	ForwardIncoming bool
 	In              chan WsMessage
 	Out             chan WsMessage
+	Reconnected     chan Reconnection
 	Retry           RetryConfig
 	Url             string
 	ID              string
+	Buffer          int
+
+	start sync.Once
+	mu    sync.Mutex
+	queue []WsMessage   // outgoing messages, oldest first
+	ready chan struct{} // signals there is something in the queue
+	// connections made so far, and messages dropped since the last one
+	connections int
+	dropped     int
+}
+
+// Reconnection describes a connection that was made again after being lost
+type Reconnection struct {
+	Count   int // the number of times the connection has been made again
+	Dropped int // outgoing messages dropped while disconnected, because the buffer was full
 }
 
 type RetryConfig struct {
@@ -61,6 +90,9 @@ func New() *ReconWs {
 	r := &ReconWs{
 		In:              make(chan WsMessage),
 		Out:             make(chan WsMessage),
+		Reconnected:     make(chan Reconnection, 1),
+		Buffer:          DefaultBuffer,
+		ready:           make(chan struct{}, 1),
 		ForwardIncoming: true,
 		Retry: RetryConfig{Factor: 2,
 			Min:     1 * time.Second,
@@ -85,6 +117,8 @@ func (r *ReconWs) Reconnect(ctx context.Context, url string) {
 
 	rand.Seed(time.Now().UTC().UnixNano())
 
+	r.start.Do(func() { go r.keep(ctx) })
+
 	// try dialling ....
 
 	for {
@@ -157,6 +191,11 @@ func (r *ReconWs) Dial(ctx context.Context, urlStr string) error {
 	}
 
 	log.WithField("To", u).Tracef("%s: connected to %s", id, u)
+
+	r.start.Do(func() { go r.keep(ctx) })
+
+	r.connected()
+
 	// handle our reading tasks
 
 	readClosed := make(chan struct{})
@@ -197,14 +236,20 @@ LOOPWRITING:
 		case <-readClosed:
 			err = nil // nil error resets the backoff
 			break LOOPWRITING
-		case msg := <-r.Out:
+		case <-r.ready:
 
-			err := c.WriteMessage(msg.Type, msg.Data)
-			if err != nil {
-				log.WithField("error", err).Infof("%s: error writing to conn; closing", id)
-				break LOOPWRITING
+			msgs := r.take()
+
+			for i, msg := range msgs {
+
+				err := c.WriteMessage(msg.Type, msg.Data)
+				if err != nil {
+					log.WithField("error", err).Infof("%s: error writing to conn; closing", id)
+					r.putBack(msgs[i:])
+					break LOOPWRITING
+				}
+				log.Debugf("%s: sent %d-byte message", id, len(msg.Data))
 			}
-			log.Debugf("%s: sent %d-byte message", id, len(msg.Data))
 
 		case <-ctx.Done(): // context has finished, either timeout or cancel
 			//TODO - do we need to do this?
@@ -223,3 +268,99 @@ LOOPWRITING:
 	return err
 
 }
+
+// keep takes each message from Out and adds it to the queue, until ctx is done, so
+// that senders are not held up while disconnected. Once the queue is full, the oldest
+// message is dropped. A transient message is dropped instead if others are waiting.
+func (r *ReconWs) keep(ctx context.Context) {
+
+	for {
+		select {
+
+		case <-ctx.Done():
+			return
+
+		case msg := <-r.Out:
+
+			r.mu.Lock()
+
+			switch {
+			case msg.Transient && len(r.queue) > 0:
+				// not worth keeping
+			case r.Buffer > 0 && len(r.queue) >= r.Buffer:
+				r.queue = append(r.queue[1:], msg)
+				r.dropped++
+			default:
+				r.queue = append(r.queue, msg)
+			}
+
+			r.mu.Unlock()
+
+			r.signal()
+		}
+	}
+}
+
+// signal wakes the writer, if it is not already due to look at the queue
+func (r *ReconWs) signal() {
+	select {
+	case r.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take empties the queue, returning what was in it
+func (r *ReconWs) take() []WsMessage {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	msgs := r.queue
+	r.queue = nil
+
+	return msgs
+}
+
+// putBack returns messages that were not sent to the front of the queue, to be
+// sent first on the next connection
+func (r *ReconWs) putBack(msgs []WsMessage) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.queue = append(append([]WsMessage{}, msgs...), r.queue...)
+
+	if r.Buffer > 0 && len(r.queue) > r.Buffer {
+		r.dropped += len(r.queue) - r.Buffer
+		r.queue = r.queue[len(r.queue)-r.Buffer:]
+	}
+}
+
+// connected notes a new connection, reporting it if it was made again, and
+// starts sending anything that was kept while disconnected
+func (r *ReconWs) connected() {
+
+	r.mu.Lock()
+
+	r.connections++
+	rc := Reconnection{Count: r.connections - 1, Dropped: r.dropped}
+	r.dropped = 0
+	pending := len(r.queue)
+
+	r.mu.Unlock()
+
+	if pending > 0 {
+		r.signal()
+	}
+
+	if rc.Count == 0 {
+		return
+	}
+
+	log.WithFields(log.Fields{"count": rc.Count, "dropped": rc.Dropped, "pending": pending}).Infof("reconws.Dial(%s): reconnected", r.ID)
+
+	select {
+	case r.Reconnected <- rc:
+	default:
+	}
+}
@@ -10,12 +10,14 @@ import (
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/jpillora/backoff"
 	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 )
 
 func init() {
@@ -206,6 +208,100 @@ func TestReconnectAfterDisconnect(t *testing.T) {
 	cancel()
 }
 
+func TestBufferWhileDisconnected(t *testing.T) {
+
+	r := New()
+	r.Buffer = 3
+	r.Retry.Min = 20 * time.Millisecond
+	r.Retry.Max = 50 * time.Millisecond
+
+	got := make(chan string, 10)
+	attempt := make(chan struct{}, 100)
+	open := make(chan struct{})
+	var mu sync.Mutex
+	n := 0
+
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+
+		mu.Lock()
+		n++
+		first := n == 1
+		mu.Unlock()
+
+		if !first {
+			select {
+			case <-open:
+			default:
+				attempt <- struct{}{}
+				http.Error(w, "down", http.StatusServiceUnavailable)
+				return
+			}
+		}
+
+		c, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+
+		for {
+			_, message, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			got <- string(message)
+			if first {
+				return // the relay goes away
+			}
+		}
+	}))
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go r.Reconnect(ctx, "ws"+strings.TrimPrefix(s.URL, "http"))
+
+	r.Out <- WsMessage{Data: []byte("a"), Type: websocket.TextMessage}
+	assert.Equal(t, "a", <-got)
+
+	// wait until the loss is noticed
+	select {
+	case <-attempt:
+	case <-time.After(time.Second):
+		t.Fatal("no attempt to reconnect")
+	}
+
+	// sending is not held up, and the oldest is dropped once the buffer is full
+	for _, m := range []string{"b", "c", "d", "e"} {
+		r.Out <- WsMessage{Data: []byte(m), Type: websocket.TextMessage}
+	}
+
+	r.Out <- WsMessage{Data: []byte("hb"), Type: websocket.TextMessage, Transient: true}
+
+	close(open)
+
+	for _, m := range []string{"c", "d", "e"} {
+		select {
+		case g := <-got:
+			assert.Equal(t, m, g)
+		case <-time.After(time.Second):
+			t.Fatal("buffered message not sent after reconnecting")
+		}
+	}
+
+	select {
+	case rc := <-r.Reconnected:
+		assert.Equal(t, Reconnection{Count: 1, Dropped: 1}, rc)
+	case <-time.After(time.Second):
+		t.Fatal("no reconnection event")
+	}
+
+	// messages go straight out again
+	r.Out <- WsMessage{Data: []byte("f"), Type: websocket.TextMessage}
+	assert.Equal(t, "f", <-got)
+}
+
 var upgrader = websocket.Upgrader{}
 
 func echo(w http.ResponseWriter, r *http.Request) {
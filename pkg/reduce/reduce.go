@@ -0,0 +1,234 @@
+// package reduce shrinks a result for a preview plot, by decimating a dense sweep
+// to the resolution of the display, and rounding its values to fewer significant
+// digits. Decimation keeps the smallest and largest magnitude in each group of
+// points, so that narrow features such as a resonance are not lost between them.
+package reduce
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strconv"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+// MaxDigits is the most significant digits worth asking for, since a float64 has no more
+const MaxDigits = 17
+
+// Check returns an error unless digits is zero, for full precision, or from 1 to
+// MaxDigits, and points is zero, for every point, or at least two, so that each
+// group of points keeps both its smallest and largest magnitude
+func Check(digits, points int) error {
+
+	if digits < 0 || digits > MaxDigits {
+		return fmt.Errorf("digits must be from 1 to %d, or zero for full precision, not %d", MaxDigits, digits)
+	}
+
+	if points < 0 || points == 1 {
+		return fmt.Errorf("display must be at least 2 points, or zero for every point, not %d", points)
+	}
+
+	return nil
+}
+
+// Indices returns the points of trace to keep so that there are no more than points
+// of them, in order, or nil if there are that few already. The trace is split into
+// points/2 groups, and from each, the points with the smallest and largest magnitude
+// are kept, of whichever selected S-parameter varies most over the group, or of any
+// of them if none is selected.
+func Indices(trace []pocket.SParam, sel pocket.SParamSelect, points int) []int {
+
+	n := len(trace)
+
+	if points < 2 || n <= points {
+		return nil
+	}
+
+	get := selected(sel)
+	groups := points / 2
+	idx := make([]int, 0, points)
+
+	for g := 0; g < groups; g++ {
+
+		start := g * n / groups
+		end := (g + 1) * n / groups
+
+		lo, hi, spread := start, start, -1.0
+
+		for _, f := range get {
+
+			l, h := start, start
+
+			for i := start; i < end; i++ {
+				if f(trace[i]) < f(trace[l]) {
+					l = i
+				}
+				if f(trace[i]) > f(trace[h]) {
+					h = i
+				}
+			}
+
+			if s := f(trace[h]) - f(trace[l]); s > spread {
+				lo, hi, spread = l, h, s
+			}
+		}
+
+		switch {
+		case lo == hi:
+			idx = append(idx, lo)
+		case lo < hi:
+			idx = append(idx, lo, hi)
+		default:
+			idx = append(idx, hi, lo)
+		}
+	}
+
+	return idx
+}
+
+// selected returns the magnitude of each selected S-parameter, or of all of them if none is
+func selected(sel pocket.SParamSelect) []func(pocket.SParam) float64 {
+
+	all := []struct {
+		on  bool
+		mag func(pocket.SParam) float64
+	}{
+		{sel.S11, func(s pocket.SParam) float64 { return mag(s.S11) }},
+		{sel.S12, func(s pocket.SParam) float64 { return mag(s.S12) }},
+		{sel.S21, func(s pocket.SParam) float64 { return mag(s.S21) }},
+		{sel.S22, func(s pocket.SParam) float64 { return mag(s.S22) }},
+	}
+
+	var get []func(pocket.SParam) float64
+
+	for _, a := range all {
+		if a.on {
+			get = append(get, a.mag)
+		}
+	}
+
+	if len(get) == 0 {
+		for _, a := range all {
+			get = append(get, a.mag)
+		}
+	}
+
+	return get
+}
+
+func mag(c pocket.Complex) float64 {
+	return cmplx.Abs(complex(c.Real, c.Imag))
+}
+
+// Pick returns a new slice of the elements of s at idx, or s itself if idx is nil
+// or s is not as long as the trace that idx was found from, e.g. because it is empty
+func Pick[T any](s []T, idx []int, n int) []T {
+
+	if idx == nil || len(s) != n {
+		return s
+	}
+
+	p := make([]T, len(idx))
+
+	for i, j := range idx {
+		p[i] = s[j]
+	}
+
+	return p
+}
+
+// Round returns x rounded to digits significant digits, or x itself if digits is zero
+func Round(x float64, digits int) float64 {
+
+	if digits <= 0 || x == 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+		return x
+	}
+
+	r, err := strconv.ParseFloat(strconv.FormatFloat(x, 'g', digits, 64), 64)
+
+	if err != nil {
+		return x
+	}
+
+	return r
+}
+
+// Floats returns a copy of s with each value rounded to digits significant digits
+func Floats(s []float64, digits int) []float64 {
+	return each(s, digits, func(v *float64, digits int) {
+		*v = Round(*v, digits)
+	})
+}
+
+// Trace returns a copy of trace with each value rounded to digits significant digits
+func Trace(trace []pocket.SParam, digits int) []pocket.SParam {
+	return each(trace, digits, func(s *pocket.SParam, digits int) {
+		for _, c := range []*pocket.Complex{&s.S11, &s.S12, &s.S21, &s.S22} {
+			c.Real = Round(c.Real, digits)
+			c.Imag = Round(c.Imag, digits)
+		}
+	})
+}
+
+// Formatted returns a copy of formatted with each value rounded to digits significant digits
+func Formatted(formatted []pocket.FormattedSParam, digits int) []pocket.FormattedSParam {
+	return each(formatted, digits, func(f *pocket.FormattedSParam, digits int) {
+		for _, v := range []**pocket.Value{&f.S11, &f.S12, &f.S21, &f.S22} {
+			if *v != nil {
+				*v = &pocket.Value{Mag: Round((*v).Mag, digits), Phase: Round((*v).Phase, digits)}
+			}
+		}
+	})
+}
+
+// Smith returns a copy of chart with each impedance rounded to digits significant digits
+func Smith(chart []pocket.SmithPoint, digits int) []pocket.SmithPoint {
+	return each(chart, digits, func(p *pocket.SmithPoint, digits int) {
+		for _, z := range []**pocket.Impedance{&p.S11, &p.S22} {
+			if *z != nil {
+				*z = &pocket.Impedance{R: Round((*z).R, digits), X: Round((*z).X, digits)}
+			}
+		}
+	})
+}
+
+// Bounds returns a copy of bounds with each value rounded to digits significant digits
+func Bounds(bounds []pocket.UncertainSParam, digits int) []pocket.UncertainSParam {
+	return each(bounds, digits, func(u *pocket.UncertainSParam, digits int) {
+		for _, b := range []*pocket.Bound{&u.S11, &u.S12, &u.S21, &u.S22} {
+			b.Mag = Round(b.Mag, digits)
+			b.DB = Round(b.DB, digits)
+			b.Phase = Round(b.Phase, digits)
+		}
+	})
+}
+
+// StdDev returns a copy of sd with each value rounded to digits significant digits
+func StdDev(sd []pocket.Deviation, digits int) []pocket.Deviation {
+	return each(sd, digits, func(d *pocket.Deviation, digits int) {
+		d.S11 = Round(d.S11, digits)
+		d.S12 = Round(d.S12, digits)
+		d.S21 = Round(d.S21, digits)
+		d.S22 = Round(d.S22, digits)
+	})
+}
+
+// each returns a copy of s with round applied to each element, so that a slice that
+// is shared, such as the last result kept for an, is not changed, or s itself if
+// digits is zero
+func each[T any](s []T, digits int, round func(*T, int)) []T {
+
+	if digits <= 0 || s == nil {
+		return s
+	}
+
+	c := make([]T, len(s))
+	copy(c, s)
+
+	for i := range c {
+		round(&c[i], digits)
+	}
+
+	return c
+}
@@ -0,0 +1,75 @@
+package reduce
+
+import (
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheck(t *testing.T) {
+	assert.NoError(t, Check(0, 0))
+	assert.NoError(t, Check(17, 2))
+	assert.Error(t, Check(18, 0))
+	assert.Error(t, Check(-1, 0))
+	assert.Error(t, Check(0, 1))
+	assert.Error(t, Check(0, -2))
+}
+
+func TestIndices(t *testing.T) {
+
+	trace := make([]pocket.SParam, 10)
+
+	for i := range trace {
+		trace[i].S21.Real = 0.5
+	}
+
+	trace[2].S21.Real = 0.9 // a peak
+	trace[3].S11.Real = 1   // not selected
+	trace[7].S21.Imag = -1  // a peak in magnitude, not in the real part
+
+	sel := pocket.SParamSelect{S21: true}
+
+	// the smallest and largest of each half, in order
+	assert.Equal(t, []int{0, 2, 5, 7}, Indices(trace, sel, 4))
+
+	// one point from a group that does not vary
+	assert.Equal(t, []int{0, 2, 3, 6, 7}, Indices(trace, sel, 7))
+
+	// of whichever varies most, of all four if none are selected
+	assert.Equal(t, []int{0, 3}, Indices(trace, pocket.SParamSelect{}, 2))
+
+	assert.Nil(t, Indices(trace, sel, 10))
+	assert.Nil(t, Indices(trace, sel, 0))
+}
+
+func TestPick(t *testing.T) {
+
+	s := []int{10, 11, 12, 13}
+
+	assert.Equal(t, []int{11, 13}, Pick(s, []int{1, 3}, 4))
+	assert.Equal(t, s, Pick(s, nil, 4))
+	assert.Nil(t, Pick([]int(nil), []int{1, 3}, 4))
+}
+
+func TestRound(t *testing.T) {
+
+	assert.Equal(t, 0.123, Round(0.123456, 3))
+	assert.Equal(t, -1.2e-9, Round(-1.23456e-9, 2))
+	assert.Equal(t, 12300.0, Round(12345, 3))
+	assert.Equal(t, 0.123456, Round(0.123456, 0))
+	assert.Equal(t, 0.0, Round(0, 3))
+
+	trace := []pocket.SParam{{Freq: 100, S11: pocket.Complex{Real: 0.123456, Imag: -0.987654}}}
+	rounded := Trace(trace, 2)
+
+	assert.Equal(t, pocket.SParam{Freq: 100, S11: pocket.Complex{Real: 0.12, Imag: -0.99}}, rounded[0])
+
+	// the original is not changed, since it may be shared
+	assert.Equal(t, 0.123456, trace[0].S11.Real)
+
+	f := []pocket.FormattedSParam{{S21: &pocket.Value{Mag: -3.0103, Phase: 45.678}}}
+	assert.Equal(t, pocket.Value{Mag: -3.01, Phase: 45.7}, *Formatted(f, 3)[0].S21)
+	assert.Equal(t, -3.0103, f[0].S21.Mag)
+	assert.Nil(t, Formatted(f, 3)[0].S11)
+}
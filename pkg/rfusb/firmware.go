@@ -0,0 +1,355 @@
+package rfusb
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.bug.st/serial"
+)
+
+// Flasher is a switch whose controller firmware can be replaced over its own link,
+// without anyone going to the rig
+type Flasher interface {
+	// Flash writes image to the controller and verifies it, talking to its
+	// bootloader at baud, then opens the switch again with the new firmware
+	Flash(ctx context.Context, image []byte, baud int) error
+}
+
+// PageSize is the flash page of the ATmega328P on the Arduino Uno and Nano
+const PageSize = 128
+
+// MaxImage is the largest image that fits below the optiboot bootloader
+const MaxImage = 32*1024 - 512
+
+// BootBaud is the speed of the optiboot bootloader on an Arduino Uno, and new Nanos.
+// Old Nanos use 57600.
+const BootBaud = 115200
+
+// SyncAttempts is how many times to try to get in sync with the bootloader, since
+// it takes a moment to start after the reset, and the first bytes may be lost
+const SyncAttempts = 10
+
+// AppStart is how long to wait for the new firmware to start after it is flashed
+const AppStart = 2 * time.Second
+
+// BootTimeout is the longest to wait for each reply from the bootloader
+const BootTimeout = 500 * time.Millisecond
+
+// STK500 version 1 commands and replies, as spoken by optiboot and avrdude -c arduino
+const (
+	stkOK            = 0x10
+	stkInSync        = 0x14
+	crcEOP           = 0x20
+	stkGetSync       = 0x30
+	stkEnterProgmode = 0x50
+	stkLeaveProgmode = 0x51
+	stkLoadAddress   = 0x55
+	stkProgPage      = 0x64
+	stkReadPage      = 0x74
+)
+
+// ParseHex returns the image in an Intel HEX file, such as the Arduino IDE exports,
+// from address zero, with any gaps filled with 0xff as in erased flash
+func ParseHex(s string) ([]byte, error) {
+
+	var image []byte
+
+	base := 0
+	ended := false
+
+	for n, line := range strings.Split(s, "\n") {
+
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		if ended {
+			return nil, fmt.Errorf("line %d is after the end of file record", n+1)
+		}
+
+		if !strings.HasPrefix(line, ":") {
+			return nil, fmt.Errorf("line %d does not start with a colon", n+1)
+		}
+
+		b, err := hex.DecodeString(line[1:])
+
+		if err != nil || len(b) < 5 || len(b) != int(b[0])+5 {
+			return nil, fmt.Errorf("line %d is not a record", n+1)
+		}
+
+		sum := byte(0)
+
+		for _, c := range b {
+			sum += c
+		}
+
+		if sum != 0 {
+			return nil, fmt.Errorf("line %d has the wrong checksum", n+1)
+		}
+
+		data := b[4 : len(b)-1]
+
+		switch b[3] {
+		case 0x00:
+			addr := base + int(b[1])<<8 + int(b[2])
+			if addr+len(data) > MaxImage {
+				return nil, fmt.Errorf("line %d is at 0x%x, which is beyond the %d bytes below the bootloader", n+1, addr, MaxImage)
+			}
+			for len(image) < addr+len(data) {
+				image = append(image, 0xff)
+			}
+			copy(image[addr:], data)
+		case 0x01:
+			ended = true
+		case 0x02:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %d is not a segment address", n+1)
+			}
+			base = (int(data[0])<<8 + int(data[1])) << 4
+		case 0x04:
+			if len(data) != 2 {
+				return nil, fmt.Errorf("line %d is not a linear address", n+1)
+			}
+			base = (int(data[0])<<8 + int(data[1])) << 16
+		case 0x03, 0x05:
+			// start address, which the bootloader does not need
+		default:
+			return nil, fmt.Errorf("line %d has unknown record type %d", n+1, b[3])
+		}
+	}
+
+	if !ended {
+		return nil, errors.New("there is no end of file record, so the file may be cut short")
+	}
+
+	if len(image) == 0 {
+		return nil, errors.New("there is no data in the file")
+	}
+
+	return image, nil
+}
+
+// Flash resets the arduino into its bootloader, by pulsing DTR as avrdude does, writes
+// image a page at a time over STK500, reads each page back to verify it, and then opens
+// the switch again with the new firmware, negotiating its protocol afresh. Only a switch
+// on a serial port can be flashed.
+func (r *RFUSB) Flash(ctx context.Context, image []byte, baud int) error {
+
+	if r.network != "" {
+		return fmt.Errorf("cannot flash a %s switch controller, only one on a usb serial port", r.network)
+	}
+
+	if r.device == "" {
+		return errors.New("the switch has not been opened, so its port is not known")
+	}
+
+	if baud <= 0 {
+		baud = BootBaud
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.sp != nil {
+		_ = r.sp.Close() // the bootloader is opened at its own speed
+		r.sp = nil
+	}
+
+	r.port = "unknown"
+
+	open := r.boot
+
+	if open == nil {
+		open = resetToBootloader
+	}
+
+	c, err := open(r.device, baud)
+
+	if err != nil {
+		return fmt.Errorf("could not reset the switch into its bootloader because %s", err.Error())
+	}
+
+	log.WithFields(log.Fields{"port": r.device, "baud": baud, "size": len(image)}).Warn("flashing switch firmware")
+
+	err = program(ctx, c, image)
+
+	c.Close()
+
+	if err != nil {
+		log.WithFields(log.Fields{"port": r.device, "error": err.Error()}).Error("could not flash switch firmware")
+		return err
+	}
+
+	log.WithField("port", r.device).Info("flashed switch firmware")
+
+	// give the new firmware time to start before talking to it
+	select {
+	case <-time.After(AppStart):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return r.Open(r.device, r.baud, r.timeout)
+}
+
+// resetToBootloader opens the serial port at the speed of the bootloader, and pulses
+// DTR to reset the arduino, which starts the bootloader for a moment
+func resetToBootloader(device string, baud int) (Conn, error) {
+
+	p, err := serial.Open(device, &serial.Mode{BaudRate: baud})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dtr := range []bool{false, true} {
+		if err := p.SetDTR(dtr); err != nil {
+			p.Close()
+			return nil, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	return p, nil
+}
+
+// program writes image to the flash through the bootloader on c, and verifies it
+func program(ctx context.Context, c Conn, image []byte) error {
+
+	if len(image) == 0 || len(image) > MaxImage {
+		return fmt.Errorf("image must be 1 to %d bytes, not %d", MaxImage, len(image))
+	}
+
+	err := c.SetReadTimeout(poll)
+
+	if err != nil {
+		return err
+	}
+
+	b := bootloader{ctx: ctx, c: c}
+
+	err = b.sync()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = b.command([]byte{stkEnterProgmode}, 0)
+
+	if err != nil {
+		return fmt.Errorf("bootloader did not enter programming mode because %s", err.Error())
+	}
+
+	// whole pages, as avrdude sends them
+	padded := append([]byte{}, image...)
+
+	for len(padded)%PageSize != 0 {
+		padded = append(padded, 0xff)
+	}
+
+	for addr := 0; addr < len(padded); addr += PageSize {
+
+		page := padded[addr : addr+PageSize]
+
+		err = b.load(addr)
+
+		if err == nil {
+			_, err = b.command(append([]byte{stkProgPage, PageSize >> 8, PageSize & 0xff, 'F'}, page...), 0)
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not write the page at 0x%04x because %s", addr, err.Error())
+		}
+	}
+
+	for addr := 0; addr < len(padded); addr += PageSize {
+
+		err = b.load(addr)
+
+		var got []byte
+
+		if err == nil {
+			got, err = b.command([]byte{stkReadPage, PageSize >> 8, PageSize & 0xff, 'F'}, PageSize)
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not read back the page at 0x%04x because %s", addr, err.Error())
+		}
+
+		if !bytes.Equal(got, padded[addr:addr+PageSize]) {
+			return fmt.Errorf("the page at 0x%04x did not verify, so the switch needs flashing again", addr)
+		}
+	}
+
+	_, err = b.command([]byte{stkLeaveProgmode}, 0)
+
+	return err
+}
+
+// bootloader speaks STK500 version 1 on c
+type bootloader struct {
+	ctx context.Context
+	c   Conn
+}
+
+// sync gets in step with the bootloader, which may still be starting
+func (b *bootloader) sync() error {
+
+	for i := 0; i < SyncAttempts; i++ {
+
+		_, err := b.command([]byte{stkGetSync}, 0)
+
+		if err == nil {
+			return nil
+		}
+
+		if e := b.ctx.Err(); e != nil {
+			return e
+		}
+
+		// discard the rest of any garbled reply, so the next one starts afresh
+		_, _ = io.Copy(io.Discard, &deadlineReader{ctx: b.ctx, sp: b.c, deadline: time.Now().Add(2 * poll)})
+	}
+
+	return fmt.Errorf("no reply from the bootloader after %d attempts, check the baud", SyncAttempts)
+}
+
+// load sets the byte address of the next page written or read, which is sent in words
+func (b *bootloader) load(addr int) error {
+	w := addr / 2
+	_, err := b.command([]byte{stkLoadAddress, byte(w), byte(w >> 8)}, 0)
+	return err
+}
+
+// command sends cmd and returns the n bytes of its reply, between in sync and ok
+func (b *bootloader) command(cmd []byte, n int) ([]byte, error) {
+
+	_, err := b.c.Write(append(cmd, crcEOP))
+
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, n+2)
+
+	_, err = io.ReadFull(&deadlineReader{ctx: b.ctx, sp: b.c, deadline: time.Now().Add(BootTimeout)}, reply)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if reply[0] != stkInSync || reply[n+1] != stkOK {
+		return nil, fmt.Errorf("bootloader replied 0x%02x...0x%02x rather than in sync and ok", reply[0], reply[n+1])
+	}
+
+	return reply[1 : n+1], nil
+}
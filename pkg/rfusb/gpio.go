@@ -0,0 +1,274 @@
+package rfusb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// GPIO drives the control lines of the rf switch directly from the Raspberry Pi
+// header, using the sysfs gpio interface, instead of via the arduino.
+type GPIO struct {
+	mu   *sync.Mutex
+	port string
+	// Root is the sysfs gpio directory, which can be changed for testing
+	Root  string
+	pins  []int
+	value []*os.File
+}
+
+func NewGPIO() *GPIO {
+	return &GPIO{
+		mu:   &sync.Mutex{},
+		port: "unknown",
+		Root: "/sys/class/gpio",
+	}
+}
+
+// ParsePins parses a comma separated list of BCM gpio numbers for the control lines
+// P1 A,B,C then P2 A,B,C, optionally followed by the power pins of the two switches,
+// e.g. 8,9,10,4,5,6,3,2 (the same lines as the arduino uses)
+func ParsePins(s string) ([]int, error) {
+
+	var pins []int
+
+	for _, item := range strings.Split(s, ",") {
+
+		n, err := strconv.Atoi(strings.TrimSpace(item))
+
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("gpio pin %q is not a BCM gpio number", item)
+		}
+
+		pins = append(pins, n)
+	}
+
+	if len(pins) != 6 && len(pins) != 8 {
+		return nil, fmt.Errorf("need 6 gpio pins (P1 A,B,C,P2 A,B,C), or 8 with the power pins, not %d", len(pins))
+	}
+
+	return pins, nil
+}
+
+func (g *GPIO) Get() string {
+	return g.port
+}
+
+// Open exports the pins listed in port (see ParsePins) and makes them outputs.
+// The power pins, if any, are set high. baud and timeout are not used.
+func (g *GPIO) Open(port string, baud int, timeout time.Duration) error {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	pins, err := ParsePins(port)
+
+	if err != nil {
+		return err
+	}
+
+	g.close()
+
+	for _, pin := range pins {
+
+		f, err := g.export(pin)
+
+		if err != nil {
+			g.close()
+			log.WithFields(log.Fields{"pins": port, "error": err.Error()}).Errorf("failed to open gpio")
+			return err
+		}
+
+		g.value = append(g.value, f)
+	}
+
+	g.pins = pins
+
+	for _, f := range g.value[6:] {
+		if err := writePin(f, true); err != nil {
+			g.close()
+			return fmt.Errorf("could not power the switch because %s", err.Error())
+		}
+	}
+
+	log.WithField("pins", port).Infof("opened gpio")
+
+	return nil
+}
+
+// export makes the pin an output and returns its value file
+func (g *GPIO) export(pin int) (*os.File, error) {
+
+	dir := filepath.Join(g.Root, fmt.Sprintf("gpio%d", pin))
+
+	if _, err := os.Stat(dir); err != nil {
+
+		err = os.WriteFile(filepath.Join(g.Root, "export"), []byte(strconv.Itoa(pin)), 0)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not export gpio %d because %s", pin, err.Error())
+		}
+	}
+
+	var err error
+
+	// udev takes a moment to give us permission on a newly exported pin
+	for i := 0; i < 20; i++ {
+
+		err = os.WriteFile(filepath.Join(dir, "direction"), []byte("out"), 0)
+
+		if err == nil {
+			break
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("could not make gpio %d an output because %s", pin, err.Error())
+	}
+
+	return os.OpenFile(filepath.Join(dir, "value"), os.O_RDWR, 0)
+}
+
+// writePin sets the level of a pin
+func writePin(f *os.File, high bool) error {
+
+	v := []byte("0")
+
+	if high {
+		v = []byte("1")
+	}
+
+	_, err := f.WriteAt(v, 0)
+
+	return err
+}
+
+// readPin gets the level of a pin
+func readPin(f *os.File) (bool, error) {
+
+	v := make([]byte, 1)
+
+	_, err := f.ReadAt(v, 0)
+
+	return v[0] == '1', err
+}
+
+func (g *GPIO) Close() error {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.close()
+
+	return nil
+}
+
+// close releases the value files, leaving the pins as they are, so the switch stays in position
+// caller must hold the lock
+func (g *GPIO) close() {
+
+	for _, f := range g.value {
+		_ = f.Close() //ignore error, closing anyway
+	}
+
+	g.value = nil
+}
+
+func (g *GPIO) SetPort(ctx context.Context, port string) error {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(g.value) < 6 {
+		return errors.New("gpio is not open")
+	}
+
+	l, err := Lines(port)
+
+	if err != nil {
+		return err
+	}
+
+	for i, level := range l {
+		if err := writePin(g.value[i], level); err != nil {
+			g.port = "unknown"
+			return fmt.Errorf("could not set gpio %d because %s", g.pins[i], err.Error())
+		}
+	}
+
+	g.port = strings.ToLower(port)
+
+	return nil
+}
+
+// QueryPort reads back the levels of the control lines
+func (g *GPIO) QueryPort(ctx context.Context) (string, error) {
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if len(g.value) < 6 {
+		return "", errors.New("gpio is not open")
+	}
+
+	var l [6]bool
+
+	for i := range l {
+
+		level, err := readPin(g.value[i])
+
+		if err != nil {
+			return "", fmt.Errorf("could not read gpio %d because %s", g.pins[i], err.Error())
+		}
+
+		l[i] = level
+	}
+
+	return Position(l), nil
+}
+
+func (g *GPIO) SetShort() error {
+	return g.SetPort(context.Background(), "short")
+}
+
+func (g *GPIO) SetOpen() error {
+	return g.SetPort(context.Background(), "open")
+}
+
+func (g *GPIO) SetLoad() error {
+	return g.SetPort(context.Background(), "load")
+}
+
+func (g *GPIO) SetThru() error {
+	return g.SetPort(context.Background(), "thru")
+}
+func (g *GPIO) SetDUT1() error {
+	return g.SetPort(context.Background(), "dut1")
+}
+func (g *GPIO) SetDUT2() error {
+	return g.SetPort(context.Background(), "dut2")
+}
+func (g *GPIO) SetDUT3() error {
+	return g.SetPort(context.Background(), "dut3")
+}
+func (g *GPIO) SetDUT4() error {
+	return g.SetPort(context.Background(), "dut4")
+}
@@ -0,0 +1,221 @@
+package rfusb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// i2cSlave is the ioctl that sets the address of the device on the bus, from linux/i2c-dev.h
+const i2cSlave = 0x0703
+
+// I2C drives the control lines of the rf switch through a PCF8574 style I2C
+// port expander. It has no registers: the byte written sets the outputs, and
+// the byte read back gives their levels. Bits 0-2 are P1 A,B,C, bits 3-5 are
+// P2 A,B,C, and bits 6 and 7 power the two switches.
+type I2C struct {
+	mu   *sync.Mutex
+	port string
+	bus  io.ReadWriteCloser
+}
+
+func NewI2C() *I2C {
+	return &I2C{
+		mu:   &sync.Mutex{},
+		port: "unknown",
+	}
+}
+
+// ParseI2C parses the bus device and address of the port expander, e.g. /dev/i2c-1@0x20
+func ParseI2C(s string) (string, int, error) {
+
+	dev, a, ok := strings.Cut(s, "@")
+
+	if !ok || dev == "" {
+		return "", 0, fmt.Errorf("i2c port must be bus@address, e.g. /dev/i2c-1@0x20, not %s", s)
+	}
+
+	addr, err := strconv.ParseInt(a, 0, 0)
+
+	if err != nil || addr < 0x03 || addr > 0x77 {
+		return "", 0, fmt.Errorf("i2c address must be 0x03 to 0x77, not %s", a)
+	}
+
+	return dev, int(addr), nil
+}
+
+// Encode returns the byte to write to the port expander for the control lines, with the switches powered
+func Encode(l [6]bool) byte {
+
+	b := byte(0xc0)
+
+	for i, level := range l {
+		if level {
+			b |= 1 << i
+		}
+	}
+
+	return b
+}
+
+// Decode returns the control lines from the byte read from the port expander
+func Decode(b byte) [6]bool {
+
+	var l [6]bool
+
+	for i := range l {
+		l[i] = b&(1<<i) != 0
+	}
+
+	return l
+}
+
+func (c *I2C) Get() string {
+	return c.port
+}
+
+// Open opens the bus and addresses the port expander given by port (see ParseI2C).
+// baud and timeout are not used.
+func (c *I2C) Open(port string, baud int, timeout time.Duration) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	dev, addr, err := ParseI2C(port)
+
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(dev, os.O_RDWR, 0)
+
+	if err != nil {
+		log.WithFields(log.Fields{"port": port, "error": err.Error()}).Errorf("failed to open i2c bus")
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), i2cSlave, uintptr(addr))
+
+	if errno != 0 {
+		f.Close()
+		return fmt.Errorf("could not address i2c device %#x because %s", addr, errno.Error())
+	}
+
+	if c.bus != nil {
+		_ = c.bus.Close() //ignore error, replacing it anyway
+	}
+
+	c.bus = f
+
+	log.WithField("port", port).Infof("opened i2c port expander")
+
+	return nil
+}
+
+func (c *I2C) Close() error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.bus == nil {
+		return nil
+	}
+
+	err := c.bus.Close()
+	c.bus = nil
+
+	return err
+}
+
+func (c *I2C) SetPort(ctx context.Context, port string) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if c.bus == nil {
+		return errors.New("i2c is not open")
+	}
+
+	l, err := Lines(port)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = c.bus.Write([]byte{Encode(l)})
+
+	if err != nil {
+		c.port = "unknown"
+		return fmt.Errorf("could not write to i2c port expander because %s", err.Error())
+	}
+
+	c.port = strings.ToLower(port)
+
+	return nil
+}
+
+// QueryPort reads back the levels of the control lines
+func (c *I2C) QueryPort(ctx context.Context) (string, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	if c.bus == nil {
+		return "", errors.New("i2c is not open")
+	}
+
+	b := make([]byte, 1)
+
+	_, err := c.bus.Read(b)
+
+	if err != nil {
+		return "", fmt.Errorf("could not read from i2c port expander because %s", err.Error())
+	}
+
+	return Position(Decode(b[0])), nil
+}
+
+func (c *I2C) SetShort() error {
+	return c.SetPort(context.Background(), "short")
+}
+
+func (c *I2C) SetOpen() error {
+	return c.SetPort(context.Background(), "open")
+}
+
+func (c *I2C) SetLoad() error {
+	return c.SetPort(context.Background(), "load")
+}
+
+func (c *I2C) SetThru() error {
+	return c.SetPort(context.Background(), "thru")
+}
+func (c *I2C) SetDUT1() error {
+	return c.SetPort(context.Background(), "dut1")
+}
+func (c *I2C) SetDUT2() error {
+	return c.SetPort(context.Background(), "dut2")
+}
+func (c *I2C) SetDUT3() error {
+	return c.SetPort(context.Background(), "dut3")
+}
+func (c *I2C) SetDUT4() error {
+	return c.SetPort(context.Background(), "dut4")
+}
@@ -0,0 +1,103 @@
+package rfusb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// KeepAlive is how often a tcp connection to a switch controller is probed,
+// so that a controller that goes away is noticed even when the switch is idle
+const KeepAlive = 15 * time.Second
+
+// NewNetwork returns a switch that talks to a networked switch controller over
+// tcp or udp, using the same JSON messages as the arduino (one per line, or per
+// datagram). Open it with the host:port of the controller. If the connection is
+// lost, it is made again on the next request.
+func NewNetwork(network string) *RFUSB {
+	return &RFUSB{
+		mu:      &sync.Mutex{},
+		port:    "unknown",
+		network: network,
+	}
+}
+
+// netConn makes a network connection behave like a serial port, so a read that
+// times out returns no data rather than an error
+type netConn struct {
+	conn    net.Conn
+	r       *bufio.Reader // holds the rest of a udp datagram that is longer than the read
+	timeout time.Duration
+	write   time.Duration
+}
+
+func (n *netConn) SetReadTimeout(t time.Duration) error {
+	n.timeout = t
+	return nil
+}
+
+func (n *netConn) Read(p []byte) (int, error) {
+
+	err := n.conn.SetReadDeadline(time.Now().Add(n.timeout))
+
+	if err != nil {
+		return 0, err
+	}
+
+	c, err := n.r.Read(p)
+
+	var ne net.Error
+
+	if errors.As(err, &ne) && ne.Timeout() {
+		return c, nil
+	}
+
+	return c, err
+}
+
+func (n *netConn) Write(p []byte) (int, error) {
+
+	err := n.conn.SetWriteDeadline(time.Now().Add(n.write))
+
+	if err != nil {
+		return 0, err
+	}
+
+	return n.conn.Write(p)
+}
+
+func (n *netConn) Close() error {
+	return n.conn.Close()
+}
+
+// dial connects to the switch controller at r.device
+func (r *RFUSB) dial() error {
+
+	d := net.Dialer{
+		Timeout:   r.timeout,
+		KeepAlive: KeepAlive,
+	}
+
+	conn, err := d.Dial(r.network, r.device)
+
+	if err != nil {
+		log.WithFields(log.Fields{"network": r.network, "addr": r.device, "error": err.Error()}).Errorf("failed to connect to switch controller")
+		return fmt.Errorf("could not connect to switch controller %s because %s", r.device, err.Error())
+	}
+
+	r.sp = &netConn{
+		conn:    conn,
+		r:       bufio.NewReaderSize(conn, 4096),
+		timeout: r.timeout,
+		write:   r.timeout,
+	}
+
+	log.WithFields(log.Fields{"network": r.network, "addr": r.device}).Infof("connected to switch controller")
+
+	return nil
+}
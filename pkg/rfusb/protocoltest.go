@@ -0,0 +1,939 @@
+package rfusb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Toggles is how many times ProtocolTest moves the switch back and forth, as fast as
+// it will go, unless it is told otherwise
+const Toggles = 20
+
+// MaxToggles limits the moves made by ProtocolTest, since each wears the switch
+const MaxToggles = 500
+
+// Quiet is how long ProtocolTest listens for anything else from the controller, after
+// a reply, or after a message that should not get one
+const Quiet = 200 * time.Millisecond
+
+// positions are the positions of the switch, in the order that ProtocolTest sets them
+var positions = []string{"short", "open", "load", "thru", "dut1", "dut2", "dut3", "dut4"}
+
+// ProtocolTester is a switch whose controller can be put through ProtocolTest, e.g. to
+// validate a new build of its firmware
+type ProtocolTester interface {
+	ProtocolTest(ctx context.Context, toggles int) (Conformance, error)
+}
+
+// Check is the outcome of one of the checks made by ProtocolTest
+type Check struct {
+	Name   string
+	Passed bool
+	Detail string // what the controller did, e.g. why it failed
+	Took   time.Duration
+}
+
+// Conformance is the report of ProtocolTest
+type Conformance struct {
+	Protocol int // the version the controller reported, 1 if it did not reply
+	Checks   []Check
+	Moves    map[string]int // how many times the switch moved to each position, e.g. to count its wear
+	Port     string         // where the switch was left, unknown if it did not say
+	Took     time.Duration
+}
+
+// Passed returns true if every check passed
+func (c Conformance) Passed() bool {
+	return len(c.Failed()) == 0
+}
+
+// Failed returns the names of the checks that failed
+func (c Conformance) Failed() []string {
+
+	var failed []string
+
+	for _, check := range c.Checks {
+		if !check.Passed {
+			failed = append(failed, check.Name)
+		}
+	}
+
+	return failed
+}
+
+// ProtocolTest checks that the switch controller on c speaks the protocol as this package
+// expects, as it is given each command, and edge cases that a good controller survives:
+// being moved back and forth as fast as it will go, two commands at once, malformed,
+// corrupted and unknown messages, and a message that is never finished. Each reply is
+// expected within timeout, the read timeout of the switch. The switch is moved toggles
+// times back and forth, Toggles if it is not positive, and is left where it started, if
+// that is known. A check that fails is reported in the Conformance, not as an error,
+// which is only returned if the controller is lost, or ctx is done, part way through.
+func ProtocolTest(ctx context.Context, c Conn, timeout time.Duration, toggles int) (Conformance, error) {
+	return probe(ctx, c, timeout, toggles, nil)
+}
+
+// probe is ProtocolTest, recording the traffic to and from the controller in traffic,
+// if it is not nil
+func probe(ctx context.Context, c Conn, timeout time.Duration, toggles int, traffic *Traffic) (Conformance, error) {
+
+	if toggles <= 0 {
+		toggles = Toggles
+	}
+
+	if toggles > MaxToggles {
+		toggles = MaxToggles
+	}
+
+	start := time.Now()
+
+	p := &prober{
+		ctx:     ctx,
+		c:       c,
+		timeout: timeout,
+		traffic: traffic,
+		moves:   make(map[string]int),
+	}
+
+	report := func() Conformance {
+
+		port := p.at
+
+		if port == "" {
+			port = "unknown"
+		}
+
+		return Conformance{
+			Protocol: p.protocol,
+			Checks:   p.checks,
+			Moves:    p.moves,
+			Port:     port,
+			Took:     time.Since(start),
+		}
+	}
+
+	err := c.SetReadTimeout(poll)
+
+	if err != nil {
+		return report(), fmt.Errorf("setting read timeout failed because %s", err.Error())
+	}
+
+	steps := []step{
+		{"version", p.version},
+		{"query", p.query},
+		{"nothing unasked", p.quiet},
+	}
+
+	for _, pos := range positions {
+		pos := pos
+		steps = append(steps, step{"set " + pos, func() (string, bool, error) { return p.set(pos) }})
+	}
+
+	steps = append(steps,
+		step{"rapid toggling", func() (string, bool, error) { return p.toggle(toggles) }},
+		step{"back to back", p.backToBack},
+	)
+
+	for _, in := range injections {
+		in := in
+		steps = append(steps, step{in.name, func() (string, bool, error) { return p.inject(in.line, in.want) }})
+	}
+
+	steps = append(steps,
+		step{"bad checksum", p.badChecksum},
+		step{"unfinished message", p.unfinished},
+		step{"slowest reply", p.slowestReply},
+	)
+
+	for _, s := range steps {
+		if err := p.check(s.name, s.fn); err != nil {
+			return report(), err
+		}
+	}
+
+	// leave it where it started
+	if p.start != "" && p.at != p.start {
+
+		if err := p.drain(); err != nil {
+			return report(), err
+		}
+
+		r, err := p.request(Command{Set: "port", To: p.start})
+
+		if err != nil {
+			return report(), err
+		}
+
+		if p.wrong(r, p.start) == "" {
+			p.moved(p.start)
+		} else {
+			p.at = ""
+		}
+	}
+
+	return report(), nil
+}
+
+// commandSize is the longest command the firmware reads at once, beyond which the rest
+// of the line is read as another command
+const commandSize = 127
+
+// step is a check made by ProtocolTest, which returns what the controller did, and
+// whether that passed
+type step struct {
+	name string
+	fn   func() (string, bool, error)
+}
+
+// injections are messages that a controller should survive, and what kind of error a
+// version 2 controller should report for each, if any
+var injections = []struct {
+	name string
+	line []byte
+	want string
+}{
+	{"malformed json", []byte(`{"set":"port","to":` + "\n"), "json"},
+	{"binary noise", []byte{0x00, 0xff, 0x1b, 0x80, 0x7f, 0xfe, '\n'}, "json"},
+	{"overlong line", append(bytes.Repeat([]byte("x"), 3*commandSize), '\n'), "json"},
+	{"unknown position", []byte(`{"set":"port","to":"dut9"}` + "\n"), ""},
+	{"unknown request", []byte(`{"get":"nothing"}` + "\n"), ""},
+}
+
+// prober talks to the controller for ProtocolTest
+type prober struct {
+	ctx      context.Context
+	c        Conn
+	timeout  time.Duration
+	traffic  *Traffic
+	protocol int
+	seq      int
+	buf      []byte         // received, but not yet a whole line
+	at       string         // where the switch last reported it was, empty if not known
+	start    string         // where the switch was at the start, empty if not known
+	slowest  time.Duration  // of the replies so far
+	moves    map[string]int // to each position
+	checks   []Check
+}
+
+// reply is the answer to a request
+type reply struct {
+	report Report
+	other  []string // lines received before the report
+	took   time.Duration
+	ok     bool // false if there was no report before the timeout
+}
+
+// check drains anything left over from the last check, then runs fn, and notes whether
+// it passed, and what it found. The error from fn is only for a lost controller, or ctx
+// being done, which stops the test.
+func (p *prober) check(name string, fn func() (string, bool, error)) error {
+
+	if err := p.drain(); err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	detail, passed, err := fn()
+
+	if err != nil {
+		return err
+	}
+
+	p.checks = append(p.checks, Check{
+		Name:   name,
+		Passed: passed,
+		Detail: detail,
+		Took:   time.Since(start),
+	})
+
+	return nil
+}
+
+// drain discards anything the controller is still sending
+func (p *prober) drain() error {
+	_, err := p.listen(poll, nil)
+	p.buf = nil
+	return err
+}
+
+// listen returns the lines received from the controller within wait, stopping at the
+// first that done returns true for, if done is not nil
+func (p *prober) listen(wait time.Duration, done func(line string) bool) ([]string, error) {
+
+	var lines []string
+
+	deadline := time.Now().Add(wait)
+
+	b := make([]byte, 128)
+
+	for {
+
+		for {
+
+			i := bytes.IndexByte(p.buf, '\n')
+
+			if i < 0 && len(p.buf) <= MaxMessage {
+				break
+			}
+
+			rest := p.buf[:0]
+
+			if i < 0 {
+				i = len(p.buf) // too long for a line, so take it as one
+			} else {
+				rest = p.buf[i+1:]
+			}
+
+			line := string(bytes.TrimSpace(p.buf[:i]))
+			p.buf = rest
+
+			if line == "" {
+				continue
+			}
+
+			lines = append(lines, line)
+
+			if done != nil && done(line) {
+				return lines, nil
+			}
+		}
+
+		if err := p.ctx.Err(); err != nil {
+			return lines, err
+		}
+
+		if !time.Now().Before(deadline) {
+			return lines, nil
+		}
+
+		n, err := p.c.Read(b)
+
+		if n > 0 {
+			p.traffic.Record(Received, b[:n])
+			p.buf = append(p.buf, b[:n]...)
+		}
+
+		if err != nil {
+			return lines, err
+		}
+	}
+}
+
+// write sends b to the controller
+func (p *prober) write(b []byte) error {
+
+	n, err := p.c.Write(b)
+
+	if n > 0 {
+		p.traffic.Record(Sent, b[:n])
+	}
+
+	if err == nil && n < len(b) {
+		err = errors.New("did not finish writing message")
+	}
+
+	return err
+}
+
+// message returns the line for a command or query, signed if the controller speaks
+// version 2, and its sequence number
+func (p *prober) message(request interface{}) ([]byte, int) {
+
+	seq := 0
+
+	if p.protocol >= 2 {
+
+		p.seq++
+		seq = p.seq
+
+		switch v := request.(type) {
+		case Command:
+			request = v.Sign(seq)
+		case Query:
+			request = v.Sign(seq)
+		}
+	}
+
+	b, _ := json.Marshal(request) // cannot fail for a Command or Query
+
+	return append(b, '\n'), seq
+}
+
+// request sends a command or query, and returns the port report that answers it
+func (p *prober) request(request interface{}) (reply, error) {
+
+	var r reply
+
+	msg, seq := p.message(request)
+
+	start := time.Now()
+
+	if err := p.write(msg); err != nil {
+		return r, err
+	}
+
+	lines, err := p.listen(p.timeout, func(line string) bool {
+		rep, ok := parse(line)
+		r.ok = ok && strings.EqualFold(rep.Report, "port") && rep.Seq == seq
+		r.report = rep
+		return r.ok
+	})
+
+	r.took = time.Since(start)
+
+	if err != nil {
+		return r, err
+	}
+
+	if !r.ok {
+		r.other = lines
+		return r, nil
+	}
+
+	r.other = lines[:len(lines)-1]
+
+	if r.took > p.slowest {
+		p.slowest = r.took
+	}
+
+	return r, nil
+}
+
+// parse returns the report in line, and false if it is not one
+func parse(line string) (Report, bool) {
+
+	var rep Report
+
+	err := json.Unmarshal([]byte(line), &rep)
+
+	return rep, err == nil && rep.Report != ""
+}
+
+// valid returns true unless the controller speaks version 2, and the checksum is wrong
+func (p *prober) valid(rep Report) bool {
+	return p.protocol < 2 || rep.Valid()
+}
+
+// moved notes that the switch reported moving to position
+func (p *prober) moved(position string) {
+
+	if p.at != position {
+		p.moves[position]++
+	}
+
+	p.at = position
+}
+
+// wrong describes what was wrong with the reply to a move to position, or returns empty
+// if nothing was
+func (p *prober) wrong(r reply, position string) string {
+
+	switch {
+	case !r.ok:
+		return fmt.Sprintf("there was no report within the timeout of %s", p.timeout)
+	case !p.valid(r.report):
+		return fmt.Sprintf("the checksum of the report of %s was wrong", r.report.Is)
+	case !strings.EqualFold(r.report.Is, position):
+		return fmt.Sprintf("it reported %s instead of %s", r.report.Is, position)
+	}
+
+	return ""
+}
+
+// where asks the controller where the switch is, and returns it, or why it could not tell
+func (p *prober) where() (string, string, error) {
+
+	r, err := p.request(Query{Get: "port"})
+
+	if err != nil {
+		return "", "", err
+	}
+
+	if !r.ok {
+		return "", fmt.Sprintf("there was no answer to a query within the timeout of %s", p.timeout), nil
+	}
+
+	if !p.valid(r.report) {
+		return "", "the checksum of the answer to a query was wrong", nil
+	}
+
+	return strings.ToLower(r.report.Is), "", nil
+}
+
+// version asks which version of the protocol the controller speaks, to which version 1
+// firmware does not reply
+func (p *prober) version() (string, bool, error) {
+
+	msg, _ := json.Marshal(Query{Get: "version"})
+
+	if err := p.write(append(msg, '\n')); err != nil {
+		return "", false, err
+	}
+
+	wait := Negotiate
+
+	if p.timeout < wait {
+		wait = p.timeout
+	}
+
+	var rep Report
+
+	_, err := p.listen(wait, func(line string) bool {
+		r, ok := parse(line)
+		if ok && strings.EqualFold(r.Report, "version") {
+			rep = r
+			return true
+		}
+		return false
+	})
+
+	if err != nil {
+		return "", false, err
+	}
+
+	p.protocol = 1
+
+	if rep.Report == "" {
+		return "no reply, so it speaks version 1", true, nil
+	}
+
+	v, err := strconv.Atoi(rep.Is)
+
+	if err != nil || v < 1 {
+		return fmt.Sprintf("it reported version %q, which is not a version", rep.Is), false, nil
+	}
+
+	if v >= 2 {
+		p.protocol = 2 // the latest we speak
+	}
+
+	return fmt.Sprintf("it reported version %d", v), true, nil
+}
+
+// query asks where the switch is, which should be one of its positions
+func (p *prober) query() (string, bool, error) {
+
+	r, err := p.request(Query{Get: "port"})
+
+	if err != nil {
+		return "", false, err
+	}
+
+	if !r.ok {
+		return fmt.Sprintf("there was no report within the timeout of %s", p.timeout), false, nil
+	}
+
+	if !p.valid(r.report) {
+		return "the checksum of the report was wrong", false, nil
+	}
+
+	is := strings.ToLower(r.report.Is)
+
+	if _, ok := Channels[is]; !ok {
+		return fmt.Sprintf("it reported %s, which is not a position", r.report.Is), false, nil
+	}
+
+	p.at = is
+	p.start = is
+
+	return fmt.Sprintf("it reported %s in %s", is, r.took.Round(time.Millisecond)), true, nil
+}
+
+// quiet checks that the controller sends nothing but the reply, since anything else,
+// e.g. from a build with debug messages turned on, slows every request
+func (p *prober) quiet() (string, bool, error) {
+
+	r, err := p.request(Query{Get: "port"})
+
+	if err != nil {
+		return "", false, err
+	}
+
+	after, err := p.listen(Quiet, nil)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	other := append(r.other, after...)
+
+	if !r.ok {
+		return fmt.Sprintf("there was no report within the timeout of %s", p.timeout), false, nil
+	}
+
+	if len(other) > 0 {
+		return fmt.Sprintf("it sent %d lines that were not asked for, e.g. %q, so check that debug and trace are false", len(other), other[0]), false, nil
+	}
+
+	return "it sent only the report", true, nil
+}
+
+// set moves the switch to position, and checks that it says so when asked
+func (p *prober) set(position string) (string, bool, error) {
+
+	r, err := p.request(Command{Set: "port", To: position})
+
+	if err != nil {
+		return "", false, err
+	}
+
+	if w := p.wrong(r, position); w != "" {
+		return w, false, nil
+	}
+
+	p.moved(position)
+
+	is, w, err := p.where()
+
+	if err != nil || w != "" {
+		return w, false, err
+	}
+
+	if is != position {
+		return fmt.Sprintf("it reported %s, then %s when asked", position, is), false, nil
+	}
+
+	return fmt.Sprintf("it moved in %s", r.took.Round(time.Millisecond)), true, nil
+}
+
+// toggle moves the switch back and forth n times, sending each command as soon as the
+// last is answered
+func (p *prober) toggle(n int) (string, bool, error) {
+
+	a, b := "short", "open"
+
+	if p.at == a {
+		a, b = b, a
+	}
+
+	var total, slowest time.Duration
+	failed, first := 0, ""
+
+	for i := 0; i < n; i++ {
+
+		to := a
+
+		if i%2 == 1 {
+			to = b
+		}
+
+		r, err := p.request(Command{Set: "port", To: to})
+
+		if err != nil {
+			return "", false, err
+		}
+
+		if w := p.wrong(r, to); w != "" {
+
+			if failed == 0 {
+				first = fmt.Sprintf("move %d failed because %s", i+1, w)
+			}
+
+			failed++
+			p.at = ""
+
+			continue
+		}
+
+		p.moved(to)
+
+		total += r.took
+
+		if r.took > slowest {
+			slowest = r.took
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Sprintf("%d of %d moves failed, and %s", failed, n, first), false, nil
+	}
+
+	return fmt.Sprintf("%d moves, taking %s on average and %s at most", n, (total / time.Duration(n)).Round(time.Millisecond), slowest.Round(time.Millisecond)), true, nil
+}
+
+// backToBack sends two commands at once, which a controller that only reads one line at
+// a time, or whose serial buffer is too small, does not answer both of
+func (p *prober) backToBack() (string, bool, error) {
+
+	first, second := "load", "thru"
+
+	m1, s1 := p.message(Command{Set: "port", To: first})
+	m2, s2 := p.message(Command{Set: "port", To: second})
+
+	if err := p.write(append(m1, m2...)); err != nil {
+		return "", false, err
+	}
+
+	var reports []Report
+
+	_, err := p.listen(2*p.timeout, func(line string) bool {
+		if r, ok := parse(line); ok && strings.EqualFold(r.Report, "port") {
+			reports = append(reports, r)
+		}
+		return len(reports) == 2
+	})
+
+	if err != nil {
+		return "", false, err
+	}
+
+	p.at = ""
+
+	var got []string
+
+	for _, r := range reports {
+		got = append(got, r.Is)
+	}
+
+	if len(reports) < 2 {
+		return fmt.Sprintf("it answered %d of two commands sent together, with %v, so one was lost", len(reports), got), false, nil
+	}
+
+	ok := strings.EqualFold(reports[0].Is, first) && strings.EqualFold(reports[1].Is, second)
+	ok = ok && reports[0].Seq == s1 && reports[1].Seq == s2 && p.valid(reports[0]) && p.valid(reports[1])
+
+	if !ok {
+		return fmt.Sprintf("it answered two commands sent together with %v, not [%s %s] in turn", got, first, second), false, nil
+	}
+
+	p.moved(first)
+	p.moved(second)
+
+	is, w, err := p.where()
+
+	if err != nil || w != "" {
+		return w, false, err
+	}
+
+	if is != second {
+		return fmt.Sprintf("it answered both, but then reported %s when asked", is), false, nil
+	}
+
+	return "it answered both in turn", true, nil
+}
+
+// inject sends line, which the controller should survive, without moving, and answer the
+// next query. A version 2 controller should say that it could not read it, unless want is
+// empty.
+func (p *prober) inject(line []byte, want string) (string, bool, error) {
+
+	before := p.at
+
+	if err := p.write(line); err != nil {
+		return "", false, err
+	}
+
+	lines, err := p.listen(Quiet, nil)
+
+	if err != nil {
+		return "", false, err
+	}
+
+	reported := false
+
+	for _, l := range lines {
+
+		r, ok := parse(l)
+
+		if !ok {
+			continue
+		}
+
+		if strings.EqualFold(r.Report, "port") {
+			return fmt.Sprintf("it answered with %s, as if it were a request", l), false, nil
+		}
+
+		if strings.EqualFold(r.Report, "error") && strings.EqualFold(r.Is, want) {
+			reported = true
+		}
+	}
+
+	if p.protocol >= 2 && want != "" && !reported {
+		return fmt.Sprintf("it did not report a %s error", want), false, nil
+	}
+
+	is, w, err := p.where()
+
+	if err != nil || w != "" {
+		return w, false, err
+	}
+
+	if before != "" && is != before {
+		p.at = is
+		return fmt.Sprintf("it moved from %s to %s", before, is), false, nil
+	}
+
+	if len(lines) == 0 {
+		return "it ignored it, and answered the next query", true, nil
+	}
+
+	return fmt.Sprintf("it replied %s, and answered the next query", lines[0]), true, nil
+}
+
+// badChecksum sends a command with the wrong checksum, which a version 2 controller
+// should report, and not act on. Version 1 controllers do not check, so pass.
+func (p *prober) badChecksum() (string, bool, error) {
+
+	if p.protocol < 2 {
+		return "skipped, since version 1 has no checksums", true, nil
+	}
+
+	to := "dut1"
+
+	if p.at == to {
+		to = "dut2"
+	}
+
+	p.seq++
+	c := Command{Set: "port", To: to}.Sign(p.seq)
+	c.CRC = Checksum(c.Set, c.To, strconv.Itoa(c.Seq+1))
+
+	b, _ := json.Marshal(c)
+
+	return p.inject(append(b, '\n'), "crc")
+}
+
+// unfinished sends part of a command without the end of the line, which the controller
+// should give up on, rather than taking the next command as the rest of it
+func (p *prober) unfinished() (string, bool, error) {
+
+	before := p.at
+
+	if err := p.write([]byte(`{"set":"port","to":"`)); err != nil {
+		return "", false, err
+	}
+
+	if _, err := p.listen(Quiet, nil); err != nil {
+		return "", false, err
+	}
+
+	is, w, err := p.where()
+
+	if err != nil {
+		return "", false, err
+	}
+
+	if w != "" {
+		return "it did not give up on an unfinished message, so " + w, false, nil
+	}
+
+	if before != "" && is != before {
+		p.at = is
+		return fmt.Sprintf("it moved from %s to %s", before, is), false, nil
+	}
+
+	return "it gave up on it, and answered the next query", true, nil
+}
+
+// slowestReply checks that there is time to spare before the timeout
+func (p *prober) slowestReply() (string, bool, error) {
+
+	detail := fmt.Sprintf("the slowest reply took %s, with a timeout of %s", p.slowest.Round(time.Millisecond), p.timeout)
+
+	if p.slowest > p.timeout/2 {
+		return detail + ", so a longer timeout is needed", false, nil
+	}
+
+	return detail, true, nil
+}
+
+// mockController is a Conn to a controller that speaks version 1 of the protocol, as the
+// firmware does, for the Mock to be put through ProtocolTest
+type mockController struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	port    string
+	in      []byte    // received, but not yet a whole line
+	last    time.Time // when in was last added to
+	out     []byte
+}
+
+// mockGiveUp is how long the mock controller waits for the rest of a line, as the
+// firmware's serial timeout
+const mockGiveUp = 50 * time.Millisecond
+
+func (c *mockController) SetReadTimeout(t time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.timeout = t
+	return nil
+}
+
+func (c *mockController) Close() error {
+	return nil
+}
+
+func (c *mockController) Write(p []byte) (int, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.in) > 0 && time.Since(c.last) > mockGiveUp {
+		c.in = nil
+	}
+
+	c.in = append(c.in, p...)
+	c.last = time.Now()
+
+	for {
+
+		i := bytes.IndexByte(c.in, '\n')
+
+		if i < 0 {
+			return len(p), nil
+		}
+
+		line := c.in[:i]
+		c.in = c.in[i+1:]
+
+		if len(line) > commandSize {
+			continue
+		}
+
+		var m struct {
+			Set string `json:"set"`
+			To  string `json:"to"`
+			Get string `json:"get"`
+		}
+
+		if json.Unmarshal(line, &m) != nil {
+			continue
+		}
+
+		if _, ok := Channels[m.To]; m.Set == "port" && ok {
+			c.port = m.To
+		} else if m.Get != "port" {
+			continue
+		}
+
+		rep, _ := json.Marshal(Report{Report: "port", Is: c.port})
+		c.out = append(c.out, append(rep, '\r', '\n')...)
+	}
+}
+
+func (c *mockController) Read(p []byte) (int, error) {
+
+	c.mu.Lock()
+
+	if len(c.out) > 0 {
+		n := copy(p, c.out)
+		c.out = c.out[n:]
+		c.mu.Unlock()
+		return n, nil
+	}
+
+	t := c.timeout
+	c.mu.Unlock()
+
+	time.Sleep(t)
+
+	return 0, nil
+}
@@ -1,9 +1,14 @@
 package rfusb
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -15,30 +20,107 @@ import (
 type Command struct {
 	Set string `json:"set"`
 	To  string `json:"to"`
+	Seq int    `json:"seq,omitempty"` // protocol version 2 only
+	CRC string `json:"crc,omitempty"` // protocol version 2 only
+}
+
+// Query asks the switch to report its position without changing it,
+// or the version of the protocol it speaks
+type Query struct {
+	Get string `json:"get"`
+	Seq int    `json:"seq,omitempty"` // protocol version 2 only
+	CRC string `json:"crc,omitempty"` // protocol version 2 only
 }
 
 type Report struct {
 	Report string `json:"report"`
 	Is     string `json:"is"`
+	Seq    int    `json:"seq,omitempty"` // protocol version 2 only
+	CRC    string `json:"crc,omitempty"` // protocol version 2 only
+}
+
+// Checksum is the CRC-16/CCITT-FALSE of the fields joined by |, as four lower case hex digits.
+// Protocol version 2 messages carry the checksum of their other fields in order, including the
+// sequence number in decimal, e.g. {"set":"port","to":"dut1","seq":5} has Checksum("port","dut1","5").
+func Checksum(fields ...string) string {
+
+	crc := uint16(0xffff)
+
+	for _, b := range []byte(strings.Join(fields, "|")) {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return fmt.Sprintf("%04x", crc)
+}
+
+// Sign returns the command with a sequence number and checksum, for protocol version 2
+func (c Command) Sign(seq int) Command {
+	c.Seq = seq
+	c.CRC = Checksum(c.Set, c.To, strconv.Itoa(seq))
+	return c
+}
+
+// Sign returns the query with a sequence number and checksum, for protocol version 2
+func (q Query) Sign(seq int) Query {
+	q.Seq = seq
+	q.CRC = Checksum(q.Get, strconv.Itoa(seq))
+	return q
+}
+
+// Valid reports whether the checksum matches the rest of the report
+func (r Report) Valid() bool {
+	return r.CRC == Checksum(r.Report, r.Is, strconv.Itoa(r.Seq))
+}
+
+// Conn is a connection to a switch controller that speaks the JSON protocol.
+// A read that times out returns no data and no error, as a serial.Port does.
+type Conn interface {
+	io.ReadWriteCloser
+	SetReadTimeout(t time.Duration) error
 }
 
 type RFUSB struct {
 	mu      *sync.Mutex
-	sp      serial.Port
+	sp      Conn
 	port    string
 	timeout time.Duration
+	device  string // e.g. /dev/ttyUSB0, kept so the port can be reopened
+	baud    int
+	// network is tcp or udp for a networked switch controller, empty for usb
+	network string
+	// protocol is the version the switch speaks, 0 until negotiated
+	protocol int
+	seq      int
+	// opens the port to the bootloader for Flash, nil to reset the arduino with DTR
+	boot func(device string, baud int) (Conn, error)
+	// records the bytes to and from the controller, nil for none
+	traffic *Traffic
 }
 
 type Mock struct {
 	mu   *sync.Mutex
 	port string
+	// Misreport is the number of upcoming QueryPort calls that report
+	// an unknown position, to simulate a switch that did not move
+	Misreport int
+	// Delay is how long SetPort takes, to simulate the serial round trip
+	Delay   time.Duration
+	traffic *Traffic
 }
 
 type Switch interface {
 	Close() error
 	Get() string
+	QueryPort(ctx context.Context) (string, error)
 	Open(port string, baud int, timeout time.Duration) error
-	SetPort(port string) error
+	SetPort(ctx context.Context, port string) error
 	SetShort() error
 	SetOpen() error
 	SetLoad() error
@@ -49,6 +131,71 @@ type Switch interface {
 	SetDUT4() error
 }
 
+// Channels gives the channel of the switch on port 1 and port 2 for each position,
+// matching the firmware, for the drivers that set the control lines directly
+var Channels = map[string][2]int{
+	"short": {1, 4},
+	"open":  {2, 5},
+	"load":  {3, 6},
+	"thru":  {4, 3},
+	"dut1":  {5, 2},
+	"dut2":  {6, 1},
+	"dut3":  {7, 0},
+	"dut4":  {0, 7},
+}
+
+// Lines returns the levels of the control lines P1 A,B,C then P2 A,B,C for a position,
+// where A is the most significant bit of the channel
+func Lines(position string) ([6]bool, error) {
+
+	var l [6]bool
+
+	c, ok := Channels[strings.ToLower(position)]
+
+	if !ok {
+		return l, fmt.Errorf("unknown switch position %s", position)
+	}
+
+	for i, ch := range c {
+		l[3*i] = ch&4 != 0
+		l[3*i+1] = ch&2 != 0
+		l[3*i+2] = ch&1 != 0
+	}
+
+	return l, nil
+}
+
+// Position returns the position that the control lines are set to, or unknown
+func Position(l [6]bool) string {
+
+	for p := range Channels {
+		if m, _ := Lines(p); m == l {
+			return p
+		}
+	}
+
+	return "unknown"
+}
+
+// NewSwitch returns the driver for an rf switch, which can be usb (arduino on a serial port),
+// gpio (raspberry pi header), i2c (port expander), or tcp or udp (networked controller).
+// Open it with the port format for that driver.
+func NewSwitch(driver string) (Switch, error) {
+
+	switch strings.ToLower(driver) {
+	case "", "usb":
+		return NewRFUSB(), nil
+	case "gpio":
+		return NewGPIO(), nil
+	case "i2c":
+		return NewI2C(), nil
+	case "tcp", "udp":
+		return NewNetwork(strings.ToLower(driver)), nil
+	}
+
+	return nil, fmt.Errorf("switch can be usb, gpio, i2c, tcp or udp but not %s", driver)
+}
+
 func NewMock() *Mock {
 	return &Mock{
 		mu:   &sync.Mutex{},
@@ -61,44 +208,112 @@ func (m *Mock) Close() error {
 }
 
 func (m *Mock) Get() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	return m.port
 }
 
+func (m *Mock) QueryPort(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.Misreport > 0 {
+		m.Misreport--
+		m.exchange(Query{Get: "port"}, "unknown")
+		return "unknown", nil
+	}
+	m.exchange(Query{Get: "port"}, m.port)
+	return m.port, nil
+}
+
 func (m *Mock) Open(port string, baud int, timeout time.Duration) error {
 	return nil
 }
 
-func (m *Mock) SetPort(port string) error {
+// SetTraffic sets what records the messages that a switch on a serial port would
+// send and receive, as if it spoke protocol version 1
+func (m *Mock) SetTraffic(t *Traffic) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.traffic = t
+}
+
+// exchange records the messages for request and its report of port
+// caller must hold the lock
+func (m *Mock) exchange(request interface{}, port string) {
+
+	if m.traffic == nil {
+		return
+	}
+
+	req, _ := json.Marshal(request)
+	rep, _ := json.Marshal(Report{Report: "port", Is: port})
+
+	m.traffic.Record(Sent, append(req, '\n'))
+	m.traffic.Record(Received, append(rep, '\n'))
+}
+
+// ProtocolTest puts a controller that speaks version 1 of the protocol, as the firmware
+// does, through ProtocolTest, starting where the mock is, or at short, as the firmware does
+func (m *Mock) ProtocolTest(ctx context.Context, toggles int) (Conformance, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c := &mockController{port: m.port}
+
+	if _, ok := Channels[c.port]; !ok {
+		c.port = "short"
+	}
+
+	conformance, err := probe(ctx, c, time.Second, toggles, m.traffic)
+
+	m.port = c.port
+
+	return conformance, err
+}
+
+func (m *Mock) SetPort(ctx context.Context, port string) error {
+	select {
+	case <-time.After(m.Delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	m.mu.Lock()
 	m.port = port
+	m.exchange(Command{Set: "port", To: port}, port)
+	m.mu.Unlock()
 	return nil
 }
 
 func (m *Mock) SetShort() error {
-	return m.SetPort("short")
+	return m.SetPort(context.Background(), "short")
 }
 
 func (m *Mock) SetOpen() error {
-	return m.SetPort("open")
+	return m.SetPort(context.Background(), "open")
 }
 
 func (m *Mock) SetLoad() error {
-	return m.SetPort("load")
+	return m.SetPort(context.Background(), "load")
 }
 
 func (m *Mock) SetThru() error {
-	return m.SetPort("thru")
+	return m.SetPort(context.Background(), "thru")
 }
 func (m *Mock) SetDUT1() error {
-	return m.SetPort("dut1")
+	return m.SetPort(context.Background(), "dut1")
 }
 func (m *Mock) SetDUT2() error {
-	return m.SetPort("dut2")
+	return m.SetPort(context.Background(), "dut2")
 }
 func (m *Mock) SetDUT3() error {
-	return m.SetPort("dut3")
+	return m.SetPort(context.Background(), "dut3")
 }
 func (m *Mock) SetDUT4() error {
-	return m.SetPort("dut4")
+	return m.SetPort(context.Background(), "dut4")
 }
 
 func NewRFUSB() *RFUSB {
@@ -113,9 +328,23 @@ func (r *RFUSB) Get() string {
 	return r.port
 }
 
+// SetTraffic sets what records the bytes to and from the controller, nil for nothing
+func (r *RFUSB) SetTraffic(t *Traffic) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.traffic = t
+}
+
 func (r *RFUSB) Open(port string, baud int, timeout time.Duration) error {
 
 	r.timeout = timeout
+	r.device = port
+	r.baud = baud
+	r.protocol = 0 // the firmware may have changed
+
+	if r.network != "" {
+		return r.dial()
+	}
 
 	mode := &serial.Mode{
 		BaudRate: baud,
@@ -146,78 +375,238 @@ func (r *RFUSB) Open(port string, baud int, timeout time.Duration) error {
 func (r *RFUSB) Close() error {
 	// don't take lock because there is read, close concurrency
 	// https://github.com/bugst/go-serial/blob/e381f2c1332081ea593d73e97c71342026876857/serial_linux_test.go#L35
-	return r.sp.Close()
+	sp := r.sp
+
+	if sp == nil {
+		return nil // never opened, or lost
+	}
+
+	return sp.Close()
+}
+
+// reopen tries to open the port again, if it failed to open, or was lost
+// caller must hold the lock
+func (r *RFUSB) reopen() error {
+
+	if r.device == "" {
+		return errors.New("port is nil")
+	}
+
+	log.WithFields(log.Fields{"port": r.device, "baud": r.baud}).Info("reopening usb port")
+
+	return r.Open(r.device, r.baud, r.timeout)
+}
+
+// lost closes the port after a serial error (e.g. the switch was unplugged),
+// so that it is reopened on the next request, rather than failing forever
+// caller must hold the lock
+func (r *RFUSB) lost(err error) {
+
+	if err == nil || errors.Is(err, errTimeout) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return
+	}
+
+	log.WithFields(log.Fields{"port": r.device, "error": err.Error()}).Warn("lost usb port, will reopen on next request")
+
+	if r.sp != nil {
+		_ = r.sp.Close() // ignore error, it's probably gone already
+	}
+
+	r.sp = nil
+	r.port = "unknown"
 }
 
 func (r *RFUSB) SetShort() error {
-	return r.SetPort("short")
+	return r.SetPort(context.Background(), "short")
 }
 
 func (r *RFUSB) SetOpen() error {
-	return r.SetPort("open")
+	return r.SetPort(context.Background(), "open")
 }
 
 func (r *RFUSB) SetLoad() error {
-	return r.SetPort("load")
+	return r.SetPort(context.Background(), "load")
 }
 
 func (r *RFUSB) SetThru() error {
-	return r.SetPort("thru")
+	return r.SetPort(context.Background(), "thru")
 }
 func (r *RFUSB) SetDUT1() error {
-	return r.SetPort("dut1")
+	return r.SetPort(context.Background(), "dut1")
 }
 func (r *RFUSB) SetDUT2() error {
-	return r.SetPort("dut2")
+	return r.SetPort(context.Background(), "dut2")
 }
 func (r *RFUSB) SetDUT3() error {
-	return r.SetPort("dut3")
+	return r.SetPort(context.Background(), "dut3")
 }
 func (r *RFUSB) SetDUT4() error {
-	return r.SetPort("dut4")
+	return r.SetPort(context.Background(), "dut4")
+}
+
+func (r *RFUSB) SetPort(ctx context.Context, port string) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	request := Command{
+		Set: "port",
+		To:  port,
+	}
+
+	report, err := r.exchange(ctx, request)
+
+	if err != nil {
+		r.lost(err)
+		return err
+	}
+
+	if strings.ToLower(report.Is) != strings.ToLower(port) {
+		return fmt.Errorf("switch reported port %s instead of %s", report.Is, port)
+	}
+	r.port = port
+	return nil
+
 }
 
-func (r *RFUSB) SetPort(port string) error {
+// QueryPort asks the switch which port it is set to, rather than
+// relying on the port cached from the last successful SetPort
+func (r *RFUSB) QueryPort(ctx context.Context) (string, error) {
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
+
+	report, err := r.exchange(ctx, Query{Get: "port"})
+
+	if err != nil {
+		r.lost(err)
+		return "", err
+	}
+
+	return strings.ToLower(report.Is), nil
+
+}
+
+// ProtocolTest puts the controller through ProtocolTest, recording the traffic if asked,
+// then negotiates its protocol afresh before the next request, in case it was confused
+func (r *RFUSB) ProtocolTest(ctx context.Context, toggles int) (Conformance, error) {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	if r.sp == nil {
-		return errors.New("port is nil")
+		if err := r.reopen(); err != nil {
+			return Conformance{}, err
+		}
 	}
 
-	resp := make([]byte, 128)
+	c, err := probe(ctx, r.sp, r.timeout, toggles, r.traffic)
+
+	r.protocol = 0
+	r.port = c.Port
+
+	if err != nil {
+		r.lost(err)
+	}
+
+	return c, err
+}
+
+// poll is how often a read from the switch checks whether it has been cancelled
+const poll = 50 * time.Millisecond
 
-	// read any stale messages before we send our command
-	// make a short timeout temporarily to avoid wasting time
+// MaxMessage is the longest line expected from the switch
+const MaxMessage = 1024
+
+// Negotiate is the longest to wait for the switch to report its protocol version,
+// since version 1 firmware does not reply
+const Negotiate = 500 * time.Millisecond
+
+// Retries is how many times a request is sent again when the switch reports
+// it was corrupted, or its reply is corrupted (protocol version 2 only)
+const Retries = 3
+
+// errTimeout is returned when the switch does not reply in time
+var errTimeout = errors.New("timed out waiting for the switch")
+
+// deadlineReader reads from the serial port until the deadline, or until ctx
+// is done. The port's own read timeout just sets how often these are checked.
+type deadlineReader struct {
+	ctx      context.Context
+	sp       Conn
+	deadline time.Time
+	traffic  *Traffic // records what is read, nil for nothing
+}
+
+func (d *deadlineReader) Read(p []byte) (int, error) {
+
+	for {
+
+		if err := d.ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		if !time.Now().Before(d.deadline) {
+			return 0, errTimeout
+		}
+
+		n, err := d.sp.Read(p)
+
+		if n > 0 {
+			d.traffic.Record(Received, p[:n])
+		}
+
+		//https://github.com/bugst/go-serial/blob/e381f2c1332081ea593d73e97c71342026876857/serial_unix.go#L94
+		// timeout is n==0, err==nil
+		if err != nil || n > 0 {
+			return n, err
+		}
+	}
+}
+
+// drain discards any stale messages, so the next line read is the reply to our request
+// caller must hold the lock
+func (r *RFUSB) drain(ctx context.Context) error {
+
+	// a short timeout avoids wasting time once there is nothing left
 	err := r.sp.SetReadTimeout(10 * time.Millisecond)
+
 	if err != nil {
 		return fmt.Errorf("setting short timeout before drain failed because %s", err.Error())
 	}
-DRAINED:
+
+	buf := make([]byte, 128)
+
 	for {
 
-		n, err := r.sp.Read(resp)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := r.sp.Read(buf)
+
+		if n > 0 {
+			r.traffic.Record(Drained, buf[:n])
+		}
+
 		if err != nil {
 			return err //port probably closed
 		}
-		//https://github.com/bugst/go-serial/blob/e381f2c1332081ea593d73e97c71342026876857/serial_unix.go#L94
-		// timeout is n==0, err==nil
+
 		if n == 0 {
-			break DRAINED
+			return nil
 		}
-		continue
 	}
+}
 
-	// restore normal timeout
-	err = r.sp.SetReadTimeout(r.timeout)
+// send drains any stale messages, then writes the request
+// caller must hold the lock
+func (r *RFUSB) send(ctx context.Context, request interface{}) error {
 
-	if err != nil {
-		return fmt.Errorf("restoring timeout after drain failed because %s", err.Error())
-	}
+	err := r.drain(ctx)
 
-	request := Command{
-		Set: "port",
-		To:  port,
+	if err != nil {
+		return err
 	}
 
 	req, err := json.Marshal(request)
@@ -226,8 +615,14 @@ DRAINED:
 		return fmt.Errorf("marshal request failed because %s", err.Error())
 	}
 
+	req = append(req, '\n') // so the controller need not wait for a timeout to know the message is complete
+
 	n, err := r.sp.Write(req)
 
+	if n > 0 {
+		r.traffic.Record(Sent, req[:n])
+	}
+
 	log.WithFields(log.Fields{"count_expected": len(req), "count_actual": n, "data_expected": string(req), "data_actual": string(req[:n])}).Trace("wrote message to usb")
 
 	if err != nil {
@@ -239,69 +634,186 @@ DRAINED:
 		return errors.New("did not finish writing message")
 	}
 
-	// Get the response
-	// note we do a drain afterwards to avoid this error:
-	// unmarshalling reply failed because because unexpected end of JSON input. Reply was {"report":"port","is":"sho
+	return nil
+}
 
-	reply := make([]byte, 128)
+// receive returns the first report from the switch that is accepted, before the
+// timeout. Each reply is one line of JSON. Any other lines (e.g. debug messages) are skipped.
+// caller must hold the lock
+func (r *RFUSB) receive(ctx context.Context, timeout time.Duration, accept func(Report) bool) (Report, error) {
 
-	n, err = r.sp.Read(resp)
+	var report Report
+
+	err := r.sp.SetReadTimeout(poll)
 
 	if err != nil {
-		return fmt.Errorf("reading reply failed because because %s", err.Error())
+		return report, fmt.Errorf("setting read timeout failed because %s", err.Error())
 	}
 
-	if n == 0 {
-		return fmt.Errorf("empty reply")
-	}
+	scanner := bufio.NewScanner(&deadlineReader{
+		ctx:      ctx,
+		sp:       r.sp,
+		deadline: time.Now().Add(timeout),
+		traffic:  r.traffic,
+	})
 
-	idx := n - 1
-	copy(reply[:], resp[:])
+	scanner.Buffer(make([]byte, 0, 128), MaxMessage)
 
-	//check we drained the whole message
-	// make a short timeout temporarily to avoid wasting time if we got the whole message already
-	err = r.sp.SetReadTimeout(100 * time.Millisecond) //don't make it too short or else get partial messages (that happens at 10ms)
+	for scanner.Scan() {
 
-	if err != nil {
-		return fmt.Errorf("setting short timeout before drain failed because %s", err.Error())
-	}
-COMPLETED:
-	for {
+		line := bytes.TrimSpace(scanner.Bytes())
 
-		n, err := r.sp.Read(resp)
-		if err != nil {
-			return err //port probably closed
+		if len(line) == 0 {
+			continue
 		}
-		//https://github.com/bugst/go-serial/blob/e381f2c1332081ea593d73e97c71342026876857/serial_unix.go#L94
-		// timeout is n==0, err==nil
-		if n == 0 {
-			break COMPLETED
-		}
-		if (idx + n) < len(reply) {
-			copy(reply[idx+1:idx+n], resp[:]) //TODO check if copies null?
-			idx = idx + n
 
-		} else {
-			log.Fatal("pkg/rfusb: serial read buffer full")
+		log.WithField("data_actual", string(line)).Trace("read message from usb")
+
+		var rep Report
+
+		err = json.Unmarshal(line, &rep)
+
+		if err != nil || !accept(rep) {
+			log.WithField("line", string(line)).Debug("pkg/rfusb: ignoring message from switch that is not the reply")
+			continue
 		}
-		continue
+
+		return rep, nil
 	}
 
-	var report Report
-	log.Debugf("(%d)%s", idx, string(reply[:idx]))
-	err = json.Unmarshal(reply[:idx], &report) //truncate to bytes read to avoid \x00 char which breaks unmarshal
+	err = scanner.Err()
+
+	if err == nil {
+		err = errors.New("port closed") // scanner stops without an error at EOF
+	}
+
+	return report, fmt.Errorf("reading reply failed because %w", err)
+}
+
+// negotiate asks the switch which version of the protocol it speaks. Firmware
+// that only speaks version 1 does not reply, so this waits for Negotiate at most.
+// caller must hold the lock
+func (r *RFUSB) negotiate(ctx context.Context) error {
+
+	err := r.send(ctx, Query{Get: "version"})
 
 	if err != nil {
-		return fmt.Errorf("unmarshalling reply failed because because %s. Reply was %s", err.Error(), string(resp))
+		return err
+	}
+
+	timeout := Negotiate
+
+	if r.timeout < timeout {
+		timeout = r.timeout
 	}
-	log.WithFields(log.Fields{"count_actual": n, "data_actual": string(resp[:n])}).Trace("read message from usb")
-	if strings.ToLower(report.Report) != "port" {
-		return errors.New("response was not a port report")
+
+	report, err := r.receive(ctx, timeout, func(rep Report) bool {
+		return strings.ToLower(rep.Report) == "version"
+	})
+
+	if errors.Is(err, errTimeout) {
+		r.protocol = 1
+		log.WithField("port", r.device).Info("switch speaks protocol version 1")
+		return nil
 	}
-	if strings.ToLower(report.Is) != strings.ToLower(port) {
+
+	if err != nil {
 		return err
 	}
-	r.port = port
+
+	v, err := strconv.Atoi(report.Is)
+
+	if err != nil || v < 2 {
+		r.protocol = 1
+	} else {
+		r.protocol = 2 // the latest we speak
+	}
+
+	log.WithFields(log.Fields{"port": r.device, "reported": report.Is, "using": r.protocol}).Info("negotiated switch protocol version")
+
 	return nil
+}
+
+// exchange sends a request to the switch and returns its port report. With
+// protocol version 2, the request and report carry a sequence number and
+// checksum, and the request is sent again (up to Retries times) if either
+// end finds the message was corrupted.
+// caller must hold the lock
+func (r *RFUSB) exchange(ctx context.Context, request interface{}) (Report, error) {
+
+	var report Report
+
+	if r.sp == nil {
+		if err := r.reopen(); err != nil {
+			return report, err
+		}
+	}
+
+	if r.protocol == 0 {
+		if err := r.negotiate(ctx); err != nil {
+			return report, err
+		}
+	}
+
+	if r.protocol < 2 {
+
+		err := r.send(ctx, request)
+
+		if err != nil {
+			return report, err
+		}
+
+		return r.receive(ctx, r.timeout, func(rep Report) bool {
+			return strings.ToLower(rep.Report) == "port"
+		})
+	}
+
+	for attempt := 0; attempt <= Retries; attempt++ {
+
+		r.seq++
+		seq := r.seq
+
+		switch v := request.(type) {
+		case Command:
+			request = v.Sign(seq)
+		case Query:
+			request = v.Sign(seq)
+		}
+
+		err := r.send(ctx, request)
+
+		if err != nil {
+			return report, err
+		}
+
+		// an error report without a sequence number is a command the switch could not parse
+		rep, err := r.receive(ctx, r.timeout, func(rep Report) bool {
+			switch strings.ToLower(rep.Report) {
+			case "port":
+				return rep.Seq == seq
+			case "error":
+				return rep.Seq == seq || rep.Seq == 0
+			}
+			return false
+		})
+
+		if err != nil {
+			return report, err
+		}
+
+		if strings.ToLower(rep.Report) == "error" {
+			log.WithFields(log.Fields{"seq": seq, "attempt": attempt, "is": rep.Is}).Warn("switch could not read command, sending again")
+			continue
+		}
+
+		if !rep.Valid() {
+			log.WithFields(log.Fields{"seq": seq, "attempt": attempt, "crc": rep.CRC}).Warn("corrupted report from switch, sending again")
+			continue
+		}
+
+		return rep, nil
+	}
+
+	return report, fmt.Errorf("messages to or from the switch were corrupted %d times", Retries+1)
 
 }
@@ -3,12 +3,22 @@ package rfusb
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"go.bug.st/serial"
 )
 
 var hardware bool
@@ -62,12 +72,26 @@ func TestSetPort(t *testing.T) {
 		t.Skip("no hardware")
 	}
 
-	err := r.SetPort("short")
+	err := r.SetPort(context.Background(), "short")
 
 	assert.NoError(t, err)
 
 }
 
+func TestQueryPort(t *testing.T) {
+	if !hardware {
+		t.Skip("no hardware")
+	}
+
+	err := r.SetPort(context.Background(), "load")
+	assert.NoError(t, err)
+
+	is, err := r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "load", is)
+
+}
+
 func TestInterface(t *testing.T) {
 
 	var rs Switch
@@ -218,3 +242,830 @@ func TestSettingPortsMock(t *testing.T) {
 	assert.NoError(t, err)
 
 }
+
+func TestQueryPortMock(t *testing.T) {
+
+	r := NewMock()
+
+	err := r.SetDUT3()
+	assert.NoError(t, err)
+
+	is, err := r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "dut3", is)
+
+	r.Misreport = 1
+
+	is, err = r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "unknown", is)
+
+	is, err = r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "dut3", is)
+
+}
+
+func TestCancelMock(t *testing.T) {
+
+	r := NewMock()
+	r.Delay = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	t0 := time.Now()
+	err := r.SetPort(ctx, "dut1")
+	assert.Error(t, err)
+	assert.True(t, time.Since(t0) < 500*time.Millisecond)
+	assert.Equal(t, "unknown", r.Get())
+
+	_, err = r.QueryPort(ctx)
+	assert.Error(t, err)
+}
+
+// fakePort replies to each write with the given chunks, then times out on every read
+type fakePort struct {
+	serial.Port // not implemented, panics if used
+	mu          sync.Mutex
+	timeout     time.Duration
+	stale       []byte
+	chunks      [][]byte
+	reply       []string
+	gone        bool // unplugged, so reads fail
+	closed      bool
+	respond     func(request []byte) []string // replaces reply, if set
+}
+
+func (f *fakePort) Close() error {
+	f.closed = true
+	return nil
+}
+
+func (f *fakePort) SetReadTimeout(t time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.timeout = t
+	return nil
+}
+
+func (f *fakePort) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.respond != nil {
+		for _, r := range f.respond(p) {
+			f.chunks = append(f.chunks, []byte(r))
+		}
+		return len(p), nil
+	}
+	for _, r := range f.reply {
+		f.chunks = append(f.chunks, []byte(r))
+	}
+	return len(p), nil
+}
+
+func (f *fakePort) Read(p []byte) (int, error) {
+	f.mu.Lock()
+	if f.gone {
+		f.mu.Unlock()
+		return 0, errors.New("device not configured")
+	}
+	if len(f.stale) > 0 {
+		n := copy(p, f.stale)
+		f.stale = f.stale[n:]
+		f.mu.Unlock()
+		return n, nil
+	}
+	if len(f.chunks) > 0 {
+		n := copy(p, f.chunks[0])
+		f.chunks[0] = f.chunks[0][n:]
+		if len(f.chunks[0]) == 0 {
+			f.chunks = f.chunks[1:]
+		}
+		f.mu.Unlock()
+		return n, nil
+	}
+	t := f.timeout
+	f.mu.Unlock()
+	time.Sleep(t)
+	return 0, nil
+}
+
+func TestFramedReader(t *testing.T) {
+
+	newFake := func(reply ...string) *RFUSB {
+		r := NewRFUSB()
+		r.timeout = 200 * time.Millisecond
+		r.sp = &fakePort{reply: reply}
+		return r
+	}
+
+	// reply split across reads, with CRLF as sent by println
+	r := newFake(`{"report":"po`, `rt","is":"dut1"}`, "\r\n")
+	err := r.SetPort(context.Background(), "dut1")
+	assert.NoError(t, err)
+	assert.Equal(t, "dut1", r.Get())
+
+	// debug lines and blank lines before the report are skipped
+	r = newFake("starting\r\n", "\r\n", `{"report":"error","is":"unknown"}`+"\r\n", `{"report":"port","is":"thru"}`+"\r\n")
+	is, err := r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "thru", is)
+
+	// stale input from before the request is drained, not taken as the reply
+	r = newFake(`{"report":"port","is":"dut2"}` + "\r\n")
+	r.sp.(*fakePort).stale = []byte(`{"report":"port","is":"short"}` + "\r\n")
+	is, err = r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "dut2", is)
+
+	// a line longer than MaxMessage is an error, not a crash
+	r = newFake(strings.Repeat("x", 2*MaxMessage), "\n")
+	err = r.SetPort(context.Background(), "dut1")
+	assert.Error(t, err)
+	assert.Equal(t, "unknown", r.Get())
+
+	// no reply times out after the usb timeout
+	r = newFake()
+	t0 := time.Now()
+	_, err = r.QueryPort(context.Background())
+	assert.Error(t, err)
+	assert.True(t, time.Since(t0) < time.Second)
+
+	// cancelling does not wait for the timeout
+	r = newFake()
+	r.timeout = 10 * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	t0 = time.Now()
+	_, err = r.QueryPort(ctx)
+	assert.Error(t, err)
+	assert.True(t, time.Since(t0) < time.Second)
+}
+
+func TestLostPort(t *testing.T) {
+
+	f := &fakePort{gone: true}
+	r := NewRFUSB()
+	r.timeout = 200 * time.Millisecond
+	r.sp = f
+
+	// a serial error is returned, rather than exiting, and the port is closed
+	err := r.SetPort(context.Background(), "dut1")
+	assert.Error(t, err)
+	assert.True(t, f.closed)
+	assert.Nil(t, r.sp)
+
+	// and reopened next time, which fails if it is still missing
+	r.device = "/dev/no-such-switch"
+	_, err = r.QueryPort(context.Background())
+	assert.Error(t, err)
+	assert.Nil(t, r.sp)
+
+	// a timeout is not a lost port
+	f = &fakePort{}
+	r.sp = f
+	_, err = r.QueryPort(context.Background())
+	assert.Error(t, err)
+	assert.False(t, f.closed)
+	assert.NoError(t, r.Close())
+}
+
+func TestTraffic(t *testing.T) {
+
+	at := time.Date(2023, 1, 9, 10, 0, 0, 123000, time.UTC)
+	assert.Equal(t, "2023-01-09T10:00:00.000123Z tx 3 7b 7d 0a |{}.|", FormatTraffic(at, Sent, []byte("{}\n")))
+
+	stale := `{"report":"port","is":"short"}` + "\r\n"
+
+	f := &fakePort{reply: []string{`{"report":"po`, `rt","is":"dut2"}` + "\r\n"}, stale: []byte(stale)}
+	r := NewRFUSB()
+	r.timeout = 200 * time.Millisecond
+	r.protocol = 1
+	r.sp = f
+
+	file := filepath.Join(t.TempDir(), "traffic.log")
+
+	tr := NewTraffic(10)
+	tr.SetFile(file)
+	r.SetTraffic(tr)
+
+	// nothing is recorded until it is started
+	_, err := r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, tr.Lines())
+
+	tr.SetRecording(true)
+	assert.True(t, tr.Recording())
+
+	f.stale = []byte(stale)
+
+	is, err := r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "dut2", is)
+
+	// each read and write as it was, with the stale report that was drained
+	lines := tr.Lines()
+	assert.Equal(t, 4, len(lines))
+	assert.Contains(t, lines[0], " drained 32 7b 22 72 65 ")
+	assert.Contains(t, lines[0], `|{"report":"port","is":"short"}..|`)
+	assert.Contains(t, lines[1], ` tx 15 7b 22 67 65 74 22 `)
+	assert.Contains(t, lines[2], ` rx 13 `)
+	assert.Contains(t, lines[2], `|{"report":"po|`)
+	assert.Contains(t, lines[3], `|rt","is":"dut2"}..|`)
+
+	b, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, strings.Join(lines, "\n")+"\n", string(b))
+
+	// only the last lines are kept in memory
+	for i := 0; i < 3; i++ {
+		_, err = r.QueryPort(context.Background())
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 10, len(tr.Lines()))
+
+	tr.Clear()
+	assert.Empty(t, tr.Lines())
+
+	tr.SetRecording(false)
+	_, err = r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, tr.Lines())
+
+	// a file that cannot be written leaves the lines in memory
+	tr.SetFile(filepath.Join(t.TempDir(), "missing", "traffic.log"))
+	tr.SetRecording(true)
+	_, err = r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(tr.Lines()))
+
+	// the mock records what a switch on a serial port would send and receive
+	m := NewMock()
+	tr = NewTraffic(0)
+	tr.SetRecording(true)
+	m.SetTraffic(tr)
+	assert.NoError(t, m.SetPort(context.Background(), "dut1"))
+	lines = tr.Lines()
+	assert.Equal(t, 2, len(lines))
+	assert.Contains(t, lines[0], `|{"set":"port","to":"dut1"}.|`)
+	assert.Contains(t, lines[1], `|{"report":"port","is":"dut1"}.|`)
+
+	var none *Traffic
+	none.Record(Sent, []byte("x"))
+	none.SetRecording(true)
+	assert.False(t, none.Recording())
+	assert.Empty(t, none.Lines())
+	assert.Equal(t, "", none.File())
+}
+
+func TestChecksum(t *testing.T) {
+
+	// CRC-16/CCITT-FALSE check value
+	assert.Equal(t, "29b1", Checksum("123456789"))
+
+	c := Command{Set: "port", To: "dut1"}.Sign(5)
+	assert.Equal(t, Checksum("port", "dut1", "5"), c.CRC)
+
+	b, err := json.Marshal(c)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"set":"port","to":"dut1","seq":5,"crc":"`+c.CRC+`"}`, string(b))
+
+	// version 1 messages are unchanged
+	b, err = json.Marshal(Query{Get: "port"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"get":"port"}`, string(b))
+
+	rep := Report{Report: "port", Is: "dut1", Seq: 5, CRC: Checksum("port", "dut1", "5")}
+	assert.True(t, rep.Valid())
+	rep.Is = "dut2"
+	assert.False(t, rep.Valid())
+}
+
+// switchV2 replies like version 2 firmware, corrupting the first corrupt replies
+func switchV2(corrupt int, position *string, received *int) func([]byte) []string {
+
+	return func(request []byte) []string {
+
+		var m struct {
+			Set string `json:"set"`
+			To  string `json:"to"`
+			Get string `json:"get"`
+			Seq int    `json:"seq"`
+			CRC string `json:"crc"`
+		}
+
+		if err := json.Unmarshal(request, &m); err != nil {
+			return []string{`{"report":"error","is":"json"}` + "\r\n"}
+		}
+
+		if m.Get == "version" {
+			return []string{`{"report":"version","is":"2"}` + "\r\n"}
+		}
+
+		*received++
+
+		seq := strconv.Itoa(m.Seq)
+
+		if (m.Set != "" && m.CRC != Checksum(m.Set, m.To, seq)) || (m.Get != "" && m.CRC != Checksum(m.Get, seq)) {
+			return []string{fmt.Sprintf(`{"report":"error","is":"crc","seq":%d,"crc":"%s"}`+"\r\n", m.Seq, Checksum("error", "crc", seq))}
+		}
+
+		if m.Set == "port" {
+			*position = m.To
+		}
+
+		crc := Checksum("port", *position, seq)
+
+		if corrupt > 0 {
+			corrupt--
+			crc = "0000"
+		}
+
+		return []string{fmt.Sprintf(`{"report":"port","is":"%s","seq":%d,"crc":"%s"}`+"\r\n", *position, m.Seq, crc)}
+	}
+}
+
+func TestProtocolV2(t *testing.T) {
+
+	position := "short"
+	received := 0
+
+	r := NewRFUSB()
+	r.timeout = time.Second
+	r.sp = &fakePort{respond: switchV2(2, &position, &received)}
+
+	// corrupted reports are detected, and the command sent again
+	err := r.SetPort(context.Background(), "dut2")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.protocol)
+	assert.Equal(t, 3, received)
+	assert.Equal(t, "dut2", r.Get())
+
+	is, err := r.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "dut2", is)
+
+	// a late report from a previous request is not taken as the reply
+	f := r.sp.(*fakePort)
+	respond := f.respond
+	late := fmt.Sprintf(`{"report":"port","is":"dut2","seq":%d,"crc":"%s"}`+"\r\n", r.seq, Checksum("port", "dut2", strconv.Itoa(r.seq)))
+	f.respond = func(request []byte) []string {
+		return append([]string{late}, respond(request)...)
+	}
+	err = r.SetPort(context.Background(), "thru")
+	assert.NoError(t, err)
+	assert.Equal(t, "thru", r.Get())
+
+	// give up if it stays corrupted
+	r.sp = &fakePort{respond: switchV2(Retries+1, &position, &received)}
+	err = r.SetPort(context.Background(), "load")
+	assert.Error(t, err)
+
+	// version 1 firmware does not reply to the version query
+	r = newV1(t)
+	err = r.SetPort(context.Background(), "dut1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.protocol)
+}
+
+func TestProtocolTest(t *testing.T) {
+
+	// the mock speaks the protocol as the firmware does
+	m := NewMock()
+	assert.NoError(t, m.SetPort(context.Background(), "dut2"))
+
+	c, err := m.ProtocolTest(context.Background(), 4)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.Protocol)
+	assert.True(t, c.Passed(), "%+v", c.Checks)
+	assert.Equal(t, 21, len(c.Checks))
+	assert.Equal(t, "dut2", c.Port)
+	assert.Equal(t, "dut2", m.Get())
+	assert.Equal(t, map[string]int{"short": 3, "open": 3, "load": 2, "thru": 2, "dut1": 1, "dut2": 2, "dut3": 1, "dut4": 1}, c.Moves)
+
+	// a version 2 switch that reads one message per write
+	position, received := "short", 0
+
+	r := NewRFUSB()
+	r.timeout = time.Second
+	r.protocol = 2
+	r.sp = &fakePort{respond: switchV2(0, &position, &received)}
+
+	c, err = r.ProtocolTest(context.Background(), 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, c.Protocol)
+	assert.Equal(t, []string{"back to back"}, c.Failed())
+	assert.False(t, c.Passed())
+	assert.Equal(t, "short", c.Port)
+	assert.Equal(t, "short", position)
+	assert.Equal(t, "short", r.Get())
+	assert.Equal(t, 0, r.protocol) // negotiated afresh
+
+	// a version 1 switch that echoes each message, and answers anything with a report
+	position = "dut1"
+
+	chatty := &fakePort{respond: func(request []byte) []string {
+		reply := []string{"got " + strings.TrimSpace(string(request)) + "\r\n"}
+		var c Command
+		if json.Unmarshal(request, &c) == nil && c.Set == "port" {
+			position = c.To
+		}
+		return append(reply, `{"report":"port","is":"`+position+`"}`+"\r\n")
+	}}
+
+	c, err = ProtocolTest(context.Background(), chatty, time.Second, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, c.Protocol)
+	assert.Equal(t, []string{"nothing unasked", "back to back", "malformed json", "binary noise", "overlong line", "unknown position", "unknown request"}, c.Failed())
+	assert.Equal(t, "dut1", c.Port)
+
+	// a lost port stops the test
+	f := &fakePort{gone: true}
+	r.sp = f
+	_, err = r.ProtocolTest(context.Background(), 0)
+	assert.Error(t, err)
+	assert.True(t, f.closed)
+	assert.Equal(t, "unknown", r.Get())
+}
+
+// newV1 returns an RFUSB connected to a fake version 1 switch
+func newV1(t *testing.T) *RFUSB {
+
+	r := NewRFUSB()
+	r.timeout = time.Second
+	r.sp = &fakePort{respond: func(request []byte) []string {
+		var c Command
+		assert.NoError(t, json.Unmarshal(request, &c))
+		if c.Set != "port" {
+			return nil
+		}
+		return []string{`{"report":"port","is":"` + c.To + `"}` + "\r\n"}
+	}}
+
+	return r
+}
+
+func TestLines(t *testing.T) {
+
+	// dut1 is channel 5 (101) on port 1 and channel 2 (010) on port 2
+	l, err := Lines("DUT1")
+	assert.NoError(t, err)
+	assert.Equal(t, [6]bool{true, false, true, false, true, false}, l)
+
+	for p := range Channels {
+		l, err := Lines(p)
+		assert.NoError(t, err)
+		assert.Equal(t, p, Position(l))
+		assert.Equal(t, p, Position(Decode(Encode(l))))
+	}
+
+	_, err = Lines("dut5")
+	assert.Error(t, err)
+	assert.Equal(t, "unknown", Position([6]bool{}))
+
+	assert.Equal(t, byte(0xc0|0x15), Encode(l0("dut1")))
+
+	for _, d := range []string{"usb", "gpio", "i2c"} {
+		_, err := NewSwitch(d)
+		assert.NoError(t, err)
+	}
+
+	_, err = NewSwitch("spi")
+	assert.Error(t, err)
+}
+
+func l0(p string) [6]bool {
+	l, _ := Lines(p)
+	return l
+}
+
+func TestGPIO(t *testing.T) {
+
+	// fake sysfs, with the pins already exported
+	root := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(root, "export"), nil, 0644))
+
+	pins := []int{8, 9, 10, 4, 5, 6, 3, 2}
+
+	for _, p := range pins {
+		dir := filepath.Join(root, "gpio"+strconv.Itoa(p))
+		assert.NoError(t, os.Mkdir(dir, 0755))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "direction"), []byte("in"), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "value"), []byte("0"), 0644))
+	}
+
+	level := func(pin int) string {
+		b, err := os.ReadFile(filepath.Join(root, "gpio"+strconv.Itoa(pin), "value"))
+		assert.NoError(t, err)
+		return string(b)
+	}
+
+	g := NewGPIO()
+	g.Root = root
+
+	err := g.SetPort(context.Background(), "dut1")
+	assert.Error(t, err)
+
+	assert.Error(t, g.Open("8,9,10", 0, 0))
+
+	err = g.Open("8,9,10,4,5,6,3,2", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", level(3)) //powered
+	assert.Equal(t, "1", level(2))
+
+	err = g.SetPort(context.Background(), "dut1")
+	assert.NoError(t, err)
+	assert.Equal(t, "dut1", g.Get())
+
+	for i, want := range []string{"1", "0", "1", "0", "1", "0"} {
+		assert.Equal(t, want, level(pins[i]))
+	}
+
+	is, err := g.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "dut1", is)
+
+	assert.NoError(t, g.SetThru())
+	is, err = g.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "thru", is)
+
+	assert.Error(t, g.SetPort(context.Background(), "dut5"))
+	assert.NoError(t, g.Close())
+}
+
+// fakeBus is a PCF8574 that reads back what was written
+type fakeBus struct {
+	b      byte
+	closed bool
+}
+
+func (f *fakeBus) Read(p []byte) (int, error) {
+	p[0] = f.b
+	return 1, nil
+}
+
+func (f *fakeBus) Write(p []byte) (int, error) {
+	f.b = p[len(p)-1]
+	return len(p), nil
+}
+
+func (f *fakeBus) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestI2C(t *testing.T) {
+
+	_, _, err := ParseI2C("/dev/i2c-1")
+	assert.Error(t, err)
+	_, _, err = ParseI2C("/dev/i2c-1@0x80")
+	assert.Error(t, err)
+
+	dev, addr, err := ParseI2C("/dev/i2c-1@0x20")
+	assert.NoError(t, err)
+	assert.Equal(t, "/dev/i2c-1", dev)
+	assert.Equal(t, 0x20, addr)
+
+	c := NewI2C()
+	assert.Error(t, c.SetDUT1())
+	assert.Error(t, c.Open("/dev/no-such-i2c@0x20", 0, 0))
+
+	bus := &fakeBus{}
+	c.bus = bus
+
+	assert.NoError(t, c.SetDUT3())
+	assert.Equal(t, Encode(l0("dut3")), bus.b)
+	assert.Equal(t, "dut3", c.Get())
+
+	is, err := c.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "dut3", is)
+
+	assert.NoError(t, c.Close())
+	assert.True(t, bus.closed)
+}
+
+// serveSwitch accepts connections to a fake version 2 switch controller, one at a time,
+// until the listener is closed. Each connection is closed after drop requests, if drop > 0.
+func serveSwitch(t *testing.T, l net.Listener, drop int) {
+
+	position := "short"
+	received := 0
+	respond := switchV2(0, &position, &received)
+
+	for {
+
+		conn, err := l.Accept()
+
+		if err != nil {
+			return
+		}
+
+		sc := bufio.NewScanner(conn)
+		n := 0
+
+		for sc.Scan() {
+
+			for _, reply := range respond(sc.Bytes()) {
+				_, err = conn.Write([]byte(reply))
+				assert.NoError(t, err)
+			}
+
+			n++
+
+			if n == drop {
+				break
+			}
+		}
+
+		conn.Close()
+	}
+}
+
+func TestNetwork(t *testing.T) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer l.Close()
+
+	go serveSwitch(t, l, 3) // version query, then two requests
+
+	s, err := NewSwitch("tcp")
+	assert.NoError(t, err)
+
+	err = s.Open(l.Addr().String(), 0, time.Second)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.SetDUT2())
+	assert.Equal(t, "dut2", s.Get())
+
+	is, err := s.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "dut2", is)
+
+	// the controller hangs up, so the request fails, but the next one reconnects
+	err = s.SetThru()
+	assert.Error(t, err)
+
+	assert.NoError(t, s.SetThru())
+	assert.Equal(t, "thru", s.Get())
+	assert.Equal(t, 2, s.(*RFUSB).protocol)
+
+	assert.NoError(t, s.Close())
+
+	// nothing listening
+	err = NewNetwork("tcp").Open("127.0.0.1:1", 0, time.Second)
+	assert.Error(t, err)
+}
+
+func TestNetworkUDP(t *testing.T) {
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer pc.Close()
+
+	go func() {
+		position := "short"
+		received := 0
+		respond := switchV2(0, &position, &received)
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			for _, reply := range respond(buf[:n]) {
+				_, _ = pc.WriteTo([]byte(reply), addr)
+			}
+		}
+	}()
+
+	s := NewNetwork("udp")
+
+	err = s.Open(pc.LocalAddr().String(), 0, time.Second)
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.SetDUT4())
+
+	is, err := s.QueryPort(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "dut4", is)
+	assert.NoError(t, s.Close())
+}
+
+func TestParseHex(t *testing.T) {
+
+	image, err := ParseHex(":0400000001020304F2\r\n:02001000AABB89\r\n:00000001FF\r\n")
+	assert.NoError(t, err)
+	assert.Equal(t, 18, len(image))
+	assert.Equal(t, []byte{1, 2, 3, 4, 0xff}, image[:5])
+	assert.Equal(t, []byte{0xaa, 0xbb}, image[16:])
+
+	for _, bad := range []string{
+		":0400000001020304F2\n",              // cut short
+		":0400000001020304F3\n:00000001FF\n", // checksum
+		"0400000001020304F2\n:00000001FF\n",  // colon
+		":04000000010203F2\n:00000001FF\n",   // length
+		":00000001FF\n",                      // empty
+		":02FF0000AABB99\n:00000001FF\n",     // beyond the bootloader
+		":00000001FF\n:0400000001020304F2\n", // after the end
+		":0400000601020304EC\n:00000001FF\n", // record type
+	} {
+		_, err = ParseHex(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+// fakeBootloader returns a responder that acts as optiboot, programming flash, and
+// garbling the first sync replies, as the bootloader is still starting
+func fakeBootloader(flash []byte, garble int, corrupt bool) func([]byte) []string {
+
+	addr := 0
+
+	return func(req []byte) []string {
+
+		ok := string([]byte{stkInSync, stkOK})
+
+		if len(req) == 0 || req[len(req)-1] != crcEOP {
+			return nil
+		}
+
+		switch req[0] {
+		case stkGetSync:
+			if garble > 0 {
+				garble--
+				return []string{"\x00"}
+			}
+			return []string{ok}
+		case stkEnterProgmode, stkLeaveProgmode:
+			return []string{ok}
+		case stkLoadAddress:
+			addr = 2 * (int(req[1]) + int(req[2])<<8)
+			return []string{ok}
+		case stkProgPage:
+			n := int(req[1])<<8 + int(req[2])
+			copy(flash[addr:addr+n], req[4:4+n])
+			if corrupt {
+				flash[addr] ^= 0xff
+			}
+			return []string{ok}
+		case stkReadPage:
+			n := int(req[1])<<8 + int(req[2])
+			return []string{string([]byte{stkInSync}) + string(flash[addr:addr+n]) + string([]byte{stkOK})}
+		}
+
+		return []string{string([]byte{0x12})}
+	}
+}
+
+func TestFlash(t *testing.T) {
+
+	image := make([]byte, 3*PageSize+10)
+
+	for i := range image {
+		image[i] = byte(i)
+	}
+
+	flash := bytes.Repeat([]byte{0xff}, MaxImage)
+
+	err := program(context.Background(), &fakePort{respond: fakeBootloader(flash, 2, false)}, image)
+	assert.NoError(t, err)
+	assert.Equal(t, image, flash[:len(image)])
+	assert.Equal(t, bytes.Repeat([]byte{0xff}, PageSize-10), flash[len(image):4*PageSize])
+
+	// a page that does not read back as written
+	err = program(context.Background(), &fakePort{respond: fakeBootloader(flash, 0, true)}, image)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "did not verify")
+
+	// a bootloader that never answers
+	err = program(context.Background(), &fakePort{}, image)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no reply from the bootloader")
+
+	assert.Error(t, program(context.Background(), &fakePort{}, nil))
+
+	// only a switch on a serial port, which has been opened, can be flashed
+	assert.Error(t, NewNetwork("tcp").Flash(context.Background(), image, 0))
+	assert.Error(t, NewRFUSB().Flash(context.Background(), image, 0))
+
+	// the bootloader is opened at the baud given, and the old port closed
+	old := &fakePort{}
+	r := NewRFUSB()
+	r.device = "/dev/ttyUSB9"
+	r.sp = old
+	r.port = "dut1"
+	r.boot = func(device string, baud int) (Conn, error) {
+		assert.Equal(t, "/dev/ttyUSB9", device)
+		assert.Equal(t, 57600, baud)
+		return nil, errors.New("no such port")
+	}
+	err = r.Flash(context.Background(), image, 57600)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "bootloader")
+	assert.True(t, old.closed)
+	assert.Equal(t, "unknown", r.Get())
+}
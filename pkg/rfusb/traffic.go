@@ -0,0 +1,217 @@
+package rfusb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/logring"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultTraffic is the number of reads and writes kept in memory while the traffic
+// to and from the switch controller is recorded, if no size is given
+const DefaultTraffic = 500
+
+// Sent, Received and Drained say which way the bytes in each line of traffic went.
+// Drained bytes were received, but thrown away as stale before a request was sent.
+const (
+	Sent     = "tx"
+	Received = "rx"
+	Drained  = "drained"
+)
+
+// Recorder is a switch that talks to its controller over a serial or network link,
+// whose traffic can be recorded to diagnose the link
+type Recorder interface {
+	SetTraffic(t *Traffic)
+}
+
+// Traffic records each read from, and write to, the switch controller, as a line
+// with the time, which way it went, and the bytes in hex and ascii, so that a partial
+// or stale message can be seen as it was, rather than as the drain and the scanner
+// leave it. Nothing is recorded until it is started. The lines are kept in memory,
+// and appended to a file too, if there is one. It is safe to use from more than one
+// goroutine, and a nil Traffic records nothing.
+type Traffic struct {
+	mu        sync.Mutex
+	recording bool
+	size      int
+	ring      *logring.Ring
+	file      string // empty to keep the lines in memory only
+	failed    bool   // the last write to the file failed, so the next failure is not logged
+}
+
+// NewTraffic returns a recorder that keeps the last size lines in memory,
+// DefaultTraffic if size is not positive
+func NewTraffic(size int) *Traffic {
+
+	if size <= 0 {
+		size = DefaultTraffic
+	}
+
+	return &Traffic{
+		size: size,
+		ring: logring.New(size),
+	}
+}
+
+// SetRecording starts or stops recording
+func (t *Traffic) SetRecording(on bool) {
+
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if on != t.recording {
+		log.WithFields(log.Fields{"recording": on, "file": t.file}).Warn("switch traffic recording changed")
+	}
+
+	t.recording = on
+}
+
+// Recording returns true if the traffic is being recorded
+func (t *Traffic) Recording() bool {
+
+	if t == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.recording
+}
+
+// SetFile sets the file that the lines are appended to as well, empty for none.
+// The file is opened for each line, so that it can be rotated while recording.
+func (t *Traffic) SetFile(path string) {
+
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.file = path
+	t.failed = false
+}
+
+// File returns the file that the lines are appended to, empty if there is none
+func (t *Traffic) File() string {
+
+	if t == nil {
+		return ""
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.file
+}
+
+// Clear drops the lines kept in memory, but not those in the file
+func (t *Traffic) Clear() {
+
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.ring = logring.New(t.size)
+}
+
+// Lines returns the lines kept in memory, oldest first
+func (t *Traffic) Lines() []string {
+
+	if t == nil {
+		return nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.ring.Lines()
+}
+
+// Record adds a line for b, which went the way of dir, if recording
+func (t *Traffic) Record(dir string, b []byte) {
+
+	if t == nil || len(b) == 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.recording {
+		return
+	}
+
+	line := FormatTraffic(time.Now(), dir, b)
+
+	t.ring.Add(line)
+
+	if t.file == "" {
+		return
+	}
+
+	err := appendLine(t.file, line)
+
+	if err != nil && !t.failed {
+		log.WithFields(log.Fields{"file": t.file, "error": err.Error()}).Error("could not write switch traffic to file, keeping it in memory only")
+	}
+
+	t.failed = err != nil
+}
+
+// appendLine adds line to the end of the file at path
+func appendLine(path, line string) error {
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(line + "\n")
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// FormatTraffic returns the line recorded for b, which went the way of dir at at,
+// e.g. 2023-01-09T10:00:00.000123Z tx 3 7b 7d 0a |{}.| with the bytes that are not
+// printable shown as dots in the ascii
+func FormatTraffic(at time.Time, dir string, b []byte) string {
+
+	var h, a strings.Builder
+
+	for i, c := range b {
+
+		if i > 0 {
+			h.WriteByte(' ')
+		}
+
+		fmt.Fprintf(&h, "%02x", c)
+
+		if c >= 0x20 && c < 0x7f {
+			a.WriteByte(c)
+		} else {
+			a.WriteByte('.')
+		}
+	}
+
+	return fmt.Sprintf("%s %s %d %s |%s|", at.UTC().Format("2006-01-02T15:04:05.000000Z"), dir, len(b), h.String(), a.String())
+}
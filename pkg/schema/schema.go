@@ -0,0 +1,447 @@
+// package schema describes the JSON of the requests and responses in pkg/pocket
+// as JSON Schemas, found from the Go types, and checks requests against them so
+// that a client is told exactly which field is wrong, rather than it being ignored.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Draft is the JSON Schema dialect of the schemas
+const Draft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is the subset of JSON Schema needed to describe the pocket types.
+// An empty Type allows any value. null is allowed for every field, as it is
+// when the JSON is unmarshalled.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+}
+
+// Errors lists what is wrong with each field of a request, one per item
+type Errors []string
+
+func (e Errors) Error() string {
+	return strings.Join(e, "; ")
+}
+
+var (
+	cache       sync.Map // reflect.Type -> *Schema
+	rawMessage  = reflect.TypeOf(json.RawMessage{})
+	unmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+)
+
+// For returns the schema of the JSON that v is marshalled to, and unmarshalled from
+func For(v interface{}) *Schema {
+
+	t := reflect.TypeOf(v)
+
+	if s, ok := cache.Load(t); ok {
+		return s.(*Schema)
+	}
+
+	s := of(t, make(map[reflect.Type]bool))
+	s.Schema = Draft
+
+	if t != nil {
+		s.Title = t.Name()
+	}
+
+	cache.Store(t, s)
+
+	return s
+}
+
+// of returns the schema of type t. Types already being described higher up
+// (i.e. recursive ones) are allowed to be anything.
+func of(t reflect.Type, seen map[reflect.Type]bool) *Schema {
+
+	if t == nil || seen[t] {
+		return &Schema{}
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t == rawMessage || reflect.PtrTo(t).Implements(unmarshaler) {
+		return &Schema{} // can't tell what it accepts
+	}
+
+	switch t.Kind() {
+
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+
+	case reflect.String:
+		return &Schema{Type: "string"}
+
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+
+	case reflect.Int, reflect.Int64:
+		return &Schema{Type: "integer"}
+
+	case reflect.Int8, reflect.Int16, reflect.Int32:
+		bits := t.Bits() - 1
+		return &Schema{Type: "integer", Minimum: bound(-math.Exp2(float64(bits))), Maximum: bound(math.Exp2(float64(bits)) - 1)}
+
+	case reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Minimum: bound(0)}
+
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Minimum: bound(0), Maximum: bound(math.Exp2(float64(t.Bits())) - 1)}
+
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string"} // base64
+		}
+		seen[t] = true
+		defer delete(seen, t)
+		return &Schema{Type: "array", Items: of(t.Elem(), seen)}
+
+	case reflect.Map:
+		return &Schema{Type: "object"}
+
+	case reflect.Struct:
+		seen[t] = true
+		defer delete(seen, t)
+		// other fields are allowed, as encoding/json ignores them, but see check
+		s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+		fields(t, s.Properties, seen)
+		return s
+	}
+
+	return &Schema{}
+}
+
+// fields adds the properties of struct t to p, following the encoding/json rules:
+// the fields of an embedded struct are promoted, unless one of the same name is
+// less deeply embedded, and fields that are unexported, or tagged "-", are left out
+func fields(t reflect.Type, p map[string]*Schema, seen map[reflect.Type]bool) {
+
+	var embedded []reflect.Type
+
+	for i := 0; i < t.NumField(); i++ {
+
+		f := t.Field(i)
+
+		tag := f.Tag.Get("json")
+
+		if tag == "-" {
+			continue
+		}
+
+		name, _, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			embedded = append(embedded, ft)
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		p[name] = of(f.Type, seen)
+	}
+
+	for _, e := range embedded {
+
+		promoted := make(map[string]*Schema)
+
+		fields(e, promoted, seen)
+
+		for name, s := range promoted {
+			if _, ok := p[name]; !ok {
+				p[name] = s
+			}
+		}
+	}
+}
+
+func bound(f float64) *float64 {
+	return &f
+}
+
+// Validate checks the JSON in data against the schema, and returns Errors
+// describing every field that is wrong, or nil if there are none
+func (s *Schema) Validate(data []byte) error {
+
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber() // keep the digits, so whole numbers can be told apart
+
+	var v interface{}
+
+	err := d.Decode(&v)
+
+	if err != nil {
+		return Errors{"request is not valid JSON because " + err.Error()}
+	}
+
+	var errs Errors
+
+	s.check("request", v, &errs)
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// check adds to errs what is wrong with the value v at path
+func (s *Schema) check(path string, v interface{}, errs *Errors) {
+
+	if v == nil || s.Type == "" {
+		return
+	}
+
+	wrong := func() {
+		*errs = append(*errs, fmt.Sprintf("%s must be %s, not %s", path, s.describe(), show(v)))
+	}
+
+	switch s.Type {
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			wrong()
+		}
+
+	case "string":
+		if _, ok := v.(string); !ok {
+			wrong()
+		}
+
+	case "number", "integer":
+
+		n, ok := v.(json.Number)
+
+		if !ok {
+			wrong()
+			return
+		}
+
+		if s.Type == "integer" && strings.ContainsAny(n.String(), ".eE") {
+			wrong()
+			return
+		}
+
+		f, err := strconv.ParseFloat(n.String(), 64)
+
+		if err != nil || (s.Minimum != nil && f < *s.Minimum) || (s.Maximum != nil && f > *s.Maximum) {
+			wrong()
+		}
+
+	case "array":
+
+		a, ok := v.([]interface{})
+
+		if !ok {
+			wrong()
+			return
+		}
+
+		if s.Items == nil {
+			return
+		}
+
+		for i, item := range a {
+			s.Items.check(fmt.Sprintf("%s[%d]", path, i), item, errs)
+		}
+
+	case "object":
+
+		o, ok := v.(map[string]interface{})
+
+		if !ok {
+			wrong()
+			return
+		}
+
+		keys := make([]string, 0, len(o))
+
+		for k := range o {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+
+			name := k
+
+			if path != "request" {
+				name = path + "." + k
+			}
+
+			p := s.property(k)
+
+			if p == nil {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					*errs = append(*errs, fmt.Sprintf("%s is not a known field", name))
+				} else if m := Suggest(k, s.names()); m != "" {
+					// most likely a typo, which would otherwise be silently ignored
+					*errs = append(*errs, fmt.Sprintf("%s is not a known field, did you mean %s?", name, m))
+				}
+				continue
+			}
+
+			p.check(name, o[k], errs)
+		}
+	}
+}
+
+// names returns the names of the properties, in order
+func (s *Schema) names() []string {
+
+	n := make([]string, 0, len(s.Properties))
+
+	for k := range s.Properties {
+		n = append(n, k)
+	}
+
+	sort.Strings(n)
+
+	return n
+}
+
+// Suggest returns the name in known that name is most likely a misspelling of,
+// ignoring case, or an empty string if none of them is close enough
+func Suggest(name string, known []string) string {
+
+	name = strings.ToLower(name)
+
+	// up to one edit in three characters, and no more than two, otherwise
+	// short names are near everything
+	best, most := "", min(2, len(name)/3)
+
+	for _, k := range known {
+		if d := distance(name, strings.ToLower(k)); d <= most && (best == "" || d < distance(name, strings.ToLower(best))) {
+			best = k
+		}
+	}
+
+	return best
+}
+
+// distance is the number of single character insertions, deletions, substitutions
+// and swaps of neighbours needed to turn a into b
+func distance(a, b string) int {
+
+	x, y := []rune(a), []rune(b)
+
+	d := make([][]int, len(x)+1)
+
+	for i := range d {
+		d[i] = make([]int, len(y)+1)
+		d[i][0] = i
+	}
+
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(x); i++ {
+		for j := 1; j <= len(y); j++ {
+
+			cost := 1
+
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(x)][len(y)]
+}
+
+// property returns the schema of the field k, matching its case if possible
+// but otherwise ignoring it, as encoding/json does, or nil if there is no such field
+func (s *Schema) property(k string) *Schema {
+
+	if p, ok := s.Properties[k]; ok {
+		return p
+	}
+
+	for name, p := range s.Properties {
+		if strings.EqualFold(name, k) {
+			return p
+		}
+	}
+
+	return nil
+}
+
+// describe says what the schema allows, for error messages
+func (s *Schema) describe() string {
+
+	switch s.Type {
+	case "boolean":
+		return "true or false"
+	case "string":
+		return "a string"
+	case "number":
+		return "a number"
+	case "integer":
+		switch {
+		case s.Minimum != nil && s.Maximum != nil:
+			return fmt.Sprintf("a whole number from %g to %g", *s.Minimum, *s.Maximum)
+		case s.Minimum != nil:
+			return fmt.Sprintf("a whole number of at least %g", *s.Minimum)
+		}
+		return "a whole number"
+	case "array":
+		return "a list"
+	case "object":
+		return "an object"
+	}
+
+	return "anything"
+}
+
+// show gives a short version of the value for error messages
+func show(v interface{}) string {
+
+	b, err := json.Marshal(v)
+
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+
+	if len(b) > 32 {
+		return string(b[:29]) + "..."
+	}
+
+	return string(b)
+}
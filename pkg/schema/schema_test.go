@@ -0,0 +1,90 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFor(t *testing.T) {
+
+	s := For(pocket.RangeQuery{})
+
+	assert.Equal(t, Draft, s.Schema)
+	assert.Equal(t, "RangeQuery", s.Title)
+	assert.Equal(t, "object", s.Type)
+	assert.Nil(t, s.AdditionalProperties) // other fields are ignored
+
+	// promoted from the embedded Command
+	assert.Equal(t, "string", s.Properties["cmd"].Type)
+	assert.Equal(t, "integer", s.Properties["t"].Type)
+
+	assert.Equal(t, "integer", s.Properties["range"].Properties["start"].Type)
+	assert.Equal(t, 0.0, *s.Properties["range"].Properties["start"].Minimum)
+	assert.Equal(t, 65535.0, *s.Properties["avg"].Maximum)
+	assert.Equal(t, "boolean", s.Properties["islog"].Type)
+	assert.Equal(t, "number", s.Properties["result"].Items.Properties["s11"].Properties["real"].Type)
+
+	// the shallower field wins
+	sw := For(pocket.Sweep{})
+	assert.Equal(t, "number", sw.Properties["interval"].Type)
+	assert.Equal(t, "string", sw.Properties["what"].Type)
+
+	b := For(pocket.Batch{})
+	assert.Nil(t, b.Properties["Requests"])
+	assert.Equal(t, "", b.Properties["commands"].Items.Type)
+
+	// can be sent to clients
+	_, err := json.Marshal(s)
+	assert.NoError(t, err)
+}
+
+func TestValidate(t *testing.T) {
+
+	s := For(pocket.RangeQuery{})
+
+	assert.NoError(t, s.Validate([]byte(`{"cmd":"rq","id":"a","range":{"start":100000,"end":4000000000},"size":2,"avg":1,"sparam":{"s11":true},"what":"dut1","segments":null}`)))
+
+	// encoding/json ignores the case of field names
+	assert.NoError(t, s.Validate([]byte(`{"cmd":"rq","Range":{"Start":1}}`)))
+
+	err := s.Validate([]byte(`{"cmd":"rq","range":{"start":-1,"end":"4e9"},"size":2.5,"avg":70000,"sparam":{"s11":"yes"},"whta":"dut1","segments":[{"size":3},{"size":"x"}]}`))
+
+	errs, ok := err.(Errors)
+	assert.True(t, ok)
+	assert.Equal(t, Errors{
+		"avg must be a whole number from 0 to 65535, not 70000",
+		"range.end must be a whole number of at least 0, not \"4e9\"",
+		"range.start must be a whole number of at least 0, not -1",
+		"segments[1].size must be a whole number, not \"x\"",
+		"size must be a whole number, not 2.5",
+		"sparam.s11 must be true or false, not \"yes\"",
+		"whta is not a known field, did you mean what?",
+	}, errs)
+
+	// other fields are ignored, as they are by encoding/json
+	assert.NoError(t, s.Validate([]byte(`{"cmd":"rq","what":"dut1","client":"lab3","x":1}`)))
+
+	assert.Error(t, s.Validate([]byte(`{"cmd":`)))
+	assert.Error(t, s.Validate([]byte(`[]`)))
+}
+
+func TestSuggest(t *testing.T) {
+
+	known := []string{"rq", "rangequery", "rc", "rangecal", "crq", "sweeps", "format"}
+
+	assert.Equal(t, "rangecal", Suggest("rangcal", known))
+	assert.Equal(t, "rangecal", Suggest("RangeCla", known))
+	assert.Equal(t, "format", Suggest("fromat", known))
+	assert.Equal(t, "sweeps", Suggest("sweep", known))
+	assert.Equal(t, "", Suggest("rx", known))
+	assert.Equal(t, "", Suggest("x", known))
+	assert.Equal(t, "", Suggest("nope", known))
+	assert.Equal(t, "", Suggest("", known))
+
+	assert.Equal(t, 0, distance("", ""))
+	assert.Equal(t, 1, distance("ab", "ba"))
+	assert.Equal(t, 3, distance("kitten", "sitting"))
+}
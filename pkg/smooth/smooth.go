@@ -0,0 +1,208 @@
+// package smooth smooths a trace over an aperture of neighbouring points, to
+// reduce the trace noise of a calibrated result at the expense of resolution
+package smooth
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+const (
+	Mean          = "mean" // moving average
+	SavitzkyGolay = "sg"   // least squares fit of a polynomial to each aperture
+)
+
+// DefaultOrder is the order of the polynomial fitted by sg if none is given
+const DefaultOrder = 2
+
+// Check returns an error if s is not a valid smoothing of a trace of n points,
+// or of any size if n is zero
+func Check(s pocket.Smoothing, n int) error {
+
+	switch strings.ToLower(s.Method) {
+	case Mean, SavitzkyGolay:
+	default:
+		return fmt.Errorf("unknown smoothing method %s, use mean or sg", s.Method)
+	}
+
+	if s.Aperture < 3 || s.Aperture%2 == 0 {
+		return fmt.Errorf("smoothing aperture must be an odd number of points, at least 3, not %d", s.Aperture)
+	}
+
+	if n > 0 && s.Aperture > n {
+		return fmt.Errorf("smoothing aperture of %d points is wider than the %d points of the trace", s.Aperture, n)
+	}
+
+	if strings.ToLower(s.Method) == Mean {
+		if s.Order != 0 {
+			return errors.New("smoothing order is only used with sg")
+		}
+		return nil
+	}
+
+	if s.Order < 0 || s.Order >= s.Aperture-1 {
+		return fmt.Errorf("smoothing order must be from 1 to %d for an aperture of %d points, not %d", s.Aperture-2, s.Aperture, s.Order)
+	}
+
+	return nil
+}
+
+// Apply returns a copy of trace with the real and imaginary parts of each
+// S-parameter smoothed with s, and s as it was applied, with the default order
+// filled in. Each point is smoothed over the aperture centred on it, or at the
+// ends, over the first or last aperture of points.
+func Apply(s pocket.Smoothing, trace []pocket.SParam) ([]pocket.SParam, pocket.Smoothing, error) {
+
+	s.Method = strings.ToLower(s.Method)
+
+	// as high as the aperture allows, if that is less than the default
+	if s.Method == SavitzkyGolay && s.Order == 0 {
+		s.Order = DefaultOrder
+		if s.Order > s.Aperture-2 {
+			s.Order = s.Aperture - 2
+		}
+	}
+
+	err := Check(s, len(trace))
+
+	if err != nil {
+		return nil, s, err
+	}
+
+	get := []func(*pocket.SParam) *pocket.Complex{
+		func(v *pocket.SParam) *pocket.Complex { return &v.S11 },
+		func(v *pocket.SParam) *pocket.Complex { return &v.S12 },
+		func(v *pocket.SParam) *pocket.Complex { return &v.S21 },
+		func(v *pocket.SParam) *pocket.Complex { return &v.S22 },
+	}
+
+	n := len(trace)
+	half := s.Aperture / 2
+
+	result := make([]pocket.SParam, n)
+	copy(result, trace)
+
+	re := make([]float64, s.Aperture)
+	im := make([]float64, s.Aperture)
+
+	for i := 0; i < n; i++ {
+
+		// the aperture, kept within the trace
+		lo := i - half
+
+		if lo < 0 {
+			lo = 0
+		}
+
+		if lo+s.Aperture > n {
+			lo = n - s.Aperture
+		}
+
+		w := weights(s.Order, s.Aperture, i-lo)
+
+		for _, g := range get {
+
+			for j := range re {
+				c := g(&trace[lo+j])
+				re[j], im[j] = c.Real, c.Imag
+			}
+
+			*g(&result[i]) = pocket.Complex{Real: dot(w, re), Imag: dot(w, im)}
+		}
+	}
+
+	return result, s, nil
+}
+
+// weights returns the weights of the points of an aperture of m points that give
+// the value at point at of the least squares fit of a polynomial of order k to them
+func weights(k, m, at int) []float64 {
+
+	// normal equations of the fit, A^T A c = A^T y, with A[j][p] = x_j^p,
+	// centred so that the powers stay small
+	x := make([]float64, m)
+
+	for j := range x {
+		x[j] = float64(j - at)
+	}
+
+	ata := make([][]float64, k+1)
+
+	for p := range ata {
+		ata[p] = make([]float64, k+1)
+		for q := range ata[p] {
+			for _, v := range x {
+				ata[p][q] += math.Pow(v, float64(p+q))
+			}
+		}
+	}
+
+	// the value at x = 0 is c[0] = e0^T (A^T A)^-1 A^T y, so the weights are
+	// A (A^T A)^-1 e0, found by solving (A^T A) u = e0
+	u := make([]float64, k+1)
+	u[0] = 1
+	u = solve(ata, u)
+
+	w := make([]float64, m)
+
+	for j, v := range x {
+		for p := range u {
+			w[j] += u[p] * math.Pow(v, float64(p))
+		}
+	}
+
+	return w
+}
+
+// solve solves a x = b by Gaussian elimination with partial pivoting. a is
+// symmetric and positive definite here, as the points of the fit are distinct.
+func solve(a [][]float64, b []float64) []float64 {
+
+	n := len(b)
+
+	for c := 0; c < n; c++ {
+
+		p := c
+
+		for r := c + 1; r < n; r++ {
+			if math.Abs(a[r][c]) > math.Abs(a[p][c]) {
+				p = r
+			}
+		}
+
+		a[c], a[p] = a[p], a[c]
+		b[c], b[p] = b[p], b[c]
+
+		for r := c + 1; r < n; r++ {
+			f := a[r][c] / a[c][c]
+			for q := c; q < n; q++ {
+				a[r][q] -= f * a[c][q]
+			}
+			b[r] -= f * b[c]
+		}
+	}
+
+	x := make([]float64, n)
+
+	for r := n - 1; r >= 0; r-- {
+		v := b[r]
+		for q := r + 1; q < n; q++ {
+			v -= a[r][q] * x[q]
+		}
+		x[r] = v / a[r][r]
+	}
+
+	return x
+}
+
+func dot(a, b []float64) float64 {
+	s := 0.0
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
@@ -0,0 +1,112 @@
+package smooth
+
+import (
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// trace returns n points with S21 from f, and S11 from f doubled, in the imaginary part
+func trace(n int, f func(x float64) float64) []pocket.SParam {
+
+	t := make([]pocket.SParam, n)
+
+	for i := range t {
+		x := float64(i)
+		t[i] = pocket.SParam{
+			Freq: uint64(100e6 + i*1e6),
+			S11:  pocket.Complex{Imag: 2 * f(x)},
+			S21:  pocket.Complex{Real: f(x)},
+		}
+	}
+
+	return t
+}
+
+func TestMean(t *testing.T) {
+
+	// a straight line is unchanged away from the ends, which take the mean of the
+	// first or last three points
+	line := trace(9, func(x float64) float64 { return 3 - 0.5*x })
+
+	got, s, err := Apply(pocket.Smoothing{Method: "MEAN", Aperture: 3}, line)
+	assert.NoError(t, err)
+	assert.Equal(t, pocket.Smoothing{Method: Mean, Aperture: 3}, s)
+
+	for i := 1; i < len(line)-1; i++ {
+		assert.Equal(t, line[i].Freq, got[i].Freq)
+		assert.InDelta(t, line[i].S21.Real, got[i].S21.Real, 1e-9)
+		assert.InDelta(t, line[i].S11.Imag, got[i].S11.Imag, 1e-9)
+	}
+
+	assert.InDelta(t, line[1].S21.Real, got[0].S21.Real, 1e-9)
+	assert.InDelta(t, line[7].S21.Real, got[8].S21.Real, 1e-9)
+
+	// a spike is spread over the aperture
+	spike := trace(5, func(x float64) float64 {
+		if x == 2 {
+			return 3
+		}
+		return 0
+	})
+
+	got, _, err = Apply(pocket.Smoothing{Method: Mean, Aperture: 3}, spike)
+	assert.NoError(t, err)
+
+	for i, want := range []float64{1, 1, 1, 1, 1} {
+		assert.InDelta(t, want, got[i].S21.Real, 1e-9)
+	}
+
+	// the trace given is not changed
+	assert.Equal(t, 3.0, spike[2].S21.Real)
+}
+
+func TestSavitzkyGolay(t *testing.T) {
+
+	// a quadratic is unchanged by the default order
+	q := trace(11, func(x float64) float64 { return 1 + 0.2*x - 0.05*x*x })
+
+	got, s, err := Apply(pocket.Smoothing{Method: SavitzkyGolay, Aperture: 5}, q)
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultOrder, s.Order)
+
+	for i := range q {
+		assert.InDelta(t, q[i].S21.Real, got[i].S21.Real, 1e-9)
+		assert.InDelta(t, q[i].S11.Imag, got[i].S11.Imag, 1e-9)
+	}
+
+	// the usual coefficients of a quadratic over five points, (-3, 12, 17, 12, -3)/35
+	spike := trace(5, func(x float64) float64 {
+		if x == 2 {
+			return 35
+		}
+		return 0
+	})
+
+	got, _, err = Apply(pocket.Smoothing{Method: SavitzkyGolay, Aperture: 5, Order: 2}, spike)
+	assert.NoError(t, err)
+	assert.InDelta(t, 17, got[2].S21.Real, 1e-9)
+
+	// the default order is lowered to fit a narrow aperture
+	_, s, err = Apply(pocket.Smoothing{Method: SavitzkyGolay, Aperture: 3}, spike)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, s.Order)
+}
+
+func TestCheck(t *testing.T) {
+
+	assert.NoError(t, Check(pocket.Smoothing{Method: Mean, Aperture: 3}, 0))
+	assert.NoError(t, Check(pocket.Smoothing{Method: "SG", Aperture: 7, Order: 5}, 7))
+
+	assert.Error(t, Check(pocket.Smoothing{Method: "median", Aperture: 3}, 0))
+	assert.Error(t, Check(pocket.Smoothing{Method: Mean, Aperture: 1}, 0))
+	assert.Error(t, Check(pocket.Smoothing{Method: Mean, Aperture: 4}, 0))
+	assert.Error(t, Check(pocket.Smoothing{Method: Mean, Aperture: 5}, 3))
+	assert.Error(t, Check(pocket.Smoothing{Method: Mean, Aperture: 3, Order: 1}, 0))
+	assert.Error(t, Check(pocket.Smoothing{Method: SavitzkyGolay, Aperture: 5, Order: 4}, 0))
+	assert.Error(t, Check(pocket.Smoothing{Method: SavitzkyGolay, Aperture: 5, Order: -1}, 0))
+
+	_, _, err := Apply(pocket.Smoothing{Method: Mean, Aperture: 5}, trace(3, func(x float64) float64 { return x }))
+	assert.Error(t, err)
+}
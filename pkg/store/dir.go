@@ -0,0 +1,170 @@
+package store
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Dir keeps files in a directory, with the slashes of a key as subdirectories
+type Dir struct {
+	mu   sync.Mutex
+	root string
+}
+
+// NewDir returns a store in the directory root, creating it if there is none
+func NewDir(root string) (*Dir, error) {
+
+	err := os.MkdirAll(root, 0755)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Dir{root: root}, nil
+}
+
+func (d *Dir) path(key string) (string, error) {
+
+	err := checkKey(key)
+
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(d.root, filepath.FromSlash(key)), nil
+}
+
+// Get returns the file with key, or ErrNotFound
+func (d *Dir) Get(key string) ([]byte, error) {
+
+	p, err := d.path(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(p)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	return b, err
+}
+
+// Put writes the file with key, by renaming a temporary file over it, so that a
+// reader never sees half of it
+func (d *Dir) Put(key string, b []byte) error {
+
+	p, err := d.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+
+	if err != nil {
+		return err
+	}
+
+	tmp := p + ".tmp"
+
+	err = os.WriteFile(tmp, b, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, p)
+}
+
+// Append adds b to the end of the file with key, in one write
+func (d *Dir) Append(key string, b []byte) error {
+
+	p, err := d.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(b)
+
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// List returns the keys that start with prefix, in order
+func (d *Dir) List(prefix string) ([]string, error) {
+
+	var keys []string
+
+	err := filepath.WalkDir(d.root, func(p string, e fs.DirEntry, err error) error {
+
+		if err != nil || e.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return err
+		}
+
+		rel, err := filepath.Rel(d.root, p)
+
+		if err != nil {
+			return err
+		}
+
+		key := filepath.ToSlash(rel)
+
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+
+		return nil
+	})
+
+	return sorted(keys), err
+}
+
+// Delete removes the file with key, if there is one
+func (d *Dir) Delete(key string) error {
+
+	p, err := d.path(key)
+
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Close does nothing, as nothing is held open
+func (d *Dir) Close() error {
+	return nil
+}
@@ -0,0 +1,614 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// S3 keeps files as objects in a bucket of an S3-compatible service, e.g. AWS, MinIO
+// or Ceph, using path-style requests signed with AWS Signature Version 4. S3 has no
+// append, so each append is kept as an object of its own, key/part-00000001 and so on,
+// and the parts of a key are joined in order when it is read, as the SQL store does
+// with rows, so that appending to a long file, such as the audit log, does not rewrite
+// it. The parts are numbered by the rig that appends them, so give each rig a prefix
+// of its own.
+type S3 struct {
+	mu       sync.Mutex
+	Endpoint string // e.g. https://s3.eu-west-2.amazonaws.com or http://minio:9000
+	Region   string
+	Bucket   string
+	Prefix   string // added to the start of every key, without a trailing slash
+	Key      string // access key id
+	Secret   string // secret access key
+	Client   *http.Client
+	now      func() time.Time
+	parts    map[string]int // the number of parts of each key appended to, once known
+}
+
+// S3Timeout limits each request to the service
+const S3Timeout = 30 * time.Second
+
+// NewS3FromURL returns a store for a URL of the form given to Open, with the
+// credentials from the environment
+func NewS3FromURL(u *url.URL) (*S3, error) {
+
+	key, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+
+	if key == "" || secret == "" {
+		return nil, errors.New("set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to use an s3 store")
+	}
+
+	region := u.Query().Get("region")
+
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3{
+		Endpoint: strings.TrimSuffix(u.Query().Get("endpoint"), "/"),
+		Region:   region,
+		Bucket:   u.Host,
+		Prefix:   strings.Trim(u.Path, "/"),
+		Key:      key,
+		Secret:   secret,
+		Client:   &http.Client{Timeout: S3Timeout},
+	}, nil
+}
+
+// partPrefix starts the name of each part of a key that has been appended to, after
+// the key and a slash
+const partPrefix = "part-"
+
+// part is the name of the nth part of key, without the prefix
+func part(key string, n int) string {
+	return fmt.Sprintf("%s/%s%08d", key, partPrefix, n)
+}
+
+// partOf returns the key that name is a part of, and the number of the part, or false
+// if it is not a part
+func partOf(name string) (string, int, bool) {
+
+	i := strings.LastIndex(name, "/"+partPrefix)
+
+	if i < 0 {
+		return "", 0, false
+	}
+
+	digits := name[i+1+len(partPrefix):]
+
+	if len(digits) != 8 {
+		return "", 0, false
+	}
+
+	n := 0
+
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return "", 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+
+	return name[:i], n, true
+}
+
+// checkS3Key returns an error if key cannot be kept in S3, including a key that would
+// be taken for a part of another
+func checkS3Key(key string) error {
+
+	err := checkKey(key)
+
+	if err != nil {
+		return err
+	}
+
+	if _, _, ok := partOf(key); ok {
+		return fmt.Errorf("key must not end in /%sNNNNNNNN, which is kept for appends, not %s", partPrefix, key)
+	}
+
+	return nil
+}
+
+// object is the name of the object for key, with the prefix
+func (s *S3) object(key string) string {
+	if s.Prefix == "" {
+		return key
+	}
+	return s.Prefix + "/" + key
+}
+
+// Get returns the file with key, or ErrNotFound, joining the parts that have been
+// appended to it, if any, in order
+func (s *S3) Get(key string) ([]byte, error) {
+
+	err := checkS3Key(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := s.get(key)
+
+	found := err == nil
+
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+
+	parts, err := s.partsOf(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range parts {
+
+		pb, err := s.get(p)
+
+		if err != nil {
+			return nil, err
+		}
+
+		b = append(b, pb...)
+		found = true
+	}
+
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return b, nil
+}
+
+func (s *S3) get(key string) ([]byte, error) {
+
+	resp, err := s.do(http.MethodGet, s.object(key), nil, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+
+	b, err := io.ReadAll(resp.Body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, failed("get", key, resp.StatusCode, b)
+	}
+
+	return b, nil
+}
+
+// Put writes the file with key as a single object, replacing any that is there,
+// and any parts appended to it
+func (s *S3) Put(key string, b []byte) error {
+
+	err := checkS3Key(key)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.put(key, b)
+
+	if err != nil {
+		return err
+	}
+
+	return s.deleteParts(key)
+}
+
+func (s *S3) put(key string, b []byte) error {
+
+	resp, err := s.do(http.MethodPut, s.object(key), nil, b)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return failed("put", key, resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// Append adds b to the end of the file with key, as a part of its own, so that only
+// b is sent. The parts of key are listed the first time it is appended to, to number
+// the next, and counted after that.
+func (s *S3) Append(key string, b []byte) error {
+
+	err := checkS3Key(key)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, ok := s.parts[key]
+
+	if !ok {
+
+		parts, err := s.partsOf(key)
+
+		if err != nil {
+			return err
+		}
+
+		if len(parts) > 0 {
+			_, n, _ = partOf(parts[len(parts)-1])
+		}
+	}
+
+	err = s.put(part(key, n+1), b)
+
+	if err != nil {
+		return err
+	}
+
+	if s.parts == nil {
+		s.parts = make(map[string]int)
+	}
+
+	s.parts[key] = n + 1
+
+	return nil
+}
+
+// partsOf returns the names of the parts of key, in order
+func (s *S3) partsOf(key string) ([]string, error) {
+
+	names, err := s.objects(key + "/" + partPrefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []string
+
+	for _, name := range names {
+		if k, _, ok := partOf(name); ok && k == key {
+			parts = append(parts, name)
+		}
+	}
+
+	// the numbers are zero padded, so they sort in order
+	sort.Strings(parts)
+
+	return parts, nil
+}
+
+// deleteParts removes the parts of key, if any, and is called with mu held
+func (s *S3) deleteParts(key string) error {
+
+	parts, err := s.partsOf(key)
+
+	if err != nil {
+		return err
+	}
+
+	for _, p := range parts {
+
+		err = s.delete(p)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	delete(s.parts, key)
+
+	return nil
+}
+
+// listResult is the part of the response to ListObjectsV2 that is used
+type listResult struct {
+	Contents []struct {
+		Key string `xml:"Key"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List returns the keys that start with prefix, in order, with each key that has been
+// appended to listed once, rather than by its parts
+func (s *S3) List(prefix string) ([]string, error) {
+
+	names, err := s.objects(prefix)
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+
+	var keys []string
+
+	for _, name := range names {
+
+		if k, _, ok := partOf(name); ok {
+			name = k
+		}
+
+		if !seen[name] && strings.HasPrefix(name, prefix) {
+			seen[name] = true
+			keys = append(keys, name)
+		}
+	}
+
+	return sorted(keys), nil
+}
+
+// objects returns the names of the objects that start with prefix, without the prefix
+// of the store, following the pages of the listing until there are no more
+func (s *S3) objects(prefix string) ([]string, error) {
+
+	var keys []string
+
+	token := ""
+
+	for {
+
+		q := map[string]string{
+			"list-type": "2",
+			"prefix":    s.object(prefix),
+		}
+
+		if s.Prefix != "" && prefix == "" {
+			q["prefix"] = s.Prefix + "/"
+		}
+
+		if token != "" {
+			q["continuation-token"] = token
+		}
+
+		resp, err := s.do(http.MethodGet, "", q, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, failed("list", prefix, resp.StatusCode, b)
+		}
+
+		var r listResult
+
+		err = xml.Unmarshal(b, &r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range r.Contents {
+			key := c.Key
+			if s.Prefix != "" {
+				key = strings.TrimPrefix(key, s.Prefix+"/")
+			}
+			keys = append(keys, key)
+		}
+
+		if !r.IsTruncated || r.NextContinuationToken == "" {
+			break
+		}
+
+		token = r.NextContinuationToken
+	}
+
+	return keys, nil
+}
+
+// Delete removes the file with key, and any parts appended to it, if there is one
+func (s *S3) Delete(key string) error {
+
+	err := checkS3Key(key)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = s.delete(key)
+
+	if err != nil {
+		return err
+	}
+
+	return s.deleteParts(key)
+}
+
+func (s *S3) delete(key string) error {
+
+	resp, err := s.do(http.MethodDelete, s.object(key), nil, nil)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotFound {
+		body, _ := io.ReadAll(resp.Body)
+		return failed("delete", key, resp.StatusCode, body)
+	}
+
+	return nil
+}
+
+// Close does nothing, as nothing is held open
+func (s *S3) Close() error {
+	return nil
+}
+
+func failed(op, key string, status int, body []byte) error {
+
+	msg := strings.TrimSpace(string(body))
+
+	if len(msg) > 200 {
+		msg = msg[:200]
+	}
+
+	return fmt.Errorf("could not %s %s because the store returned %d %s", op, key, status, msg)
+}
+
+// do makes a signed request for object, which is empty for the bucket itself
+func (s *S3) do(method, object string, query map[string]string, body []byte) (*http.Response, error) {
+
+	now := time.Now
+
+	if s.now != nil {
+		now = s.now
+	}
+
+	path := "/" + escape(s.Bucket, false)
+
+	if object != "" {
+		path += "/" + escape(object, true)
+	}
+
+	req, err := http.NewRequest(method, s.Endpoint+path, bytes.NewReader(body))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// exactly as it was signed
+	req.URL.RawPath = path
+	req.URL.RawQuery = canonicalQuery(query)
+
+	s.sign(req, path, body, now().UTC())
+
+	client := s.Client
+
+	if client == nil {
+		client = &http.Client{Timeout: S3Timeout}
+	}
+
+	return client.Do(req)
+}
+
+// sign adds the headers of AWS Signature Version 4 to req, which has the escaped path
+func (s *S3) sign(req *http.Request, path string, body []byte, t time.Time) {
+
+	date := t.Format("20060102")
+	stamp := t.Format("20060102T150405Z")
+
+	sum := sha256.Sum256(body)
+	payload := hex.EncodeToString(sum[:])
+
+	req.Header.Set("x-amz-date", stamp)
+	req.Header.Set("x-amz-content-sha256", payload)
+
+	signed := "host;x-amz-content-sha256;x-amz-date"
+
+	canonical := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payload,
+		"x-amz-date:" + stamp,
+		"",
+		signed,
+		payload,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+
+	cs := sha256.Sum256([]byte(canonical))
+
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(cs[:])
+
+	k := hmacSHA256([]byte("AWS4"+s.Secret), date)
+	k = hmacSHA256(k, s.Region)
+	k = hmacSHA256(k, "s3")
+	k = hmacSHA256(k, "aws4_request")
+
+	signature := hex.EncodeToString(hmacSHA256(k, toSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.Key+"/"+scope+", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery is the query sorted by name, with names and values escaped
+func canonicalQuery(query map[string]string) string {
+
+	names := make([]string, 0, len(query))
+
+	for n := range query {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	parts := make([]string, len(names))
+
+	for i, n := range names {
+		parts[i] = escape(n, false) + "=" + escape(query[n], false)
+	}
+
+	return strings.Join(parts, "&")
+}
+
+// escape percent-encodes everything but the unreserved characters, and the slashes
+// too, unless keepSlash, as Signature Version 4 requires
+func escape(s string, keepSlash bool) string {
+
+	var b strings.Builder
+
+	for _, c := range []byte(s) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,231 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// SQL keeps files as rows of a table, files, in a database. An append is kept as a
+// row of its own, and the rows of a key are joined in order when it is read, so that
+// appending to a long file, such as the audit log, does not rewrite it.
+type SQL struct {
+	mu sync.Mutex
+	db *sql.DB
+}
+
+// SQLiteDriver is the name of the database/sql driver used for sqlite:// stores
+const SQLiteDriver = "sqlite3"
+
+// OpenSQLite opens the SQLite database at path, creating it if there is none. A
+// driver must be registered as SQLiteDriver, e.g. by building with an import of one.
+func OpenSQLite(path string) (*SQL, error) {
+
+	if !hasSQLite() {
+		return nil, fmt.Errorf("cannot open %s because there is no %s driver built in", path, SQLiteDriver)
+	}
+
+	db, err := sql.Open(SQLiteDriver, path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewSQL(db)
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// hasSQLite returns true if a driver is registered as SQLiteDriver
+func hasSQLite() bool {
+
+	for _, d := range sql.Drivers() {
+		if d == SQLiteDriver {
+			return true
+		}
+	}
+
+	return false
+}
+
+// NewSQL returns a store in db, creating its table if there is none. The SQL is plain
+// enough for SQLite, PostgreSQL and MySQL, apart from the placeholders, which are ?.
+func NewSQL(db *sql.DB) (*SQL, error) {
+
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS files (
+		k VARCHAR(1024) NOT NULL,
+		part INTEGER NOT NULL,
+		data BLOB NOT NULL,
+		PRIMARY KEY (k, part))`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &SQL{db: db}, nil
+}
+
+// Get returns the file with key, or ErrNotFound
+func (s *SQL) Get(key string) ([]byte, error) {
+
+	err := checkKey(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT data FROM files WHERE k = ? ORDER BY part`, key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var b []byte
+	found := false
+
+	for rows.Next() {
+
+		var part []byte
+
+		err = rows.Scan(&part)
+
+		if err != nil {
+			return nil, err
+		}
+
+		b = append(b, part...)
+		found = true
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return b, nil
+}
+
+// Put writes the file with key as a single row, replacing any that is there
+func (s *SQL) Put(key string, b []byte) error {
+
+	err := checkKey(key)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx, err := s.db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM files WHERE k = ?`, key)
+
+	if err == nil {
+		_, err = tx.Exec(`INSERT INTO files (k, part, data) VALUES (?, 0, ?)`, key, b)
+	}
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Append adds b to the end of the file with key, as a row of its own
+func (s *SQL) Append(key string, b []byte) error {
+
+	err := checkKey(key)
+
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var last sql.NullInt64
+
+	err = s.db.QueryRow(`SELECT MAX(part) FROM files WHERE k = ?`, key).Scan(&last)
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	part := int64(0)
+
+	if last.Valid {
+		part = last.Int64 + 1
+	}
+
+	_, err = s.db.Exec(`INSERT INTO files (k, part, data) VALUES (?, ?, ?)`, key, part, b)
+
+	return err
+}
+
+// List returns the keys that start with prefix, in order
+func (s *SQL) List(prefix string) ([]string, error) {
+
+	rows, err := s.db.Query(`SELECT DISTINCT k FROM files ORDER BY k`)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var keys []string
+
+	for rows.Next() {
+
+		var k string
+
+		err = rows.Scan(&k)
+
+		if err != nil {
+			return nil, err
+		}
+
+		// compared here, rather than with LIKE, so that % and _ in a prefix are not wildcards
+		if len(k) >= len(prefix) && k[:len(prefix)] == prefix {
+			keys = append(keys, k)
+		}
+	}
+
+	return sorted(keys), rows.Err()
+}
+
+// Delete removes the file with key, if there is one
+func (s *SQL) Delete(key string) error {
+
+	err := checkKey(key)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = s.db.Exec(`DELETE FROM files WHERE k = ?`, key)
+
+	return err
+}
+
+// Close closes the database
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,125 @@
+// package store keeps files, such as exported calibrations and the audit log, in a
+// directory, an SQL database, e.g. SQLite, or an S3-compatible bucket, so that a
+// deployment of many rigs can keep them somewhere central rather than on the SD
+// card of each. A file is named by a key, a path separated by slashes, e.g.
+// cals/cal-20231001T120000Z.zip.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Store keeps files by key. Implementations are safe to use from more than one goroutine.
+type Store interface {
+	// Get returns the file with key, or ErrNotFound
+	Get(key string) ([]byte, error)
+	// Put writes the file with key, replacing any that is there
+	Put(key string, b []byte) error
+	// Append adds b to the end of the file with key, creating it if there is none
+	Append(key string, b []byte) error
+	// List returns the keys that start with prefix, in order
+	List(prefix string) ([]string, error)
+	// Delete removes the file with key, if there is one
+	Delete(key string) error
+	// Close releases anything held by the store
+	Close() error
+}
+
+// ErrNotFound is returned by Get for a key that has no file
+var ErrNotFound = errors.New("not found")
+
+// Open opens the store described by u, one of:
+//
+//	/var/lib/vna, or file:///var/lib/vna             a directory
+//	sqlite:///var/lib/vna/store.db                   an SQLite database
+//	s3://bucket/prefix?endpoint=https://host&region=r  an S3-compatible bucket
+//
+// The credentials for S3 are taken from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+// SQLite needs a database/sql driver registered as sqlite3 to be built in, or it is an error.
+func Open(u string) (Store, error) {
+
+	p, err := parse(u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.Scheme {
+	case "", "file":
+		return NewDir(p.Path)
+	case "sqlite":
+		return OpenSQLite(p.Path)
+	case "s3":
+		return NewS3FromURL(p)
+	}
+
+	// parse has already checked the scheme
+	return nil, fmt.Errorf("unknown store %s", u)
+}
+
+// Check returns an error if u does not describe a store that can be opened, without
+// opening it, e.g. an SQLite database when there is no driver built in
+func Check(u string) error {
+	_, err := parse(u)
+	return err
+}
+
+func parse(u string) (*url.URL, error) {
+
+	p, err := url.Parse(u)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch p.Scheme {
+	case "", "file", "sqlite":
+		if p.Path == "" {
+			return nil, fmt.Errorf("store %s has no path", u)
+		}
+		if p.Scheme == "sqlite" && !hasSQLite() {
+			return nil, fmt.Errorf("store %s cannot be used because there is no %s driver built in", u, SQLiteDriver)
+		}
+	case "s3":
+		if p.Host == "" {
+			return nil, fmt.Errorf("store %s has no bucket", u)
+		}
+		if p.Query().Get("endpoint") == "" {
+			return nil, fmt.Errorf("store %s has no endpoint", u)
+		}
+	default:
+		return nil, fmt.Errorf("store must be a directory, or start with file://, sqlite:// or s3://, not %s", u)
+	}
+
+	return p, nil
+}
+
+// checkKey returns an error if key is not a relative path without . or .. in it,
+// so that a key cannot reach outside the store
+func checkKey(key string) error {
+
+	if key == "" || strings.HasPrefix(key, "/") {
+		return fmt.Errorf("key must be a relative path, not %s", key)
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("key must not have empty, . or .. parts, not %s", key)
+		}
+	}
+
+	return nil
+}
+
+// sorted returns keys in order
+func sorted(keys []string) []string {
+	if keys == nil {
+		keys = []string{}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,249 @@
+package store
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// exercise checks the behaviour that every store shares
+func exercise(t *testing.T, s Store) {
+
+	_, err := s.Get("cals/a.zip")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, s.Put("cals/a.zip", []byte("one")))
+	assert.NoError(t, s.Put("cals/a.zip", []byte("two")))
+
+	b, err := s.Get("cals/a.zip")
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(b))
+
+	assert.NoError(t, s.Append("audit/2023-10-01.jsonl", []byte("{}\n")))
+	assert.NoError(t, s.Append("audit/2023-10-01.jsonl", []byte("[]\n")))
+
+	b, err = s.Get("audit/2023-10-01.jsonl")
+	assert.NoError(t, err)
+	assert.Equal(t, "{}\n[]\n", string(b))
+
+	assert.NoError(t, s.Put("cals/b.zip", []byte("three")))
+
+	keys, err := s.List("cals/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cals/a.zip", "cals/b.zip"}, keys)
+
+	keys, err = s.List("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"audit/2023-10-01.jsonl", "cals/a.zip", "cals/b.zip"}, keys)
+
+	keys, err = s.List("nothing/")
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	assert.NoError(t, s.Delete("cals/a.zip"))
+	assert.NoError(t, s.Delete("cals/a.zip"))
+	_, err = s.Get("cals/a.zip")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// not outside the store
+	assert.Error(t, s.Put("../escape", nil))
+	assert.Error(t, s.Put("/abs", nil))
+	_, err = s.Get("a//b")
+	assert.Error(t, err)
+
+	assert.NoError(t, s.Close())
+}
+
+func TestDir(t *testing.T) {
+
+	s, err := NewDir(t.TempDir() + "/store")
+	assert.NoError(t, err)
+
+	exercise(t, s)
+}
+
+func TestOpen(t *testing.T) {
+
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	assert.NoError(t, err)
+	assert.IsType(t, &Dir{}, s)
+
+	s, err = Open("file://" + dir)
+	assert.NoError(t, err)
+	assert.IsType(t, &Dir{}, s)
+
+	// no driver in the tests
+	_, err = Open("sqlite://" + dir + "/store.db")
+	assert.EqualError(t, err, "store sqlite://"+dir+"/store.db cannot be used because there is no sqlite3 driver built in")
+
+	_, err = OpenSQLite(dir + "/store.db")
+	assert.EqualError(t, err, "cannot open "+dir+"/store.db because there is no sqlite3 driver built in")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	_, err = Open("s3://bucket/rig1?endpoint=http://localhost:9000")
+	assert.Error(t, err)
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "id")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	s, err = Open("s3://bucket/rig1?endpoint=http://localhost:9000/&region=eu-west-2")
+	assert.NoError(t, err)
+	s3 := s.(*S3)
+	assert.Equal(t, "http://localhost:9000", s3.Endpoint)
+	assert.Equal(t, "eu-west-2", s3.Region)
+	assert.Equal(t, "bucket", s3.Bucket)
+	assert.Equal(t, "rig1", s3.Prefix)
+
+	assert.NoError(t, Check("/var/lib/vna"))
+	assert.EqualError(t, Check("s3://bucket"), "store s3://bucket has no endpoint")
+	assert.EqualError(t, Check("s3:///x?endpoint=http://h"), "store s3:///x?endpoint=http://h has no bucket")
+	assert.EqualError(t, Check("ftp://host/x"), "store must be a directory, or start with file://, sqlite:// or s3://, not ftp://host/x")
+	assert.EqualError(t, Check("sqlite://"), "store sqlite:// has no path")
+	assert.EqualError(t, Check("sqlite:///var/lib/vna/store.db"), "store sqlite:///var/lib/vna/store.db cannot be used because there is no sqlite3 driver built in")
+}
+
+// fakeS3 is enough of S3 for the tests, with pages of one key, to follow
+// continuation tokens
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	auth    []string
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.auth = append(f.auth, r.Header.Get("Authorization"))
+
+	path, _ := url.PathUnescape(r.URL.EscapedPath())
+	parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+
+	if parts[0] != "vna" {
+		http.Error(w, "NoSuchBucket", http.StatusForbidden)
+		return
+	}
+
+	if len(parts) == 1 {
+
+		var keys []string
+
+		for k := range f.objects {
+			if strings.HasPrefix(k, r.URL.Query().Get("prefix")) && k > r.URL.Query().Get("continuation-token") {
+				keys = append(keys, k)
+			}
+		}
+
+		sort.Strings(keys)
+
+		var res listResult
+
+		if len(keys) > 0 {
+			res.Contents = append(res.Contents, struct {
+				Key string `xml:"Key"`
+			}{keys[0]})
+			res.IsTruncated = len(keys) > 1
+			res.NextContinuationToken = keys[0]
+		}
+
+		b, _ := xml.Marshal(res)
+		w.Write(b)
+		return
+	}
+
+	key := parts[1]
+
+	switch r.Method {
+	case http.MethodGet:
+		b, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(b)
+	case http.MethodPut:
+		b, _ := io.ReadAll(r.Body)
+		f.objects[key] = b
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func TestS3(t *testing.T) {
+
+	f := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(f)
+	defer server.Close()
+
+	s := &S3{
+		Endpoint: server.URL,
+		Region:   "eu-west-2",
+		Bucket:   "vna",
+		Prefix:   "rig1",
+		Key:      "id",
+		Secret:   "secret",
+	}
+
+	exercise(t, s)
+
+	// kept under the prefix
+	_, ok := f.objects["rig1/cals/b.zip"]
+	assert.True(t, ok)
+
+	assert.True(t, strings.HasPrefix(f.auth[0], "AWS4-HMAC-SHA256 Credential=id/"))
+	assert.Contains(t, f.auth[0], "/eu-west-2/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature=")
+
+	// each append is an object of its own, so the file is not rewritten
+	assert.Equal(t, "{}\n", string(f.objects["rig1/audit/2023-10-01.jsonl/part-00000001"]))
+	assert.Equal(t, "[]\n", string(f.objects["rig1/audit/2023-10-01.jsonl/part-00000002"]))
+
+	// and numbered on from the last by a store that has not appended to it yet
+	s2 := &S3{Endpoint: s.Endpoint, Region: s.Region, Bucket: s.Bucket, Prefix: s.Prefix, Key: s.Key, Secret: s.Secret}
+	assert.NoError(t, s2.Append("audit/2023-10-01.jsonl", []byte("3\n")))
+	assert.Equal(t, "3\n", string(f.objects["rig1/audit/2023-10-01.jsonl/part-00000003"]))
+
+	assert.NoError(t, s.Put("audit/2023-10-01.jsonl/x", []byte("y")))
+
+	keys, err := s.List("audit/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"audit/2023-10-01.jsonl", "audit/2023-10-01.jsonl/x"}, keys)
+
+	// a put replaces the parts
+	assert.NoError(t, s.Put("audit/2023-10-01.jsonl", []byte("new\n")))
+	assert.NoError(t, s.Append("audit/2023-10-01.jsonl", []byte("more\n")))
+	b, err := s.Get("audit/2023-10-01.jsonl")
+	assert.NoError(t, err)
+	assert.Equal(t, "new\nmore\n", string(b))
+
+	// as does a delete
+	assert.NoError(t, s.Delete("audit/2023-10-01.jsonl"))
+	_, err = s.Get("audit/2023-10-01.jsonl")
+	assert.ErrorIs(t, err, ErrNotFound)
+	keys, err = s.List("audit/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"audit/2023-10-01.jsonl/x"}, keys)
+
+	// a key that would be taken for a part
+	assert.Error(t, s.Put("audit/a/part-00000001", nil))
+
+	// an error from the service is passed on
+	s.Bucket = ""
+	assert.Error(t, s.Put("cals/c.zip", nil))
+}
+
+func TestEscape(t *testing.T) {
+	assert.Equal(t, "cals/a%20b~.zip", escape("cals/a b~.zip", true))
+	assert.Equal(t, "cals%2Fa", escape("cals/a", false))
+	assert.Equal(t, "list-type=2&prefix=a%2Fb", canonicalQuery(map[string]string{"prefix": "a/b", "list-type": "2"}))
+}
@@ -0,0 +1,194 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/reconws"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultMaxMessage is the largest message sent to users unless set otherwise,
+// because some relays and proxies drop websocket frames of several megabytes
+const DefaultMaxMessage = 1 << 20
+
+// MinMessage is the smallest limit that can be set, so that a part has room for some data
+const MinMessage = 1024
+
+// Reassembly is the contract for putting the parts of a large response back together, for hello
+const Reassembly = "a response larger than maxmessage bytes is sent as parts with cmd part, " +
+	"the same message number, and part numbered from 1 to parts; join their data in order of part, then parse the result as JSON"
+
+// messages numbers the responses that are split into parts, so their parts can be told apart
+var messages atomic.Int64
+
+// SetMaxMessage sets the largest message sent to users, in bytes. Larger responses
+// are split into parts (see Chunk). Zero means there is no limit.
+func (s *Stream) SetMaxMessage(n int) {
+	if s.max != nil {
+		s.max.Store(int64(n))
+	}
+}
+
+// MaxMessage returns the largest message sent to users, in bytes, or zero if there is no limit
+func (s *Stream) MaxMessage() int {
+	if s.max == nil {
+		return 0
+	}
+	return int(s.max.Load())
+}
+
+// Chunk splits the JSON of a response into pocket.Part messages of no more than max bytes each,
+// or returns it as it is if it is small enough already. The parts carry the id, t and session
+// of the response, so they can be matched to their request before they are put back together.
+func Chunk(payload []byte, max int, message int) ([][]byte, error) {
+
+	if max <= 0 || len(payload) <= max {
+		return [][]byte{payload}, nil
+	}
+
+	var c pocket.Command
+
+	_ = json.Unmarshal(payload, &c) // not every response has an id
+
+	p := pocket.Part{
+		Command: pocket.Command{ID: c.ID, Time: c.Time, Command: "part", Session: c.Session},
+		Of:      c.Command,
+		Message: message,
+		Part:    len(payload), // there can't be more parts than bytes, so this allows for the longest numbers
+		Parts:   len(payload),
+	}
+
+	empty, err := json.Marshal(p)
+
+	if err != nil {
+		return nil, err
+	}
+
+	room := max - len(empty)
+
+	if room < utf8.UTFMax*6 {
+		return nil, fmt.Errorf("a limit of %d bytes leaves no room for data in each part", max)
+	}
+
+	var data []string
+
+	for start := 0; start < len(payload); {
+
+		end, size := start, 0
+
+		for end < len(payload) {
+
+			r, n := utf8.DecodeRune(payload[end:])
+
+			cost := escaped(r, n)
+
+			if size+cost > room {
+				break
+			}
+
+			size += cost
+			end += n
+		}
+
+		data = append(data, string(payload[start:end]))
+
+		start = end
+	}
+
+	var parts [][]byte
+
+	for i, d := range data {
+
+		p.Part = i + 1
+		p.Parts = len(data)
+		p.Data = d
+
+		b, err := json.Marshal(p)
+
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, b)
+	}
+
+	return parts, nil
+}
+
+// escaped returns the most bytes that rune r, of n bytes, can take up once it is in a JSON string
+func escaped(r rune, n int) int {
+
+	switch {
+	case r == utf8.RuneError && n == 1:
+		return 6 // replaced by \ufffd
+	case r == '"' || r == '\\' || r == '\n' || r == '\r' || r == '\t':
+		return 2
+	case r < 0x20 || r == '<' || r == '>' || r == '&' || r == '\u2028' || r == '\u2029':
+		return 6
+	}
+
+	return n
+}
+
+// PipeInterfaceToWsLimit is PipeInterfaceToWs, except that responses larger than
+// max bytes are split into parts. max can be changed while it runs.
+func PipeInterfaceToWsLimit(in chan interface{}, out chan reconws.WsMessage, max *atomic.Int64, ctx context.Context) {
+	pipe(in, out, max, &atomic.Pointer[reconws.ReconWs]{}, ctx)
+}
+
+// pipe is PipeInterfaceToWsLimit, mirroring each message to the spectator topic, if there is one
+func pipe(in chan interface{}, out chan reconws.WsMessage, max *atomic.Int64, spectator *atomic.Pointer[reconws.ReconWs], ctx context.Context) {
+
+	mtype := int(websocket.TextMessage)
+
+	for {
+		select {
+
+		case <-ctx.Done():
+			return
+		case s := <-in:
+
+			payload, err := pocket.Marshal(s)
+
+			if err != nil {
+				log.WithField("error", err).Warning("Could not turn interface{} into JSON")
+			}
+
+			parts := [][]byte{payload}
+
+			// so that a server addressing sessions sends it only to the session that asked
+			c, _ := pocket.CommandOf(s)
+
+			if m := int(max.Load()); m > 0 && len(payload) > m {
+
+				parts, err = Chunk(payload, m, int(messages.Add(1)))
+
+				if err != nil {
+					log.WithField("error", err).Warning("Could not split response into parts, sending it whole")
+					parts = [][]byte{payload}
+				}
+
+				log.WithFields(log.Fields{"size": len(payload), "parts": len(parts)}).Debug("response split into parts")
+			}
+
+			for _, p := range parts {
+
+				msg := reconws.WsMessage{Data: p, Type: mtype, To: c.Session}
+
+				select {
+				case out <- msg:
+				case <-ctx.Done():
+					return
+				}
+
+				mirror(spectator, msg, ctx)
+			}
+		}
+	}
+}
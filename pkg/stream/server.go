@@ -0,0 +1,271 @@
+package stream
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/practable/pocket-vna-two-port/pkg/reconws"
+	log "github.com/sirupsen/logrus"
+)
+
+// WriteTimeout is how long a client of the server has to take each message,
+// before it is dropped so that it can't hold up the others
+const WriteTimeout = 10 * time.Second
+
+// Listen configures a stream that serves websocket clients directly, instead of
+// connecting out to a relay, e.g. for benchtop use on a laptop
+type Listen struct {
+	Addr    string // host:port to listen on
+	Cert    string // TLS certificate file, if serving wss
+	Key     string // TLS key file, if serving wss
+	Token   string // clients must give this as ?token= or an Authorization: Bearer header, unless empty
+	Address bool   // send responses only to the clients of the session that made the request
+}
+
+// NewServer listens on l.Addr and returns a stream of the requests from every
+// client that connects, on any path. Responses and heartbeats are sent to all of them,
+// unless l.Address is set, when a response to a request with a session is only sent to
+// the clients of that session. A client joins a session by connecting with ?session=,
+// or by sending a request with a session.
+func NewServer(ctx context.Context, l Listen) (Stream, error) {
+
+	if (l.Cert == "") != (l.Key == "") {
+		return Stream{}, errors.New("give both a TLS certificate and key, or neither")
+	}
+
+	ln, err := net.Listen("tcp", l.Addr)
+
+	if err != nil {
+		return Stream{}, err
+	}
+
+	return Serve(ctx, ln, l), nil
+}
+
+// Serve is NewServer, using a listener that is already open. It is closed when ctx is done.
+func Serve(ctx context.Context, ln net.Listener, l Listen) Stream {
+
+	request := make(chan interface{}, 2)
+	response := make(chan interface{}, 2)
+
+	in := make(chan reconws.WsMessage)
+	out := make(chan reconws.WsMessage)
+
+	max := &atomic.Int64{}
+	max.Store(DefaultMaxMessage)
+
+	h := &hub{
+		ctx:     ctx,
+		token:   l.Token,
+		address: l.Address,
+		in:      in,
+		// browsers on any origin may connect, e.g. a UI opened from a file, so the token is the check
+		upgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+		clients:  make(map[*websocket.Conn]string),
+	}
+
+	srv := &http.Server{Handler: h}
+
+	go func() {
+
+		var err error
+
+		if l.Cert != "" {
+			err = srv.ServeTLS(ln, l.Cert, l.Key)
+		} else {
+			err = srv.Serve(ln)
+		}
+
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.WithFields(log.Fields{"addr": ln.Addr().String(), "error": err.Error()}).Error("stream server stopped")
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+		h.closeAll()
+	}()
+
+	go h.broadcast(out)
+
+	go PipeWsToInterface(in, request, ctx)
+
+	spectator := &atomic.Pointer[reconws.ReconWs]{}
+
+	go pipe(response, out, max, spectator, ctx)
+
+	go HeartBeat(out, time.Second, ctx)
+
+	scheme := "ws://"
+
+	if l.Cert != "" {
+		scheme = "wss://"
+	}
+
+	log.WithField("addr", ln.Addr().String()).Infof("serving stream")
+
+	return Stream{
+		u:         scheme + ln.Addr().String(),
+		Ctx:       ctx,
+		Request:   request,
+		Response:  response,
+		Timeout:   time.Second,
+		max:       max,
+		spectator: spectator,
+	}
+}
+
+// hub keeps track of the clients of the server, and the session of each, if known
+type hub struct {
+	ctx      context.Context
+	token    string
+	address  bool
+	in       chan reconws.WsMessage
+	upgrader websocket.Upgrader
+	mu       sync.Mutex
+	clients  map[*websocket.Conn]string
+}
+
+// ServeHTTP checks the token, then reads requests from the client until it goes away
+func (h *hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if !h.allowed(r) {
+		log.WithField("remote", r.RemoteAddr).Warn("stream client refused because of a missing or wrong token")
+		http.Error(w, "missing or wrong token", http.StatusUnauthorized)
+		return
+	}
+
+	c, err := h.upgrader.Upgrade(w, r, nil)
+
+	if err != nil {
+		return // Upgrade has already replied
+	}
+
+	session := r.URL.Query().Get("session")
+
+	h.mu.Lock()
+	h.clients[c] = session
+	h.mu.Unlock()
+
+	log.WithFields(log.Fields{"remote": r.RemoteAddr, "session": session}).Infof("stream client connected")
+
+	defer h.drop(c)
+
+	for {
+
+		mt, data, err := c.ReadMessage()
+
+		if err != nil {
+			log.WithFields(log.Fields{"remote": r.RemoteAddr, "error": err.Error()}).Infof("stream client disconnected")
+			return
+		}
+
+		h.join(c, data)
+
+		select {
+		case h.in <- reconws.WsMessage{Data: data, Type: mt}:
+		case <-h.ctx.Done():
+			return
+		}
+	}
+}
+
+// allowed returns whether the request has the token, if one is needed
+func (h *hub) allowed(r *http.Request) bool {
+
+	if h.token == "" {
+		return true
+	}
+
+	t := r.URL.Query().Get("token")
+
+	if b, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		t = b
+	}
+
+	return subtle.ConstantTimeCompare([]byte(t), []byte(h.token)) == 1
+}
+
+// join records the session of the request in data as that of client c, if it has one
+func (h *hub) join(c *websocket.Conn, data []byte) {
+
+	var cmd pocket.Command
+
+	if json.Unmarshal(data, &cmd) != nil || cmd.Session == "" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.clients[c] = cmd.Session
+}
+
+// broadcast sends each message to every client, or only to the clients of its
+// session if it has one and sessions are addressed, dropping any that can't take it
+func (h *hub) broadcast(out chan reconws.WsMessage) {
+
+	for {
+		select {
+
+		case <-h.ctx.Done():
+			return
+
+		case msg := <-out:
+
+			h.mu.Lock()
+
+			for c, session := range h.clients {
+
+				if h.address && msg.To != "" && msg.To != session {
+					continue
+				}
+
+				_ = c.SetWriteDeadline(time.Now().Add(WriteTimeout))
+
+				err := c.WriteMessage(msg.Type, msg.Data)
+
+				if err != nil {
+					log.WithField("error", err.Error()).Infof("could not write to stream client; closing")
+					delete(h.clients, c)
+					c.Close()
+				}
+			}
+
+			h.mu.Unlock()
+		}
+	}
+}
+
+// drop forgets a client and closes its connection
+func (h *hub) drop(c *websocket.Conn) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.clients, c)
+	c.Close()
+}
+
+// closeAll closes the connection to every client
+func (h *hub) closeAll() {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for c := range h.clients {
+		c.Close()
+		delete(h.clients, c)
+	}
+}
@@ -0,0 +1,56 @@
+package stream
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/reconws"
+	log "github.com/sirupsen/logrus"
+)
+
+// Spectate mirrors every message sent on the stream, i.e. the responses, progress and
+// events such as switchevent, to a second topic at u, e.g. so that a class can watch the
+// measurements of a demonstrator live. The topic is read-only: anything sent to it is
+// ignored, so spectators cannot send commands. Messages for the topic are kept while it
+// is disconnected, up to reconws.DefaultBuffer of them, and never hold up the stream.
+func (s *Stream) Spectate(ctx context.Context, u string) {
+
+	if s.spectator == nil {
+		log.WithField("topic", u).Error("stream was not made by New or Serve, so it cannot be mirrored to the spectator topic")
+		return
+	}
+
+	r := reconws.New()
+	r.ForwardIncoming = false
+
+	go r.Reconnect(ctx, u)
+
+	go HeartBeat(r.Out, time.Second, ctx)
+
+	s.spectator.Store(r)
+
+	log.WithField("topic", u).Infof("mirroring stream to spectator topic")
+}
+
+// mirror sends msg to the spectator topic, if there is one. ReconWs queues it, so this
+// only waits for ctx if the topic has stopped.
+func mirror(spectator *atomic.Pointer[reconws.ReconWs], msg reconws.WsMessage, ctx context.Context) {
+
+	if spectator == nil {
+		return
+	}
+
+	r := spectator.Load()
+
+	if r == nil {
+		return
+	}
+
+	msg.To = "" // spectators watch every session
+
+	select {
+	case r.Out <- msg:
+	case <-ctx.Done():
+	}
+}
@@ -8,15 +8,19 @@ This is done here to simplify the message handling in middleware package `middle
 package stream
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/practable/pocket-vna-two-port/pkg/pocket"
 	"github.com/practable/pocket-vna-two-port/pkg/reconws"
+	"github.com/practable/pocket-vna-two-port/pkg/schema"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -27,6 +31,25 @@ type Stream struct {
 	Request  chan interface{}
 	Response chan interface{}
 	Timeout  time.Duration
+	max      *atomic.Int64 // largest message sent, see SetMaxMessage
+	// read-only topic that every message sent is mirrored to, nil if none, see Spectate
+	spectator *atomic.Pointer[reconws.ReconWs]
+}
+
+// Publish sends v, which no one asked for, e.g. a calevent, along with the responses,
+// waiting until it is taken, unless ctx is done first. ctx may be nil, to always wait.
+func (s *Stream) Publish(ctx context.Context, v interface{}) {
+
+	var done <-chan struct{}
+
+	if ctx != nil {
+		done = ctx.Done()
+	}
+
+	select {
+	case s.Response <- v:
+	case <-done:
+	}
 }
 
 // TODO duplicate the testing applied to RunDirect
@@ -37,6 +60,9 @@ func New(ctx context.Context, u string) Stream {
 
 	r := reconws.New()
 
+	max := &atomic.Int64{}
+	max.Store(DefaultMaxMessage)
+
 	go r.Reconnect(ctx, u)
 
 	// We receive requests from user
@@ -44,17 +70,23 @@ func New(ctx context.Context, u string) Stream {
 
 	go PipeWsToInterface(r.In, request, ctx)
 
-	go PipeInterfaceToWs(response, r.Out, ctx)
+	spectator := &atomic.Pointer[reconws.ReconWs]{}
+
+	go pipe(response, r.Out, max, spectator, ctx)
 
 	go HeartBeat(r.Out, time.Second, ctx)
 
+	go Resync(r.Reconnected, response, ctx)
+
 	return Stream{
-		u:        u,
-		R:        r,
-		Ctx:      ctx,
-		Request:  request,
-		Response: response,
-		Timeout:  time.Second,
+		u:         u,
+		R:         r,
+		Ctx:       ctx,
+		Request:   request,
+		Response:  response,
+		Timeout:   time.Second,
+		max:       max,
+		spectator: spectator,
 	}
 
 }
@@ -279,8 +311,9 @@ func HeartBeat(out chan reconws.WsMessage, t time.Duration, ctx context.Context)
 			return
 		case <-time.After(t):
 			out <- reconws.WsMessage{
-				Data: []byte("{\"cmd\":\"hb\"}"),
-				Type: mtype,
+				Data:      []byte("{\"cmd\":\"hb\"}"),
+				Type:      mtype,
+				Transient: true,
 			}
 
 		}
@@ -288,6 +321,26 @@ func HeartBeat(out chan reconws.WsMessage, t time.Duration, ctx context.Context)
 
 }
 
+// Resync tells users each time the connection to the relay is made again, so
+// they can ask again for anything that they might have missed
+func Resync(in chan reconws.Reconnection, out chan interface{}, ctx context.Context) {
+
+	for {
+		select {
+
+		case <-ctx.Done():
+			return
+
+		case rc := <-in:
+			out <- pocket.Reconnected{
+				Command: pocket.Command{Command: "reconnected"},
+				Count:   rc.Count,
+				Dropped: rc.Dropped,
+			}
+		}
+	}
+}
+
 func PipeWsToInterface(in chan reconws.WsMessage, out chan interface{}, ctx context.Context) {
 
 	for {
@@ -298,78 +351,773 @@ func PipeWsToInterface(in chan reconws.WsMessage, out chan interface{}, ctx cont
 
 		case msg := <-in:
 
-			//var rq pocket.RangeQuery
-			//var sq pocket.SingleQuery
-			//var rr pocket.ReasonableFrequencyRange
+			if s, ok := Parse([]byte(msg.Data)); ok {
+				out <- s
+			}
 
-			var c pocket.Command
+		}
 
-			err := json.Unmarshal([]byte(msg.Data), &c)
+	}
 
-			if err != nil {
-				log.WithField("error", err).Warning("Could not turn unmarshal JSON - invalid cmd string in JSON?")
-				fmt.Printf("\n%s\n", msg.Data)
-			}
+}
 
-			switch strings.ToLower(c.Command) {
+// Protocol is the version of the stream protocol, which is increased when a
+// change to the commands or results could break an existing client
+const Protocol = 2
 
-			case "rq", "rangequery", "rc", "rangecal":
+// Commands lists every cmd that Parse accepts, including the aliases, for hello
+var Commands = pocket.AllNames()
 
-				s := pocket.RangeQuery{}
+// Parse turns a JSON command into the request type for its cmd, or returns false if data is empty.
+// The cmd may be any of the names of the command (see pocket.Names), in any case, and is replaced
+// by its usual name, which the response has. Anything else, such as a command that is not JSON, whose cmd is not
+// known, or that does not match the schema of its type, is returned as a pocket.Invalid, saying why.
+func Parse(data []byte) (interface{}, bool) {
 
-				err := json.Unmarshal([]byte(msg.Data), &s)
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil, false
+	}
 
-				if err != nil {
-					log.WithField("error", err).Warning("Could not turn unmarshal JSON for RangeQuery (rq) command - invalid or missing parameters in JSON?")
-					fmt.Printf("\n%s\n", msg.Data)
-				}
+	var c pocket.Command
 
-				out <- s
+	err := json.Unmarshal(data, &c)
 
-			case "crq", "calibratedrangequery":
+	if err != nil {
 
-				s := pocket.CalibratedRangeQuery{}
+		// say which field is wrong, if it is valid JSON
+		if e := schema.For(c).Validate(data); e != nil {
+			err = e
+		}
 
-				err := json.Unmarshal([]byte(msg.Data), &s)
+		return pocket.Invalid{Request: c, Errors: toErrors(err)}, true
+	}
 
-				if err != nil {
-					log.WithField("error", err).Warning("Could not turn unmarshal JSON for CalibratedRangeQuery (rq) command - invalid or missing parameters in JSON?")
-					fmt.Printf("\n%s\n", msg.Data)
-				}
+	cmd, ok := pocket.Lookup(c.Command)
 
-				out <- s
+	if !ok {
+		return pocket.Invalid{Request: c, Errors: []string{unknown(c.Command)}}, true
+	}
 
-			case "sq", "singlequery":
+	// the response has the usual name of the command, whichever was used
+	if cmd != c.Command {
+		data = rename(data, cmd)
+	}
 
-				s := pocket.SingleQuery{}
+	s, _ := parse(data)
 
-				err := json.Unmarshal([]byte(msg.Data), &s)
+	err = schema.For(s).Validate(data)
 
-				if err != nil {
-					log.WithField("error", err).Warning("Could not turn unmarshal JSON for SingleQuery (sq) command - invalid or missing parameters in JSON?")
-					fmt.Printf("\n%s\n", msg.Data)
-				}
+	if err != nil {
+		log.WithField("error", err).Warning("Request does not match its schema")
+		return pocket.Invalid{Request: s, Errors: toErrors(err)}, true
+	}
 
-				out <- s
+	return s, true
+}
 
-			case "rr", "reasonablefrequencyrange":
+// rename sets the cmd of a request to cmd
+func rename(data []byte, cmd string) []byte {
 
-				s := pocket.ReasonableFrequencyRange{}
+	var r map[string]json.RawMessage
 
-				err := json.Unmarshal([]byte(msg.Data), &s)
+	if json.Unmarshal(data, &r) != nil {
+		return data
+	}
 
-				if err != nil {
-					log.WithField("error", err).Warning("Could not turn unmarshal JSON for ReasonableFrequencyRange (rr) command - invalid or missing parameters in JSON?")
-					fmt.Printf("\n%s\n", msg.Data)
-				}
+	for k := range r {
 
-				out <- s
-			}
+		if strings.EqualFold(k, "cmd") {
+			r[k], _ = json.Marshal(cmd)
+		}
+	}
+
+	b, err := json.Marshal(r)
+
+	if err != nil {
+		return data
+	}
+
+	return b
+}
+
+// unknown says that cmd is not a command, and suggests the one that was probably meant
+func unknown(cmd string) string {
+
+	if cmd == "" {
+		return "cmd is missing, e.g. {\"cmd\":\"hello\"} to list the commands"
+	}
+
+	if m := schema.Suggest(cmd, Commands); m != "" {
+		return fmt.Sprintf("unknown command %s, did you mean %s?", cmd, m)
+	}
+
+	return fmt.Sprintf("unknown command %s, send {\"cmd\":\"hello\"} to list the commands", cmd)
+}
+
+// toErrors lists the errors found by Validate
+func toErrors(err error) []string {
+
+	var errs schema.Errors
+
+	if errors.As(err, &errs) {
+		return errs
+	}
+
+	return []string{err.Error()}
+}
+
+// Schemas returns the schema of each command that Parse accepts, keyed by cmd,
+// along with that of the error response, keyed by "error"
+func Schemas() map[string]*schema.Schema {
+
+	s := map[string]*schema.Schema{
+		"error": schema.For(pocket.CustomResult{}),
+	}
+
+	for _, c := range Commands {
+		v, _ := parse([]byte(`{"cmd":"` + c + `"}`))
+		s[c] = schema.For(v)
+	}
+
+	return s
+}
+
+// parse turns a JSON command into the request type for its cmd, or returns false if cmd is not known
+func parse(data []byte) (interface{}, bool) {
+
+	var c pocket.Command
+
+	err := json.Unmarshal(data, &c)
+
+	if err != nil {
+		log.WithField("error", err).Warning("Could not turn unmarshal JSON - invalid cmd string in JSON?")
+		fmt.Printf("\n%s\n", data)
+	}
+
+	cmd, _ := pocket.Lookup(c.Command)
+
+	switch cmd {
+
+	case "rq", "rc":
+
+		s := pocket.RangeQuery{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for RangeQuery (rq) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "crq":
+
+		s := pocket.CalibratedRangeQuery{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for CalibratedRangeQuery (rq) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "td":
+
+		s := pocket.TimeDomainQuery{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for TimeDomainQuery (td) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "sq":
+
+		s := pocket.SingleQuery{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for SingleQuery (sq) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "rr":
+
+		s := pocket.ReasonableFrequencyRange{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for ReasonableFrequencyRange (rr) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "sp":
+
+		s := pocket.SetPower{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for SetPower (sp) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "sf":
+
+		s := pocket.SetFixture{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for SetFixture (sf) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "pe":
+
+		s := pocket.PortExtension{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for PortExtension (pe) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "an":
+
+		s := pocket.Analysis{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Analysis (an) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "tq":
+
+		s := pocket.TimeQuery{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for TimeQuery (tq) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "startsweep", "stopsweep":
+
+		s := pocket.Sweep{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Sweep (startsweep, stopsweep) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "stability":
+
+		s := pocket.Stability{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Stability (stability) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "hs", "hq", "hr":
+
+		s := pocket.Hold{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Hold (hs, hq, hr) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "nf":
+
+		s := pocket.NoiseFloor{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for NoiseFloor (nf) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "cf":
+
+		s := pocket.ClearFixture{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for ClearFixture (cf) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "exportcal":
+
+		s := pocket.ExportCal{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for ExportCal (exportcal) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "comparecal":
+
+		s := pocket.CompareCal{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for CompareCal (comparecal) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "bench":
+
+		s := pocket.Bench{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Bench (bench) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "calstate":
+
+		s := pocket.CalState{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for CalState (calstate) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "mc":
+
+		s := pocket.MeasureCal{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for MeasureCal (mc) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "resumecal":
+
+		s := pocket.ResumeCal{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for ResumeCal (resumecal) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "getconfig":
+
+		s := pocket.GetConfig{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for GetConfig (getconfig) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "reload":
+
+		s := pocket.Reload{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Reload (reload) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "cancel":
+
+		s := pocket.Cancel{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Cancel (cancel) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "queue":
+
+		s := pocket.Queue{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Queue (queue) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "flushqueue":
+
+		s := pocket.FlushQueue{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for FlushQueue (flushqueue) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "schedule":
+
+		s := pocket.Schedule{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Schedule (schedule) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "unschedule":
+
+		s := pocket.Unschedule{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Unschedule (unschedule) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "audit":
+
+		s := pocket.Audit{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Audit (audit) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "estimate":
+
+		s := pocket.Estimate{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Estimate (estimate) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "reset":
+
+		s := pocket.Reset{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Reset (reset) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "flashswitch":
+
+		s := pocket.FlashSwitch{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for FlashSwitch (flashswitch) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "protocoltest":
+
+		s := pocket.ProtocolTest{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for ProtocolTest (protocoltest) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "switchwear":
+
+		s := pocket.SwitchWear{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for SwitchWear (switchwear) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "switchtraffic":
+
+		s := pocket.SwitchTraffic{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for SwitchTraffic (switchtraffic) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "getgrid":
+
+		s := pocket.GetGrid{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for GetGrid (getgrid) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "logs":
+
+		s := pocket.Logs{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Logs (logs) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "history":
+
+		s := pocket.History{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for History (history) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "lastresult":
+
+		s := pocket.LastResult{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for LastResult (lastresult) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "saveref":
+
+		s := pocket.SaveReference{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for SaveReference (saveref) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "clearref":
+
+		s := pocket.ClearReference{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for ClearReference (clearref) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "setlimits":
+
+		s := pocket.SetLimits{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for SetLimits (setlimits) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "compare":
+
+		s := pocket.Compare{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Compare (compare) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "hello":
+
+		s := pocket.Hello{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Hello (hello) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "schema":
+
+		s := pocket.Schema{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Schema (schema) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "characterize":
+
+		s := pocket.Characterize{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Characterize (characterize) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		return s, true
+
+	case "batch":
+
+		s := pocket.Batch{}
+
+		err := json.Unmarshal(data, &s)
+
+		if err != nil {
+			log.WithField("error", err).Warning("Could not turn unmarshal JSON for Batch (batch) command - invalid or missing parameters in JSON?")
+			fmt.Printf("\n%s\n", data)
+		}
+
+		// an unknown command is left as nil, for middle to report in its place
+		s.Requests = make([]interface{}, len(s.Commands))
 
+		for i, c := range s.Commands {
+			s.Requests[i], _ = Parse(c)
 		}
 
+		return s, true
 	}
 
+	return nil, false
 }
 
 // This can be used for all of the external connections because it is data structure agnostic
@@ -384,7 +1132,7 @@ func PipeInterfaceToWs(in chan interface{}, out chan reconws.WsMessage, ctx cont
 			return
 		case s := <-in:
 
-			payload, err := json.Marshal(s)
+			payload, err := pocket.Marshal(s)
 
 			if err != nil {
 				log.WithField("error", err).Warning("Could not turn interface{} into JSON")
@@ -4,10 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -524,6 +526,418 @@ func TestPipeWsToInterface(t *testing.T) {
 		// no need to check the Sparam results because we are not expecting to pass them in this direction
 	}
 
+	/* Test SetPower */
+	message = []byte("{\"id\":\"p0\",\"cmd\":\"setpower\",\"power\":-12.5}")
+
+	ws = reconws.WsMessage{
+		Data: message,
+		Type: mt,
+	}
+
+	chanWs <- ws
+
+	select {
+
+	case <-time.After(timeout):
+		t.Error("timeout awaiting response")
+	case reply := <-chanInterface:
+		assert.Equal(t, reflect.TypeOf(reply), reflect.TypeOf(pocket.SetPower{}))
+		sp := reply.(pocket.SetPower)
+		assert.Equal(t, "sp", sp.Command.Command) // the usual name, though sent as setpower
+		assert.Equal(t, -12.5, sp.Power)
+	}
+
+	/* Test TimeDomainQuery */
+	message = []byte("{\"id\":\"t0\",\"cmd\":\"td\",\"what\":\"dut1\",\"window\":\"kaiser\",\"beta\":8,\"sparam\":{\"s11\":true}}")
+
+	ws = reconws.WsMessage{
+		Data: message,
+		Type: mt,
+	}
+
+	chanWs <- ws
+
+	select {
+
+	case <-time.After(timeout):
+		t.Error("timeout awaiting response")
+	case reply := <-chanInterface:
+		assert.Equal(t, reflect.TypeOf(reply), reflect.TypeOf(pocket.TimeDomainQuery{}))
+		td := reply.(pocket.TimeDomainQuery)
+		assert.Equal(t, "td", td.Command.Command)
+		assert.Equal(t, "dut1", td.What)
+		assert.Equal(t, "kaiser", td.Window)
+		assert.Equal(t, 8.0, td.Beta)
+		assert.Equal(t, pocket.SParamSelect{S11: true}, td.Select)
+	}
+
+	/* Test SetFixture */
+	message = []byte("{\"cmd\":\"sf\",\"port\":2,\"s2p\":\"# GHz S RI R 50\\n1 0 0 1 0 1 0 0 0\\n\"}")
+
+	ws = reconws.WsMessage{
+		Data: message,
+		Type: mt,
+	}
+
+	chanWs <- ws
+
+	select {
+
+	case <-time.After(timeout):
+		t.Error("timeout awaiting response")
+	case reply := <-chanInterface:
+		assert.Equal(t, reflect.TypeOf(reply), reflect.TypeOf(pocket.SetFixture{}))
+		sf := reply.(pocket.SetFixture)
+		assert.Equal(t, 2, sf.Port)
+		assert.Equal(t, "# GHz S RI R 50\n1 0 0 1 0 1 0 0 0\n", sf.S2P)
+	}
+
+	/* Test TimeQuery */
+	message = []byte("{\"cmd\":\"tq\",\"what\":\"dut3\",\"freq\":433000000,\"avg\":1,\"sparam\":{\"s11\":true},\"duration\":10}")
+
+	ws = reconws.WsMessage{
+		Data: message,
+		Type: mt,
+	}
+
+	chanWs <- ws
+
+	select {
+
+	case <-time.After(timeout):
+		t.Error("timeout awaiting response")
+	case reply := <-chanInterface:
+		assert.Equal(t, reflect.TypeOf(reply), reflect.TypeOf(pocket.TimeQuery{}))
+		tq := reply.(pocket.TimeQuery)
+		assert.Equal(t, "tq", tq.Command.Command)
+		assert.Equal(t, "dut3", tq.What)
+		assert.Equal(t, uint64(433000000), tq.Freq)
+		assert.Equal(t, 10.0, tq.Duration)
+		assert.Equal(t, pocket.SParamSelect{S11: true}, tq.Select)
+	}
+
+	/* Test Sweep */
+	message = []byte("{\"id\":\"s1\",\"cmd\":\"startsweep\",\"what\":\"dut1\",\"format\":\"db\",\"interval\":2.5}")
+
+	ws = reconws.WsMessage{
+		Data: message,
+		Type: mt,
+	}
+
+	chanWs <- ws
+
+	select {
+
+	case <-time.After(timeout):
+		t.Error("timeout awaiting response")
+	case reply := <-chanInterface:
+		assert.Equal(t, reflect.TypeOf(reply), reflect.TypeOf(pocket.Sweep{}))
+		sw := reply.(pocket.Sweep)
+		assert.Equal(t, "startsweep", sw.Command.Command)
+		assert.Equal(t, "s1", sw.Command.ID)
+		assert.Equal(t, "dut1", sw.What)
+		assert.Equal(t, "db", sw.Format)
+		assert.Equal(t, 2.5, sw.Interval)
+	}
+
+	/* Test Hold */
+	message = []byte("{\"cmd\":\"holdstart\",\"what\":\"dut2\"}")
+
+	ws = reconws.WsMessage{
+		Data: message,
+		Type: mt,
+	}
+
+	chanWs <- ws
+
+	select {
+
+	case <-time.After(timeout):
+		t.Error("timeout awaiting response")
+	case reply := <-chanInterface:
+		assert.Equal(t, reflect.TypeOf(reply), reflect.TypeOf(pocket.Hold{}))
+		h := reply.(pocket.Hold)
+		assert.Equal(t, "hs", h.Command.Command)
+		assert.Equal(t, "dut2", h.What)
+	}
+
+	/* Test NoiseFloor */
+	message = []byte("{\"cmd\":\"nf\",\"range\":{\"start\":100000000,\"end\":500000000},\"size\":51,\"avg\":1,\"sweeps\":20}")
+
+	ws = reconws.WsMessage{
+		Data: message,
+		Type: mt,
+	}
+
+	chanWs <- ws
+
+	select {
+
+	case <-time.After(timeout):
+		t.Error("timeout awaiting response")
+	case reply := <-chanInterface:
+		assert.Equal(t, reflect.TypeOf(reply), reflect.TypeOf(pocket.NoiseFloor{}))
+		nf := reply.(pocket.NoiseFloor)
+		assert.Equal(t, "nf", nf.Command.Command)
+		assert.Equal(t, pocket.Range{Start: 100000000, End: 500000000}, nf.Range)
+		assert.Equal(t, 51, nf.Size)
+		assert.Equal(t, 20, nf.Sweeps)
+	}
+
+}
+
+func TestParseBatch(t *testing.T) {
+
+	s, ok := Parse([]byte(`{"cmd":"batch","id":"b","commands":[{"cmd":"crq","what":"dut1"},{"cmd":"sp","power":-10},{"cmd":"nope"}]}`))
+	assert.True(t, ok)
+
+	b, ok := s.(pocket.Batch)
+	assert.True(t, ok)
+	assert.Equal(t, "b", b.ID)
+	assert.Equal(t, 3, len(b.Requests))
+
+	crq, ok := b.Requests[0].(pocket.CalibratedRangeQuery)
+	assert.True(t, ok)
+	assert.Equal(t, "dut1", crq.What)
+
+	_, ok = b.Requests[1].(pocket.SetPower)
+	assert.True(t, ok)
+
+	_, ok = b.Requests[2].(pocket.Invalid)
+	assert.True(t, ok)
+}
+
+func TestCommands(t *testing.T) {
+
+	for _, c := range Commands {
+		_, ok := Parse([]byte(`{"cmd":"` + c + `"}`))
+		assert.True(t, ok, c)
+	}
+
+	s, ok := Parse([]byte(`{"cmd":"capabilities","version":1}`))
+	assert.True(t, ok)
+
+	h, ok := s.(pocket.Hello)
+	assert.True(t, ok)
+	assert.Equal(t, 1, h.Version)
+}
+
+func TestParseTolerant(t *testing.T) {
+
+	// unknown fields are ignored
+	s, ok := Parse([]byte(`{"cmd":"crq","what":"dut1","client":"lab3"}`))
+	assert.True(t, ok)
+	crq, ok := s.(pocket.CalibratedRangeQuery)
+	assert.True(t, ok)
+	assert.Equal(t, "dut1", crq.What)
+
+	// commands are not case sensitive
+	s, ok = Parse([]byte(`{"CMD":"RangeCal","id":"a","size":2}`))
+	assert.True(t, ok)
+	rq, ok := s.(pocket.RangeQuery)
+	assert.True(t, ok)
+	assert.Equal(t, "rc", rq.Command.Command)
+	assert.Equal(t, "a", rq.ID)
+	assert.Equal(t, 2, rq.Size)
+
+	for data, want := range map[string]string{
+		`{"cmd":"rangcal","id":"b"}`: "unknown command rangcal, did you mean rangecal?",
+		`{"cmd":"CRQQ"}`:             "unknown command CRQQ, did you mean crq?",
+		`{"cmd":"nope"}`:             `unknown command nope, send {"cmd":"hello"} to list the commands`,
+		`{"what":"dut1"}`:            `cmd is missing, e.g. {"cmd":"hello"} to list the commands`,
+		`{"cmd":"rq"`:                "request is not valid JSON because unexpected EOF",
+		`{"cmd":7}`:                  "cmd must be a string, not 7",
+		`"rq"`:                       "request must be an object, not \"rq\"",
+	} {
+		s, ok = Parse([]byte(data))
+		assert.True(t, ok, data)
+		i, ok := s.(pocket.Invalid)
+		assert.True(t, ok, data)
+		assert.Equal(t, []string{want}, i.Errors, data)
+	}
+
+	// the id is kept, so the error can be matched to the request
+	s, _ = Parse([]byte(`{"cmd":"rangcal","id":"b"}`))
+	b, err := json.Marshal(s)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"id":"b"`)
+
+	_, ok = Parse([]byte(" \n"))
+	assert.False(t, ok)
+}
+
+// FuzzParse checks that nothing a client sends can crash the daemon, and that
+// whatever is parsed can be sent back as JSON
+func FuzzParse(f *testing.F) {
+
+	for _, c := range Commands {
+		f.Add([]byte(`{"cmd":"` + c + `"}`))
+	}
+
+	f.Add([]byte(`{"cmd":"crq","what":"dut1","avg":-1,"fromat":"db","sparam":{"s11":"yes"}}`))
+	f.Add([]byte(`{"cmd":"batch","commands":[{"cmd":"batch","commands":[{"cmd":"RQ"}]},7,null]}`))
+	f.Add([]byte(`{"cmd":"rq","range":{"start":1e99,"end":-0},"segments":[{}],"frequencies":[1,2,"3"]}`))
+	f.Add([]byte(`{"CMD":"setlimits","limits":[{"sparam":"s21","start":1,"end":2,"max":null}]}`))
+	f.Add([]byte(`[{"cmd":"rq"}]`))
+	f.Add([]byte(`{"cmd":"sf","s2p":"# MHz S RI R 50\n1 2 3"}`))
+	f.Add([]byte("\xff\xfe{\"cmd\":\"hello\"}"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+
+		s, ok := Parse(data)
+
+		if !ok {
+			return
+		}
+
+		if s == nil {
+			t.Fatalf("nil request for %q", data)
+		}
+
+		if _, err := json.Marshal(s); err != nil {
+			t.Fatalf("cannot send back %q because %s", data, err.Error())
+		}
+	})
+}
+
+func TestParseInvalid(t *testing.T) {
+
+	s, ok := Parse([]byte(`{"cmd":"crq","id":"x","what":"dut1","avg":-1,"fromat":"db"}`))
+	assert.True(t, ok)
+
+	i, ok := s.(pocket.Invalid)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"avg must be a whole number from 0 to 65535, not -1", "fromat is not a known field, did you mean format?"}, i.Errors)
+
+	// sent back as the request
+	b, err := json.Marshal(i)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"id":"x"`)
+
+	s, ok = Parse([]byte(`{"cmd":"batch","commands":[{"cmd":"sp","power":"high"}]}`))
+	assert.True(t, ok)
+	_, ok = s.(pocket.Batch).Requests[0].(pocket.Invalid)
+	assert.True(t, ok)
+
+	sc := Schemas()
+	assert.Equal(t, "CalibratedRangeQuery", sc["crq"].Title)
+	assert.Equal(t, "CustomResult", sc["error"].Title)
+}
+
+func TestServer(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	s := Serve(ctx, ln, Listen{Token: "secret"})
+
+	u := "ws://" + ln.Addr().String() + "/ws/data"
+
+	_, resp, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	a, _, err := websocket.DefaultDialer.Dial(u+"?token=secret", nil)
+	assert.NoError(t, err)
+	defer a.Close()
+
+	b, _, err := websocket.DefaultDialer.Dial(u, http.Header{"Authorization": []string{"Bearer secret"}})
+	assert.NoError(t, err)
+	defer b.Close()
+
+	err = a.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"rr","id":"a"}`))
+	assert.NoError(t, err)
+
+	select {
+	case r := <-s.Request:
+		assert.Equal(t, "a", r.(pocket.ReasonableFrequencyRange).ID)
+	case <-time.After(time.Second):
+		t.Fatal("no request")
+	}
+
+	s.Response <- pocket.ReasonableFrequencyRange{Command: pocket.Command{ID: "a", Command: "rr"}}
+
+	// both clients get the response, after any heartbeats
+	for _, c := range []*websocket.Conn{a, b} {
+		for {
+			_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, data, err := c.ReadMessage()
+			if !assert.NoError(t, err) {
+				break
+			}
+			if strings.Contains(string(data), `"rr"`) {
+				assert.Contains(t, string(data), `"id":"a"`)
+				break
+			}
+		}
+	}
+
+	// the clients are closed with the stream
+	cancel()
+	_ = a.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err = a.ReadMessage(); err != nil {
+			break
+		}
+	}
+}
+
+func TestServerSessions(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	s := Serve(ctx, ln, Listen{Address: true})
+
+	u := "ws://" + ln.Addr().String() + "/ws/data"
+
+	a, _, err := websocket.DefaultDialer.Dial(u+"?session=alice", nil)
+	assert.NoError(t, err)
+	defer a.Close()
+
+	b, _, err := websocket.DefaultDialer.Dial(u, nil)
+	assert.NoError(t, err)
+	defer b.Close()
+
+	// b joins its session by sending a request with it
+	err = b.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"rr","id":"b","session":"bob"}`))
+	assert.NoError(t, err)
+
+	select {
+	case r := <-s.Request:
+		assert.Equal(t, "bob", r.(pocket.ReasonableFrequencyRange).Session)
+	case <-time.After(time.Second):
+		t.Fatal("no request")
+	}
+
+	// a response goes only to its session, and one without a session goes to everyone
+	s.Response <- pocket.ReasonableFrequencyRange{Command: pocket.Command{ID: "b", Command: "rr", Session: "bob"}}
+	s.Response <- pocket.CustomResult{Message: "oops", Command: pocket.Command{ID: "a", Command: "rr", Session: "alice"}}
+	s.Response <- pocket.ReasonableFrequencyRange{Command: pocket.Command{ID: "all", Command: "rr"}}
+
+	next := func(c *websocket.Conn) string {
+		for {
+			_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+			_, data, err := c.ReadMessage()
+			if !assert.NoError(t, err) || !strings.Contains(string(data), `"hb"`) {
+				return string(data)
+			}
+		}
+	}
+
+	assert.Contains(t, next(a), `"message":"oops"`)
+	assert.Contains(t, next(a), `"id":"all"`)
+
+	r := next(b)
+	assert.Contains(t, r, `"id":"b"`)
+	assert.Contains(t, r, `"session":"bob"`)
+	assert.Contains(t, next(b), `"id":"all"`)
 }
 
 func reasonableRange(w http.ResponseWriter, r *http.Request) {
@@ -604,3 +1018,210 @@ func channelHandler(toClient, fromClient chan reconws.WsMessage, ctx context.Con
 	} //anon func
 
 }
+
+func TestSpectate(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the spectator topic, which tries to send a command, and passes on the rest
+	watched := make(chan string, 100)
+
+	spectators := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		_ = c.WriteMessage(websocket.TextMessage, []byte(`{"cmd":"rr","id":"spectator"}`))
+		for {
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			watched <- string(data)
+		}
+	}))
+	defer spectators.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+
+	s := Serve(ctx, ln, Listen{Address: true})
+	s.Spectate(ctx, "ws"+strings.TrimPrefix(spectators.URL, "http")+"/ws/spectate")
+
+	// a response to one session, and an event, are both mirrored
+	s.Response <- pocket.ReasonableFrequencyRange{Command: pocket.Command{ID: "a", Command: "rr", Session: "alice"}}
+	s.Publish(ctx, pocket.SwitchEvent{Command: pocket.Command{Command: "switchevent"}, From: "short", To: "dut1"})
+
+	var got []string
+
+	timeout := time.After(5 * time.Second)
+
+	for len(got) < 2 {
+		select {
+		case m := <-watched:
+			if !strings.Contains(m, `"hb"`) {
+				got = append(got, m)
+			}
+		case <-timeout:
+			t.Fatal("spectators did not get the messages")
+		}
+	}
+
+	assert.Contains(t, got[0], `"id":"a"`)
+	assert.Contains(t, got[1], `"switchevent"`)
+
+	// spectators cannot send commands
+	select {
+	case r := <-s.Request:
+		t.Fatalf("request from spectator topic %v", r)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// a stream that was not made by New or Serve is not mirrored
+	var none Stream
+	none.Spectate(ctx, "ws://localhost:1/ws/spectate")
+	mirror(none.spectator, reconws.WsMessage{}, ctx)
+}
+
+func TestChunk(t *testing.T) {
+
+	small := []byte(`{"id":"x","cmd":"rq"}`)
+
+	parts, err := Chunk(small, 2048, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]byte{small}, parts)
+
+	// characters that are escaped in JSON strings take up more room in a part
+	rq := pocket.RangeQuery{
+		Command: pocket.Command{ID: "big", Time: 7, Command: "rq"},
+		What:    "dut1 <\"Ω\"> & \n",
+	}
+
+	for i := 0; i < 500; i++ {
+		rq.Result = append(rq.Result, pocket.SParam{Freq: uint64(i) * 1000, S11: pocket.Complex{Real: 0.5, Imag: -0.25}})
+	}
+
+	payload, err := json.Marshal(rq)
+	assert.NoError(t, err)
+
+	parts, err = Chunk(payload, 2048, 3)
+	assert.NoError(t, err)
+	assert.Greater(t, len(parts), 1)
+
+	var whole string
+
+	for i, b := range parts {
+
+		assert.LessOrEqual(t, len(b), 2048)
+
+		var p pocket.Part
+		assert.NoError(t, json.Unmarshal(b, &p))
+		assert.Equal(t, "part", p.Command.Command)
+		assert.Equal(t, "big", p.ID)
+		assert.Equal(t, 7, p.Time)
+		assert.Equal(t, "rq", p.Of)
+		assert.Equal(t, 3, p.Message)
+		assert.Equal(t, i+1, p.Part)
+		assert.Equal(t, len(parts), p.Parts)
+
+		whole += p.Data
+	}
+
+	assert.Equal(t, string(payload), whole)
+
+	_, err = Chunk(payload, 100, 4)
+	assert.Error(t, err)
+}
+
+func TestPipeInterfaceToWsLimit(t *testing.T) {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan interface{})
+	out := make(chan reconws.WsMessage, 100)
+
+	max := &atomic.Int64{}
+	max.Store(1024)
+
+	go PipeInterfaceToWsLimit(in, out, max, ctx)
+
+	in <- pocket.Command{Command: "hb"}
+	assert.Equal(t, `{"id":"","t":0,"cmd":"hb"}`, string((<-out).Data))
+
+	in <- pocket.CustomResult{Message: strings.Repeat("x", 3000)}
+
+	var p pocket.Part
+	assert.NoError(t, json.Unmarshal((<-out).Data, &p))
+	assert.Equal(t, 1, p.Part)
+	assert.Greater(t, p.Parts, 1)
+
+	// no limit
+	max.Store(0)
+	in <- pocket.CustomResult{Message: strings.Repeat("y", 3000)}
+
+	for {
+		m := <-out
+		if strings.Contains(string(m.Data), "yyy") {
+			assert.Greater(t, len(m.Data), 3000)
+			break
+		}
+	}
+}
+
+// BenchmarkMarshal times the marshalling of a calibrated result of 1001 points, formatted
+// in dB, which is the largest response usually sent
+func BenchmarkMarshal(b *testing.B) {
+
+	r := pocket.CalibratedRangeQuery{Command: pocket.Command{Command: "crq", ID: "bench"}}
+
+	for i := 0; i < 1001; i++ {
+		v := pocket.Value{Mag: -6.02 - float64(i)/1000, Phase: float64(i%360) - 180}
+		r.Result = append(r.Result, pocket.SParam{
+			Freq: uint64(1e6 + i*3e6),
+			S11:  pocket.Complex{Real: 0.1, Imag: -0.05},
+			S12:  pocket.Complex{Real: 0.5, Imag: 0.001 * float64(i)},
+			S21:  pocket.Complex{Real: 0.5, Imag: 0.001 * float64(i)},
+			S22:  pocket.Complex{Real: -0.1, Imag: 0.05},
+		})
+		r.Formatted = append(r.Formatted, pocket.FormattedSParam{Freq: uint64(1e6 + i*3e6), S11: &v, S12: &v, S21: &v, S22: &v})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := json.Marshal(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMarshalParallel is BenchmarkMarshal with the result marshalled in parallel, as it is sent
+func BenchmarkMarshalParallel(b *testing.B) {
+
+	r := pocket.CalibratedRangeQuery{Command: pocket.Command{Command: "crq", ID: "bench"}}
+
+	for i := 0; i < 1001; i++ {
+		v := pocket.Value{Mag: -6.02 - float64(i)/1000, Phase: float64(i%360) - 180}
+		r.Result = append(r.Result, pocket.SParam{
+			Freq: uint64(1e6 + i*3e6),
+			S11:  pocket.Complex{Real: 0.1, Imag: -0.05},
+			S12:  pocket.Complex{Real: 0.5, Imag: 0.001 * float64(i)},
+			S21:  pocket.Complex{Real: 0.5, Imag: 0.001 * float64(i)},
+			S22:  pocket.Complex{Real: -0.1, Imag: 0.05},
+		})
+		r.Formatted = append(r.Formatted, pocket.FormattedSParam{Freq: uint64(1e6 + i*3e6), S11: &v, S12: &v, S21: &v, S22: &v})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := pocket.Marshal(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
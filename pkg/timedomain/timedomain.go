@@ -0,0 +1,248 @@
+// package timedomain transforms calibrated frequency-domain S-parameters into
+// impulse and step responses, e.g. for distance-to-fault measurements
+//
+// If the frequencies are harmonics of the step size (start == step), a lowpass
+// transform is used. DC is extrapolated from the first two points, and the
+// response is real, so a step response can be found too. Otherwise a bandpass
+// transform gives the magnitude of the impulse response only.
+package timedomain
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+)
+
+const (
+	None   = "none"
+	Hann   = "hann"
+	Kaiser = "kaiser"
+
+	Lowpass  = "lowpass"
+	Bandpass = "bandpass"
+
+	// DefaultBeta is the Kaiser window shape used if none is given
+	DefaultBeta = 6.0
+
+	// tolerance is the fractional error allowed in frequency spacing
+	tolerance = 1e-3
+)
+
+// Window selects how the data is tapered before transforming, trading
+// resolution (none) for lower sidelobes (hann, kaiser)
+type Window struct {
+	Name string
+	Beta float64 // only used by kaiser
+}
+
+// Response is the time domain version of one S-parameter
+type Response struct {
+	Mode    string    // lowpass or bandpass
+	Time    []float64 // seconds
+	Impulse []float64
+	Step    []float64 // lowpass only
+}
+
+// Transform finds the time domain response of data measured at freq (Hz)
+// which must be linearly spaced
+func Transform(freq []float64, data []complex128, w Window) (Response, error) {
+
+	if len(freq) != len(data) {
+		return Response{}, fmt.Errorf("have %d frequencies but %d data points", len(freq), len(data))
+	}
+
+	if len(freq) < 2 {
+		return Response{}, errors.New("need at least two points to transform")
+	}
+
+	df := freq[1] - freq[0]
+
+	if df <= 0 {
+		return Response{}, errors.New("frequencies must be increasing")
+	}
+
+	for i := 1; i < len(freq); i++ {
+		if math.Abs(freq[i]-freq[i-1]-df) > tolerance*df {
+			return Response{}, errors.New("frequencies must be linearly spaced for a time domain transform")
+		}
+	}
+
+	wf, err := windowFunc(w)
+
+	if err != nil {
+		return Response{}, err
+	}
+
+	if math.Abs(freq[0]-df) <= tolerance*df {
+		return lowpass(df, data, wf), nil
+	}
+
+	return bandpass(df, data, wf), nil
+}
+
+func lowpass(df float64, data []complex128, wf func(float64) float64) Response {
+
+	n := len(data)
+
+	// space for the positive and negative frequencies, zero padded for a smoother result
+	m := nextPow2(4 * (n + 1))
+
+	x := make([]complex128, m)
+
+	// linear extrapolation to DC, which must be real
+	x[0] = complex(real(2*data[0]-data[1]), 0)
+
+	for k := 1; k <= n; k++ {
+		v := data[k-1] * complex(wf(float64(k)/float64(n)), 0)
+		x[k] = v
+		x[m-k] = cmplx.Conj(v)
+	}
+
+	y := ifft(x)
+
+	r := Response{
+		Mode:    Lowpass,
+		Time:    make([]float64, m/2),
+		Impulse: make([]float64, m/2),
+		Step:    make([]float64, m/2),
+	}
+
+	sum := 0.0
+
+	for i := 0; i < m/2; i++ {
+		r.Time[i] = float64(i) / (float64(m) * df)
+		r.Impulse[i] = real(y[i])
+		sum += real(y[i])
+		r.Step[i] = sum
+	}
+
+	return r
+}
+
+func bandpass(df float64, data []complex128, wf func(float64) float64) Response {
+
+	n := len(data)
+
+	m := nextPow2(4 * n)
+
+	x := make([]complex128, m)
+
+	for k := 0; k < n; k++ {
+		// window is centred on the middle of the band
+		pos := 2*float64(k)/float64(n-1) - 1
+		x[k] = data[k] * complex(wf(pos), 0)
+	}
+
+	y := ifft(x)
+
+	r := Response{
+		Mode:    Bandpass,
+		Time:    make([]float64, m),
+		Impulse: make([]float64, m),
+	}
+
+	for i := 0; i < m; i++ {
+		r.Time[i] = float64(i) / (float64(m) * df)
+		// scale so that a flat response gives a unit peak
+		r.Impulse[i] = cmplx.Abs(y[i]) * float64(m) / float64(n)
+	}
+
+	return r
+}
+
+// windowFunc returns the window as a function of position, from -1 to 1
+func windowFunc(w Window) (func(float64) float64, error) {
+
+	switch strings.ToLower(w.Name) {
+
+	case "", None:
+		return func(x float64) float64 { return 1 }, nil
+
+	case Hann:
+		return func(x float64) float64 { return 0.5 * (1 + math.Cos(math.Pi*x)) }, nil
+
+	case Kaiser:
+		beta := w.Beta
+		if beta == 0 {
+			beta = DefaultBeta
+		}
+		if beta < 0 {
+			return nil, errors.New("kaiser beta must not be negative")
+		}
+		norm := besselI0(beta)
+		return func(x float64) float64 {
+			return besselI0(beta*math.Sqrt(math.Max(0, 1-x*x))) / norm
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown window %s, use none, hann or kaiser", w.Name)
+	}
+
+}
+
+// besselI0 is the modified Bessel function of the first kind, order zero
+func besselI0(x float64) float64 {
+
+	sum := 1.0
+	term := 1.0
+
+	for k := 1; k < 100; k++ {
+		term *= (x / (2 * float64(k))) * (x / (2 * float64(k)))
+		sum += term
+		if term < 1e-12*sum {
+			break
+		}
+	}
+
+	return sum
+}
+
+func nextPow2(n int) int {
+	m := 1
+	for m < n {
+		m <<= 1
+	}
+	return m
+}
+
+// ifft is an inverse radix-2 FFT, scaled by 1/len(x), which must be a power of two
+func ifft(x []complex128) []complex128 {
+
+	n := len(x)
+	y := make([]complex128, n)
+	copy(y, x)
+
+	// bit reversal permutation
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			y[i], y[j] = y[j], y[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, 2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				a := y[start+k]
+				b := y[start+k+size/2] * w
+				y[start+k] = a + b
+				y[start+k+size/2] = a - b
+				w *= step
+			}
+		}
+	}
+
+	for i := range y {
+		y[i] /= complex(float64(n), 0)
+	}
+
+	return y
+}
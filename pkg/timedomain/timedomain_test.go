@@ -0,0 +1,96 @@
+package timedomain
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// delay makes the response of a reflection with magnitude g, delayed by tau
+func delay(start, step float64, n int, g, tau float64) ([]float64, []complex128) {
+
+	f := make([]float64, n)
+	d := make([]complex128, n)
+
+	for i := 0; i < n; i++ {
+		f[i] = start + float64(i)*step
+		d[i] = complex(g, 0) * cmplx.Exp(complex(0, -2*math.Pi*f[i]*tau))
+	}
+
+	return f, d
+}
+
+func peak(r Response) (float64, float64) {
+
+	idx := 0
+	for i, v := range r.Impulse {
+		if math.Abs(v) > math.Abs(r.Impulse[idx]) {
+			idx = i
+		}
+	}
+
+	return r.Time[idx], r.Impulse[idx]
+}
+
+func TestLowpass(t *testing.T) {
+
+	tau := 5e-9
+
+	f, d := delay(10e6, 10e6, 400, -1, tau)
+
+	for _, w := range []Window{{Name: None}, {Name: Hann}, {Name: Kaiser}} {
+
+		r, err := Transform(f, d, w)
+
+		assert.NoError(t, err)
+		assert.Equal(t, Lowpass, r.Mode)
+		assert.Equal(t, len(r.Time), len(r.Step))
+
+		at, v := peak(r)
+
+		assert.InDelta(t, tau, at, 0.2e-9, w.Name)
+		assert.True(t, v < 0, w.Name)
+
+		// a short at the end of a line steps down to -1
+		assert.InDelta(t, -1, r.Step[len(r.Step)-1], 0.05, w.Name)
+
+	}
+
+}
+
+func TestBandpass(t *testing.T) {
+
+	tau := 8e-9
+
+	f, d := delay(100e6, 5e6, 300, 0.5, tau)
+
+	r, err := Transform(f, d, Window{Name: Hann})
+
+	assert.NoError(t, err)
+	assert.Equal(t, Bandpass, r.Mode)
+	assert.Nil(t, r.Step)
+
+	at, v := peak(r)
+
+	assert.InDelta(t, tau, at, 0.2e-9)
+	assert.True(t, v > 0)
+
+}
+
+func TestTransformErrors(t *testing.T) {
+
+	_, err := Transform([]float64{1, 2}, []complex128{1}, Window{})
+	assert.Error(t, err)
+
+	_, err = Transform([]float64{1, 2, 4}, []complex128{1, 1, 1}, Window{})
+	assert.Error(t, err)
+
+	_, err = Transform([]float64{1, 2, 3}, []complex128{1, 1, 1}, Window{Name: "triangle"})
+	assert.Error(t, err)
+
+	_, err = Transform([]float64{1, 2, 3}, []complex128{1, 1, 1}, Window{Name: Kaiser, Beta: -1})
+	assert.Error(t, err)
+
+}
@@ -0,0 +1,216 @@
+// package touchstone reads and writes one or two-port S-parameter data in touchstone (.s1p, .s2p) format
+//
+// The option line sets the frequency unit (Hz, kHz, MHz, GHz), the data format
+// (RI, MA, DB) and the reference impedance, e.g.
+//
+//	# GHz S RI R 50
+//
+// Each data line holds frequency, S11 for a one-port file, or
+// frequency, S11, S21, S12, S22 (note the order) for a two-port file.
+// Comments start with !
+package touchstone
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+// Data holds the parsed file
+type Data struct {
+	Ports  int     // 1 or 2
+	Z0     float64 // reference impedance, ohms
+	SParam []pocket.SParam
+}
+
+// Parse reads the contents of an .s1p or .s2p file
+func Parse(s string) (Data, error) {
+
+	d := Data{Z0: 50}
+
+	// defaults from the touchstone specification
+	unit := 1e9
+	format := "ma"
+	seenOption := false
+
+	scanner := bufio.NewScanner(strings.NewReader(s))
+
+	line := 0
+
+	for scanner.Scan() {
+
+		line++
+
+		text := scanner.Text()
+
+		if idx := strings.Index(text, "!"); idx >= 0 {
+			text = text[:idx]
+		}
+
+		fields := strings.Fields(strings.ToLower(text))
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		if fields[0] == "#" || strings.HasPrefix(fields[0], "#") {
+
+			if seenOption {
+				continue // only the first option line counts
+			}
+			seenOption = true
+
+			fields[0] = strings.TrimPrefix(fields[0], "#")
+
+			for i := 0; i < len(fields); i++ {
+				switch fields[i] {
+				case "":
+				case "hz":
+					unit = 1
+				case "khz":
+					unit = 1e3
+				case "mhz":
+					unit = 1e6
+				case "ghz":
+					unit = 1e9
+				case "s":
+				case "y", "z", "h", "g":
+					return d, fmt.Errorf("only S parameters are supported, not %s", fields[i])
+				case "ri", "ma", "db":
+					format = fields[i]
+				case "r":
+					if i+1 >= len(fields) {
+						return d, errors.New("option line is missing the reference impedance")
+					}
+					z, err := strconv.ParseFloat(fields[i+1], 64)
+					if err != nil {
+						return d, fmt.Errorf("reference impedance %s is not a number", fields[i+1])
+					}
+					d.Z0 = z
+					i++
+				default:
+					return d, fmt.Errorf("unknown option %s", fields[i])
+				}
+			}
+			continue
+		}
+
+		ports := 0
+
+		switch len(fields) {
+		case 3:
+			ports = 1
+		case 9:
+			ports = 2
+		default:
+			return d, fmt.Errorf("line %d has %d values but a one-port file needs 3, and a two-port file needs 9", line, len(fields))
+		}
+
+		if d.Ports == 0 {
+			d.Ports = ports
+		}
+
+		if ports != d.Ports {
+			return d, fmt.Errorf("line %d has data for %d ports but earlier lines had %d", line, ports, d.Ports)
+		}
+
+		v := make([]float64, len(fields))
+
+		for i, f := range fields {
+			x, err := strconv.ParseFloat(f, 64)
+			if err != nil {
+				return d, fmt.Errorf("line %d has invalid value %s", line, f)
+			}
+			v[i] = x
+		}
+
+		if ports == 1 {
+			d.SParam = append(d.SParam, pocket.SParam{
+				Freq: uint64(math.Round(v[0] * unit)),
+				S11:  toComplex(format, v[1], v[2]),
+			})
+			continue
+		}
+
+		d.SParam = append(d.SParam, pocket.SParam{
+			Freq: uint64(math.Round(v[0] * unit)),
+			S11:  toComplex(format, v[1], v[2]),
+			S21:  toComplex(format, v[3], v[4]),
+			S12:  toComplex(format, v[5], v[6]),
+			S22:  toComplex(format, v[7], v[8]),
+		})
+
+	}
+
+	if err := scanner.Err(); err != nil {
+		return d, err
+	}
+
+	if len(d.SParam) == 0 {
+		return d, errors.New("no data found")
+	}
+
+	for i := 1; i < len(d.SParam); i++ {
+		if d.SParam[i].Freq <= d.SParam[i-1].Freq {
+			return d, errors.New("frequencies must be increasing")
+		}
+	}
+
+	return d, nil
+}
+
+func toComplex(format string, a, b float64) pocket.Complex {
+
+	var mag float64
+
+	switch format {
+	case "ri":
+		return pocket.Complex{Real: a, Imag: b}
+	case "db":
+		mag = math.Pow(10, a/20)
+	default:
+		mag = a
+	}
+
+	angle := b * math.Pi / 180
+
+	return pocket.Complex{
+		Real: mag * math.Cos(angle),
+		Imag: mag * math.Sin(angle),
+	}
+}
+
+// Write writes s to w as a two-port file, with frequencies in Hz, real and imaginary
+// parts, and reference impedance z0. Each comment is written on a line of its own first.
+func Write(w io.Writer, s []pocket.SParam, z0 float64, comments ...string) error {
+
+	for _, c := range comments {
+		if _, err := fmt.Fprintf(w, "! %s\n", c); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "# Hz S RI R %g\n", z0); err != nil {
+		return err
+	}
+
+	for _, v := range s {
+		_, err := fmt.Fprintf(w, "%d %g %g %g %g %g %g %g %g\n", v.Freq,
+			v.S11.Real, v.S11.Imag,
+			v.S21.Real, v.S21.Imag,
+			v.S12.Real, v.S12.Imag,
+			v.S22.Real, v.S22.Imag)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
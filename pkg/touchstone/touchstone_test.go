@@ -0,0 +1,99 @@
+package touchstone
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+
+	s2p := `! an adapter
+# MHz S RI R 75
+100 0.1 0.2 0.9 -0.1 0.8 -0.2 0.3 0.4 ! first point
+200 0.1 0.2 0.9 -0.1 0.8 -0.2 0.3 0.4
+`
+
+	d, err := Parse(s2p)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 75.0, d.Z0)
+	assert.Equal(t, 2, d.Ports)
+	assert.Equal(t, 2, len(d.SParam))
+	assert.Equal(t, uint64(100e6), d.SParam[0].Freq)
+	assert.Equal(t, 0.9, d.SParam[0].S21.Real)
+	assert.Equal(t, 0.8, d.SParam[0].S12.Real)
+	assert.Equal(t, 0.4, d.SParam[1].S22.Imag)
+
+}
+
+func TestParseFormats(t *testing.T) {
+
+	d, err := Parse("#hz s db\n1 -20 90 0 0 0 180 -6.0206 0\n")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 50.0, d.Z0)
+	assert.InDelta(t, 0.1, d.SParam[0].S11.Imag, 1e-9)
+	assert.InDelta(t, 1, d.SParam[0].S21.Real, 1e-9)
+	assert.InDelta(t, -1, d.SParam[0].S12.Real, 1e-9)
+	assert.InDelta(t, 0.5, d.SParam[0].S22.Real, 1e-4)
+
+	// default is GHz MA
+	d, err = Parse("1 1 0 1 0 1 0 1 0\n")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1e9), d.SParam[0].Freq)
+
+}
+
+func TestParseOnePort(t *testing.T) {
+
+	d, err := Parse("# Hz S RI R 50\n1e6 -0.99 0.01\n2e6 -0.98 0.02\n")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, d.Ports)
+	assert.Equal(t, 2, len(d.SParam))
+	assert.Equal(t, -0.98, d.SParam[1].S11.Real)
+
+	_, err = Parse("1 0 0\n2 0 0 0 0 0 0 0 0\n")
+	assert.Error(t, err)
+}
+
+func TestParseErrors(t *testing.T) {
+
+	_, err := Parse("")
+	assert.Error(t, err)
+
+	_, err = Parse("# GHz Z RI\n1 0 0 0 0 0 0 0 0\n")
+	assert.Error(t, err)
+
+	_, err = Parse("1 0 0 0 0\n")
+	assert.Error(t, err)
+
+	_, err = Parse("2 0 0 0 0 0 0 0 0\n1 0 0 0 0 0 0 0 0\n")
+	assert.Error(t, err)
+
+	_, err = Parse("1 0 0 0 0 0 0 0 x\n")
+	assert.Error(t, err)
+}
+
+func TestWrite(t *testing.T) {
+
+	s := []pocket.SParam{
+		{Freq: 100e6, S11: pocket.Complex{Real: 0.1, Imag: 0.2}, S21: pocket.Complex{Real: 0.9}, S12: pocket.Complex{Real: 0.8}, S22: pocket.Complex{Imag: -0.4}},
+		{Freq: 200e6, S11: pocket.Complex{Real: -0.1}},
+	}
+
+	var b strings.Builder
+
+	assert.NoError(t, Write(&b, s, 50, "short", "measured"))
+	assert.True(t, strings.HasPrefix(b.String(), "! short\n! measured\n# Hz S RI R 50\n"))
+
+	d, err := Parse(b.String())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, d.Ports)
+	assert.Equal(t, 50.0, d.Z0)
+	assert.Equal(t, s, d.SParam)
+}
@@ -0,0 +1,533 @@
+// package twoport does network operations on two-port S-parameters,
+// such as removing the effect of fixtures on either side of a DUT
+package twoport
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+// S is a two-port scattering matrix, indexed from zero
+type S [2][2]complex128
+
+// T is a two-port transfer (cascading) matrix, defined by [b1 a1] = T [a2 b2]
+// so that cascaded networks multiply in order
+type T [2][2]complex128
+
+// Thru is a perfect, zero-length connection
+var Thru = S{{0, 1}, {1, 0}}
+
+func FromSParam(p pocket.SParam) S {
+	return S{
+		{complex(p.S11.Real, p.S11.Imag), complex(p.S12.Real, p.S12.Imag)},
+		{complex(p.S21.Real, p.S21.Imag), complex(p.S22.Real, p.S22.Imag)},
+	}
+}
+
+func (s S) SParam(freq uint64) pocket.SParam {
+	return pocket.SParam{
+		Freq: freq,
+		S11:  pocket.Complex{Real: real(s[0][0]), Imag: imag(s[0][0])},
+		S12:  pocket.Complex{Real: real(s[0][1]), Imag: imag(s[0][1])},
+		S21:  pocket.Complex{Real: real(s[1][0]), Imag: imag(s[1][0])},
+		S22:  pocket.Complex{Real: real(s[1][1]), Imag: imag(s[1][1])},
+	}
+}
+
+// Flip swaps the ports, e.g. to turn a fixture around
+func (s S) Flip() S {
+	return S{
+		{s[1][1], s[1][0]},
+		{s[0][1], s[0][0]},
+	}
+}
+
+func (s S) T() (T, error) {
+
+	if s[1][0] == 0 {
+		return T{}, errors.New("cannot convert to T-parameters because S21 is zero")
+	}
+
+	det := s[0][0]*s[1][1] - s[0][1]*s[1][0]
+
+	return T{
+		{-det / s[1][0], s[0][0] / s[1][0]},
+		{-s[1][1] / s[1][0], 1 / s[1][0]},
+	}, nil
+}
+
+func (t T) S() (S, error) {
+
+	if t[1][1] == 0 {
+		return S{}, errors.New("cannot convert to S-parameters because T22 is zero")
+	}
+
+	det := t[0][0]*t[1][1] - t[0][1]*t[1][0]
+
+	return S{
+		{t[0][1] / t[1][1], det / t[1][1]},
+		{1 / t[1][1], -t[1][0] / t[1][1]},
+	}, nil
+}
+
+func (t T) Mul(u T) T {
+	return T{
+		{t[0][0]*u[0][0] + t[0][1]*u[1][0], t[0][0]*u[0][1] + t[0][1]*u[1][1]},
+		{t[1][0]*u[0][0] + t[1][1]*u[1][0], t[1][0]*u[0][1] + t[1][1]*u[1][1]},
+	}
+}
+
+func (t T) Inverse() (T, error) {
+
+	det := t[0][0]*t[1][1] - t[0][1]*t[1][0]
+
+	if cmplx.Abs(det) == 0 {
+		return T{}, errors.New("T-parameters are singular")
+	}
+
+	return T{
+		{t[1][1] / det, -t[0][1] / det},
+		{-t[1][0] / det, t[0][0] / det},
+	}, nil
+}
+
+// Cascade connects port 2 of a to port 1 of b
+func Cascade(a, b S) (S, error) {
+
+	ta, err := a.T()
+
+	if err != nil {
+		return S{}, err
+	}
+
+	tb, err := b.T()
+
+	if err != nil {
+		return S{}, err
+	}
+
+	return ta.Mul(tb).S()
+}
+
+// Deembed removes fixture a (on port 1) and fixture b (on port 2) from
+// measurement m. Both fixtures have their port 1 towards the VNA port 1,
+// i.e. the measurement is a, then the DUT, then b.
+func Deembed(m, a, b S) (S, error) {
+
+	tm, err := m.T()
+
+	if err != nil {
+		return S{}, fmt.Errorf("measurement: %s", err.Error())
+	}
+
+	ta, err := a.T()
+
+	if err != nil {
+		return S{}, fmt.Errorf("port 1 fixture: %s", err.Error())
+	}
+
+	tb, err := b.T()
+
+	if err != nil {
+		return S{}, fmt.Errorf("port 2 fixture: %s", err.Error())
+	}
+
+	ia, err := ta.Inverse()
+
+	if err != nil {
+		return S{}, fmt.Errorf("port 1 fixture: %s", err.Error())
+	}
+
+	ib, err := tb.Inverse()
+
+	if err != nil {
+		return S{}, fmt.Errorf("port 2 fixture: %s", err.Error())
+	}
+
+	return ia.Mul(tm).Mul(ib).S()
+}
+
+// Extend moves the reference planes further from the VNA by the one-way
+// electrical delays d1 (port 1) and d2 (port 2), in seconds, at freq in Hz,
+// by removing the phase shift of the extra line
+func (s S) Extend(freq, d1, d2 float64) S {
+
+	w := 2 * math.Pi * freq
+
+	e1 := cmplx.Exp(complex(0, w*d1))
+	e2 := cmplx.Exp(complex(0, w*d2))
+
+	return S{
+		{s[0][0] * e1 * e1, s[0][1] * e1 * e2},
+		{s[1][0] * e1 * e2, s[1][1] * e2 * e2},
+	}
+}
+
+// Renormalize changes the reference impedance of both ports from z0 to z (ohms)
+// using S' = (S - rI)(I - rS)^-1 where r = (z - z0)/(z + z0)
+func (s S) Renormalize(z0, z float64) (S, error) {
+
+	if z0 <= 0 || z <= 0 {
+		return S{}, errors.New("reference impedances must be positive")
+	}
+
+	r := complex((z-z0)/(z+z0), 0)
+
+	a := S{
+		{s[0][0] - r, s[0][1]},
+		{s[1][0], s[1][1] - r},
+	}
+
+	b := S{
+		{1 - r*s[0][0], -r * s[0][1]},
+		{-r * s[1][0], 1 - r*s[1][1]},
+	}
+
+	det := b[0][0]*b[1][1] - b[0][1]*b[1][0]
+
+	if det == 0 {
+		return S{}, errors.New("cannot renormalize because the network is singular")
+	}
+
+	bi := S{
+		{b[1][1] / det, -b[0][1] / det},
+		{-b[1][0] / det, b[0][0] / det},
+	}
+
+	return S{
+		{a[0][0]*bi[0][0] + a[0][1]*bi[1][0], a[0][0]*bi[0][1] + a[0][1]*bi[1][1]},
+		{a[1][0]*bi[0][0] + a[1][1]*bi[1][0], a[1][0]*bi[0][1] + a[1][1]*bi[1][1]},
+	}, nil
+}
+
+// Resample linearly interpolates data (real and imaginary parts separately)
+// onto the frequencies in freq, which must lie within the range of data
+func Resample(data []pocket.SParam, freq []uint64) ([]pocket.SParam, error) {
+
+	if len(data) == 0 {
+		return nil, errors.New("no data to resample")
+	}
+
+	first := data[0].Freq
+	last := data[len(data)-1].Freq
+
+	var r []pocket.SParam
+
+	j := 0
+
+	for _, f := range freq {
+
+		if f < first || f > last {
+			return nil, fmt.Errorf("frequency %d Hz is outside the range %d - %d Hz", f, first, last)
+		}
+
+		// frequencies are usually increasing, so carry on from the last interval
+		if j > 0 && data[j].Freq > f {
+			j = 0
+		}
+
+		for j < len(data)-1 && data[j+1].Freq < f {
+			j++
+		}
+
+		if data[j].Freq == f || j == len(data)-1 {
+			p := data[j]
+			p.Freq = f
+			r = append(r, p)
+			continue
+		}
+
+		lo := data[j]
+		hi := data[j+1]
+
+		x := float64(f-lo.Freq) / float64(hi.Freq-lo.Freq)
+
+		r = append(r, pocket.SParam{
+			Freq: f,
+			S11:  lerp(lo.S11, hi.S11, x),
+			S12:  lerp(lo.S12, hi.S12, x),
+			S21:  lerp(lo.S21, hi.S21, x),
+			S22:  lerp(lo.S22, hi.S22, x),
+		})
+	}
+
+	return r, nil
+}
+
+func lerp(a, b pocket.Complex, x float64) pocket.Complex {
+	return pocket.Complex{
+		Real: a.Real + x*(b.Real-a.Real),
+		Imag: a.Imag + x*(b.Imag-a.Imag),
+	}
+}
+
+// Terminate adds the effect of imperfect switch terminations to s, where s is
+// the raw measurement that an ideal switch would give. gf is the reflection of
+// the idle port 2 when driving port 1 (forward), and gr is the reflection of
+// the idle port 1 when driving port 2 (reverse)
+func (s S) Terminate(gf, gr complex128) S {
+
+	df := 1 - s[1][1]*gf
+	dr := 1 - s[0][0]*gr
+
+	return S{
+		{s[0][0] + s[0][1]*s[1][0]*gf/df, s[0][1] / dr},
+		{s[1][0] / df, s[1][1] + s[1][0]*s[0][1]*gr/dr},
+	}
+}
+
+// Unterminate removes the effect of the switch terms gf, gr (see Terminate)
+// from the raw measurement s
+func (s S) Unterminate(gf, gr complex128) S {
+
+	d := 1 - s[0][1]*s[1][0]*gf*gr
+
+	return S{
+		{(s[0][0] - s[0][1]*s[1][0]*gf) / d, (s[0][1] - s[0][0]*s[0][1]*gr) / d},
+		{(s[1][0] - s[1][1]*s[1][0]*gf) / d, (s[1][1] - s[0][1]*s[1][0]*gr) / d},
+	}
+}
+
+// SwitchTerms finds the forward and reverse switch terms from raw measurements
+// of at least three different reciprocal, transmissive devices (e.g. the thru and
+// two DUTs), at one frequency. Once the switch terms are removed, the ratio
+// S21/S12 of any reciprocal device depends only on the error boxes, so is the
+// same for all the devices, which gives one linear equation per device in
+// gf, k and k.gr, where k is that ratio.
+func SwitchTerms(m []S) (gf, gr complex128, err error) {
+
+	if len(m) < 3 {
+		return 0, 0, fmt.Errorf("need at least three reciprocal devices to find switch terms, not %d", len(m))
+	}
+
+	// least squares, via the normal equations
+	var a [3][3]complex128
+	var b [3]complex128
+
+	for _, s := range m {
+
+		row := [3]complex128{-s[1][0] * s[1][1], -s[0][1], s[0][0] * s[0][1]}
+		rhs := -s[1][0]
+
+		for i := 0; i < 3; i++ {
+			for j := 0; j < 3; j++ {
+				a[i][j] += cmplx.Conj(row[i]) * row[j]
+			}
+			b[i] += cmplx.Conj(row[i]) * rhs
+		}
+	}
+
+	x, err := solve3(a, b)
+
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot find switch terms because %s - are the devices different enough?", err.Error())
+	}
+
+	if x[1] == 0 {
+		return 0, 0, errors.New("cannot find switch terms because the devices do not transmit")
+	}
+
+	return x[0], x[2] / x[1], nil
+}
+
+// ErrorTerms are the twelve-term error model of a two-port calibration at one
+// frequency, as found by the calibration service. The names follow the usual
+// convention, e.g. Edf is forward directivity and Elr is reverse load match.
+type ErrorTerms struct {
+	Edf, Esf, Erf, Etf, Elf, Exf complex128 // forward directivity, source match, reflection tracking, transmission tracking, load match, isolation
+	Edr, Esr, Err, Etr, Elr, Exr complex128 // the same, in reverse
+}
+
+// Measure returns the raw measurement of a device with actual S-parameters s,
+// as seen through the error terms e (mainly for testing Correct)
+func (e ErrorTerms) Measure(s S) S {
+
+	det := s[0][0]*s[1][1] - s[0][1]*s[1][0]
+
+	df := 1 - e.Esf*s[0][0] - e.Elf*s[1][1] + e.Esf*e.Elf*det
+	dr := 1 - e.Esr*s[1][1] - e.Elr*s[0][0] + e.Esr*e.Elr*det
+
+	return S{
+		{e.Edf + e.Erf*(s[0][0]-e.Elf*det)/df, e.Exr + e.Etr*s[0][1]/dr},
+		{e.Exf + e.Etf*s[1][0]/df, e.Edr + e.Err*(s[1][1]-e.Elr*det)/dr},
+	}
+}
+
+// Correct removes the error terms e from the raw measurement m, giving the
+// calibrated S-parameters of the device
+func (e ErrorTerms) Correct(m S) S {
+
+	n11 := (m[0][0] - e.Edf) / e.Erf
+	n21 := (m[1][0] - e.Exf) / e.Etf
+	n12 := (m[0][1] - e.Exr) / e.Etr
+	n22 := (m[1][1] - e.Edr) / e.Err
+
+	d := (1+n11*e.Esf)*(1+n22*e.Esr) - n21*n12*e.Elf*e.Elr
+
+	return S{
+		{(n11*(1+n22*e.Esr) - e.Elf*n21*n12) / d, n12 * (1 + n11*(e.Esf-e.Elr)) / d},
+		{n21 * (1 + n22*(e.Esr-e.Elf)) / d, (n22*(1+n11*e.Esf) - e.Elr*n21*n12) / d},
+	}
+}
+
+// Standards holds the S-parameters of the short, open, load and thru at one
+// frequency, either as measured, or as they actually are. The short, open and
+// load are on both ports at once, so S11 is that on port 1 and S22 that on port 2.
+type Standards struct {
+	Short, Open, Load, Thru S
+}
+
+// Ideal are perfect standards, with a zero-length thru
+var Ideal = Standards{
+	Short: S{{-1, 0}, {0, -1}},
+	Open:  S{{1, 0}, {0, 1}},
+	Load:  S{},
+	Thru:  Thru,
+}
+
+// Solve finds the twelve error terms from the raw measurements m of the
+// standards, whose actual S-parameters are a. It is a plain SOLT calibration,
+// with the isolation taken from the loads.
+func Solve(m, a Standards) (ErrorTerms, error) {
+
+	f, err := forward(m, a)
+
+	if err != nil {
+		return ErrorTerms{}, fmt.Errorf("cannot find forward error terms because %s", err.Error())
+	}
+
+	flip := func(s Standards) Standards {
+		return Standards{Short: s.Short.Flip(), Open: s.Open.Flip(), Load: s.Load.Flip(), Thru: s.Thru.Flip()}
+	}
+
+	r, err := forward(flip(m), flip(a))
+
+	if err != nil {
+		return ErrorTerms{}, fmt.Errorf("cannot find reverse error terms because %s", err.Error())
+	}
+
+	return ErrorTerms{
+		Edf: f.Edf, Esf: f.Esf, Erf: f.Erf, Etf: f.Etf, Elf: f.Elf, Exf: f.Exf,
+		Edr: r.Edf, Esr: r.Esf, Err: r.Erf, Etr: r.Etf, Elr: r.Elf, Exr: r.Exf,
+	}, nil
+}
+
+// OnePort finds the directivity, source match and reflection tracking of a port
+// from the raw reflections m of three standards whose actual reflections are a
+func OnePort(m, a [3]complex128) (ed, es, er complex128, err error) {
+
+	// each standard gives one equation, m = Ed + a.m.Es + a.(Er - Ed.Es),
+	// which is linear in Ed, Es, and Er - Ed.Es
+	var k [3][3]complex128
+
+	for i := range m {
+		k[i] = [3]complex128{1, a[i] * m[i], a[i]}
+	}
+
+	x, err := solve3(k, m)
+
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("the reflection standards %s - are they different enough?", err.Error())
+	}
+
+	ed, es, er = x[0], x[1], x[2]+x[0]*x[1]
+
+	if er == 0 {
+		return 0, 0, 0, errors.New("the reflection tracking is zero")
+	}
+
+	return ed, es, er, nil
+}
+
+// forward finds the forward error terms, leaving the reverse ones zero
+func forward(m, a Standards) (ErrorTerms, error) {
+
+	ed, es, er, err := OnePort(
+		[3]complex128{m.Short[0][0], m.Open[0][0], m.Load[0][0]},
+		[3]complex128{a.Short[0][0], a.Open[0][0], a.Load[0][0]},
+	)
+
+	if err != nil {
+		return ErrorTerms{}, err
+	}
+
+	e := ErrorTerms{
+		Edf: ed,
+		Esf: es,
+		Erf: er,
+		Exf: m.Load[1][0],
+	}
+
+	// the reflection at port 1 of the thru, corrected for port 1, depends only on the load match
+	t := a.Thru
+	det := t[0][0]*t[1][1] - t[0][1]*t[1][0]
+	g := (m.Thru[0][0] - e.Edf) / e.Erf
+
+	d := det - g*(t[1][1]-e.Esf*det)
+
+	if d == 0 || t[1][0] == 0 {
+		return ErrorTerms{}, errors.New("the thru does not transmit")
+	}
+
+	e.Elf = (t[0][0] - g*(1-e.Esf*t[0][0])) / d
+
+	df := 1 - e.Esf*t[0][0] - e.Elf*t[1][1] + e.Esf*e.Elf*det
+
+	e.Etf = (m.Thru[1][0] - e.Exf) * df / t[1][0]
+
+	return e, nil
+}
+
+// solve3 solves a x = b by gaussian elimination with partial pivoting
+func solve3(a [3][3]complex128, b [3]complex128) ([3]complex128, error) {
+
+	var x [3]complex128
+
+	scale := 0.0
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			scale = math.Max(scale, cmplx.Abs(a[i][j]))
+		}
+	}
+
+	for c := 0; c < 3; c++ {
+
+		p := c
+
+		for r := c + 1; r < 3; r++ {
+			if cmplx.Abs(a[r][c]) > cmplx.Abs(a[p][c]) {
+				p = r
+			}
+		}
+
+		if cmplx.Abs(a[p][c]) <= 1e-12*scale {
+			return x, errors.New("equations are singular")
+		}
+
+		a[c], a[p] = a[p], a[c]
+		b[c], b[p] = b[p], b[c]
+
+		for r := c + 1; r < 3; r++ {
+			f := a[r][c] / a[c][c]
+			for k := c; k < 3; k++ {
+				a[r][k] -= f * a[c][k]
+			}
+			b[r] -= f * b[c]
+		}
+	}
+
+	for r := 2; r >= 0; r-- {
+		v := b[r]
+		for k := r + 1; k < 3; k++ {
+			v -= a[r][k] * x[k]
+		}
+		x[r] = v / a[r][r]
+	}
+
+	return x, nil
+}
@@ -0,0 +1,247 @@
+package twoport
+
+import (
+	"math"
+	"math/cmplx"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertNear(t *testing.T, expected, actual S) {
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			assert.InDelta(t, 0, cmplx.Abs(expected[i][j]-actual[i][j]), 1e-9, "S%d%d", i+1, j+1)
+		}
+	}
+}
+
+func TestConversion(t *testing.T) {
+
+	s := S{{0.1 + 0.2i, 0.7 - 0.1i}, {0.8 - 0.2i, -0.3 + 0.1i}}
+
+	tt, err := s.T()
+	assert.NoError(t, err)
+
+	s2, err := tt.S()
+	assert.NoError(t, err)
+	assertNear(t, s, s2)
+
+	p := s.SParam(123)
+	assert.Equal(t, uint64(123), p.Freq)
+	assertNear(t, s, FromSParam(p))
+
+	_, err = S{}.T()
+	assert.Error(t, err)
+
+}
+
+func TestCascade(t *testing.T) {
+
+	s := S{{0.1 + 0.2i, 0.7 - 0.1i}, {0.8 - 0.2i, -0.3 + 0.1i}}
+
+	c, err := Cascade(Thru, s)
+	assert.NoError(t, err)
+	assertNear(t, s, c)
+
+	// attenuators in series multiply
+	a := S{{0, 0.5}, {0.5, 0}}
+	c, err = Cascade(a, a)
+	assert.NoError(t, err)
+	assertNear(t, S{{0, 0.25}, {0.25, 0}}, c)
+
+}
+
+func TestDeembed(t *testing.T) {
+
+	dut := S{{0.2 - 0.1i, 0.6 + 0.3i}, {0.6 + 0.3i, 0.1 + 0.1i}}
+	a := S{{0.05 + 0.02i, 0.9 - 0.2i}, {0.9 - 0.2i, 0.03 - 0.01i}}
+	b := S{{0.02, 0.8i}, {0.8i, 0.04 + 0.01i}}
+
+	ad, err := Cascade(a, dut)
+	assert.NoError(t, err)
+
+	m, err := Cascade(ad, b)
+	assert.NoError(t, err)
+
+	d, err := Deembed(m, a, b)
+	assert.NoError(t, err)
+	assertNear(t, dut, d)
+
+	assertNear(t, a, a.Flip().Flip())
+
+	_, err = Deembed(m, S{}, b)
+	assert.Error(t, err)
+}
+
+func TestResample(t *testing.T) {
+
+	data := []pocket.SParam{
+		{Freq: 100, S21: pocket.Complex{Real: 1}},
+		{Freq: 200, S21: pocket.Complex{Real: 0, Imag: 1}},
+		{Freq: 300, S21: pocket.Complex{Real: -1}},
+	}
+
+	r, err := Resample(data, []uint64{100, 150, 200, 275, 300})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(r))
+	assert.Equal(t, uint64(150), r[1].Freq)
+	assert.Equal(t, pocket.Complex{Real: 0.5, Imag: 0.5}, r[1].S21)
+	assert.Equal(t, pocket.Complex{Real: 0, Imag: 1}, r[2].S21)
+	assert.Equal(t, pocket.Complex{Real: -0.75, Imag: 0.25}, r[3].S21)
+	assert.Equal(t, pocket.Complex{Real: -1}, r[4].S21)
+
+	_, err = Resample(data, []uint64{50})
+	assert.Error(t, err)
+
+	_, err = Resample(nil, []uint64{50})
+	assert.Error(t, err)
+}
+
+func TestExtend(t *testing.T) {
+
+	// a 1ns line on port 1, 0.5ns on port 2, in front of a dut, at 250 MHz
+	dut := S{{0.5, 0.5}, {0.5, 0.5}}
+
+	line := func(d float64) S {
+		p := cmplx.Exp(complex(0, -2*math.Pi*250e6*d))
+		return S{{0, p}, {p, 0}}
+	}
+
+	ad, err := Cascade(line(1e-9), dut)
+	assert.NoError(t, err)
+
+	m, err := Cascade(ad, line(0.5e-9))
+	assert.NoError(t, err)
+
+	assertNear(t, dut, m.Extend(250e6, 1e-9, 0.5e-9))
+	assertNear(t, m, m.Extend(250e6, 0, 0))
+}
+
+func TestRenormalize(t *testing.T) {
+
+	// a 75 ohm load is matched in a 75 ohm system
+	g := complex((75.0-50)/(75+50), 0)
+	load := S{{g, 0}, {0, g}}
+
+	r, err := load.Renormalize(50, 75)
+	assert.NoError(t, err)
+	assertNear(t, S{}, r)
+
+	// a 50 ohm thru is mismatched, but still lossless
+	r, err = Thru.Renormalize(50, 75)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1, cmplx.Abs(r[0][0])*cmplx.Abs(r[0][0])+cmplx.Abs(r[1][0])*cmplx.Abs(r[1][0]), 1e-9)
+	assert.InDelta(t, 0, cmplx.Abs(r[0][0]), 0.2)
+
+	// round trip
+	s := S{{0.1 + 0.2i, 0.7 - 0.1i}, {0.7 - 0.1i, -0.3 + 0.1i}}
+	r, err = s.Renormalize(50, 75)
+	assert.NoError(t, err)
+	r, err = r.Renormalize(75, 50)
+	assert.NoError(t, err)
+	assertNear(t, s, r)
+
+	_, err = s.Renormalize(50, 0)
+	assert.Error(t, err)
+}
+
+func TestSwitchTerms(t *testing.T) {
+
+	gf := 0.15 - 0.05i
+	gr := -0.1 + 0.12i
+
+	s := S{{0.1 + 0.2i, 0.7 - 0.1i}, {0.8 - 0.2i, -0.3 + 0.1i}}
+	assertNear(t, s, s.Terminate(gf, gr).Unterminate(gf, gr))
+
+	// error boxes either side of three reciprocal devices
+	x := S{{0.05 + 0.02i, 0.9 - 0.2i}, {0.85 - 0.1i, 0.03 - 0.01i}}
+	y := S{{0.02, 0.8i}, {0.75i, 0.04 + 0.01i}}
+
+	devices := []S{
+		Thru,
+		{{0.2 - 0.1i, 0.6 + 0.3i}, {0.6 + 0.3i, 0.1 + 0.1i}},
+		{{-0.4, 0.3 - 0.5i}, {0.3 - 0.5i, 0.25i}},
+	}
+
+	var m []S
+
+	for _, d := range devices {
+		xd, err := Cascade(x, d)
+		assert.NoError(t, err)
+		xdy, err := Cascade(xd, y)
+		assert.NoError(t, err)
+		m = append(m, xdy.Terminate(gf, gr))
+	}
+
+	f, r, err := SwitchTerms(m)
+	assert.NoError(t, err)
+	assert.InDelta(t, 0, cmplx.Abs(gf-f), 1e-9)
+	assert.InDelta(t, 0, cmplx.Abs(gr-r), 1e-9)
+
+	_, _, err = SwitchTerms(m[:2])
+	assert.Error(t, err)
+
+	_, _, err = SwitchTerms([]S{m[0], m[0], m[0]})
+	assert.Error(t, err)
+}
+
+func TestErrorTerms(t *testing.T) {
+
+	e := ErrorTerms{
+		Edf: 0.05 + 0.02i, Esf: 0.1 - 0.05i, Erf: 0.9 + 0.1i, Etf: 0.85 - 0.2i, Elf: 0.08 + 0.03i, Exf: 0.001i,
+		Edr: 0.04 - 0.01i, Esr: -0.07 + 0.06i, Err: 0.88 - 0.15i, Etr: 0.8 + 0.25i, Elr: 0.06 - 0.02i, Exr: 0.002,
+	}
+
+	s := S{{0.1 + 0.2i, 0.7 - 0.1i}, {0.8 - 0.2i, -0.3 + 0.1i}}
+
+	assertNear(t, s, e.Correct(e.Measure(s)))
+
+	// perfect error terms change nothing
+	ideal := ErrorTerms{Erf: 1, Etf: 1, Err: 1, Etr: 1}
+	assertNear(t, s, ideal.Measure(s))
+	assertNear(t, s, ideal.Correct(s))
+}
+
+func TestSolve(t *testing.T) {
+
+	e := ErrorTerms{
+		Edf: 0.05 + 0.02i, Esf: 0.1 - 0.05i, Erf: 0.9 + 0.1i, Etf: 0.85 - 0.2i, Elf: 0.08 + 0.03i, Exf: 0.001i,
+		Edr: 0.04 - 0.01i, Esr: -0.07 + 0.06i, Err: 0.88 - 0.15i, Etr: 0.8 + 0.25i, Elr: 0.06 - 0.02i, Exr: 0.002,
+	}
+
+	// standards that are not quite ideal, and a thru with some length
+	actual := Standards{
+		Short: S{{-0.98 + 0.1i, 0}, {0, -0.97 + 0.12i}},
+		Open:  S{{0.99 - 0.05i, 0}, {0, 0.98 - 0.06i}},
+		Load:  S{{0.01, 0}, {0, -0.01i}},
+		Thru:  S{{0.02, 0.9 - 0.3i}, {0.9 - 0.3i, 0.01i}},
+	}
+
+	for _, a := range []Standards{Ideal, actual} {
+
+		m := Standards{
+			Short: e.Measure(a.Short),
+			Open:  e.Measure(a.Open),
+			Load:  e.Measure(a.Load),
+			Thru:  e.Measure(a.Thru),
+		}
+
+		found, err := Solve(m, a)
+		assert.NoError(t, err)
+
+		assertNear(t, S{{e.Edf, e.Esf}, {e.Erf, e.Etf}}, S{{found.Edf, found.Esf}, {found.Erf, found.Etf}})
+		assertNear(t, S{{e.Elf, e.Exf}, {e.Elr, e.Exr}}, S{{found.Elf, found.Exf}, {found.Elr, found.Exr}})
+		assertNear(t, S{{e.Edr, e.Esr}, {e.Err, e.Etr}}, S{{found.Edr, found.Esr}, {found.Err, found.Etr}})
+
+		dut := S{{0.1 + 0.2i, 0.7 - 0.1i}, {0.8 - 0.2i, -0.3 + 0.1i}}
+		assertNear(t, dut, found.Correct(e.Measure(dut)))
+	}
+
+	// the reflection standards must differ
+	same := Standards{Short: Ideal.Open, Open: Ideal.Open, Load: Ideal.Open, Thru: Thru}
+	_, err := Solve(same, same)
+	assert.Error(t, err)
+}
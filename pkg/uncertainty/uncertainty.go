@@ -0,0 +1,141 @@
+// package uncertainty estimates bounds on the S-parameters of a calibrated result,
+// from the trace noise of the rig and the systematic errors that are left after an
+// SOLT calibration whose standards were measured with that noise. The residual errors
+// and the noise of the result are each taken at a coverage factor of two, i.e. about
+// 95% of readings would be within them, and combined linearly, as the worst case.
+package uncertainty
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+)
+
+// Coverage is the number of standard deviations of noise included in the bounds
+const Coverage = 2.0
+
+// Residuals are the systematic errors left after calibration at one frequency, in
+// one direction, as magnitudes in corrected (linear) units
+type Residuals struct {
+	Directivity          float64
+	SourceMatch          float64
+	ReflectionTracking   float64
+	LoadMatch            float64
+	TransmissionTracking float64
+}
+
+// Tracking is the magnitude of the reflection and transmission tracking of the cal
+// at one frequency, forward (port 1) and reverse (port 2), which scale raw noise into
+// corrected units. Use one for all of them if the error terms are not known.
+type Tracking struct {
+	Reflection   [2]float64
+	Transmission [2]float64
+}
+
+// FromNoise estimates the residual errors of a cal whose standards were measured with
+// the raw trace noise n, forward and reverse, at the Coverage. The directivity comes
+// from the load, the source match and reflection tracking from the short and open, and
+// the load match and transmission tracking from the thru, each to first order in the noise.
+func FromNoise(n pocket.Deviation, t Tracking) [2]Residuals {
+
+	reflection := [2]float64{n.S11, n.S22}
+	transmission := [2]float64{n.S21, n.S12}
+
+	var r [2]Residuals
+
+	for i := range r {
+
+		s := Coverage * reflection[i] / t.Reflection[i]
+
+		r[i] = Residuals{
+			Directivity: s,
+			// from (open - short) / 2 and (open + short - 2 load) / 2
+			ReflectionTracking:   math.Sqrt(2) * s / 2,
+			SourceMatch:          math.Sqrt(6) * s / 2,
+			LoadMatch:            s,
+			TransmissionTracking: Coverage * transmission[i] / t.Transmission[i],
+		}
+	}
+
+	return r
+}
+
+// Bounds returns the uncertainty of each S-parameter of s, given the residual errors r
+// of the cal and the raw trace noise n of the measurement, scaled by the tracking t
+func Bounds(s pocket.SParam, r [2]Residuals, n pocket.Deviation, t Tracking) pocket.UncertainSParam {
+
+	mag := func(c pocket.Complex) float64 {
+		return cmplx.Abs(complex(c.Real, c.Imag))
+	}
+
+	s11, s12, s21, s22 := mag(s.S11), mag(s.S12), mag(s.S21), mag(s.S22)
+
+	f, v := r[0], r[1]
+
+	// the usual reflection and transmission uncertainty equations, in each direction
+	d11 := f.Directivity + f.ReflectionTracking*s11 + f.SourceMatch*s11*s11 + f.LoadMatch*s21*s12 + Coverage*n.S11/t.Reflection[0]
+	d22 := v.Directivity + v.ReflectionTracking*s22 + v.SourceMatch*s22*s22 + v.LoadMatch*s12*s21 + Coverage*n.S22/t.Reflection[1]
+	d21 := f.TransmissionTracking*s21 + f.SourceMatch*s11*s21 + f.LoadMatch*s22*s21 + Coverage*n.S21/t.Transmission[0]
+	d12 := v.TransmissionTracking*s12 + v.SourceMatch*s22*s12 + v.LoadMatch*s11*s12 + Coverage*n.S12/t.Transmission[1]
+
+	return pocket.UncertainSParam{
+		Freq: s.Freq,
+		S11:  bound(s11, d11),
+		S12:  bound(s12, d12),
+		S21:  bound(s21, d21),
+		S22:  bound(s22, d22),
+	}
+}
+
+// bound describes an uncertainty of d in a magnitude of a. The dB and phase bounds are
+// the widest either way, and the phase can be anything once d reaches a.
+func bound(a, d float64) pocket.Bound {
+
+	b := pocket.Bound{
+		Mag:   d,
+		DB:    Unbounded,
+		Phase: 180,
+	}
+
+	if d < a {
+		b.DB = math.Max(20*math.Log10((a+d)/a), -20*math.Log10((a-d)/a))
+		b.Phase = math.Asin(d/a) * 180 / math.Pi
+	}
+
+	return b
+}
+
+// Unbounded is the dB bound given when the uncertainty is as large as the magnitude,
+// since JSON has no infinity
+const Unbounded = 999.0
+
+// Apply returns bounds at each point of s, which were measured with the raw trace
+// noise n, at the same frequencies, through the tracking t of the cal at each point
+func Apply(s []pocket.SParam, n []pocket.Deviation, t []Tracking) ([]pocket.UncertainSParam, error) {
+
+	if len(n) != len(s) || len(t) != len(s) {
+		return nil, fmt.Errorf("cannot find the uncertainty of %d points from the noise at %d points", len(s), len(n))
+	}
+
+	u := make([]pocket.UncertainSParam, len(s))
+
+	for i := range s {
+
+		if n[i].Freq != s[i].Freq {
+			return nil, fmt.Errorf("cannot find the uncertainty at %d Hz from the noise at %d Hz", s[i].Freq, n[i].Freq)
+		}
+
+		for _, v := range append(t[i].Reflection[:], t[i].Transmission[:]...) {
+			if v == 0 {
+				return nil, errors.New("cannot find the uncertainty because the cal has no tracking")
+			}
+		}
+
+		u[i] = Bounds(s[i], FromNoise(n[i], t[i]), n[i], t[i])
+	}
+
+	return u, nil
+}
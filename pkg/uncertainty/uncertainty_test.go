@@ -0,0 +1,70 @@
+package uncertainty
+
+import (
+	"math"
+	"testing"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	"github.com/stretchr/testify/assert"
+)
+
+var ones = Tracking{Reflection: [2]float64{1, 1}, Transmission: [2]float64{1, 1}}
+
+func TestFromNoise(t *testing.T) {
+
+	n := pocket.Deviation{S11: 0.001, S12: 0.004, S21: 0.003, S22: 0.002}
+
+	r := FromNoise(n, ones)
+	assert.InDelta(t, 0.002, r[0].Directivity, 1e-12)
+	assert.InDelta(t, 0.004, r[1].Directivity, 1e-12)
+	assert.InDelta(t, 0.006, r[0].TransmissionTracking, 1e-12)
+	assert.InDelta(t, 0.008, r[1].TransmissionTracking, 1e-12)
+	assert.InDelta(t, math.Sqrt(6)*0.001, r[0].SourceMatch, 1e-12)
+
+	// noise in raw units is smaller once corrected, if the tracking is more than one
+	r = FromNoise(n, Tracking{Reflection: [2]float64{2, 2}, Transmission: [2]float64{2, 2}})
+	assert.InDelta(t, 0.001, r[0].Directivity, 1e-12)
+}
+
+func TestBounds(t *testing.T) {
+
+	// a matched thru, where only the tracking and the noise matter
+	s := pocket.SParam{Freq: 100, S21: pocket.Complex{Real: 1}, S12: pocket.Complex{Imag: 1}}
+	n := pocket.Deviation{Freq: 100, S11: 0.001, S12: 0.001, S21: 0.001, S22: 0.001}
+
+	u := Bounds(s, FromNoise(n, ones), n, ones)
+
+	assert.Equal(t, uint64(100), u.Freq)
+	// directivity + load match + noise
+	assert.InDelta(t, 0.002+0.002+0.002, u.S11.Mag, 1e-12)
+	// tracking + noise
+	assert.InDelta(t, 0.004, u.S21.Mag, 1e-12)
+	assert.InDelta(t, 20*math.Log10(1/0.996), u.S21.DB, 1e-9)
+	assert.InDelta(t, math.Asin(0.004)*180/math.Pi, u.S21.Phase, 1e-9)
+
+	// a match is as uncertain as its own size, so the phase is not known
+	assert.Equal(t, Unbounded, u.S11.DB)
+	assert.Equal(t, 180.0, u.S11.Phase)
+}
+
+func TestApply(t *testing.T) {
+
+	s := []pocket.SParam{{Freq: 100, S11: pocket.Complex{Real: 0.5}}, {Freq: 200, S11: pocket.Complex{Real: 0.5}}}
+	n := []pocket.Deviation{{Freq: 100, S11: 0.001}, {Freq: 200, S11: 0.01}}
+
+	u, err := Apply(s, n, []Tracking{ones, ones})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(u))
+	assert.Less(t, u[0].S11.Mag, u[1].S11.Mag)
+
+	_, err = Apply(s, n[:1], []Tracking{ones})
+	assert.Error(t, err)
+
+	n[1].Freq = 300
+	_, err = Apply(s, n, []Tracking{ones, ones})
+	assert.EqualError(t, err, "cannot find the uncertainty at 200 Hz from the noise at 300 Hz")
+
+	n[1].Freq = 200
+	_, err = Apply(s, n, []Tracking{ones, {}})
+	assert.Error(t, err)
+}
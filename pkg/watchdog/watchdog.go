@@ -0,0 +1,291 @@
+// package watchdog looks after a VNA that stops responding, which pocketVNAs
+// occasionally do until they are unplugged. Each operation is given a hard
+// ceiling, and if it is exceeded, or the VNA reports that it cannot be reached,
+// the VNA is reset by releasing and reopening it, after power cycling its USB
+// port if there is a way to, and the operation is tried once more.
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// Wedged lists the results from the pocketVNA driver that mean the device has
+// stopped responding, rather than that the request was wrong
+var Wedged = []string{
+	"PVNA_Res_NoDevice",
+	"PVNA_Res_InvalidHandle",
+	"PVNA_Res_BadTransmission",
+	"PVNA_Res_DataReadFailure",
+	"PVNA_Res_EmptyResponse",
+	"PVNA_Res_IncompleteResponse",
+	"PVNA_Res_FailedToWriteRequest",
+	"PVNA_Res_BadResponse",
+}
+
+// Reconnects is how many times to try opening the VNA again after a reset,
+// waiting Settle between each, since it takes a while to be found after a power cycle
+const Reconnects = 5
+
+// ErrHung is returned, wrapped, when an operation takes longer than the ceiling
+var ErrHung = errors.New("no response from the VNA")
+
+// Watchdog is a VNA that resets the VNA it wraps when it hangs or stops responding.
+// It is safe to use from more than one goroutine, but the VNA is only used by one at a time.
+type Watchdog struct {
+	mu      sync.Mutex
+	vna     pocket.VNA
+	release func() error
+	// Ceiling is the longest any one operation may take, zero for no limit
+	Ceiling time.Duration
+	// Cycle power cycles the USB port of the VNA, nil if there is no way to
+	Cycle func(ctx context.Context) error
+	// Settle is how long to wait after a power cycle, and between attempts to reopen the VNA
+	Settle time.Duration
+	// Resets counts the resets so far
+	Resets int
+}
+
+// New returns a watchdog for v, which has been opened already, and is closed by release
+func New(v pocket.VNA, release func() error, ceiling time.Duration) *Watchdog {
+	return &Watchdog{
+		vna:     v,
+		release: release,
+		Ceiling: ceiling,
+		Settle:  2 * time.Second,
+	}
+}
+
+// Command returns a Cycle that runs a command, such as uhubctl, to power cycle a
+// USB hub port, e.g. "uhubctl -l 1-1 -p 2 -a cycle". The command is split on spaces.
+func Command(command string) func(ctx context.Context) error {
+
+	args := strings.Fields(command)
+
+	return func(ctx context.Context) error {
+
+		if len(args) == 0 {
+			return errors.New("no command to power cycle the USB port")
+		}
+
+		out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput()
+
+		if err != nil {
+			return fmt.Errorf("%s failed because %s: %s", args[0], err.Error(), strings.TrimSpace(string(out)))
+		}
+
+		return nil
+	}
+}
+
+// Release closes the VNA
+func (w *Watchdog) Release() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.release()
+}
+
+func (w *Watchdog) Connect() (func() error, error) {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	release, err := w.vna.Connect()
+
+	if err != nil {
+		return release, err
+	}
+
+	w.release = release
+
+	return w.Release, nil
+}
+
+func (w *Watchdog) GetReasonableFrequencyRange(command interface{}) error {
+	return w.do("reasonable frequency range", command, w.vna.GetReasonableFrequencyRange)
+}
+
+func (w *Watchdog) HandleCommand(command interface{}) error {
+	return w.do("command", command, w.vna.HandleCommand)
+}
+
+func (w *Watchdog) RangeQuery(command interface{}) error {
+	return w.do("range query", command, w.vna.RangeQuery)
+}
+
+func (w *Watchdog) SetPower(command interface{}) error {
+	return w.do("set power", command, w.vna.SetPower)
+}
+
+func (w *Watchdog) SingleQuery(command interface{}) error {
+	return w.do("single query", command, w.vna.SingleQuery)
+}
+
+// Prepare gets the VNA ready for a range query, if it can be
+func (w *Watchdog) Prepare(command interface{}) error {
+
+	p, ok := w.vna.(pocket.Preparer)
+
+	if !ok {
+		return nil
+	}
+
+	return w.do("prepare", command, p.Prepare)
+}
+
+// do runs op on command, and if it hangs or the VNA stops responding, resets the VNA
+// and runs it once more. op is given a copy of command, so that an operation that
+// has hung cannot change it later, and the copy is kept if op succeeds.
+func (w *Watchdog) do(name string, command interface{}, op func(interface{}) error) error {
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	err := w.run(command, op)
+
+	if err == nil || !(errors.Is(err, ErrHung) || wedged(err)) {
+		return err
+	}
+
+	log.Errorf("pkg/watchdog: VNA %s failed because %s, resetting the VNA", name, err.Error())
+
+	rerr := w.reset()
+
+	if rerr != nil {
+		return fmt.Errorf("VNA %s failed because %s, and the VNA could not be reset because %s", name, err.Error(), rerr.Error())
+	}
+
+	err = w.run(command, op)
+
+	if err != nil {
+		return fmt.Errorf("VNA %s failed again after resetting the VNA because %w", name, err)
+	}
+
+	log.Infof("pkg/watchdog: VNA %s succeeded after resetting the VNA", name)
+
+	return nil
+}
+
+// run does op on a copy of command, giving up after the ceiling
+func (w *Watchdog) run(command interface{}, op func(interface{}) error) error {
+
+	c := clone(command)
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- op(c)
+	}()
+
+	var ceiling <-chan time.Time
+
+	if w.Ceiling > 0 {
+		t := time.NewTimer(w.Ceiling)
+		defer t.Stop()
+		ceiling = t.C
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			restore(command, c)
+		}
+		return err
+	case <-ceiling:
+		return fmt.Errorf("%w in %s", ErrHung, w.Ceiling)
+	}
+}
+
+// reset releases the VNA, power cycles it if possible, then opens it again
+func (w *Watchdog) reset() error {
+
+	w.Resets++
+
+	if err := w.release(); err != nil {
+		log.Warnf("pkg/watchdog: could not release the VNA because %s", err.Error())
+	}
+
+	if w.Cycle != nil {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := w.Cycle(ctx)
+		cancel()
+
+		if err != nil {
+			log.Warnf("pkg/watchdog: could not power cycle the VNA because %s", err.Error())
+		} else {
+			log.Infof("pkg/watchdog: power cycled the VNA")
+			time.Sleep(w.Settle)
+		}
+	}
+
+	var err error
+
+	for i := 0; i < Reconnects; i++ {
+
+		if i > 0 {
+			time.Sleep(w.Settle)
+		}
+
+		var release func() error
+
+		release, err = w.vna.Connect()
+
+		if err == nil {
+			w.release = release
+			return nil
+		}
+
+		log.Warnf("pkg/watchdog: could not open the VNA (attempt %d) because %s", i+1, err.Error())
+	}
+
+	return err
+}
+
+// wedged returns true if err means the VNA has stopped responding
+func wedged(err error) bool {
+
+	for _, r := range Wedged {
+		if strings.Contains(err.Error(), r) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// clone returns a pointer to a copy of what command points to, or command itself if it is not a pointer
+func clone(command interface{}) interface{} {
+
+	v := reflect.ValueOf(command)
+
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return command
+	}
+
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+
+	return c.Interface()
+}
+
+// restore copies c back to what command points to
+func restore(command, c interface{}) {
+
+	v := reflect.ValueOf(command)
+
+	if v.Kind() != reflect.Ptr || v.IsNil() || c == command {
+		return
+	}
+
+	v.Elem().Set(reflect.ValueOf(c).Elem())
+}
@@ -0,0 +1,130 @@
+package watchdog
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func init() {
+	log.SetLevel(log.PanicLevel)
+}
+
+// stuck is a VNA whose range queries hang, or fail with err, the first few times
+type stuck struct {
+	pocket.Mock
+	mu       sync.Mutex
+	hang     int
+	err      error
+	fail     int
+	queries  int
+	connects int
+	released int
+	block    chan struct{}
+}
+
+func (s *stuck) Connect() (func() error, error) {
+	s.connects++
+	return func() error { s.released++; return nil }, nil
+}
+
+func (s *stuck) RangeQuery(command interface{}) error {
+
+	s.mu.Lock()
+	s.queries++
+	hang := s.hang > 0
+	if hang {
+		s.hang--
+	}
+	s.mu.Unlock()
+
+	c := command.(*pocket.RangeQuery)
+
+	if hang {
+		<-s.block
+		c.Result = []pocket.SParam{{Freq: 999}} // too late
+		return nil
+	}
+
+	if s.fail > 0 {
+		s.fail--
+		return s.err
+	}
+
+	c.Result = []pocket.SParam{{Freq: 100}}
+
+	return nil
+}
+
+func TestHung(t *testing.T) {
+
+	s := &stuck{hang: 1, block: make(chan struct{})}
+	defer close(s.block)
+
+	w := New(s, func() error { s.released++; return nil }, 50*time.Millisecond)
+	w.Settle = 0
+
+	rq := pocket.RangeQuery{}
+	assert.NoError(t, w.RangeQuery(&rq))
+	assert.Equal(t, []pocket.SParam{{Freq: 100}}, rq.Result)
+	s.mu.Lock()
+	assert.Equal(t, 2, s.queries)
+	s.mu.Unlock()
+	assert.Equal(t, 1, s.connects)
+	assert.Equal(t, 1, s.released)
+	assert.Equal(t, 1, w.Resets)
+
+	// only tried once more
+	s.mu.Lock()
+	s.hang = 2
+	s.mu.Unlock()
+	err := w.RangeQuery(&rq)
+	assert.Error(t, err)
+	assert.True(t, errors.Is(err, ErrHung))
+	assert.Contains(t, err.Error(), "failed again after resetting")
+	assert.Equal(t, 2, w.Resets)
+}
+
+func TestWedged(t *testing.T) {
+
+	s := &stuck{fail: 1, err: errors.New("PVNA_Res_DataReadFailure")}
+
+	w := New(s, func() error { return nil }, 0)
+	w.Settle = 0
+
+	cycled := 0
+	w.Cycle = func(ctx context.Context) error { cycled++; return nil }
+
+	rq := pocket.RangeQuery{}
+	assert.NoError(t, w.RangeQuery(&rq))
+	assert.Equal(t, 1, cycled)
+	assert.Equal(t, 1, s.connects)
+
+	// a mistake in the request is not the VNA's fault
+	s.fail = 1
+	s.err = errors.New("PVNA_Res_BadFrequency")
+	assert.EqualError(t, w.RangeQuery(&rq), "PVNA_Res_BadFrequency")
+	assert.Equal(t, 1, cycled)
+
+	// nor are the other operations, which are passed on
+	assert.NoError(t, w.SetPower(&pocket.SetPower{}))
+	assert.NoError(t, w.Prepare(&rq))
+
+	// the new release is used
+	release, err := w.Connect()
+	assert.NoError(t, err)
+	assert.NoError(t, release())
+	assert.Equal(t, 1, s.released)
+}
+
+func TestCommand(t *testing.T) {
+	assert.NoError(t, Command("true")(context.Background()))
+	assert.Error(t, Command("false")(context.Background()))
+	assert.Error(t, Command("")(context.Background()))
+}
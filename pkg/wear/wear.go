@@ -0,0 +1,204 @@
+// package wear counts how many times the rf switch has moved to each position, and
+// keeps the counts in a file so that they carry on across restarts. Mechanical rf
+// switches are rated for a limited number of cycles, so the counts tell a lab manager
+// when to replace one, before it starts to give poor contact.
+package wear
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// SaveEvery is how often the counts are written to the file as the switch moves,
+// so that the SD card is not written on every move. Up to this long of counts is
+// lost if the daemon stops without Save.
+const SaveEvery = time.Minute
+
+// Counter counts the moves of the switch. It is safe to use from more than one
+// goroutine, and a nil Counter counts nothing.
+type Counter struct {
+	mu     sync.Mutex
+	path   string // empty to keep the counts in memory only
+	counts map[string]uint64
+	since  time.Time
+	rated  uint64
+	saved  time.Time
+}
+
+// file is how the counts are kept
+type file struct {
+	Since  time.Time         `json:"since"`
+	Counts map[string]uint64 `json:"counts"`
+}
+
+// New returns a counter that keeps the counts in memory only
+func New() *Counter {
+	return &Counter{
+		counts: make(map[string]uint64),
+		since:  time.Now().UTC(),
+		saved:  time.Now(),
+	}
+}
+
+// Open returns a counter that carries on from the counts in the file at path, if
+// there is one, and keeps them there
+func Open(path string) (*Counter, error) {
+
+	c := New()
+	c.path = path
+
+	b, err := os.ReadFile(path)
+
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, c.Save() // so that a path that cannot be written is found now
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var f file
+
+	err = json.Unmarshal(b, &f)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f.Counts != nil {
+		c.counts = f.Counts
+	}
+
+	if !f.Since.IsZero() {
+		c.since = f.Since
+	}
+
+	return c, nil
+}
+
+// SetRated sets the moves into any one position at which the switch is worn, zero for no limit
+func (c *Counter) SetRated(n uint64) {
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.rated = n
+}
+
+// Add counts a move of the switch to position, warning when it reaches the rated count
+func (c *Counter) Add(position string) {
+
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts[position]++
+
+	if c.rated > 0 && c.counts[position] == c.rated {
+		log.WithFields(log.Fields{"position": position, "count": c.counts[position]}).Warn("switch has reached its rated number of moves, and should be replaced")
+	}
+
+	if time.Since(c.saved) >= SaveEvery {
+		if err := c.save(); err != nil {
+			log.WithFields(log.Fields{"path": c.path, "error": err.Error()}).Error("could not save switch wear")
+		}
+	}
+}
+
+// Save writes the counts to the file, if there is one, by renaming a temporary file over it
+func (c *Counter) Save() error {
+
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.save()
+}
+
+// save writes the counts. Hold the lock.
+func (c *Counter) save() error {
+
+	c.saved = time.Now()
+
+	if c.path == "" {
+		return nil
+	}
+
+	b, err := json.Marshal(file{Since: c.since, Counts: c.counts})
+
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(filepath.Dir(c.path), "."+filepath.Base(c.path)+".tmp")
+
+	err = os.WriteFile(tmp, b, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, c.path)
+}
+
+// Reset starts counting again from zero, e.g. after the switch is replaced
+func (c *Counter) Reset() error {
+
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.counts = make(map[string]uint64)
+	c.since = time.Now().UTC()
+
+	return c.save()
+}
+
+// Wear returns the counts, and the positions that have reached the rated count
+func (c *Counter) Wear() pocket.Wear {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := pocket.Wear{
+		Counts: make(map[string]uint64),
+		Since:  c.since,
+		Rated:  c.rated,
+	}
+
+	for p, n := range c.counts {
+
+		w.Counts[p] = n
+		w.Total += n
+
+		if c.rated > 0 && n >= c.rated {
+			w.Worn = append(w.Worn, p)
+		}
+	}
+
+	sort.Strings(w.Worn)
+
+	return w
+}
@@ -0,0 +1,70 @@
+package wear
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounter(t *testing.T) {
+
+	var nothing *Counter
+	nothing.Add("short")
+	nothing.SetRated(1)
+	assert.NoError(t, nothing.Save())
+	assert.NoError(t, nothing.Reset())
+
+	path := filepath.Join(t.TempDir(), "wear.json")
+
+	c, err := Open(path)
+	assert.NoError(t, err)
+	assert.FileExists(t, path)
+
+	c.SetRated(2)
+
+	for _, p := range []string{"short", "open", "short", "thru"} {
+		c.Add(p)
+	}
+
+	w := c.Wear()
+	assert.Equal(t, map[string]uint64{"short": 2, "open": 1, "thru": 1}, w.Counts)
+	assert.Equal(t, uint64(4), w.Total)
+	assert.Equal(t, uint64(2), w.Rated)
+	assert.Equal(t, []string{"short"}, w.Worn)
+
+	// carried on from the file after a restart
+	assert.NoError(t, c.Save())
+
+	d, err := Open(path)
+	assert.NoError(t, err)
+	d.Add("open")
+	assert.Equal(t, uint64(5), d.Wear().Total)
+	assert.Equal(t, w.Since, d.Wear().Since)
+	assert.Empty(t, d.Wear().Worn) // until the rated count is set
+
+	// and from zero once the switch is replaced
+	assert.NoError(t, d.Reset())
+	assert.Empty(t, d.Wear().Counts)
+	assert.True(t, d.Wear().Since.After(w.Since))
+
+	e, err := Open(path)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0), e.Wear().Total)
+
+	// not a count file
+	assert.NoError(t, os.WriteFile(path, []byte("nonsense"), 0644))
+	_, err = Open(path)
+	assert.Error(t, err)
+
+	// nowhere to keep it
+	_, err = Open(filepath.Join(t.TempDir(), "no", "such", "dir", "wear.json"))
+	assert.Error(t, err)
+
+	// in memory only
+	m := New()
+	m.Add("dut1")
+	assert.NoError(t, m.Save())
+	assert.Equal(t, uint64(1), m.Wear().Counts["dut1"])
+}
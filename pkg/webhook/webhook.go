@@ -0,0 +1,141 @@
+// package webhook POSTs the responses to chosen commands to an http endpoint, as
+// well as sending them on the stream, e.g. so that an auto-grader in a learning
+// management system, or a data-collection service, gets every result without the
+// clients being changed. A response that cannot be posted is logged and dropped,
+// so that a slow or broken endpoint never holds up the rig.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/practable/pocket-vna-two-port/pkg/pocket"
+	log "github.com/sirupsen/logrus"
+)
+
+// Timeout limits each post, so that a slow endpoint does not tie up a post for long
+const Timeout = 10 * time.Second
+
+// MaxPending is how many posts may be in progress at once, beyond which responses
+// are dropped rather than piling up behind an endpoint that is not keeping up
+const MaxPending = 16
+
+// Header names the command that a posted response is to, e.g. crq, so that the
+// endpoint can route it without parsing the body
+const Header = "X-VNA-Command"
+
+// Hook posts the responses to Cmds to URL
+type Hook struct {
+	URL    string
+	Token  string          // sent as a bearer token, unless empty
+	Cmds   map[string]bool // full names of the commands, as returned by pocket.Lookup
+	Client *http.Client
+	sem    chan struct{}
+}
+
+// New returns a hook that posts the responses to cmds, which may be aliases, to url.
+// It returns an error if a command is not known.
+func New(url, token string, cmds []string) (*Hook, error) {
+
+	h := &Hook{
+		URL:    url,
+		Token:  token,
+		Cmds:   make(map[string]bool),
+		Client: &http.Client{Timeout: Timeout},
+		sem:    make(chan struct{}, MaxPending),
+	}
+
+	for _, c := range cmds {
+
+		cmd, ok := pocket.Lookup(strings.TrimSpace(c))
+
+		if !ok {
+			return nil, fmt.Errorf("there is no command called %s", c)
+		}
+
+		h.Cmds[cmd] = true
+	}
+
+	return h, nil
+}
+
+// Wants returns true if the responses to cmd, which may be an alias, are posted
+func (h *Hook) Wants(cmd string) bool {
+
+	if h == nil {
+		return false
+	}
+
+	cmd, ok := pocket.Lookup(cmd)
+
+	return ok && h.Cmds[cmd]
+}
+
+// Post sends body, the response to cmd, which may be an alias, in the background,
+// unless MaxPending posts are already in progress, when it is dropped
+func (h *Hook) Post(ctx context.Context, cmd string, body []byte) {
+
+	if full, ok := pocket.Lookup(cmd); ok {
+		cmd = full
+	}
+
+	select {
+	case h.sem <- struct{}{}:
+	default:
+		log.WithFields(log.Fields{"url": h.URL, "cmd": cmd}).Warn("webhook is not keeping up, so a response was not posted")
+		return
+	}
+
+	go func() {
+
+		defer func() { <-h.sem }()
+
+		if err := h.Send(ctx, cmd, body); err != nil {
+			log.WithFields(log.Fields{"url": h.URL, "cmd": cmd, "error": err.Error()}).Warn("could not post response to webhook")
+		}
+	}()
+}
+
+// Send POSTs body, the response to cmd, to the URL, and returns an error unless it
+// is accepted with a 2xx status
+func (h *Hook) Send(ctx context.Context, cmd string, body []byte) error {
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(Header, cmd)
+
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+
+	client := h.Client
+
+	if client == nil {
+		client = &http.Client{Timeout: Timeout}
+	}
+
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 200))
+		return fmt.Errorf("%s returned %d %s", h.URL, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	return nil
+}
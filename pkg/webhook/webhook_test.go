@@ -0,0 +1,66 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNew(t *testing.T) {
+
+	h, err := New("http://example.org", "", []string{"crq", " rq "})
+	assert.NoError(t, err)
+	assert.True(t, h.Wants("crq"))
+	assert.True(t, h.Wants("rangequery"))
+	assert.False(t, h.Wants("rc"))
+	assert.False(t, h.Wants("nope"))
+
+	var nothing *Hook
+	assert.False(t, nothing.Wants("crq"))
+
+	_, err = New("http://example.org", "", []string{"crq", "nope"})
+	assert.EqualError(t, err, "there is no command called nope")
+}
+
+func TestPost(t *testing.T) {
+
+	got := make(chan string, 1)
+	var auth, cmd string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		cmd = r.Header.Get(Header)
+		b, _ := io.ReadAll(r.Body)
+		if string(b) == "reject" {
+			http.Error(w, "not a result", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		got <- string(b)
+	}))
+	defer srv.Close()
+
+	h, err := New(srv.URL, "secret", []string{"crq"})
+	assert.NoError(t, err)
+
+	h.Post(context.Background(), "crq", []byte(`{"cmd":"crq"}`))
+
+	select {
+	case b := <-got:
+		assert.Equal(t, `{"cmd":"crq"}`, b)
+	case <-time.After(time.Second):
+		t.Fatal("response was not posted")
+	}
+
+	assert.Equal(t, "Bearer secret", auth)
+	assert.Equal(t, "crq", cmd)
+
+	err = h.Send(context.Background(), "crq", []byte("reject"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "400 not a result")
+}
@@ -19,7 +19,7 @@ DESCRIPTOR = _descriptor.FileDescriptor(
   syntax='proto3',
   serialized_options=b'Z/github.com/practable/pocket-vna-two-port/pkg/pb',
   create_key=_descriptor._internal_create_key,
-  serialized_pb=b'\n\x0f\x63\x61librate.proto\x12\x02pb\"J\n\x18\x43\x61librateOnePortResponse\x12\x11\n\tfrequency\x18\x01 \x03(\x01\x12\x1b\n\x06result\x18\x02 \x03(\x0b\x32\x0b.pb.Complex\"J\n\x18\x43\x61librateTwoPortResponse\x12\x11\n\tfrequency\x18\x01 \x03(\x01\x12\x1b\n\x06result\x18\x02 \x01(\x0b\x32\x0b.pb.SParams\"\xb3\x01\n\x17\x43\x61librateOnePortRequest\x12\x11\n\tfrequency\x18\x01 \x03(\x01\x12\x1a\n\x05short\x18\x02 \x03(\x0b\x32\x0b.pb.Complex\x12\x19\n\x04open\x18\x03 \x03(\x0b\x32\x0b.pb.Complex\x12\x19\n\x04load\x18\x04 \x03(\x0b\x32\x0b.pb.Complex\x12\x19\n\x04thru\x18\x05 \x03(\x0b\x32\x0b.pb.Complex\x12\x18\n\x03\x64ut\x18\x06 \x03(\x0b\x32\x0b.pb.Complex\"\xb3\x01\n\x17\x43\x61librateTwoPortRequest\x12\x11\n\tfrequency\x18\x01 \x03(\x01\x12\x1a\n\x05short\x18\x02 \x01(\x0b\x32\x0b.pb.SParams\x12\x19\n\x04open\x18\x03 \x01(\x0b\x32\x0b.pb.SParams\x12\x19\n\x04load\x18\x04 \x01(\x0b\x32\x0b.pb.SParams\x12\x19\n\x04thru\x18\x05 \x01(\x0b\x32\x0b.pb.SParams\x12\x18\n\x03\x64ut\x18\x06 \x01(\x0b\x32\x0b.pb.SParams\"q\n\x07SParams\x12\x18\n\x03s11\x18\x01 \x03(\x0b\x32\x0b.pb.Complex\x12\x18\n\x03s12\x18\x02 \x03(\x0b\x32\x0b.pb.Complex\x12\x18\n\x03s21\x18\x03 \x03(\x0b\x32\x0b.pb.Complex\x12\x18\n\x03s22\x18\x04 \x03(\x0b\x32\x0b.pb.Complex\"%\n\x07\x43omplex\x12\x0c\n\x04imag\x18\x01 \x01(\x01\x12\x0c\n\x04real\x18\x02 \x01(\x01\x32\xad\x01\n\tCalibrate\x12O\n\x10\x43\x61librateOnePort\x12\x1b.pb.CalibrateOnePortRequest\x1a\x1c.pb.CalibrateOnePortResponse\"\x00\x12O\n\x10\x43\x61librateTwoPort\x12\x1b.pb.CalibrateTwoPortRequest\x1a\x1c.pb.CalibrateTwoPortResponse\"\x00\x42\x31Z/github.com/practable/pocket-vna-two-port/pkg/pbb\x06proto3'
+  serialized_pb=b'\n\x0f\x63\x61librate.proto\x12\x02pb\"J\n\x18\x43\x61librateOnePortResponse\x12\x11\n\tfrequency\x18\x01 \x03(\x01\x12\x1b\n\x06result\x18\x02 \x03(\x0b\x32\x0b.pb.Complex\"o\n\x18\x43\x61librateTwoPortResponse\x12\x11\n\tfrequency\x18\x01 \x03(\x01\x12\x1b\n\x06result\x18\x02 \x01(\x0b\x32\x0b.pb.SParams\x12#\n\x0b\x65rror_terms\x18\x03 \x01(\x0b\x32\x0e.pb.ErrorTerms\"\xb3\x01\n\x17\x43\x61librateOnePortRequest\x12\x11\n\tfrequency\x18\x01 \x03(\x01\x12\x1a\n\x05short\x18\x02 \x03(\x0b\x32\x0b.pb.Complex\x12\x19\n\x04open\x18\x03 \x03(\x0b\x32\x0b.pb.Complex\x12\x19\n\x04load\x18\x04 \x03(\x0b\x32\x0b.pb.Complex\x12\x19\n\x04thru\x18\x05 \x03(\x0b\x32\x0b.pb.Complex\x12\x18\n\x03\x64ut\x18\x06 \x03(\x0b\x32\x0b.pb.Complex\"\xb8\x02\n\x17\x43\x61librateTwoPortRequest\x12\x11\n\tfrequency\x18\x01 \x03(\x01\x12\x1a\n\x05short\x18\x02 \x01(\x0b\x32\x0b.pb.SParams\x12\x19\n\x04open\x18\x03 \x01(\x0b\x32\x0b.pb.SParams\x12\x19\n\x04load\x18\x04 \x01(\x0b\x32\x0b.pb.SParams\x12\x19\n\x04thru\x18\x05 \x01(\x0b\x32\x0b.pb.SParams\x12\x18\n\x03\x64ut\x18\x06 \x01(\x0b\x32\x0b.pb.SParams\x12 \n\x0bideal_short\x18\x07 \x01(\x0b\x32\x0b.pb.SParams\x12\x1f\n\nideal_open\x18\x08 \x01(\x0b\x32\x0b.pb.SParams\x12\x1f\n\nideal_load\x18\t \x01(\x0b\x32\x0b.pb.SParams\x12\x1f\n\nideal_thru\x18\n \x01(\x0b\x32\x0b.pb.SParams\"q\n\x07SParams\x12\x18\n\x03s11\x18\x01 \x03(\x0b\x32\x0b.pb.Complex\x12\x18\n\x03s12\x18\x02 \x03(\x0b\x32\x0b.pb.Complex\x12\x18\n\x03s21\x18\x03 \x03(\x0b\x32\x0b.pb.Complex\x12\x18\n\x03s22\x18\x04 \x03(\x0b\x32\x0b.pb.Complex\"\xa4\x04\n\nErrorTerms\x12(\n\x13\x66orward_directivity\x18\x01 \x03(\x0b\x32\x0b.pb.Complex\x12)\n\x14\x66orward_source_match\x18\x02 \x03(\x0b\x32\x0b.pb.Complex\x12\x30\n\x1b\x66orward_reflection_tracking\x18\x03 \x03(\x0b\x32\x0b.pb.Complex\x12\x32\n\x1d\x66orward_transmission_tracking\x18\x04 \x03(\x0b\x32\x0b.pb.Complex\x12\'\n\x12\x66orward_load_match\x18\x05 \x03(\x0b\x32\x0b.pb.Complex\x12&\n\x11\x66orward_isolation\x18\x06 \x03(\x0b\x32\x0b.pb.Complex\x12(\n\x13reverse_directivity\x18\x07 \x03(\x0b\x32\x0b.pb.Complex\x12)\n\x14reverse_source_match\x18\x08 \x03(\x0b\x32\x0b.pb.Complex\x12\x30\n\x1breverse_reflection_tracking\x18\t \x03(\x0b\x32\x0b.pb.Complex\x12\x32\n\x1dreverse_transmission_tracking\x18\n \x03(\x0b\x32\x0b.pb.Complex\x12\'\n\x12reverse_load_match\x18\x0b \x03(\x0b\x32\x0b.pb.Complex\x12&\n\x11reverse_isolation\x18\x0c \x03(\x0b\x32\x0b.pb.Complex\"%\n\x07\x43omplex\x12\x0c\n\x04imag\x18\x01 \x01(\x01\x12\x0c\n\x04real\x18\x02 \x01(\x01\"\xa2\x01\n\x0eMeasureRequest\x12\x0c\n\x04what\x18\x01 \x01(\t\x12\x12\n\ncalibrated\x18\x02 \x01(\x08\x12\r\n\x05start\x18\x03 \x01(\x04\x12\x0b\n\x03\x65nd\x18\x04 \x01(\x04\x12\x0c\n\x04size\x18\x05 \x01(\x05\x12\x0b\n\x03log\x18\x06 \x01(\x08\x12\x0b\n\x03\x61vg\x18\x07 \x01(\r\x12\x0e\n\x06sweeps\x18\x08 \x01(\x05\x12\x0e\n\x06reject\x18\t \x01(\t\x12\n\n\x02z0\x18\n \x01(\x01\"A\n\x0fMeasureResponse\x12\x11\n\tfrequency\x18\x01 \x03(\x01\x12\x1b\n\x06result\x18\x02 \x01(\x0b\x32\x0b.pb.SParams\"v\n\x10\x43\x61librateRequest\x12\r\n\x05start\x18\x01 \x01(\x04\x12\x0b\n\x03\x65nd\x18\x02 \x01(\x04\x12\x0c\n\x04size\x18\x03 \x01(\x05\x12\x0b\n\x03log\x18\x04 \x01(\x08\x12\x0b\n\x03\x61vg\x18\x05 \x01(\r\x12\x0e\n\x06sweeps\x18\x06 \x01(\x05\x12\x0e\n\x06reject\x18\x07 \x01(\t\"A\n\x11\x43\x61librateResponse\x12\x11\n\tfrequency\x18\x01 \x03(\x01\x12\x19\n\x04thru\x18\x02 \x01(\x0b\x32\x0b.pb.SParams\"\x0f\n\rStatusRequest\"\xae\x01\n\x0eStatusResponse\x12\x10\n\x08protocol\x18\x01 \x01(\x05\x12\x15\n\rmin_frequency\x18\x02 \x01(\x04\x12\x15\n\rmax_frequency\x18\x03 \x01(\x04\x12\x12\n\ncalibrated\x18\x04 \x01(\x08\x12\r\n\x05start\x18\x05 \x01(\x04\x12\x0b\n\x03\x65nd\x18\x06 \x01(\x04\x12\x0c\n\x04size\x18\x07 \x01(\x05\x12\x0b\n\x03log\x18\x08 \x01(\x08\x12\x11\n\tpositions\x18\t \x03(\t2\xad\x01\n\tCalibrate\x12O\n\x10\x43\x61librateOnePort\x12\x1b.pb.CalibrateOnePortRequest\x1a\x1c.pb.CalibrateOnePortResponse\"\x00\x12O\n\x10\x43\x61librateTwoPort\x12\x1b.pb.CalibrateTwoPortRequest\x1a\x1c.pb.CalibrateTwoPortResponse\"\x00\x32\xaa\x01\n\x03VNA\x12\x34\n\x07Measure\x12\x12.pb.MeasureRequest\x1a\x13.pb.MeasureResponse\"\x00\x12:\n\tCalibrate\x12\x14.pb.CalibrateRequest\x1a\x15.pb.CalibrateResponse\"\x00\x12\x31\n\x06Status\x12\x11.pb.StatusRequest\x1a\x12.pb.StatusResponse\"\x00\x42\x31Z/github.com/practable/pocket-vna-two-port/pkg/pbb\x06proto3'
 )
 
 
@@ -86,6 +86,13 @@ _CALIBRATETWOPORTRESPONSE = _descriptor.Descriptor(
       message_type=None, enum_type=None, containing_type=None,
       is_extension=False, extension_scope=None,
       serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='error_terms', full_name='pb.CalibrateTwoPortResponse.error_terms', index=2,
+      number=3, type=11, cpp_type=10, label=1,
+      has_default_value=False, default_value=None,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
   ],
   extensions=[
   ],
@@ -99,7 +106,7 @@ _CALIBRATETWOPORTRESPONSE = _descriptor.Descriptor(
   oneofs=[
   ],
   serialized_start=99,
-  serialized_end=173,
+  serialized_end=210,
 )
 
 
@@ -165,8 +172,8 @@ _CALIBRATEONEPORTREQUEST = _descriptor.Descriptor(
   extension_ranges=[],
   oneofs=[
   ],
-  serialized_start=176,
-  serialized_end=355,
+  serialized_start=213,
+  serialized_end=392,
 )
 
 
@@ -220,6 +227,34 @@ _CALIBRATETWOPORTREQUEST = _descriptor.Descriptor(
       message_type=None, enum_type=None, containing_type=None,
       is_extension=False, extension_scope=None,
       serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='ideal_short', full_name='pb.CalibrateTwoPortRequest.ideal_short', index=6,
+      number=7, type=11, cpp_type=10, label=1,
+      has_default_value=False, default_value=None,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='ideal_open', full_name='pb.CalibrateTwoPortRequest.ideal_open', index=7,
+      number=8, type=11, cpp_type=10, label=1,
+      has_default_value=False, default_value=None,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='ideal_load', full_name='pb.CalibrateTwoPortRequest.ideal_load', index=8,
+      number=9, type=11, cpp_type=10, label=1,
+      has_default_value=False, default_value=None,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='ideal_thru', full_name='pb.CalibrateTwoPortRequest.ideal_thru', index=9,
+      number=10, type=11, cpp_type=10, label=1,
+      has_default_value=False, default_value=None,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
   ],
   extensions=[
   ],
@@ -232,8 +267,8 @@ _CALIBRATETWOPORTREQUEST = _descriptor.Descriptor(
   extension_ranges=[],
   oneofs=[
   ],
-  serialized_start=358,
-  serialized_end=537,
+  serialized_start=395,
+  serialized_end=707,
 )
 
 
@@ -285,8 +320,117 @@ _SPARAMS = _descriptor.Descriptor(
   extension_ranges=[],
   oneofs=[
   ],
-  serialized_start=539,
-  serialized_end=652,
+  serialized_start=709,
+  serialized_end=822,
+)
+
+
+_ERRORTERMS = _descriptor.Descriptor(
+  name='ErrorTerms',
+  full_name='pb.ErrorTerms',
+  filename=None,
+  file=DESCRIPTOR,
+  containing_type=None,
+  create_key=_descriptor._internal_create_key,
+  fields=[
+    _descriptor.FieldDescriptor(
+      name='forward_directivity', full_name='pb.ErrorTerms.forward_directivity', index=0,
+      number=1, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='forward_source_match', full_name='pb.ErrorTerms.forward_source_match', index=1,
+      number=2, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='forward_reflection_tracking', full_name='pb.ErrorTerms.forward_reflection_tracking', index=2,
+      number=3, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='forward_transmission_tracking', full_name='pb.ErrorTerms.forward_transmission_tracking', index=3,
+      number=4, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='forward_load_match', full_name='pb.ErrorTerms.forward_load_match', index=4,
+      number=5, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='forward_isolation', full_name='pb.ErrorTerms.forward_isolation', index=5,
+      number=6, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='reverse_directivity', full_name='pb.ErrorTerms.reverse_directivity', index=6,
+      number=7, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='reverse_source_match', full_name='pb.ErrorTerms.reverse_source_match', index=7,
+      number=8, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='reverse_reflection_tracking', full_name='pb.ErrorTerms.reverse_reflection_tracking', index=8,
+      number=9, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='reverse_transmission_tracking', full_name='pb.ErrorTerms.reverse_transmission_tracking', index=9,
+      number=10, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='reverse_load_match', full_name='pb.ErrorTerms.reverse_load_match', index=10,
+      number=11, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='reverse_isolation', full_name='pb.ErrorTerms.reverse_isolation', index=11,
+      number=12, type=11, cpp_type=10, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+  ],
+  extensions=[
+  ],
+  nested_types=[],
+  enum_types=[
+  ],
+  serialized_options=None,
+  is_extendable=False,
+  syntax='proto3',
+  extension_ranges=[],
+  oneofs=[
+  ],
+  serialized_start=825,
+  serialized_end=1373,
 )
 
 
@@ -324,12 +468,373 @@ _COMPLEX = _descriptor.Descriptor(
   extension_ranges=[],
   oneofs=[
   ],
-  serialized_start=654,
-  serialized_end=691,
+  serialized_start=1375,
+  serialized_end=1412,
+)
+
+
+_MEASUREREQUEST = _descriptor.Descriptor(
+  name='MeasureRequest',
+  full_name='pb.MeasureRequest',
+  filename=None,
+  file=DESCRIPTOR,
+  containing_type=None,
+  create_key=_descriptor._internal_create_key,
+  fields=[
+    _descriptor.FieldDescriptor(
+      name='what', full_name='pb.MeasureRequest.what', index=0,
+      number=1, type=9, cpp_type=9, label=1,
+      has_default_value=False, default_value=b"".decode('utf-8'),
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='calibrated', full_name='pb.MeasureRequest.calibrated', index=1,
+      number=2, type=8, cpp_type=7, label=1,
+      has_default_value=False, default_value=False,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='start', full_name='pb.MeasureRequest.start', index=2,
+      number=3, type=4, cpp_type=4, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='end', full_name='pb.MeasureRequest.end', index=3,
+      number=4, type=4, cpp_type=4, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='size', full_name='pb.MeasureRequest.size', index=4,
+      number=5, type=5, cpp_type=1, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='log', full_name='pb.MeasureRequest.log', index=5,
+      number=6, type=8, cpp_type=7, label=1,
+      has_default_value=False, default_value=False,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='avg', full_name='pb.MeasureRequest.avg', index=6,
+      number=7, type=13, cpp_type=3, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='sweeps', full_name='pb.MeasureRequest.sweeps', index=7,
+      number=8, type=5, cpp_type=1, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='reject', full_name='pb.MeasureRequest.reject', index=8,
+      number=9, type=9, cpp_type=9, label=1,
+      has_default_value=False, default_value=b"".decode('utf-8'),
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='z0', full_name='pb.MeasureRequest.z0', index=9,
+      number=10, type=1, cpp_type=5, label=1,
+      has_default_value=False, default_value=float(0),
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+  ],
+  extensions=[
+  ],
+  nested_types=[],
+  enum_types=[
+  ],
+  serialized_options=None,
+  is_extendable=False,
+  syntax='proto3',
+  extension_ranges=[],
+  oneofs=[
+  ],
+  serialized_start=1415,
+  serialized_end=1577,
+)
+
+
+_MEASURERESPONSE = _descriptor.Descriptor(
+  name='MeasureResponse',
+  full_name='pb.MeasureResponse',
+  filename=None,
+  file=DESCRIPTOR,
+  containing_type=None,
+  create_key=_descriptor._internal_create_key,
+  fields=[
+    _descriptor.FieldDescriptor(
+      name='frequency', full_name='pb.MeasureResponse.frequency', index=0,
+      number=1, type=1, cpp_type=5, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='result', full_name='pb.MeasureResponse.result', index=1,
+      number=2, type=11, cpp_type=10, label=1,
+      has_default_value=False, default_value=None,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+  ],
+  extensions=[
+  ],
+  nested_types=[],
+  enum_types=[
+  ],
+  serialized_options=None,
+  is_extendable=False,
+  syntax='proto3',
+  extension_ranges=[],
+  oneofs=[
+  ],
+  serialized_start=1579,
+  serialized_end=1644,
+)
+
+
+_CALIBRATEREQUEST = _descriptor.Descriptor(
+  name='CalibrateRequest',
+  full_name='pb.CalibrateRequest',
+  filename=None,
+  file=DESCRIPTOR,
+  containing_type=None,
+  create_key=_descriptor._internal_create_key,
+  fields=[
+    _descriptor.FieldDescriptor(
+      name='start', full_name='pb.CalibrateRequest.start', index=0,
+      number=1, type=4, cpp_type=4, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='end', full_name='pb.CalibrateRequest.end', index=1,
+      number=2, type=4, cpp_type=4, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='size', full_name='pb.CalibrateRequest.size', index=2,
+      number=3, type=5, cpp_type=1, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='log', full_name='pb.CalibrateRequest.log', index=3,
+      number=4, type=8, cpp_type=7, label=1,
+      has_default_value=False, default_value=False,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='avg', full_name='pb.CalibrateRequest.avg', index=4,
+      number=5, type=13, cpp_type=3, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='sweeps', full_name='pb.CalibrateRequest.sweeps', index=5,
+      number=6, type=5, cpp_type=1, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='reject', full_name='pb.CalibrateRequest.reject', index=6,
+      number=7, type=9, cpp_type=9, label=1,
+      has_default_value=False, default_value=b"".decode('utf-8'),
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+  ],
+  extensions=[
+  ],
+  nested_types=[],
+  enum_types=[
+  ],
+  serialized_options=None,
+  is_extendable=False,
+  syntax='proto3',
+  extension_ranges=[],
+  oneofs=[
+  ],
+  serialized_start=1646,
+  serialized_end=1764,
+)
+
+
+_CALIBRATERESPONSE = _descriptor.Descriptor(
+  name='CalibrateResponse',
+  full_name='pb.CalibrateResponse',
+  filename=None,
+  file=DESCRIPTOR,
+  containing_type=None,
+  create_key=_descriptor._internal_create_key,
+  fields=[
+    _descriptor.FieldDescriptor(
+      name='frequency', full_name='pb.CalibrateResponse.frequency', index=0,
+      number=1, type=1, cpp_type=5, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='thru', full_name='pb.CalibrateResponse.thru', index=1,
+      number=2, type=11, cpp_type=10, label=1,
+      has_default_value=False, default_value=None,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+  ],
+  extensions=[
+  ],
+  nested_types=[],
+  enum_types=[
+  ],
+  serialized_options=None,
+  is_extendable=False,
+  syntax='proto3',
+  extension_ranges=[],
+  oneofs=[
+  ],
+  serialized_start=1766,
+  serialized_end=1831,
+)
+
+
+_STATUSREQUEST = _descriptor.Descriptor(
+  name='StatusRequest',
+  full_name='pb.StatusRequest',
+  filename=None,
+  file=DESCRIPTOR,
+  containing_type=None,
+  create_key=_descriptor._internal_create_key,
+  fields=[
+  ],
+  extensions=[
+  ],
+  nested_types=[],
+  enum_types=[
+  ],
+  serialized_options=None,
+  is_extendable=False,
+  syntax='proto3',
+  extension_ranges=[],
+  oneofs=[
+  ],
+  serialized_start=1833,
+  serialized_end=1848,
+)
+
+
+_STATUSRESPONSE = _descriptor.Descriptor(
+  name='StatusResponse',
+  full_name='pb.StatusResponse',
+  filename=None,
+  file=DESCRIPTOR,
+  containing_type=None,
+  create_key=_descriptor._internal_create_key,
+  fields=[
+    _descriptor.FieldDescriptor(
+      name='protocol', full_name='pb.StatusResponse.protocol', index=0,
+      number=1, type=5, cpp_type=1, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='min_frequency', full_name='pb.StatusResponse.min_frequency', index=1,
+      number=2, type=4, cpp_type=4, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='max_frequency', full_name='pb.StatusResponse.max_frequency', index=2,
+      number=3, type=4, cpp_type=4, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='calibrated', full_name='pb.StatusResponse.calibrated', index=3,
+      number=4, type=8, cpp_type=7, label=1,
+      has_default_value=False, default_value=False,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='start', full_name='pb.StatusResponse.start', index=4,
+      number=5, type=4, cpp_type=4, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='end', full_name='pb.StatusResponse.end', index=5,
+      number=6, type=4, cpp_type=4, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='size', full_name='pb.StatusResponse.size', index=6,
+      number=7, type=5, cpp_type=1, label=1,
+      has_default_value=False, default_value=0,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='log', full_name='pb.StatusResponse.log', index=7,
+      number=8, type=8, cpp_type=7, label=1,
+      has_default_value=False, default_value=False,
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+    _descriptor.FieldDescriptor(
+      name='positions', full_name='pb.StatusResponse.positions', index=8,
+      number=9, type=9, cpp_type=9, label=3,
+      has_default_value=False, default_value=[],
+      message_type=None, enum_type=None, containing_type=None,
+      is_extension=False, extension_scope=None,
+      serialized_options=None, file=DESCRIPTOR,  create_key=_descriptor._internal_create_key),
+  ],
+  extensions=[
+  ],
+  nested_types=[],
+  enum_types=[
+  ],
+  serialized_options=None,
+  is_extendable=False,
+  syntax='proto3',
+  extension_ranges=[],
+  oneofs=[
+  ],
+  serialized_start=1851,
+  serialized_end=2025,
 )
 
 _CALIBRATEONEPORTRESPONSE.fields_by_name['result'].message_type = _COMPLEX
 _CALIBRATETWOPORTRESPONSE.fields_by_name['result'].message_type = _SPARAMS
+_CALIBRATETWOPORTRESPONSE.fields_by_name['error_terms'].message_type = _ERRORTERMS
 _CALIBRATEONEPORTREQUEST.fields_by_name['short'].message_type = _COMPLEX
 _CALIBRATEONEPORTREQUEST.fields_by_name['open'].message_type = _COMPLEX
 _CALIBRATEONEPORTREQUEST.fields_by_name['load'].message_type = _COMPLEX
@@ -340,16 +845,41 @@ _CALIBRATETWOPORTREQUEST.fields_by_name['open'].message_type = _SPARAMS
 _CALIBRATETWOPORTREQUEST.fields_by_name['load'].message_type = _SPARAMS
 _CALIBRATETWOPORTREQUEST.fields_by_name['thru'].message_type = _SPARAMS
 _CALIBRATETWOPORTREQUEST.fields_by_name['dut'].message_type = _SPARAMS
+_CALIBRATETWOPORTREQUEST.fields_by_name['ideal_short'].message_type = _SPARAMS
+_CALIBRATETWOPORTREQUEST.fields_by_name['ideal_open'].message_type = _SPARAMS
+_CALIBRATETWOPORTREQUEST.fields_by_name['ideal_load'].message_type = _SPARAMS
+_CALIBRATETWOPORTREQUEST.fields_by_name['ideal_thru'].message_type = _SPARAMS
 _SPARAMS.fields_by_name['s11'].message_type = _COMPLEX
 _SPARAMS.fields_by_name['s12'].message_type = _COMPLEX
 _SPARAMS.fields_by_name['s21'].message_type = _COMPLEX
 _SPARAMS.fields_by_name['s22'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['forward_directivity'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['forward_source_match'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['forward_reflection_tracking'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['forward_transmission_tracking'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['forward_load_match'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['forward_isolation'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['reverse_directivity'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['reverse_source_match'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['reverse_reflection_tracking'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['reverse_transmission_tracking'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['reverse_load_match'].message_type = _COMPLEX
+_ERRORTERMS.fields_by_name['reverse_isolation'].message_type = _COMPLEX
+_MEASURERESPONSE.fields_by_name['result'].message_type = _SPARAMS
+_CALIBRATERESPONSE.fields_by_name['thru'].message_type = _SPARAMS
 DESCRIPTOR.message_types_by_name['CalibrateOnePortResponse'] = _CALIBRATEONEPORTRESPONSE
 DESCRIPTOR.message_types_by_name['CalibrateTwoPortResponse'] = _CALIBRATETWOPORTRESPONSE
 DESCRIPTOR.message_types_by_name['CalibrateOnePortRequest'] = _CALIBRATEONEPORTREQUEST
 DESCRIPTOR.message_types_by_name['CalibrateTwoPortRequest'] = _CALIBRATETWOPORTREQUEST
 DESCRIPTOR.message_types_by_name['SParams'] = _SPARAMS
+DESCRIPTOR.message_types_by_name['ErrorTerms'] = _ERRORTERMS
 DESCRIPTOR.message_types_by_name['Complex'] = _COMPLEX
+DESCRIPTOR.message_types_by_name['MeasureRequest'] = _MEASUREREQUEST
+DESCRIPTOR.message_types_by_name['MeasureResponse'] = _MEASURERESPONSE
+DESCRIPTOR.message_types_by_name['CalibrateRequest'] = _CALIBRATEREQUEST
+DESCRIPTOR.message_types_by_name['CalibrateResponse'] = _CALIBRATERESPONSE
+DESCRIPTOR.message_types_by_name['StatusRequest'] = _STATUSREQUEST
+DESCRIPTOR.message_types_by_name['StatusResponse'] = _STATUSRESPONSE
 _sym_db.RegisterFileDescriptor(DESCRIPTOR)
 
 CalibrateOnePortResponse = _reflection.GeneratedProtocolMessageType('CalibrateOnePortResponse', (_message.Message,), {
@@ -387,6 +917,13 @@ SParams = _reflection.GeneratedProtocolMessageType('SParams', (_message.Message,
   })
 _sym_db.RegisterMessage(SParams)
 
+ErrorTerms = _reflection.GeneratedProtocolMessageType('ErrorTerms', (_message.Message,), {
+  'DESCRIPTOR' : _ERRORTERMS,
+  '__module__' : 'calibrate_pb2'
+  # @@protoc_insertion_point(class_scope:pb.ErrorTerms)
+  })
+_sym_db.RegisterMessage(ErrorTerms)
+
 Complex = _reflection.GeneratedProtocolMessageType('Complex', (_message.Message,), {
   'DESCRIPTOR' : _COMPLEX,
   '__module__' : 'calibrate_pb2'
@@ -394,6 +931,48 @@ Complex = _reflection.GeneratedProtocolMessageType('Complex', (_message.Message,
   })
 _sym_db.RegisterMessage(Complex)
 
+MeasureRequest = _reflection.GeneratedProtocolMessageType('MeasureRequest', (_message.Message,), {
+  'DESCRIPTOR' : _MEASUREREQUEST,
+  '__module__' : 'calibrate_pb2'
+  # @@protoc_insertion_point(class_scope:pb.MeasureRequest)
+  })
+_sym_db.RegisterMessage(MeasureRequest)
+
+MeasureResponse = _reflection.GeneratedProtocolMessageType('MeasureResponse', (_message.Message,), {
+  'DESCRIPTOR' : _MEASURERESPONSE,
+  '__module__' : 'calibrate_pb2'
+  # @@protoc_insertion_point(class_scope:pb.MeasureResponse)
+  })
+_sym_db.RegisterMessage(MeasureResponse)
+
+CalibrateRequest = _reflection.GeneratedProtocolMessageType('CalibrateRequest', (_message.Message,), {
+  'DESCRIPTOR' : _CALIBRATEREQUEST,
+  '__module__' : 'calibrate_pb2'
+  # @@protoc_insertion_point(class_scope:pb.CalibrateRequest)
+  })
+_sym_db.RegisterMessage(CalibrateRequest)
+
+CalibrateResponse = _reflection.GeneratedProtocolMessageType('CalibrateResponse', (_message.Message,), {
+  'DESCRIPTOR' : _CALIBRATERESPONSE,
+  '__module__' : 'calibrate_pb2'
+  # @@protoc_insertion_point(class_scope:pb.CalibrateResponse)
+  })
+_sym_db.RegisterMessage(CalibrateResponse)
+
+StatusRequest = _reflection.GeneratedProtocolMessageType('StatusRequest', (_message.Message,), {
+  'DESCRIPTOR' : _STATUSREQUEST,
+  '__module__' : 'calibrate_pb2'
+  # @@protoc_insertion_point(class_scope:pb.StatusRequest)
+  })
+_sym_db.RegisterMessage(StatusRequest)
+
+StatusResponse = _reflection.GeneratedProtocolMessageType('StatusResponse', (_message.Message,), {
+  'DESCRIPTOR' : _STATUSRESPONSE,
+  '__module__' : 'calibrate_pb2'
+  # @@protoc_insertion_point(class_scope:pb.StatusResponse)
+  })
+_sym_db.RegisterMessage(StatusResponse)
+
 
 DESCRIPTOR._options = None
 
@@ -404,8 +983,8 @@ _CALIBRATE = _descriptor.ServiceDescriptor(
   index=0,
   serialized_options=None,
   create_key=_descriptor._internal_create_key,
-  serialized_start=694,
-  serialized_end=867,
+  serialized_start=2028,
+  serialized_end=2201,
   methods=[
   _descriptor.MethodDescriptor(
     name='CalibrateOnePort',
@@ -432,4 +1011,49 @@ _sym_db.RegisterServiceDescriptor(_CALIBRATE)
 
 DESCRIPTOR.services_by_name['Calibrate'] = _CALIBRATE
 
+_VNA = _descriptor.ServiceDescriptor(
+  name='VNA',
+  full_name='pb.VNA',
+  file=DESCRIPTOR,
+  index=1,
+  serialized_options=None,
+  create_key=_descriptor._internal_create_key,
+  serialized_start=2204,
+  serialized_end=2374,
+  methods=[
+  _descriptor.MethodDescriptor(
+    name='Measure',
+    full_name='pb.VNA.Measure',
+    index=0,
+    containing_service=None,
+    input_type=_MEASUREREQUEST,
+    output_type=_MEASURERESPONSE,
+    serialized_options=None,
+    create_key=_descriptor._internal_create_key,
+  ),
+  _descriptor.MethodDescriptor(
+    name='Calibrate',
+    full_name='pb.VNA.Calibrate',
+    index=1,
+    containing_service=None,
+    input_type=_CALIBRATEREQUEST,
+    output_type=_CALIBRATERESPONSE,
+    serialized_options=None,
+    create_key=_descriptor._internal_create_key,
+  ),
+  _descriptor.MethodDescriptor(
+    name='Status',
+    full_name='pb.VNA.Status',
+    index=2,
+    containing_service=None,
+    input_type=_STATUSREQUEST,
+    output_type=_STATUSRESPONSE,
+    serialized_options=None,
+    create_key=_descriptor._internal_create_key,
+  ),
+])
+_sym_db.RegisterServiceDescriptor(_VNA)
+
+DESCRIPTOR.services_by_name['VNA'] = _VNA
+
 # @@protoc_insertion_point(module_scope)
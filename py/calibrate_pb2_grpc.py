@@ -97,3 +97,130 @@ class Calibrate(object):
             calibrate__pb2.CalibrateTwoPortResponse.FromString,
             options, channel_credentials,
             insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+
+class VNAStub(object):
+    """Missing associated documentation comment in .proto file."""
+
+    def __init__(self, channel):
+        """Constructor.
+
+        Args:
+            channel: A grpc.Channel.
+        """
+        self.Measure = channel.unary_unary(
+                '/pb.VNA/Measure',
+                request_serializer=calibrate__pb2.MeasureRequest.SerializeToString,
+                response_deserializer=calibrate__pb2.MeasureResponse.FromString,
+                )
+        self.Calibrate = channel.unary_unary(
+                '/pb.VNA/Calibrate',
+                request_serializer=calibrate__pb2.CalibrateRequest.SerializeToString,
+                response_deserializer=calibrate__pb2.CalibrateResponse.FromString,
+                )
+        self.Status = channel.unary_unary(
+                '/pb.VNA/Status',
+                request_serializer=calibrate__pb2.StatusRequest.SerializeToString,
+                response_deserializer=calibrate__pb2.StatusResponse.FromString,
+                )
+
+
+class VNAServicer(object):
+    """Missing associated documentation comment in .proto file."""
+
+    def Measure(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def Calibrate(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+    def Status(self, request, context):
+        """Missing associated documentation comment in .proto file."""
+        context.set_code(grpc.StatusCode.UNIMPLEMENTED)
+        context.set_details('Method not implemented!')
+        raise NotImplementedError('Method not implemented!')
+
+
+def add_VNAServicer_to_server(servicer, server):
+    rpc_method_handlers = {
+            'Measure': grpc.unary_unary_rpc_method_handler(
+                    servicer.Measure,
+                    request_deserializer=calibrate__pb2.MeasureRequest.FromString,
+                    response_serializer=calibrate__pb2.MeasureResponse.SerializeToString,
+            ),
+            'Calibrate': grpc.unary_unary_rpc_method_handler(
+                    servicer.Calibrate,
+                    request_deserializer=calibrate__pb2.CalibrateRequest.FromString,
+                    response_serializer=calibrate__pb2.CalibrateResponse.SerializeToString,
+            ),
+            'Status': grpc.unary_unary_rpc_method_handler(
+                    servicer.Status,
+                    request_deserializer=calibrate__pb2.StatusRequest.FromString,
+                    response_serializer=calibrate__pb2.StatusResponse.SerializeToString,
+            ),
+    }
+    generic_handler = grpc.method_handlers_generic_handler(
+            'pb.VNA', rpc_method_handlers)
+    server.add_generic_rpc_handlers((generic_handler,))
+
+
+ # This class is part of an EXPERIMENTAL API.
+class VNA(object):
+    """Missing associated documentation comment in .proto file."""
+
+    @staticmethod
+    def Measure(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_unary(request, target, '/pb.VNA/Measure',
+            calibrate__pb2.MeasureRequest.SerializeToString,
+            calibrate__pb2.MeasureResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def Calibrate(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_unary(request, target, '/pb.VNA/Calibrate',
+            calibrate__pb2.CalibrateRequest.SerializeToString,
+            calibrate__pb2.CalibrateResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
+
+    @staticmethod
+    def Status(request,
+            target,
+            options=(),
+            channel_credentials=None,
+            call_credentials=None,
+            insecure=False,
+            compression=None,
+            wait_for_ready=None,
+            timeout=None,
+            metadata=None):
+        return grpc.experimental.unary_unary(request, target, '/pb.VNA/Status',
+            calibrate__pb2.StatusRequest.SerializeToString,
+            calibrate__pb2.StatusResponse.FromString,
+            options, channel_credentials,
+            insecure, call_credentials, compression, wait_for_ready, timeout, metadata)
@@ -8,7 +8,7 @@ from skrf.calibration import OnePort, TwelveTerm
 from skrf.media import DefinedGammaZ0
 import skrf as rf
 
-from calibrate_pb2 import CalibrateOnePortResponse,CalibrateTwoPortResponse, SParams, Complex
+from calibrate_pb2 import CalibrateOnePortResponse,CalibrateTwoPortResponse, SParams, Complex, ErrorTerms
 from calibrate_pb2_grpc import CalibrateServicer, add_CalibrateServicer_to_server
 
 # For tutorial on grpc with python, see
@@ -47,6 +47,17 @@ def convert_rf_to_protoc(rfobj):
     s21 = convert_complex_np_to_protoc(rfobj.s[:,1,0])
     s22 = convert_complex_np_to_protoc(rfobj.s[:,1,1])
     return SParams(s11=s11,s12=s12,s21=s21,s22=s22)
+
+def convert_coefs_to_protoc(coefs):
+    # skrf names the twelve terms e.g. 'forward directivity', so the
+    # protocol buffer fields are the same names with underscores
+    terms = {}
+    for direction in ['forward', 'reverse']:
+        for term in ['directivity', 'source match', 'reflection tracking',
+                     'transmission tracking', 'load match', 'isolation']:
+            key = direction + ' ' + term
+            terms[key.replace(' ', '_')] = convert_complex_np_to_protoc(coefs[key])
+    return ErrorTerms(**terms)
     
 class CalibrateServer(CalibrateServicer):
     def CalibrateOnePort(self, request, context):
@@ -107,6 +118,21 @@ class CalibrateServer(CalibrateServicer):
                 standard.load(1e-99, nports=2),
                 standard.thru(),
                 ]
+
+        # replace with the actual response of the standards, if a cal kit was supplied
+        definitions = [
+                ("ideal_short", request.ideal_short),
+                ("ideal_open", request.ideal_open),
+                ("ideal_load", request.ideal_load),
+                ("ideal_thru", request.ideal_thru),
+                ]
+
+        for i, (name, definition) in enumerate(definitions):
+            if not request.HasField(name):
+                continue
+            if not all(len(x) == rl for x in [definition.s11, definition.s12, definition.s21, definition.s22]):
+                context.abort(grpc.StatusCode.INVALID_ARGUMENT, name + " array lengths do not match frequency")
+            ideal[i] = rf.Network(frequency=f, s=convert_sparams_protoc_to_np(f, definition), name=name)
            
         dut = rf.Network(frequency=f, s=np_dut, name="dut")
 
@@ -115,7 +141,11 @@ class CalibrateServer(CalibrateServicer):
         
         result = convert_rf_to_protoc(cal.apply_cal(dut))
 
-        resp=CalibrateTwoPortResponse(frequency=request.frequency, result=result) 
+        # return the error terms so the client can correct further DUT
+        # measurements itself, without sending the standards again
+        error_terms = convert_coefs_to_protoc(cal.coefs)
+
+        resp=CalibrateTwoPortResponse(frequency=request.frequency, result=result, error_terms=error_terms) 
         
         return resp
         